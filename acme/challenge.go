@@ -0,0 +1,92 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"time"
+)
+
+// ALPNProto TLS-ALPN-01 验证连接协商的ALPN协议名
+const ALPNProto = "acme-tls/1"
+
+// HTTP01Path HTTP-01 验证请求的路径前缀，后接令牌
+const HTTP01Path = "/.well-known/acme-challenge/"
+
+var errNoKey = errors.New("没有找到PEM编码的EC私钥")
+
+// idPeACMEIdentifier TLS-ALPN-01 验证证书中的acmeIdentifier扩展（RFC 8737）
+var idPeACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// TLSALPN01Certificate 生成TLS-ALPN-01验证使用的自签名证书：SAN为待验证域名，
+// 带有包含keyAuth摘要的关键扩展acmeIdentifier
+func TLSALPN01Certificate(domain, keyAuth string) (*tls.Certificate, error) {
+	key, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(keyAuth))
+	extValue, err := asn1.Marshal(digest[:])
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "ACME challenge"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		DNSNames:     []string{domain},
+		ExtraExtensions: []pkix.Extension{
+			{Id: idPeACMEIdentifier, Critical: true, Value: extValue},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// MarshalKey 将ECDSA私钥编码为PEM
+func MarshalKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pemEncode("EC PRIVATE KEY", der), nil
+}
+
+// ParseKey 解析PEM编码的ECDSA私钥
+func ParseKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block := pemDecode(data, "EC PRIVATE KEY")
+	if block == nil {
+		return nil, errNoKey
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+func pemEncode(blockType string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
+
+// pemDecode 返回data中第一个指定类型的PEM块
+func pemDecode(data []byte, blockType string) *pem.Block {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil || block.Type == blockType {
+			return block
+		}
+	}
+}
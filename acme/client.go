@@ -0,0 +1,433 @@
+// Package acme 实现签发证书所需的ACME（RFC 8555）客户端子集：账户注册、下单、
+// HTTP-01/TLS-ALPN-01 验证和签发，只依赖标准库
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LetsEncryptURL Let's Encrypt 生产环境的目录地址
+const LetsEncryptURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+// 验证方式
+const (
+	ChallengeHTTP01    = "http-01"
+	ChallengeTLSALPN01 = "tls-alpn-01"
+)
+
+// Solver 完成验证：Present 在通知CA验证前发布keyAuth（HTTP-01为令牌响应，TLS-ALPN-01为验证证书），
+// CleanUp 在验证结束后撤下
+type Solver interface {
+	Present(domain, token, keyAuth string) error
+	CleanUp(domain, token string)
+}
+
+// Client ACME客户端。Key为账户密钥（ECDSA P-256），首次使用时按Email注册或找回账户
+type Client struct {
+	DirectoryURL string
+	Key          *ecdsa.PrivateKey
+	Email        string
+	HTTPClient   *http.Client
+
+	mu     sync.Mutex
+	dir    *directory
+	kid    string // 账户URL，注册后作为JWS的kid
+	nonces []string
+}
+
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+// Error ACME服务端返回的问题文档
+type Error struct {
+	Status int
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ACME错误 %d %s: %s", e.Status, e.Type, e.Detail)
+}
+
+type order struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+	Error          *Error   `json:"error"`
+}
+
+type authorization struct {
+	Status     string `json:"status"`
+	Identifier struct {
+		Value string `json:"value"`
+	} `json:"identifier"`
+	Challenges []challenge `json:"challenges"`
+}
+
+type challenge struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+	Error  *Error `json:"error"`
+}
+
+// GenerateKey 生成ECDSA P-256密钥，用于账户或证书
+func GenerateKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// ObtainCertificate 为domains签发一张证书，返回证书链（PEM，叶子证书在前）。
+// certKey为证书私钥，challengeType指定验证方式，solver负责发布验证内容
+func (c *Client) ObtainCertificate(ctx context.Context, domains []string, certKey crypto.Signer, challengeType string, solver Solver) ([]byte, error) {
+	if err := c.register(ctx); err != nil {
+		return nil, err
+	}
+
+	identifiers := make([]map[string]string, 0, len(domains))
+	for _, domain := range domains {
+		identifiers = append(identifiers, map[string]string{"type": "dns", "value": domain})
+	}
+	var o order
+	resp, err := c.post(ctx, c.dir.NewOrder, map[string]interface{}{"identifiers": identifiers}, &o)
+	if err != nil {
+		return nil, fmt.Errorf("创建订单失败: %w", err)
+	}
+	orderURL := resp.Header.Get("Location")
+
+	for _, authzURL := range o.Authorizations {
+		if err := c.authorize(ctx, authzURL, challengeType, solver); err != nil {
+			return nil, err
+		}
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, certKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.post(ctx, o.Finalize, map[string]string{"csr": b64(csr)}, &o); err != nil {
+		return nil, fmt.Errorf("提交CSR失败: %w", err)
+	}
+	for o.Status != "valid" {
+		if o.Status == "invalid" {
+			return nil, fmt.Errorf("订单失败: %v", o.Error)
+		}
+		if err := sleep(ctx, time.Second); err != nil {
+			return nil, err
+		}
+		if _, err := c.post(ctx, orderURL, nil, &o); err != nil {
+			return nil, fmt.Errorf("查询订单失败: %w", err)
+		}
+	}
+
+	resp, err = c.post(ctx, o.Certificate, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("下载证书失败: %w", err)
+	}
+	defer resp.Body.Close()
+	chain, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(chain); block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("CA返回的证书不是PEM格式")
+	}
+	return chain, nil
+}
+
+// authorize 完成一个域名的验证，已验证过的授权直接返回
+func (c *Client) authorize(ctx context.Context, authzURL, challengeType string, solver Solver) error {
+	var authz authorization
+	if _, err := c.post(ctx, authzURL, nil, &authz); err != nil {
+		return fmt.Errorf("查询授权失败: %w", err)
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+	domain := authz.Identifier.Value
+	var chal *challenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == challengeType {
+			chal = &authz.Challenges[i]
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("CA不支持对 %s 使用 %s 验证", domain, challengeType)
+	}
+
+	keyAuth := chal.Token + "." + c.thumbprint()
+	if err := solver.Present(domain, chal.Token, keyAuth); err != nil {
+		return err
+	}
+	defer solver.CleanUp(domain, chal.Token)
+
+	// 提交空对象通知CA开始验证，然后轮询授权状态
+	if _, err := c.post(ctx, chal.URL, struct{}{}, &challenge{}); err != nil {
+		return fmt.Errorf("提交 %s 验证失败: %w", domain, err)
+	}
+	for {
+		if err := sleep(ctx, time.Second); err != nil {
+			return err
+		}
+		if _, err := c.post(ctx, authzURL, nil, &authz); err != nil {
+			return fmt.Errorf("查询授权失败: %w", err)
+		}
+		switch authz.Status {
+		case "valid":
+			return nil
+		case "pending", "processing":
+			continue
+		}
+		for _, ch := range authz.Challenges {
+			if ch.Type == challengeType && ch.Error != nil {
+				return fmt.Errorf("%s 的 %s 验证失败: %s", domain, challengeType, ch.Error.Detail)
+			}
+		}
+		return fmt.Errorf("%s 的授权状态为 %s", domain, authz.Status)
+	}
+}
+
+// register 读取目录并注册账户（已存在时返回已有账户），只执行一次
+func (c *Client) register(ctx context.Context) error {
+	c.mu.Lock()
+	done := c.kid != ""
+	c.mu.Unlock()
+	if done {
+		return nil
+	}
+
+	dir := &directory{}
+	if err := c.getJSON(ctx, c.DirectoryURL, dir); err != nil {
+		return fmt.Errorf("读取ACME目录失败: %w", err)
+	}
+	c.mu.Lock()
+	c.dir = dir
+	c.mu.Unlock()
+
+	account := map[string]interface{}{"termsOfServiceAgreed": true}
+	if c.Email != "" {
+		account["contact"] = []string{"mailto:" + c.Email}
+	}
+	resp, err := c.post(ctx, dir.NewAccount, account, nil)
+	if err != nil {
+		return fmt.Errorf("注册ACME账户失败: %w", err)
+	}
+	resp.Body.Close()
+	kid := resp.Header.Get("Location")
+	if kid == "" {
+		return errors.New("注册ACME账户失败: 响应中没有账户地址")
+	}
+	c.mu.Lock()
+	c.kid = kid
+	c.mu.Unlock()
+	return nil
+}
+
+// post 发送JWS签名的POST请求，payload为nil时为POST-as-GET。
+// result非nil时解析响应JSON并关闭响应体，否则由调用方关闭。nonce失效时重试一次
+func (c *Client) post(ctx context.Context, url string, payload interface{}, result interface{}) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		body, err := c.sign(ctx, url, payload)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/jose+json")
+		resp, err := c.httpClient().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		c.saveNonce(resp)
+		if resp.StatusCode >= 400 {
+			problem := readProblem(resp)
+			if problem.Type == "urn:ietf:params:acme:error:badNonce" && attempt == 0 {
+				continue
+			}
+			return nil, problem
+		}
+		if result != nil {
+			defer resp.Body.Close()
+			if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+				return nil, fmt.Errorf("解析ACME响应失败: %v", err)
+			}
+		}
+		return resp, nil
+	}
+}
+
+func (c *Client) getJSON(ctx context.Context, url string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return readProblem(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// sign 生成JWS（flattened JSON序列化）：注册账户前用jwk，之后用kid
+func (c *Client) sign(ctx context.Context, url string, payload interface{}) ([]byte, error) {
+	nonce, err := c.nonce(ctx)
+	if err != nil {
+		return nil, err
+	}
+	protected := map[string]interface{}{"alg": "ES256", "nonce": nonce, "url": url}
+	c.mu.Lock()
+	if c.kid != "" {
+		protected["kid"] = c.kid
+	} else {
+		protected["jwk"] = c.jwk()
+	}
+	c.mu.Unlock()
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	var encodedPayload string
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		encodedPayload = b64(data)
+	}
+
+	signingInput := b64(header) + "." + encodedPayload
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, c.Key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return json.Marshal(map[string]string{
+		"protected": b64(header),
+		"payload":   encodedPayload,
+		"signature": b64(signature),
+	})
+}
+
+// nonce 取一个未使用的nonce，没有时向newNonce请求
+func (c *Client) nonce(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	if n := len(c.nonces); n > 0 {
+		nonce := c.nonces[n-1]
+		c.nonces = c.nonces[:n-1]
+		c.mu.Unlock()
+		return nonce, nil
+	}
+	newNonceURL := c.dir.NewNonce
+	c.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", newNonceURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", errors.New("ACME服务端没有返回nonce")
+	}
+	return nonce, nil
+}
+
+func (c *Client) saveNonce(resp *http.Response) {
+	if nonce := resp.Header.Get("Replay-Nonce"); nonce != "" {
+		c.mu.Lock()
+		c.nonces = append(c.nonces, nonce)
+		c.mu.Unlock()
+	}
+}
+
+// jwk 账户公钥的JWK，字段按字典序排列，与thumbprint的计算方式一致
+func (c *Client) jwk() map[string]string {
+	size := (c.Key.Curve.Params().BitSize + 7) / 8
+	return map[string]string{
+		"crv": c.Key.Curve.Params().Name,
+		"kty": "EC",
+		"x":   b64(padded(c.Key.X, size)),
+		"y":   b64(padded(c.Key.Y, size)),
+	}
+}
+
+// thumbprint 账户公钥的JWK指纹（RFC 7638），用于计算keyAuthorization
+func (c *Client) thumbprint() string {
+	jwk := c.jwk()
+	data := fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, jwk["crv"], jwk["x"], jwk["y"])
+	sum := sha256.Sum256([]byte(data))
+	return b64(sum[:])
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func readProblem(resp *http.Response) *Error {
+	defer resp.Body.Close()
+	problem := &Error{Status: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(data, problem) != nil || problem.Detail == "" {
+		problem.Detail = strings.TrimSpace(string(data))
+	}
+	return problem
+}
+
+func padded(n *big.Int, size int) []byte {
+	return n.FillBytes(make([]byte, size))
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/secrets"
+)
+
+// 连接令牌的 token_use 声明，用于和普通JWT区分
+const connectionTokenUse = "connection"
+
+// 连接令牌默认有效期
+const defaultConnectionTokenTTL = time.Minute
+
+// ConnectionTokenConfig 短期连接令牌：客户端先通过 POST /api/token 换取令牌，
+// 再在WebSocket握手时出示。令牌只能使用一次，并绑定到申请时的 client_id。
+// 已使用的令牌只记录在本进程的内存中（同一进程内共享认证方式的节点共用一份记录），不在集群范围内去重
+type ConnectionTokenConfig struct {
+	Enabled bool              `json:"enabled" yaml:"enabled"`
+	Secret  string            `json:"secret" yaml:"secret"` // 签名密钥，负载均衡器和服务端需相同，可以是 env:/file:/vault: 引用
+	TTL     protocol.Duration `json:"ttl" yaml:"ttl"`       // 有效期，默认1m
+}
+
+// Validate 校验连接令牌配置
+func (c ConnectionTokenConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Secret == "" {
+		return fmt.Errorf("auth: 启用 connection_tokens 时必须配置 secret")
+	}
+	if c.TTL < 0 {
+		return fmt.Errorf("auth: connection_tokens.ttl 不能为负数")
+	}
+	if err := secrets.ValidateRef(c.Secret); err != nil {
+		return fmt.Errorf("auth: connection_tokens.secret: %v", err)
+	}
+	return nil
+}
+
+// usedTokens 已使用的连接令牌ID，保留到令牌过期。只在本进程内有效，不同进程的节点各自记录
+type usedTokens struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time // jti -> 过期时间
+	lastPurge time.Time
+}
+
+// consume 标记令牌已使用，令牌此前已使用过时返回false
+func (u *usedTokens) consume(jti string, expiresAt time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := time.Now()
+	if now.Sub(u.lastPurge) > time.Second {
+		for id, exp := range u.seen {
+			if now.After(exp) {
+				delete(u.seen, id)
+			}
+		}
+		u.lastPurge = now
+	}
+	if _, used := u.seen[jti]; used {
+		return false
+	}
+	u.seen[jti] = expiresAt
+	return true
+}
+
+// setupConnectionTokens 读取连接令牌的签名密钥，密钥变化时自动轮换
+func (v *Verifier) setupConnectionTokens(cfg ConnectionTokenConfig) error {
+	secret, err := secrets.Resolve(cfg.Secret)
+	if err != nil {
+		return fmt.Errorf("auth: 读取 connection_tokens.secret 失败: %v", err)
+	}
+	if secret.Value() == "" {
+		return fmt.Errorf("auth: connection_tokens.secret 为空")
+	}
+	v.connSecrets = [][]byte{[]byte(secret.Value())}
+	v.connTTL = time.Duration(cfg.TTL)
+	if v.connTTL <= 0 {
+		v.connTTL = defaultConnectionTokenTTL
+	}
+	v.usedTokens = &usedTokens{seen: make(map[string]time.Time)}
+	secret.OnChange(func(value string) {
+		if value == "" {
+			log.Printf("auth: 新的 connection_tokens.secret 为空，继续使用原密钥")
+			return
+		}
+		v.keysMu.Lock()
+		v.connSecrets = [][]byte{[]byte(value), v.connSecrets[0]}
+		v.keysMu.Unlock()
+		log.Printf("auth: 连接令牌密钥已轮换，旧密钥签发的令牌在有效期内仍可使用")
+	})
+	return nil
+}
+
+// IssuesConnectionTokens 是否启用了连接令牌
+func (v *Verifier) IssuesConnectionTokens() bool {
+	return v != nil && v.usedTokens != nil
+}
+
+// IssueConnectionToken 签发绑定到clientID的短期连接令牌，name和namespace非空时写入令牌声明
+func (v *Verifier) IssueConnectionToken(clientID, name, namespace string) (string, time.Time, error) {
+	if !v.IssuesConnectionTokens() {
+		return "", time.Time{}, errors.New("未启用连接令牌")
+	}
+	if clientID == "" {
+		return "", time.Time{}, errors.New("client_id为必填字段")
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(v.connTTL)
+	claims := map[string]interface{}{
+		"sub":       clientID,
+		"jti":       hex.EncodeToString(id),
+		"iat":       now.Unix(),
+		"exp":       expiresAt.Unix(),
+		"token_use": connectionTokenUse,
+	}
+	if name != "" {
+		claims["name"] = name
+	}
+	if namespace != "" {
+		claims["namespace"] = namespace
+	}
+	// 与普通令牌使用相同的 iss/aud 校验
+	if v.issuer != "" {
+		claims["iss"] = v.issuer
+	}
+	if v.audience != "" {
+		claims["aud"] = v.audience
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	v.keysMu.RLock()
+	mac := hmac.New(sha256.New, v.connSecrets[0])
+	v.keysMu.RUnlock()
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), expiresAt, nil
+}
+
+// verifyConnectionToken 校验连接令牌的签名、有效期，并将其标记为已使用
+func (v *Verifier) verifyConnectionToken(alg, signed string, signature []byte, raw map[string]interface{}) (*Claims, error) {
+	if !v.IssuesConnectionTokens() {
+		return nil, errors.New("未启用连接令牌")
+	}
+	if alg != "HS256" {
+		return nil, fmt.Errorf("不支持的签名算法: %q", alg)
+	}
+	v.keysMu.RLock()
+	connSecrets := v.connSecrets
+	v.keysMu.RUnlock()
+	valid := false
+	for _, secret := range connSecrets {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		if hmac.Equal(mac.Sum(nil), signature) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, errors.New("令牌签名无效")
+	}
+
+	claims, err := v.validateClaims(raw)
+	if err != nil {
+		return nil, err
+	}
+	jti, _ := raw["jti"].(string)
+	if jti == "" || claims.Subject == "" || claims.ExpiresAt.IsZero() {
+		return nil, errors.New("连接令牌缺少 jti/sub/exp 声明")
+	}
+	if !v.usedTokens.consume(jti, claims.ExpiresAt.Add(v.leeway)) {
+		return nil, errors.New("连接令牌已使用")
+	}
+	claims.Connection = true
+	return claims, nil
+}
+
+// HandleTokenRequest 签发连接令牌: POST /api/token {"client_id": "...", "client_name": "...", "namespace": "..."}
+// 该接口本身不做认证，应部署在完成用户认证的上游（API网关、业务后端）之后，不直接暴露给客户端
+func (v *Verifier) HandleTokenRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "仅支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ClientID   string `json:"client_id"`
+		ClientName string `json:"client_name"`
+		Namespace  string `json:"namespace"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "请求格式错误", http.StatusBadRequest)
+		return
+	}
+	if req.ClientID == "" {
+		http.Error(w, "client_id为必填字段", http.StatusBadRequest)
+		return
+	}
+	token, expiresAt, err := v.IssueConnectionToken(req.ClientID, req.ClientName, req.Namespace)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("为客户端 %s 签发连接令牌，%s 前有效 (%s)", req.ClientID, expiresAt.Format(time.RFC3339), r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":      token,
+		"client_id":  req.ClientID,
+		"expires_at": expiresAt.Unix(),
+		"expires_in": int(v.connTTL.Seconds()),
+	})
+}
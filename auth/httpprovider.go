@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"websocket-loadbalance/protocol"
+)
+
+// 外部认证服务请求的默认超时
+const defaultHTTPProviderTimeout = 3 * time.Second
+
+// HTTPProviderConfig 外部HTTP认证服务：握手（以及可选的注册）时向 url POST 一个JSON请求，
+// 2xx响应表示通过，其他状态码表示拒绝。组织可以借此接入自己的SSO而不需要修改负载均衡器或节点
+type HTTPProviderConfig struct {
+	URL               string            `json:"url" yaml:"url"`
+	Timeout           protocol.Duration `json:"timeout" yaml:"timeout"`                       // 默认3s，超时视为拒绝
+	ForwardHeaders    []string          `json:"forward_headers" yaml:"forward_headers"`       // 转发给认证服务的握手请求头，默认 Authorization 和 Cookie
+	CheckRegistration bool              `json:"check_registration" yaml:"check_registration"` // 节点收到注册消息时再请求一次认证服务
+}
+
+// Validate 校验外部认证服务配置
+func (c HTTPProviderConfig) Validate() error {
+	if c.URL != "" {
+		u, err := url.Parse(c.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("auth: http.url 应为 http(s):// 形式的地址: %q", c.URL)
+		}
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("auth: http.timeout 不能为负数")
+	}
+	return nil
+}
+
+// httpAuthRequest 发给外部认证服务的请求体，stage 为 upgrade 或 registration
+type httpAuthRequest struct {
+	Stage        string                 `json:"stage"`
+	Token        string                 `json:"token,omitempty"`        // 按JWT相同的位置取出的令牌
+	Headers      map[string]string      `json:"headers,omitempty"`      // forward_headers 中的握手请求头
+	RemoteAddr   string                 `json:"remote_addr,omitempty"`  // 握手请求的来源地址
+	Path         string                 `json:"path,omitempty"`         // 握手请求的路径和查询参数
+	Registration map[string]interface{} `json:"registration,omitempty"` // 注册消息
+}
+
+// httpAuthResponse 外部认证服务的响应体，subject 为空时注册阶段沿用握手时的身份
+type httpAuthResponse struct {
+	Subject   string                 `json:"subject"`
+	Name      string                 `json:"name"`
+	Namespace string                 `json:"namespace"`
+	Claims    map[string]interface{} `json:"claims"` // 附加声明，放入 Claims.Raw
+}
+
+// httpProvider 将认证委托给外部HTTP服务
+type httpProvider struct {
+	cfg        HTTPProviderConfig
+	queryParam string
+	client     *http.Client
+}
+
+func newHTTPProvider(cfg Config) (*httpProvider, error) {
+	p := &httpProvider{cfg: cfg.HTTP, queryParam: cfg.QueryParam}
+	if p.queryParam == "" {
+		p.queryParam = "token"
+	}
+	if len(p.cfg.ForwardHeaders) == 0 {
+		p.cfg.ForwardHeaders = []string{"Authorization", "Cookie"}
+	}
+	timeout := time.Duration(p.cfg.Timeout)
+	if timeout <= 0 {
+		timeout = defaultHTTPProviderTimeout
+	}
+	p.client = &http.Client{Timeout: timeout}
+	return p, nil
+}
+
+// AuthenticateUpgrade 将握手请求中的令牌和请求头交给认证服务判断
+func (p *httpProvider) AuthenticateUpgrade(r *http.Request) (*Claims, http.Header, error) {
+	token, viaSubprotocol := tokenFromRequest(r, p.queryParam)
+	req := httpAuthRequest{
+		Stage:      "upgrade",
+		Token:      token,
+		Headers:    make(map[string]string),
+		RemoteAddr: r.RemoteAddr,
+		Path:       r.URL.RequestURI(),
+	}
+	for _, name := range p.cfg.ForwardHeaders {
+		if value := r.Header.Get(name); value != "" {
+			req.Headers[http.CanonicalHeaderKey(name)] = value
+		}
+	}
+	claims, err := p.call(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if claims == nil {
+		return nil, nil, errors.New("认证服务未返回 subject")
+	}
+	return claims, subprotocolHeader(viaSubprotocol), nil
+}
+
+// AuthenticateRegistration 启用 check_registration 时将注册消息交给认证服务判断
+func (p *httpProvider) AuthenticateRegistration(msg map[string]interface{}) (*Claims, error) {
+	if !p.cfg.CheckRegistration {
+		return nil, nil
+	}
+	return p.call(httpAuthRequest{Stage: "registration", Registration: msg})
+}
+
+// call 请求认证服务，2xx以外的状态码视为拒绝，响应体作为拒绝原因
+func (p *httpProvider) call(req httpAuthRequest) (*Claims, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Post(p.cfg.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("请求认证服务失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if reason := strings.TrimSpace(string(message)); reason != "" {
+			return nil, fmt.Errorf("认证服务拒绝: %s", reason)
+		}
+		return nil, fmt.Errorf("认证服务拒绝: %s", resp.Status)
+	}
+	var result httpAuthResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("解析认证服务响应失败: %v", err)
+	}
+	if result.Subject == "" {
+		return nil, nil
+	}
+	raw := result.Claims
+	if raw == nil {
+		raw = make(map[string]interface{})
+	}
+	raw["sub"] = result.Subject
+	return &Claims{
+		Subject:   result.Subject,
+		Name:      result.Name,
+		Namespace: result.Namespace,
+		Raw:       raw,
+	}, nil
+}
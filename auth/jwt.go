@@ -0,0 +1,335 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/secrets"
+)
+
+// 通过 Sec-WebSocket-Protocol 传递令牌时使用的子协议名：
+// 客户端发送 "access_token, <jwt>"，服务端回应 "access_token"
+const SubprotocolName = "access_token"
+
+// Config 认证配置
+type Config struct {
+	Enabled          bool              `json:"enabled" yaml:"enabled"`
+	Provider         string            `json:"provider" yaml:"provider"`                       // 认证方式: jwt（默认）、static、http、none，负载均衡器和节点可用 auth_provider 分别覆盖
+	HMACSecret       string            `json:"hmac_secret" yaml:"hmac_secret"`                 // HS256/HS384/HS512 共享密钥，可以是 env:/file:/vault: 引用
+	RSAPublicKeyFile string            `json:"rsa_public_key_file" yaml:"rsa_public_key_file"` // RS256/RS384/RS512 公钥(PEM)
+	Issuer           string            `json:"issuer" yaml:"issuer"`                           // 非空时校验 iss
+	Audience         string            `json:"audience" yaml:"audience"`                       // 非空时校验 aud
+	QueryParam       string            `json:"query_param" yaml:"query_param"`                 // 携带令牌的查询参数，默认 token
+	Leeway           protocol.Duration `json:"leeway" yaml:"leeway"`                           // exp/nbf 允许的时钟偏差
+	// 通过 POST /api/token 签发的短期、一次性连接令牌
+	ConnectionTokens ConnectionTokenConfig `json:"connection_tokens" yaml:"connection_tokens"`
+	// static 认证方式使用的静态令牌
+	StaticTokens []StaticToken `json:"static_tokens" yaml:"static_tokens"`
+	// http 认证方式调用的外部认证服务
+	HTTP HTTPProviderConfig `json:"http" yaml:"http"`
+}
+
+// Validate 校验认证配置
+// 未启用时只检查格式，监听端口通过 auth_provider 选用的认证方式由 ValidateProvider 检查
+func (c Config) Validate() error {
+	if c.Enabled {
+		if err := c.ValidateProvider(""); err != nil {
+			return err
+		}
+	}
+	if c.Leeway < 0 {
+		return fmt.Errorf("auth: leeway 不能为负数")
+	}
+	if err := secrets.ValidateRef(c.HMACSecret); err != nil {
+		return fmt.Errorf("auth: hmac_secret: %v", err)
+	}
+	for i, token := range c.StaticTokens {
+		if err := token.Validate(); err != nil {
+			return fmt.Errorf("auth: static_tokens[%d]: %v", i, err)
+		}
+	}
+	if err := c.HTTP.Validate(); err != nil {
+		return err
+	}
+	return c.ConnectionTokens.Validate()
+}
+
+// Claims 令牌中解析出的声明
+type Claims struct {
+	Subject    string
+	Name       string
+	Issuer     string
+	Namespace  string                 // namespace 声明，客户端所属的命名空间
+	Connection bool                   // 是否为一次性连接令牌，此时客户端ID必须与 sub 一致
+	ExpiresAt  time.Time              // 无exp声明时为零值
+	Raw        map[string]interface{} // 全部原始声明
+}
+
+// Verifier 校验JWT令牌。密钥轮换后，轮换前的密钥继续有效到下一次轮换，
+// 以便已签发的令牌在有效期内仍能通过校验
+type Verifier struct {
+	keysMu      sync.RWMutex
+	hmacSecrets [][]byte         // 当前密钥在前，轮换前的密钥在后
+	rsaKeys     []*rsa.PublicKey // 同上
+	connSecrets [][]byte         // 连接令牌的签名密钥，同上
+	connTTL     time.Duration
+	usedTokens  *usedTokens // 已使用的连接令牌，nil表示未启用连接令牌
+	issuer      string
+	audience    string
+	queryParam  string
+	leeway      time.Duration
+}
+
+// New 根据配置创建校验器，未启用认证时返回nil
+func New(cfg Config) (*Verifier, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	v := &Verifier{
+		issuer:     cfg.Issuer,
+		audience:   cfg.Audience,
+		queryParam: cfg.QueryParam,
+		leeway:     time.Duration(cfg.Leeway),
+	}
+	if v.queryParam == "" {
+		v.queryParam = "token"
+	}
+	// 密钥通过secrets读取，引用的值或公钥文件变化时自动轮换
+	if cfg.HMACSecret != "" {
+		secret, err := secrets.Resolve(cfg.HMACSecret)
+		if err != nil {
+			return nil, fmt.Errorf("auth: 读取 hmac_secret 失败: %v", err)
+		}
+		if secret.Value() == "" {
+			return nil, fmt.Errorf("auth: hmac_secret 为空")
+		}
+		v.hmacSecrets = [][]byte{[]byte(secret.Value())}
+		secret.OnChange(v.rotateHMACSecret)
+	}
+	if cfg.RSAPublicKeyFile != "" {
+		keyFile, err := secrets.Resolve("file:" + cfg.RSAPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("auth: 读取RSA公钥失败: %v", err)
+		}
+		key, err := parseRSAPublicKey([]byte(keyFile.Value()), cfg.RSAPublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		v.rsaKeys = []*rsa.PublicKey{key}
+		keyFile.OnChange(func(data string) {
+			v.rotateRSAPublicKey(data, cfg.RSAPublicKeyFile)
+		})
+	}
+	if cfg.ConnectionTokens.Enabled {
+		if err := v.setupConnectionTokens(cfg.ConnectionTokens); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// rotateHMACSecret 更换HMAC密钥，轮换前的密钥继续有效到下一次轮换
+func (v *Verifier) rotateHMACSecret(secret string) {
+	if secret == "" {
+		log.Printf("auth: 新的 hmac_secret 为空，继续使用原密钥")
+		return
+	}
+	v.keysMu.Lock()
+	v.hmacSecrets = [][]byte{[]byte(secret), v.hmacSecrets[0]}
+	v.keysMu.Unlock()
+	log.Printf("auth: HMAC密钥已轮换，旧密钥在下一次轮换前仍然有效")
+}
+
+// rotateRSAPublicKey 更换RSA公钥，新公钥无法解析时继续使用原公钥
+func (v *Verifier) rotateRSAPublicKey(data, path string) {
+	key, err := parseRSAPublicKey([]byte(data), path)
+	if err != nil {
+		log.Printf("%v，继续使用原公钥", err)
+		return
+	}
+	v.keysMu.Lock()
+	v.rsaKeys = []*rsa.PublicKey{key, v.rsaKeys[0]}
+	v.keysMu.Unlock()
+	log.Printf("auth: RSA公钥已轮换，旧公钥在下一次轮换前仍然有效")
+}
+
+// parseRSAPublicKey 解析PEM格式的RSA公钥（PKIX、PKCS#1或证书），path用于错误信息
+func parseRSAPublicKey(data []byte, path string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("auth: %s 不是有效的PEM文件", path)
+	}
+
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("auth: 解析证书失败: %v", err)
+		}
+		if key, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("auth: 证书中不是RSA公钥")
+	default:
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("auth: 解析RSA公钥失败: %v", err)
+		}
+		if key, ok := pub.(*rsa.PublicKey); ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("auth: %s 不是RSA公钥", path)
+	}
+}
+
+// Verify 校验令牌签名与有效期，返回其中的声明
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("令牌格式错误")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("令牌头解析失败: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("令牌签名解码失败: %v", err)
+	}
+	var raw map[string]interface{}
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, fmt.Errorf("令牌声明解析失败: %v", err)
+	}
+
+	// 连接令牌只用连接令牌的密钥校验，普通密钥签发的令牌不能冒充连接令牌，反之亦然
+	if use, _ := raw["token_use"].(string); use == connectionTokenUse {
+		return v.verifyConnectionToken(header.Alg, parts[0]+"."+parts[1], signature, raw)
+	}
+	if err := v.verifySignature(header.Alg, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+	return v.validateClaims(raw)
+}
+
+// verifySignature 按alg校验签名，只接受已配置密钥对应的算法
+func (v *Verifier) verifySignature(alg, signed string, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("不支持的签名算法: %q", alg)
+	}
+	var newHash func() hash.Hash
+	var cryptoHash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		newHash, cryptoHash = sha256.New, crypto.SHA256
+	case "384":
+		newHash, cryptoHash = sha512.New384, crypto.SHA384
+	case "512":
+		newHash, cryptoHash = sha512.New, crypto.SHA512
+	default:
+		return fmt.Errorf("不支持的签名算法: %q", alg)
+	}
+
+	v.keysMu.RLock()
+	hmacSecrets, rsaKeys := v.hmacSecrets, v.rsaKeys
+	v.keysMu.RUnlock()
+
+	switch {
+	case strings.HasPrefix(alg, "HS") && len(hmacSecrets) > 0:
+		for _, secret := range hmacSecrets {
+			mac := hmac.New(newHash, secret)
+			mac.Write([]byte(signed))
+			if hmac.Equal(mac.Sum(nil), signature) {
+				return nil
+			}
+		}
+		return errors.New("令牌签名无效")
+	case strings.HasPrefix(alg, "RS") && len(rsaKeys) > 0:
+		h := cryptoHash.New()
+		h.Write([]byte(signed))
+		digest := h.Sum(nil)
+		for _, key := range rsaKeys {
+			if rsa.VerifyPKCS1v15(key, cryptoHash, digest, signature) == nil {
+				return nil
+			}
+		}
+		return errors.New("令牌签名无效")
+	default:
+		return fmt.Errorf("不支持的签名算法: %q", alg)
+	}
+}
+
+// validateClaims 校验 exp/nbf/iss/aud
+func (v *Verifier) validateClaims(raw map[string]interface{}) (*Claims, error) {
+	now := time.Now()
+	claims := &Claims{Raw: raw}
+	claims.Subject, _ = raw["sub"].(string)
+	claims.Name, _ = raw["name"].(string)
+	claims.Issuer, _ = raw["iss"].(string)
+	claims.Namespace, _ = raw["namespace"].(string)
+
+	if exp, ok := raw["exp"].(float64); ok {
+		claims.ExpiresAt = time.Unix(int64(exp), 0)
+		if now.After(claims.ExpiresAt.Add(v.leeway)) {
+			return nil, errors.New("令牌已过期")
+		}
+	}
+	if nbf, ok := raw["nbf"].(float64); ok {
+		if now.Add(v.leeway).Before(time.Unix(int64(nbf), 0)) {
+			return nil, errors.New("令牌尚未生效")
+		}
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return nil, fmt.Errorf("令牌签发者不匹配: %q", claims.Issuer)
+	}
+	if v.audience != "" && !hasAudience(raw["aud"], v.audience) {
+		return nil, errors.New("令牌受众不匹配")
+	}
+	return claims, nil
+}
+
+// hasAudience aud 可以是字符串或字符串数组
+func hasAudience(aud interface{}, want string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == want
+	case []interface{}:
+		for _, item := range a {
+			if s, ok := item.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// 内置的认证方式，通过 auth.provider 或负载均衡器、节点各自的 auth_provider 选择
+const (
+	ProviderNone   = "none"   // 不认证
+	ProviderJWT    = "jwt"    // 校验JWT和一次性连接令牌（默认）
+	ProviderStatic = "static" // 预先分配的静态令牌
+	ProviderHTTP   = "http"   // 委托外部HTTP认证服务，如组织的SSO网关
+)
+
+// Provider 客户端认证方式。负载均衡器和服务端节点通过 SetAuth 设置，nil表示不认证；
+// 接入其他SSO时实现该接口即可，不需要修改握手处理
+type Provider interface {
+	// AuthenticateUpgrade 校验WebSocket握手请求，失败时握手以401拒绝。
+	// 返回的响应头需传给Upgrade（如通过子协议传递令牌时的协商）
+	AuthenticateUpgrade(r *http.Request) (*Claims, http.Header, error)
+	// AuthenticateRegistration 校验节点收到的注册消息，失败时以 unauthorized 错误拒绝注册并关闭连接。
+	// 返回nil声明表示沿用握手时的身份
+	AuthenticateRegistration(msg map[string]interface{}) (*Claims, error)
+}
+
+// TokenIssuer 可以通过 POST /api/token 签发一次性连接令牌的认证方式
+type TokenIssuer interface {
+	IssuesConnectionTokens() bool
+	HandleTokenRequest(w http.ResponseWriter, r *http.Request)
+}
+
+// TokenHandler 认证方式签发连接令牌时返回 /api/token 的处理函数，否则返回nil
+func TokenHandler(p Provider) http.HandlerFunc {
+	if issuer, ok := p.(TokenIssuer); ok && issuer.IssuesConnectionTokens() {
+		return issuer.HandleTokenRequest
+	}
+	return nil
+}
+
+// ProviderName 监听端口实际使用的认证方式：override（负载均衡器或节点的 auth_provider）非空时优先，
+// 否则启用认证时为 provider（默认jwt），未启用时为none
+func (c Config) ProviderName(override string) string {
+	switch {
+	case override != "":
+		return override
+	case !c.Enabled:
+		return ProviderNone
+	case c.Provider != "":
+		return c.Provider
+	}
+	return ProviderJWT
+}
+
+// ValidateProvider 检查监听端口选用的认证方式所需的配置是否齐全
+func (c Config) ValidateProvider(override string) error {
+	switch name := c.ProviderName(override); name {
+	case ProviderNone:
+	case ProviderJWT:
+		if c.HMACSecret == "" && c.RSAPublicKeyFile == "" && !c.ConnectionTokens.Enabled {
+			return fmt.Errorf("auth: 使用jwt认证时必须配置 hmac_secret、rsa_public_key_file 或 connection_tokens")
+		}
+	case ProviderStatic:
+		if len(c.StaticTokens) == 0 {
+			return fmt.Errorf("auth: 使用static认证时必须配置 static_tokens")
+		}
+	case ProviderHTTP:
+		if c.HTTP.URL == "" {
+			return fmt.Errorf("auth: 使用http认证时必须配置 http.url")
+		}
+	default:
+		return fmt.Errorf("auth: 无效的认证方式: %s (可选: none, jwt, static, http)", name)
+	}
+	return nil
+}
+
+// NewProvider 按配置创建监听端口使用的认证方式，override 见 ProviderName。认证方式为none时返回nil
+func NewProvider(cfg Config, override string) (Provider, error) {
+	if err := cfg.ValidateProvider(override); err != nil {
+		return nil, err
+	}
+	switch cfg.ProviderName(override) {
+	case ProviderJWT:
+		cfg.Enabled, cfg.Provider = true, ProviderJWT
+		return New(cfg)
+	case ProviderStatic:
+		return newStaticProvider(cfg)
+	case ProviderHTTP:
+		return newHTTPProvider(cfg)
+	}
+	return nil, nil
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// TokenFromRequest 从查询参数、Sec-WebSocket-Protocol 或 Authorization: Bearer 中取出令牌
+// 第二个返回值表示令牌是否来自子协议（此时握手响应需回应 SubprotocolName）
+func (v *Verifier) TokenFromRequest(r *http.Request) (string, bool) {
+	return tokenFromRequest(r, v.queryParam)
+}
+
+func tokenFromRequest(r *http.Request, queryParam string) (string, bool) {
+	if token := r.URL.Query().Get(queryParam); token != "" {
+		return token, false
+	}
+	if token := SubprotocolToken(r); token != "" {
+		return token, true
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token), false
+	}
+	return "", false
+}
+
+// subprotocolHeader 令牌来自子协议时握手响应需回应的请求头
+func subprotocolHeader(viaSubprotocol bool) http.Header {
+	if !viaSubprotocol {
+		return nil
+	}
+	return http.Header{"Sec-WebSocket-Protocol": {SubprotocolName}}
+}
+
+// SubprotocolToken 返回以 "access_token, <jwt>" 形式放在子协议列表中的令牌
+// 浏览器无法自定义握手头，只能借助子协议传递令牌
+func SubprotocolToken(r *http.Request) string {
+	var protocols []string
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(header, ",") {
+			protocols = append(protocols, strings.TrimSpace(p))
+		}
+	}
+	for i := 0; i+1 < len(protocols); i++ {
+		if protocols[i] == SubprotocolName {
+			return protocols[i+1]
+		}
+	}
+	return ""
+}
+
+// AuthenticateUpgrade 校验握手请求中的JWT
+// 返回的响应头需传给 Upgrade，以便在使用子协议传递令牌时完成协商
+func (v *Verifier) AuthenticateUpgrade(r *http.Request) (*Claims, http.Header, error) {
+	token, viaSubprotocol := v.TokenFromRequest(r)
+	if token == "" {
+		return nil, nil, errors.New("缺少访问令牌")
+	}
+	claims, err := v.Verify(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	return claims, subprotocolHeader(viaSubprotocol), nil
+}
+
+// AuthenticateRegistration JWT在握手时已完成认证，注册消息沿用握手时的身份
+func (v *Verifier) AuthenticateRegistration(msg map[string]interface{}) (*Claims, error) {
+	return nil, nil
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"websocket-loadbalance/secrets"
+)
+
+// StaticToken 预先分配给某个客户端或服务的令牌，适合内部服务之间的连接
+type StaticToken struct {
+	Token     string `json:"token" yaml:"token"`         // 令牌值，可以是 env:/file:/vault: 引用
+	Subject   string `json:"subject" yaml:"subject"`     // 认证后的身份，相当于JWT的 sub
+	Name      string `json:"name" yaml:"name"`           // 相当于JWT的 name
+	Namespace string `json:"namespace" yaml:"namespace"` // 客户端所属的命名空间
+}
+
+// Validate 校验静态令牌配置
+func (t StaticToken) Validate() error {
+	if t.Token == "" {
+		return errors.New("token 不能为空")
+	}
+	if t.Subject == "" {
+		return errors.New("subject 不能为空")
+	}
+	return secrets.ValidateRef(t.Token)
+}
+
+// staticEntry 令牌值通过secrets读取，引用的值变化时自动生效
+type staticEntry struct {
+	token  *secrets.Secret
+	claims Claims
+}
+
+// staticProvider 校验握手请求中的静态令牌，令牌的位置与JWT相同（查询参数、子协议或Bearer）
+type staticProvider struct {
+	queryParam string
+	entries    []staticEntry
+}
+
+func newStaticProvider(cfg Config) (*staticProvider, error) {
+	p := &staticProvider{queryParam: cfg.QueryParam}
+	if p.queryParam == "" {
+		p.queryParam = "token"
+	}
+	for i, t := range cfg.StaticTokens {
+		token, err := secrets.Resolve(t.Token)
+		if err != nil {
+			return nil, fmt.Errorf("auth: 读取 static_tokens[%d].token 失败: %v", i, err)
+		}
+		p.entries = append(p.entries, staticEntry{
+			token: token,
+			claims: Claims{
+				Subject:   t.Subject,
+				Name:      t.Name,
+				Namespace: t.Namespace,
+				Raw:       map[string]interface{}{"sub": t.Subject},
+			},
+		})
+	}
+	return p, nil
+}
+
+// AuthenticateUpgrade 按常量时间比较所有已配置的令牌，避免通过耗时推测令牌
+func (p *staticProvider) AuthenticateUpgrade(r *http.Request) (*Claims, http.Header, error) {
+	token, viaSubprotocol := tokenFromRequest(r, p.queryParam)
+	if token == "" {
+		return nil, nil, errors.New("缺少访问令牌")
+	}
+	var matched *staticEntry
+	for i := range p.entries {
+		value := p.entries[i].token.Value()
+		if value != "" && subtle.ConstantTimeCompare([]byte(token), []byte(value)) == 1 && matched == nil {
+			matched = &p.entries[i]
+		}
+	}
+	if matched == nil {
+		return nil, nil, errors.New("令牌无效")
+	}
+	claims := matched.claims
+	return &claims, subprotocolHeader(viaSubprotocol), nil
+}
+
+// AuthenticateRegistration 静态令牌在握手时已完成认证，注册消息沿用握手时的身份
+func (p *staticProvider) AuthenticateRegistration(msg map[string]interface{}) (*Claims, error) {
+	return nil, nil
+}
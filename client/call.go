@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"websocket-loadbalance/protocol"
+)
+
+// defaultCallTimeout Call等待响应的默认超时
+const defaultCallTimeout = 10 * time.Second
+
+var (
+	errNotConnected   = errors.New("客户端未连接")
+	errConnectionLost = errors.New("连接已断开，未收到响应")
+)
+
+// pendingCalls 按消息ID登记等待响应的请求
+type pendingCalls struct {
+	mu      sync.Mutex
+	waiters map[string]chan *protocol.Response
+}
+
+func newPendingCalls() *pendingCalls {
+	return &pendingCalls{waiters: make(map[string]chan *protocol.Response)}
+}
+
+func (p *pendingCalls) add(id string) chan *protocol.Response {
+	ch := make(chan *protocol.Response, 1)
+	p.mu.Lock()
+	p.waiters[id] = ch
+	p.mu.Unlock()
+	return ch
+}
+
+func (p *pendingCalls) remove(id string) {
+	p.mu.Lock()
+	delete(p.waiters, id)
+	p.mu.Unlock()
+}
+
+// resolve 将响应交给对应的等待者，返回是否有等待者
+func (p *pendingCalls) resolve(resp *protocol.Response) bool {
+	p.mu.Lock()
+	ch, ok := p.waiters[resp.ID]
+	delete(p.waiters, resp.ID)
+	p.mu.Unlock()
+	if ok {
+		ch <- resp
+	}
+	return ok
+}
+
+// failAll 连接断开时让所有等待者立即返回errConnectionLost
+func (p *pendingCalls) failAll() {
+	p.mu.Lock()
+	waiters := p.waiters
+	p.waiters = make(map[string]chan *protocol.Response)
+	p.mu.Unlock()
+	for _, ch := range waiters {
+		close(ch)
+	}
+}
+
+// Call 发送method/path请求并等待对应ID的响应，超时时间见Options.CallTimeout。
+// 响应由消息处理循环（StartWithAutoReconnect或HandleServerMessages）分发，
+// 多个goroutine可以同时调用，各自只会收到自己请求的响应
+func (c *Client) Call(method, path string, body interface{}) (*protocol.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.callTimeout)
+	defer cancel()
+	return c.CallContext(ctx, method, path, body)
+}
+
+// CallContext 与Call相同，由ctx控制超时和取消
+func (c *Client) CallContext(ctx context.Context, method, path string, body interface{}) (*protocol.Response, error) {
+	if c.conn == nil {
+		return nil, errNotConnected
+	}
+
+	msg := protocol.NewMessage(method, path, body)
+	ch := c.calls.add(msg.ID)
+	if err := c.writeJSON(msg); err != nil {
+		c.calls.remove(msg.ID)
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, errConnectionLost
+		}
+		return resp, nil
+	case <-ctx.Done():
+		c.calls.remove(msg.ID)
+		return nil, fmt.Errorf("等待 %s %s 的响应失败: %w", method, path, ctx.Err())
+	}
+}
+
+// resolveCall 将收到的响应交给等待中的Call，没有等待者时返回false
+func (c *Client) resolveCall(msg map[string]interface{}) bool {
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		return false
+	}
+	var resp protocol.Response
+	if err := json.Unmarshal(encoded, &resp); err != nil || resp.ID == "" {
+		return false
+	}
+	return c.calls.resolve(&resp)
+}
+
+// writeJSON 串行化写入连接，gorilla/websocket不支持并发写
+func (c *Client) writeJSON(v interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.conn == nil {
+		return errNotConnected
+	}
+	return c.writeFrame(c.conn, c.codec, v)
+}
@@ -0,0 +1,714 @@
+// Package client 实现连接负载均衡器的WebSocket客户端，支持自动重连和服务端指令
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/tracing"
+)
+
+// Client WebSocket客户端
+type Client struct {
+	conn              *websocket.Conn
+	clientID          string
+	clientName        string
+	proxyURL          string
+	serverURL         string
+	dialer            *websocket.Dialer
+	compressionLevel  int
+	clientType        string
+	topics            []string          // 连接（含重连）后自动订阅的主题
+	namespace         string            // 注册时声明的命名空间，空表示默认命名空间
+	labels            map[string]string // 注册时声明的标签，可按标签选择器向客户端发送指令
+	affinity          string            // 节点亲和性（节点标签的选择器），握手和注册时声明
+	tokenURL          string            // 每次连接前换取连接令牌的地址，空表示不携带令牌
+	writeMu           sync.Mutex        // 串行化写操作，Call可能与消息处理并发写
+	calls             *pendingCalls     // 等待响应的Call请求
+	callTimeout       time.Duration
+	encoding          string                    // 请求的消息编码，空表示JSON
+	codec             protocol.Codec            // 当前连接协商成功的二进制编码，nil表示JSON
+	protocolVersion   int                       // 握手时声明的协议版本，0表示不声明
+	heartbeatEvery    time.Duration             // Options指定的心跳间隔，0表示采用服务端告知的间隔
+	serverHeartbeat   atomic.Int64              // 服务端在 heartbeat_ack 中告知的心跳间隔
+	heartbeatChanged  chan struct{}             // 服务端告知的心跳间隔变化时通知心跳协程
+	status            atomic.Value              // 在心跳中上报的状态（string），空表示online
+	middleware        []protocol.Middleware     // 收发消息经过的中间件，见Use
+	handlers          map[string]CommandHandler // 指令处理函数，见RegisterHandler
+	handlerMiddleware []HandlerMiddleware       // 指令处理中间件，见UseHandler
+	handlersMu        sync.RWMutex
+	deliveries        *deliveryCache // 处理过的需要确认的指令，重发时去重
+}
+
+// Options 客户端连接选项
+type Options struct {
+	Compression      bool // 协商permessage-deflate
+	CompressionLevel int  // 压缩级别1~9，0表示使用默认级别
+	// 客户端类型，握手时以 client_type 参数发送，负载均衡器可据此关闭会话保持
+	ClientType string
+	// 连接后自动订阅的主题
+	Topics []string
+	// 客户端所属的命名空间，只能与同一命名空间的客户端互通，空表示默认命名空间
+	Namespace string
+	// 注册时声明的标签，如 {"env": "prod", "role": "pos"}，/api/send-command 可按标签选择器选择客户端
+	Labels map[string]string
+	// 节点亲和性，节点标签的选择器，如 "region=eu,gpu"。握手时以 affinity 参数发送，
+	// 负载均衡器使用 affinity 策略时优先选择标签满足条件的节点；同时在注册时声明，记录在全局注册表中
+	Affinity string
+	// 连接令牌接口地址（如 http://app.example.com/api/token），设置后每次连接（含重连）前
+	// 以 client_id 换取一次性连接令牌，并通过 token 查询参数携带
+	TokenURL string
+	// Call等待响应的超时，0表示默认10s
+	CallTimeout time.Duration
+	// 消息编码: json(默认)、msgpack 或 protobuf，服务端不支持时退回JSON
+	Encoding string
+	// 握手时以 protocol_version 参数声明的协议版本，0表示不声明（服务端按版本1处理）
+	ProtocolVersion int
+	// 心跳间隔，0表示采用服务端在 heartbeat_ack 中告知的间隔（默认10s）
+	HeartbeatInterval time.Duration
+}
+
+// New 创建客户端
+func New(proxyURL, serverURL, clientID, clientName string) (*Client, error) {
+	c := &Client{
+		clientID:         clientID,
+		clientName:       clientName,
+		proxyURL:         proxyURL,
+		serverURL:        serverURL,
+		dialer:           websocket.DefaultDialer,
+		calls:            newPendingCalls(),
+		callTimeout:      defaultCallTimeout,
+		heartbeatChanged: make(chan struct{}, 1),
+		handlers:         make(map[string]CommandHandler),
+		deliveries:       newDeliveryCache(),
+	}
+	c.registerBuiltinHandlers()
+	return c, nil
+}
+
+// SetOptions 设置连接选项，在下次连接时生效
+func (c *Client) SetOptions(opts Options) {
+	dialer := *websocket.DefaultDialer
+	dialer.EnableCompression = opts.Compression
+	c.dialer = &dialer
+	c.compressionLevel = opts.CompressionLevel
+	c.clientType = opts.ClientType
+	c.topics = opts.Topics
+	c.namespace = opts.Namespace
+	c.labels = opts.Labels
+	c.affinity = opts.Affinity
+	c.tokenURL = opts.TokenURL
+	c.encoding = opts.Encoding
+	c.protocolVersion = opts.ProtocolVersion
+	c.heartbeatEvery = opts.HeartbeatInterval
+	if c.encoding != "" && c.encoding != protocol.EncodingJSON {
+		dialer.Subprotocols = []string{c.encoding}
+	}
+	if opts.CallTimeout > 0 {
+		c.callTimeout = opts.CallTimeout
+	}
+}
+
+// fetchConnectionToken 以客户端ID换取一次性连接令牌
+func (c *Client) fetchConnectionToken() (string, error) {
+	body, _ := json.Marshal(map[string]string{
+		"client_id":   c.clientID,
+		"client_name": c.clientName,
+		"namespace":   c.namespace,
+	})
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	resp, err := httpClient.Post(c.tokenURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	var result struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.Token == "" {
+		return "", fmt.Errorf("响应中没有 token")
+	}
+	return result.Token, nil
+}
+
+// 连接到负载均衡器
+func (c *Client) ConnectToLoadBalancer() error {
+	u, err := url.Parse(c.proxyURL)
+	if err != nil {
+		return err
+	}
+	// 携带client_id，负载均衡器据此保持会话，换了地址重连也会回到原来的节点
+	query := u.Query()
+	query.Set("client_id", c.clientID)
+	if c.clientType != "" {
+		query.Set("client_type", c.clientType)
+	}
+	if c.affinity != "" {
+		query.Set("affinity", c.affinity)
+	}
+	if c.protocolVersion > 0 {
+		query.Set(protocol.VersionParam, strconv.Itoa(c.protocolVersion))
+	}
+	if c.tokenURL != "" {
+		token, err := c.fetchConnectionToken()
+		if err != nil {
+			return fmt.Errorf("获取连接令牌失败: %v", err)
+		}
+		query.Set("token", token)
+	}
+	u.RawQuery = query.Encode()
+
+	log.Printf("连接到负载均衡器: %s", c.proxyURL)
+	conn, _, err := c.dialer.Dial(u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.dialer.EnableCompression && c.compressionLevel != 0 {
+		conn.SetCompressionLevel(c.compressionLevel)
+	}
+	codec, _ := protocol.CodecFor(conn.Subprotocol())
+	if c.encoding != "" && c.encoding != protocol.EncodingJSON && codec == nil {
+		log.Printf("服务端不支持 %s 编码，使用JSON", c.encoding)
+	}
+
+	c.writeMu.Lock()
+	c.conn = conn
+	c.codec = codec
+	c.writeMu.Unlock()
+
+	// 发送注册消息
+	registerMsg := map[string]interface{}{
+		"client_id":    c.clientID,
+		"client_name":  c.clientName,
+		"accept_batch": true, // 支持拆包服务端的批量消息
+		"ack":          true, // 确认收到的指令，节点启用 command_delivery 时未确认的指令会重发
+		// 声明能力，服务端据此拒绝本客户端无法处理的指令
+		"capabilities": map[string]interface{}{
+			"supports_exec":          false,
+			"supports_file_transfer": false,
+			"commands":               c.Commands(),
+		},
+		"timestamp": time.Now().Unix(),
+	}
+	if c.namespace != "" {
+		registerMsg["namespace"] = c.namespace
+	}
+	if len(c.labels) > 0 {
+		registerMsg["labels"] = c.labels
+	}
+	if c.affinity != "" {
+		registerMsg["affinity"] = c.affinity
+	}
+
+	if err := c.writeFrame(conn, codec, registerMsg); err != nil {
+		conn.Close()
+		return err
+	}
+
+	log.Printf("✅ 客户端注册成功: %s (%s)", c.clientName, c.clientID)
+	// 服务端按心跳判定在线状态
+	go c.heartbeatLoop(conn)
+
+	// 订阅关系保存在服务端的连接上，重连后需要重新订阅
+	for _, topic := range c.topics {
+		if err := c.Subscribe(topic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Subscribe 订阅主题，之后发布到该主题的消息以 message 类型投递
+func (c *Client) Subscribe(topic string) error {
+	return c.writeJSON(protocol.PubSubMessage{Type: protocol.TypeSubscribe, Topic: topic, Timestamp: time.Now().UnixMilli()})
+}
+
+// Unsubscribe 取消订阅主题
+func (c *Client) Unsubscribe(topic string) error {
+	return c.writeJSON(protocol.PubSubMessage{Type: protocol.TypeUnsubscribe, Topic: topic, Timestamp: time.Now().UnixMilli()})
+}
+
+// Publish 向主题发布消息，所有节点上的订阅者都会收到
+func (c *Client) Publish(topic string, data interface{}) error {
+	return c.writeJSON(protocol.PubSubMessage{Type: protocol.TypePublish, Topic: topic, Data: data, Timestamp: time.Now().UnixMilli()})
+}
+
+// SendMessage 发送消息，不等待响应。需要响应时使用Call
+func (c *Client) SendMessage(method, path string, body interface{}) error {
+	msg := protocol.NewMessage(method, path, body)
+
+	if err := c.writeJSON(msg); err != nil {
+		return err
+	}
+
+	fmt.Printf("发送: %s %s\n", method, path)
+	return nil
+}
+
+// ReceiveResponse 直接从连接读取下一条响应，不能与消息处理循环或Call同时使用，
+// 否则会读到其他请求的响应
+func (c *Client) ReceiveResponse() (*protocol.Response, error) {
+	var resp protocol.Response
+	err := c.readJSON(&resp)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("接收: 状态%d\n", resp.Status)
+	if resp.Body != nil {
+		bodyJSON, _ := json.MarshalIndent(resp.Body, "", "  ")
+		fmt.Printf("响应体: %s\n", string(bodyJSON))
+	}
+	if resp.Error != "" {
+		fmt.Printf("错误: %s\n", resp.Error)
+	}
+
+	return &resp, nil
+}
+
+// HandleServerMessages 处理服务器消息
+func (c *Client) HandleServerMessages() {
+	for {
+		var msg map[string]interface{}
+		err := c.readJSON(&msg)
+		if err != nil {
+			log.Printf("读取服务器消息失败: %v", err)
+			c.calls.failAll()
+			break
+		}
+
+		c.handleServerMessage(msg)
+	}
+}
+
+// 处理服务器消息
+func (c *Client) handleServerMessage(msg map[string]interface{}) {
+	msgType, ok := msg["type"].(string)
+	if !ok {
+		// 没有type字段的是method/path请求的响应，交给等待中的Call
+		if _, isResponse := msg["status"]; isResponse && c.resolveCall(msg) {
+			return
+		}
+		log.Printf("收到无效消息: %v", msg)
+		return
+	}
+
+	switch msgType {
+	case "batch":
+		// 服务端批量发送的消息，逐条处理
+		messages, _ := msg["messages"].([]interface{})
+		for _, item := range messages {
+			if inner, ok := item.(map[string]interface{}); ok {
+				c.handleServerMessage(inner)
+			}
+		}
+
+	case "command":
+		// 处理服务器发送的指令
+		c.handleCommand(msg)
+
+	case "query_name":
+		// 服务器查询客户端名字
+		log.Printf("📨 收到服务器查询名字请求")
+
+		// 回复客户端名字
+		replyMsg := map[string]interface{}{
+			"type":        "name_response",
+			"client_id":   c.clientID,
+			"client_name": c.clientName,
+			"timestamp":   time.Now().Unix(),
+		}
+
+		if err := c.writeJSON(replyMsg); err != nil {
+			log.Printf("回复客户端名字失败: %v", err)
+		} else {
+			log.Printf("✅ 已回复客户端名字: %s", c.clientName)
+		}
+
+	case "quota_warning":
+		// 接近配额上限，降低发送频率
+		log.Printf("⚠️ 接近%v配额: %v/%v，窗口重置时间: %v", msg["quota"], msg["used"], msg["limit"],
+			time.Unix(int64(toFloat(msg["reset_at"])), 0).Format("15:04:05"))
+
+	case "quota_exceeded":
+		log.Printf("🚫 超出%v配额，消息已被服务器丢弃: %v/%v", msg["quota"], msg["used"], msg["limit"])
+
+	case "error":
+		// 服务端返回的协议层错误，如 rate_limited
+		log.Printf("❌ 服务器错误 [%v]: %v", msg["code"], msg["message"])
+
+	case protocol.TypeTopicMessage:
+		data, _ := json.Marshal(msg["data"])
+		from, _ := msg["from"].(string)
+		if from == "" {
+			from = "HTTP API" // 通过 /api/publish 发布
+		}
+		log.Printf("📢 主题 %v 的消息 (来自 %s): %s", msg["topic"], from, data)
+
+	case protocol.TypePubSubAck:
+		if success, _ := msg["success"].(bool); success {
+			log.Printf("✅ %v 主题 %v 成功", msg["action"], msg["topic"])
+		} else {
+			log.Printf("❌ %v 主题 %v 失败: %v", msg["action"], msg["topic"], msg["error"])
+		}
+
+	case "summary":
+		// 读取过慢期间服务端合并了部分广播消息
+		log.Printf("🐢 读取过慢期间有 %v 条广播被服务端合并，从 %v 开始", msg["suppressed"],
+			time.Unix(int64(toFloat(msg["since"])), 0).Format("15:04:05"))
+
+	case protocol.TypeHeartbeatAck:
+		c.handleHeartbeatAck(msg)
+
+	case protocol.TypeClientIDAssigned:
+		// 节点分配了新ID或把旧ID映射到了新ID，当前连接已以新ID注册，之后的消息和重连都使用新ID。
+		// 写操作持有writeMu时读取clientID，其余读取都在消息处理协程中
+		if id, _ := msg["client_id"].(string); id != "" && id != c.clientID {
+			c.writeMu.Lock()
+			c.clientID = id
+			c.writeMu.Unlock()
+			log.Printf("🆔 节点使用客户端ID %s（请求的ID: %v，方案: %v），重连时使用该ID", id, msg["requested_id"], msg["scheme"])
+		}
+
+	case protocol.TypeReconnect:
+		// 服务端要求重连（如连接达到最长存活时间），正常关闭后由自动重连重新连接
+		log.Printf("♻️ 服务器要求重连: %v", msg["reason"])
+		c.closeForReconnect()
+
+	case "ping":
+		// 心跳检测
+		pongMsg := map[string]interface{}{
+			"type":      "pong",
+			"timestamp": time.Now().Unix(),
+		}
+		c.writeJSON(pongMsg)
+
+	default:
+		log.Printf("收到消息: %s", msgType)
+	}
+}
+
+// handleCommand 处理服务器发送的指令
+func (c *Client) handleCommand(msg map[string]interface{}) {
+	// 同步指令携带request_id，响应中需原样带回
+	requestID, _ := msg["request_id"].(string)
+
+	// 需要确认的指令先回复ack；重发的指令已处理过时不再执行，只重新发送之前的响应
+	deliveryID, _ := msg["delivery_id"].(string)
+	if deliveryID != "" {
+		c.ackCommand(deliveryID)
+		if response, seen := c.deliveries.lookup(deliveryID); seen {
+			log.Printf("📨 指令 %s 已处理过，重新发送响应", deliveryID)
+			if err := c.writeJSON(response); err != nil {
+				log.Printf("❌ 发送指令响应失败: %v", err)
+			}
+			return
+		}
+	}
+
+	// 指令带有追踪上下文时，处理过程记录为节点 command.deliver 的子span
+	traceparent, _ := msg[tracing.Header].(string)
+	parent, _ := tracing.ParseTraceparent(traceparent)
+	span := tracing.Start("client.command", tracing.KindConsumer, parent)
+	defer span.End()
+	span.SetAttribute("client.id", c.clientID)
+	span.SetAttribute("request.id", requestID)
+
+	command, ok := msg["command"].(string)
+	if !ok {
+		log.Printf("❌ 收到无效指令: %v", msg)
+		c.sendCommandResponse(span, requestID, "error", "无效的指令格式", nil)
+		return
+	}
+	span.SetAttribute("command", command)
+
+	log.Printf("📨 收到指令: %s", command)
+
+	var payload json.RawMessage
+	if data := msg["data"]; data != nil {
+		payload, _ = json.Marshal(data)
+	}
+	from, _ := msg["from"].(string)
+	ctx := context.WithValue(context.Background(), commandInfoKey{}, CommandInfo{Command: command, RequestID: requestID, From: from})
+	result, err := c.dispatch(ctx, command, payload)
+	if deliveryID != "" && errors.Is(err, ErrRetry) {
+		span.SetError(err)
+		c.nackCommand(deliveryID, err)
+		return
+	}
+	responseType, responseMessage, responseData := commandResponse(result, err)
+	response := c.sendCommandResponse(span, requestID, responseType, responseMessage, responseData)
+	if deliveryID != "" {
+		c.deliveries.store(deliveryID, response)
+	}
+}
+
+// sendCommandResponse 发送指令响应，并将结果记录到处理指令的span，返回发送的响应
+func (c *Client) sendCommandResponse(span *tracing.Span, requestID, responseType, message string, data interface{}) map[string]interface{} {
+	span.SetAttribute("command.result", responseType)
+	if responseType != "success" {
+		span.SetError(fmt.Errorf("%s", message))
+	}
+
+	response := map[string]interface{}{
+		"type":      "command_response",
+		"result":    responseType,
+		"message":   message,
+		"data":      data,
+		"client_id": c.clientID,
+		"timestamp": time.Now().Unix(),
+	}
+	if requestID != "" {
+		response["request_id"] = requestID
+	}
+
+	if err := c.writeJSON(response); err != nil {
+		span.SetError(err)
+		log.Printf("❌ 发送指令响应失败: %v", err)
+	} else {
+		log.Printf("✅ 已发送指令响应: %s - %s", responseType, message)
+	}
+	return response
+}
+
+// Close 关闭连接
+func (c *Client) Close() error {
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+// closeForReconnect 以正常关闭码发起关闭握手，服务端回应后读取返回关闭错误，
+// 自动重连时重新换取连接令牌，并由负载均衡器重新选择节点
+func (c *Client) closeForReconnect() {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.conn == nil {
+		return
+	}
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "按服务器要求重连")
+	if err := c.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second)); err != nil {
+		log.Printf("发送关闭帧失败: %v", err)
+	}
+}
+
+// StartWithAutoReconnect 启动客户端并支持自动重连
+func (c *Client) StartWithAutoReconnect() {
+	// 创建上下文用于优雅关闭
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 监听系统信号
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	// 启动重连循环
+	go c.reconnectLoop(ctx)
+
+	// 等待系统信号
+	<-sigChan
+	log.Printf("收到关闭信号，正在优雅关闭客户端...")
+	cancel()
+
+	// 给一些时间清理资源
+	time.Sleep(1 * time.Second)
+	c.Close()
+	log.Printf("客户端已关闭")
+}
+
+// RunWithAutoReconnect 保持连接并在断开后自动重连，直到ctx取消。
+// 与 StartWithAutoReconnect 不同，不监听系统信号，适合在同一进程中运行多个客户端
+func (c *Client) RunWithAutoReconnect(ctx context.Context) {
+	c.reconnectLoop(ctx)
+	c.Close()
+}
+
+// reconnectLoop 自动重连循环
+func (c *Client) reconnectLoop(ctx context.Context) {
+	retryCount := 0
+	baseDelay := 2 * time.Second
+	maxDelay := 30 * time.Second
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("重连循环收到关闭信号，退出")
+			return
+		default:
+		}
+
+		// 尝试连接
+		err := c.ConnectToLoadBalancer()
+		if err != nil {
+			retryCount++
+			// 计算退避延迟
+			delay := time.Duration(retryCount) * baseDelay
+			if delay > maxDelay {
+				delay = maxDelay
+			}
+
+			log.Printf("❌ 连接失败 (第%d次重试): %v", retryCount, err)
+			log.Printf("⏳ %v 后重试连接...", delay)
+
+			// 等待重试或接收关闭信号
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+				continue
+			}
+		}
+
+		// 连接成功，重置重试计数
+		if retryCount > 0 {
+			log.Printf("✅ 重连成功! (共重试%d次)", retryCount)
+		} else {
+			log.Printf("✅ 首次连接成功")
+		}
+		retryCount = 0
+
+		// 启动消息处理
+		messageErrChan := make(chan error, 1)
+		go func() {
+			messageErrChan <- c.handleMessagesWithReconnect(ctx)
+		}()
+
+		// 等待连接断开或关闭信号
+		select {
+		case <-ctx.Done():
+			c.Close()
+			return
+		case err := <-messageErrChan:
+			if err != nil {
+				log.Printf("🔗 连接中断: %v", err)
+			}
+			c.Close()
+			c.calls.failAll()
+			log.Printf("🔄 准备重连...")
+			time.Sleep(1 * time.Second) // 短暂等待后重连
+		}
+	}
+}
+
+// handleMessagesWithReconnect 处理消息并支持重连
+func (c *Client) handleMessagesWithReconnect(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		var msg map[string]interface{}
+		err := c.readJSON(&msg)
+		if err != nil {
+			// 检查是否是正常关闭
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("服务器正常关闭连接")
+				return err
+			}
+			// 其他错误表示异常断开，需要重连
+			return fmt.Errorf("读取消息失败: %v", err)
+		}
+
+		// 处理收到的消息
+		c.handleServerMessage(msg)
+	}
+}
+
+// InteractiveClient 交互式客户端（带自动重连）
+func InteractiveClient(loadbalancerURL, serverURL, clientID, clientName string, opts Options) {
+	client, err := New(loadbalancerURL, serverURL, clientID, clientName)
+	if err != nil {
+		log.Fatal("创建客户端失败:", err)
+	}
+	client.SetOptions(opts)
+
+	fmt.Println("WebSocket客户端启动")
+	fmt.Println("负载均衡器:", loadbalancerURL)
+	fmt.Println("客户端ID:", clientID)
+	fmt.Println("客户端名称:", clientName)
+	fmt.Println("自动重连: 已启用")
+	if opts.Compression {
+		fmt.Println("压缩: permessage-deflate")
+	}
+	fmt.Println()
+	fmt.Println("按 Ctrl+C 退出")
+	fmt.Println()
+
+	// 启动自动重连循环
+	client.StartWithAutoReconnect()
+}
+
+// Run 独立运行客户端，clientID和clientName为空时自动生成
+func Run(loadbalancerURL, serverURL, clientID, clientName string, opts Options) {
+	// 生成默认的客户端ID和名称
+	if clientID == "" {
+		clientID = fmt.Sprintf("client_%d_%s", time.Now().Unix(), generateRandomString(6))
+	}
+	if clientName == "" {
+		suffix := clientID
+		if len(suffix) > 6 {
+			suffix = suffix[len(suffix)-6:]
+		}
+		clientName = fmt.Sprintf("客户端_%s", suffix)
+	}
+
+	fmt.Println("启动Go WebSocket客户端")
+	fmt.Println("负载均衡器:", loadbalancerURL)
+	fmt.Println("服务端:", serverURL)
+	fmt.Println("客户端ID:", clientID)
+	fmt.Println("客户端名称:", clientName)
+	fmt.Println()
+
+	InteractiveClient(loadbalancerURL, serverURL, clientID, clientName, opts)
+}
+
+// 将JSON数字转换为float64，非数字返回0
+func toFloat(v interface{}) float64 {
+	f, _ := v.(float64)
+	return f
+}
+
+// 生成随机字符串
+func generateRandomString(length int) string {
+	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, length)
+	for i := range b {
+		b[i] = charset[time.Now().UnixNano()%int64(len(charset))]
+	}
+	return string(b)
+}
+
+// 测试用例示例:
+// GET /info - 获取服务器信息
+// GET /health - 健康检查
+// POST /users {"name": "张三", "age": 25}
+// PUT /users/1 {"name": "李四", "age": 30}
+// DELETE /users/1
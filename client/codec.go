@@ -0,0 +1,50 @@
+package client
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+)
+
+// readJSON 读取一条消息，二进制帧按协商的编码解码后经过中间件；被中间件丢弃的消息跳过，继续读取下一条
+func (c *Client) readJSON(v interface{}) error {
+	for {
+		messageType, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if messageType == websocket.BinaryMessage && c.codec != nil {
+			if data, err = c.codec.ToJSON(data); err != nil {
+				return err
+			}
+		}
+		data, ok := c.inbound(data)
+		if !ok {
+			continue
+		}
+		return json.Unmarshal(data, v)
+	}
+}
+
+// writeFrame 让消息经过中间件后按协商的编码写出，未协商二进制编码时使用JSON文本帧
+func (c *Client) writeFrame(conn *websocket.Conn, codec protocol.Codec, v interface{}) error {
+	if codec == nil && len(c.middleware) == 0 {
+		return conn.WriteJSON(v)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return protocol.Chain(protocol.DirectionOutbound, func(f *protocol.Frame) error {
+		if codec == nil {
+			return conn.WriteMessage(websocket.TextMessage, f.Data)
+		}
+		encoded, err := codec.FromJSON(f.Data)
+		if err != nil {
+			return err
+		}
+		return conn.WriteMessage(websocket.BinaryMessage, encoded)
+	}, c.middleware)(&protocol.Frame{Direction: protocol.DirectionOutbound, ClientID: c.clientID, Data: data})
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"websocket-loadbalance/protocol"
+)
+
+// ErrRetry 处理函数返回包装了 ErrRetry 的错误（如 fmt.Errorf("设备忙: %w", client.ErrRetry)）时，
+// 需要确认的指令不发送响应，而是回复 nack，由节点按退避间隔重发；不需要确认的指令按普通错误响应
+var ErrRetry = errors.New("暂时无法处理，请稍后重发")
+
+// 去重缓存保留的 delivery_id 数
+const maxDeliveryCache = 256
+
+// deliveryCache 最近处理过的 delivery_id 及其响应。节点未收到确认时会重发同一条指令，
+// 重复的指令不再执行，只重新发送之前的响应
+type deliveryCache struct {
+	mu        sync.Mutex
+	responses map[string]map[string]interface{}
+	order     []string
+}
+
+func newDeliveryCache() *deliveryCache {
+	return &deliveryCache{responses: make(map[string]map[string]interface{})}
+}
+
+// lookup 返回之前对该指令的响应，没有处理过时ok为false
+func (d *deliveryCache) lookup(deliveryID string) (response map[string]interface{}, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	response, ok = d.responses[deliveryID]
+	return response, ok
+}
+
+// store 记录指令的响应，超过上限时淘汰最早的
+func (d *deliveryCache) store(deliveryID string, response map[string]interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, exists := d.responses[deliveryID]; !exists {
+		d.order = append(d.order, deliveryID)
+	}
+	d.responses[deliveryID] = response
+	for len(d.order) > maxDeliveryCache {
+		delete(d.responses, d.order[0])
+		d.order = d.order[1:]
+	}
+}
+
+// ackCommand 确认收到需要确认的指令
+func (c *Client) ackCommand(deliveryID string) {
+	if err := c.writeJSON(map[string]interface{}{
+		"type":        protocol.TypeAck,
+		"delivery_id": deliveryID,
+	}); err != nil {
+		log.Printf("❌ 确认指令 %s 失败: %v", deliveryID, err)
+	}
+}
+
+// nackCommand 请求节点稍后重发指令
+func (c *Client) nackCommand(deliveryID string, reason error) {
+	if err := c.writeJSON(map[string]interface{}{
+		"type":        protocol.TypeNack,
+		"delivery_id": deliveryID,
+		"reason":      reason.Error(),
+		"timestamp":   time.Now().Unix(),
+	}); err != nil {
+		log.Printf("❌ nack 指令 %s 失败: %v", deliveryID, err)
+	} else {
+		log.Printf("↩️ 指令 %s 暂时无法处理，已请求重发: %v", deliveryID, reason)
+	}
+}
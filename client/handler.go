@@ -0,0 +1,206 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// CommandHandler 处理服务端下发的一种指令。payload 为指令的 data 字段（原始JSON，指令未携带数据时为nil），
+// 返回值作为 command_response 的 data 发回服务端；返回错误时响应的 result 为 error、message 为错误信息。
+// 指令在消息处理协程中依次处理，耗时的操作应在处理函数中另起协程，避免阻塞后续消息
+type CommandHandler func(ctx context.Context, payload json.RawMessage) (interface{}, error)
+
+// HandlerMiddleware 包装指令处理函数，用于日志、鉴权、指标等横切逻辑，command为正在处理的指令
+type HandlerMiddleware func(command string, next CommandHandler) CommandHandler
+
+// Result 处理函数可以返回的结果，需要自定义响应的 message 时使用；其他返回值作为 data、message 为"执行成功"
+type Result struct {
+	Message string
+	Data    interface{}
+}
+
+// CommandError 处理函数返回的错误，Data随错误响应一起发回服务端
+type CommandError struct {
+	Message string
+	Data    interface{}
+}
+
+func (e *CommandError) Error() string {
+	return e.Message
+}
+
+// CommandInfo 正在处理的指令，处理函数通过 CommandInfoFrom 从ctx中取得
+type CommandInfo struct {
+	Command   string
+	RequestID string // 同步指令的请求ID，异步指令为空
+	From      string // 下发指令的节点，如 node-node1
+}
+
+type commandInfoKey struct{}
+
+// CommandInfoFrom 返回ctx中正在处理的指令
+func CommandInfoFrom(ctx context.Context) (CommandInfo, bool) {
+	info, ok := ctx.Value(commandInfoKey{}).(CommandInfo)
+	return info, ok
+}
+
+// Typed 把参数为具体类型的处理函数包装为 CommandHandler：payload按JSON解析为T，
+// 解析失败时不调用fn，直接返回错误响应。指令未携带数据时fn收到T的零值
+func Typed[T any](fn func(ctx context.Context, payload T) (interface{}, error)) CommandHandler {
+	return func(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+		var payload T
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &payload); err != nil {
+				return nil, fmt.Errorf("指令数据格式错误: %v", err)
+			}
+		}
+		return fn(ctx, payload)
+	}
+}
+
+// RegisterHandler 注册指令处理函数（需在连接之前调用），与已有指令（包括内置的 ping、status 等）同名时替换原来的处理函数。
+// 已注册的指令在连接时作为能力声明发送给服务端，服务端不会向客户端下发未注册的指令
+func (c *Client) RegisterHandler(command string, handler CommandHandler) {
+	c.handlersMu.Lock()
+	defer c.handlersMu.Unlock()
+	c.handlers[command] = handler
+}
+
+// UseHandler 添加指令处理中间件（需在连接之前调用），先添加的中间件在外层
+func (c *Client) UseHandler(middleware ...HandlerMiddleware) {
+	c.handlersMu.Lock()
+	defer c.handlersMu.Unlock()
+	c.handlerMiddleware = append(c.handlerMiddleware, middleware...)
+}
+
+// Commands 已注册的指令，按名称排序
+func (c *Client) Commands() []string {
+	c.handlersMu.RLock()
+	defer c.handlersMu.RUnlock()
+	commands := make([]string, 0, len(c.handlers))
+	for command := range c.handlers {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	return commands
+}
+
+// dispatch 按指令名称找到处理函数，经过中间件后调用。处理函数panic时返回错误，不影响连接
+func (c *Client) dispatch(ctx context.Context, command string, payload json.RawMessage) (result interface{}, err error) {
+	c.handlersMu.RLock()
+	handler, ok := c.handlers[command]
+	middleware := c.handlerMiddleware
+	c.handlersMu.RUnlock()
+	if !ok {
+		return nil, &CommandError{
+			Message: fmt.Sprintf("未知指令: %s", command),
+			Data:    map[string]interface{}{"supported_commands": c.Commands()},
+		}
+	}
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](command, handler)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("❌ 处理指令 %s 时panic: %v", command, r)
+			result, err = nil, fmt.Errorf("处理指令 %s 出错: %v", command, r)
+		}
+	}()
+	return handler(ctx, payload)
+}
+
+// commandResponse 把处理结果转换为 command_response 的 result、message 和 data
+func commandResponse(result interface{}, err error) (string, string, interface{}) {
+	if err != nil {
+		var cmdErr *CommandError
+		if errors.As(err, &cmdErr) {
+			return "error", cmdErr.Message, cmdErr.Data
+		}
+		return "error", err.Error(), nil
+	}
+	switch r := result.(type) {
+	case Result:
+		return "success", r.Message, r.Data
+	case *Result:
+		return "success", r.Message, r.Data
+	}
+	return "success", "执行成功", result
+}
+
+// registerBuiltinHandlers 注册内置指令，调用方可以用 RegisterHandler 替换
+func (c *Client) registerBuiltinHandlers() {
+	c.handlers["ping"] = func(ctx context.Context, _ json.RawMessage) (interface{}, error) {
+		return Result{Message: "pong", Data: map[string]interface{}{
+			"client_info": map[string]interface{}{
+				"id":   c.clientID,
+				"name": c.clientName,
+			},
+			"server_time": time.Now().Unix(),
+			"latency":     "< 1ms",
+		}}, nil
+	}
+
+	c.handlers["status"] = func(ctx context.Context, _ json.RawMessage) (interface{}, error) {
+		return Result{Message: "客户端状态正常", Data: map[string]interface{}{
+			"client_id":   c.clientID,
+			"client_name": c.clientName,
+			"status":      "online",
+			"uptime":      time.Now().Unix(), // 简化版，实际应该记录启动时间
+			"version":     "1.0.0",
+			"platform":    "Go WebSocket Client",
+		}}, nil
+	}
+
+	// 重启连接：响应发出后关闭连接，触发重连机制
+	c.handlers["restart"] = func(ctx context.Context, _ json.RawMessage) (interface{}, error) {
+		log.Printf("🔄 3秒后重启连接...")
+		go func() {
+			time.Sleep(3 * time.Second)
+			c.conn.Close()
+		}()
+		return Result{Message: "即将重启连接", Data: map[string]interface{}{
+			"restart_in": "3 seconds",
+		}}, nil
+	}
+
+	// 运维集中重命名，之后重连时使用新名称注册
+	c.handlers["rename"] = Typed(func(ctx context.Context, data struct {
+		Name string `json:"name"`
+	}) (interface{}, error) {
+		if data.Name == "" {
+			return nil, errors.New("缺少新名称")
+		}
+		log.Printf("✏️ 客户端名称已由服务器修改: %s -> %s", c.clientName, data.Name)
+		c.clientName = data.Name
+		return Result{Message: "名称已更新", Data: map[string]interface{}{
+			"client_id":   c.clientID,
+			"client_name": c.clientName,
+		}}, nil
+	})
+
+	c.handlers["info"] = func(ctx context.Context, _ json.RawMessage) (interface{}, error) {
+		return Result{Message: "客户端信息", Data: map[string]interface{}{
+			"client_id":    c.clientID,
+			"client_name":  c.clientName,
+			"proxy_url":    c.proxyURL,
+			"server_url":   c.serverURL,
+			"connected":    c.conn != nil,
+			"timestamp":    time.Now().Unix(),
+			"capabilities": c.Commands(),
+		}}, nil
+	}
+
+	// 回显数据
+	c.handlers["echo"] = Typed(func(ctx context.Context, data interface{}) (interface{}, error) {
+		return Result{Message: "echo响应", Data: map[string]interface{}{
+			"original_data": data,
+			"echo_time":     time.Now().Unix(),
+		}}, nil
+	})
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+)
+
+// heartbeatInterval 当前的心跳间隔：Options指定的间隔优先，其次为服务端在 heartbeat_ack 中告知的间隔
+func (c *Client) heartbeatInterval() time.Duration {
+	if c.heartbeatEvery > 0 {
+		return c.heartbeatEvery
+	}
+	if interval := time.Duration(c.serverHeartbeat.Load()); interval > 0 {
+		return interval
+	}
+	return protocol.DefaultHeartbeatInterval
+}
+
+// heartbeatLoop 注册后立即发送一次心跳（服务端据此改为按心跳判定在线状态，并在确认中告知间隔），
+// 之后定期发送，连接被替换（重连）或写入失败时退出
+func (c *Client) heartbeatLoop(conn *websocket.Conn) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			c.writeMu.Lock()
+			current := c.conn
+			c.writeMu.Unlock()
+			if current != conn {
+				return
+			}
+			if err := c.sendHeartbeat(); err != nil {
+				return
+			}
+			timer.Reset(c.heartbeatInterval())
+		case <-c.heartbeatChanged:
+			// 服务端告知了新的间隔，按新间隔重新计时
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(c.heartbeatInterval())
+		}
+	}
+}
+
+// sendHeartbeat 发送一次心跳，带上当前的状态
+func (c *Client) sendHeartbeat() error {
+	msg := map[string]interface{}{
+		"type":      protocol.TypeHeartbeat,
+		"timestamp": time.Now().Unix(),
+	}
+	if status, _ := c.status.Load().(string); status != "" {
+		msg["status"] = status
+	}
+	return c.writeJSON(msg)
+}
+
+// handleHeartbeatAck 未指定心跳间隔时采用服务端期望的间隔
+func (c *Client) handleHeartbeatAck(msg map[string]interface{}) {
+	seconds := toFloat(msg["interval"])
+	if c.heartbeatEvery > 0 || seconds <= 0 {
+		return
+	}
+	interval := time.Duration(seconds * float64(time.Second))
+	if old := time.Duration(c.serverHeartbeat.Swap(int64(interval))); old != interval {
+		log.Printf("💓 按服务端要求每 %v 发送一次心跳", interval)
+		select {
+		case c.heartbeatChanged <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// SetStatus 设置在心跳中上报的状态（online 或 busy），并立即发送一次心跳使服务端尽快更新
+func (c *Client) SetStatus(status string) error {
+	c.status.Store(status)
+	return c.sendHeartbeat()
+}
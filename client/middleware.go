@@ -0,0 +1,30 @@
+package client
+
+import (
+	"log"
+
+	"websocket-loadbalance/protocol"
+)
+
+// Use 添加消息中间件（需在连接之前调用），用于加解密、指标、日志等横切逻辑，与服务端的 Server.Use 对称：
+// 出站消息按添加顺序经过中间件后写出，入站消息按相反顺序经过后交给消息处理和Call。
+// 两端以相同顺序添加同一组中间件即可互通，如最后添加的中间件负责加解密，之前的中间件看到的都是明文
+func (c *Client) Use(middleware ...protocol.Middleware) {
+	c.middleware = append(c.middleware, middleware...)
+}
+
+// inbound 让收到的消息经过中间件，中间件丢弃消息或返回错误时ok为false
+func (c *Client) inbound(data []byte) (result []byte, ok bool) {
+	if len(c.middleware) == 0 {
+		return data, true
+	}
+	err := protocol.Chain(protocol.DirectionInbound, func(f *protocol.Frame) error {
+		result, ok = f.Data, true
+		return nil
+	}, c.middleware)(&protocol.Frame{Direction: protocol.DirectionInbound, ClientID: c.clientID, Data: data})
+	if err != nil {
+		log.Printf("收到的消息被中间件拒绝: %v", err)
+		return nil, false
+	}
+	return result, ok
+}
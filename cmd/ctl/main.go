@@ -0,0 +1,235 @@
+// ctl 负载均衡器的命令行管理工具，通过管理API声明式地维护集群状态
+//
+//	ctl get                        输出负载均衡器当前的集群状态(YAML)
+//	ctl plan  -f cluster.yaml      显示将要执行的变更
+//	ctl apply -f cluster.yaml      显示变更计划，确认后执行
+//	ctl clients|backends|sessions|stats|status [-o table|json|yaml]  列出客户端、后端、会话、集群统计或健康状态
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/pkg/adminclient"
+)
+
+const usage = `用法:
+  ctl get   [-lb URL] [-o yaml|json]
+  ctl plan  -f cluster.yaml [-lb URL]
+  ctl apply -f cluster.yaml [-lb URL] [-auto-approve]
+  ctl clients  [-o table|json|yaml]   所有节点上的客户端
+  ctl backends [-o table|json|yaml]   后端及健康状态
+  ctl sessions [-o table|json|yaml] [-backend ID]   会话保持记录
+  ctl stats    [-o table|json|yaml]   集群统计
+  ctl status   [-o table|json|yaml]   集群健康状态和健康分数
+
+json和yaml输出管理API的响应本身，字段名稳定，可直接交给jq或脚本处理；table仅供阅读
+
+通用参数:
+  -lb      负载均衡器地址，默认 http://localhost:8080（也可通过环境变量 WSLB_ADDR 设置）
+  -token   管理API令牌（也可通过环境变量 WSLB_TOKEN 设置）
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	command := os.Args[1]
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	address := fs.String("lb", envOr("WSLB_ADDR", "http://localhost:8080"), "负载均衡器地址")
+	token := fs.String("token", os.Getenv("WSLB_TOKEN"), "管理API令牌")
+	file := fs.String("f", "", "期望状态文件 (YAML/JSON)")
+	output := fs.String("o", "", "输出格式: get 为 yaml(默认) 或 json，列表命令为 table(默认)、json 或 yaml")
+	backendID := fs.String("backend", "", "sessions 只列出绑定到该后端的会话")
+	autoApprove := fs.Bool("auto-approve", false, "apply 时不询问确认")
+	fs.Parse(os.Args[2:])
+
+	client := adminclient.New(*address, adminclient.Options{Token: *token})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var err error
+	switch command {
+	case "get":
+		if *output == "" {
+			*output = outputYAML
+		}
+		if *output != outputYAML && *output != outputJSON {
+			err = fmt.Errorf("get 不支持输出格式 %q (可选: yaml, json)", *output)
+			break
+		}
+		err = runGet(ctx, client, *output)
+	case "clients", "backends", "sessions", "stats", "status":
+		if *output == "" {
+			*output = outputTable
+		}
+		if *output != outputTable && *output != outputJSON && *output != outputYAML {
+			err = fmt.Errorf("%s 不支持输出格式 %q (可选: table, json, yaml)", command, *output)
+			break
+		}
+		err = runList(ctx, client, command, *output, *backendID)
+	case "plan", "apply":
+		if *file == "" {
+			err = fmt.Errorf("%s 需要 -f 指定期望状态文件", command)
+			break
+		}
+		err = runPlanApply(ctx, client, *address, *file, command == "apply", *autoApprove)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runGet 输出当前状态，可直接作为 apply 的期望状态文件
+func runGet(ctx context.Context, client *adminclient.Client, output string) error {
+	state, err := client.ClusterState(ctx)
+	if err != nil {
+		return err
+	}
+	if output == outputJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(state)
+	}
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	return encoder.Encode(state)
+}
+
+// runPlanApply 比较期望状态和当前状态，打印变更计划；apply时确认后按顺序执行
+func runPlanApply(ctx context.Context, client *adminclient.Client, address, path string, apply, autoApprove bool) error {
+	desired, err := loadClusterFile(path)
+	if err != nil {
+		return err
+	}
+	current, err := client.ClusterState(ctx)
+	if err != nil {
+		return fmt.Errorf("获取当前状态失败: %w", err)
+	}
+
+	changes := adminclient.PlanCluster(*current, *desired)
+	if len(changes) == 0 {
+		fmt.Printf("负载均衡器 %s 已与 %s 一致，无需变更。\n", address, path)
+		return nil
+	}
+	printPlan(address, changes)
+	if !apply {
+		return nil
+	}
+
+	if !autoApprove {
+		fmt.Print("\n是否执行以上变更？只有输入 yes 才会执行: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(answer) != "yes" {
+			fmt.Println("已取消。")
+			return nil
+		}
+	}
+
+	fmt.Println()
+	applied, err := client.ApplyCluster(ctx, changes, func(change adminclient.Change) {
+		fmt.Printf("%s %s: 完成\n", actionSymbol(change.Action), change)
+	})
+	if err != nil {
+		return fmt.Errorf("已执行 %d/%d 项变更，%w", applied, len(changes), err)
+	}
+	fmt.Printf("\n已执行 %d 项变更。\n", applied)
+	return nil
+}
+
+// loadClusterFile 读取期望状态文件，拒绝未知字段以便发现拼写错误
+func loadClusterFile(path string) (*lb.ClusterState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取 %s 失败: %v", path, err)
+	}
+
+	var state lb.ClusterState
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&state)
+	default:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&state)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %v", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for _, backend := range state.Backends {
+		if backend.ID == "" || (backend.Port <= 0 && backend.Socket == "") {
+			return nil, fmt.Errorf("后端配置无效: id=%q port=%d", backend.ID, backend.Port)
+		}
+		if seen[backend.ID] {
+			return nil, fmt.Errorf("后端ID重复: %s", backend.ID)
+		}
+		seen[backend.ID] = true
+	}
+	seen = make(map[string]bool)
+	for _, pool := range state.Pools {
+		if pool.Name == "" || pool.Name == lb.DefaultPoolName || seen[pool.Name] {
+			return nil, fmt.Errorf("后端池名称为空、重复或为 %s: %q", lb.DefaultPoolName, pool.Name)
+		}
+		seen[pool.Name] = true
+	}
+	if state.ACL != nil {
+		if err := state.ACL.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &state, nil
+}
+
+// printPlan 按 Terraform 的风格打印变更计划
+func printPlan(address string, changes []adminclient.Change) {
+	fmt.Printf("负载均衡器 %s 的变更计划：\n\n", address)
+	counts := make(map[string]int)
+	for _, change := range changes {
+		counts[change.Action]++
+		fmt.Printf("  %s %s\n", actionSymbol(change.Action), change)
+		for _, detail := range change.Details {
+			fmt.Printf("      %s\n", detail)
+		}
+	}
+	fmt.Printf("\n计划: 新增 %d, 修改 %d, 删除 %d\n",
+		counts[adminclient.ActionCreate], counts[adminclient.ActionUpdate], counts[adminclient.ActionDelete])
+}
+
+func actionSymbol(action string) string {
+	switch action {
+	case adminclient.ActionCreate:
+		return "+"
+	case adminclient.ActionDelete:
+		return "-"
+	}
+	return "~"
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/pkg/adminclient"
+)
+
+// 输出格式
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// writeOutput 按格式输出查询结果。json和yaml输出API响应本身，字段名与管理API一致，
+// 便于用jq或脚本处理；table输出便于阅读的表格，列可能随版本调整，不应用于脚本
+func writeOutput(format string, value interface{}, table func(w io.Writer)) error {
+	switch format {
+	case outputJSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case outputYAML:
+		return writeYAML(value)
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		table(w)
+		return w.Flush()
+	}
+}
+
+// writeYAML 以JSON字段名输出YAML：API类型只有json标签，先编码为JSON再转换为YAML，字段顺序保持不变
+func writeYAML(value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return err
+	}
+	clearStyle(&node)
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	return encoder.Encode(&node)
+}
+
+// clearStyle 去掉从JSON解析得到的流式和引号样式，输出块样式的YAML
+func clearStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		clearStyle(child)
+	}
+}
+
+// runList 列出负载均衡器的客户端、后端、会话、集群统计或健康状态，列表按ID排序使输出稳定
+func runList(ctx context.Context, client *adminclient.Client, command, output, backendID string) error {
+	switch command {
+	case "clients":
+		list, err := client.AllClients(ctx)
+		if err != nil {
+			return err
+		}
+		sort.Slice(list.Clients, func(i, j int) bool { return list.Clients[i].ID < list.Clients[j].ID })
+		return writeOutput(output, list, func(w io.Writer) {
+			fmt.Fprintln(w, "ID\tNAME\tNAMESPACE\tNODE\tSTATUS\tCONNECTED\tLAST_SEEN")
+			for _, c := range list.Clients {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", c.ID, c.Name, orDash(c.Namespace), c.NodeID,
+					orDash(c.Status), formatTime(c.ConnTime), formatTime(c.LastSeen))
+			}
+		})
+	case "backends":
+		list, err := client.Backends(ctx)
+		if err != nil {
+			return err
+		}
+		sort.Slice(list.Backends, func(i, j int) bool { return list.Backends[i].ID < list.Backends[j].ID })
+		return writeOutput(output, list, func(w io.Writer) {
+			fmt.Fprintln(w, "ID\tADDRESS\tSTATE\tCONNECTIONS\tCLIENTS\tWEIGHT\tLAST_CHECK")
+			for _, b := range list.Backends {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%s\n", b.ID, b.Address, backendState(b),
+					b.Connections, b.ReportedClients, b.Weight, orDash(b.LastCheck))
+			}
+		})
+	case "sessions":
+		list, err := client.Sessions(ctx, backendID)
+		if err != nil {
+			return err
+		}
+		return writeOutput(output, list, func(w io.Writer) {
+			fmt.Fprintln(w, "SESSION_ID\tBACKEND\tCLIENT_IP\tCONNECTIONS\tAGE\tLAST_SEEN")
+			for _, s := range list.Sessions {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", s.SessionID, s.BackendID, orDash(s.ClientIP),
+					s.Connections, time.Duration(s.AgeSeconds)*time.Second, formatTime(s.LastSeen))
+			}
+		})
+	case "status":
+		status, err := client.ClusterStatus(ctx)
+		if err != nil {
+			return err
+		}
+		return writeOutput(output, status, func(w io.Writer) {
+			b, c, r := status.Components.Backends, status.Components.Connections, status.Components.Registry
+			fmt.Fprintln(w, "COMPONENT	STATUS	DETAIL")
+			fmt.Fprintf(w, "backends	%s	%d/%d healthy, %d in maintenance\n", b.Status, b.Healthy, b.Total, b.InMaintenance)
+			fmt.Fprintf(w, "connections	%s	%.2f%% errors (%d/%d in %.0fs)\n", c.Status, c.ErrorRate*100, c.Failures, c.Attempts, c.WindowSeconds)
+			fmt.Fprintf(w, "registry	%s	%d registered, %d reported, lag %.1fs\n", r.Status, r.RegisteredClients, r.ReportedClients, r.LagSeconds)
+			fmt.Fprintf(w, "CLUSTER	%s	score %.1f since %s\n", status.Status, status.Score, formatTime(status.Since))
+		})
+	default: // stats
+		stats, err := client.ClusterStats(ctx)
+		if err != nil {
+			return err
+		}
+		return writeOutput(output, stats, func(w io.Writer) { writeStatsTable(w, stats) })
+	}
+}
+
+// writeStatsTable 每个节点一行，最后一行为汇总
+func writeStatsTable(w io.Writer, stats *lb.ClusterStats) {
+	ids := make([]string, 0, len(stats.Nodes))
+	for id := range stats.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	fmt.Fprintln(w, "NODE\tCLIENTS\tLB_CONNS\tRECV/S\tSENT/S\tUPTIME\tHEAP\tERROR")
+	for _, id := range ids {
+		node := stats.Nodes[id]
+		if node.Error != "" {
+			fmt.Fprintf(w, "%s\t-\t%d\t-\t-\t-\t-\t%s\n", id, node.Connections, node.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\t%.1f\t%s\t%s\t-\n", id, node.Clients, node.Connections,
+			node.Throughput.ReceivedPerSec, node.Throughput.SentPerSec,
+			formatUptime(node.UptimeSeconds), formatBytes(node.Memory.HeapAlloc))
+	}
+	fmt.Fprintf(w, "TOTAL (%d/%d)\t%d\t-\t%.1f\t%.1f\t-\t%s\t-\n", stats.NodesQueried, stats.NodesTotal,
+		stats.Clients, stats.Throughput.ReceivedPerSec, stats.Throughput.SentPerSec, formatBytes(stats.HeapAlloc))
+}
+
+// backendState 后端的综合状态，维护和排空优先于健康状态
+func backendState(b adminclient.Backend) string {
+	switch {
+	case b.InMaintenance:
+		return "maintenance"
+	case b.Draining:
+		return "draining"
+	case b.HoldDown:
+		return "hold_down"
+	case b.IsHealthy:
+		return "healthy"
+	}
+	return "unhealthy"
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+func formatUptime(seconds int64) string {
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+func formatBytes(n uint64) string {
+	return fmt.Sprintf("%.1fMiB", float64(n)/(1<<20))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"websocket-loadbalance/auth"
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/logging"
+	"websocket-loadbalance/origin"
+	"websocket-loadbalance/perf"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+	"websocket-loadbalance/secrets"
+	"websocket-loadbalance/server"
+	"websocket-loadbalance/tracing"
+)
+
+// Config 系统配置，可从YAML/JSON文件加载
+type Config struct {
+	RegistryFile           string            `json:"registry_file" yaml:"registry_file"`
+	RegistryFlushInterval  protocol.Duration `json:"registry_flush_interval" yaml:"registry_flush_interval"`   // 客户端记录的写回间隔，间隔内的修改合并为一次写文件
+	RegistryBackups        int               `json:"registry_backups" yaml:"registry_backups"`                 // 注册表文件保留的备份数，文件损坏时从最新的可用备份恢复，0表示不备份
+	RegistryBackupInterval protocol.Duration `json:"registry_backup_interval" yaml:"registry_backup_interval"` // 两次备份的最小间隔
+	RegistryRetention      protocol.Duration `json:"registry_retention" yaml:"registry_retention"`             // 加载时丢弃超过该时长没有活动的客户端记录，0表示全部保留
+	DrainTimeout           protocol.Duration `json:"drain_timeout" yaml:"drain_timeout"`                       // 优雅关闭排空超时
+	LogLevel               string            `json:"log_level" yaml:"log_level"`                               // 日志级别: info(默认) 或 debug
+	Performance            perf.Config       `json:"performance" yaml:"performance"`                           // 性能调优
+	Auth                   auth.Config       `json:"auth" yaml:"auth"`                                         // WebSocket握手和注册的客户端认证
+	Origins                origin.Config     `json:"origins" yaml:"origins"`                                   // 浏览器来源白名单和管理API的CORS
+	Secrets                secrets.Config    `json:"secrets" yaml:"secrets"`                                   // 从环境变量、文件或Vault读取敏感配置
+	Tracing                tracing.Config    `json:"tracing" yaml:"tracing"`                                   // 分布式追踪，通过OTLP/HTTP导出span
+	LoadBalancer           lb.Config         `json:"loadbalancer" yaml:"loadbalancer"`
+	Server                 server.Config     `json:"server" yaml:"server"`
+}
+
+// DefaultConfig 返回与原硬编码部署一致的默认配置
+func DefaultConfig() *Config {
+	return &Config{
+		RegistryFile:           "global_clients.json",
+		RegistryFlushInterval:  protocol.Duration(registry.DefaultFlushInterval),
+		RegistryBackups:        registry.DefaultBackupCount,
+		RegistryBackupInterval: protocol.Duration(registry.DefaultBackupInterval),
+		DrainTimeout:           protocol.Duration(10 * time.Second),
+		LoadBalancer:           lb.DefaultConfig(),
+		Server:                 server.DefaultConfig(),
+	}
+}
+
+// LoadConfig 从文件加载配置，未填写的字段保留默认值
+// 根据扩展名选择格式：.yaml/.yml 为YAML，其余按JSON解析。
+// 未知的配置项和取值无效的枚举项（如拼错的策略名）按行号报告，不会被静默忽略
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+	if err := checkSchema(path, data); err != nil {
+		return nil, err
+	}
+
+	cfg := DefaultConfig()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	default:
+		err = json.Unmarshal(data, cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析配置文件 %s 失败: %v", path, err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate 校验配置的基本合法性
+func (c *Config) Validate() error {
+	if err := logging.Validate(c.LogLevel); err != nil {
+		return err
+	}
+	if c.RegistryFlushInterval < 0 {
+		return fmt.Errorf("registry_flush_interval 不能为负数")
+	}
+	if c.RegistryBackups < 0 || c.RegistryBackupInterval < 0 || c.RegistryRetention < 0 {
+		return fmt.Errorf("registry_backups、registry_backup_interval 和 registry_retention 不能为负数")
+	}
+	if err := c.LoadBalancer.Validate(); err != nil {
+		return err
+	}
+	if err := c.Server.Validate(); err != nil {
+		return err
+	}
+	if _, err := c.Performance.Resolve(); err != nil {
+		return err
+	}
+	if err := c.Auth.Validate(); err != nil {
+		return err
+	}
+	if err := c.Auth.ValidateProvider(c.LoadBalancer.AuthProvider); err != nil {
+		return fmt.Errorf("loadbalancer.auth_provider: %v", err)
+	}
+	if err := c.Auth.ValidateProvider(c.Server.AuthProvider); err != nil {
+		return fmt.Errorf("server.auth_provider: %v", err)
+	}
+	if err := c.Origins.Validate(); err != nil {
+		return err
+	}
+	if err := c.Secrets.Validate(); err != nil {
+		return err
+	}
+	if err := c.Tracing.Validate(); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"websocket-loadbalance/auth"
+	"websocket-loadbalance/client"
+	"websocket-loadbalance/origin"
+	"websocket-loadbalance/perf"
+	"websocket-loadbalance/pkg/adminclient"
+	"websocket-loadbalance/protocol"
+)
+
+// 开发模式各组件日志前缀的颜色（ANSI），节点和模拟客户端依次取用
+const (
+	colorReset = "\033[0m"
+	colorGray  = "\033[90m"
+	colorLB    = "\033[1;36m"
+)
+
+var devColors = []string{"\033[32m", "\033[33m", "\033[35m", "\033[34m", "\033[92m", "\033[93m", "\033[95m", "\033[94m"}
+
+// runDev 开发模式（-service=dev）：在一个进程中启动负载均衡器、若干服务端节点和模拟客户端，
+// 节点自注册到负载均衡器，客户端注册表只保存在内存中。为便于上手，不启用客户端认证
+func runDev(cfg *Config, perfSettings perf.Settings, origins *origin.Policy, clientCount int) {
+	if cfg.Auth.Enabled || cfg.LoadBalancer.AuthProvider != "" || cfg.Server.AuthProvider != "" {
+		log.Printf("开发模式不启用客户端认证，忽略 auth 配置")
+	}
+	cfg.Auth = auth.Config{}
+	cfg.LoadBalancer.AuthProvider = ""
+	cfg.Server.AuthProvider = ""
+
+	balancer, err := newColocatedLoadBalancer(cfg, perfSettings, origins)
+	if err != nil {
+		log.Fatalf("创建负载均衡器失败: %v", err)
+	}
+	lbAddr := net.JoinHostPort(protocol.LocalHost(cfg.LoadBalancer.ListenAddress, cfg.LoadBalancer.AddressFamily), strconv.Itoa(cfg.LoadBalancer.Port))
+
+	nodes := cfg.Server.MultiNodes()
+	runMultiNodes(cfg.Server, perfSettings, nil, origins, time.Duration(cfg.DrainTimeout), balancer, func() func() {
+		waitForRegistrations("http://"+lbAddr, len(nodes))
+		log.Printf("🚀 开发集群已启动")
+		log.Printf("   客户端接入: ws://%s/ws", lbAddr)
+		log.Printf("   管理界面:   http://%s/admin/", lbAddr)
+		for _, node := range nodes {
+			log.Printf("   节点 %s: http://%s/health", node.ID, net.JoinHostPort("localhost", strconv.Itoa(node.Port)))
+		}
+		log.Printf("   注册表只保存在内存中，退出后清空。按 Ctrl+C 退出")
+		return startSimulatedClients("ws://"+lbAddr+"/ws", clientCount)
+	})
+}
+
+// waitForRegistrations 等待所有节点登记到负载均衡器，避免模拟客户端在没有可用后端时连接失败。
+// 超时后不再等待，客户端会自动重试
+func waitForRegistrations(lbURL string, count int) {
+	admin := adminclient.New(lbURL, adminclient.Options{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		if list, err := admin.Backends(ctx); err == nil {
+			healthy := 0
+			for _, backend := range list.Backends {
+				if backend.IsHealthy {
+					healthy++
+				}
+			}
+			if healthy >= count {
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("等待节点登记到负载均衡器超时")
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// simulatedClientID 第i个模拟客户端的ID（从1开始）
+func simulatedClientID(i int) string {
+	return fmt.Sprintf("sim-client-%d", i)
+}
+
+// startSimulatedClients 启动count个经负载均衡器接入、断开后自动重连的模拟客户端，返回停止所有客户端的函数
+func startSimulatedClients(wsURL string, count int) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 1; i <= count; i++ {
+		c, err := client.New(wsURL, wsURL, simulatedClientID(i), fmt.Sprintf("模拟客户端%d", i))
+		if err != nil {
+			log.Printf("创建模拟客户端失败: %v", err)
+			continue
+		}
+		c.SetOptions(client.Options{Labels: map[string]string{"env": "dev"}})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.RunWithAutoReconnect(ctx)
+		}()
+	}
+	if count > 0 {
+		log.Printf("已启动 %d 个模拟客户端", count)
+	}
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// devLabel 日志中可识别的组件
+type devLabel struct {
+	id    string
+	color string
+}
+
+// devLogWriter 开发模式的日志输出：根据日志所在的包以及其中提到的节点ID、模拟客户端ID，
+// 为每一行加上组件前缀和颜色，如 [lb]、[node2]、[sim-client-3]
+type devLogWriter struct {
+	out   io.Writer
+	color bool
+	nodes []devLabel // 按ID长度降序，避免 node1 先于 node10 匹配
+	sims  []devLabel
+	width int
+	mu    sync.Mutex
+}
+
+// setupDevLogging 为开发模式设置带组件前缀的日志输出。标准错误不是终端或设置了 NO_COLOR 时不输出颜色
+func setupDevLogging(cfg *Config, clientCount int) {
+	w := &devLogWriter{out: os.Stderr, color: isTerminal(os.Stderr) && os.Getenv("NO_COLOR") == "", width: len("[registry]")}
+	for i, node := range cfg.Server.MultiNodes() {
+		w.nodes = append(w.nodes, devLabel{id: node.ID, color: devColors[i%len(devColors)]})
+	}
+	for i := 1; i <= clientCount; i++ {
+		w.sims = append(w.sims, devLabel{id: simulatedClientID(i), color: colorGray})
+	}
+	for _, labels := range [][]devLabel{w.nodes, w.sims} {
+		sort.SliceStable(labels, func(i, j int) bool { return len(labels[i].id) > len(labels[j].id) })
+		for _, label := range labels {
+			if n := len(label.id) + 2; n > w.width {
+				w.width = n
+			}
+		}
+	}
+	log.SetFlags(log.Ltime | log.Llongfile)
+	log.SetOutput(w)
+}
+
+// isTerminal 输出是否为终端
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Write 处理log包输出的一行: "15:04:05 /path/to/pkg/file.go:123: 内容"
+func (w *devLogWriter) Write(p []byte) (int, error) {
+	line := string(bytes.TrimRight(p, "\n"))
+	ts, rest, _ := strings.Cut(line, " ")
+	file, msg, ok := strings.Cut(rest, ": ")
+	if !ok {
+		file, msg = "", rest
+	}
+	label := w.classify(filepath.Base(filepath.Dir(file)), msg)
+
+	prefix := fmt.Sprintf("%-*s", w.width, "["+label.id+"]")
+	if w.color && label.color != "" {
+		prefix = label.color + prefix + colorReset
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := fmt.Fprintf(w.out, "%s %s %s\n", prefix, ts, msg); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// classify 按日志所在的包确定组件，节点和客户端的日志再按其中提到的ID细分
+func (w *devLogWriter) classify(pkg, msg string) devLabel {
+	switch pkg {
+	case "lb":
+		return devLabel{id: "lb", color: colorLB}
+	case "server":
+		if label, ok := findLabel(w.nodes, msg); ok {
+			return label
+		}
+		return devLabel{id: "server"}
+	case "client":
+		if label, ok := findLabel(w.sims, msg); ok {
+			return label
+		}
+		return devLabel{id: "client", color: colorGray}
+	case "registry":
+		return devLabel{id: "registry"}
+	}
+	return devLabel{id: "dev"}
+}
+
+// findLabel 返回消息中提到的ID对应的组件
+func findLabel(labels []devLabel, msg string) (devLabel, bool) {
+	for _, label := range labels {
+		if strings.Contains(msg, label.id) {
+			return label, true
+		}
+	}
+	return devLabel{}, false
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"websocket-loadbalance/auth"
+	"websocket-loadbalance/client"
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/logging"
+	"websocket-loadbalance/origin"
+	"websocket-loadbalance/perf"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+	"websocket-loadbalance/secrets"
+	"websocket-loadbalance/server"
+	"websocket-loadbalance/tracing"
+)
+
+func main() {
+	service := flag.String("service", "server", "服务类型: server(服务端), client(客户端), loadbalancer(负载均衡器), benchmark(性能自测), dev(开发模式，一个进程运行完整集群)")
+	port := flag.Int("port", 8081, "服务器端口")
+	nodeID := flag.String("node", "node1", "节点ID")
+	mode := flag.String("mode", "single", "运行模式: single(单节点) 或 multi(多节点)")
+	nodeCount := flag.Int("nodes", 0, "多节点模式的节点数 (可选)，覆盖配置中的节点列表")
+	basePort := flag.Int("base-port", 8081, "多节点模式第一个节点的端口，其余节点依次加1")
+	nodeIDs := flag.String("node-ids", "", "多节点模式各节点的ID，逗号分隔 (可选)，默认为 node1、node2...")
+	withLB := flag.Bool("with-lb", false, "多节点模式同时启动负载均衡器，节点自动向其注册")
+	simClients := flag.Int("clients", 0, "开发模式启动的模拟客户端数")
+	strategy := flag.String("strategy", "round_robin", "负载均衡策略: round_robin, least_conn, ip_hash, consistent_hash, ketama, affinity")
+	clientName := flag.String("name", "", "客户端名称")
+	clientID := flag.String("id", "", "客户端ID (可选)")
+	clientType := flag.String("client-type", "", "客户端类型 (可选)，负载均衡器可按类型关闭会话保持")
+	topics := flag.String("topics", "", "客户端连接后订阅的主题，逗号分隔 (可选)")
+	namespace := flag.String("namespace", "", "客户端所属的命名空间 (可选)，默认为 default")
+	labels := flag.String("labels", "", "客户端注册时声明的标签 (可选)，如 env=prod,role=pos")
+	affinity := flag.String("affinity", "", "客户端的节点亲和性 (可选)，节点标签的选择器，如 region=eu,gpu；负载均衡器使用 affinity 策略时优先选择满足条件的节点")
+	tokenURL := flag.String("token-url", "", "客户端每次连接前换取一次性连接令牌的地址 (可选)，如 http://localhost:8080/api/token")
+	encoding := flag.String("encoding", "json", "客户端消息编码: json, msgpack, protobuf")
+	protocolVersion := flag.Int("protocol-version", 0, "客户端握手时声明的协议版本 (可选)，0表示不声明")
+	heartbeatInterval := flag.Duration("heartbeat-interval", 0, "客户端心跳间隔，0表示采用服务端要求的间隔")
+	loadbalancerURL := flag.String("loadbalancer", "ws://localhost:8080/ws", "客户端连接的负载均衡器地址")
+	serverURL := flag.String("server", "ws://localhost:8080/ws", "客户端的服务端地址")
+	listen := flag.String("listen", "", "监听地址 host:port (可选)，如 [::]:8080、0.0.0.0:8081、[::1]:8081，主机为空表示所有地址；指定时忽略 -port（服务端和负载均衡器）")
+	backends := flag.String("backends", "", "负载均衡器的后端，逗号分隔的 host:port 或 id=host:port (可选)，如 node1=10.0.0.5:8081,[2001:db8::6]:8081，ID默认为 node1、node2...；覆盖配置中的后端")
+	socket := flag.String("socket", "", "同时监听的Unix域套接字，如 unix:///run/ws/node1.sock（服务端单节点模式和负载均衡器）")
+	configPath := flag.String("config", "", "配置文件路径 (YAML/JSON)")
+	drainTimeout := flag.Duration("drain-timeout", 10*time.Second, "优雅关闭时等待连接排空的超时时间")
+	connMode := flag.String("conn-mode", "gorilla", "服务端连接处理模式: gorilla, epoll(实验性，仅Linux)")
+	profile := flag.String("profile", "", "性能profile: default, low-latency, high-throughput, low-memory")
+	compression := flag.Bool("compression", false, "协商permessage-deflate压缩（覆盖performance.compression）")
+	benchConns := flag.Int("bench-conns", 1000, "性能自测的并发连接数")
+	benchDuration := flag.Duration("bench-duration", 5*time.Second, "性能自测的消息收发时长")
+	flag.Parse()
+
+	// 加载配置文件，命令行显式指定的参数优先。负载均衡器重新加载配置时同样处理
+	loadConfig := func() (*Config, error) {
+		cfg := DefaultConfig()
+		if *configPath != "" {
+			loaded, err := LoadConfig(*configPath)
+			if err != nil {
+				return nil, err
+			}
+			cfg = loaded
+		}
+		var flagErr error
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "port":
+				if *listen == "" {
+					cfg.Server.Port = *port
+					cfg.LoadBalancer.Port = *port
+				}
+			case "listen":
+				host, listenPort, err := protocol.SplitHostPort(*listen)
+				if err != nil {
+					flagErr = fmt.Errorf("-listen: %v", err)
+					return
+				}
+				cfg.Server.ListenAddress, cfg.Server.Port = host, listenPort
+				cfg.LoadBalancer.ListenAddress, cfg.LoadBalancer.Port = host, listenPort
+			case "backends":
+				parsed, err := parseBackends(*backends)
+				if err != nil {
+					flagErr = fmt.Errorf("-backends: %v", err)
+					return
+				}
+				cfg.LoadBalancer.Backends = parsed
+			case "node":
+				cfg.Server.NodeID = *nodeID
+			case "nodes":
+				cfg.Server.NodeSet.Count = *nodeCount
+			case "base-port":
+				cfg.Server.NodeSet.BasePort = *basePort
+			case "node-ids":
+				cfg.Server.NodeSet.IDs = splitList(*nodeIDs)
+			case "socket":
+				cfg.Server.Socket = *socket
+				cfg.LoadBalancer.Socket = *socket
+			case "strategy":
+				cfg.LoadBalancer.Strategy = lb.Strategy(*strategy)
+			case "drain-timeout":
+				cfg.DrainTimeout = protocol.Duration(*drainTimeout)
+			case "conn-mode":
+				cfg.Server.ConnMode = *connMode
+			case "profile":
+				cfg.Performance.Profile = *profile
+			case "compression":
+				cfg.Performance.Compression = compression
+			}
+		})
+		if flagErr != nil {
+			return nil, flagErr
+		}
+		// 命令行参数覆盖后再次校验，无效的 -strategy 等参数在启动时报错而不是退回默认行为
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+		return cfg, nil
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("加载配置失败: %v", err)
+	}
+	if *configPath != "" {
+		log.Printf("已加载配置文件: %s", *configPath)
+	}
+	logging.SetLevel(cfg.LogLevel)
+
+	// 应用性能profile
+	perfSettings, err := cfg.Performance.Resolve()
+	if err != nil {
+		log.Fatalf("性能配置错误: %v", err)
+	}
+	perfSettings.ApplyRuntime()
+
+	// 密钥管理，需在读取密钥的组件创建之前初始化
+	if err := secrets.Init(cfg.Secrets); err != nil {
+		log.Fatalf("密钥管理配置错误: %v", err)
+	}
+
+	// 分布式追踪，服务名默认为 websocket-<service>
+	if err := tracing.Init(cfg.Tracing, "websocket-"+*service); err != nil {
+		log.Fatalf("追踪配置错误: %v", err)
+	}
+
+	// 来源白名单和CORS，未启用时origins为nil
+	origins, err := origin.New(cfg.Origins)
+	if err != nil {
+		log.Fatalf("来源配置错误: %v", err)
+	}
+
+	// 初始化全局客户端注册表，开发模式只保存在内存中
+	registry.SetFlushInterval(time.Duration(cfg.RegistryFlushInterval))
+	registry.SetBackups(cfg.RegistryBackups, time.Duration(cfg.RegistryBackupInterval))
+	registry.SetRetention(time.Duration(cfg.RegistryRetention))
+	if *service == "dev" {
+		setupDevLogging(cfg, *simClients)
+		registry.Init("")
+	} else {
+		registry.Init(cfg.RegistryFile)
+	}
+
+	switch *service {
+	case "server":
+		// 客户端认证，认证方式为none时provider为nil
+		provider, err := auth.NewProvider(cfg.Auth, cfg.Server.AuthProvider)
+		if err != nil {
+			log.Fatalf("认证配置错误: %v", err)
+		}
+		switch *mode {
+		case "single":
+			runSingleNode(cfg.Server, perfSettings, provider, origins, time.Duration(cfg.DrainTimeout))
+		case "multi":
+			var balancer *lb.LoadBalancer
+			if *withLB {
+				if balancer, err = newColocatedLoadBalancer(cfg, perfSettings, origins); err != nil {
+					log.Fatalf("创建负载均衡器失败: %v", err)
+				}
+			}
+			runMultiNodes(cfg.Server, perfSettings, provider, origins, time.Duration(cfg.DrainTimeout), balancer, nil)
+		default:
+			fmt.Println("无效的模式。可用模式: single, multi")
+			os.Exit(1)
+		}
+	case "client":
+		if _, err := protocol.CodecFor(*encoding); err != nil {
+			log.Fatal(err)
+		}
+		if _, err := registry.NormalizeNamespace(*namespace); err != nil {
+			log.Fatal(err)
+		}
+		clientLabels, err := parseLabels(*labels)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *affinity != "" {
+			if _, err := registry.ParseSelector(*affinity); err != nil {
+				log.Fatalf("无效的节点亲和性: %v", err)
+			}
+		}
+		client.Run(*loadbalancerURL, *serverURL, *clientID, *clientName, client.Options{
+			Compression:       perfSettings.Compression,
+			CompressionLevel:  perfSettings.CompressionLevel,
+			ClientType:        *clientType,
+			Topics:            splitList(*topics),
+			Namespace:         *namespace,
+			Labels:            clientLabels,
+			Affinity:          *affinity,
+			TokenURL:          *tokenURL,
+			Encoding:          *encoding,
+			ProtocolVersion:   *protocolVersion,
+			HeartbeatInterval: *heartbeatInterval,
+		})
+		flushTraces()
+	case "loadbalancer":
+		provider, err := auth.NewProvider(cfg.Auth, cfg.LoadBalancer.AuthProvider)
+		if err != nil {
+			log.Fatalf("认证配置错误: %v", err)
+		}
+		// 未指定配置文件时不支持重新加载
+		var reload func() (lb.Config, error)
+		if *configPath != "" {
+			reload = func() (lb.Config, error) {
+				loaded, err := loadConfig()
+				if err != nil {
+					return lb.Config{}, err
+				}
+				if old, _ := logging.SetLevel(loaded.LogLevel); old != logging.Level() {
+					log.Printf("日志级别: %s -> %s", old, logging.Level())
+				}
+				// 重新读取密钥引用，值变化的密钥立即轮换
+				if changed := secrets.Refresh(); len(changed) > 0 {
+					log.Printf("已轮换 %d 个密钥", len(changed))
+				}
+				return loaded.LoadBalancer, nil
+			}
+		}
+		runLoadBalancer(cfg.LoadBalancer, perfSettings, provider, origins, time.Duration(cfg.DrainTimeout), reload)
+	case "benchmark":
+		perf.RunBenchmark(perfSettings, *benchConns, *benchDuration)
+	case "dev":
+		runDev(cfg, perfSettings, origins, *simClients)
+	default:
+		fmt.Println("无效的服务类型。可用类型: server, client, loadbalancer, benchmark, dev")
+		fmt.Println("使用示例:")
+		fmt.Println("  负载均衡器: go run ./cmd/websocket-system -service=loadbalancer -port=8080 -strategy=round_robin")
+		fmt.Println("  服务端: go run ./cmd/websocket-system -service=server -mode=single -port=8081 -node=node1")
+		fmt.Println("  客户端: go run ./cmd/websocket-system -service=client -loadbalancer=ws://localhost:8080/ws -name=我的客户端")
+		fmt.Println("  使用配置文件: go run ./cmd/websocket-system -service=loadbalancer -config=config.yaml")
+		fmt.Println("  性能自测: go run ./cmd/websocket-system -service=benchmark -profile=high-throughput -bench-conns=5000")
+		fmt.Println("  开发模式: go run ./cmd/websocket-system -service=dev -nodes=3 -clients=5")
+		os.Exit(1)
+	}
+}
+
+// 拆分逗号分隔的命令行参数，忽略空项
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseLabels 解析 key=value 形式、逗号分隔的标签
+func parseLabels(value string) (map[string]string, error) {
+	items := splitList(value)
+	if len(items) == 0 {
+		return nil, nil
+	}
+	raw := make(map[string]interface{}, len(items))
+	for _, item := range items {
+		key, val, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("无效的标签 %q，应为 key=value", item)
+		}
+		raw[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return registry.ParseLabels(raw)
+}
+
+// parseBackends 解析逗号分隔的后端地址，每项为 host:port 或 id=host:port，未指定ID时按顺序命名为 node1、node2...
+func parseBackends(value string) ([]lb.BackendConfig, error) {
+	var backends []lb.BackendConfig
+	for i, item := range splitList(value) {
+		id, address, ok := strings.Cut(item, "=")
+		if !ok {
+			id, address = fmt.Sprintf("node%d", i+1), item
+		}
+		if _, _, err := protocol.SplitHostPort(address); err != nil {
+			return nil, err
+		}
+		backends = append(backends, lb.BackendConfig{ID: strings.TrimSpace(id), Address: strings.TrimSpace(address)})
+	}
+	return backends, nil
+}
+
+// 等待中断信号
+func notifyShutdown() <-chan os.Signal {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	return c
+}
+
+// 运行单节点
+func runSingleNode(cfg server.Config, perfSettings perf.Settings, provider auth.Provider, origins *origin.Policy, drainTimeout time.Duration) {
+	port, nodeID := cfg.Port, cfg.NodeID
+	node := server.NewFromConfig(cfg, perfSettings, port, nodeID)
+	node.SetAuth(provider)
+	node.SetOrigins(origins)
+	node.SetUnixSocket(cfg.Socket)
+
+	log.Printf("启动单节点WebSocket服务器: %s (端口 %d)", nodeID, port)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- node.Start()
+	}()
+
+	select {
+	case err := <-errChan:
+		log.Fatal(err)
+	case <-notifyShutdown():
+	}
+
+	// 优雅关闭
+	log.Printf("正在关闭服务器节点 %s (排空超时 %v)...", nodeID, drainTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := node.Shutdown(ctx); err != nil {
+		log.Printf("服务器节点 %s 关闭出错: %v", nodeID, err)
+	}
+	registry.Flush()
+	flushTraces()
+	log.Printf("服务器节点 %s 已关闭", nodeID)
+}
+
+// nodeExit 多节点模式中某个节点（或同进程的负载均衡器）的 Start 返回
+type nodeExit struct {
+	id  string
+	err error
+}
+
+// 运行多节点（演示用）：各节点是独立的Server实例，使用各自的路由和监听端口。
+// balancer非nil时先启动同进程的负载均衡器，节点启动后向其自注册。
+// 全部节点开始监听后才算启动完成；任一节点启动失败或运行中退出时，
+// 与收到中断信号一样按同一流程关闭所有节点，并以非零状态码退出。
+// onStarted非nil时在全部节点启动后调用，返回的stop在关闭节点之前调用
+func runMultiNodes(cfg server.Config, perfSettings perf.Settings, provider auth.Provider, origins *origin.Policy, drainTimeout time.Duration, balancer *lb.LoadBalancer, onStarted func() (stop func())) {
+	shutdownSignal := notifyShutdown()
+	nodeConfigs := cfg.MultiNodes()
+	exits := make(chan nodeExit, len(nodeConfigs)+1)
+
+	// 等待ready关闭，期间有组件退出或收到中断信号时返回false
+	var failed *nodeExit
+	wait := func(ready <-chan struct{}) bool {
+		select {
+		case <-ready:
+			return true
+		case exit := <-exits:
+			failed = &exit
+		case <-shutdownSignal:
+		}
+		return false
+	}
+
+	started := balancer == nil
+	if balancer != nil {
+		go func() {
+			exits <- nodeExit{id: "负载均衡器", err: balancer.Start()}
+		}()
+		started = wait(balancer.Ready())
+	}
+	nodes := make([]*server.Server, 0, len(nodeConfigs))
+	if started {
+		for _, nodeCfg := range nodeConfigs {
+			node := server.NewFromConfig(cfg, perfSettings, nodeCfg.Port, nodeCfg.ID)
+			node.SetAuth(provider)
+			node.SetOrigins(origins)
+			node.SetUnixSocket(nodeCfg.Socket)
+			nodes = append(nodes, node)
+			log.Printf("启动多节点服务器: %s (端口 %d)", nodeCfg.ID, nodeCfg.Port)
+			go func(node *server.Server) {
+				exits <- nodeExit{id: node.NodeID(), err: node.Start()}
+			}(node)
+		}
+		// 等待所有节点开始监听，期间任一节点失败则不再等待其余节点
+		for _, node := range nodes {
+			if started = wait(node.Ready()); !started {
+				break
+			}
+		}
+	}
+	var stopExtras func()
+	if started {
+		log.Printf("全部 %d 个服务器节点已启动", len(nodes))
+		if onStarted != nil {
+			stopExtras = onStarted()
+		}
+		select {
+		case exit := <-exits:
+			failed = &exit
+		case <-shutdownSignal:
+		}
+	}
+	if stopExtras != nil {
+		stopExtras()
+	}
+	if failed != nil {
+		if failed.err == nil {
+			failed.err = errors.New("服务意外停止")
+		}
+		log.Printf("❌ %s 退出: %v，关闭所有节点", failed.id, failed.err)
+	}
+
+	// 先让所有节点停止接受新连接，再同时排空，被断开的客户端不会重连到同一进程中尚未关闭的节点
+	log.Printf("正在关闭所有服务器节点 (排空超时 %v)...", drainTimeout)
+	for _, node := range nodes {
+		node.StopAccepting()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	shutdownErrs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node *server.Server) {
+			defer wg.Done()
+			shutdownErrs[i] = node.Shutdown(ctx)
+		}(i, node)
+	}
+	wg.Wait()
+	for i, node := range nodes {
+		if err := shutdownErrs[i]; err != nil {
+			log.Printf("服务器节点 %s 关闭出错: %v", node.NodeID(), err)
+		} else {
+			log.Printf("服务器节点 %s 已关闭", node.NodeID())
+		}
+	}
+	// 节点注销后再关闭负载均衡器
+	if balancer != nil {
+		if err := balancer.Shutdown(ctx); err != nil {
+			log.Printf("负载均衡器关闭出错: %v", err)
+		} else {
+			log.Printf("负载均衡器已关闭")
+		}
+	}
+	registry.Flush()
+	flushTraces()
+	log.Println("所有服务器节点已关闭")
+	if failed != nil {
+		os.Exit(1)
+	}
+}
+
+// newColocatedLoadBalancer 创建与多节点同进程运行的负载均衡器：不使用配置中的静态后端，
+// 改为开启自注册，并让各节点启动后向它登记（-with-lb）
+func newColocatedLoadBalancer(cfg *Config, perfSettings perf.Settings, origins *origin.Policy) (*lb.LoadBalancer, error) {
+	lbCfg := cfg.LoadBalancer
+	if lbCfg.TLS.Enabled || lbCfg.Autocert.Enabled || lbCfg.Observer.Enabled {
+		return nil, errors.New("-with-lb 不支持启用了TLS或只读观察者模式的负载均衡器")
+	}
+	if len(lbCfg.Backends) > 0 {
+		log.Printf("同进程的负载均衡器使用自注册的节点，忽略 loadbalancer.backends 中的 %d 个后端", len(lbCfg.Backends))
+	}
+	lbCfg.Backends = nil
+	lbCfg.SelfRegistration.Enabled = true
+
+	provider, err := auth.NewProvider(cfg.Auth, lbCfg.AuthProvider)
+	if err != nil {
+		return nil, fmt.Errorf("认证配置错误: %v", err)
+	}
+	balancer, err := lb.NewFromConfig(lbCfg, perfSettings)
+	if err != nil {
+		return nil, err
+	}
+	balancer.SetAuth(provider)
+	balancer.SetOrigins(origins)
+
+	lbHost := protocol.LocalHost(lbCfg.ListenAddress, lbCfg.AddressFamily)
+	cfg.Server.Registration = server.RegistrationConfig{
+		LoadBalancer:  "http://" + net.JoinHostPort(lbHost, strconv.Itoa(lbCfg.Port)),
+		AdvertiseHost: protocol.LocalHost(cfg.Server.ListenAddress, cfg.Server.AddressFamily),
+		Weight:        cfg.Server.Registration.Weight,
+		Token:         lbCfg.SelfRegistration.Token,
+	}
+	return balancer, nil
+}
+
+// 运行负载均衡器
+// reload非nil时收到SIGHUP重新加载配置
+func runLoadBalancer(cfg lb.Config, perfSettings perf.Settings, provider auth.Provider, origins *origin.Policy, drainTimeout time.Duration, reload func() (lb.Config, error)) {
+	balancer, err := lb.NewFromConfig(cfg, perfSettings)
+	if err != nil {
+		log.Fatal(err)
+	}
+	balancer.SetAuth(provider)
+	balancer.SetOrigins(origins)
+	if reload != nil {
+		balancer.SetConfigSource(reload)
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- balancer.Start()
+	}()
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	shutdown := notifyShutdown()
+	for running := true; running; {
+		select {
+		case err := <-errChan:
+			log.Fatal(err)
+		case <-hangup:
+			log.Printf("收到SIGHUP，重新加载配置")
+			if _, err := balancer.Reload(); err != nil {
+				log.Printf("重新加载配置失败: %v", err)
+			}
+		case <-shutdown:
+			running = false
+		}
+	}
+
+	// 优雅关闭
+	log.Printf("正在关闭负载均衡器 (排空超时 %v)...", drainTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := balancer.Shutdown(ctx); err != nil {
+		log.Printf("负载均衡器关闭出错: %v", err)
+	}
+	registry.Flush()
+	flushTraces()
+	log.Printf("负载均衡器已关闭")
+}
+
+// flushTraces 退出前导出等待中的span
+func flushTraces() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tracing.Flush(ctx)
+}
+
+// 使用说明：
+// 单节点启动: go run ./cmd/websocket-system -mode=single -port=8081 -node=node1
+// 多节点启动: go run ./cmd/websocket-system -mode=multi
+// 配置文件启动: go run ./cmd/websocket-system -service=loadbalancer -config=config.example.yaml
+// 性能自测: go run ./cmd/websocket-system -service=benchmark -profile=low-memory -bench-conns=2000
+// 开发模式: go run ./cmd/websocket-system -service=dev -nodes=3 -clients=5
+//
+// 测试命令:
+// curl http://localhost:8081/health
+// curl http://localhost:8082/health
+// curl http://localhost:8083/health
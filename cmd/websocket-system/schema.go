@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"websocket-loadbalance/auth"
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/logging"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/server"
+)
+
+// enumFields 取值受限的配置项，路径中的 [] 表示列表中的任意一项。空字符串表示可以留空使用默认值
+var enumFields = map[string][]string{
+	"log_level":                          {logging.LevelInfo, logging.LevelDebug},
+	"performance.profile":                {"default", "low-latency", "high-throughput", "low-memory"},
+	"loadbalancer.strategy":              {string(lb.RoundRobin), string(lb.LeastConn), string(lb.IPHash), string(lb.ConsistentHash), string(lb.Ketama)},
+	"loadbalancer.pools[].strategy":      {string(lb.RoundRobin), string(lb.LeastConn), string(lb.IPHash), string(lb.ConsistentHash), string(lb.Ketama)},
+	"loadbalancer.health_check.protocol": {lb.HealthProbeHTTP, lb.HealthProbeWebSocket},
+	"loadbalancer.sessions.store":        {"none", "file", "redis"},
+	"loadbalancer.address_family":        {protocol.AddressFamilyDual, protocol.AddressFamilyIPv4, protocol.AddressFamilyIPv6},
+	"server.address_family":              {protocol.AddressFamilyDual, protocol.AddressFamilyIPv4, protocol.AddressFamilyIPv6},
+	"server.conn_mode":                   {server.ConnModeGorilla, server.ConnModeEpoll},
+	"server.command_log.store":           {"none", "memory", "file"},
+	"auth.provider":                      {auth.ProviderNone, auth.ProviderJWT, auth.ProviderStatic, auth.ProviderHTTP},
+	"loadbalancer.auth_provider":         {auth.ProviderNone, auth.ProviderJWT, auth.ProviderStatic, auth.ProviderHTTP},
+	"server.auth_provider":               {auth.ProviderNone, auth.ProviderJWT, auth.ProviderStatic, auth.ProviderHTTP},
+}
+
+// schemaError 配置文件中某一处的错误
+type schemaError struct {
+	line    int
+	path    string
+	message string
+}
+
+// SchemaErrors 配置文件不符合配置结构的所有错误，按行号排序
+type SchemaErrors struct {
+	file   string
+	errors []schemaError
+}
+
+func (e *SchemaErrors) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "配置文件 %s 有 %d 处错误:", e.file, len(e.errors))
+	for _, err := range e.errors {
+		fmt.Fprintf(&b, "\n  第%d行 %s: %s", err.line, err.path, err.message)
+	}
+	return b.String()
+}
+
+var unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+// checkSchema 按Config的结构检查配置文件：未知的配置项和取值不在可选范围内的配置项，
+// 附带行号和可能的正确写法。JSON是YAML的子集，两种格式使用同一套检查
+func checkSchema(file string, data []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("解析配置文件 %s 失败: %v", file, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	checker := &SchemaErrors{file: file}
+	checker.walk(doc.Content[0], reflect.TypeOf(Config{}), "")
+	if len(checker.errors) == 0 {
+		return nil
+	}
+	sort.SliceStable(checker.errors, func(i, j int) bool { return checker.errors[i].line < checker.errors[j].line })
+	return checker
+}
+
+// walk 检查node是否符合类型t，path为node在配置中的路径
+func (e *SchemaErrors) walk(node *yaml.Node, t reflect.Type, path string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if node.Kind == yaml.AliasNode || isNull(node) {
+		return
+	}
+	if allowed, ok := enumFields[enumPath(path)]; ok && node.Kind == yaml.ScalarNode {
+		e.checkEnum(node, path, allowed)
+		return
+	}
+	// 自定义解析的类型（如时长）由解析器本身校验
+	if reflect.PointerTo(t).Implements(unmarshalerType) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return // 类型不匹配由yaml解析报告
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" {
+				continue // YAML合并键
+			}
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				e.add(key.Line, joinPath(path, key.Value), unknownKeyMessage(key.Value, fields))
+				continue
+			}
+			e.walk(value, fieldType, joinPath(path, key.Value))
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			e.walk(node.Content[i+1], t.Elem(), joinPath(path, node.Content[i].Value))
+		}
+	case reflect.Slice, reflect.Array:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, item := range node.Content {
+			e.walk(item, t.Elem(), path+"["+strconv.Itoa(i)+"]")
+		}
+	}
+}
+
+func (e *SchemaErrors) add(line int, path, message string) {
+	e.errors = append(e.errors, schemaError{line: line, path: path, message: message})
+}
+
+// checkEnum 检查取值受限的配置项
+func (e *SchemaErrors) checkEnum(node *yaml.Node, path string, allowed []string) {
+	if node.Value == "" {
+		return
+	}
+	for _, value := range allowed {
+		if node.Value == value {
+			return
+		}
+	}
+	message := fmt.Sprintf("无效的值 %q (可选: %s)", node.Value, strings.Join(allowed, ", "))
+	if suggestion := closest(node.Value, allowed); suggestion != "" {
+		message += fmt.Sprintf("，是否为 %s？", suggestion)
+	}
+	e.add(node.Line, path, message)
+}
+
+// unknownKeyMessage 未知配置项的错误说明，拼写接近某个已知配置项时给出提示
+func unknownKeyMessage(key string, fields map[string]reflect.Type) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if suggestion := closest(key, names); suggestion != "" {
+		return fmt.Sprintf("未知的配置项，是否为 %s？", suggestion)
+	}
+	return "未知的配置项"
+}
+
+// yamlFields 结构体可以出现在配置文件中的键及其类型，与yaml.v3的规则一致：
+// 键名取yaml标签，没有标签时为小写的字段名，",inline" 的字段展开到外层
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if options == "inline" {
+			for key, fieldType := range yamlFields(field.Type) {
+				fields[key] = fieldType
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+var indexPattern = regexp.MustCompile(`\[\d+\]`)
+
+// enumPath 将路径中的列表下标替换为 []，用于匹配 enumFields
+func enumPath(path string) string {
+	return indexPattern.ReplaceAllString(path, "[]")
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func isNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == "!!null"
+}
+
+// closest 返回与s编辑距离最小的候选项，距离超过2或超过s长度的一半时返回空，避免给出无关的提示
+func closest(s string, candidates []string) string {
+	best, bestDistance := "", 3
+	for _, candidate := range candidates {
+		if d := editDistance(strings.ToLower(s), candidate); d < bestDistance && d <= max(len(s)/2, 1) {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance Levenshtein编辑距离
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
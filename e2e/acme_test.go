@@ -0,0 +1,490 @@
+package e2e
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"websocket-loadbalance/acme"
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/perf"
+	"websocket-loadbalance/protocol"
+)
+
+// fakeACME 测试用的ACME CA：校验JWS签名、nonce和url，按HTTP-01向负载均衡器取回keyAuth后签发证书。
+// 第一个签名请求以 badNonce 拒绝，订单在提交CSR后先处于 processing，检验客户端的重试和轮询
+type fakeACME struct {
+	t        *testing.T
+	server   *httptest.Server
+	caKey    *ecdsa.PrivateKey
+	caCert   *x509.Certificate
+	lifetime time.Duration // 签发证书的有效期
+	httpPort int           // 负载均衡器响应HTTP-01验证的端口
+
+	mu          sync.Mutex
+	nonces      map[string]bool
+	badNonce    bool                        // 下一个签名请求以 badNonce 拒绝
+	accounts    map[string]*ecdsa.PublicKey // 账户URL -> 公钥
+	thumbprints map[string]string           // 公钥指纹 -> 账户URL
+	orders      []*fakeOrder
+	validations int // 成功的HTTP-01验证数
+}
+
+type fakeOrder struct {
+	domains    []string
+	account    string
+	token      string
+	authzValid bool
+	status     string
+	chain      []byte
+}
+
+func newFakeACME(t *testing.T, lifetime time.Duration) *fakeACME {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake ACME root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * 365 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(der)
+	ca := &fakeACME{
+		t:           t,
+		caKey:       caKey,
+		caCert:      caCert,
+		lifetime:    lifetime,
+		nonces:      make(map[string]bool),
+		badNonce:    true,
+		accounts:    make(map[string]*ecdsa.PublicKey),
+		thumbprints: make(map[string]string),
+	}
+	ca.server = httptest.NewServer(http.HandlerFunc(ca.handle))
+	t.Cleanup(ca.server.Close)
+	return ca
+}
+
+func (ca *fakeACME) directoryURL() string { return ca.server.URL + "/directory" }
+
+// roots 只包含本CA根证书的证书池
+func (ca *fakeACME) roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.caCert)
+	return pool
+}
+
+func (ca *fakeACME) stats() (accounts, orders, validations int) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	return len(ca.accounts), len(ca.orders), ca.validations
+}
+
+func (ca *fakeACME) newNonce() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	nonce := base64.RawURLEncoding.EncodeToString(buf)
+	ca.mu.Lock()
+	ca.nonces[nonce] = true
+	ca.mu.Unlock()
+	return nonce
+}
+
+func (ca *fakeACME) problem(w http.ResponseWriter, status int, typ, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"type": "urn:ietf:params:acme:error:" + typ, "detail": detail})
+}
+
+func (ca *fakeACME) reply(w http.ResponseWriter, status int, location string, body interface{}) {
+	if location != "" {
+		w.Header().Set("Location", ca.server.URL+location)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (ca *fakeACME) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", ca.newNonce())
+	switch {
+	case r.URL.Path == "/directory":
+		ca.reply(w, http.StatusOK, "", map[string]string{
+			"newNonce":   ca.server.URL + "/new-nonce",
+			"newAccount": ca.server.URL + "/new-account",
+			"newOrder":   ca.server.URL + "/new-order",
+		})
+		return
+	case r.URL.Path == "/new-nonce":
+		return
+	}
+
+	account, payload, ok := ca.verify(w, r)
+	if !ok {
+		return
+	}
+	kind, id, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if kind == "new-account" {
+		ca.reply(w, http.StatusCreated, strings.TrimPrefix(account, ca.server.URL), map[string]string{"status": "valid"})
+		return
+	}
+	if kind == "new-order" {
+		var req struct {
+			Identifiers []struct{ Value string } `json:"identifiers"`
+		}
+		json.Unmarshal(payload, &req)
+		o := &fakeOrder{account: account, token: ca.newNonce(), status: "pending"}
+		for _, identifier := range req.Identifiers {
+			o.domains = append(o.domains, identifier.Value)
+		}
+		ca.mu.Lock()
+		ca.orders = append(ca.orders, o)
+		n := len(ca.orders) - 1
+		ca.mu.Unlock()
+		ca.reply(w, http.StatusCreated, fmt.Sprintf("/order/%d", n), ca.orderJSON(n, o))
+		return
+	}
+
+	n, err := strconv.Atoi(id)
+	ca.mu.Lock()
+	if err != nil || n < 0 || n >= len(ca.orders) || ca.orders[n].account != account {
+		ca.mu.Unlock()
+		ca.problem(w, http.StatusNotFound, "malformed", "没有这个订单")
+		return
+	}
+	o := ca.orders[n]
+	ca.mu.Unlock()
+
+	switch kind {
+	case "order":
+		ca.mu.Lock()
+		if o.status == "processing" {
+			o.status = "valid"
+		}
+		ca.mu.Unlock()
+		ca.reply(w, http.StatusOK, "", ca.orderJSON(n, o))
+	case "authz":
+		ca.reply(w, http.StatusOK, "", ca.authzJSON(n, o))
+	case "chal":
+		ca.validate(o, account)
+		ca.reply(w, http.StatusOK, "", map[string]string{"type": acme.ChallengeHTTP01, "status": "processing"})
+	case "finalize":
+		ca.finalize(w, n, o, payload)
+	case "cert":
+		ca.mu.Lock()
+		chain := o.chain
+		ca.mu.Unlock()
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(chain)
+	default:
+		ca.problem(w, http.StatusNotFound, "malformed", "未知的地址")
+	}
+}
+
+func (ca *fakeACME) orderJSON(n int, o *fakeOrder) map[string]interface{} {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	body := map[string]interface{}{
+		"status":         o.status,
+		"authorizations": []string{fmt.Sprintf("%s/authz/%d", ca.server.URL, n)},
+		"finalize":       fmt.Sprintf("%s/finalize/%d", ca.server.URL, n),
+	}
+	if o.status == "valid" {
+		body["certificate"] = fmt.Sprintf("%s/cert/%d", ca.server.URL, n)
+	}
+	return body
+}
+
+func (ca *fakeACME) authzJSON(n int, o *fakeOrder) map[string]interface{} {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	status := "pending"
+	if o.authzValid {
+		status = "valid"
+	}
+	return map[string]interface{}{
+		"status":     status,
+		"identifier": map[string]string{"type": "dns", "value": o.domains[0]},
+		"challenges": []map[string]string{
+			{"type": acme.ChallengeTLSALPN01, "url": fmt.Sprintf("%s/chal/%d", ca.server.URL, n), "token": o.token, "status": "pending"},
+			{"type": acme.ChallengeHTTP01, "url": fmt.Sprintf("%s/chal/%d", ca.server.URL, n), "token": o.token, "status": "pending"},
+		},
+	}
+}
+
+// validate 按HTTP-01从负载均衡器的HTTP端口取回keyAuth，与令牌和账户公钥指纹比对
+func (ca *fakeACME) validate(o *fakeOrder, account string) {
+	req, _ := http.NewRequest("GET", fmt.Sprintf("http://127.0.0.1:%d%s%s", ca.httpPort, acme.HTTP01Path, o.token), nil)
+	req.Host = o.domains[0]
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		ca.t.Errorf("HTTP-01验证请求失败: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	want := o.token + "." + thumbprint(ca.accounts[account])
+	if resp.StatusCode != http.StatusOK || string(body) != want {
+		ca.t.Errorf("HTTP-01验证内容不符: %d %q, 期望 %q", resp.StatusCode, body, want)
+		return
+	}
+	o.authzValid = true
+	ca.validations++
+}
+
+// finalize 校验CSR与订单的域名一致后签发证书，订单先进入 processing
+func (ca *fakeACME) finalize(w http.ResponseWriter, n int, o *fakeOrder, payload []byte) {
+	var req struct {
+		CSR string `json:"csr"`
+	}
+	json.Unmarshal(payload, &req)
+	der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+	csr, err := x509.ParseCertificateRequest(der)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	ca.mu.Lock()
+	if err != nil || !o.authzValid || strings.Join(csr.DNSNames, ",") != strings.Join(o.domains, ",") {
+		ca.mu.Unlock()
+		ca.problem(w, http.StatusForbidden, "badCSR", fmt.Sprintf("CSR无效或授权未完成: %v", err))
+		return
+	}
+	ca.mu.Unlock()
+
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	leaf, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: csr.DNSNames[0]},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(ca.lifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca.caCert, csr.PublicKey, ca.caKey)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	chain := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.caCert.Raw})...)
+	ca.mu.Lock()
+	o.chain = chain
+	o.status = "processing"
+	ca.mu.Unlock()
+	ca.reply(w, http.StatusOK, "", ca.orderJSON(n, o))
+}
+
+// verify 校验JWS：nonce未用过、url与请求地址一致、签名与jwk或kid对应的账户公钥相符。
+// 返回账户URL和解码后的payload，newAccount请求时按公钥注册或找回账户
+func (ca *fakeACME) verify(w http.ResponseWriter, r *http.Request) (string, []byte, bool) {
+	var jws struct{ Protected, Payload, Signature string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil || r.Header.Get("Content-Type") != "application/jose+json" {
+		ca.problem(w, http.StatusBadRequest, "malformed", "请求不是JWS")
+		return "", nil, false
+	}
+	headerJSON, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	var header struct {
+		Alg, Nonce, URL, Kid string
+		JWK                  *struct{ Crv, Kty, X, Y string }
+	}
+	json.Unmarshal(headerJSON, &header)
+
+	ca.mu.Lock()
+	fresh := ca.nonces[header.Nonce]
+	delete(ca.nonces, header.Nonce)
+	rejectNonce := ca.badNonce
+	ca.badNonce = false
+	ca.mu.Unlock()
+	if !fresh || rejectNonce {
+		ca.problem(w, http.StatusBadRequest, "badNonce", "nonce无效")
+		return "", nil, false
+	}
+	if header.Alg != "ES256" || header.URL != ca.server.URL+r.URL.Path {
+		ca.problem(w, http.StatusBadRequest, "malformed", fmt.Sprintf("alg=%s url=%s", header.Alg, header.URL))
+		return "", nil, false
+	}
+
+	var key *ecdsa.PublicKey
+	account := header.Kid
+	if header.JWK != nil {
+		if r.URL.Path != "/new-account" {
+			ca.problem(w, http.StatusBadRequest, "malformed", "注册账户后应使用kid")
+			return "", nil, false
+		}
+		x, _ := base64.RawURLEncoding.DecodeString(header.JWK.X)
+		y, _ := base64.RawURLEncoding.DecodeString(header.JWK.Y)
+		key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	} else {
+		ca.mu.Lock()
+		key = ca.accounts[account]
+		ca.mu.Unlock()
+		if key == nil {
+			ca.problem(w, http.StatusUnauthorized, "accountDoesNotExist", "账户不存在")
+			return "", nil, false
+		}
+	}
+	signature, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if len(signature) != 64 || !ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		ca.problem(w, http.StatusUnauthorized, "unauthorized", "签名无效")
+		return "", nil, false
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+
+	if header.JWK != nil {
+		ca.mu.Lock()
+		fingerprint := thumbprint(key)
+		if account = ca.thumbprints[fingerprint]; account == "" {
+			account = fmt.Sprintf("%s/account/%d", ca.server.URL, len(ca.accounts))
+			ca.thumbprints[fingerprint] = account
+			ca.accounts[account] = key
+		}
+		ca.mu.Unlock()
+	}
+	return account, payload, true
+}
+
+// thumbprint 按RFC 7638独立计算账户公钥的JWK指纹
+func thumbprint(key *ecdsa.PublicKey) string {
+	b64 := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, 32))) }
+	sum := sha256.Sum256([]byte(`{"crv":"P-256","kty":"EC","x":"` + b64(key.X) + `","y":"` + b64(key.Y) + `"}`))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// TestAutocert 负载均衡器通过ACME申请证书：注册账户、下单、HTTP-01验证、提交CSR并轮询订单后，
+// HTTPS端口提供CA签发的证书；重启后使用缓存的账户密钥和证书，证书进入续期窗口时重新签发
+func TestAutocert(t *testing.T) {
+	if testing.Short() {
+		t.Skip("端到端测试需要监听本机端口，-short 时跳过")
+	}
+	const domain = "lb.example.test"
+	ca := newFakeACME(t, 90*24*time.Hour)
+	ca.httpPort = freePort(t)
+	cacheDir := t.TempDir()
+	c := &cluster{t: t}
+
+	// start 启动使用自动证书的负载均衡器，返回其HTTPS端口和停止函数
+	start := func(renewBefore time.Duration) (int, func()) {
+		t.Helper()
+		cfg := lb.DefaultConfig()
+		cfg.Port = freePort(t)
+		cfg.Backends = nil
+		cfg.Autocert = lb.AutocertConfig{
+			Enabled:      true,
+			Domains:      []string{domain},
+			AcceptTOS:    true,
+			DirectoryURL: ca.directoryURL(),
+			HTTPPort:     ca.httpPort,
+			CacheDir:     cacheDir,
+			RenewBefore:  protocol.Duration(renewBefore),
+		}
+		settings, err := perf.Config{}.Resolve()
+		if err != nil {
+			t.Fatal(err)
+		}
+		balancer, err := lb.NewFromConfig(cfg, settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go serve(t, "负载均衡器", balancer.Start)
+		return cfg.Port, func() { shutdown(balancer.Shutdown) }
+	}
+	// servedSerial HTTPS端口按SNI提供的证书（经本CA验证）的序列号，握手失败时为空
+	servedSerial := func(port int) string {
+		conn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port), &tls.Config{ServerName: domain, RootCAs: ca.roots()})
+		if err != nil {
+			return ""
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.String()
+	}
+	waitSerial := func(port int) string {
+		t.Helper()
+		var serial string
+		c.waitFor("HTTPS端口提供签发的证书", func() bool {
+			serial = servedSerial(port)
+			return serial != ""
+		})
+		return serial
+	}
+
+	port, stop := start(720 * time.Hour)
+	issued := waitSerial(port)
+	if accounts, orders, validations := ca.stats(); accounts != 1 || orders != 1 || validations != 1 {
+		t.Errorf("首次签发应注册1个账户、1个订单、1次验证: accounts=%d orders=%d validations=%d", accounts, orders, validations)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{ServerName: domain, RootCAs: ca.roots()}}}
+	resp, err := client.Get(fmt.Sprintf("https://127.0.0.1:%d/api/certificates", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status struct {
+		Certificates []struct {
+			Domain string `json:"domain"`
+			Valid  bool   `json:"valid"`
+		} `json:"certificates"`
+	}
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if len(status.Certificates) != 1 || status.Certificates[0].Domain != domain || !status.Certificates[0].Valid {
+		t.Errorf("/api/certificates 应显示有效的证书: %+v", status)
+	}
+	redirect, err := (&http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}).
+		Get(fmt.Sprintf("http://127.0.0.1:%d/ws", ca.httpPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect.Body.Close()
+	if redirect.StatusCode != http.StatusMovedPermanently || !strings.HasPrefix(redirect.Header.Get("Location"), "https://") {
+		t.Errorf("HTTP端口的其他请求应跳转到HTTPS: %d %s", redirect.StatusCode, redirect.Header.Get("Location"))
+	}
+	stop()
+
+	// 重启后使用缓存的证书，不再下单
+	port, stop = start(720 * time.Hour)
+	if serial := waitSerial(port); serial != issued {
+		t.Errorf("重启后应使用缓存的证书 %s, 实际 %s", issued, serial)
+	}
+	if _, orders, _ := ca.stats(); orders != 1 {
+		t.Errorf("证书未到续期窗口时不应下单: orders=%d", orders)
+	}
+	stop()
+
+	// 续期窗口大于证书剩余有效期时启动即续期，沿用缓存的账户
+	port, stop = start(100 * 24 * time.Hour)
+	defer stop()
+	c.waitFor("续期后提供新证书", func() bool {
+		serial := servedSerial(port)
+		return serial != "" && serial != issued
+	})
+	if accounts, orders, validations := ca.stats(); accounts != 1 || orders != 2 || validations != 2 {
+		t.Errorf("续期应沿用账户并重新验证: accounts=%d orders=%d validations=%d", accounts, orders, validations)
+	}
+}
@@ -0,0 +1,36 @@
+package e2e
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestAdminDashboard 管理界面编译进负载均衡器，/admin 重定向到 /admin/，节点不再提供工作目录中的文件
+func TestAdminDashboard(t *testing.T) {
+	c := startCluster(t, "", 1)
+
+	resp, err := http.Get(c.httpURL(c.lbPort) + "/admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/admin/" {
+		t.Fatalf("/admin 应重定向到管理界面, 实际 %d %s", resp.StatusCode, resp.Request.URL.Path)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") || !strings.Contains(string(body), "/api/backends") {
+		t.Errorf("管理界面内容不正确: %s", resp.Header.Get("Content-Type"))
+	}
+
+	node := c.nodes[c.order[0]]
+	resp, err = http.Get(c.httpURL(node.port) + "/e2e_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("节点不应再提供工作目录中的文件, 实际 %d", resp.StatusCode)
+	}
+}
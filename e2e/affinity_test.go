@@ -0,0 +1,88 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"websocket-loadbalance/client"
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/pkg/adminclient"
+	"websocket-loadbalance/server"
+)
+
+// TestAffinityRouting affinity 策略把声明了节点亲和性的客户端分配到标签满足条件的节点，
+// 没有满足条件的节点时退回到全部节点；亲和性和客户端标签记录在全局注册表中，可按标签选择器过滤
+func TestAffinityRouting(t *testing.T) {
+	nodeLabels := map[string]map[string]string{
+		"node1": {"region": "us"},
+		"node2": {"region": "eu"},
+		"node3": {"region": "eu", "gpu": "true"},
+	}
+	c := startClusterWith(t, 3, func(cfg *lb.Config) {
+		cfg.Strategy = lb.Affinity
+	}, func(s *server.Server) {
+		for suffix, labels := range nodeLabels {
+			if strings.HasSuffix(s.NodeID(), suffix) {
+				s.SetLabels(labels)
+			}
+		}
+	})
+	ctx := context.Background()
+	nodeLabel := func(nodeID string) map[string]string {
+		for suffix, labels := range nodeLabels {
+			if strings.HasSuffix(nodeID, suffix) {
+				return labels
+			}
+		}
+		return nil
+	}
+
+	// 标签随健康检查上报，负载均衡器得到所有节点的标签之后才能按亲和性选择
+	c.waitFor("负载均衡器得到节点标签", func() bool {
+		backends, err := c.admin.Backends(ctx)
+		if err != nil {
+			return false
+		}
+		for _, backend := range backends.Backends {
+			if fmt.Sprint(backend.Labels) != fmt.Sprint(nodeLabel(backend.ID)) {
+				return false
+			}
+		}
+		return true
+	})
+
+	gpu := c.connectWith("affinity-gpu", "affinity-gpu", client.Options{Affinity: "gpu"})
+	if node := c.nodeOf(gpu.id); nodeLabel(node)["gpu"] != "true" {
+		t.Errorf("亲和性为 gpu 的客户端被分配到 %s", node)
+	}
+	for i := 0; i < 4; i++ {
+		id := fmt.Sprintf("affinity-eu-%d", i)
+		c.connectWith(id, id, client.Options{Affinity: "region=eu", Labels: map[string]string{"team": "maps"}})
+		if node := c.nodeOf(id); nodeLabel(node)["region"] != "eu" {
+			t.Errorf("亲和性为 region=eu 的客户端 %s 被分配到 %s", id, node)
+		}
+	}
+	// 没有满足条件的节点时仍然可以连接
+	apac := c.connectWith("affinity-apac", "affinity-apac", client.Options{Affinity: "region=apac"})
+	if c.nodeOf(apac.id) == "" {
+		t.Error("亲和性无法满足的客户端应分配到任意节点")
+	}
+
+	list, err := c.admin.GlobalClientsPage(ctx, adminclient.ClientPageQuery{Selector: "team=maps"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Total != 4 {
+		t.Fatalf("按标签选择器过滤应返回4个客户端，实际 %d", list.Total)
+	}
+	for _, info := range list.Clients {
+		if info.Affinity != "region=eu" {
+			t.Errorf("注册表中客户端 %s 的亲和性不正确: %q", info.ID, info.Affinity)
+		}
+	}
+	if _, err := c.admin.GlobalClientsPage(ctx, adminclient.ClientPageQuery{Selector: "team in (maps"}); err == nil {
+		t.Error("无效的标签选择器应返回错误")
+	}
+}
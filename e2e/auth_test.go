@@ -0,0 +1,124 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/auth"
+	"websocket-loadbalance/server"
+)
+
+// TestAuthProviders 节点使用静态令牌和外部HTTP认证服务：握手时拒绝无效令牌，
+// 外部认证服务还可以在注册阶段拒绝客户端，认证得到的身份记录在客户端信息中
+func TestAuthProviders(t *testing.T) {
+	// 外部认证服务：令牌 sso-alice 对应 alice，注册阶段拒绝 client_id 为 mallory 的客户端
+	sso := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Stage        string                 `json:"stage"`
+			Token        string                 `json:"token"`
+			Registration map[string]interface{} `json:"registration"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		switch {
+		case req.Stage == "upgrade" && req.Token == "sso-alice":
+			json.NewEncoder(w).Encode(map[string]string{"subject": "alice", "namespace": "sso"})
+		case req.Stage == "registration" && req.Registration["client_id"] != "mallory":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "denied", http.StatusForbidden)
+		}
+	}))
+	defer sso.Close()
+
+	cases := []struct {
+		name          string
+		cfg           auth.Config
+		provider      string
+		good, bad     string // 有效和无效的令牌
+		wantSubject   string
+		wantNamespace string
+	}{
+		{
+			name:     "static",
+			provider: auth.ProviderStatic,
+			cfg: auth.Config{StaticTokens: []auth.StaticToken{
+				{Token: "static-secret", Subject: "billing-service", Namespace: "billing"},
+			}},
+			good: "static-secret", bad: "guess", wantSubject: "billing-service", wantNamespace: "billing",
+		},
+		{
+			name:     "http",
+			provider: auth.ProviderHTTP,
+			cfg:      auth.Config{HTTP: auth.HTTPProviderConfig{URL: sso.URL, CheckRegistration: true}},
+			good:     "sso-alice", bad: "sso-eve", wantSubject: "alice", wantNamespace: "sso",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			provider, err := auth.NewProvider(tc.cfg, tc.provider)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := startClusterWith(t, 1, nil, func(s *server.Server) { s.SetAuth(provider) })
+			url := func(token string) string {
+				return fmt.Sprintf("ws://127.0.0.1:%d/ws?token=%s", c.nodes[c.order[0]].port, token)
+			}
+
+			_, resp, err := websocket.DefaultDialer.Dial(url(tc.bad), nil)
+			if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+				t.Fatalf("无效令牌应以401拒绝, err=%v", err)
+			}
+
+			clientID := "auth-" + tc.name
+			conn, _, err := websocket.DefaultDialer.Dial(url(tc.good), nil)
+			if err != nil {
+				t.Fatalf("有效令牌连接失败: %v", err)
+			}
+			defer conn.Close()
+			if err := conn.WriteJSON(map[string]interface{}{"client_id": clientID}); err != nil {
+				t.Fatal(err)
+			}
+			c.waitFor("客户端 "+clientID+" 注册", func() bool { return c.nodeOf(clientID) != "" })
+			list, err := c.nodes[c.order[0]].admin.ListClients(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, info := range list.Clients {
+				if info.ID == clientID && (info.Subject != tc.wantSubject || info.Namespace != tc.wantNamespace) {
+					t.Errorf("客户端身份为 %s/%s，期望 %s/%s", info.Subject, info.Namespace, tc.wantSubject, tc.wantNamespace)
+				}
+			}
+		})
+	}
+
+	t.Run("registration", func(t *testing.T) {
+		provider, err := auth.NewProvider(auth.Config{
+			HTTP: auth.HTTPProviderConfig{URL: sso.URL, CheckRegistration: true},
+		}, auth.ProviderHTTP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := startClusterWith(t, 1, nil, func(s *server.Server) { s.SetAuth(provider) })
+		conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws?token=sso-alice", c.nodes[c.order[0]].port), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if err := conn.WriteJSON(map[string]interface{}{"client_id": "mallory"}); err != nil {
+			t.Fatal(err)
+		}
+		var reply map[string]interface{}
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatalf("读取注册结果失败: %v", err)
+		}
+		if reply["type"] != "error" || reply["code"] != "unauthorized" {
+			t.Errorf("注册应以 unauthorized 拒绝，实际为 %v", reply)
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/client"
+	"websocket-loadbalance/pkg/adminclient"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+	"websocket-loadbalance/server"
+)
+
+var uuidFormat = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// TestClientIDSchemes uuid 方案下节点为未提供ID的客户端分配UUID并以 client_id_assigned 告知；
+// legacy=map 时旧ID映射到固定的UUID，重新注册得到同一个ID，以旧ID发送的指令送达映射后的客户端；
+// regex 方案拒绝不匹配的ID
+func TestClientIDSchemes(t *testing.T) {
+	const staticID = "6f1c2d9e-8b7a-4c3d-9e2f-1a2b3c4d5e6f"
+	c := startClusterWith(t, 1, nil, func(s *server.Server) {
+		s.SetClientIDs(server.ClientIDConfig{
+			Scheme:  server.ClientIDSchemeUUID,
+			Legacy:  server.LegacyIDMap,
+			Aliases: map[string]string{"pos-static": staticID},
+		})
+	})
+	n := c.nodes[c.order[0]]
+	ctx := context.Background()
+
+	// register 直接连接节点并发送注册消息，返回连接和节点随后发来的第一条消息（没有消息时为nil）
+	register := func(port int, clientID string) (*websocket.Conn, map[string]interface{}) {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws", port), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		if err := conn.WriteJSON(map[string]interface{}{"client_id": clientID}); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		var msg map[string]interface{}
+		if err := conn.ReadJSON(&msg); err != nil {
+			return conn, nil
+		}
+		conn.SetReadDeadline(time.Time{})
+		return conn, msg
+	}
+	assigned := func(msg map[string]interface{}) string {
+		t.Helper()
+		if msg == nil || msg["type"] != protocol.TypeClientIDAssigned {
+			t.Fatalf("应收到 client_id_assigned 消息: %v", msg)
+		}
+		id, _ := msg["client_id"].(string)
+		return id
+	}
+
+	conn, msg := register(n.port, "")
+	fresh := assigned(msg)
+	if !uuidFormat.MatchString(fresh) {
+		t.Errorf("节点应分配版本4的UUID: %q", fresh)
+	}
+	c.waitFor("以分配的UUID注册", func() bool { return c.nodeOf(fresh) == n.id })
+	conn.Close()
+	c.waitFor("客户端注销", func() bool { return c.nodeOf(fresh) == "" })
+
+	// 带回分配的UUID重连时保持不变，不再发送 client_id_assigned
+	if _, msg := register(n.port, fresh); msg != nil {
+		t.Errorf("以分配的UUID重连时不应再分配: %v", msg)
+	}
+	c.waitFor("以原UUID重新注册", func() bool { return c.nodeOf(fresh) == n.id })
+
+	// 配置的别名
+	_, msg = register(n.port, "pos-static")
+	if id := assigned(msg); id != staticID || msg["legacy_id"] != "pos-static" {
+		t.Errorf("pos-static 应映射到配置的 %s: %v", staticID, msg)
+	}
+
+	// legacy=map：旧ID第一次注册时分配UUID并记录映射，再次注册得到同一个UUID
+	conn, msg = register(n.port, "legacy-pos-1")
+	mapped := assigned(msg)
+	if !uuidFormat.MatchString(mapped) || msg["legacy_id"] != "legacy-pos-1" {
+		t.Errorf("旧ID应映射到新分配的UUID: %v", msg)
+	}
+	if id, ok := registry.ResolveAlias("legacy-pos-1"); !ok || id != mapped {
+		t.Errorf("注册表应记录别名 legacy-pos-1 -> %s, 实际 %q", mapped, id)
+	}
+	conn.Close()
+	c.waitFor("旧ID客户端注销", func() bool { return c.nodeOf(mapped) == "" })
+
+	// Go客户端以旧ID连接，采用节点告知的ID；运维仍可按旧ID发送指令
+	wsURL := fmt.Sprintf("ws://127.0.0.1:%d/ws", n.port)
+	legacy, err := client.New(wsURL, wsURL, "legacy-pos-1", "旧收银台")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := &testClient{Client: legacy, id: mapped}
+	tc.dial(c)
+	t.Cleanup(tc.close)
+	result, err := n.admin.SendCommandAndWait(ctx, "legacy-pos-1", "ping", nil, 3*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Success || result.Response == nil || result.Response.Message != "pong" {
+		t.Errorf("按旧ID发送的指令应送达映射后的客户端: %+v", result)
+	}
+	aliases, err := n.admin.ClientAliases(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := make(map[string]string)
+	for _, alias := range aliases.Aliases {
+		sources[alias.LegacyID] = alias.Source + ":" + alias.ClientID
+	}
+	if sources["pos-static"] != "config:"+staticID || sources["legacy-pos-1"] != "registry:"+mapped {
+		t.Errorf("别名列表应包含配置的和记录的映射: %+v", aliases)
+	}
+	if err := n.admin.DeleteClientAlias(ctx, "no-such-legacy"); !adminclient.IsNotFound(err) {
+		t.Errorf("删除不存在的别名应返回404, err=%v", err)
+	}
+
+	// regex 方案
+	c.setup = func(s *server.Server) {
+		s.SetClientIDs(server.ClientIDConfig{Scheme: server.ClientIDSchemeRegex, Pattern: `pos-[0-9]+`})
+	}
+	strict := c.startNode(t.Name() + "-regex")
+	_, msg = register(strict.port, "kiosk-7")
+	if msg == nil || msg["type"] != "error" || msg["code"] != "invalid_client_id" {
+		t.Errorf("不匹配 pattern 的ID应以 invalid_client_id 拒绝: %v", msg)
+	}
+	if _, msg = register(strict.port, "pos-12"); msg != nil {
+		t.Errorf("匹配 pattern 的ID应原样注册: %v", msg)
+	}
+	c.waitFor("pos-12 注册", func() bool { return c.nodeOf("pos-12") == strict.id })
+}
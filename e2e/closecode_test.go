@@ -0,0 +1,105 @@
+package e2e
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/lb"
+)
+
+// TestCloseCodePropagation 关闭帧的关闭码和原因经负载均衡器原样送达另一侧，
+// 后端异常断开时客户端收到1011而不是无关闭帧的连接中断
+func TestCloseCodePropagation(t *testing.T) {
+	// 按客户端发来的第一条消息关闭连接的后端，记录收到的关闭帧
+	backendClosed := make(chan error, 1)
+	upgrader := websocket.Upgrader{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.Write([]byte(`{"status":"ok"}`))
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		switch string(data) {
+		case "kick":
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(4001, "会话被踢出"), time.Now().Add(time.Second))
+			conn.ReadMessage() // 等待关闭帧的回应
+		case "crash":
+			conn.UnderlyingConn().Close()
+		case "wait":
+			_, _, err := conn.ReadMessage()
+			backendClosed <- err
+		}
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	backendPort, _ := strconv.Atoi(backendURL.Port())
+
+	c := startClusterWith(t, 0, nil, nil)
+	if err := c.balancer.AddBackendConfig(lb.BackendConfig{ID: "raw-backend", Host: "127.0.0.1", Port: backendPort}); err != nil {
+		t.Fatal(err)
+	}
+	c.waitFor("后端健康", func() bool { return c.healthyBackends()["raw-backend"] })
+
+	dial := func(first string) *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws", c.lbPort), nil)
+		if err != nil {
+			t.Fatalf("连接负载均衡器失败: %v", err)
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(first)); err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	readClose := func(conn *websocket.Conn) *websocket.CloseError {
+		t.Helper()
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, _, err := conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			t.Fatalf("应收到关闭帧, err=%v", err)
+		}
+		return closeErr
+	}
+
+	if got := readClose(dial("kick")); got.Code != 4001 || got.Text != "会话被踢出" {
+		t.Errorf("后端的关闭帧应原样转发给客户端, 收到 %d %q", got.Code, got.Text)
+	}
+	if got := readClose(dial("crash")); got.Code != websocket.CloseInternalServerErr {
+		t.Errorf("后端异常断开时客户端应收到1011, 收到 %d %q", got.Code, got.Text)
+	}
+
+	conn := dial("wait")
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(4002, "客户端退出"), time.Now().Add(time.Second))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, 4002) {
+		t.Errorf("客户端应收到关闭帧的回应, err=%v", err)
+	}
+	conn.Close()
+	select {
+	case err := <-backendClosed:
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != 4002 || closeErr.Text != "客户端退出" {
+			t.Errorf("客户端的关闭帧应原样转发给后端, err=%v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("后端没有收到关闭帧")
+	}
+}
@@ -0,0 +1,354 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"websocket-loadbalance/client"
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/pkg/adminclient"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+	"websocket-loadbalance/server"
+)
+
+// TestStrategyDistribution 轮询和最少连接策略把新客户端均匀分配到各个节点
+func TestStrategyDistribution(t *testing.T) {
+	for _, strategy := range []lb.Strategy{lb.RoundRobin, lb.LeastConn} {
+		t.Run(string(strategy), func(t *testing.T) {
+			c := startCluster(t, strategy, 3)
+			for i := 0; i < 6; i++ {
+				c.connect(fmt.Sprintf("%s-client-%d", t.Name(), i))
+			}
+			for id, count := range c.clientCounts() {
+				if count != 2 {
+					t.Errorf("节点 %s 有 %d 个客户端，期望 2（分布: %v）", id, count, c.clientCounts())
+				}
+			}
+		})
+	}
+}
+
+// TestKetamaRemapping ketama策略按client_id把请求固定到节点，新增节点后只有一小部分客户端迁移，且都迁到新节点
+func TestKetamaRemapping(t *testing.T) {
+	sticky := false
+	c := startClusterWith(t, 3, func(cfg *lb.Config) {
+		cfg.Pools = []lb.PoolConfig{{Name: "node-info", PathPrefix: "/api/node-info", Strategy: lb.Ketama, Sticky: &sticky}}
+	}, nil)
+
+	const clients = 200
+	placement := func() map[string]string {
+		t.Helper()
+		nodes := make(map[string]string, clients)
+		for i := 0; i < clients; i++ {
+			id := fmt.Sprintf("ketama-%d", i)
+			resp, err := http.Get(c.httpURL(c.lbPort) + "/api/node-info?client_id=" + id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var info struct {
+				NodeID string `json:"node_id"`
+			}
+			json.NewDecoder(resp.Body).Decode(&info)
+			resp.Body.Close()
+			nodes[id] = info.NodeID
+		}
+		return nodes
+	}
+
+	before := placement()
+	perNode := make(map[string]int)
+	for _, node := range before {
+		perNode[node]++
+	}
+	if len(perNode) != 3 {
+		t.Fatalf("客户端应分布到全部3个节点: %v", perNode)
+	}
+	for id, node := range placement() {
+		if before[id] != node {
+			t.Fatalf("后端不变时客户端 %s 从 %s 换到了 %s", id, before[id], node)
+		}
+	}
+
+	added := c.startNode(t.Name() + "-node4")
+	if err := c.balancer.AddBackendConfig(lb.BackendConfig{ID: added.id, Host: "127.0.0.1", Port: added.port}); err != nil {
+		t.Fatal(err)
+	}
+	c.waitFor("新节点健康", func() bool { return c.healthyBackends()[added.id] })
+
+	moved := 0
+	for id, node := range placement() {
+		if node == before[id] {
+			continue
+		}
+		moved++
+		if node != added.id {
+			t.Errorf("客户端 %s 从 %s 迁到了 %s，只应迁到新节点", id, before[id], node)
+		}
+	}
+	if moved == 0 || moved > clients/2 {
+		t.Errorf("新增第4个节点后迁移了 %d/%d 个客户端，期望约四分之一", moved, clients)
+	}
+}
+
+// TestStickySession 同一客户端ID断开重连后回到原来的节点，即使策略本会选择其他节点
+func TestStickySession(t *testing.T) {
+	c := startCluster(t, lb.RoundRobin, 3)
+	sticky := c.connect("sticky-client")
+	first := c.nodeOf(sticky.id)
+
+	for i := 0; i < 3; i++ {
+		sticky.close()
+		c.waitFor("客户端断开", func() bool { return c.nodeOf(sticky.id) == "" })
+		// 其他客户端推进轮询位置
+		c.connect(fmt.Sprintf("filler-%d", i))
+		sticky.dial(c)
+		if got := c.nodeOf(sticky.id); got != first {
+			t.Fatalf("第 %d 次重连到了节点 %s，期望保持在 %s", i+1, got, first)
+		}
+	}
+
+	sessions := c.balancer.Sessions(first)
+	found := false
+	for _, session := range sessions.Sessions {
+		if session.SessionID == "client:"+sticky.id {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("节点 %s 的会话中没有客户端 %s: %+v", first, sticky.id, sessions.Sessions)
+	}
+}
+
+// TestHealthFailover 节点关闭后负载均衡器将其标记为不健康，客户端重连到其他节点，
+// 节点恢复前不再分配新连接
+func TestHealthFailover(t *testing.T) {
+	c := startCluster(t, lb.RoundRobin, 3)
+	victim := c.connect("failover-client")
+	down := c.nodeOf(victim.id)
+
+	c.stopNode(down)
+	select {
+	case <-victim.disconnected():
+	case <-time.After(waitTimeout):
+		t.Fatalf("节点 %s 关闭后客户端连接没有断开", down)
+	}
+	c.waitFor("负载均衡器发现节点 "+down+" 不健康", func() bool {
+		healthy := c.healthyBackends()
+		return len(healthy) == 2 && !healthy[down]
+	})
+
+	victim.dial(c)
+	if got := c.nodeOf(victim.id); got == down {
+		t.Fatalf("客户端重连到了已关闭的节点 %s", down)
+	}
+	for i := 0; i < 4; i++ {
+		id := fmt.Sprintf("after-failover-%d", i)
+		c.connect(id)
+		if got := c.nodeOf(id); got == down {
+			t.Fatalf("新客户端 %s 被分配到不健康的节点 %s", id, down)
+		}
+	}
+	if info, ok := registry.Get(victim.id); !ok || info.NodeID == down {
+		t.Errorf("注册表中客户端 %s 的节点为 %+v，期望已迁移出 %s", victim.id, info, down)
+	}
+}
+
+// TestCrossNodeCommand 向任意节点发送指令都能送达连接在其他节点上的客户端，并同步返回客户端的响应
+func TestCrossNodeCommand(t *testing.T) {
+	c := startCluster(t, lb.RoundRobin, 3)
+	target := c.connect("command-target")
+	home := c.nodeOf(target.id)
+
+	for _, id := range c.order {
+		if id == home {
+			continue
+		}
+		result, err := c.nodes[id].admin.SendCommandAndWait(context.Background(), target.id, "ping", nil, 3*time.Second)
+		if err != nil {
+			t.Fatalf("经节点 %s 向 %s 上的客户端发送指令失败: %v", id, home, err)
+		}
+		if !result.Success {
+			t.Fatalf("经节点 %s 发送的指令未成功: %+v", id, result)
+		}
+		if result.Response == nil || result.Response.Result != "success" || result.Response.Message != "pong" {
+			t.Errorf("经节点 %s 发送的指令响应为 %+v，期望客户端回复 pong", id, result.Response)
+		}
+	}
+}
+
+// TestRegistryConsistency 全局注册表与各节点实际连接的客户端一致，客户端断开后从注册表移除
+func TestRegistryConsistency(t *testing.T) {
+	c := startCluster(t, lb.RoundRobin, 3)
+	clients := make([]*testClient, 0, 6)
+	for i := 0; i < 6; i++ {
+		clients = append(clients, c.connect(fmt.Sprintf("registry-client-%d", i)))
+	}
+
+	check := func() {
+		t.Helper()
+		for _, cl := range clients {
+			home := c.nodeOf(cl.id)
+			info, ok := registry.Get(cl.id)
+			if home == "" {
+				if ok {
+					t.Errorf("已断开的客户端 %s 仍在注册表中 (节点 %s)", cl.id, info.NodeID)
+				}
+				continue
+			}
+			if !ok {
+				t.Errorf("客户端 %s 连接在节点 %s，但不在注册表中", cl.id, home)
+				continue
+			}
+			if info.NodeID != home || info.NodePort != c.nodes[home].port {
+				t.Errorf("注册表中客户端 %s 的节点为 %s:%d，实际为 %s:%d", cl.id, info.NodeID, info.NodePort, home, c.nodes[home].port)
+			}
+			if !info.IsActive || info.Status != registry.StatusOnline {
+				t.Errorf("注册表中客户端 %s 的状态为 %s (is_active=%v)，期望 online", cl.id, info.Status, info.IsActive)
+			}
+		}
+	}
+	check()
+
+	// 负载均衡器汇总各节点的客户端，与注册表一致
+	all, err := c.admin.AllClients(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all.Total != len(clients) {
+		t.Errorf("负载均衡器汇总了 %d 个客户端，期望 %d", all.Total, len(clients))
+	}
+
+	for _, cl := range clients[:3] {
+		cl.close()
+	}
+	c.waitFor("注册表移除断开的客户端", func() bool {
+		for _, cl := range clients[:3] {
+			if _, ok := registry.Get(cl.id); ok {
+				return false
+			}
+		}
+		return true
+	})
+	check()
+}
+
+// TestCommandBySelector 按名称通配符或标签选择器向分布在多个节点上的客户端发送指令，返回每个客户端的结果
+func TestCommandBySelector(t *testing.T) {
+	c := startCluster(t, lb.RoundRobin, 3)
+	fleet := map[string]map[string]string{
+		"pos-1":   {"env": "prod", "role": "pos"},
+		"pos-2":   {"env": "prod", "role": "pos"},
+		"kiosk-1": {"env": "prod", "role": "kiosk"},
+		"pos-dev": {"env": "dev", "role": "pos"},
+	}
+	for id, labels := range fleet {
+		c.connectWith("selector-"+id, "store-"+id, client.Options{Labels: labels})
+	}
+	homes := make(map[string]bool)
+	for id := range fleet {
+		homes[c.nodeOf("selector-"+id)] = true
+	}
+	if len(homes) < 2 {
+		t.Fatalf("客户端都连接在同一个节点上，无法验证跨节点发送: %v", homes)
+	}
+
+	cases := []struct {
+		name, selector string
+		want           []string
+	}{
+		{selector: "env=prod,role=pos", want: []string{"pos-1", "pos-2"}},
+		{selector: "role in (pos, kiosk),env!=dev", want: []string{"kiosk-1", "pos-1", "pos-2"}},
+		{name: "store-pos-*", want: []string{"pos-1", "pos-2", "pos-dev"}},
+		{name: "store-pos-*", selector: "env=dev", want: []string{"pos-dev"}},
+	}
+	admin := c.nodes[c.order[0]].admin
+	for _, tc := range cases {
+		result, err := admin.SendCommandToMatching(context.Background(), adminclient.CommandRequest{
+			Name: tc.name, Selector: tc.selector, Command: "ping", Wait: true, Timeout: 3 * time.Second,
+		})
+		if err != nil {
+			t.Fatalf("name=%q selector=%q: %v", tc.name, tc.selector, err)
+		}
+		if result.Matched != len(tc.want) || result.Succeeded != len(tc.want) {
+			t.Errorf("name=%q selector=%q 匹配 %d 个、成功 %d 个，期望 %d 个", tc.name, tc.selector, result.Matched, result.Succeeded, len(tc.want))
+		}
+		for _, id := range tc.want {
+			target, ok := result.Results["selector-"+id]
+			if !ok || !target.Success || target.Response == nil || target.Response.Message != "pong" {
+				t.Errorf("name=%q selector=%q 中客户端 %s 的结果为 %+v", tc.name, tc.selector, id, target)
+			}
+		}
+	}
+
+	_, err := admin.SendCommandToMatching(context.Background(), adminclient.CommandRequest{Selector: "env=staging", Command: "ping"})
+	if !adminclient.IsNotFound(err) {
+		t.Errorf("没有匹配的客户端时期望404，得到 %v", err)
+	}
+	var apiErr *adminclient.APIError
+	_, err = admin.SendCommandToMatching(context.Background(), adminclient.CommandRequest{Selector: "env in ()", Command: "ping"})
+	if !errors.As(err, &apiErr) || apiErr.Code != "invalid_selector" {
+		t.Errorf("无效的选择器期望 invalid_selector，得到 %v", err)
+	}
+}
+
+// TestSelfRegistration 节点启动时自注册为后端并接收客户端，按心跳续约，关闭时注销；
+// 停止心跳的后端在租约到期后移除，令牌错误的登记被拒绝
+func TestSelfRegistration(t *testing.T) {
+	const token = "join-secret"
+	ttl := 600 * time.Millisecond
+	c := startClusterWith(t, 0, func(cfg *lb.Config) {
+		cfg.SelfRegistration = lb.SelfRegistrationConfig{Enabled: true, TTL: protocol.Duration(ttl), Token: token}
+	}, nil)
+	c.setup = func(s *server.Server) {
+		s.SetRegistration(server.RegistrationConfig{LoadBalancer: c.httpURL(c.lbPort), Token: token})
+	}
+	first := c.startNode(t.Name() + "-node1")
+	c.startNode(t.Name() + "-node2")
+	c.waitFor("节点自注册", func() bool { return len(c.healthyBackends()) == 2 })
+	c.connect("self-registered-client")
+
+	time.Sleep(2 * ttl)
+	if healthy := c.healthyBackends(); len(healthy) != 2 {
+		t.Fatalf("超过租约时长后在册的健康后端为 %v，期望心跳续约了2个节点", healthy)
+	}
+
+	registered := func(id string) bool {
+		list, err := c.admin.Backends(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, backend := range list.Backends {
+			if backend.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+	c.stopNode(first.id)
+	if registered(first.id) {
+		t.Errorf("节点 %s 关闭后仍在负载均衡器的后端列表中", first.id)
+	}
+
+	ghost := protocol.BackendRegistration{ID: t.Name() + "-ghost", Host: "127.0.0.1", Port: freePort(t)}
+	if _, err := c.balancer.RegisterBackend(ghost); err != nil {
+		t.Fatal(err)
+	}
+	c.waitFor("租约到期的后端被移除", func() bool { return !registered(ghost.ID) })
+
+	body, _ := json.Marshal(ghost)
+	resp, err := http.Post(c.httpURL(c.lbPort)+"/api/backends", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("不带令牌的登记返回 %d，期望 401", resp.StatusCode)
+	}
+}
@@ -0,0 +1,86 @@
+package e2e
+
+import (
+	"context"
+	"testing"
+
+	"websocket-loadbalance/pkg/adminclient"
+	"websocket-loadbalance/server"
+)
+
+// TestClientCommandHistory 节点为每个客户端保留最近的指令及其响应，超出 size 时丢弃最早的；
+// 从其他节点查询时转发到客户端所在的节点
+func TestClientCommandHistory(t *testing.T) {
+	c := startClusterWith(t, 2, nil, func(s *server.Server) {
+		s.SetCommandHistory(server.CommandHistoryConfig{Enabled: true, Size: 2})
+	})
+	target := c.connect("cmdhistory-target")
+	home := c.nodeOf(target.id)
+	other := c.order[0]
+	if other == home {
+		other = c.order[1]
+	}
+	ctx := context.Background()
+	admin := c.nodes[other].admin
+
+	var last *adminclient.CommandResult
+	for _, command := range []string{"ping", "status", "echo"} {
+		result, err := admin.SendCommand(ctx, adminclient.CommandRequest{ClientID: target.id, Command: command, Data: map[string]string{"from": command}})
+		if err != nil {
+			t.Fatalf("发送指令 %s 失败: %v", command, err)
+		}
+		last = result
+	}
+
+	var history *adminclient.ClientCommands
+	c.waitFor("最后一条指令的响应记入指令历史", func() bool {
+		var err error
+		history, err = admin.ClientCommands(ctx, target.id, 0)
+		return err == nil && history.LastResponse != nil && history.LastResponse.RequestID == last.RequestID
+	})
+	if history.Node != home || !history.Online || history.Capacity != 2 || len(history.Commands) != 2 {
+		t.Fatalf("指令历史不正确: %+v", history)
+	}
+	if history.Commands[0].Command != "status" || history.Commands[1].Command != "echo" {
+		t.Errorf("应只保留最近2条指令: %+v", history.Commands)
+	}
+	echo := history.Commands[1]
+	if echo.Status != server.CommandStatusResponded || echo.Result != "success" || string(echo.Data) != `{"from":"echo"}` || len(echo.Response) == 0 {
+		t.Errorf("指令历史应包含指令数据和客户端的响应: %+v", echo)
+	}
+
+	limited, err := c.nodes[home].admin.ClientCommands(ctx, target.id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limited.Commands) != 1 || limited.Commands[0].RequestID != last.RequestID || limited.LastResponse == nil {
+		t.Errorf("limit=1 应只返回最后一条指令: %+v", limited)
+	}
+}
+
+// TestCommandHistoryEviction 超过 max_clients 时淘汰最久没有收到指令的客户端
+func TestCommandHistoryEviction(t *testing.T) {
+	c := startClusterWith(t, 1, nil, func(s *server.Server) {
+		s.SetCommandHistory(server.CommandHistoryConfig{Enabled: true, MaxClients: 2})
+	})
+	admin := c.nodes[c.order[0]].admin
+	ctx := context.Background()
+	for _, id := range []string{"evict-a", "evict-b", "evict-c"} {
+		defer c.connect(id).close()
+	}
+
+	// evict-a 再次收到指令后，evict-b 成为最久没有收到指令的客户端
+	for _, id := range []string{"evict-a", "evict-b", "evict-a", "evict-c"} {
+		if _, err := admin.SendCommand(ctx, adminclient.CommandRequest{ClientID: id, Command: "ping"}); err != nil {
+			t.Fatalf("向 %s 发送指令失败: %v", id, err)
+		}
+	}
+	if _, err := admin.ClientCommands(ctx, "evict-b", 0); !adminclient.IsNotFound(err) {
+		t.Errorf("evict-b 的指令历史应被淘汰: %v", err)
+	}
+	for _, id := range []string{"evict-a", "evict-c"} {
+		if history, err := admin.ClientCommands(ctx, id, 0); err != nil || len(history.Commands) == 0 {
+			t.Errorf("%s 的指令历史应保留: %+v %v", id, history, err)
+		}
+	}
+}
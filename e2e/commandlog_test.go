@@ -0,0 +1,57 @@
+package e2e
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"websocket-loadbalance/pkg/adminclient"
+	"websocket-loadbalance/server"
+)
+
+// TestCommandLog 异步发送的指令和客户端的响应记录在客户端所在的节点，
+// 之后凭发送时返回的 request_id 从任意节点查询结果
+func TestCommandLog(t *testing.T) {
+	dir := t.TempDir()
+	c := startClusterWith(t, 2, nil, func(s *server.Server) {
+		s.SetCommandLog(server.CommandLogConfig{Store: "file", File: filepath.Join(dir, "commands-{node_id}.jsonl")})
+	})
+	target := c.connect("commandlog-target")
+	home := c.nodeOf(target.id)
+	other := c.order[0]
+	if other == home {
+		other = c.order[1]
+	}
+
+	// 从另一个节点发送，指令被转发到客户端所在的节点执行
+	sent, err := c.nodes[other].admin.SendCommand(context.Background(), adminclient.CommandRequest{
+		ClientID: target.id,
+		Command:  "echo",
+		Data:     map[string]string{"hello": "world"},
+	})
+	if err != nil {
+		t.Fatalf("发送指令失败: %v", err)
+	}
+	if sent.RequestID == "" {
+		t.Fatalf("异步发送的响应中应带有 request_id: %+v", sent)
+	}
+
+	var record *server.CommandRecord
+	c.waitFor("指令 "+sent.RequestID+" 的响应被记录", func() bool {
+		record, err = c.nodes[other].admin.CommandRecord(context.Background(), sent.RequestID)
+		return err == nil && record.Status == server.CommandStatusResponded
+	})
+	if record.Node != home || record.ClientID != target.id || record.Command != "echo" || record.Result != "success" {
+		t.Errorf("指令记录不正确: %+v", record)
+	}
+	if string(record.Data) != `{"hello":"world"}` || len(record.Response) == 0 || record.RespondedAt == nil {
+		t.Errorf("指令记录应包含指令数据、响应数据和响应时间: %+v", record)
+	}
+
+	var apiErr *adminclient.APIError
+	if _, err := c.nodes[home].admin.CommandRecord(context.Background(), "no-such-request"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("查询不存在的 request_id 应返回404, err=%v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/client"
+	"websocket-loadbalance/pkg/adminclient"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/server"
+)
+
+// TestCommandDelivery 声明 ack 的客户端 nack 的指令被重发并最终得到响应；
+// 从不确认的客户端收到 max_attempts 次同一 delivery_id 的指令后判定失败。未启用 command_log 时也能查询投递状态
+func TestCommandDelivery(t *testing.T) {
+	c := startClusterWith(t, 1, nil, func(s *server.Server) {
+		s.SetCommandDelivery(server.CommandDeliveryConfig{
+			Enabled:       true,
+			RetryInterval: protocol.Duration(100 * time.Millisecond),
+			MaxAttempts:   3,
+		})
+	})
+	admin := c.nodes[c.order[0]].admin
+	ctx := context.Background()
+	wsURL := fmt.Sprintf("ws://127.0.0.1:%d/ws", c.lbPort)
+
+	// 第一次处理时设备忙，请求重发
+	const flakyID = "delivery-flaky"
+	cl, err := client.New(wsURL, wsURL, flakyID, flakyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls atomic.Int32
+	cl.RegisterHandler("open_drawer", func(ctx context.Context, _ json.RawMessage) (interface{}, error) {
+		if calls.Add(1) == 1 {
+			return nil, fmt.Errorf("设备忙: %w", client.ErrRetry)
+		}
+		return "opened", nil
+	})
+	tc := &testClient{Client: cl, id: flakyID}
+	tc.dial(c)
+	t.Cleanup(tc.close)
+
+	sent, err := admin.SendCommand(ctx, adminclient.CommandRequest{ClientID: flakyID, Command: "open_drawer"})
+	if err != nil {
+		t.Fatalf("发送指令失败: %v", err)
+	}
+	var record *server.CommandRecord
+	c.waitFor("被 nack 的指令重发后得到响应", func() bool {
+		record, err = admin.CommandRecord(ctx, sent.RequestID)
+		return err == nil && record.Delivery != nil && record.Delivery.State == server.DeliveryResponded
+	})
+	if d := record.Delivery; d.Attempts != 2 || d.Nacks != 1 || d.AckedAt == nil || record.Status != server.CommandStatusResponded {
+		t.Errorf("投递状态不正确: %+v %+v", record, d)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("处理函数应被调用2次，实际 %d 次", n)
+	}
+
+	// 声明 ack 却从不确认的客户端
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	const silentID = "delivery-silent"
+	if err := conn.WriteJSON(map[string]interface{}{"client_id": silentID, "client_name": silentID, "ack": true}); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	attempts := make(map[string][]float64) // delivery_id -> 收到的 attempt
+	go func() {
+		for {
+			var msg map[string]interface{}
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if deliveryID, _ := msg["delivery_id"].(string); msg["type"] == "command" && deliveryID != "" {
+				attempt, _ := msg["attempt"].(float64)
+				mu.Lock()
+				attempts[deliveryID] = append(attempts[deliveryID], attempt)
+				mu.Unlock()
+			}
+		}
+	}()
+	c.waitFor("客户端 "+silentID+" 注册", func() bool { return c.nodeOf(silentID) != "" })
+
+	sent, err = admin.SendCommand(ctx, adminclient.CommandRequest{ClientID: silentID, Command: "status"})
+	if err != nil {
+		t.Fatalf("发送指令失败: %v", err)
+	}
+	c.waitFor("未确认的指令判定失败", func() bool {
+		record, err = admin.CommandRecord(ctx, sent.RequestID)
+		return err == nil && record.Delivery != nil && record.Delivery.State == server.DeliveryFailed
+	})
+	if record.Status != server.CommandStatusFailed || record.Delivery.Attempts != 3 || record.Error == "" {
+		t.Errorf("失败的投递记录不正确: %+v %+v", record, record.Delivery)
+	}
+	mu.Lock()
+	got := fmt.Sprint(attempts[sent.RequestID])
+	mu.Unlock()
+	if got != "[1 2 3]" {
+		t.Errorf("客户端应收到3次同一 delivery_id 的指令，实际 attempt: %s", got)
+	}
+
+	metrics, err := admin.Metrics(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery := metrics.Delivery; delivery["failed"] != float64(1) || delivery["nacked"] != float64(1) || delivery["retries"] != float64(3) {
+		t.Errorf("投递统计不正确: %v", delivery)
+	}
+}
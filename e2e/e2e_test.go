@@ -0,0 +1,312 @@
+// Package e2e 端到端测试：在同一进程中启动负载均衡器、3个服务端节点和若干客户端，
+// 通过WebSocket和管理API验证负载均衡策略、会话保持、故障转移、跨节点指令和全局注册表。
+//
+//	go test ./e2e/            运行全部用例
+//	go test -v ./e2e/         同时输出各组件的日志
+//	go test -short ./e2e/     跳过（用例需要启动真实的监听端口，耗时数秒）
+//	go test -race ./e2e/      检查共享状态的并发访问，合并前应通过
+package e2e
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"websocket-loadbalance/client"
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/perf"
+	"websocket-loadbalance/pkg/adminclient"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+	"websocket-loadbalance/server"
+)
+
+// waitTimeout 等待异步状态（注册、健康检查、重连）收敛的上限
+const waitTimeout = 5 * time.Second
+
+// registryFile 测试进程共享的全局注册表文件
+var registryFile string
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	// 同一进程中的节点和负载均衡器共享全局注册表，与 -mode=multi 部署一致
+	dir, err := os.MkdirTemp("", "websocket-e2e")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	registryFile = filepath.Join(dir, "global_clients.json")
+	registry.Init(registryFile)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// cluster 测试用的集群：一个负载均衡器和若干服务端节点，均监听在本机的随机端口
+type cluster struct {
+	t        *testing.T
+	lbPort   int
+	balancer *lb.LoadBalancer
+	admin    *adminclient.Client // 负载均衡器的管理API
+	nodes    map[string]*node
+	order    []string             // 节点ID，按启动顺序
+	setup    func(*server.Server) // 节点启动前的额外设置，可为nil
+}
+
+type node struct {
+	id     string
+	port   int
+	server *server.Server
+	admin  *adminclient.Client
+	down   bool
+}
+
+// startCluster 启动 nodeCount 个节点和使用 strategy 的负载均衡器，返回时所有后端均已健康
+func startCluster(t *testing.T, strategy lb.Strategy, nodeCount int) *cluster {
+	t.Helper()
+	return startClusterWith(t, nodeCount, func(cfg *lb.Config) { cfg.Strategy = strategy }, nil)
+}
+
+// startClusterWith 同 startCluster，configure 调整负载均衡器的配置，setup 在每个节点启动前调用（均可为nil）
+func startClusterWith(t *testing.T, nodeCount int, configure func(*lb.Config), setup func(*server.Server)) *cluster {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("端到端测试需要监听本机端口，-short 时跳过")
+	}
+	c := &cluster{t: t, nodes: make(map[string]*node), setup: setup}
+
+	cfg := lb.DefaultConfig()
+	cfg.Port = freePort(t)
+	cfg.Backends = nil
+	if configure != nil {
+		configure(&cfg)
+	}
+	cfg.HealthCheck.Interval = protocol.Duration(100 * time.Millisecond)
+	cfg.HealthCheck.Timeout = protocol.Duration(500 * time.Millisecond)
+	for i := 1; i <= nodeCount; i++ {
+		n := c.startNode(fmt.Sprintf("%s-node%d", t.Name(), i))
+		cfg.Backends = append(cfg.Backends, lb.BackendConfig{ID: n.id, Host: "127.0.0.1", Port: n.port})
+	}
+
+	settings, err := perf.Config{}.Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	balancer, err := lb.NewFromConfig(cfg, settings)
+	if err != nil {
+		t.Fatalf("创建负载均衡器失败: %v", err)
+	}
+	c.lbPort = cfg.Port
+	c.balancer = balancer
+	c.admin = adminclient.New(c.httpURL(cfg.Port), adminclient.Options{})
+	go serve(t, "负载均衡器", balancer.Start)
+	t.Cleanup(func() { shutdown(balancer.Shutdown) })
+
+	c.waitFor("所有后端健康", func() bool {
+		return len(c.healthyBackends()) == nodeCount
+	})
+	return c
+}
+
+// startNode 启动一个服务端节点并等待其 /health 可用
+func (c *cluster) startNode(id string) *node {
+	c.t.Helper()
+	n := &node{id: id, port: freePort(c.t)}
+	n.server = server.New(n.port, id)
+	if c.setup != nil {
+		c.setup(n.server)
+	}
+	n.admin = adminclient.New(c.httpURL(n.port), adminclient.Options{})
+	c.nodes[id] = n
+	c.order = append(c.order, id)
+	go serve(c.t, "节点 "+id, n.server.Start)
+	c.t.Cleanup(func() {
+		if !n.down {
+			shutdown(n.server.Shutdown)
+		}
+	})
+	c.waitFor("节点 "+id+" 启动", func() bool {
+		resp, err := http.Get(c.httpURL(n.port) + "/health")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	})
+	return n
+}
+
+// stopNode 关闭节点，节点上的客户端连接随之断开
+func (c *cluster) stopNode(id string) {
+	c.t.Helper()
+	n := c.nodes[id]
+	n.down = true
+	shutdown(n.server.Shutdown)
+}
+
+// connect 创建客户端，经负载均衡器连接并开始处理服务端消息，返回时客户端已在某个节点注册
+func (c *cluster) connect(clientID string) *testClient {
+	c.t.Helper()
+	return c.connectWith(clientID, clientID, client.Options{})
+}
+
+// connectWith 以指定的名称和连接选项创建客户端，其余同 connect
+func (c *cluster) connectWith(clientID, name string, opts client.Options) *testClient {
+	c.t.Helper()
+	wsURL := fmt.Sprintf("ws://127.0.0.1:%d/ws", c.lbPort)
+	cl, err := client.New(wsURL, wsURL, clientID, name)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	cl.SetOptions(opts)
+	tc := &testClient{Client: cl, id: clientID}
+	tc.dial(c)
+	c.t.Cleanup(tc.close)
+	return tc
+}
+
+// nodeOf 返回客户端当前所在的节点ID（以各节点 /api/clients 为准），不在线时返回空
+func (c *cluster) nodeOf(clientID string) string {
+	c.t.Helper()
+	for _, id := range c.order {
+		n := c.nodes[id]
+		if n.down {
+			continue
+		}
+		list, err := n.admin.ListClients(context.Background())
+		if err != nil {
+			c.t.Fatalf("查询节点 %s 的客户端失败: %v", id, err)
+		}
+		for _, info := range list.Clients {
+			if info.ID == clientID {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// clientCounts 各个在线节点上的客户端数
+func (c *cluster) clientCounts() map[string]int {
+	c.t.Helper()
+	counts := make(map[string]int)
+	for _, id := range c.order {
+		n := c.nodes[id]
+		if n.down {
+			continue
+		}
+		list, err := n.admin.ListClients(context.Background())
+		if err != nil {
+			c.t.Fatalf("查询节点 %s 的客户端失败: %v", id, err)
+		}
+		counts[id] = list.Total
+	}
+	return counts
+}
+
+// healthyBackends 负载均衡器认为健康的后端ID
+func (c *cluster) healthyBackends() map[string]bool {
+	list, err := c.admin.Backends(context.Background())
+	if err != nil {
+		return nil
+	}
+	healthy := make(map[string]bool)
+	for _, backend := range list.Backends {
+		if backend.IsHealthy {
+			healthy[backend.ID] = true
+		}
+	}
+	return healthy
+}
+
+// waitFor 轮询直到cond成立，超时则测试失败
+func (c *cluster) waitFor(what string, cond func() bool) {
+	c.t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			c.t.Fatalf("等待 %s 超时 (%v)", what, waitTimeout)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func (c *cluster) httpURL(port int) string {
+	return "http://127.0.0.1:" + strconv.Itoa(port)
+}
+
+// testClient 测试中的客户端，记录当前连接的消息处理协程以便重连和关闭
+type testClient struct {
+	*client.Client
+	id      string
+	mu      sync.Mutex
+	handled chan struct{} // 当前连接的 HandleServerMessages 返回时关闭
+}
+
+// dial 经负载均衡器连接并等待注册完成
+func (tc *testClient) dial(c *cluster) {
+	c.t.Helper()
+	if err := tc.ConnectToLoadBalancer(); err != nil {
+		c.t.Fatalf("客户端 %s 连接失败: %v", tc.id, err)
+	}
+	handled := make(chan struct{})
+	tc.mu.Lock()
+	tc.handled = handled
+	tc.mu.Unlock()
+	go func() {
+		defer close(handled)
+		tc.HandleServerMessages()
+	}()
+	c.waitFor("客户端 "+tc.id+" 注册", func() bool { return c.nodeOf(tc.id) != "" })
+}
+
+// disconnected 当前连接断开（消息处理协程返回）时关闭
+func (tc *testClient) disconnected() <-chan struct{} {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.handled
+}
+
+func (tc *testClient) close() {
+	tc.Close()
+	if handled := tc.disconnected(); handled != nil {
+		<-handled
+	}
+}
+
+// serve 在后台运行Start，正常关闭以外的错误记录为测试失败
+func serve(t *testing.T, name string, start func() error) {
+	if err := start(); err != nil && err != http.ErrServerClosed {
+		t.Errorf("%s 退出: %v", name, err)
+	}
+}
+
+func shutdown(fn func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	fn(ctx)
+}
+
+// freePort 返回一个当前空闲的本机端口
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
@@ -0,0 +1,79 @@
+package e2e
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/pkg/adminclient"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+	"websocket-loadbalance/server"
+)
+
+// TestFeatureFlags 在任意节点设置的功能开关由各节点在运行时应用：按节点关闭执行类指令后，
+// 该节点以403拒绝向其客户端发送 exec，其他节点不受影响；删除开关后恢复默认
+func TestFeatureFlags(t *testing.T) {
+	c := startClusterWith(t, 2, nil, func(s *server.Server) {
+		s.SetFeatureFlags(server.FeatureFlagsConfig{WatchInterval: protocol.Duration(100 * time.Millisecond)})
+	})
+	home, other := c.order[0], c.order[1]
+	t.Cleanup(func() { registry.DeleteFlag(server.FlagExecCommands) })
+
+	// 不声明能力的客户端接受任何指令，直接连接到 home 节点
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws", c.nodes[home].port), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteJSON(map[string]interface{}{"client_id": "flags-target"}); err != nil {
+		t.Fatal(err)
+	}
+	c.waitFor("客户端 flags-target 注册", func() bool { return c.nodeOf("flags-target") == home })
+
+	effective := func(nodeID string) bool {
+		flags, err := c.nodes[nodeID].admin.FeatureFlags(context.Background())
+		if err != nil {
+			t.Fatalf("查询节点 %s 的功能开关失败: %v", nodeID, err)
+		}
+		return flags.Effective[server.FlagExecCommands]
+	}
+	exec := func() error {
+		_, err := c.nodes[home].admin.SendCommand(context.Background(), adminclient.CommandRequest{
+			ClientID: "flags-target",
+			Command:  "exec",
+			Data:     map[string]interface{}{"cmd": "uptime"},
+		})
+		return err
+	}
+
+	if _, err := c.nodes[other].admin.SetFeatureFlag(context.Background(), server.FlagExecCommands, registry.FeatureFlag{
+		Enabled:   true,
+		Nodes:     map[string]bool{home: false},
+		UpdatedBy: "e2e",
+	}); err != nil {
+		t.Fatalf("设置功能开关失败: %v", err)
+	}
+	c.waitFor("节点 "+home+" 关闭执行类指令", func() bool { return !effective(home) })
+	if !effective(other) {
+		t.Errorf("节点 %s 不在覆盖列表中，执行类指令应保持开启", other)
+	}
+
+	var apiErr *adminclient.APIError
+	if err := exec(); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden || apiErr.Code != "feature_disabled" {
+		t.Fatalf("关闭执行类指令后发送 exec 应以403 feature_disabled 拒绝, err=%v", err)
+	}
+
+	if err := c.nodes[other].admin.DeleteFeatureFlag(context.Background(), server.FlagExecCommands); err != nil {
+		t.Fatalf("删除功能开关失败: %v", err)
+	}
+	c.waitFor("节点 "+home+" 恢复执行类指令", func() bool { return effective(home) })
+	if err := exec(); err != nil {
+		t.Errorf("删除功能开关后发送 exec 失败: %v", err)
+	}
+}
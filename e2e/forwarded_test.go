@@ -0,0 +1,72 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/forwarded"
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/server"
+)
+
+// TestForwardedClientAddress 节点经负载均衡器得到真实客户端地址，不受信的连接伪造的 X-Forwarded-For 被丢弃
+func TestForwardedClientAddress(t *testing.T) {
+	loopback := forwarded.Config{TrustedProxies: []string{"127.0.0.0/8"}}
+	cases := []struct {
+		name          string
+		lbTrusted     bool // 负载均衡器信任本机客户端给出的 X-Forwarded-For
+		proxyProtocol bool // 负载均衡器与节点之间使用PROXY协议
+		want          string
+	}{
+		{name: "headers", lbTrusted: true, want: "203.0.113.7"},
+		{name: "proxy_protocol", lbTrusted: true, proxyProtocol: true, want: "203.0.113.7"},
+		{name: "untrusted", want: "127.0.0.1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			configure := func(cfg *lb.Config) {
+				if tc.lbTrusted {
+					cfg.Forwarded = loopback
+				}
+				cfg.BackendProxyProtocol = tc.proxyProtocol
+			}
+			setup := func(s *server.Server) {
+				nodeCfg := loopback
+				nodeCfg.ProxyProtocol = tc.proxyProtocol
+				resolver, err := forwarded.New(nodeCfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				s.SetForwarded(resolver)
+			}
+			c := startClusterWith(t, 1, configure, setup)
+
+			clientID := "forwarded-" + tc.name
+			header := http.Header{}
+			header.Set(forwarded.HeaderForwardedFor, "203.0.113.7")
+			conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws", c.lbPort), header)
+			if err != nil {
+				t.Fatalf("连接负载均衡器失败: %v", err)
+			}
+			defer conn.Close()
+			if err := conn.WriteJSON(map[string]interface{}{"client_id": clientID, "client_name": clientID}); err != nil {
+				t.Fatal(err)
+			}
+			c.waitFor("客户端 "+clientID+" 注册", func() bool { return c.nodeOf(clientID) != "" })
+
+			list, err := c.nodes[c.order[0]].admin.ListClients(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, info := range list.Clients {
+				if info.ID == clientID && info.RemoteAddr != tc.want {
+					t.Errorf("节点记录的客户端地址为 %q，期望 %q", info.RemoteAddr, tc.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"websocket-loadbalance/client"
+)
+
+// TestCommandHandlers 客户端注册的指令处理函数按类型解析指令数据并自动回复，
+// 中间件包在处理函数外层，未注册的指令由节点按能力声明拒绝
+func TestCommandHandlers(t *testing.T) {
+	c := startClusterWith(t, 1, nil, nil)
+
+	const clientID = "handler-client"
+	wsURL := fmt.Sprintf("ws://127.0.0.1:%d/ws", c.lbPort)
+	cl, err := client.New(wsURL, wsURL, clientID, clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type volume struct {
+		Level int `json:"level"`
+	}
+	var mu sync.Mutex
+	var requestID string
+	var seen []string
+	cl.RegisterHandler("set_volume", client.Typed(func(ctx context.Context, v volume) (interface{}, error) {
+		if v.Level > 100 {
+			return nil, &client.CommandError{Message: "音量超出范围", Data: map[string]int{"max": 100}}
+		}
+		info, _ := client.CommandInfoFrom(ctx)
+		mu.Lock()
+		requestID = info.RequestID
+		mu.Unlock()
+		return map[string]int{"level": v.Level}, nil
+	}))
+	cl.UseHandler(func(command string, next client.CommandHandler) client.CommandHandler {
+		return func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+			mu.Lock()
+			seen = append(seen, command)
+			mu.Unlock()
+			return next(ctx, payload)
+		}
+	})
+	tc := &testClient{Client: cl, id: clientID}
+	tc.dial(c)
+	t.Cleanup(tc.close)
+
+	admin := c.nodes[c.order[0]].admin
+	ctx := context.Background()
+	result, err := admin.SendCommandAndWait(ctx, clientID, "set_volume", map[string]int{"level": 30}, 3*time.Second)
+	if err != nil {
+		t.Fatalf("自定义指令失败: %v", err)
+	}
+	if r := result.Response; r == nil || r.Result != "success" || fmt.Sprint(r.Data) != "map[level:30]" {
+		t.Fatalf("自定义指令的响应不正确: %+v", result.Response)
+	}
+	mu.Lock()
+	if requestID == "" || requestID != result.RequestID {
+		t.Errorf("处理函数应从ctx取得请求ID %q, 实际 %q", result.RequestID, requestID)
+	}
+	mu.Unlock()
+
+	result, _ = admin.SendCommandAndWait(ctx, clientID, "set_volume", map[string]int{"level": 300}, 3*time.Second)
+	if r := result.Response; r == nil || r.Result != "error" || r.Message != "音量超出范围" || fmt.Sprint(r.Data) != "map[max:100]" {
+		t.Errorf("处理函数返回的错误应作为错误响应发回: %+v", result.Response)
+	}
+	result, _ = admin.SendCommandAndWait(ctx, clientID, "set_volume", "loud", 3*time.Second)
+	if r := result.Response; r == nil || r.Result != "error" {
+		t.Errorf("无法解析的指令数据应返回错误响应: %+v", result.Response)
+	}
+
+	if _, err := admin.SendCommandAndWait(ctx, clientID, "unregistered", nil, 3*time.Second); err == nil {
+		t.Error("未注册的指令应被节点拒绝")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) != 3 {
+		t.Errorf("中间件应看到3次 set_volume, 实际 %v", seen)
+	}
+}
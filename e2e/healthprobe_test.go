@@ -0,0 +1,63 @@
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/server"
+)
+
+// TestHealthProbeAdmission 带 health_probe 参数的连接不认证，但同样占用节点的连接名额；
+// 节点满员时负载均衡器的WebSocket探测被拒绝握手，后端仍视为健康
+func TestHealthProbeAdmission(t *testing.T) {
+	c := startClusterWith(t, 1, func(cfg *lb.Config) {
+		cfg.HealthCheck.Protocol = lb.HealthProbeWebSocket
+	}, func(s *server.Server) {
+		s.SetMaxClients(1)
+	})
+	n := c.nodes[c.order[0]]
+	nodeURL := fmt.Sprintf("ws://127.0.0.1:%d/ws", n.port)
+
+	// 负载均衡器的探测连接随时可能短暂占用名额，重试直到外部的探测连接拿到名额
+	var probe *websocket.Conn
+	c.waitFor("外部探测连接占用名额", func() bool {
+		conn, _, err := websocket.DefaultDialer.Dial(nodeURL+"?health_probe=anything", nil)
+		probe = conn
+		return err == nil
+	})
+	defer probe.Close()
+
+	_, resp, err := websocket.DefaultDialer.Dial(nodeURL, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("探测连接占用名额后客户端连接应以503拒绝: %v", err)
+	}
+	var body struct {
+		Code string `json:"code"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if body.Code != "server_full" {
+		t.Errorf("拒绝原因应为 server_full, 实际 %q", body.Code)
+	}
+
+	// 满员期间负载均衡器的探测被拒绝握手，后端不应被判定为不健康
+	time.Sleep(500 * time.Millisecond)
+	if !c.healthyBackends()[n.id] {
+		t.Error("节点满员时WebSocket探测应仍视后端为健康")
+	}
+
+	probe.Close()
+	c.waitFor("探测连接关闭后名额释放", func() bool {
+		conn, _, err := websocket.DefaultDialer.Dial(nodeURL, nil)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	})
+}
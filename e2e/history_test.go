@@ -0,0 +1,65 @@
+package e2e
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"websocket-loadbalance/pkg/adminclient"
+	"websocket-loadbalance/server"
+)
+
+// TestClientHistory 节点记录客户端收到的指令和回复的响应，从任意节点都能查到
+func TestClientHistory(t *testing.T) {
+	c := startClusterWith(t, 2, nil, func(s *server.Server) {
+		s.SetHistory(server.HistoryConfig{Enabled: true, Size: 10})
+	})
+	target := c.connect("history-target")
+	home := c.nodeOf(target.id)
+	other := c.order[0]
+	if other == home {
+		other = c.order[1]
+	}
+
+	for i := 0; i < 8; i++ {
+		if _, err := c.nodes[home].admin.SendCommandAndWait(context.Background(), target.id, "ping", nil, 3*time.Second); err != nil {
+			t.Fatalf("发送指令失败: %v", err)
+		}
+	}
+
+	// 从另一个节点查询，由其转发到客户端所在的节点
+	history, err := c.nodes[other].admin.ClientHistory(context.Background(), target.id, adminclient.HistoryQuery{})
+	if err != nil {
+		t.Fatalf("查询消息记录失败: %v", err)
+	}
+	if history.Node != home || history.Total != 10 || history.LastSeq < 16 {
+		t.Fatalf("消息记录来自 %s，共 %d 条，最后序号 %d；期望来自 %s、保留最近10条、至少16条记录",
+			history.Node, history.Total, history.LastSeq, home)
+	}
+	var sent, replied int
+	for _, entry := range history.Entries {
+		switch {
+		case entry.Direction == server.HistoryOutbound && entry.Command == "ping":
+			sent++
+		case entry.Direction == server.HistoryInbound && entry.Type == "command_response":
+			replied++
+		}
+	}
+	if sent == 0 || replied == 0 {
+		t.Errorf("消息记录中应同时有发出的指令和客户端的响应: %+v", history.Entries)
+	}
+
+	replies, err := c.nodes[home].admin.ClientHistory(context.Background(), target.id,
+		adminclient.HistoryQuery{Direction: server.HistoryInbound, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies.Entries) != 2 || replies.Entries[0].Seq >= replies.Entries[1].Seq {
+		t.Fatalf("按条数过滤的结果不正确: %+v", replies.Entries)
+	}
+	for _, entry := range replies.Entries {
+		if entry.Direction != server.HistoryInbound {
+			t.Errorf("按方向过滤的结果中有发出的消息: %+v", entry)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/server"
+)
+
+// TestConnectionLifetime 连接达到最长存活时间后服务端发送 reconnect，
+// SDK客户端以正常关闭码主动断开；不响应的客户端在宽限期后被服务端以1001断开
+func TestConnectionLifetime(t *testing.T) {
+	c := startClusterWith(t, 1, nil, func(s *server.Server) {
+		s.SetConnectionLifetime(server.ConnectionLifetimeConfig{
+			MaxAge:      protocol.Duration(600 * time.Millisecond),
+			Jitter:      protocol.Duration(200 * time.Millisecond),
+			GracePeriod: protocol.Duration(500 * time.Millisecond),
+		})
+	})
+	node := c.nodes[c.order[0]]
+
+	tc := c.connect("lifetime-client")
+	select {
+	case <-tc.disconnected():
+	case <-time.After(waitTimeout):
+		t.Fatal("客户端没有在连接到期后断开")
+	}
+
+	// 只注册、不处理 reconnect 的客户端
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws", c.lbPort), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.WriteJSON(map[string]interface{}{"client_id": "lifetime-raw", "client_name": "lifetime-raw"})
+	conn.SetReadDeadline(time.Now().Add(waitTimeout))
+	var reconnectAt time.Time
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				t.Fatalf("宽限期后应以1001断开, err=%v", err)
+			}
+			break
+		}
+		var msg map[string]interface{}
+		json.Unmarshal(data, &msg)
+		if msg["type"] == protocol.TypeReconnect {
+			if msg["reason"] != protocol.ReconnectMaxAge {
+				t.Errorf("reconnect 的原因不正确: %v", msg)
+			}
+			reconnectAt = time.Now()
+		}
+	}
+	if reconnectAt.IsZero() {
+		t.Fatal("断开前没有收到 reconnect")
+	}
+	if waited := time.Since(reconnectAt); waited < 400*time.Millisecond {
+		t.Errorf("服务端应等待宽限期后再断开, 实际 %v", waited)
+	}
+
+	metrics, err := node.admin.Metrics(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := metrics.ConnectionLifetime; got["requested"] != float64(2) || got["forced"] != float64(1) {
+		t.Errorf("回收统计不正确: %v", got)
+	}
+}
@@ -0,0 +1,47 @@
+package e2e
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/pkg/adminclient"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/server"
+)
+
+// TestIPv6Backend 只监听IPv6回环地址的节点通过完整的后端地址 [::1]:port 接入负载均衡器，
+// 客户端经负载均衡器连接到该节点，IPv4地址上不接受连接
+func TestIPv6Backend(t *testing.T) {
+	if listener, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skip("本机不支持IPv6回环地址")
+	} else {
+		listener.Close()
+	}
+
+	nodeID := t.Name() + "-node1"
+	port := freePort(t)
+	address := net.JoinHostPort("::1", strconv.Itoa(port))
+	// 节点在负载均衡器之后启动，负载均衡器启动时后端尚不健康
+	c := startClusterWith(t, 0, func(cfg *lb.Config) {
+		cfg.Backends = []lb.BackendConfig{{ID: nodeID, Address: address}}
+	}, nil)
+	s := server.New(port, nodeID)
+	s.SetListenAddress("::1", protocol.AddressFamilyIPv6)
+	go serve(t, "节点 "+nodeID, s.Start)
+	t.Cleanup(func() { shutdown(s.Shutdown) })
+	c.nodes[nodeID] = &node{id: nodeID, port: port, server: s, admin: adminclient.New("http://"+address, adminclient.Options{})}
+	c.order = append(c.order, nodeID)
+	c.waitFor("IPv6后端健康", func() bool { return c.healthyBackends()[nodeID] })
+
+	if resp, err := http.Get(c.httpURL(port) + "/health"); err == nil {
+		resp.Body.Close()
+		t.Error("只监听IPv6的节点不应在IPv4地址上接受连接")
+	}
+	tc := c.connect("ipv6-client")
+	if node := c.nodeOf(tc.id); node != nodeID {
+		t.Errorf("客户端应连接到IPv6节点，实际为 %q", node)
+	}
+}
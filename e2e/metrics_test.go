@@ -0,0 +1,98 @@
+package e2e
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/tracing"
+)
+
+// TestOTLPMetricsPush 启用 tracing.metrics 后节点和负载均衡器的指标定期推送到OTLP接收端
+func TestOTLPMetricsPush(t *testing.T) {
+	var mu sync.Mutex
+	points := make(map[string][]map[string]interface{}) // 指标名 -> 最近一次推送的数据点
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" || r.Header.Get("X-Token") != "secret" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req struct {
+			ResourceMetrics []struct {
+				ScopeMetrics []struct {
+					Metrics []struct {
+						Name  string
+						Gauge *struct{ DataPoints []map[string]interface{} }
+						Sum   *struct {
+							DataPoints  []map[string]interface{}
+							IsMonotonic bool
+						}
+					}
+				}
+			}
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rm := range req.ResourceMetrics {
+			for _, sm := range rm.ScopeMetrics {
+				for _, m := range sm.Metrics {
+					if m.Gauge != nil {
+						points[m.Name] = m.Gauge.DataPoints
+					} else if m.Sum != nil && m.Sum.IsMonotonic {
+						points[m.Name] = m.Sum.DataPoints
+					}
+				}
+			}
+		}
+	}))
+	defer collector.Close()
+
+	cfg := tracing.Config{
+		Headers: map[string]string{"X-Token": "secret"},
+		Metrics: tracing.MetricsConfig{Enabled: true, Endpoint: collector.URL + "/v1/metrics", Interval: protocol.Duration(100 * time.Millisecond)},
+	}
+	if err := tracing.Init(cfg, "e2e"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tracing.Init(tracing.Config{}, "") })
+
+	c := startCluster(t, lb.RoundRobin, 2)
+	c.connect("metrics-a")
+	c.connect("metrics-b")
+
+	// 两个节点的连接数之和为2，负载均衡器报告两个健康后端
+	value := func(point map[string]interface{}) float64 {
+		v, _ := point["asDouble"].(float64)
+		return v
+	}
+	c.waitFor("指标推送到接收端", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		clients := 0.0
+		for _, point := range points["websocket.server.clients"] {
+			clients += value(point)
+		}
+		healthy := points["websocket.lb.backends.healthy"]
+		return len(points["websocket.server.clients"]) == 2 && clients == 2 &&
+			len(healthy) == 1 && value(healthy[0]) == 2 &&
+			len(points["websocket.lb.backend.connections"]) == 2 &&
+			len(points["websocket.server.messages.received"]) == 2
+	})
+
+	// 接收端返回响应之后推送统计才更新
+	c.waitFor("推送统计", func() bool {
+		metrics, ok := tracing.Stats()["metrics"].(map[string]interface{})
+		return ok && metrics["pushes"].(int64) > 0
+	})
+}
@@ -0,0 +1,121 @@
+package e2e
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"websocket-loadbalance/client"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/server"
+)
+
+// sealer 演示用的对称加密中间件：出站消息异或后封装为 {"type":"sealed"}，入站时解封
+func sealer(key byte) protocol.Middleware {
+	xor := func(data []byte) []byte {
+		out := make([]byte, len(data))
+		for i, b := range data {
+			out[i] = b ^ key
+		}
+		return out
+	}
+	return func(next protocol.FrameHandler) protocol.FrameHandler {
+		return func(f *protocol.Frame) error {
+			if f.Direction == protocol.DirectionOutbound {
+				f.Data, _ = json.Marshal(map[string]string{"type": "sealed", "payload": base64.StdEncoding.EncodeToString(xor(f.Data))})
+				return next(f)
+			}
+			var sealed struct {
+				Type    string `json:"type"`
+				Payload string `json:"payload"`
+			}
+			if err := json.Unmarshal(f.Data, &sealed); err != nil || sealed.Type != "sealed" {
+				return errors.New("收到未加密的消息")
+			}
+			raw, err := base64.StdEncoding.DecodeString(sealed.Payload)
+			if err != nil {
+				return err
+			}
+			f.Data = xor(raw)
+			return next(f)
+		}
+	}
+}
+
+// frameTypes 记录经过的入站消息的 type 字段和所属客户端
+type frameTypes struct {
+	mu    sync.Mutex
+	types map[string]int
+}
+
+func (ft *frameTypes) middleware(next protocol.FrameHandler) protocol.FrameHandler {
+	return func(f *protocol.Frame) error {
+		if f.Direction == protocol.DirectionInbound {
+			var msg struct {
+				Type string `json:"type"`
+			}
+			json.Unmarshal(f.Data, &msg)
+			ft.mu.Lock()
+			ft.types[f.ClientID+"/"+msg.Type]++
+			ft.mu.Unlock()
+		}
+		return next(f)
+	}
+}
+
+func (ft *frameTypes) snapshot() map[string]int {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	types := make(map[string]int, len(ft.types))
+	for key, n := range ft.types {
+		types[key] = n
+	}
+	return types
+}
+
+// TestMessageMiddleware 客户端和节点以相同顺序添加同一组中间件：线路上只有加密后的消息，
+// 两端先添加的中间件看到明文，指令和响应照常收发
+func TestMessageMiddleware(t *testing.T) {
+	serverSeen := &frameTypes{types: make(map[string]int)}
+	c := startClusterWith(t, 1, nil, func(s *server.Server) {
+		s.Use(serverSeen.middleware, sealer(0x5a))
+	})
+
+	const clientID = "sealed-client"
+	wsURL := fmt.Sprintf("ws://127.0.0.1:%d/ws", c.lbPort)
+	cl, err := client.New(wsURL, wsURL, clientID, clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wire := &frameTypes{types: make(map[string]int)}
+	cl.Use(sealer(0x5a), wire.middleware)
+	tc := &testClient{Client: cl, id: clientID}
+	tc.dial(c)
+	t.Cleanup(tc.close)
+
+	result, err := c.nodes[c.order[0]].admin.SendCommandAndWait(context.Background(), clientID, "ping", nil, 3*time.Second)
+	if err != nil {
+		t.Fatalf("经过中间件的指令失败: %v", err)
+	}
+	if result.Response == nil || result.Response.Result != "success" {
+		t.Fatalf("指令结果为 %+v，期望客户端回复 success", result.Response)
+	}
+
+	onWire := wire.snapshot()
+	if onWire[clientID+"/sealed"] == 0 {
+		t.Fatalf("客户端的内层中间件没有看到加密的消息: %v", onWire)
+	}
+	for key, n := range onWire {
+		if key != clientID+"/sealed" {
+			t.Errorf("客户端在线路上收到了 %d 条未加密的 %s 消息", n, key)
+		}
+	}
+	if seen := serverSeen.snapshot(); seen[clientID+"/command_response"] == 0 {
+		t.Errorf("节点的外层中间件应看到客户端 %s 的 command_response: %v", clientID, seen)
+	}
+}
@@ -0,0 +1,54 @@
+package e2e
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"websocket-loadbalance/client"
+	"websocket-loadbalance/pkg/adminclient"
+)
+
+// TestNamespaceIsolation 未指定命名空间的指令和广播属于默认命名空间，不能到达其他命名空间的客户端
+func TestNamespaceIsolation(t *testing.T) {
+	c := startClusterWith(t, 1, nil, nil)
+	admin := c.nodes[c.order[0]].admin
+	ctx := context.Background()
+
+	defer c.connectWith("ns-tenant", "ns-tenant", client.Options{Namespace: "team-a"}).close()
+	defer c.connect("ns-default-1").close()
+	defer c.connect("ns-default-2").close()
+
+	// mismatch 指令因命名空间不符被拒绝
+	mismatch := func(err error) bool {
+		var apiErr *adminclient.APIError
+		return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusForbidden && apiErr.Code == "namespace_mismatch"
+	}
+	if _, err := admin.SendCommand(ctx, adminclient.CommandRequest{ClientID: "ns-tenant", Command: "ping"}); !mismatch(err) {
+		t.Errorf("未指定命名空间时向 team-a 的客户端发送指令应返回403 namespace_mismatch: %v", err)
+	}
+	if _, err := admin.SendCommand(ctx, adminclient.CommandRequest{ClientID: "ns-default-1", Command: "ping", Namespace: "team-a"}); !mismatch(err) {
+		t.Errorf("team-a 向默认命名空间的客户端发送指令应返回403 namespace_mismatch: %v", err)
+	}
+	if _, err := admin.SendCommand(ctx, adminclient.CommandRequest{ClientID: "ns-tenant", Command: "ping", Namespace: "team-a"}); err != nil {
+		t.Errorf("同一命名空间内应可以发送指令: %v", err)
+	}
+	if _, err := admin.SendCommandToMatching(ctx, adminclient.CommandRequest{Name: "ns-tenant", Command: "ping"}); !adminclient.IsNotFound(err) {
+		t.Errorf("未指定命名空间时按名称不应匹配到 team-a 的客户端: %v", err)
+	}
+
+	result, err := admin.Broadcast(ctx, adminclient.BroadcastRequest{Command: "ping"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Sent != 2 || result.Skipped != 1 {
+		t.Errorf("未指定命名空间的广播应只发给默认命名空间的2个客户端: %+v", result)
+	}
+	if result, err = admin.Broadcast(ctx, adminclient.BroadcastRequest{Command: "ping", Namespace: "team-a"}); err != nil {
+		t.Fatal(err)
+	}
+	if result.Sent != 1 || result.Skipped != 2 {
+		t.Errorf("team-a 的广播应只发给 team-a 的客户端: %+v", result)
+	}
+}
@@ -0,0 +1,85 @@
+//go:build linux
+
+package e2e
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/server"
+)
+
+// TestPollConnPanic epoll模式下注册或处理消息时发生panic只关闭出错的连接：
+// 连接名额被释放（节点只允许1个连接），工作协程和节点继续服务新的连接
+func TestPollConnPanic(t *testing.T) {
+	c := startClusterWith(t, 1, nil, func(s *server.Server) {
+		s.SetConnMode(server.ConnModeEpoll, 1)
+		s.SetMaxClients(1)
+		s.Use(func(next protocol.FrameHandler) protocol.FrameHandler {
+			return func(f *protocol.Frame) error {
+				if f.Direction == protocol.DirectionInbound && bytes.Contains(f.Data, []byte("boom")) {
+					panic("测试中间件: " + string(f.Data))
+				}
+				return next(f)
+			}
+		})
+	})
+	n := c.nodes[c.order[0]]
+
+	dial := func(clientID string) *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws", n.port), nil)
+		if err != nil {
+			t.Fatalf("客户端 %s 连接失败: %v", clientID, err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		if err := conn.WriteJSON(map[string]interface{}{"client_id": clientID}); err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	// closed 节点是否在2秒内关闭了连接
+	closed := func(conn *websocket.Conn) bool {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				netErr, ok := err.(net.Error)
+				return !ok || !netErr.Timeout()
+			}
+		}
+	}
+
+	// 注册消息触发panic
+	if !closed(dial("poll-boom")) {
+		t.Fatal("注册时panic的连接应被关闭")
+	}
+
+	// 已注册连接的消息触发panic
+	conn := dial("poll-a")
+	c.waitFor("poll-a 注册", func() bool { return c.nodeOf("poll-a") == n.id })
+	if err := conn.WriteJSON(map[string]interface{}{"type": "heartbeat", "status": "boom"}); err != nil {
+		t.Fatal(err)
+	}
+	if !closed(conn) {
+		t.Fatal("处理消息时panic的连接应被关闭")
+	}
+	c.waitFor("poll-a 注销", func() bool { return c.nodeOf("poll-a") == "" })
+
+	// 名额已释放，唯一的工作协程仍在处理消息
+	conn = dial("poll-b")
+	c.waitFor("poll-b 注册", func() bool { return c.nodeOf("poll-b") == n.id })
+	if err := conn.WriteJSON(map[string]interface{}{"type": "heartbeat"}); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var ack map[string]interface{}
+	if err := conn.ReadJSON(&ack); err != nil || ack["type"] != protocol.TypeHeartbeatAck {
+		t.Errorf("panic之后工作协程应继续处理消息: %v %v", ack, err)
+	}
+}
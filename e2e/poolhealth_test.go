@@ -0,0 +1,48 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"websocket-loadbalance/lb"
+)
+
+// TestPoolHealthCheck 后端池的 health_check 只作用于池中的后端：池的探测路径失效时池中后端变为不健康，
+// 其他后端仍按全局 /health 探测；同一后端不能属于两个设置了健康检查的池
+func TestPoolHealthCheck(t *testing.T) {
+	chatNode := fmt.Sprintf("%s-node2", t.Name())
+	chat := lb.PoolConfig{Name: "chat", PathPrefix: "/ws/chat", Backends: []string{chatNode}, HealthCheck: &lb.PoolHealthCheck{Path: "/health"}}
+	c := startClusterWith(t, 2, func(cfg *lb.Config) { cfg.Pools = []lb.PoolConfig{chat} }, nil)
+	ctx := context.Background()
+
+	pools, err := c.admin.Pools(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range pools {
+		if p.Name == "chat" && (p.HealthCheck == nil || p.HealthCheck.Path != "/health") {
+			t.Errorf("后端池列表应包含池的健康检查设置: %+v", p)
+		}
+	}
+
+	chat.HealthCheck = &lb.PoolHealthCheck{Path: "/no-such-health"}
+	if err := c.admin.PutPool(ctx, chat); err != nil {
+		t.Fatal(err)
+	}
+	c.waitFor("池中后端按池的路径探测后变为不健康", func() bool { return !c.healthyBackends()[chatNode] })
+	if !c.healthyBackends()[c.order[0]] {
+		t.Errorf("不在池中的后端 %s 应仍按全局设置探测为健康", c.order[0])
+	}
+
+	overlap := lb.PoolConfig{Name: "chat-v2", PathPrefix: "/ws/chat/v2", Backends: []string{chatNode}, HealthCheck: &lb.PoolHealthCheck{Protocol: lb.HealthProbeWebSocket}}
+	if err := c.admin.PutPool(ctx, overlap); err == nil {
+		t.Error("后端属于两个设置了健康检查的池时应拒绝")
+	}
+
+	chat.HealthCheck = nil
+	if err := c.admin.PutPool(ctx, chat); err != nil {
+		t.Fatal(err)
+	}
+	c.waitFor("去掉池的健康检查后恢复健康", func() bool { return c.healthyBackends()[chatNode] })
+}
@@ -0,0 +1,79 @@
+package e2e
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"websocket-loadbalance/client"
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/server"
+)
+
+// TestPoolProtocolVersions 通过管理API和重新加载配置修改的后端池保留 protocol_versions：
+// 版本不符的客户端落到更短前缀的池，只改版本范围的重新加载也会生效
+func TestPoolProtocolVersions(t *testing.T) {
+	node1, node2 := t.Name()+"-node1", t.Name()+"-node2"
+	// 版本不符时 /ws 的请求回退到前缀 /w 的池，落点确定
+	fallback := lb.PoolConfig{Name: "fallback", PathPrefix: "/w", Backends: []string{node1}}
+	var cfg *lb.Config // 集群启动后即为负载均衡器的完整配置
+	c := startClusterWith(t, 2, func(lbCfg *lb.Config) {
+		lbCfg.Pools = []lb.PoolConfig{fallback}
+		cfg = lbCfg
+	}, func(s *server.Server) {
+		s.SetProtocolVersions(protocol.VersionRange{Min: 1, Max: 2})
+	})
+	ctx := context.Background()
+
+	gated := lb.PoolConfig{Name: "gated", PathPrefix: "/ws", Backends: []string{node2}, ProtocolVersions: &protocol.VersionRange{Min: 1, Max: 1}}
+	if err := c.admin.PutPool(ctx, gated); err != nil {
+		t.Fatal(err)
+	}
+	pools, err := c.admin.Pools(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range pools {
+		if p.Name == "gated" && (p.ProtocolVersions == nil || p.ProtocolVersions.String() != "1") {
+			t.Errorf("后端池列表应包含池的协议版本: %+v", p)
+		}
+	}
+
+	// expectNode 以指定的协议版本连接，检查客户端被分配到的节点
+	expectNode := func(clientID string, version int, want string) {
+		t.Helper()
+		tc := c.connectWith(clientID, clientID, client.Options{ProtocolVersion: version})
+		if got := c.nodeOf(clientID); got != want {
+			t.Errorf("协议版本 %d 的客户端应分配到 %s, 实际 %s", version, want, got)
+		}
+		tc.close()
+	}
+	expectNode("pv-put-1", 1, node2)
+	expectNode("pv-put-2", 2, node1)
+
+	// 重新加载时只修改版本范围
+	gated.ProtocolVersions = &protocol.VersionRange{Min: 2, Max: 2}
+	c.balancer.SetConfigSource(func() (lb.Config, error) {
+		reloaded := *cfg
+		reloaded.Pools = []lb.PoolConfig{fallback, gated}
+		return reloaded, nil
+	})
+	result, err := c.balancer.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := strings.Join(result.Changes, "\n"); !strings.Contains(changes, "后端池 gated") {
+		t.Errorf("只修改 protocol_versions 的重新加载应更新后端池: %v", result.Changes)
+	}
+	expectNode("pv-reload-1", 1, node1)
+	expectNode("pv-reload-2", 2, node2)
+
+	// 版本范围不变时重新加载不再更新池
+	if result, err = c.balancer.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Changes) != 0 {
+		t.Errorf("配置未变化时不应有变更: %v", result.Changes)
+	}
+}
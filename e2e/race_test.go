@@ -0,0 +1,106 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/perf"
+)
+
+// TestConcurrentState 客户端频繁连接断开、节点上下线的同时并发查询各个管理API和发送指令，
+// 以 go test -race 运行时验证共享状态的并发访问
+func TestConcurrentState(t *testing.T) {
+	c := startCluster(t, lb.LeastConn, 3)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	run := func(fn func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ctx.Err() == nil; i++ {
+				fn(i)
+			}
+		}()
+	}
+
+	// 客户端反复连接和断开
+	for w := 0; w < 4; w++ {
+		w := w
+		tc := c.connect(fmt.Sprintf("churn-%d", w))
+		run(func(i int) {
+			tc.Close()
+			<-tc.disconnected()
+			if ctx.Err() != nil {
+				return
+			}
+			if err := tc.ConnectToLoadBalancer(); err != nil {
+				time.Sleep(10 * time.Millisecond)
+				return
+			}
+			handled := make(chan struct{})
+			tc.mu.Lock()
+			tc.handled = handled
+			tc.mu.Unlock()
+			go func() {
+				defer close(handled)
+				tc.HandleServerMessages()
+			}()
+			time.Sleep(5 * time.Millisecond)
+		})
+	}
+	// 稳定连接的客户端持续接收指令
+	target := c.connect("race-target")
+	run(func(i int) {
+		node := c.nodes[c.order[i%len(c.order)]]
+		node.admin.SendCommandAndWait(ctx, target.id, "ping", nil, time.Second)
+	})
+	// 并发查询节点和负载均衡器的管理API
+	paths := []string{"/api/clients", "/api/global-clients", "/api/node-info", "/api/metrics", "/api/latency",
+		"/api/query?client_id=" + target.id, "/api/latency?client_id=" + target.id, "/health"}
+	for _, id := range c.order {
+		port := c.nodes[id].port
+		run(func(i int) { get(c.httpURL(port) + paths[i%len(paths)]) })
+	}
+	// 重命名与查询客户端列表并发
+	run(func(i int) {
+		body := strings.NewReader(fmt.Sprintf(`{"name": "race-target-%d", "updated_by": "e2e"}`, i))
+		req, _ := http.NewRequestWithContext(ctx, "PUT", c.httpURL(c.nodes[c.order[0]].port)+"/api/clients/"+target.id+"/name", body)
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+		time.Sleep(10 * time.Millisecond)
+	})
+	lbPaths := []string{"/api/backends", "/api/all-clients", "/api/global-clients", "/api/sessions", "/api/cluster-stats", "/api/status", "/api/pools", "/health"}
+	run(func(i int) { get(c.httpURL(c.lbPort) + lbPaths[i%len(lbPaths)]) })
+	// 运行时修改后端权重，与健康检查和后端选择并发
+	run(func(i int) {
+		c.admin.PutBackend(ctx, lb.BackendState{ID: c.order[0], Host: "127.0.0.1", Port: c.nodes[c.order[0]].port, Weight: 1 + i%3})
+		time.Sleep(20 * time.Millisecond)
+	})
+	// 同一进程中创建其他负载均衡器并设置性能参数，与正在转发的连接并发
+	settings, err := perf.Config{CopyBufferSize: 16 * 1024}.Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(func(i int) {
+		lb.New(0, lb.RoundRobin).SetPerformance(settings)
+		time.Sleep(5 * time.Millisecond)
+	})
+
+	wg.Wait()
+}
+
+func get(url string) {
+	resp, err := http.Get(url)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
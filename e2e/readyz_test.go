@@ -0,0 +1,115 @@
+package e2e
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/client"
+	"websocket-loadbalance/registry"
+	"websocket-loadbalance/server"
+)
+
+// TestRegistryUnavailable 注册表文件不可写时进入降级状态：默认策略的节点照常接受注册，
+// /readyz 返回200并标记 registry_degraded；reject 策略的节点返回503并以1013拒绝新注册。
+// 文件恢复可写后暂存的操作写回，降级状态解除
+func TestRegistryUnavailable(t *testing.T) {
+	c := startClusterWith(t, 0, nil, nil)
+	accept := c.startNode(t.Name() + "-accept")
+	c.setup = func(s *server.Server) { s.SetRegistryUnavailablePolicy(server.RegistryUnavailableReject) }
+	reject := c.startNode(t.Name() + "-reject")
+
+	readyz := func(n *node) (int, map[string]interface{}) {
+		t.Helper()
+		resp, err := http.Get(c.httpURL(n.port) + "/readyz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, body
+	}
+	if status, _ := readyz(reject); status != http.StatusOK {
+		t.Fatalf("注册表可用时 /readyz 应返回200, 实际 %d", status)
+	}
+
+	// 把注册表文件所在的目录移走，写回失败
+	dir := filepath.Dir(registryFile)
+	if err := os.Rename(dir, dir+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	restored := false
+	restore := func() {
+		if !restored {
+			restored = true
+			os.Rename(dir+".moved", dir)
+		}
+	}
+	t.Cleanup(restore)
+
+	wsURL := fmt.Sprintf("ws://127.0.0.1:%d/ws", accept.port)
+	cl, err := client.New(wsURL, wsURL, "degraded-accepted", "degraded-accepted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := &testClient{Client: cl, id: "degraded-accepted"}
+	tc.dial(c)
+	t.Cleanup(tc.close)
+	c.waitFor("注册表进入降级状态", registry.Degraded)
+
+	if status, body := readyz(accept); status != http.StatusOK || body["registry_degraded"] != true {
+		t.Errorf("accept 策略下 /readyz 应返回200且 registry_degraded=true, 实际 %d %v", status, body)
+	}
+	if status, body := readyz(reject); status != http.StatusServiceUnavailable || body["registry_degraded"] != true {
+		t.Errorf("reject 策略下 /readyz 应返回503, 实际 %d %v", status, body)
+	}
+	if health := registry.Health(); health.PendingOps == 0 || health.LastError == "" {
+		t.Errorf("降级期间应有待写回的操作和失败原因: %+v", health)
+	}
+
+	// reject 策略的节点以错误消息和1013拒绝新注册
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws", reject.port), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteJSON(map[string]interface{}{"client_id": "degraded-rejected"}); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var rejection map[string]interface{}
+	if err := conn.ReadJSON(&rejection); err != nil || rejection["code"] != "registry_unavailable" {
+		t.Errorf("应收到 registry_unavailable 错误, 收到 %v err=%v", rejection, err)
+	}
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseTryAgainLater {
+		t.Errorf("应以1013关闭连接, err=%v", err)
+	}
+
+	restore()
+	c.waitFor("注册表恢复可用", func() bool {
+		health := registry.Health()
+		return !health.Degraded && health.PendingOps == 0
+	})
+	if status, _ := readyz(reject); status != http.StatusOK {
+		t.Errorf("注册表恢复后 /readyz 应返回200, 实际 %d", status)
+	}
+	saved, err := registry.ReadFile(registryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := saved["degraded-accepted"]; !ok {
+		t.Error("降级期间的注册应在恢复后写回注册表文件")
+	}
+}
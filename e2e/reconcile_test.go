@@ -0,0 +1,67 @@
+package e2e
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+	"websocket-loadbalance/server"
+)
+
+// TestRegistryReconcile 节点定期对账：补回注册表中丢失的本节点客户端，删除已不再连接的遗留记录，
+// 客户端在其他节点上有更新的记录时不覆盖；偏差计入 /api/metrics 的 registry_reconcile
+func TestRegistryReconcile(t *testing.T) {
+	c := startClusterWith(t, 1, nil, func(s *server.Server) {
+		s.SetRegistryReconcile(server.RegistryReconcileConfig{Enabled: true, Interval: protocol.Duration(100 * time.Millisecond)})
+	})
+	n := c.nodes[c.order[0]]
+	tc := c.connect("reconcile-live")
+	onNode := func(clientID, nodeID string) func() bool {
+		return func() bool {
+			info, ok := registry.Get(clientID)
+			return ok && info.NodeID == nodeID
+		}
+	}
+	c.waitFor("客户端写入注册表", onNode(tc.id, n.id))
+
+	// 记录丢失（如注册表文件被其他进程覆盖）
+	registry.Unregister(tc.id)
+	c.waitFor("补回丢失的记录", onNode(tc.id, n.id))
+
+	// 节点崩溃重启后遗留的记录
+	registry.Register("reconcile-ghost", "reconcile-ghost", "", n.id, n.port, nil, nil, "")
+	c.waitFor("删除遗留记录", func() bool {
+		_, ok := registry.Get("reconcile-ghost")
+		return !ok
+	})
+
+	// 客户端已重连到其他节点（记录更新），本节点的旧连接不应覆盖它
+	registry.Register(tc.id, tc.id, "", "reconcile-other-node", 1, nil, nil, "")
+	time.Sleep(300 * time.Millisecond)
+	if !onNode(tc.id, "reconcile-other-node")() {
+		t.Error("注册表中更新的其他节点记录不应被对账覆盖")
+	}
+
+	resp, err := http.Get(c.httpURL(n.port) + "/api/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var metrics struct {
+		Reconcile struct {
+			Runs    int64                    `json:"runs"`
+			Added   int64                    `json:"added"`
+			Removed int64                    `json:"removed"`
+			Recent  []server.ReconcileReport `json:"recent"`
+		} `json:"registry_reconcile"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&metrics); err != nil {
+		t.Fatal(err)
+	}
+	if r := metrics.Reconcile; r.Runs == 0 || r.Added != 1 || r.Removed != 1 || len(r.Recent) != 2 {
+		t.Errorf("对账统计应记录1次补回和1次删除: %+v", r)
+	}
+}
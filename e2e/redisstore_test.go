@@ -0,0 +1,260 @@
+package e2e
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"websocket-loadbalance/lb"
+)
+
+// fakeRedis 最小化的RESP服务端，支持 AUTH、SELECT、GET、SET，设置了密码时未认证的命令返回NOAUTH
+type fakeRedis struct {
+	t        *testing.T
+	password string
+
+	mu       sync.Mutex
+	listener net.Listener
+	addr     string
+	conns    map[net.Conn]bool
+	accepted int
+	data     map[int]map[string]string // 数据库编号 -> key -> value
+}
+
+func startFakeRedis(t *testing.T, password string) *fakeRedis {
+	f := &fakeRedis{t: t, password: password, conns: make(map[net.Conn]bool), data: make(map[int]map[string]string)}
+	f.listen("127.0.0.1:0")
+	t.Cleanup(f.stop)
+	return f
+}
+
+func (f *fakeRedis) listen(addr string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.mu.Lock()
+	f.listener, f.addr = listener, listener.Addr().String()
+	f.mu.Unlock()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns[conn] = true
+			f.accepted++
+			f.mu.Unlock()
+			go f.serve(conn)
+		}
+	}()
+}
+
+// dropConns 断开全部已建立的连接，模拟Redis重启或空闲连接被断开
+func (f *fakeRedis) dropConns() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for conn := range f.conns {
+		conn.Close()
+		delete(f.conns, conn)
+	}
+}
+
+func (f *fakeRedis) stop() {
+	f.mu.Lock()
+	f.listener.Close()
+	f.mu.Unlock()
+	f.dropConns()
+}
+
+func (f *fakeRedis) connections() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.accepted
+}
+
+func (f *fakeRedis) get(db int, key string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, ok := f.data[db][key]
+	return value, ok
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authed := f.password == ""
+	db := 0
+	for {
+		args, err := readRESPCommand(reader)
+		if err != nil {
+			return
+		}
+		var reply string
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "AUTH":
+			if len(args) == 2 && args[1] == f.password {
+				authed = true
+				reply = "+OK\r\n"
+			} else {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case !authed:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case cmd == "SELECT":
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n > 15 {
+				reply = "-ERR DB index is out of range\r\n"
+				break
+			}
+			db = n
+			reply = "+OK\r\n"
+		case cmd == "GET":
+			value, ok := f.get(db, args[1])
+			if !ok {
+				reply = "$-1\r\n"
+			} else {
+				reply = fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+			}
+		case cmd == "SET":
+			f.mu.Lock()
+			if f.data[db] == nil {
+				f.data[db] = make(map[string]string)
+			}
+			f.data[db][args[1]] = args[2]
+			f.mu.Unlock()
+			reply = "+OK\r\n"
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// readRESPCommand 读取一条以RESP数组发送的命令
+func readRESPCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("无效的命令: %q", line)
+	}
+	args := make([]string, count)
+	for i := range args {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+// TestRedisSessionStore Redis会话存储按配置认证并选择数据库，连接在命令之间复用，
+// 连接被断开或Redis暂时不可用后重新连接
+func TestRedisSessionStore(t *testing.T) {
+	redis := startFakeRedis(t, "s3cret")
+	t.Setenv("E2E_REDIS_PASSWORD", "s3cret")
+
+	newStore := func(password string, db int) lb.SessionStore {
+		t.Helper()
+		cfg := lb.SessionConfig{Store: "redis", RedisAddr: redis.addr, RedisPassword: password, RedisDB: db}
+		if err := cfg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		store, err := lb.NewSessionStore(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.(io.Closer).Close() })
+		return store
+	}
+
+	if _, err := newStore("wrong", 0).Load(); err == nil || !strings.Contains(err.Error(), "认证失败") {
+		t.Errorf("密码错误时应返回认证失败: %v", err)
+	}
+	if _, err := newStore("", 0).Load(); err == nil || !strings.Contains(err.Error(), "NOAUTH") {
+		t.Errorf("未配置密码时应返回Redis的NOAUTH错误: %v", err)
+	}
+	if err := (lb.SessionConfig{Store: "redis", RedisDB: -1}).Validate(); err == nil {
+		t.Error("redis_db 为负数时配置应无效")
+	}
+
+	store := newStore("env:E2E_REDIS_PASSWORD", 3)
+	connsBefore := redis.connections()
+	if sessions, err := store.Load(); err != nil || sessions != nil {
+		t.Fatalf("key不存在时应返回空会话: %v %v", sessions, err)
+	}
+	now := time.Now().Truncate(time.Second)
+	save := func(backendID string) {
+		t.Helper()
+		sessions := map[string]*lb.Session{
+			"client:redis-a": {SessionID: "client:redis-a", BackendID: backendID, CreateTime: now, LastSeen: now},
+		}
+		if err := store.Save(sessions); err != nil {
+			t.Fatalf("保存会话失败: %v", err)
+		}
+	}
+	// load 读回会话并检查绑定的后端
+	load := func(wantBackend string) {
+		t.Helper()
+		sessions, err := store.Load()
+		if err != nil {
+			t.Fatalf("加载会话失败: %v", err)
+		}
+		if s := sessions["client:redis-a"]; s == nil || s.BackendID != wantBackend || !s.LastSeen.Equal(now) {
+			t.Errorf("加载的会话应绑定到 %s: %+v", wantBackend, s)
+		}
+	}
+
+	save("node1")
+	load("node1")
+	if _, ok := redis.get(3, "lb:sessions"); !ok {
+		t.Error("会话应保存在 redis_db 指定的数据库中")
+	}
+	if _, ok := redis.get(0, "lb:sessions"); ok {
+		t.Error("会话不应保存在默认数据库中")
+	}
+	if got := redis.connections() - connsBefore; got != 1 {
+		t.Errorf("多条命令应复用同一个连接, 实际建立了 %d 个", got)
+	}
+
+	// 连接被服务端断开：下一条命令重新连接、认证并选择数据库
+	redis.dropConns()
+	save("node2")
+	load("node2")
+	if got := redis.connections() - connsBefore; got != 2 {
+		t.Errorf("连接断开后应重新连接一次, 实际共建立了 %d 个", got)
+	}
+
+	// Redis不可用期间返回错误，恢复后重新连接
+	redis.stop()
+	if err := store.Save(nil); err == nil {
+		t.Error("Redis不可用时保存应返回错误")
+	}
+	redis.listen(redis.addr)
+	save("node3")
+	load("node3")
+	if value, _ := redis.get(3, "lb:sessions"); !strings.Contains(value, "node3") {
+		t.Errorf("Redis恢复后会话应保存成功: %s", value)
+	}
+}
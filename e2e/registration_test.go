@@ -0,0 +1,75 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestRegistrationValidation 注册消息先按模式校验：字段类型不符时回复 invalid_message 并关闭连接；
+// 很短的客户端ID可以正常注册，默认名称取整个ID
+func TestRegistrationValidation(t *testing.T) {
+	c := startClusterWith(t, 1, nil, nil)
+	n := c.nodes[c.order[0]]
+
+	// register 直接连接节点发送注册消息，返回连接和节点随后发来的第一条消息（没有消息时为nil）
+	register := func(regMsg map[string]interface{}) (*websocket.Conn, map[string]interface{}) {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws", n.port), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		if err := conn.WriteJSON(regMsg); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		var msg map[string]interface{}
+		if err := conn.ReadJSON(&msg); err != nil {
+			return conn, nil
+		}
+		conn.SetReadDeadline(time.Time{})
+		return conn, msg
+	}
+
+	_, msg := register(map[string]interface{}{"client_id": 42})
+	if msg == nil || msg["code"] != "invalid_message" {
+		t.Errorf("client_id 不是字符串时应以 invalid_message 拒绝: %v", msg)
+	}
+	_, msg = register(map[string]interface{}{"type": "subscribe", "client_id": "reg-typed"})
+	if msg == nil || msg["code"] != "invalid_message" {
+		t.Errorf("type 不是 register 的注册消息应被拒绝: %v", msg)
+	}
+
+	// 读超时后gorilla连接不可再用，短ID的连接不经 register 等待回复
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws", n.port), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteJSON(map[string]interface{}{"type": "register", "client_id": "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	c.waitFor("短ID注册", func() bool { return c.nodeOf("abc") == n.id })
+	list, err := n.admin.ListClients(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range list.Clients {
+		if info.ID == "abc" && info.Name != "客户端_abc" {
+			t.Errorf("短ID的默认名称应为 客户端_abc, 实际 %q", info.Name)
+		}
+	}
+	// 连接仍然可用
+	if err := conn.WriteJSON(map[string]interface{}{"type": "heartbeat"}); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var ack map[string]interface{}
+	if err := conn.ReadJSON(&ack); err != nil || ack["type"] != "heartbeat_ack" {
+		t.Errorf("注册短ID后连接应保持可用: %v %v", ack, err)
+	}
+}
@@ -0,0 +1,95 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/registry"
+)
+
+// TestRegistryBatchedWrites 一批客户端的注册在一个写回间隔内合并为少数几次写文件，
+// 写回后文件包含所有客户端，目录中不留下临时文件
+func TestRegistryBatchedWrites(t *testing.T) {
+	c := startCluster(t, lb.RoundRobin, 1)
+	registry.Flush()
+	before := registry.PersistStats()
+
+	const clients = 10
+	for i := 0; i < clients; i++ {
+		c.connect(fmt.Sprintf("persist-client-%d", i))
+	}
+
+	var saved map[string]*registry.ClientInfo
+	c.waitFor("注册记录写回文件", func() bool {
+		var err error
+		if saved, err = registry.ReadFile(registryFile); err != nil {
+			return false
+		}
+		for i := 0; i < clients; i++ {
+			if _, ok := saved[fmt.Sprintf("persist-client-%d", i)]; !ok {
+				return false
+			}
+		}
+		return true
+	})
+
+	after := registry.PersistStats()
+	changes := after["changes"].(int64) - before["changes"].(int64)
+	writes := after["writes"].(int64) - before["writes"].(int64)
+	if changes < clients || writes >= changes/2 {
+		t.Errorf("注册应合并写回: %d 次修改写了 %d 次文件", changes, writes)
+	}
+
+	if tmp, _ := filepath.Glob(filepath.Join(filepath.Dir(registryFile), ".*.tmp-*")); len(tmp) != 0 {
+		t.Errorf("写回后不应留下临时文件: %v", tmp)
+	}
+}
+
+// TestRegistryRebuild 注册表文件带校验和，被改动的文件读取时报告损坏，写回时同时保留备份；
+// 丢失的记录由负载均衡器按节点上报的在线客户端重建
+func TestRegistryRebuild(t *testing.T) {
+	c := startCluster(t, lb.RoundRobin, 2)
+	ids := []string{"rebuild-a", "rebuild-b", "rebuild-c"}
+	for _, id := range ids {
+		c.connect(id)
+	}
+	registry.Flush()
+
+	data, err := os.ReadFile(registryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := filepath.Join(t.TempDir(), "clients.json")
+	if err := os.WriteFile(tampered, bytes.Replace(data, []byte("rebuild-a"), []byte("rebuild-x"), 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registry.ReadFile(tampered); err == nil {
+		t.Error("被改动的注册表文件应校验失败")
+	}
+	if _, err := registry.ReadFile(registryFile + ".bak.1"); err != nil {
+		t.Errorf("写回注册表文件后应保留可用的备份: %v", err)
+	}
+
+	// 模拟随损坏的文件丢失的记录
+	for _, id := range ids {
+		registry.Unregister(id)
+	}
+	result, err := c.admin.RebuildRegistry(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Nodes) != 2 || len(result.Failed) != 0 {
+		t.Errorf("应按两个节点的上报重建: %+v", result)
+	}
+	for _, id := range ids {
+		info, ok := registry.Get(id)
+		if !ok || !info.IsActive || info.NodeID != c.nodeOf(id) || info.NodePort != c.nodes[info.NodeID].port {
+			t.Errorf("客户端 %s 的记录没有正确重建: %+v", id, info)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"websocket-loadbalance/client"
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/pkg/adminclient"
+	"websocket-loadbalance/registry"
+)
+
+// TestRegistryPagination 按游标分页读取和NDJSON导出的全局注册表按客户端ID排序，
+// 不重复、不遗漏，namespace 过滤后只包含该命名空间的客户端
+func TestRegistryPagination(t *testing.T) {
+	c := startCluster(t, lb.RoundRobin, 2)
+	ctx := context.Background()
+	const namespace = "paging"
+
+	var want []string
+	for i := 0; i < 7; i++ {
+		id := fmt.Sprintf("page-client-%d", i)
+		c.connectWith(id, id, client.Options{Namespace: namespace})
+		want = append(want, id)
+	}
+	sort.Strings(want)
+	c.waitFor("客户端注册到全局注册表", func() bool {
+		list, err := c.admin.GlobalClientsPage(ctx, adminclient.ClientPageQuery{Namespace: namespace})
+		return err == nil && list.Total == len(want)
+	})
+
+	var paged []string
+	query := adminclient.ClientPageQuery{Limit: 3, Namespace: namespace}
+	for pages := 1; ; pages++ {
+		list, err := c.admin.GlobalClientsPage(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if list.Total != len(want) || list.Count != len(list.Clients) || list.Count > 3 {
+			t.Fatalf("第 %d 页不正确: total=%d count=%d clients=%d", pages, list.Total, list.Count, len(list.Clients))
+		}
+		for _, info := range list.Clients {
+			paged = append(paged, info.ID)
+		}
+		if list.NextCursor == "" {
+			if pages != 3 {
+				t.Errorf("7个客户端每页3个应分3页，实际 %d 页", pages)
+			}
+			break
+		}
+		query.Cursor = list.NextCursor
+	}
+	if fmt.Sprint(paged) != fmt.Sprint(want) {
+		t.Errorf("分页结果不正确:\n得到 %v\n期望 %v", paged, want)
+	}
+
+	// 节点共用同一个注册表，导出结果与分页一致
+	var exported []string
+	err := c.nodes[c.order[0]].admin.ExportGlobalClients(ctx, adminclient.ClientPageQuery{Namespace: namespace}, func(info registry.ClientInfo) error {
+		exported = append(exported, info.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(exported) != fmt.Sprint(want) {
+		t.Errorf("导出结果不正确:\n得到 %v\n期望 %v", exported, want)
+	}
+}
@@ -0,0 +1,142 @@
+package e2e
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/pkg/adminclient"
+)
+
+// TestSessionReset 会话列表带有会话时长和转发中的连接数；重置会话只删除绑定，连接不受影响，
+// 带 disconnect 时以1012关闭按该会话转发中的连接，不存在的会话返回404
+func TestSessionReset(t *testing.T) {
+	c := startCluster(t, lb.RoundRobin, 2)
+	ctx := context.Background()
+	const sessionID = "client:session-reset"
+
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws?client_id=session-reset", c.lbPort), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteJSON(map[string]interface{}{"client_id": "session-reset"}); err != nil {
+		t.Fatal(err)
+	}
+	c.waitFor("客户端 session-reset 注册", func() bool { return c.nodeOf("session-reset") != "" })
+
+	session := func() *lb.SessionInfo {
+		list, err := c.admin.Sessions(ctx, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range list.Sessions {
+			if list.Sessions[i].SessionID == sessionID {
+				return &list.Sessions[i]
+			}
+		}
+		return nil
+	}
+	c.waitFor("会话记录转发中的连接", func() bool {
+		s := session()
+		return s != nil && s.Connections == 1
+	})
+	if s := session(); s.BackendID != c.nodeOf("session-reset") {
+		t.Errorf("会话应绑定到客户端所在的节点 %s, 实际 %+v", c.nodeOf("session-reset"), s)
+	}
+
+	// 只删除绑定
+	result, err := c.admin.ResetSession(ctx, sessionID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Removed || result.ConnectionsClosed != 0 {
+		t.Errorf("应只删除会话保持记录: %+v", result)
+	}
+	if session() != nil {
+		t.Error("重置后会话列表中不应再有该会话")
+	}
+	if c.nodeOf("session-reset") == "" {
+		t.Error("只删除绑定时连接不应断开")
+	}
+
+	// 绑定已删除，连接仍在转发，强制断开
+	result, err = c.admin.ResetSession(ctx, sessionID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Removed || result.ConnectionsClosed != 1 {
+		t.Errorf("应关闭1个连接: %+v", result)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err = conn.ReadMessage()
+		if err != nil {
+			break
+		}
+	}
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseServiceRestart {
+		t.Errorf("强制断开应以1012关闭连接, err=%v", err)
+	}
+	c.waitFor("客户端从节点注销", func() bool { return c.nodeOf("session-reset") == "" })
+
+	if _, err := c.admin.ResetSession(ctx, sessionID, true); !adminclient.IsNotFound(err) {
+		t.Errorf("会话和连接都不存在时应返回404, err=%v", err)
+	}
+}
+
+// TestSessionResetPool 非默认池的会话以 池名/ 为前缀，列表中统计其转发中的连接，强制断开时关闭该连接
+func TestSessionResetPool(t *testing.T) {
+	c := startClusterWith(t, 2, func(cfg *lb.Config) {
+		cfg.Pools = []lb.PoolConfig{{Name: "chat", PathPrefix: "/ws"}}
+	}, nil)
+	ctx := context.Background()
+	const sessionID = "chat/client:pool-reset"
+
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws?client_id=pool-reset", c.lbPort), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteJSON(map[string]interface{}{"client_id": "pool-reset"}); err != nil {
+		t.Fatal(err)
+	}
+	c.waitFor("客户端 pool-reset 注册", func() bool { return c.nodeOf("pool-reset") != "" })
+	c.waitFor("池内会话记录转发中的连接", func() bool {
+		list, err := c.admin.Sessions(ctx, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range list.Sessions {
+			if s.SessionID == sessionID {
+				return s.Connections == 1
+			}
+		}
+		return false
+	})
+
+	result, err := c.admin.ResetSession(ctx, sessionID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Removed || result.ConnectionsClosed != 1 {
+		t.Errorf("应删除池内的会话并关闭1个连接: %+v", result)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err = conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseServiceRestart {
+		t.Errorf("强制断开应以1012关闭池内的连接, err=%v", err)
+	}
+	c.waitFor("客户端从节点注销", func() bool { return c.nodeOf("pool-reset") == "" })
+}
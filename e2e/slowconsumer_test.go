@@ -0,0 +1,59 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/pkg/adminclient"
+	"websocket-loadbalance/server"
+)
+
+// TestWriteTimeout 停止读取的客户端写满TCP缓冲区后，发送指令的请求最多阻塞一个写超时，
+// 随后节点断开该连接并计入 write_timeouts，之后的指令不再卡在该连接上
+func TestWriteTimeout(t *testing.T) {
+	const writeTimeout = 300 * time.Millisecond
+	c := startClusterWith(t, 1, nil, func(s *server.Server) {
+		s.SetWriteTimeout(writeTimeout)
+	})
+	n := c.nodes[c.order[0]]
+	ctx := context.Background()
+
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws", n.port), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	const stuckID = "stuck-reader"
+	if err := conn.WriteJSON(map[string]interface{}{"client_id": stuckID, "client_name": stuckID}); err != nil {
+		t.Fatal(err)
+	}
+	c.waitFor("客户端 "+stuckID+" 注册", func() bool { return c.nodeOf(stuckID) != "" })
+
+	payload := strings.Repeat("x", 256*1024)
+	disconnected := false
+	for i := 0; i < 200 && !disconnected; i++ {
+		start := time.Now()
+		_, err := n.admin.SendCommand(ctx, adminclient.CommandRequest{ClientID: stuckID, Command: "sync", Data: payload})
+		if elapsed := time.Since(start); elapsed > writeTimeout+2*time.Second {
+			t.Fatalf("第 %d 条指令阻塞了 %v", i+1, elapsed)
+		}
+		disconnected = err != nil
+	}
+	if !disconnected {
+		t.Fatal("客户端一直不读取，指令却始终发送成功")
+	}
+
+	c.waitFor("写超时的客户端被注销", func() bool { return c.nodeOf(stuckID) == "" })
+	metrics, err := n.admin.Metrics(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slow := metrics.SlowConsumer; slow["write_timeouts"] != float64(1) || slow["write_timeout"] != writeTimeout.String() {
+		t.Errorf("写超时统计不正确: %v", slow)
+	}
+}
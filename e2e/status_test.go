@@ -0,0 +1,70 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/lb"
+)
+
+// TestClusterStatus 节点逐个停止时集群状态从正常变为降级再变为不可用，
+// 无法建立的连接计入错误率，状态页在不可用时返回503
+func TestClusterStatus(t *testing.T) {
+	c := startClusterWith(t, 2, func(cfg *lb.Config) {
+		cfg.Status.Title = "测试集群"
+	}, nil)
+	c.connect("status-client")
+
+	var status *lb.ClusterStatus
+	c.waitFor("注册表与节点上报的连接一致", func() bool {
+		var err error
+		status, err = c.admin.ClusterStatus(context.Background())
+		return err == nil && status.Components.Registry.ReportedClients == 1
+	})
+	if status.Status != lb.StatusOperational || status.Score != 100 || status.Title != "测试集群" {
+		t.Fatalf("所有后端健康时集群应为正常且健康分数为100: %+v", status)
+	}
+	if r := status.Components.Registry; r.RegisteredClients != 1 || r.MismatchedClients != 0 {
+		t.Errorf("注册表状态不正确: %+v", r)
+	}
+	if conns := status.Components.Connections; conns.Attempts == 0 || conns.Failures != 0 {
+		t.Errorf("连接统计应包含一次成功的连接: %+v", conns)
+	}
+
+	c.stopNode(c.order[0])
+	c.waitFor("一个后端不健康后集群降级", func() bool {
+		var err error
+		status, err = c.admin.ClusterStatus(context.Background())
+		return err == nil && status.Status == lb.StatusDegraded
+	})
+	if b := status.Components.Backends; b.Healthy != 1 || b.Total != 2 || b.Ratio != 0.5 || status.Score >= 100 {
+		t.Errorf("降级时的后端统计或健康分数不正确: score=%.1f %+v", status.Score, b)
+	}
+
+	c.stopNode(c.order[1])
+	c.waitFor("所有后端不健康后集群不可用", func() bool {
+		var err error
+		status, err = c.admin.ClusterStatus(context.Background())
+		return err == nil && status.Status == lb.StatusOutage
+	})
+	if conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws", c.lbPort), nil); err == nil {
+		conn.Close()
+	}
+	if status, _ = c.admin.ClusterStatus(context.Background()); status == nil || status.Components.Connections.Failures == 0 {
+		t.Errorf("没有可用后端时的连接应计入失败: %+v", status)
+	}
+
+	resp, err := http.Get(c.httpURL(c.lbPort) + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("集群不可用时状态页应返回503的HTML, 实际 %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
@@ -0,0 +1,64 @@
+package e2e
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/pkg/adminclient"
+	"websocket-loadbalance/server"
+)
+
+// TestBuildVersionSkew 节点上报的构建版本不一致时负载均衡器记录 version_skew，
+// 低于 min_backend_version 的后端记录 backend_outdated，旧版本节点下线后分歧消除
+func TestBuildVersionSkew(t *testing.T) {
+	c := startClusterWith(t, 3, func(cfg *lb.Config) {
+		cfg.MinBackendVersion = "v1.4.0"
+	}, func(s *server.Server) {
+		if strings.HasSuffix(s.NodeID(), "-node3") {
+			s.SetBuildVersion("v1.3.9")
+		} else {
+			s.SetBuildVersion("v1.4.2")
+		}
+	})
+	old := c.order[2]
+	ctx := context.Background()
+
+	var status *lb.BuildVersionStatus
+	c.waitFor("发现构建版本分歧", func() bool {
+		var err error
+		status, err = c.admin.BuildVersions(ctx)
+		return err == nil && status.Skewed
+	})
+	if len(status.Versions["v1.4.2"]) != 2 || len(status.Versions["v1.3.9"]) != 1 {
+		t.Fatalf("按版本分组不正确: %v", status.Versions)
+	}
+	if len(status.Outdated) != 1 || status.Outdated[0] != old {
+		t.Fatalf("低于最低版本的后端应为 %s: %v", old, status.Outdated)
+	}
+
+	events := func(eventType string) []lb.TimelineEvent {
+		list, err := c.admin.Timeline(ctx, adminclient.TimelineQuery{Types: []string{eventType}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return list
+	}
+	if list := events(lb.EventBackendOutdated); len(list) != 1 || list[0].BackendID != old {
+		t.Fatalf("应记录一次 %s 的 backend_outdated 事件: %+v", old, list)
+	}
+	if list := events(lb.EventVersionSkew); len(list) != 1 {
+		t.Fatalf("应记录一次 version_skew 事件: %+v", list)
+	}
+
+	// 旧版本节点下线后健康后端的版本一致
+	c.stopNode(old)
+	c.waitFor("构建版本分歧消除", func() bool {
+		return len(events(lb.EventVersionSkewResolved)) == 1
+	})
+	status, err := c.admin.BuildVersions(ctx)
+	if err != nil || status.Skewed {
+		t.Fatalf("分歧应已消除: %+v %v", status, err)
+	}
+}
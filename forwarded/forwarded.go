@@ -0,0 +1,196 @@
+// Package forwarded 确定经代理转发的请求的真实客户端地址：只采信受信代理给出的
+// X-Forwarded-For、X-Real-IP 请求头和PROXY协议头，并在继续转发时添加这些请求头
+package forwarded
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// 转发请求头
+const (
+	HeaderForwardedFor   = "X-Forwarded-For"
+	HeaderRealIP         = "X-Real-IP"
+	HeaderForwardedProto = "X-Forwarded-Proto"
+	HeaderForwardedHost  = "X-Forwarded-Host"
+)
+
+// Config 受信代理和PROXY协议配置
+type Config struct {
+	// 受信代理的IP或CIDR，如 10.0.0.0/8、127.0.0.1。只有来自这些地址的连接携带的
+	// X-Forwarded-For、X-Real-IP 请求头和PROXY协议头才被采信，为空表示不采信任何转发信息
+	TrustedProxies []string `json:"trusted_proxies" yaml:"trusted_proxies"`
+	// 监听端口接受PROXY协议v1/v2头（如前面是AWS NLB、HAProxy等四层代理）。
+	// 头只在受信代理的连接上解析，没有头的连接按普通连接处理
+	ProxyProtocol bool `json:"proxy_protocol" yaml:"proxy_protocol"`
+}
+
+// Validate 校验受信代理地址
+func (c Config) Validate() error {
+	if c.ProxyProtocol && len(c.TrustedProxies) == 0 {
+		return fmt.Errorf("forwarded: 启用 proxy_protocol 时 trusted_proxies 不能为空")
+	}
+	_, err := parseNetworks(c.TrustedProxies)
+	return err
+}
+
+// Resolver 按受信代理解析客户端地址，方法对nil安全（nil表示不信任任何代理，客户端地址即TCP对端地址）
+type Resolver struct {
+	trusted       []*net.IPNet
+	proxyProtocol bool
+}
+
+// New 按配置创建解析器，未配置受信代理时返回nil
+func New(cfg Config) (*Resolver, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	trusted, _ := parseNetworks(cfg.TrustedProxies)
+	if len(trusted) == 0 {
+		return nil, nil
+	}
+	return &Resolver{trusted: trusted, proxyProtocol: cfg.ProxyProtocol}, nil
+}
+
+// parseNetworks 解析IP或CIDR列表，单个IP视为/32或/128
+func parseNetworks(entries []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("forwarded: 无效的受信代理地址: %q", entry)
+			}
+			bits := 128
+			if v4 := ip.To4(); v4 != nil {
+				ip, bits = v4, 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("forwarded: 无效的受信代理地址: %q", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Trusted ip是否为受信代理
+func (res *Resolver) Trusted(ip net.IP) bool {
+	if res == nil || ip == nil {
+		return false
+	}
+	for _, network := range res.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// PeerIP 请求的TCP对端地址（经PROXY协议转发时为头中的源地址），不含端口
+func PeerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ClientIP 真实客户端地址：对端是受信代理时，从右向左跳过 X-Forwarded-For 中的受信代理，
+// 取第一个不受信的地址；没有 X-Forwarded-For 时取 X-Real-IP。其余情况为对端地址
+func (res *Resolver) ClientIP(r *http.Request) string {
+	peer := PeerIP(r)
+	if !res.Trusted(net.ParseIP(peer)) {
+		return peer
+	}
+	chain := forwardedChain(r.Header)
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := net.ParseIP(chain[i])
+		if ip == nil {
+			// 格式错误的条目之前的内容不可信
+			break
+		}
+		if !res.Trusted(ip) {
+			return chain[i]
+		}
+		peer = chain[i]
+	}
+	if len(chain) == 0 {
+		if realIP := strings.TrimSpace(r.Header.Get(HeaderRealIP)); net.ParseIP(realIP) != nil {
+			return realIP
+		}
+	}
+	return peer
+}
+
+// forwardedChain 按顺序展开所有 X-Forwarded-For 请求头中的地址
+func forwardedChain(header http.Header) []string {
+	var chain []string
+	for _, value := range header.Values(HeaderForwardedFor) {
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				chain = append(chain, entryHost(entry))
+			}
+		}
+	}
+	return chain
+}
+
+// entryHost 去掉条目中可能带有的端口和IPv6方括号
+func entryHost(entry string) string {
+	if host, _, err := net.SplitHostPort(entry); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(entry, "["), "]")
+}
+
+// PrepareProxyRequest 为交给 httputil.ReverseProxy 转发的请求设置转发请求头。
+// ReverseProxy 会在 X-Forwarded-For 末尾追加对端地址，这里只保留受信代理给出的部分
+func (res *Resolver) PrepareProxyRequest(r *http.Request) {
+	res.setHeaders(r.Header, r, false)
+}
+
+// SetUpgradeHeaders 为转发的WebSocket握手设置转发请求头，X-Forwarded-For 末尾为对端地址
+func (res *Resolver) SetUpgradeHeaders(header http.Header, r *http.Request) {
+	res.setHeaders(header, r, true)
+}
+
+func (res *Resolver) setHeaders(header http.Header, r *http.Request, appendPeer bool) {
+	// header可能就是r.Header，先读取原始请求头再写入
+	peer := PeerIP(r)
+	trusted := res.Trusted(net.ParseIP(peer))
+	clientIP := res.ClientIP(r)
+
+	var chain []string
+	if trusted {
+		chain = forwardedChain(r.Header)
+	}
+	if appendPeer {
+		chain = append(chain, peer)
+	}
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	} else if upstream := r.Header.Get(HeaderForwardedProto); trusted && upstream != "" {
+		proto = upstream
+	}
+	host := r.Host
+	if upstream := r.Header.Get(HeaderForwardedHost); trusted && upstream != "" {
+		host = upstream
+	}
+
+	if len(chain) > 0 {
+		header.Set(HeaderForwardedFor, strings.Join(chain, ", "))
+	} else {
+		header.Del(HeaderForwardedFor)
+	}
+	header.Set(HeaderRealIP, clientIP)
+	header.Set(HeaderForwardedProto, proto)
+	header.Set(HeaderForwardedHost, host)
+}
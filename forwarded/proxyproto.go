@@ -0,0 +1,216 @@
+package forwarded
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PROXY协议头的签名
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	proxyHeaderTimeout = 5 * time.Second // 等待PROXY协议头的最长时间
+	proxyV1MaxLength   = 107             // v1头的最大长度（含CRLF）
+	proxyV2MaxLength   = 16 + 536        // 本实现接受的v2头最大长度（地址和TLV）
+)
+
+// Listen 启用了PROXY协议时包装listener，受信代理的连接先解析PROXY协议头，RemoteAddr返回头中的源地址。
+// 解析在连接的第一次Read或RemoteAddr调用时进行，不阻塞Accept
+func (res *Resolver) Listen(listener net.Listener) net.Listener {
+	if res == nil || !res.proxyProtocol {
+		return listener
+	}
+	return &proxyListener{Listener: listener, res: res}
+}
+
+type proxyListener struct {
+	net.Listener
+	res *Resolver
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, res: l.res, reader: bufio.NewReader(conn)}, nil
+}
+
+// proxyConn 读取PROXY协议头之后的连接
+type proxyConn struct {
+	net.Conn
+	res    *Resolver
+	reader *bufio.Reader
+	once   sync.Once
+	source net.Addr // 头中的源地址，nil表示使用TCP对端地址
+	err    error
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		peer, ok := c.Conn.RemoteAddr().(*net.TCPAddr)
+		if !ok || !c.res.Trusted(peer.IP) {
+			return
+		}
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.source, c.err = readProxyHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			log.Printf("解析来自 %s 的PROXY协议头失败: %v", peer, c.err)
+		}
+	})
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.source != nil {
+		return c.source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader 读取PROXY协议v1或v2头并返回源地址。没有头时不消耗数据并返回nil；
+// LOCAL命令（代理自身的健康检查）和未知地址族同样返回nil
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	peek, err := r.Peek(len(proxyV2Signature))
+	if err != nil && len(peek) == 0 {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(peek, proxyV2Signature):
+		return readProxyV2(r)
+	case bytes.HasPrefix(peek, proxyV1Prefix):
+		return readProxyV1(r)
+	}
+	return nil, nil
+}
+
+// readProxyV1 解析文本格式: PROXY TCP4 源地址 目标地址 源端口 目标端口\r\n
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("PROXY v1 头过长或没有以CRLF结尾")
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("无效的PROXY v1 头: %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("无效的PROXY v1 源地址: %s:%s", fields[2], fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 解析二进制格式：12字节签名、版本和命令、地址族和协议、2字节长度，随后是地址和TLV
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("不支持的PROXY协议版本: %d", header[12]>>4)
+	}
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+	if 16+length > proxyV2MaxLength {
+		return nil, fmt.Errorf("PROXY v2 头过长: %d 字节", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	command, family := header[12]&0x0f, header[13]
+	if command == 0x0 { // LOCAL
+		return nil, nil
+	}
+	if command != 0x1 {
+		return nil, fmt.Errorf("未知的PROXY v2 命令: %d", command)
+	}
+	switch family {
+	case 0x11, 0x12: // TCP或UDP over IPv4: 源地址4 + 目标地址4 + 源端口2 + 目标端口2
+		if length < 12 {
+			return nil, errors.New("PROXY v2 IPv4 地址长度不足")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 0x21, 0x22: // IPv6: 源地址16 + 目标地址16 + 源端口2 + 目标端口2
+		if length < 36 {
+			return nil, errors.New("PROXY v2 IPv6 地址长度不足")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	}
+	// UNSPEC、Unix域套接字等没有可用的IP地址
+	return nil, nil
+}
+
+// WriteProxyHeader 写入PROXY协议v2头（PROXY命令、TCP），告知对端真实的源地址。
+// 源地址和目标地址的地址族不同时都按IPv6表示；dst不是TCP地址（如Unix域套接字）时目标地址为全零
+func WriteProxyHeader(w io.Writer, src, dst net.Addr) error {
+	srcTCP, ok := src.(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("PROXY协议源地址不是TCP地址: %v", src)
+	}
+	dstTCP, ok := dst.(*net.TCPAddr)
+	if !ok {
+		dstTCP = &net.TCPAddr{IP: net.IPv4zero}
+		if srcTCP.IP.To4() == nil {
+			dstTCP.IP = net.IPv6zero
+		}
+	}
+
+	family, addrLen := byte(0x11), 4
+	srcIP, dstIP := srcTCP.IP.To4(), dstTCP.IP.To4()
+	if srcIP == nil || dstIP == nil {
+		family, addrLen = 0x21, 16
+		srcIP, dstIP = srcTCP.IP.To16(), dstTCP.IP.To16()
+	}
+
+	buf := make([]byte, 0, 16+2*addrLen+4)
+	buf = append(buf, proxyV2Signature...)
+	buf = append(buf, 0x21, family) // 版本2、PROXY命令
+	buf = binary.BigEndian.AppendUint16(buf, uint16(2*addrLen+4))
+	buf = append(buf, srcIP...)
+	buf = append(buf, dstIP...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(srcTCP.Port))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(dstTCP.Port))
+	_, err := w.Write(buf)
+	return err
+}
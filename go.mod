@@ -3,3 +3,5 @@ module websocket-loadbalance
 go 1.21
 
 require github.com/gorilla/websocket v1.5.0
+
+require gopkg.in/yaml.v3 v3.0.1
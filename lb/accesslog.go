@@ -0,0 +1,370 @@
+package lb
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// AccessLogConfig 访问日志配置，每个转发的HTTP请求和WebSocket会话结束时写一行JSON
+type AccessLogConfig struct {
+	Enabled    bool   `json:"enabled" yaml:"enabled"`
+	Path       string `json:"path" yaml:"path"`               // 日志文件路径，为空时写到标准输出
+	MaxSizeMB  int    `json:"max_size_mb" yaml:"max_size_mb"` // 文件达到该大小后轮转，0表示不轮转
+	MaxBackups int    `json:"max_backups" yaml:"max_backups"` // 轮转后保留的历史文件数（path.1 ~ path.N），默认5
+}
+
+// Validate 校验访问日志配置
+func (c AccessLogConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.MaxSizeMB < 0 || c.MaxBackups < 0 {
+		return fmt.Errorf("access_log 的 max_size_mb 和 max_backups 不能为负数")
+	}
+	if c.MaxSizeMB > 0 && c.Path == "" {
+		return fmt.Errorf("access_log 按大小轮转需要设置 path")
+	}
+	return nil
+}
+
+// 访问日志中的关闭原因
+const (
+	CloseReasonClient         = "client_closed"       // 客户端发送关闭帧
+	CloseReasonBackend        = "backend_closed"      // 后端发送关闭帧
+	CloseReasonClientError    = "client_error"        // 读取客户端消息出错（连接中断等）
+	CloseReasonBackendError   = "backend_error"       // 读取后端消息出错
+	CloseReasonShutdown       = "shutdown"            // 负载均衡器关闭
+	CloseReasonRegistration   = "registration_failed" // 读取注册消息失败
+	CloseReasonNoBackend      = "no_backend"          // 没有可用的后端
+	CloseReasonDialFailed     = "backend_dial_failed" // 连接后端失败（含重试）
+	CloseReasonTooBig         = "message_too_big"     // 任一侧消息超过 max_message_size
+	CloseReasonBackendRemoved = "backend_removed"     // 后端被移除，负载均衡器关闭了连接
+)
+
+// AccessLogEntry 访问日志中的一条记录
+type AccessLogEntry struct {
+	Time        time.Time `json:"time"` // 请求开始时间
+	Type        string    `json:"type"` // http 或 websocket
+	ClientIP    string    `json:"client_ip"`
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	Pool        string    `json:"pool,omitempty"`
+	Backend     string    `json:"backend,omitempty"`
+	Status      int       `json:"status"` // HTTP状态码，WebSocket升级成功为101
+	DurationMs  float64   `json:"duration_ms"`
+	BytesIn     int64     `json:"bytes_in"`  // 客户端发送的请求体或消息负载字节数
+	BytesOut    int64     `json:"bytes_out"` // 发给客户端的响应体或消息负载字节数（转换编码时按后端发出的JSON计）
+	CloseReason string    `json:"close_reason,omitempty"`
+	CloseCode   int       `json:"close_code,omitempty"` // 对端关闭帧中的状态码
+	CloseText   string    `json:"close_text,omitempty"` // 对端关闭帧中的原因
+	Error       string    `json:"error,omitempty"`
+}
+
+// accessLogger 将访问日志写到文件或标准输出
+type accessLogger struct {
+	mu   sync.Mutex
+	out  io.Writer
+	file *rotatingFile // 写到文件时非nil
+}
+
+// newAccessLogger 按配置创建访问日志，未启用时返回nil
+func newAccessLogger(cfg AccessLogConfig) (*accessLogger, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.Path == "" {
+		return &accessLogger{out: os.Stdout}, nil
+	}
+	if cfg.MaxBackups == 0 {
+		cfg.MaxBackups = 5
+	}
+	file, err := openRotatingFile(cfg.Path, int64(cfg.MaxSizeMB)<<20, cfg.MaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return &accessLogger{out: file, file: file}, nil
+}
+
+func (l *accessLogger) write(entry AccessLogEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.out.Write(data); err != nil {
+		log.Printf("写访问日志失败: %v", err)
+	}
+}
+
+// Close 关闭日志文件，之后的记录被丢弃
+func (l *accessLogger) Close() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// rotatingFile 按大小轮转的日志文件：超过maxSize时依次重命名为 path.1 ~ path.N，最旧的被删除
+type rotatingFile struct {
+	path       string
+	maxSize    int64 // 0表示不轮转
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("打开访问日志文件失败: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write 写入一条记录，调用方负责加锁
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+	if f.maxBackups > 0 {
+		os.Rename(f.path, f.path+".1")
+	} else {
+		os.Remove(f.path)
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// SetAccessLog 设置访问日志（需在Start之前调用）
+func (lb *LoadBalancer) SetAccessLog(cfg AccessLogConfig) error {
+	logger, err := newAccessLogger(cfg)
+	if err != nil {
+		return err
+	}
+	lb.accessLog = logger
+	return nil
+}
+
+// accessRecord 一个请求或WebSocket会话的访问记录，未启用访问日志时为nil，各方法均可在nil上调用
+type accessRecord struct {
+	entry    AccessLogEntry
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+}
+
+// beginAccess 开始记录请求，未启用访问日志时返回nil
+func (lb *LoadBalancer) beginAccess(r *http.Request, isWebSocket bool) *accessRecord {
+	if lb.accessLog == nil {
+		return nil
+	}
+	rec := &accessRecord{entry: AccessLogEntry{
+		Time:     time.Now(),
+		Type:     "http",
+		ClientIP: lb.clientIP(r),
+		Method:   r.Method,
+		Path:     r.URL.Path,
+	}}
+	if isWebSocket {
+		rec.entry.Type = "websocket"
+	}
+	return rec
+}
+
+// finishAccess 写出访问记录
+func (lb *LoadBalancer) finishAccess(rec *accessRecord) {
+	if rec == nil {
+		return
+	}
+	rec.entry.DurationMs = float64(time.Since(rec.entry.Time).Microseconds()) / 1000
+	rec.entry.BytesIn = rec.bytesIn.Load()
+	rec.entry.BytesOut = rec.bytesOut.Load()
+	lb.accessLog.write(rec.entry)
+}
+
+// wrap 记录HTTP响应的状态码和字节数，以及请求体的字节数
+func (rec *accessRecord) wrap(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	if rec == nil {
+		return w
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &countingReader{ReadCloser: r.Body, n: &rec.bytesIn}
+	}
+	return &accessResponseWriter{ResponseWriter: w, rec: rec}
+}
+
+func (rec *accessRecord) setRoute(rt route) {
+	if rec != nil && rt.pool != nil {
+		rec.entry.Pool = rt.pool.name
+	}
+}
+
+func (rec *accessRecord) setBackend(backend *BackendServer) {
+	if rec != nil && backend != nil {
+		rec.entry.Backend = backend.ID
+	}
+}
+
+func (rec *accessRecord) setStatus(status int) {
+	if rec != nil {
+		rec.entry.Status = status
+	}
+}
+
+// setClose 记录WebSocket会话的关闭原因
+func (rec *accessRecord) setClose(reason string, err error) {
+	if rec == nil {
+		return
+	}
+	rec.entry.CloseReason = reason
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		rec.entry.CloseCode = closeErr.Code
+		rec.entry.CloseText = closeErr.Text
+	} else if err != nil {
+		rec.entry.Error = err.Error()
+	}
+}
+
+// inCounter 和 outCounter 返回WebSocket消息字节计数器，未启用访问日志时返回nil
+func (rec *accessRecord) inCounter() *atomic.Int64 {
+	if rec == nil {
+		return nil
+	}
+	return &rec.bytesIn
+}
+
+func (rec *accessRecord) outCounter() *atomic.Int64 {
+	if rec == nil {
+		return nil
+	}
+	return &rec.bytesOut
+}
+
+// relayCloseReason 按转发方向和错误判断WebSocket会话的关闭原因
+func (lb *LoadBalancer) relayCloseReason(fromClient bool, err error) string {
+	// 连接中断时gorilla也返回1006的CloseError，但对端并未发送关闭帧
+	var closeErr *websocket.CloseError
+	closed := errors.As(err, &closeErr) && closeErr.Code != websocket.CloseAbnormalClosure
+	switch {
+	case lb.draining.Load():
+		return CloseReasonShutdown
+	case errors.Is(err, websocket.ErrReadLimit):
+		return CloseReasonTooBig
+	case closed && fromClient:
+		return CloseReasonClient
+	case closed:
+		return CloseReasonBackend
+	case fromClient:
+		return CloseReasonClientError
+	default:
+		return CloseReasonBackendError
+	}
+}
+
+// accessResponseWriter 记录状态码和响应字节数，并保留Hijack（WebSocket升级）和Flush
+type accessResponseWriter struct {
+	http.ResponseWriter
+	rec *accessRecord
+}
+
+func (w *accessResponseWriter) WriteHeader(status int) {
+	if w.rec.entry.Status == 0 {
+		w.rec.entry.Status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessResponseWriter) Write(p []byte) (int, error) {
+	if w.rec.entry.Status == 0 {
+		w.rec.entry.Status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.rec.bytesOut.Add(int64(n))
+	return n, err
+}
+
+func (w *accessResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("连接不支持Hijack")
+	}
+	return hijacker.Hijack()
+}
+
+func (w *accessResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *accessResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// countingReader 统计读取的请求体字节数
+type countingReader struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
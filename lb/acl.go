@@ -0,0 +1,161 @@
+package lb
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// ACLConfig 客户端访问控制：按来源IP放行或拒绝转发的请求，管理API不受影响。
+// deny优先；allow非空时只放行其中的地址
+type ACLConfig struct {
+	Allow []string `json:"allow" yaml:"allow"` // 允许的IP或CIDR，为空表示不限制
+	Deny  []string `json:"deny" yaml:"deny"`   // 拒绝的IP或CIDR
+}
+
+// aclRules 解析后的访问控制规则
+type aclRules struct {
+	config ACLConfig
+	allow  []*net.IPNet
+	deny   []*net.IPNet
+}
+
+// Validate 校验访问控制规则
+func (c ACLConfig) Validate() error {
+	_, err := c.compile()
+	return err
+}
+
+func (c ACLConfig) compile() (*aclRules, error) {
+	if c.Allow == nil {
+		c.Allow = []string{}
+	}
+	if c.Deny == nil {
+		c.Deny = []string{}
+	}
+	rules := &aclRules{config: c}
+	var err error
+	if rules.allow, err = parseCIDRs(c.Allow); err != nil {
+		return nil, fmt.Errorf("acl.allow: %v", err)
+	}
+	if rules.deny, err = parseCIDRs(c.Deny); err != nil {
+		return nil, fmt.Errorf("acl.deny: %v", err)
+	}
+	return rules, nil
+}
+
+// parseCIDRs 解析CIDR列表，单个IP视为/32或/128
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("无效的IP: %q", value)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("无效的CIDR: %q", value)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func (r *aclRules) allows(ip net.IP) bool {
+	for _, n := range r.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(r.allow) == 0 {
+		return true
+	}
+	for _, n := range r.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// aclHolder 当前生效的规则，可在运行时整体替换
+type aclHolder struct {
+	rules atomic.Pointer[aclRules]
+}
+
+// SetACL 设置客户端访问控制规则，可在运行时调用，新规则只影响之后的请求
+func (lb *LoadBalancer) SetACL(cfg ACLConfig) error {
+	rules, err := cfg.compile()
+	if err != nil {
+		return err
+	}
+	lb.acl.rules.Store(rules)
+	return nil
+}
+
+// ACL 当前的访问控制规则
+func (lb *LoadBalancer) ACL() ACLConfig {
+	if rules := lb.acl.rules.Load(); rules != nil {
+		return rules.config
+	}
+	return ACLConfig{Allow: []string{}, Deny: []string{}}
+}
+
+// aclAllows 请求来源是否被访问控制规则放行。按真实客户端地址判断，
+// 只有来自 forwarded.trusted_proxies 的 X-Forwarded-For 被采信
+func (lb *LoadBalancer) aclAllows(r *http.Request) bool {
+	rules := lb.acl.rules.Load()
+	if rules == nil || (len(rules.allow) == 0 && len(rules.deny) == 0) {
+		return true
+	}
+	ip := net.ParseIP(lb.clientIP(r))
+	if ip == nil {
+		return false
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	return rules.allows(ip)
+}
+
+// handleACL 访问控制API
+// GET 查看当前规则
+// PUT {"allow": ["10.0.0.0/8"], "deny": ["10.0.0.13"]} 整体替换规则
+func (lb *LoadBalancer) handleACL(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "PUT":
+		var cfg ACLConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			http.Error(w, "请求格式错误", http.StatusBadRequest)
+			return
+		}
+		old := lb.ACL()
+		if err := lb.SetACL(cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("更新访问控制规则: allow=%v deny=%v", cfg.Allow, cfg.Deny)
+		lb.RecordEvent(EventConfigChange, "", "更新访问控制规则",
+			map[string]interface{}{"old": old, "acl": cfg})
+	default:
+		http.Error(w, "仅支持GET和PUT请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lb.ACL())
+}
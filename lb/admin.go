@@ -0,0 +1,27 @@
+package lb
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// 管理界面的静态文件，编译进可执行文件，不依赖工作目录
+//
+//go:embed admin
+var adminFiles embed.FS
+
+// adminHandler 提供 /admin/ 下的管理界面，页面通过本负载均衡器的管理API获取数据，
+// 指令和广播经负载均衡器转发到健康的后端节点
+func adminHandler() http.Handler {
+	root, _ := fs.Sub(adminFiles, "admin")
+	files := http.StripPrefix("/admin/", http.FileServer(http.FS(root)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			http.Error(w, "仅支持GET请求", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		files.ServeHTTP(w, r)
+	})
+}
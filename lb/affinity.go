@@ -0,0 +1,46 @@
+package lb
+
+import (
+	"net/http"
+
+	"websocket-loadbalance/logging"
+	"websocket-loadbalance/registry"
+)
+
+// AffinityParam 客户端在握手时声明节点亲和性的查询参数，也可以使用 X-Client-Affinity 请求头。
+// 取值为节点标签的选择器（语法同 registry.Selector），如 "region=eu,gpu"
+const AffinityParam = "affinity"
+
+// requestedAffinity 握手声明的节点亲和性，未声明或无效时返回nil（无效的亲和性由节点在注册时拒绝）
+func requestedAffinity(r *http.Request) registry.Selector {
+	value := r.URL.Query().Get(AffinityParam)
+	if value == "" {
+		value = r.Header.Get("X-Client-Affinity")
+	}
+	if value == "" {
+		return nil
+	}
+	selector, err := registry.ParseSelector(value)
+	if err != nil {
+		logging.Debugf("忽略无效的节点亲和性 %q: %v", value, err)
+		return nil
+	}
+	return selector
+}
+
+// affinityPick affinity策略：在标签满足客户端亲和性的后端中选择负载最低的一个；
+// 客户端未声明亲和性或没有满足条件的可用后端时，在全部候选后端中选择（亲和性是偏好而不是硬性要求）
+func affinityPick(candidates []*BackendServer, affinity registry.Selector) *BackendServer {
+	if affinity != nil {
+		var matched []*BackendServer
+		for _, backend := range candidates {
+			if affinity.Matches(backend.Labels) {
+				matched = append(matched, backend)
+			}
+		}
+		if len(matched) > 0 {
+			return leastLoaded(matched)
+		}
+	}
+	return leastLoaded(candidates)
+}
@@ -0,0 +1,685 @@
+package lb
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"websocket-loadbalance/acme"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/secrets"
+)
+
+// 证书缓存位置
+const (
+	CertCacheDir      = "dir"      // 本地目录
+	CertCacheRegistry = "registry" // 服务发现使用的注册中心（Consul KV 或 etcd），多个负载均衡器实例共享证书
+)
+
+const (
+	certCheckInterval = 12 * time.Hour   // 检查证书是否需要续期的间隔
+	certRetryInterval = 10 * time.Minute // 申请失败后的重试间隔
+	certObtainTimeout = 5 * time.Minute  // 单次申请的超时
+	accountKeyName    = "acme_account.key"
+)
+
+// AutocertConfig 自动证书配置：通过ACME（如 Let's Encrypt）为域名申请和续期证书，
+// 启用后负载均衡器端口改为提供HTTPS/WSS
+type AutocertConfig struct {
+	Enabled      bool              `json:"enabled" yaml:"enabled"`
+	Domains      []string          `json:"domains" yaml:"domains"`             // 申请证书的域名，每个域名一张证书
+	Email        string            `json:"email" yaml:"email"`                 // ACME账户联系邮箱，用于接收证书过期提醒
+	AcceptTOS    bool              `json:"accept_tos" yaml:"accept_tos"`       // 同意CA的服务条款，必须为true
+	DirectoryURL string            `json:"directory_url" yaml:"directory_url"` // ACME目录地址，默认 Let's Encrypt 生产环境
+	Challenge    string            `json:"challenge" yaml:"challenge"`         // http-01（默认）或 tls-alpn-01
+	HTTPPort     int               `json:"http_port" yaml:"http_port"`         // 响应HTTP-01验证并将HTTP跳转到HTTPS的端口，默认80，-1表示不监听
+	Cache        string            `json:"cache" yaml:"cache"`                 // dir（默认）或 registry
+	CacheDir     string            `json:"cache_dir" yaml:"cache_dir"`         // cache=dir 时的目录，默认 ./certs
+	CachePrefix  string            `json:"cache_prefix" yaml:"cache_prefix"`   // cache=registry 时的键前缀，默认 websocket-lb/certs/
+	RenewBefore  protocol.Duration `json:"renew_before" yaml:"renew_before"`   // 到期前多久续期，默认720h
+}
+
+// Validate 校验自动证书配置
+func (c AutocertConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Domains) == 0 {
+		return fmt.Errorf("autocert 需要配置 domains")
+	}
+	for _, domain := range c.Domains {
+		if domain == "" || strings.ContainsAny(domain, ":/*") || net.ParseIP(domain) != nil {
+			return fmt.Errorf("autocert 的域名无效: %q", domain)
+		}
+	}
+	if !c.AcceptTOS {
+		return fmt.Errorf("autocert 需要设置 accept_tos: true 同意CA的服务条款")
+	}
+	switch c.Challenge {
+	case "", acme.ChallengeHTTP01:
+		if c.HTTPPort < 0 {
+			return fmt.Errorf("http-01 验证需要监听 http_port")
+		}
+	case acme.ChallengeTLSALPN01:
+	default:
+		return fmt.Errorf("无效的autocert验证方式: %s (可选: http-01, tls-alpn-01)", c.Challenge)
+	}
+	if c.HTTPPort < -1 || c.HTTPPort > 65535 {
+		return fmt.Errorf("无效的autocert http_port: %d", c.HTTPPort)
+	}
+	switch c.Cache {
+	case "", CertCacheDir, CertCacheRegistry:
+	default:
+		return fmt.Errorf("无效的证书缓存类型: %s (可选: dir, registry)", c.Cache)
+	}
+	if c.RenewBefore < 0 {
+		return fmt.Errorf("autocert.renew_before 不能为负数")
+	}
+	return nil
+}
+
+// errCacheMiss 缓存中没有该条目
+var errCacheMiss = errors.New("证书缓存中不存在")
+
+// CertCache 保存ACME账户密钥和签发的证书
+type CertCache interface {
+	Get(ctx context.Context, name string) ([]byte, error) // 不存在时返回 errCacheMiss
+	Put(ctx context.Context, name string, data []byte) error
+}
+
+// NewCertCache 根据配置创建证书缓存，cache=registry 时使用服务发现配置中的注册中心
+func NewCertCache(cfg AutocertConfig, discovery DiscoveryConfig) (CertCache, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	switch cfg.Cache {
+	case "", CertCacheDir:
+		if cfg.CacheDir == "" {
+			cfg.CacheDir = "./certs"
+		}
+		return dirCertCache(cfg.CacheDir), nil
+	case CertCacheRegistry:
+		prefix := cfg.CachePrefix
+		if prefix == "" {
+			prefix = "websocket-lb/certs/"
+		}
+		client := &http.Client{Timeout: 10 * time.Second}
+		switch discovery.Provider {
+		case DiscoveryConsul:
+			if discovery.Address == "" {
+				discovery.Address = "http://127.0.0.1:8500"
+			}
+			token, err := secrets.Resolve(discovery.Token)
+			if err != nil {
+				return nil, fmt.Errorf("读取 discovery.token 失败: %v", err)
+			}
+			return &consulCertCache{address: strings.TrimSuffix(discovery.Address, "/"), prefix: strings.TrimPrefix(prefix, "/"), token: token, client: client}, nil
+		case DiscoveryEtcd:
+			if discovery.Address == "" {
+				discovery.Address = "http://127.0.0.1:2379"
+			}
+			return &etcdCertCache{address: strings.TrimSuffix(discovery.Address, "/"), prefix: prefix, client: client}, nil
+		}
+		return nil, fmt.Errorf("证书缓存 registry 需要配置 discovery 的 consul 或 etcd 注册中心")
+	}
+	return nil, fmt.Errorf("无效的证书缓存类型: %s", cfg.Cache)
+}
+
+// dirCertCache 将证书保存在本地目录，文件权限0600
+type dirCertCache string
+
+func (d dirCertCache) Get(ctx context.Context, name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(string(d), name))
+	if os.IsNotExist(err) {
+		return nil, errCacheMiss
+	}
+	return data, err
+}
+
+func (d dirCertCache) Put(ctx context.Context, name string, data []byte) error {
+	if err := os.MkdirAll(string(d), 0700); err != nil {
+		return err
+	}
+	// 先写临时文件再改名，避免进程中途退出留下不完整的证书
+	path := filepath.Join(string(d), name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// consulCertCache 将证书保存在Consul KV
+type consulCertCache struct {
+	address string
+	prefix  string
+	token   *secrets.Secret
+	client  *http.Client
+}
+
+func (c *consulCertCache) Get(ctx context.Context, name string) ([]byte, error) {
+	resp, err := c.do(ctx, "GET", name+"?raw", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errCacheMiss
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Consul返回状态码 %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (c *consulCertCache) Put(ctx context.Context, name string, data []byte) error {
+	resp, err := c.do(ctx, "PUT", name, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Consul返回状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (c *consulCertCache) do(ctx context.Context, method, name string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.address+"/v1/kv/"+c.prefix+name, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if token := c.token.Value(); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	return c.client.Do(req)
+}
+
+// etcdCertCache 通过etcd v3 的HTTP网关将证书保存在etcd
+type etcdCertCache struct {
+	address string
+	prefix  string
+	client  *http.Client
+}
+
+func (c *etcdCertCache) Get(ctx context.Context, name string) ([]byte, error) {
+	var result etcdRangeResponse
+	if err := c.call(ctx, "/v3/kv/range", map[string]string{"key": base64.StdEncoding.EncodeToString([]byte(c.prefix + name))}, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Kvs) == 0 {
+		return nil, errCacheMiss
+	}
+	return base64.StdEncoding.DecodeString(result.Kvs[0].Value)
+}
+
+func (c *etcdCertCache) Put(ctx context.Context, name string, data []byte) error {
+	return c.call(ctx, "/v3/kv/put", map[string]string{
+		"key":   base64.StdEncoding.EncodeToString([]byte(c.prefix + name)),
+		"value": base64.StdEncoding.EncodeToString(data),
+	}, nil)
+}
+
+func (c *etcdCertCache) call(ctx context.Context, path string, request interface{}, result interface{}) error {
+	body, _ := json.Marshal(request)
+	req, err := http.NewRequestWithContext(ctx, "POST", c.address+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd返回状态码 %d", resp.StatusCode)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// managedCert 一个域名的证书及申请状态
+type managedCert struct {
+	cert      *tls.Certificate // 尚未申请成功时为nil
+	notAfter  time.Time
+	issuer    string
+	renewedAt time.Time
+	lastError string
+	failedAt  time.Time
+}
+
+// certManager 为配置的域名申请、缓存和续期证书，并在TLS握手时提供证书
+type certManager struct {
+	cfg    AutocertConfig
+	cache  CertCache
+	client *acme.Client
+	lb     *LoadBalancer
+
+	mu         sync.RWMutex
+	certs      map[string]*managedCert
+	httpTokens map[string]string           // HTTP-01 令牌 -> keyAuth
+	alpnCerts  map[string]*tls.Certificate // TLS-ALPN-01 域名 -> 验证证书
+
+	obtainMu   sync.Mutex // 同一时间只申请一张证书
+	httpServer *http.Server
+	stop       context.CancelFunc
+}
+
+// SetAutocert 启用自动证书（需在Start之前调用），cache为nil时不启用
+func (lb *LoadBalancer) SetAutocert(cfg AutocertConfig, cache CertCache) error {
+	if !cfg.Enabled || cache == nil {
+		lb.autocert = nil
+		return nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if cfg.DirectoryURL == "" {
+		cfg.DirectoryURL = acme.LetsEncryptURL
+	}
+	if cfg.Challenge == "" {
+		cfg.Challenge = acme.ChallengeHTTP01
+	}
+	if cfg.HTTPPort == 0 {
+		cfg.HTTPPort = 80
+	}
+	if cfg.RenewBefore == 0 {
+		cfg.RenewBefore = protocol.Duration(720 * time.Hour)
+	}
+	m := &certManager{
+		cfg:        cfg,
+		cache:      cache,
+		client:     &acme.Client{DirectoryURL: cfg.DirectoryURL, Email: cfg.Email, HTTPClient: &http.Client{Timeout: 30 * time.Second}},
+		lb:         lb,
+		certs:      make(map[string]*managedCert),
+		httpTokens: make(map[string]string),
+		alpnCerts:  make(map[string]*tls.Certificate),
+	}
+	for _, domain := range cfg.Domains {
+		m.certs[strings.ToLower(domain)] = &managedCert{}
+	}
+	lb.autocert = m
+	return nil
+}
+
+// start 加载账户密钥和缓存的证书，启动HTTP-01监听和后台续期
+func (m *certManager) start(listenAddress, family string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.stop = cancel
+
+	key, err := m.loadAccountKey(ctx)
+	if err != nil {
+		return fmt.Errorf("加载ACME账户密钥失败: %w", err)
+	}
+	m.client.Key = key
+	for domain, mc := range m.certs {
+		if err := m.loadCached(ctx, domain, mc); err != nil && err != errCacheMiss {
+			log.Printf("读取 %s 的缓存证书失败: %v", domain, err)
+		}
+	}
+
+	if m.cfg.HTTPPort > 0 {
+		listener, err := protocol.Listen(family, listenAddress, m.cfg.HTTPPort)
+		if err != nil {
+			return fmt.Errorf("监听HTTP端口失败: %w", err)
+		}
+		m.httpServer = &http.Server{Handler: http.HandlerFunc(m.handleHTTP), ReadHeaderTimeout: 10 * time.Second}
+		log.Printf("自动证书: 在端口 %d 响应HTTP-01验证，其余HTTP请求跳转到HTTPS", m.cfg.HTTPPort)
+		go func() {
+			if err := m.httpServer.Serve(listener); err != http.ErrServerClosed {
+				log.Printf("HTTP端口监听出错: %v", err)
+			}
+		}()
+	}
+	log.Printf("自动证书: 域名 %v，通过 %s 验证，CA %s", m.cfg.Domains, m.cfg.Challenge, m.cfg.DirectoryURL)
+	go m.renewLoop(ctx)
+	return nil
+}
+
+// shutdown 停止后台续期和HTTP端口
+func (m *certManager) shutdown(ctx context.Context) {
+	if m.stop != nil {
+		m.stop()
+	}
+	if m.httpServer != nil {
+		m.httpServer.Shutdown(ctx)
+	}
+}
+
+// tlsConfig 负载均衡器端口使用的TLS配置
+func (m *certManager) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.getCertificate,
+		NextProtos:     []string{"http/1.1", acme.ALPNProto},
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// getCertificate 按SNI返回证书：TLS-ALPN-01 验证连接返回验证证书；
+// 证书尚未申请时同步申请（最近申请失败过则直接返回错误，避免反复请求CA）
+func (m *certManager) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	domain := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if domain == "" && len(m.cfg.Domains) == 1 {
+		// 没有SNI的客户端（如直接用IP访问）使用唯一的域名证书
+		domain = strings.ToLower(m.cfg.Domains[0])
+	}
+
+	m.mu.RLock()
+	mc, ok := m.certs[domain]
+	alpnCert := m.alpnCerts[domain]
+	var cert *tls.Certificate
+	var failedAt time.Time
+	var lastError string
+	if ok {
+		cert, failedAt, lastError = mc.cert, mc.failedAt, mc.lastError
+	}
+	m.mu.RUnlock()
+
+	// CA的验证连接只协商 acme-tls/1
+	if len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto {
+		if alpnCert == nil {
+			return nil, fmt.Errorf("没有 %s 的TLS-ALPN-01验证", domain)
+		}
+		return alpnCert, nil
+	}
+	if !ok {
+		return nil, fmt.Errorf("域名 %q 不在autocert配置中", hello.ServerName)
+	}
+	if cert != nil {
+		return cert, nil
+	}
+	if time.Since(failedAt) < certRetryInterval {
+		return nil, fmt.Errorf("%s 的证书申请失败: %s", domain, lastError)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), certObtainTimeout)
+	defer cancel()
+	if err := m.obtain(ctx, domain); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.certs[domain].cert, nil
+}
+
+// renewLoop 申请缺失的证书并在到期前续期
+func (m *certManager) renewLoop(ctx context.Context) {
+	for {
+		failed := false
+		for _, domain := range m.domains() {
+			if err := m.obtain(ctx, domain); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				failed = true
+			}
+		}
+		wait := certCheckInterval
+		if failed {
+			wait = certRetryInterval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (m *certManager) domains() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	domains := make([]string, 0, len(m.certs))
+	for domain := range m.certs {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// needsRenewal 证书缺失或进入续期窗口
+func (m *certManager) needsRenewal(domain string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	mc := m.certs[domain]
+	return mc.cert == nil || time.Until(mc.notAfter) < time.Duration(m.cfg.RenewBefore)
+}
+
+// obtain 在证书缺失或进入续期窗口时申请证书并写入缓存。
+// 申请前先检查缓存，多个实例共享缓存时由其他实例续期的证书可直接使用
+func (m *certManager) obtain(ctx context.Context, domain string) error {
+	m.obtainMu.Lock()
+	defer m.obtainMu.Unlock()
+	if !m.needsRenewal(domain) {
+		return nil
+	}
+
+	m.mu.RLock()
+	mc := m.certs[domain]
+	m.mu.RUnlock()
+	if err := m.loadCached(ctx, domain, mc); err == nil && !m.needsRenewal(domain) {
+		return nil
+	}
+
+	renewal := mc.cert != nil
+	err := m.issue(ctx, domain, mc)
+	if err != nil {
+		m.mu.Lock()
+		mc.lastError = err.Error()
+		mc.failedAt = time.Now()
+		m.mu.Unlock()
+		log.Printf("申请 %s 的证书失败: %v", domain, err)
+		return err
+	}
+
+	m.mu.RLock()
+	notAfter := mc.notAfter
+	m.mu.RUnlock()
+	message := fmt.Sprintf("签发 %s 的证书，有效期至 %s", domain, notAfter.Format(time.RFC3339))
+	if renewal {
+		message = fmt.Sprintf("续期 %s 的证书，有效期至 %s", domain, notAfter.Format(time.RFC3339))
+	}
+	log.Print(message)
+	m.lb.RecordEvent(EventCertificateIssued, "", message,
+		map[string]interface{}{"domain": domain, "not_after": notAfter, "renewal": renewal})
+	return nil
+}
+
+// issue 通过ACME签发证书，保存到缓存后替换正在使用的证书
+func (m *certManager) issue(ctx context.Context, domain string, mc *managedCert) error {
+	key, err := acme.GenerateKey()
+	if err != nil {
+		return err
+	}
+	chain, err := m.client.ObtainCertificate(ctx, []string{domain}, key, m.cfg.Challenge, m)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := acme.MarshalKey(key)
+	if err != nil {
+		return err
+	}
+	data := append(keyPEM, chain...)
+	if err := m.cache.Put(ctx, domain, data); err != nil {
+		log.Printf("保存 %s 的证书到缓存失败: %v", domain, err)
+	}
+	return m.useCert(mc, data)
+}
+
+// loadCached 从缓存读取证书，比正在使用的证书新时替换
+func (m *certManager) loadCached(ctx context.Context, domain string, mc *managedCert) error {
+	data, err := m.cache.Get(ctx, domain)
+	if err != nil {
+		return err
+	}
+	return m.useCert(mc, data)
+}
+
+// useCert 解析PEM（私钥+证书链）并替换正在使用的证书
+func (m *certManager) useCert(mc *managedCert, data []byte) error {
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	cert.Leaf = leaf
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mc.cert != nil && !leaf.NotAfter.After(mc.notAfter) {
+		return nil
+	}
+	mc.cert = &cert
+	mc.notAfter = leaf.NotAfter
+	mc.issuer = leaf.Issuer.String()
+	mc.renewedAt = time.Now()
+	mc.lastError = ""
+	mc.failedAt = time.Time{}
+	return nil
+}
+
+// loadAccountKey 读取缓存中的ACME账户密钥，不存在时生成并保存
+func (m *certManager) loadAccountKey(ctx context.Context) (*ecdsa.PrivateKey, error) {
+	data, err := m.cache.Get(ctx, accountKeyName)
+	if err == nil {
+		return acme.ParseKey(data)
+	}
+	if err != errCacheMiss {
+		return nil, err
+	}
+	key, err := acme.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	data, err = acme.MarshalKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.cache.Put(ctx, accountKeyName, data); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Present 发布验证内容（实现 acme.Solver）
+func (m *certManager) Present(domain, token, keyAuth string) error {
+	if m.cfg.Challenge == acme.ChallengeTLSALPN01 {
+		cert, err := acme.TLSALPN01Certificate(domain, keyAuth)
+		if err != nil {
+			return err
+		}
+		m.mu.Lock()
+		m.alpnCerts[domain] = cert
+		m.mu.Unlock()
+		return nil
+	}
+	m.mu.Lock()
+	m.httpTokens[token] = keyAuth
+	m.mu.Unlock()
+	return nil
+}
+
+// CleanUp 撤下验证内容（实现 acme.Solver）
+func (m *certManager) CleanUp(domain, token string) {
+	m.mu.Lock()
+	delete(m.alpnCerts, domain)
+	delete(m.httpTokens, token)
+	m.mu.Unlock()
+}
+
+// handleHTTP HTTP端口：响应HTTP-01验证，其余请求跳转到HTTPS
+func (m *certManager) handleHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, acme.HTTP01Path) {
+		m.mu.RLock()
+		keyAuth, ok := m.httpTokens[strings.TrimPrefix(r.URL.Path, acme.HTTP01Path)]
+		m.mu.RUnlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, keyAuth)
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "请使用HTTPS", http.StatusBadRequest)
+		return
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if m.lb.port != 443 {
+		host = net.JoinHostPort(protocol.TrimHostBrackets(host), strconv.Itoa(m.lb.port))
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// status 各域名的证书状态
+func (m *certManager) status() []map[string]interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := make([]map[string]interface{}, 0, len(m.certs))
+	for domain, mc := range m.certs {
+		item := map[string]interface{}{
+			"domain": domain,
+			"valid":  mc.cert != nil && time.Now().Before(mc.notAfter),
+		}
+		if mc.cert != nil {
+			item["not_after"] = mc.notAfter
+			item["renew_at"] = mc.notAfter.Add(-time.Duration(m.cfg.RenewBefore))
+			item["issuer"] = mc.issuer
+			item["renewed_at"] = mc.renewedAt
+		}
+		if mc.lastError != "" {
+			item["last_error"] = mc.lastError
+			item["failed_at"] = mc.failedAt
+		}
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i]["domain"].(string) < list[j]["domain"].(string)
+	})
+	return list
+}
+
+// handleCertificates 自动证书和证书文件的状态
+func (lb *LoadBalancer) handleCertificates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "仅支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	response := map[string]interface{}{"enabled": lb.autocert != nil}
+	if lb.autocert != nil {
+		response["challenge"] = lb.autocert.cfg.Challenge
+		response["directory_url"] = lb.autocert.cfg.DirectoryURL
+		response["certificates"] = lb.autocert.status()
+	}
+	if lb.tlsCerts != nil {
+		response["tls"] = lb.tlsCerts.status()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
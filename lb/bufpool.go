@@ -0,0 +1,140 @@
+package lb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 默认的转发复制缓冲区大小
+const defaultCopyBufferSize = 32 * 1024
+
+// 超过该容量的消息缓冲区不放回池中，避免个别大消息让池长期占用内存
+const maxPooledMessageBuffer = 1 << 20
+
+// bufferPools 一个负载均衡器转发消息使用的缓冲区池，缓冲区大小由SetPerformance设置，
+// 同一进程中的多个负载均衡器各自独立
+type bufferPools struct {
+	// 消息转发使用的复制缓冲区。未压缩时消息写入器实现了io.ReaderFrom，负载直接从src读入dst的写缓冲区，
+	// 只有协商了permessage-deflate的写入器才经过该缓冲区
+	copyBuffers sync.Pool
+	// 需要完整消息内容时（编码转换、复制到影子后端）使用的消息缓冲区，每个转发方向取一个并在消息之间复用
+	messageBuffers sync.Pool
+}
+
+func newBufferPools(size int) *bufferPools {
+	if size <= 0 {
+		size = defaultCopyBufferSize
+	}
+	p := &bufferPools{}
+	p.copyBuffers.New = func() interface{} {
+		buf := make([]byte, size)
+		return &buf
+	}
+	p.messageBuffers.New = func() interface{} {
+		return bytes.NewBuffer(make([]byte, 0, size))
+	}
+	return p
+}
+
+func (p *bufferPools) getMessageBuffer() *bytes.Buffer {
+	return p.messageBuffers.Get().(*bytes.Buffer)
+}
+
+func (p *bufferPools) putMessageBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledMessageBuffer {
+		return
+	}
+	buf.Reset()
+	p.messageBuffers.Put(buf)
+}
+
+// WebSocket写缓冲区池，连接空闲时归还写缓冲区，减少大量长连接的常驻内存
+var writeBufferPool = &sync.Pool{}
+
+// proxyMessages 将src的消息逐帧转发到dst，流式复制并复用缓冲区，避免每条消息整体分配内存。
+// counter非nil时累加转发的消息负载字节数，shadow非nil时将完整的消息复制到影子后端
+func (p *bufferPools) proxyMessages(dst, src *websocket.Conn, counter *atomic.Int64, shadow *shadowConn) error {
+	bufp := p.copyBuffers.Get().(*[]byte)
+	defer p.copyBuffers.Put(bufp)
+	var mirror *bytes.Buffer
+	if shadow != nil {
+		mirror = p.getMessageBuffer()
+		defer p.putMessageBuffer(mirror)
+	}
+
+	for {
+		messageType, reader, err := src.NextReader()
+		if err != nil {
+			return err
+		}
+		n, err := copyMessage(dst, messageType, reader, *bufp, mirror)
+		if counter != nil {
+			counter.Add(n)
+		}
+		if err != nil {
+			return err
+		}
+		if mirror != nil {
+			shadow.send(messageType, bytes.Clone(mirror.Bytes()))
+		}
+	}
+}
+
+// copyMessage 将一条消息流式写入dst并返回负载字节数，mirror非nil时同时将消息内容保留在mirror中
+func copyMessage(dst *websocket.Conn, messageType int, reader io.Reader, buf []byte, mirror *bytes.Buffer) (int64, error) {
+	writer, err := dst.NextWriter(messageType)
+	if err != nil {
+		return 0, err
+	}
+	if mirror != nil {
+		mirror.Reset()
+		reader = io.TeeReader(reader, mirror)
+	}
+	n, err := io.CopyBuffer(writer, reader, buf)
+	if err != nil {
+		return n, err
+	}
+	return n, writer.Close()
+}
+
+// relayControlFrames 在客户端和后端之间原样转发ping/pong，而不是由负载均衡器自行应答，
+// 这样后端通过ping测得的往返时延覆盖到真实客户端
+func relayControlFrames(clientConn, backendConn *websocket.Conn) {
+	forward := func(dst *websocket.Conn, messageType int) func(string) error {
+		return func(data string) error {
+			// 写失败由另一个方向的转发发现并关闭连接，这里不中断读取
+			dst.WriteControl(messageType, []byte(data), time.Now().Add(time.Second))
+			return nil
+		}
+	}
+	backendConn.SetPingHandler(forward(clientConn, websocket.PingMessage))
+	backendConn.SetPongHandler(forward(clientConn, websocket.PongMessage))
+	clientConn.SetPingHandler(forward(backendConn, websocket.PingMessage))
+	clientConn.SetPongHandler(forward(backendConn, websocket.PongMessage))
+}
+
+// 一侧先结束后等待另一侧回应关闭帧的最长时间，回应之前不断开TCP连接，保证转告的关闭帧送达
+const closeHandshakeTimeout = time.Second
+
+// relayCloseMessage 一侧先结束时转告另一侧的关闭帧：对端的关闭帧按原关闭码和原因转发（空关闭帧仍为空）；
+// 消息超过大小上限时超限一侧已由gorilla发送1009，另一侧同样以1009关闭；
+// 连接中断而没有关闭帧时，后端中断以1011通知客户端，客户端中断以1001通知后端
+func relayCloseMessage(fromClient bool, err error) []byte {
+	var closeErr *websocket.CloseError
+	switch {
+	case errors.Is(err, websocket.ErrReadLimit):
+		return websocket.FormatCloseMessage(websocket.CloseMessageTooBig, "消息超过大小上限")
+	case errors.As(err, &closeErr) && closeErr.Code != websocket.CloseAbnormalClosure:
+		return websocket.FormatCloseMessage(closeErr.Code, closeErr.Text)
+	case fromClient:
+		return websocket.FormatCloseMessage(websocket.CloseGoingAway, "客户端连接中断")
+	default:
+		return websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "后端连接中断")
+	}
+}
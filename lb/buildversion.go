@@ -0,0 +1,171 @@
+package lb
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"websocket-loadbalance/protocol"
+)
+
+// SetMinBackendVersion 设置后端的最低构建版本，低于该版本的后端记入时间线并在 /api/build-versions 中标出，
+// 为空表示不检查。可在运行时调用（重新加载配置）
+func (lb *LoadBalancer) SetMinBackendVersion(version string) error {
+	if version != "" {
+		if _, _, err := protocol.ParseBuildVersion(version); err != nil {
+			return fmt.Errorf("min_backend_version: %v", err)
+		}
+	}
+	lb.backendsMu.Lock()
+	defer lb.backendsMu.Unlock()
+	if version == lb.minBackendVersion {
+		return nil
+	}
+	// 按新的最低版本重新检查，仍低于新版本的后端再次记入时间线
+	lb.minBackendVersion = version
+	for _, backend := range lb.backends {
+		backend.outdated = false
+	}
+	lb.checkBuildVersionsUnsafe()
+	return nil
+}
+
+// MinBackendVersion 当前的最低构建版本，为空表示不检查
+func (lb *LoadBalancer) MinBackendVersion() string {
+	lb.backendsMu.RLock()
+	defer lb.backendsMu.RUnlock()
+	return lb.minBackendVersion
+}
+
+// isOutdated 构建版本是否低于最低版本，未上报或无法解析（如 dev）的版本不参与比较
+func isOutdated(version, minVersion string) bool {
+	if version == "" || minVersion == "" {
+		return false
+	}
+	cmp, ok := protocol.CompareBuildVersions(version, minVersion)
+	return ok && cmp < 0
+}
+
+// healthyBuildVersionsUnsafe 健康后端按构建版本分组，未上报版本的后端不计入（调用方持有backendsMu）
+func (lb *LoadBalancer) healthyBuildVersionsUnsafe() map[string][]string {
+	versions := make(map[string][]string)
+	for id, backend := range lb.backends {
+		if backend.IsHealthy && backend.BuildVersion != "" {
+			versions[backend.BuildVersion] = append(versions[backend.BuildVersion], id)
+		}
+	}
+	for _, ids := range versions {
+		sort.Strings(ids)
+	}
+	return versions
+}
+
+// formatBuildVersions 把分组的构建版本格式化为 "v1.4.1(node1) v1.4.2(node2,node3)"
+func formatBuildVersions(versions map[string][]string) string {
+	names := make([]string, 0, len(versions))
+	for version := range versions {
+		names = append(names, version)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, version := range names {
+		parts[i] = fmt.Sprintf("%s(%s)", version, strings.Join(versions[version], ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+// checkBuildVersionsUnsafe 每轮健康检查后比较各后端上报的构建版本：健康后端的版本出现分歧、
+// 分歧中的版本集合变化以及分歧消除时记入时间线，后端首次被发现低于最低版本时记入时间线（调用方持有backendsMu）
+func (lb *LoadBalancer) checkBuildVersionsUnsafe() {
+	for _, backend := range lb.backends {
+		outdated := isOutdated(backend.BuildVersion, lb.minBackendVersion)
+		if outdated && !backend.outdated {
+			log.Printf("⚠️ 后端服务器 %s 的构建版本 %s 低于最低版本 %s", backend.ID, backend.BuildVersion, lb.minBackendVersion)
+			lb.RecordEvent(EventBackendOutdated, backend.ID,
+				fmt.Sprintf("构建版本 %s 低于最低版本 %s", backend.BuildVersion, lb.minBackendVersion),
+				map[string]interface{}{"build_version": backend.BuildVersion, "min_version": lb.minBackendVersion})
+		}
+		backend.outdated = outdated
+	}
+
+	versions := lb.healthyBuildVersionsUnsafe()
+	names := make([]string, 0, len(versions))
+	for version := range versions {
+		names = append(names, version)
+	}
+	sort.Strings(names)
+	previous := lb.skewedVersions
+	if len(names) > 1 {
+		if strings.Join(names, " ") == strings.Join(previous, " ") {
+			return
+		}
+		lb.skewedVersions = names
+		log.Printf("⚠️ 后端构建版本不一致: %s", formatBuildVersions(versions))
+		lb.RecordEvent(EventVersionSkew, "", "后端构建版本不一致: "+formatBuildVersions(versions),
+			map[string]interface{}{"versions": versions})
+		return
+	}
+	if previous != nil {
+		lb.skewedVersions = nil
+		log.Printf("后端构建版本已一致: %s", formatBuildVersions(versions))
+		lb.RecordEvent(EventVersionSkewResolved, "", "后端构建版本已一致",
+			map[string]interface{}{"versions": versions})
+	}
+}
+
+// BackendBuildVersion 单个后端上报的构建版本
+type BackendBuildVersion struct {
+	ID           string `json:"id"`
+	IsHealthy    bool   `json:"is_healthy"`
+	BuildVersion string `json:"build_version"` // 后端 /health 上报的构建版本，未上报时为空
+	Outdated     bool   `json:"outdated"`      // 低于 min_backend_version
+}
+
+// BuildVersionStatus 各后端构建版本的分布，用于发现未完成的滚动升级
+type BuildVersionStatus struct {
+	MinVersion string                `json:"min_version,omitempty"` // 配置的最低构建版本
+	Versions   map[string][]string   `json:"versions"`              // 健康后端按构建版本分组
+	Skewed     bool                  `json:"skewed"`                // 健康后端的构建版本不一致
+	Outdated   []string              `json:"outdated"`              // 低于最低版本的后端
+	Backends   []BackendBuildVersion `json:"backends"`
+}
+
+// BuildVersionStatus 各后端上报的构建版本及是否存在分歧
+func (lb *LoadBalancer) BuildVersionStatus() BuildVersionStatus {
+	lb.backendsMu.RLock()
+	status := BuildVersionStatus{
+		MinVersion: lb.minBackendVersion,
+		Versions:   lb.healthyBuildVersionsUnsafe(),
+		Outdated:   []string{},
+		Backends:   make([]BackendBuildVersion, 0, len(lb.backends)),
+	}
+	for _, backend := range lb.backends {
+		status.Backends = append(status.Backends, BackendBuildVersion{
+			ID:           backend.ID,
+			IsHealthy:    backend.IsHealthy,
+			BuildVersion: backend.BuildVersion,
+			Outdated:     backend.outdated,
+		})
+		if backend.outdated {
+			status.Outdated = append(status.Outdated, backend.ID)
+		}
+	}
+	lb.backendsMu.RUnlock()
+	sort.Slice(status.Backends, func(i, j int) bool { return status.Backends[i].ID < status.Backends[j].ID })
+	sort.Strings(status.Outdated)
+	status.Skewed = len(status.Versions) > 1
+	return status
+}
+
+// handleBuildVersions 各后端的构建版本: GET /api/build-versions
+func (lb *LoadBalancer) handleBuildVersions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "仅支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lb.BuildVersionStatus())
+}
@@ -0,0 +1,442 @@
+package lb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"websocket-loadbalance/protocol"
+)
+
+// errBackendNotFound 后端不存在
+var errBackendNotFound = errors.New("后端服务器不存在")
+
+// BackendState 静态后端的状态
+type BackendState struct {
+	ID             string `json:"id" yaml:"id"`
+	Host           string `json:"host" yaml:"host"`     // 默认localhost
+	Port           int    `json:"port" yaml:"port"`     // HTTP和WebSocket端口
+	Weight         int    `json:"weight" yaml:"weight"` // 默认1
+	BackendOptions `yaml:",inline"`
+}
+
+// normalize 填充默认值，未启用的TLS设置视为没有设置
+func (b BackendState) normalize() BackendState {
+	b.Host = protocol.TrimHostBrackets(b.Host)
+	if b.Host == "" {
+		b.Host = "localhost"
+	}
+	if b.Weight <= 0 {
+		b.Weight = 1
+	}
+	if !b.tlsEnabled() {
+		b.TLS = nil
+	}
+	return b
+}
+
+// ClusterState 可以通过管理API在运行时修改的负载均衡器状态：
+// 全局策略、静态后端（服务发现和自注册的后端不包含在内）、后端池和访问控制
+type ClusterState struct {
+	Strategy Strategy       `json:"strategy" yaml:"strategy"`
+	Backends []BackendState `json:"backends" yaml:"backends"`
+	Pools    []PoolConfig   `json:"pools" yaml:"pools"`
+	ACL      *ACLConfig     `json:"acl" yaml:"acl"`
+}
+
+// ClusterState 导出当前状态，后端和后端池按名称排序
+func (lb *LoadBalancer) ClusterState() ClusterState {
+	state := ClusterState{
+		Strategy: lb.defaultPool.getStrategy(),
+		Backends: make([]BackendState, 0),
+		Pools:    make([]PoolConfig, 0),
+	}
+
+	lb.backendsMu.RLock()
+	for _, backend := range lb.backends {
+		if backend.Discovered || backend.Registered {
+			continue
+		}
+		host, port := backend.hostPort()
+		state.Backends = append(state.Backends, BackendState{
+			ID:             backend.ID,
+			Host:           host,
+			Port:           port,
+			Weight:         backend.weight(),
+			BackendOptions: backend.endpoint.options,
+		})
+	}
+	lb.backendsMu.RUnlock()
+	sort.Slice(state.Backends, func(i, j int) bool { return state.Backends[i].ID < state.Backends[j].ID })
+
+	lb.poolsMu.RLock()
+	for _, pool := range lb.pools {
+		state.Pools = append(state.Pools, pool.config())
+	}
+	lb.poolsMu.RUnlock()
+	sort.Slice(state.Pools, func(i, j int) bool { return state.Pools[i].Name < state.Pools[j].Name })
+
+	acl := lb.ACL()
+	state.ACL = &acl
+	return state
+}
+
+// hostPort 从HTTP地址解析主机和端口
+func (b *BackendServer) hostPort() (string, int) {
+	u, err := url.Parse(b.HTTPAddress)
+	if err != nil {
+		return "", 0
+	}
+	host, portStr, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return u.Host, 0
+	}
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
+
+// config 导出后端池的配置
+func (p *backendPool) config() PoolConfig {
+	backends := make([]string, 0, len(p.backendIDs))
+	for id := range p.backendIDs {
+		backends = append(backends, id)
+	}
+	sort.Strings(backends)
+	sticky := p.sticky
+	return PoolConfig{
+		Name:             p.name,
+		PathPrefix:       p.pathPrefix,
+		Strategy:         p.getStrategy(),
+		Backends:         backends,
+		Sticky:           &sticky,
+		ProtocolVersions: p.versions,
+		HealthCheck:      p.healthCheck,
+	}
+}
+
+// 运行时修改集群状态的来源，用于日志和时间线
+const (
+	viaAPI    = "通过API"
+	viaConfig = "按配置文件"
+)
+
+// PutBackend 添加静态后端，或更新已有后端的地址、Host头、TLS设置和权重。
+// 地址或连接设置变化时按新后端重建（健康状态重新探测，已建立的代理连接保持到自然断开），只改权重时原地更新
+func (lb *LoadBalancer) PutBackend(state BackendState) (created bool, err error) {
+	created, _, err = lb.putBackend(state, viaAPI)
+	return created, err
+}
+
+// putBackend 同PutBackend，返回描述变更的消息（没有变化时为空）
+func (lb *LoadBalancer) putBackend(state BackendState, via string) (created bool, message string, err error) {
+	state = state.normalize()
+	if state.ID == "" || (state.Port <= 0 && state.Socket == "") || state.Port < 0 || state.Port > 65535 {
+		return false, "", fmt.Errorf("后端配置无效: id=%q port=%d", state.ID, state.Port)
+	}
+	endpoint, err := newBackendEndpoint(state.Host, state.BackendOptions)
+	if err != nil {
+		return false, "", err
+	}
+
+	lb.backendsMu.Lock()
+	backend, exists := lb.backends[state.ID]
+	if exists && backend.Discovered {
+		lb.backendsMu.Unlock()
+		return false, "", fmt.Errorf("后端 %s 由服务发现管理，不能%s修改", state.ID, via)
+	}
+	if exists && backend.Registered {
+		lb.backendsMu.Unlock()
+		return false, "", fmt.Errorf("后端 %s 由节点自注册管理，不能%s修改", state.ID, via)
+	}
+	httpAddr, _ := endpoint.addresses(state.Host, state.Port)
+	switch {
+	case !exists:
+		lb.addBackendUnsafe(state.ID, state.Host, state.Port, state.Weight, endpoint)
+		message = fmt.Sprintf("%s添加后端 %s (%s, 权重 %d)", via, state.ID, httpAddr, state.Weight)
+	case backend.HTTPAddress != httpAddr:
+		lb.addBackendUnsafe(state.ID, state.Host, state.Port, state.Weight, endpoint)
+		message = fmt.Sprintf("%s更新后端 %s 地址: %s -> %s", via, state.ID, backend.HTTPAddress, httpAddr)
+	case !reflect.DeepEqual(backend.endpoint.options, state.BackendOptions):
+		lb.addBackendUnsafe(state.ID, state.Host, state.Port, state.Weight, endpoint)
+		message = fmt.Sprintf("%s更新后端 %s 的Host头和TLS设置", via, state.ID)
+	case backend.weight() != state.Weight:
+		message = fmt.Sprintf("%s更新后端 %s 权重: %d -> %d", via, state.ID, backend.weight(), state.Weight)
+		backend.Weight = state.Weight
+	}
+	lb.backendsMu.Unlock()
+
+	if message != "" {
+		log.Print(message)
+		lb.RecordEvent(EventBackendAdded, state.ID, message,
+			map[string]interface{}{"address": httpAddr, "weight": state.Weight})
+	}
+	return !exists, message, nil
+}
+
+// RemoveBackend 移除静态后端，同时删除指向它的会话保持记录，并以1012关闭经它转发的代理连接，
+// 客户端重连后分配到其他后端。被后端池引用的后端需先从池中移除；自注册的后端（节点关闭时注销）不受此限制
+func (lb *LoadBalancer) RemoveBackend(id string) error {
+	_, _, err := lb.removeBackend(id, viaAPI)
+	return err
+}
+
+func (lb *LoadBalancer) removeBackend(id, via string) (orphanCleanup, string, error) {
+	lb.backendsMu.RLock()
+	registered := lb.backends[id] != nil && lb.backends[id].Registered
+	lb.backendsMu.RUnlock()
+
+	lb.poolsMu.RLock()
+	for _, pool := range lb.pools {
+		if pool.backendIDs[id] && !registered {
+			lb.poolsMu.RUnlock()
+			return orphanCleanup{}, "", fmt.Errorf("后端 %s 仍被后端池 %s 引用", id, pool.name)
+		}
+	}
+	lb.poolsMu.RUnlock()
+
+	lb.backendsMu.Lock()
+	backend, exists := lb.backends[id]
+	if !exists {
+		lb.backendsMu.Unlock()
+		return orphanCleanup{}, "", errBackendNotFound
+	}
+	if backend.Discovered {
+		lb.backendsMu.Unlock()
+		return orphanCleanup{}, "", fmt.Errorf("后端 %s 由服务发现管理，不能%s移除", id, via)
+	}
+	delete(lb.backends, id)
+	lb.backendsMu.Unlock()
+
+	cleanup := lb.releaseBackend(backend)
+	message := fmt.Sprintf("%s移除后端 %s", via, id)
+	log.Print(message)
+	lb.RecordEvent(EventBackendRemoved, id, message, map[string]interface{}{
+		"address":            backend.HTTPAddress,
+		"sessions_removed":   cleanup.Sessions,
+		"connections_closed": cleanup.Connections,
+	})
+	return cleanup, message, nil
+}
+
+// PutPool 添加或替换按路径路由的后端池，替换后池内的会话保持不变
+func (lb *LoadBalancer) PutPool(cfg PoolConfig) (created bool, err error) {
+	created, _, err = lb.putPool(cfg, viaAPI)
+	return created, err
+}
+
+func (lb *LoadBalancer) putPool(cfg PoolConfig, via string) (created bool, message string, err error) {
+	if cfg.Name == "" || cfg.Name == DefaultPoolName {
+		return false, "", fmt.Errorf("后端池名称不能为空或 %s（默认池只能修改策略）", DefaultPoolName)
+	}
+	if !strings.HasPrefix(cfg.PathPrefix, "/") {
+		return false, "", fmt.Errorf("后端池 %s 的 path_prefix 必须以 / 开头", cfg.Name)
+	}
+	if cfg.Strategy == "" {
+		cfg.Strategy = lb.defaultPool.getStrategy()
+	}
+	if !cfg.Strategy.valid() {
+		return false, "", fmt.Errorf("后端池 %s 的负载均衡策略无效: %s", cfg.Name, cfg.Strategy)
+	}
+	lb.backendsMu.RLock()
+	for _, id := range cfg.Backends {
+		if _, exists := lb.backends[id]; !exists && lb.discovery == nil && lb.selfRegistration == nil {
+			lb.backendsMu.RUnlock()
+			return false, "", fmt.Errorf("后端池 %s 引用了不存在的后端: %s", cfg.Name, id)
+		}
+	}
+	lb.backendsMu.RUnlock()
+	if cfg.ProtocolVersions != nil {
+		if err := cfg.ProtocolVersions.Validate(); err != nil {
+			return false, "", fmt.Errorf("后端池 %s 的 protocol_versions 无效: %v", cfg.Name, err)
+		}
+	}
+	if err := validatePoolHealthCheck(cfg); err != nil {
+		return false, "", err
+	}
+
+	pool := newBackendPool(cfg.Name, cfg.PathPrefix, cfg.Strategy, cfg.Backends)
+	if cfg.Sticky != nil {
+		pool.sticky = *cfg.Sticky
+	}
+	pool.versions = cfg.ProtocolVersions
+	pool.setHealthCheck(cfg.HealthCheck)
+
+	lb.poolsMu.Lock()
+	pools := make([]*backendPool, 0, len(lb.pools)+1)
+	created = true
+	for _, existing := range lb.pools {
+		if existing.name == cfg.Name {
+			created = false
+			continue
+		}
+		pools = append(pools, existing)
+	}
+	pools = append(pools, pool)
+	if err := checkHealthOverlap(pools); err != nil {
+		lb.poolsMu.Unlock()
+		return false, "", err
+	}
+	sort.SliceStable(pools, func(i, j int) bool { return len(pools[i].pathPrefix) > len(pools[j].pathPrefix) })
+	lb.pools = pools
+	lb.poolsMu.Unlock()
+
+	action := "更新"
+	if created {
+		action = "添加"
+	}
+	message = fmt.Sprintf("%s%s后端池 %s: 路径前缀 %s, 策略 %s, 后端 %v, 会话保持 %v",
+		via, action, cfg.Name, cfg.PathPrefix, cfg.Strategy, cfg.Backends, pool.sticky)
+	if pool.versions != nil {
+		message += ", 协议版本 " + pool.versions.String()
+	}
+	if pool.healthCheck != nil {
+		message += ", 健康检查 " + pool.healthCheck.String()
+	}
+	log.Print(message)
+	lb.RecordEvent(EventConfigChange, "", message, map[string]interface{}{"pool": pool.config()})
+	return created, message, nil
+}
+
+// RemovePool 移除后端池，之后匹配该路径前缀的请求回退到更短的前缀或默认池
+func (lb *LoadBalancer) RemovePool(name string) error {
+	_, err := lb.removePool(name, viaAPI)
+	return err
+}
+
+func (lb *LoadBalancer) removePool(name, via string) (string, error) {
+	lb.poolsMu.Lock()
+	pools := make([]*backendPool, 0, len(lb.pools))
+	found := false
+	for _, pool := range lb.pools {
+		if pool.name == name {
+			found = true
+			continue
+		}
+		pools = append(pools, pool)
+	}
+	lb.pools = pools
+	lb.poolsMu.Unlock()
+
+	if !found {
+		return "", fmt.Errorf("后端池不存在: %s", name)
+	}
+	message := fmt.Sprintf("%s移除后端池 %s", via, name)
+	log.Print(message)
+	lb.RecordEvent(EventConfigChange, "", message, map[string]interface{}{"pool": name})
+	return message, nil
+}
+
+// handleBackendUpdate 添加、更新或移除静态后端: PUT/DELETE /api/backends/{id}
+// PUT {"host": "10.0.0.5", "port": 8081, "weight": 2}
+func (lb *LoadBalancer) handleBackendUpdate(w http.ResponseWriter, r *http.Request, id string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "PUT":
+		var state BackendState
+		if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+			http.Error(w, "请求格式错误", http.StatusBadRequest)
+			return
+		}
+		state.ID = id
+		created, err := lb.PutBackend(state)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"created": created,
+			"backend": state.normalize(),
+		})
+	case "DELETE":
+		cleanup, _, err := lb.removeBackend(id, viaAPI)
+		if err != nil {
+			status := http.StatusConflict
+			if err == errBackendNotFound {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":            true,
+			"id":                 id,
+			"sessions_removed":   cleanup.Sessions,
+			"connections_closed": cleanup.Connections,
+		})
+	default:
+		http.Error(w, "仅支持GET、PUT和DELETE请求", http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePool 单个后端池: /api/pools/{name}
+// GET 查看，PUT 添加或替换（请求体同配置文件中的 pools 条目），DELETE 移除
+func (lb *LoadBalancer) handlePool(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/api/pools/")
+	if name == "" {
+		lb.handlePools(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		pool := lb.findPool(name)
+		if pool == nil {
+			http.Error(w, "后端池不存在: "+name, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(lb.poolStatus(pool))
+	case "PUT":
+		var cfg PoolConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			http.Error(w, "请求格式错误", http.StatusBadRequest)
+			return
+		}
+		cfg.Name = name
+		created, err := lb.PutPool(cfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"created": created,
+			"pool":    lb.poolStatus(lb.findPool(name)),
+		})
+	case "DELETE":
+		if err := lb.RemovePool(name); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "name": name})
+	default:
+		http.Error(w, "仅支持GET、PUT和DELETE请求", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleCluster 导出负载均衡器当前的集群状态，格式与 ctl apply 使用的期望状态文件相同
+func (lb *LoadBalancer) handleCluster(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "仅支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lb.ClusterState())
+}
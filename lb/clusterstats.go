@@ -0,0 +1,196 @@
+package lb
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// NodeMessages 节点的消息收发总数
+type NodeMessages struct {
+	Received      int64 `json:"received"`
+	ReceivedBytes int64 `json:"received_bytes"`
+	Sent          int64 `json:"sent"`
+}
+
+// NodeMemory 节点进程的内存统计，同一进程中的多个节点报告相同的值
+type NodeMemory struct {
+	HeapAlloc           uint64 `json:"heap_alloc"`
+	HeapInuse           uint64 `json:"heap_inuse"`
+	Sys                 uint64 `json:"sys"`
+	NumGC               uint32 `json:"num_gc"`
+	Goroutines          int    `json:"goroutines"`
+	ConnectionsEstimate int64  `json:"connections_estimate"` // 按连接估算的内存
+}
+
+// Throughput 每秒消息数
+type Throughput struct {
+	ReceivedPerSec float64 `json:"received_per_sec"`
+	SentPerSec     float64 `json:"sent_per_sec"`
+}
+
+// NodeStats 单个节点的统计，来自节点的 /api/node-info
+type NodeStats struct {
+	NodeID        string       `json:"node_id"`
+	Clients       int          `json:"clients"`
+	Connections   int          `json:"lb_connections"` // 负载均衡器转发到该节点的连接数
+	StartTime     string       `json:"start_time,omitempty"`
+	UptimeSeconds int64        `json:"uptime_seconds"`
+	Messages      NodeMessages `json:"messages"`
+	Memory        NodeMemory   `json:"memory"`
+	// 与上次查询之间的平均速率；首次查询或节点重启后为启动以来的平均速率
+	Throughput       Throughput `json:"throughput"`
+	ThroughputWindow float64    `json:"throughput_window_seconds"`
+	Error            string     `json:"error,omitempty"` // 后端不健康或查询失败的原因
+}
+
+// ClusterStats 所有节点统计的汇总，nodes按后端ID索引
+type ClusterStats struct {
+	Timestamp        int64                 `json:"timestamp"`
+	Nodes            map[string]*NodeStats `json:"nodes"`
+	NodesTotal       int                   `json:"nodes_total"`
+	NodesQueried     int                   `json:"nodes_queried"`
+	Clients          int                   `json:"clients"`
+	Messages         NodeMessages          `json:"messages"`
+	Throughput       Throughput            `json:"throughput"`
+	HeapAlloc        uint64                `json:"heap_alloc"`
+	Sys              uint64                `json:"sys"`
+	MinUptimeSeconds int64                 `json:"min_uptime_seconds"` // 最近启动的节点的运行时长
+	MaxUptimeSeconds int64                 `json:"max_uptime_seconds"`
+}
+
+// nodeSample 上次查询节点时的消息总数，用于计算查询间隔内的吞吐量
+type nodeSample struct {
+	at        time.Time
+	startTime string
+	received  int64
+	sent      int64
+}
+
+// statsSamples 各节点最近一次的查询样本，零值可用
+type statsSamples struct {
+	mu      sync.Mutex
+	samples map[string]nodeSample
+}
+
+// throughput 根据上次样本计算节点的吞吐量并保存本次样本，返回速率和计算窗口（秒）
+func (c *statsSamples) throughput(id string, node *NodeStats, now time.Time) (Throughput, float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.samples == nil {
+		c.samples = make(map[string]nodeSample)
+	}
+	prev, ok := c.samples[id]
+	c.samples[id] = nodeSample{at: now, startTime: node.StartTime, received: node.Messages.Received, sent: node.Messages.Sent}
+
+	// 节点重启后计数归零，退化为启动以来的平均速率
+	window := now.Sub(prev.at).Seconds()
+	if !ok || prev.startTime != node.StartTime || node.Messages.Received < prev.received || window <= 0 {
+		window = float64(node.UptimeSeconds)
+		if window <= 0 {
+			return Throughput{}, 0
+		}
+		return Throughput{
+			ReceivedPerSec: float64(node.Messages.Received) / window,
+			SentPerSec:     float64(node.Messages.Sent) / window,
+		}, window
+	}
+	return Throughput{
+		ReceivedPerSec: float64(node.Messages.Received-prev.received) / window,
+		SentPerSec:     float64(node.Messages.Sent-prev.sent) / window,
+	}, window
+}
+
+// prune 删除不在ids中的节点样本（后端已移除）
+func (c *statsSamples) prune(ids map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id := range c.samples {
+		if !ids[id] {
+			delete(c.samples, id)
+		}
+	}
+}
+
+// clusterStats 查询健康节点的 /api/node-info 并汇总，不健康的后端只列出不查询
+func (lb *LoadBalancer) clusterStats() *ClusterStats {
+	// 健康状态和连接数由健康检查和代理连接并发修改，在锁内取快照，查询节点时不持锁
+	type backendState struct {
+		*BackendServer
+		healthy     bool
+		connections int
+	}
+	lb.backendsMu.RLock()
+	backends := make([]backendState, 0, len(lb.backends))
+	for _, backend := range lb.backends {
+		backends = append(backends, backendState{backend, backend.IsHealthy, backend.Connections})
+	}
+	lb.backendsMu.RUnlock()
+	sort.Slice(backends, func(i, j int) bool { return backends[i].ID < backends[j].ID })
+
+	now := time.Now()
+	stats := &ClusterStats{
+		Timestamp:  now.Unix(),
+		Nodes:      make(map[string]*NodeStats, len(backends)),
+		NodesTotal: len(backends),
+	}
+	ids := make(map[string]bool, len(backends))
+	for _, backend := range backends {
+		ids[backend.ID] = true
+		if !backend.healthy {
+			stats.Nodes[backend.ID] = &NodeStats{Connections: backend.connections, Error: "后端不健康"}
+			continue
+		}
+		node, err := lb.nodeStats(backend.BackendServer)
+		if err != nil {
+			log.Printf("获取节点 %s 统计失败: %v", backend.ID, err)
+			stats.Nodes[backend.ID] = &NodeStats{Connections: backend.connections, Error: err.Error()}
+			continue
+		}
+		node.Connections = backend.connections
+		node.Throughput, node.ThroughputWindow = lb.statsSamples.throughput(backend.ID, node, now)
+		stats.Nodes[backend.ID] = node
+
+		if stats.NodesQueried == 0 || node.UptimeSeconds < stats.MinUptimeSeconds {
+			stats.MinUptimeSeconds = node.UptimeSeconds
+		}
+		stats.MaxUptimeSeconds = max(stats.MaxUptimeSeconds, node.UptimeSeconds)
+		stats.NodesQueried++
+		stats.Clients += node.Clients
+		stats.Messages.Received += node.Messages.Received
+		stats.Messages.ReceivedBytes += node.Messages.ReceivedBytes
+		stats.Messages.Sent += node.Messages.Sent
+		stats.Throughput.ReceivedPerSec += node.Throughput.ReceivedPerSec
+		stats.Throughput.SentPerSec += node.Throughput.SentPerSec
+		stats.HeapAlloc += node.Memory.HeapAlloc
+		stats.Sys += node.Memory.Sys
+	}
+	lb.statsSamples.prune(ids)
+	return stats
+}
+
+func (lb *LoadBalancer) nodeStats(backend *BackendServer) (*NodeStats, error) {
+	resp, err := backend.endpoint.get(http.DefaultClient, backend.HTTPAddress+"/api/node-info")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var node NodeStats
+	if err := json.NewDecoder(resp.Body).Decode(&node); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// handleClusterStats 集群统计汇总: GET /api/cluster-stats
+func (lb *LoadBalancer) handleClusterStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "仅支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lb.clusterStats())
+}
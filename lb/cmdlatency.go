@@ -0,0 +1,100 @@
+package lb
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+
+	"websocket-loadbalance/protocol"
+)
+
+// NodeCommandLatency 节点的指令时延统计，来自节点的 /api/command-latency
+type NodeCommandLatency struct {
+	NodeID   string                                `json:"node_id"`
+	Pending  int                                   `json:"pending"`
+	Total    *protocol.LatencyHistogram            `json:"total"`
+	Commands map[string]*protocol.LatencyHistogram `json:"commands"`
+	Error    string                                `json:"error,omitempty"` // 查询节点失败时的原因
+}
+
+// FleetCommandLatency 合并所有健康节点的指令时延统计，nodes按后端ID索引
+type FleetCommandLatency struct {
+	BucketsMs    []float64                             `json:"buckets_ms"`
+	Pending      int                                   `json:"pending"`
+	Total        *protocol.LatencyHistogram            `json:"total"`
+	Commands     map[string]*protocol.LatencyHistogram `json:"commands"`
+	Nodes        map[string]*NodeCommandLatency        `json:"nodes"`
+	NodesQueried int                                   `json:"nodes_queried"`
+}
+
+// fleetCommandLatency 查询健康节点的指令时延并合并，command非空时只统计该指令类型
+func (lb *LoadBalancer) fleetCommandLatency(command string) *FleetCommandLatency {
+	lb.backendsMu.RLock()
+	backends := make([]*BackendServer, 0, len(lb.backends))
+	for _, backend := range lb.backends {
+		if backend.IsHealthy {
+			backends = append(backends, backend)
+		}
+	}
+	lb.backendsMu.RUnlock()
+
+	fleet := &FleetCommandLatency{
+		BucketsMs: protocol.LatencyBucketsMs,
+		Total:     protocol.NewLatencyHistogram(),
+		Commands:  make(map[string]*protocol.LatencyHistogram),
+		Nodes:     make(map[string]*NodeCommandLatency, len(backends)),
+	}
+	for _, backend := range backends {
+		node, err := lb.nodeCommandLatency(backend, command)
+		if err != nil {
+			log.Printf("获取节点 %s 指令时延统计失败: %v", backend.ID, err)
+			fleet.Nodes[backend.ID] = &NodeCommandLatency{Error: err.Error()}
+			continue
+		}
+		fleet.Nodes[backend.ID] = node
+		fleet.NodesQueried++
+		fleet.Pending += node.Pending
+		fleet.Total.Merge(node.Total)
+		for name, h := range node.Commands {
+			merged, ok := fleet.Commands[name]
+			if !ok {
+				merged = protocol.NewLatencyHistogram()
+				fleet.Commands[name] = merged
+			}
+			merged.Merge(h)
+		}
+	}
+	fleet.Total.Summarize()
+	for _, h := range fleet.Commands {
+		h.Summarize()
+	}
+	return fleet
+}
+
+func (lb *LoadBalancer) nodeCommandLatency(backend *BackendServer, command string) (*NodeCommandLatency, error) {
+	nodeURL := backend.HTTPAddress + "/api/command-latency"
+	if command != "" {
+		nodeURL += "?command=" + url.QueryEscape(command)
+	}
+	resp, err := backend.endpoint.get(http.DefaultClient, nodeURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var node NodeCommandLatency
+	if err := json.NewDecoder(resp.Body).Decode(&node); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// handleCommandLatency 集群的指令时延统计: GET /api/command-latency?command=restart
+func (lb *LoadBalancer) handleCommandLatency(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "仅支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lb.fleetCommandLatency(r.URL.Query().Get("command")))
+}
@@ -0,0 +1,77 @@
+package lb
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+)
+
+// 二进制编码（msgpack/protobuf）通过子协议协商：负载均衡器向客户端回应该子协议，
+// 并把客户端的子协议列表透传给后端，后端同样选中该编码时帧原样转发，不做解码。
+// 后端未选中（如epoll模式的节点只支持JSON）时由负载均衡器在二进制帧和JSON文本帧之间转换
+
+// codecUpgradeHeader 客户端请求了支持的二进制编码时，返回回应该子协议的握手响应头
+func codecUpgradeHeader(r *http.Request, header http.Header) http.Header {
+	if codec := protocol.NegotiateCodec(websocket.Subprotocols(r)); codec != nil {
+		return http.Header{"Sec-WebSocket-Protocol": {codec.Name()}}
+	}
+	return header
+}
+
+// transcodeMessages 逐条转发src的消息，fromType类型的消息经convert转换后以toType类型写出，
+// 其他消息与proxyMessages一样流式转发。待转换的消息读入复用的缓冲区，每条消息只有转换结果需要分配。
+// counter非nil时累加从src读取的消息负载字节数（转换前），shadow非nil时将写出的消息复制到影子后端
+func (p *bufferPools) transcodeMessages(dst, src *websocket.Conn, fromType, toType int, convert func([]byte) ([]byte, error), counter *atomic.Int64, shadow *shadowConn) error {
+	bufp := p.copyBuffers.Get().(*[]byte)
+	defer p.copyBuffers.Put(bufp)
+	message := p.getMessageBuffer()
+	defer p.putMessageBuffer(message)
+	var mirror *bytes.Buffer
+	if shadow != nil {
+		mirror = message
+	}
+
+	for {
+		messageType, reader, err := src.NextReader()
+		if err != nil {
+			return err
+		}
+		if messageType != fromType {
+			n, err := copyMessage(dst, messageType, reader, *bufp, mirror)
+			if counter != nil {
+				counter.Add(n)
+			}
+			if err != nil {
+				return err
+			}
+			if mirror != nil {
+				shadow.send(messageType, bytes.Clone(mirror.Bytes()))
+			}
+			continue
+		}
+
+		message.Reset()
+		n, err := message.ReadFrom(reader)
+		if counter != nil {
+			counter.Add(n)
+		}
+		if err != nil {
+			return err
+		}
+		// 转换结果是新分配的，不引用message，可以直接交给影子连接
+		converted, err := convert(message.Bytes())
+		if err != nil {
+			log.Printf("消息编码转换失败，已丢弃: %v", err)
+			continue
+		}
+		if err := dst.WriteMessage(toType, converted); err != nil {
+			return err
+		}
+		shadow.send(toType, converted)
+	}
+}
@@ -0,0 +1,358 @@
+package lb
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"websocket-loadbalance/forwarded"
+	"websocket-loadbalance/perf"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/secrets"
+)
+
+// BackendConfig 后端服务器配置
+type BackendConfig struct {
+	ID   string `json:"id" yaml:"id"`
+	Host string `json:"host" yaml:"host"` // 拨号地址，默认localhost
+	Port int    `json:"port" yaml:"port"`
+	// 完整的拨号地址 host:port，如 "10.0.0.5:8081"、"[2001:db8::5]:8081"，与 host、port 二选一
+	Address string `json:"address" yaml:"address"`
+	// 加权策略使用的权重，默认1
+	Weight int `json:"weight" yaml:"weight"`
+	// Host头和TLS设置，与拨号地址相互独立
+	BackendOptions `yaml:",inline"`
+}
+
+// state 转换为填充了默认值的后端状态，与通过API添加的后端使用相同的默认值
+func (c BackendConfig) state() BackendState {
+	host, port := c.Host, c.Port
+	if c.Address != "" {
+		// 地址已在Validate中校验
+		host, port, _ = protocol.SplitHostPort(c.Address)
+	}
+	return BackendState{ID: c.ID, Host: host, Port: port, Weight: c.Weight, BackendOptions: c.BackendOptions}.normalize()
+}
+
+// validateAddress 校验后端的拨号地址：address 与 host、port 二选一，未使用Unix域套接字时必须有端口
+func (c BackendConfig) validateAddress() error {
+	if c.Address != "" {
+		if c.Host != "" || c.Port != 0 {
+			return fmt.Errorf("address 与 host、port 不能同时设置")
+		}
+		if _, _, err := protocol.SplitHostPort(c.Address); err != nil {
+			return err
+		}
+		return nil
+	}
+	if c.Port <= 0 && c.Socket == "" {
+		return fmt.Errorf("无效的端口: %d", c.Port)
+	}
+	return nil
+}
+
+// HealthCheckConfig 健康检查配置
+type HealthCheckConfig struct {
+	Interval protocol.Duration `json:"interval" yaml:"interval"` // 检查间隔
+	Timeout  protocol.Duration `json:"timeout" yaml:"timeout"`   // 单次检查超时
+
+	HealthyThreshold   int    `json:"healthy_threshold" yaml:"healthy_threshold"`     // 连续成功多少次后恢复健康
+	UnhealthyThreshold int    `json:"unhealthy_threshold" yaml:"unhealthy_threshold"` // 连续失败多少次后判定不健康
+	Protocol           string `json:"protocol" yaml:"protocol"`                       // 探测方式: http(默认) 或 websocket
+	Path               string `json:"path" yaml:"path"`                               // HTTP探测路径，默认 /health
+
+	HistorySize   int               `json:"history_size" yaml:"history_size"`     // 每个后端保留的探测结果数
+	FlapThreshold float64           `json:"flap_threshold" yaml:"flap_threshold"` // 相邻探测结果切换比例达到该值判定为抖动(0~1)
+	HoldDown      protocol.Duration `json:"hold_down" yaml:"hold_down"`           // 抖动后不恢复健康的抑制时长
+}
+
+// Config 负载均衡器配置
+type Config struct {
+	Port        int               `json:"port" yaml:"port"`
+	Socket      string            `json:"socket" yaml:"socket"` // 同时监听的Unix域套接字，如 unix:///run/ws/lb.sock
+	Strategy    Strategy          `json:"strategy" yaml:"strategy"`
+	Backends    []BackendConfig   `json:"backends" yaml:"backends"`
+	HealthCheck HealthCheckConfig `json:"health_check" yaml:"health_check"`
+	Sessions    SessionConfig     `json:"sessions" yaml:"sessions"`
+	// 连接后端失败时切换到其他健康后端的最大重试次数
+	ProxyRetries int `json:"proxy_retries" yaml:"proxy_retries"`
+	// 集群事件时间线
+	Timeline TimelineConfig `json:"timeline" yaml:"timeline"`
+	// 按路径路由的后端池，各自使用独立的负载均衡策略
+	Pools []PoolConfig `json:"pools" yaml:"pools"`
+	// 从etcd或Consul动态发现后端，与静态配置的后端共存
+	Discovery DiscoveryConfig `json:"discovery" yaml:"discovery"`
+	// 允许节点通过 POST /api/backends 自注册并定期发送心跳
+	SelfRegistration SelfRegistrationConfig `json:"self_registration" yaml:"self_registration"`
+	// 按来源IP放行或拒绝客户端请求
+	ACL ACLConfig `json:"acl" yaml:"acl"`
+	// 记录每个转发的HTTP请求和WebSocket会话
+	AccessLog AccessLogConfig `json:"access_log" yaml:"access_log"`
+	// 监听的主机地址（为空表示所有地址）和地址族（dual、ipv4、ipv6），
+	// 地址族为ipv4或ipv6时只绑定该地址族，连接后端时也优先使用该地址族的地址
+	ListenAddress string `json:"listen_address" yaml:"listen_address"`
+	AddressFamily string `json:"address_family" yaml:"address_family"`
+	// 通过ACME自动申请和续期证书，启用后端口提供HTTPS/WSS
+	Autocert AutocertConfig `json:"autocert" yaml:"autocert"`
+	// 使用证书文件提供HTTPS/WSS，文件变化后自动重新加载
+	TLS TLSConfig `json:"tls" yaml:"tls"`
+	// 代理连接两侧单条消息的最大字节数，0表示不限制
+	MaxMessageSize int64 `json:"max_message_size" yaml:"max_message_size"`
+	// 只读观察者：镜像主负载均衡器的状态，只提供只读API，拒绝修改和客户端连接
+	Observer ObserverConfig `json:"observer" yaml:"observer"`
+	// 影子流量：将一定比例连接的客户端消息单向复制到影子后端池
+	Shadow ShadowConfig `json:"shadow" yaml:"shadow"`
+	// 受信的上游代理：采信其 X-Forwarded-For 和PROXY协议头中的客户端地址，用于ip_hash、访问控制和访问日志
+	Forwarded forwarded.Config `json:"forwarded" yaml:"forwarded"`
+	// 连接后端WebSocket时先发送PROXY协议v2头，节点需在 forwarded 中启用 proxy_protocol 并信任负载均衡器的地址
+	BackendProxyProtocol bool `json:"backend_proxy_protocol" yaml:"backend_proxy_protocol"`
+	// 负载均衡器使用的认证方式（none、jwt、static、http），为空时沿用 auth 中的设置
+	AuthProvider string `json:"auth_provider" yaml:"auth_provider"`
+	// 集群健康状态的计算参数，状态见 /api/status 和公开状态页 /status
+	Status StatusConfig `json:"status" yaml:"status"`
+	// 后端的最低构建版本（如 v1.4.0），低于该版本的后端记入时间线，为空表示不检查
+	MinBackendVersion string `json:"min_backend_version" yaml:"min_backend_version"`
+}
+
+// DefaultConfig 返回默认的负载均衡器配置（8080端口，后端为8081-8083）
+func DefaultConfig() Config {
+	return Config{
+		Port:     8080,
+		Strategy: RoundRobin,
+		Backends: []BackendConfig{
+			{ID: "node1", Port: 8081},
+			{ID: "node2", Port: 8082},
+			{ID: "node3", Port: 8083},
+		},
+		HealthCheck: HealthCheckConfig{
+			Interval:           protocol.Duration(10 * time.Second),
+			Timeout:            protocol.Duration(5 * time.Second),
+			HealthyThreshold:   1,
+			UnhealthyThreshold: 1,
+			Protocol:           HealthProbeHTTP,
+			Path:               "/health",
+			HistorySize:        20,
+			FlapThreshold:      0.5,
+			HoldDown:           protocol.Duration(time.Minute),
+		},
+		Sessions: SessionConfig{
+			TTL:             protocol.Duration(24 * time.Hour),
+			CleanupInterval: protocol.Duration(time.Minute),
+			Store:           "none",
+		},
+		ProxyRetries: 2,
+	}
+}
+
+// Validate 校验负载均衡器配置
+func (c Config) Validate() error {
+	if !c.Strategy.valid() {
+		return fmt.Errorf("无效的负载均衡策略: %s (可选: round_robin, least_conn, ip_hash, consistent_hash, ketama, affinity)", c.Strategy)
+	}
+	if err := protocol.ValidateAddressFamily(c.AddressFamily); err != nil {
+		return err
+	}
+
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("无效的负载均衡器端口: %d", c.Port)
+	}
+	if c.Autocert.Enabled && c.Autocert.HTTPPort == c.Port {
+		return fmt.Errorf("autocert.http_port 与负载均衡器端口 %d 冲突", c.Port)
+	}
+
+	seen := make(map[string]bool)
+	addresses := make(map[string]string) // 拨号地址 -> 后端ID
+	for _, backend := range c.Backends {
+		if backend.ID == "" {
+			return fmt.Errorf("后端配置无效: id为空")
+		}
+		if err := backend.validateAddress(); err != nil {
+			return fmt.Errorf("后端 %s 配置无效: %v", backend.ID, err)
+		}
+		if seen[backend.ID] {
+			return fmt.Errorf("后端ID重复: %s", backend.ID)
+		}
+		seen[backend.ID] = true
+		if backend.Socket == "" {
+			state := backend.state()
+			address := net.JoinHostPort(state.Host, strconv.Itoa(state.Port))
+			if other, ok := addresses[address]; ok {
+				return fmt.Errorf("后端 %s 与 %s 的地址相同: %s", backend.ID, other, address)
+			}
+			addresses[address] = backend.ID
+			if state.Port == c.Port && protocol.IsLoopbackHost(state.Host) {
+				return fmt.Errorf("后端 %s 指向负载均衡器自身的端口 %d", backend.ID, c.Port)
+			}
+		}
+		if backend.Weight < 0 {
+			return fmt.Errorf("后端 %s 的权重不能为负数", backend.ID)
+		}
+		if err := backend.BackendOptions.Validate(); err != nil {
+			return fmt.Errorf("后端 %s: %v", backend.ID, err)
+		}
+	}
+
+	if c.HealthCheck.Interval <= 0 {
+		return fmt.Errorf("健康检查间隔必须大于0")
+	}
+	if c.HealthCheck.HealthyThreshold < 0 || c.HealthCheck.UnhealthyThreshold < 0 {
+		return fmt.Errorf("健康检查阈值不能为负数")
+	}
+	if c.HealthCheck.FlapThreshold < 0 || c.HealthCheck.FlapThreshold > 1 {
+		return fmt.Errorf("flap_threshold 必须在0到1之间")
+	}
+	poolNames := map[string]bool{DefaultPoolName: true}
+	for _, pool := range c.Pools {
+		if pool.Name == "" || poolNames[pool.Name] {
+			return fmt.Errorf("后端池名称为空或重复: %q", pool.Name)
+		}
+		poolNames[pool.Name] = true
+		if !strings.HasPrefix(pool.PathPrefix, "/") {
+			return fmt.Errorf("后端池 %s 的 path_prefix 必须以 / 开头", pool.Name)
+		}
+		if pool.Strategy != "" && !pool.Strategy.valid() {
+			return fmt.Errorf("后端池 %s 的负载均衡策略无效: %s", pool.Name, pool.Strategy)
+		}
+		if pool.ProtocolVersions != nil {
+			if err := pool.ProtocolVersions.Validate(); err != nil {
+				return fmt.Errorf("后端池 %s 的 protocol_versions 无效: %v", pool.Name, err)
+			}
+		}
+		for _, id := range pool.Backends {
+			// 启用服务发现或自注册时后端可能在运行时才出现
+			if !seen[id] && c.Discovery.Provider == "" && !c.SelfRegistration.Enabled {
+				return fmt.Errorf("后端池 %s 引用了不存在的后端: %s", pool.Name, id)
+			}
+		}
+	}
+
+	switch c.HealthCheck.Protocol {
+	case "", HealthProbeHTTP, HealthProbeWebSocket:
+	default:
+		return fmt.Errorf("无效的健康检查方式: %s (可选: http, websocket)", c.HealthCheck.Protocol)
+	}
+	if err := c.ACL.Validate(); err != nil {
+		return err
+	}
+	if err := c.AccessLog.Validate(); err != nil {
+		return err
+	}
+	if c.MaxMessageSize < 0 {
+		return fmt.Errorf("max_message_size 不能为负数")
+	}
+	if err := c.Autocert.Validate(); err != nil {
+		return err
+	}
+	if err := c.TLS.Validate(); err != nil {
+		return err
+	}
+	if err := c.Observer.Validate(); err != nil {
+		return err
+	}
+	if err := c.Shadow.Validate(); err != nil {
+		return err
+	}
+	if err := c.Status.Validate(); err != nil {
+		return err
+	}
+	if c.MinBackendVersion != "" {
+		if _, _, err := protocol.ParseBuildVersion(c.MinBackendVersion); err != nil {
+			return fmt.Errorf("min_backend_version: %v", err)
+		}
+	}
+	if err := c.Forwarded.Validate(); err != nil {
+		return err
+	}
+	if err := c.SelfRegistration.Validate(); err != nil {
+		return err
+	}
+	if err := c.Sessions.Validate(); err != nil {
+		return err
+	}
+	if c.TLS.Enabled && c.Autocert.Enabled {
+		return fmt.Errorf("tls 和 autocert 不能同时启用")
+	}
+	if c.Autocert.Enabled && c.Autocert.Cache == CertCacheRegistry && c.Discovery.Provider == "" {
+		return fmt.Errorf("证书缓存 registry 需要配置 discovery 的 consul 或 etcd 注册中心")
+	}
+	return c.Discovery.Validate()
+}
+
+// NewFromConfig 按配置创建负载均衡器并添加后端
+func NewFromConfig(cfg Config, perfSettings perf.Settings) (*LoadBalancer, error) {
+	lb := New(cfg.Port, cfg.Strategy)
+	lb.SetListenAddress(cfg.ListenAddress, cfg.AddressFamily)
+	lb.SetUnixSocket(cfg.Socket)
+	lb.SetPerformance(perfSettings)
+	lb.SetHealthCheck(time.Duration(cfg.HealthCheck.Interval), time.Duration(cfg.HealthCheck.Timeout))
+	lb.SetHealthThresholds(cfg.HealthCheck.HealthyThreshold, cfg.HealthCheck.UnhealthyThreshold)
+	lb.SetHealthProbe(cfg.HealthCheck.Protocol, cfg.HealthCheck.Path)
+	lb.SetFlapDetection(cfg.HealthCheck.HistorySize, cfg.HealthCheck.FlapThreshold, time.Duration(cfg.HealthCheck.HoldDown))
+
+	sessionStore, err := NewSessionStore(cfg.Sessions)
+	if err != nil {
+		return nil, fmt.Errorf("创建会话存储失败: %v", err)
+	}
+	lb.SetProxyRetries(cfg.ProxyRetries)
+	lb.SetMaxMessageSize(cfg.MaxMessageSize)
+	lb.SetTimeline(cfg.Timeline)
+	lb.SetStatus(cfg.Status)
+	if err := lb.SetMinBackendVersion(cfg.MinBackendVersion); err != nil {
+		return nil, err
+	}
+	lb.SetSessionPersistence(time.Duration(cfg.Sessions.TTL), time.Duration(cfg.Sessions.CleanupInterval), sessionStore)
+	lb.SetNonStickyClientTypes(cfg.Sessions.NonStickyClientTypes)
+	lb.SetPeekRegistration(cfg.Sessions.PeekRegistration)
+	lb.SetObserver(cfg.Observer)
+	if err := lb.SetACL(cfg.ACL); err != nil {
+		return nil, err
+	}
+	if err := lb.SetAccessLog(cfg.AccessLog); err != nil {
+		return nil, err
+	}
+	if err := lb.SetShadow(cfg.Shadow); err != nil {
+		return nil, err
+	}
+	resolver, err := forwarded.New(cfg.Forwarded)
+	if err != nil {
+		return nil, err
+	}
+	lb.SetForwarded(resolver)
+	lb.SetBackendProxyProtocol(cfg.BackendProxyProtocol)
+
+	// 添加后端服务器（传入端口号，不再是ws地址）
+	for _, backend := range cfg.Backends {
+		if err := lb.AddBackendConfig(backend); err != nil {
+			return nil, err
+		}
+	}
+	discovery, err := NewDiscovery(cfg.Discovery)
+	if err != nil {
+		return nil, fmt.Errorf("创建服务发现失败: %v", err)
+	}
+	lb.SetDiscovery(discovery)
+	if cfg.SelfRegistration.Enabled {
+		token, err := secrets.Resolve(cfg.SelfRegistration.Token)
+		if err != nil {
+			return nil, fmt.Errorf("读取 self_registration.token 失败: %v", err)
+		}
+		lb.SetSelfRegistration(time.Duration(cfg.SelfRegistration.TTL), token)
+	}
+	certCache, err := NewCertCache(cfg.Autocert, cfg.Discovery)
+	if err != nil {
+		return nil, fmt.Errorf("创建证书缓存失败: %v", err)
+	}
+	if err := lb.SetAutocert(cfg.Autocert, certCache); err != nil {
+		return nil, err
+	}
+	if err := lb.SetTLS(cfg.TLS); err != nil {
+		return nil, err
+	}
+	if err := lb.SetPools(cfg.Pools); err != nil {
+		return nil, err
+	}
+	lb.startupConfig = &cfg
+	return lb, nil
+}
@@ -0,0 +1,481 @@
+package lb
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/secrets"
+)
+
+// 服务发现提供方
+const (
+	DiscoveryConsul = "consul"
+	DiscoveryEtcd   = "etcd"
+)
+
+// DiscoveryConfig 从注册中心发现后端，provider为空表示只使用静态配置的后端
+type DiscoveryConfig struct {
+	Provider string            `json:"provider" yaml:"provider"` // consul 或 etcd
+	Address  string            `json:"address" yaml:"address"`   // 注册中心HTTP地址，如 http://127.0.0.1:8500
+	Service  string            `json:"service" yaml:"service"`   // Consul服务名
+	Prefix   string            `json:"prefix" yaml:"prefix"`     // etcd键前缀，如 /websocket/backends/
+	Token    string            `json:"token" yaml:"token"`       // Consul ACL令牌（可选），可以是 env:/file:/vault: 引用
+	Interval protocol.Duration `json:"interval" yaml:"interval"` // 长轮询等待时长和出错后的重试间隔，默认30s
+}
+
+// Validate 校验服务发现配置
+func (c DiscoveryConfig) Validate() error {
+	switch c.Provider {
+	case "":
+		return nil
+	case DiscoveryConsul:
+		if c.Service == "" {
+			return fmt.Errorf("consul 服务发现需要配置 service")
+		}
+	case DiscoveryEtcd:
+		if c.Prefix == "" {
+			return fmt.Errorf("etcd 服务发现需要配置 prefix")
+		}
+	default:
+		return fmt.Errorf("无效的服务发现类型: %s (可选: consul, etcd)", c.Provider)
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("discovery.interval 不能为负数")
+	}
+	if err := secrets.ValidateRef(c.Token); err != nil {
+		return fmt.Errorf("discovery.token: %v", err)
+	}
+	return nil
+}
+
+// DiscoveredBackend 注册中心中的一个后端实例
+type DiscoveredBackend struct {
+	ID     string `json:"id"`
+	Host   string `json:"host"`
+	Port   int    `json:"port"`
+	Weight int    `json:"weight"`
+}
+
+// Discovery 后端服务发现
+type Discovery interface {
+	// Watch 持续监听注册中心直到ctx结束，每次后端列表变化时以完整列表调用update
+	Watch(ctx context.Context, update func([]DiscoveredBackend))
+	// String 用于日志的描述
+	String() string
+}
+
+// NewDiscovery 根据配置创建服务发现，provider为空时返回nil
+func NewDiscovery(cfg DiscoveryConfig) (Discovery, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	interval := time.Duration(cfg.Interval)
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	switch cfg.Provider {
+	case DiscoveryConsul:
+		if cfg.Address == "" {
+			cfg.Address = "http://127.0.0.1:8500"
+		}
+		// 令牌每次查询时读取，轮换后下一次查询即使用新令牌
+		token, err := secrets.Resolve(cfg.Token)
+		if err != nil {
+			return nil, fmt.Errorf("读取 discovery.token 失败: %v", err)
+		}
+		return &consulDiscovery{
+			address:  strings.TrimSuffix(cfg.Address, "/"),
+			service:  cfg.Service,
+			token:    token,
+			interval: interval,
+			// 阻塞查询最长等待interval，客户端超时需要留出余量
+			client: &http.Client{Timeout: interval + 10*time.Second},
+		}, nil
+	case DiscoveryEtcd:
+		if cfg.Address == "" {
+			cfg.Address = "http://127.0.0.1:2379"
+		}
+		return &etcdDiscovery{
+			address:  strings.TrimSuffix(cfg.Address, "/"),
+			prefix:   cfg.Prefix,
+			interval: interval,
+			client:   &http.Client{Timeout: 10 * time.Second},
+			// watch是长连接流，不能设置整体超时
+			watchClient: &http.Client{},
+		}, nil
+	}
+	return nil, nil
+}
+
+// SetDiscovery 启用服务发现（需在Start之前调用），传nil表示只使用静态后端
+func (lb *LoadBalancer) SetDiscovery(d Discovery) {
+	lb.discovery = d
+}
+
+// runDiscovery 监听注册中心并同步后端列表
+func (lb *LoadBalancer) runDiscovery(ctx context.Context) {
+	log.Printf("启用服务发现: %s", lb.discovery)
+	lb.discovery.Watch(ctx, lb.syncDiscoveredBackends)
+}
+
+// syncDiscoveredBackends 按注册中心的完整列表增删后端、更新地址和权重。
+// 静态配置的后端不受影响；被移除后端的会话保持记录和代理连接随之清理
+func (lb *LoadBalancer) syncDiscoveredBackends(list []DiscoveredBackend) {
+	type change struct {
+		event, backendID, message string
+		details                   map[string]interface{}
+	}
+	var changes []change
+
+	lb.backendsMu.Lock()
+	current := make(map[string]bool, len(list))
+	for _, d := range list {
+		if d.Weight <= 0 {
+			d.Weight = 1
+		}
+		current[d.ID] = true
+
+		backend, exists := lb.backends[d.ID]
+		if exists && !backend.Discovered {
+			log.Printf("服务发现的后端 %s 与静态配置的后端重名，忽略", d.ID)
+			continue
+		}
+		httpAddr := fmt.Sprintf("http://%s", net.JoinHostPort(d.Host, strconv.Itoa(d.Port)))
+		details := map[string]interface{}{"address": httpAddr, "weight": d.Weight}
+		switch {
+		case !exists:
+			backend = lb.addBackendUnsafe(d.ID, d.Host, d.Port, d.Weight, nil)
+			backend.Discovered = true
+			changes = append(changes, change{EventBackendAdded, d.ID, fmt.Sprintf("服务发现添加后端 %s (%s, 权重 %d)", d.ID, httpAddr, d.Weight), details})
+		case backend.HTTPAddress != httpAddr:
+			// 地址变化时按新后端重建，健康状态重新探测
+			lb.addBackendUnsafe(d.ID, d.Host, d.Port, d.Weight, nil).Discovered = true
+			changes = append(changes, change{EventBackendAdded, d.ID, fmt.Sprintf("服务发现更新后端 %s 地址: %s -> %s", d.ID, backend.HTTPAddress, httpAddr), details})
+		case backend.Weight != d.Weight:
+			log.Printf("服务发现更新后端 %s 权重: %d -> %d", d.ID, backend.Weight, d.Weight)
+			backend.Weight = d.Weight
+		}
+	}
+	var removed []*BackendServer
+	for id, backend := range lb.backends {
+		if backend.Discovered && !current[id] {
+			delete(lb.backends, id)
+			removed = append(removed, backend)
+		}
+	}
+	lb.backendsMu.Unlock()
+
+	for _, backend := range removed {
+		cleanup := lb.releaseBackend(backend)
+		log.Printf("服务发现移除后端: %s", backend.ID)
+		changes = append(changes, change{EventBackendRemoved, backend.ID, fmt.Sprintf("服务发现移除后端 %s", backend.ID),
+			map[string]interface{}{
+				"address":            backend.HTTPAddress,
+				"sessions_removed":   cleanup.Sessions,
+				"connections_closed": cleanup.Connections,
+			}})
+	}
+	for _, c := range changes {
+		lb.RecordEvent(c.event, c.backendID, c.message, c.details)
+	}
+}
+
+// consulDiscovery 通过Consul健康检查API的阻塞查询发现通过检查的服务实例
+type consulDiscovery struct {
+	address  string
+	service  string
+	token    *secrets.Secret
+	interval time.Duration
+	client   *http.Client
+}
+
+func (d *consulDiscovery) String() string {
+	return fmt.Sprintf("consul %s 服务 %s", d.address, d.service)
+}
+
+// consulServiceEntry /v1/health/service 返回的条目（只解析用到的字段）
+type consulServiceEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		ID      string
+		Address string
+		Port    int
+		Meta    map[string]string
+		Weights struct {
+			Passing int
+		}
+	}
+}
+
+func (d *consulDiscovery) Watch(ctx context.Context, update func([]DiscoveredBackend)) {
+	var index string
+	for ctx.Err() == nil {
+		backends, newIndex, err := d.query(ctx, index)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("查询Consul服务 %s 失败: %v", d.service, err)
+				sleepContext(ctx, d.interval)
+			}
+			// 出错后重新做一次完整查询
+			index = ""
+			continue
+		}
+		// 索引未变化说明阻塞查询超时返回，列表没有变化
+		if newIndex != index || index == "" {
+			update(backends)
+		}
+		if newIndex == "" {
+			// 没有返回索引时无法阻塞查询，退化为定时轮询
+			sleepContext(ctx, d.interval)
+		}
+		index = newIndex
+	}
+}
+
+// query 执行一次阻塞查询，index为空时立即返回当前列表
+func (d *consulDiscovery) query(ctx context.Context, index string) ([]DiscoveredBackend, string, error) {
+	params := url.Values{"passing": {"true"}}
+	if index != "" {
+		params.Set("index", index)
+		params.Set("wait", d.interval.String())
+	}
+	reqURL := fmt.Sprintf("%s/v1/health/service/%s?%s", d.address, url.PathEscape(d.service), params.Encode())
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if token := d.token.Value(); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, "", fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, "", fmt.Errorf("解析响应失败: %v", err)
+	}
+
+	backends := make([]DiscoveredBackend, 0, len(entries))
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		// 权重优先取服务元数据中的weight，其次取Consul的Weights.Passing
+		weight := entry.Service.Weights.Passing
+		if w, err := strconv.Atoi(entry.Service.Meta["weight"]); err == nil {
+			weight = w
+		}
+		backends = append(backends, DiscoveredBackend{
+			ID:     entry.Service.ID,
+			Host:   host,
+			Port:   entry.Service.Port,
+			Weight: weight,
+		})
+	}
+	return backends, resp.Header.Get("X-Consul-Index"), nil
+}
+
+// etcdDiscovery 通过etcd v3的HTTP/JSON网关读取键前缀下的后端并监听变化。
+// 每个键的值为 {"id": "node4", "host": "10.0.0.4", "port": 8084, "weight": 2}，
+// id为空时使用键名最后一段，也可以直接写 "host:port"
+type etcdDiscovery struct {
+	address     string
+	prefix      string
+	interval    time.Duration
+	client      *http.Client
+	watchClient *http.Client
+}
+
+func (d *etcdDiscovery) String() string {
+	return fmt.Sprintf("etcd %s 前缀 %s", d.address, d.prefix)
+}
+
+// etcd网关的JSON中字节字段为base64编码，int64字段为字符串
+type etcdKeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type etcdRangeResponse struct {
+	Header struct {
+		Revision string `json:"revision"`
+	} `json:"header"`
+	Kvs []etcdKeyValue `json:"kvs"`
+}
+
+type etcdWatchResponse struct {
+	Result struct {
+		Created  bool            `json:"created"`
+		Canceled bool            `json:"canceled"`
+		Events   json.RawMessage `json:"events"`
+	} `json:"result"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (d *etcdDiscovery) Watch(ctx context.Context, update func([]DiscoveredBackend)) {
+	for ctx.Err() == nil {
+		backends, revision, err := d.list(ctx)
+		if err == nil {
+			update(backends)
+			// 阻塞直到前缀下有变化，之后重新读取完整列表
+			err = d.watch(ctx, revision+1)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("监听etcd前缀 %s 失败: %v", d.prefix, err)
+			sleepContext(ctx, d.interval)
+		}
+	}
+}
+
+// list 读取前缀下的全部后端，返回读取时的revision
+func (d *etcdDiscovery) list(ctx context.Context) ([]DiscoveredBackend, int64, error) {
+	body, _ := json.Marshal(map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(d.prefix)),
+		"range_end": base64.StdEncoding.EncodeToString(prefixRangeEnd(d.prefix)),
+	})
+	req, err := http.NewRequestWithContext(ctx, "POST", d.address+"/v3/kv/range", bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, 0, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var result etcdRangeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, 0, fmt.Errorf("解析响应失败: %v", err)
+	}
+	revision, _ := strconv.ParseInt(result.Header.Revision, 10, 64)
+
+	backends := make([]DiscoveredBackend, 0, len(result.Kvs))
+	for _, kv := range result.Kvs {
+		key, _ := base64.StdEncoding.DecodeString(kv.Key)
+		value, _ := base64.StdEncoding.DecodeString(kv.Value)
+		backend, err := parseEtcdBackend(string(key), value)
+		if err != nil {
+			log.Printf("忽略etcd中无效的后端 %s: %v", key, err)
+			continue
+		}
+		backends = append(backends, backend)
+	}
+	return backends, revision, nil
+}
+
+// watch 从指定revision开始监听前缀，收到第一批变更事件时返回nil
+func (d *etcdDiscovery) watch(ctx context.Context, startRevision int64) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            base64.StdEncoding.EncodeToString([]byte(d.prefix)),
+			"range_end":      base64.StdEncoding.EncodeToString(prefixRangeEnd(d.prefix)),
+			"start_revision": strconv.FormatInt(startRevision, 10),
+		},
+	})
+	req, err := http.NewRequestWithContext(ctx, "POST", d.address+"/v3/watch", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.watchClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("watch HTTP %d", resp.StatusCode)
+	}
+
+	// 网关以换行分隔的JSON流返回watch响应
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var msg etcdWatchResponse
+		if err := decoder.Decode(&msg); err != nil {
+			return fmt.Errorf("watch 流中断: %v", err)
+		}
+		switch {
+		case msg.Error != nil:
+			return fmt.Errorf("watch 错误: %s", msg.Error.Message)
+		case msg.Result.Canceled:
+			return fmt.Errorf("watch 被etcd取消")
+		case len(msg.Result.Events) > 0 && string(msg.Result.Events) != "null":
+			return nil
+		}
+	}
+}
+
+// parseEtcdBackend 解析etcd中的后端值
+func parseEtcdBackend(key string, value []byte) (DiscoveredBackend, error) {
+	var backend DiscoveredBackend
+	if err := json.Unmarshal(value, &backend); err != nil {
+		host, port, splitErr := net.SplitHostPort(strings.TrimSpace(string(value)))
+		if splitErr != nil {
+			return backend, fmt.Errorf("值既不是JSON也不是host:port")
+		}
+		backend.Host = host
+		backend.Port, _ = strconv.Atoi(port)
+	}
+	if backend.ID == "" {
+		backend.ID = key[strings.LastIndex(key, "/")+1:]
+	}
+	if backend.ID == "" || backend.Host == "" || backend.Port <= 0 {
+		return backend, fmt.Errorf("缺少id、host或port")
+	}
+	return backend, nil
+}
+
+// prefixRangeEnd 计算前缀查询的range_end：前缀最后一个可递增字节加1
+func prefixRangeEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// 前缀全为0xff时查询到键空间末尾
+	return []byte{0}
+}
+
+// sleepContext 等待指定时长或ctx结束
+func sleepContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
@@ -0,0 +1,180 @@
+package lb
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// DrainStatus 后端排空进度
+type DrainStatus struct {
+	BackendID          string     `json:"backend_id"`
+	Draining           bool       `json:"draining"`
+	StartedAt          *time.Time `json:"started_at,omitempty"`
+	DrainedAt          *time.Time `json:"drained_at,omitempty"` // 连接全部断开的时间
+	InitialConnections int        `json:"initial_connections"`  // 开始排空时经本负载均衡器转发的连接数
+	Connections        int        `json:"connections"`          // 剩余连接数
+	ReportedClients    int        `json:"reported_clients"`     // 后端 /health 上报的连接数（含其他负载均衡器转发的连接）
+	Progress           float64    `json:"progress"`             // 已断开的比例，0~1
+	Drained            bool       `json:"drained"`              // 剩余连接为0，可以安全重启
+	Elapsed            string     `json:"elapsed,omitempty"`
+}
+
+// drainState 后端的排空状态，nil表示未排空
+type drainState struct {
+	startedAt          time.Time
+	drainedAt          time.Time
+	initialConnections int
+}
+
+// DrainBackend 将后端标记为排空：不再分配新会话（会话保持绑定在该后端的客户端重连时会迁移到其他后端），
+// 已建立的WebSocket连接保持不变，直到客户端自行断开。用于滚动重启节点
+func (lb *LoadBalancer) DrainBackend(id string) (DrainStatus, error) {
+	lb.backendsMu.Lock()
+	backend, exists := lb.backends[id]
+	if !exists {
+		lb.backendsMu.Unlock()
+		return DrainStatus{}, fmt.Errorf("后端服务器 %s 不存在", id)
+	}
+	started := backend.drain == nil
+	if started {
+		backend.drain = &drainState{startedAt: time.Now(), initialConnections: backend.Connections}
+	}
+	status := backend.drainStatus()
+	lb.backendsMu.Unlock()
+
+	if started {
+		log.Printf("后端服务器 %s 开始排空，停止分配新连接（现有连接数: %d）", id, status.InitialConnections)
+		lb.RecordEvent(EventBackendDraining, id, "开始排空，停止分配新连接",
+			map[string]interface{}{"connections": status.InitialConnections})
+		lb.checkDrains()
+	}
+	return status, nil
+}
+
+// UndrainBackend 结束排空，后端恢复分配新连接
+func (lb *LoadBalancer) UndrainBackend(id string) (DrainStatus, error) {
+	lb.backendsMu.Lock()
+	backend, exists := lb.backends[id]
+	if !exists {
+		lb.backendsMu.Unlock()
+		return DrainStatus{}, fmt.Errorf("后端服务器 %s 不存在", id)
+	}
+	wasDraining := backend.drain != nil
+	backend.drain = nil
+	status := backend.drainStatus()
+	lb.backendsMu.Unlock()
+
+	if wasDraining {
+		log.Printf("后端服务器 %s 结束排空，恢复分配连接", id)
+		lb.RecordEvent(EventBackendUndrained, id, "结束排空，恢复分配连接", nil)
+	}
+	return status, nil
+}
+
+// BackendDrainStatus 查询后端的排空进度
+func (lb *LoadBalancer) BackendDrainStatus(id string) (DrainStatus, bool) {
+	lb.backendsMu.RLock()
+	defer lb.backendsMu.RUnlock()
+	backend, exists := lb.backends[id]
+	if !exists {
+		return DrainStatus{}, false
+	}
+	return backend.drainStatus(), true
+}
+
+// drainStatus 导出排空进度（调用方持有backendsMu）
+func (b *BackendServer) drainStatus() DrainStatus {
+	status := DrainStatus{
+		BackendID:       b.ID,
+		Connections:     b.Connections,
+		ReportedClients: b.ReportedClients,
+	}
+	if b.drain == nil {
+		return status
+	}
+	status.Draining = true
+	startedAt := b.drain.startedAt
+	status.StartedAt = &startedAt
+	if !b.drain.drainedAt.IsZero() {
+		drainedAt := b.drain.drainedAt
+		status.DrainedAt = &drainedAt
+	}
+	status.InitialConnections = b.drain.initialConnections
+	status.Drained = b.Connections == 0
+	status.Progress = 1
+	if b.drain.initialConnections > 0 && b.Connections > 0 {
+		remaining := b.Connections
+		if remaining > b.drain.initialConnections {
+			remaining = b.drain.initialConnections
+		}
+		status.Progress = 1 - float64(remaining)/float64(b.drain.initialConnections)
+	}
+	elapsed := time.Since(b.drain.startedAt)
+	if !b.drain.drainedAt.IsZero() {
+		elapsed = b.drain.drainedAt.Sub(b.drain.startedAt)
+	}
+	status.Elapsed = elapsed.Round(time.Second).String()
+	return status
+}
+
+// checkDrains 记录排空完成的后端，由维护调度每秒调用
+func (lb *LoadBalancer) checkDrains() {
+	var drained []DrainStatus
+	lb.backendsMu.Lock()
+	for _, backend := range lb.backends {
+		if backend.drain == nil {
+			continue
+		}
+		if backend.Connections > 0 {
+			// 排空开始时仍在握手的连接稍后才计入，重新等待
+			backend.drain.drainedAt = time.Time{}
+			continue
+		}
+		if backend.drain.drainedAt.IsZero() {
+			backend.drain.drainedAt = time.Now()
+			drained = append(drained, backend.drainStatus())
+		}
+	}
+	lb.backendsMu.Unlock()
+
+	for _, status := range drained {
+		log.Printf("后端服务器 %s 排空完成，用时 %s", status.BackendID, status.Elapsed)
+		lb.RecordEvent(EventBackendDrained, status.BackendID, "排空完成，可以安全重启",
+			map[string]interface{}{"initial_connections": status.InitialConnections, "elapsed": status.Elapsed})
+	}
+}
+
+// handleBackendDrain 后端排空API: /api/backends/{id}/drain
+// GET    查询排空进度
+// POST   开始排空
+// DELETE 结束排空
+func (lb *LoadBalancer) handleBackendDrain(w http.ResponseWriter, r *http.Request, id string) {
+	var (
+		status DrainStatus
+		err    error
+	)
+	switch r.Method {
+	case "GET":
+		var exists bool
+		if status, exists = lb.BackendDrainStatus(id); !exists {
+			err = fmt.Errorf("后端服务器 %s 不存在", id)
+		}
+	case "POST":
+		status, err = lb.DrainBackend(id)
+	case "DELETE":
+		status, err = lb.UndrainBackend(id)
+	default:
+		http.Error(w, "仅支持GET、POST和DELETE请求", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,292 @@
+package lb
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+)
+
+// emergencyRetryAfter 紧急停止期间拒绝连接时建议客户端等待的秒数
+const emergencyRetryAfter = 30
+
+// emergencyNodeTimeout 通知单个节点紧急停止或解除的超时
+const emergencyNodeTimeout = 10 * time.Second
+
+// emergencyStop 负载均衡器的紧急停止状态
+type emergencyStop struct {
+	active        atomic.Bool
+	rejected      atomic.Int64
+	mu            sync.Mutex // 串行化停止和解除，同时保护status
+	status        protocol.EmergencyStop
+	confirmations protocol.EmergencyConfirmations
+}
+
+// NodeEmergencyStop 节点的紧急停止状态，来自节点的 /api/emergency-stop
+type NodeEmergencyStop struct {
+	protocol.EmergencyStop
+	Error string `json:"error,omitempty"` // 通知或查询节点失败时的原因
+}
+
+// FleetEmergencyStop 集群的紧急停止状态：顶层字段为负载均衡器自身（closed 为其关闭的代理连接数），
+// nodes 按后端ID列出各节点的状态
+type FleetEmergencyStop struct {
+	protocol.EmergencyStop
+	Nodes map[string]*NodeEmergencyStop `json:"nodes"`
+}
+
+// rejectEmergency 紧急停止期间拒绝新的WebSocket连接
+func (lb *LoadBalancer) rejectEmergency(w http.ResponseWriter) {
+	lb.emergency.rejected.Add(1)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(emergencyRetryAfter))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":        "error",
+		"code":        "emergency_stop",
+		"message":     "集群处于紧急停止状态，暂停接受连接",
+		"retry_after": emergencyRetryAfter,
+	})
+}
+
+// EmergencyStop 集群紧急停止：负载均衡器立即拒绝新的WebSocket连接并以指定关闭码关闭所有代理连接，
+// 然后通知所有后端节点关闭各自的客户端连接（包括直连节点的客户端）并暂停接受连接，直到 LiftEmergencyStop
+func (lb *LoadBalancer) EmergencyStop(closeCode int, reason string) *FleetEmergencyStop {
+	e := lb.emergency
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.active.Store(true)
+	if !e.status.Active {
+		now := time.Now()
+		e.status = protocol.EmergencyStop{Active: true, Since: &now, LiftedAt: e.status.LiftedAt}
+		e.rejected.Store(0)
+	}
+	e.status.CloseCode = closeCode
+	e.status.Reason = reason
+
+	// 登记代理连接时会检查紧急停止状态，持锁关闭保证不会遗漏正在建立的连接
+	closeMsg := websocket.FormatCloseMessage(closeCode, reason)
+	lb.proxyConnsMu.Lock()
+	closed := len(lb.proxyConns)
+	for conn := range lb.proxyConns {
+		conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		conn.Close()
+	}
+	lb.proxyConnsMu.Unlock()
+	e.status.Closed += closed
+	log.Printf("🛑 紧急停止：以关闭码 %d 关闭 %d 个代理连接，暂停接受新连接 (%s)", closeCode, closed, reason)
+
+	fleet := &FleetEmergencyStop{Nodes: lb.forEachBackendEmergency(http.MethodPost,
+		&protocol.EmergencyStopRequest{CloseCode: closeCode, Reason: reason})}
+	fleet.EmergencyStop = e.statusUnsafe()
+
+	nodesClosed, failed := 0, 0
+	for id, node := range fleet.Nodes {
+		if node.Error != "" {
+			failed++
+			log.Printf("通知节点 %s 紧急停止失败: %v", id, node.Error)
+			continue
+		}
+		nodesClosed += node.Closed
+	}
+	lb.RecordEvent(EventEmergencyStop, "", "紧急停止: "+reason, map[string]interface{}{
+		"close_code":   closeCode,
+		"proxy_closed": closed,
+		"node_closed":  nodesClosed,
+		"nodes":        len(fleet.Nodes),
+		"nodes_failed": failed,
+	})
+	return fleet
+}
+
+// LiftEmergencyStop 解除紧急停止，负载均衡器和所有后端节点恢复接受连接
+func (lb *LoadBalancer) LiftEmergencyStop() *FleetEmergencyStop {
+	e := lb.emergency
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	wasActive := e.status.Active
+	if wasActive {
+		now := time.Now()
+		e.status.Active = false
+		e.status.LiftedAt = &now
+		e.status.Rejected = e.rejected.Load()
+	}
+	e.active.Store(false)
+
+	fleet := &FleetEmergencyStop{Nodes: lb.forEachBackendEmergency(http.MethodDelete, nil)}
+	fleet.EmergencyStop = e.statusUnsafe()
+	for id, node := range fleet.Nodes {
+		if node.Error != "" {
+			log.Printf("通知节点 %s 解除紧急停止失败: %v", id, node.Error)
+		}
+	}
+	if wasActive {
+		log.Printf("✅ 解除紧急停止，恢复接受连接（期间拒绝 %d 个连接）", fleet.Rejected)
+		lb.RecordEvent(EventEmergencyLifted, "", "解除紧急停止",
+			map[string]interface{}{"rejected": fleet.Rejected})
+	}
+	return fleet
+}
+
+// EmergencyStatus 查询负载均衡器和所有后端节点的紧急停止状态
+func (lb *LoadBalancer) EmergencyStatus() *FleetEmergencyStop {
+	fleet := &FleetEmergencyStop{Nodes: lb.forEachBackendEmergency(http.MethodGet, nil)}
+	lb.emergency.mu.Lock()
+	fleet.EmergencyStop = lb.emergency.statusUnsafe()
+	lb.emergency.mu.Unlock()
+	return fleet
+}
+
+func (e *emergencyStop) statusUnsafe() protocol.EmergencyStop {
+	status := e.status
+	if status.Active {
+		status.Rejected = e.rejected.Load()
+	}
+	return status
+}
+
+// forEachBackendEmergency 并发地对所有后端（包括不健康的）调用节点的紧急停止接口
+func (lb *LoadBalancer) forEachBackendEmergency(method string, req *protocol.EmergencyStopRequest) map[string]*NodeEmergencyStop {
+	lb.backendsMu.RLock()
+	backends := make([]*BackendServer, 0, len(lb.backends))
+	for _, backend := range lb.backends {
+		backends = append(backends, backend)
+	}
+	lb.backendsMu.RUnlock()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	nodes := make(map[string]*NodeEmergencyStop, len(backends))
+	for _, backend := range backends {
+		wg.Add(1)
+		go func(backend *BackendServer) {
+			defer wg.Done()
+			node := &NodeEmergencyStop{}
+			status, err := lb.nodeEmergency(backend, method, req)
+			if err != nil {
+				node.Error = err.Error()
+			} else {
+				node.EmergencyStop = *status
+			}
+			mu.Lock()
+			nodes[backend.ID] = node
+			mu.Unlock()
+		}(backend)
+	}
+	wg.Wait()
+	return nodes
+}
+
+// nodeEmergency 调用节点的 /api/emergency-stop，POST时代为完成节点的二次确认
+func (lb *LoadBalancer) nodeEmergency(backend *BackendServer, method string, req *protocol.EmergencyStopRequest) (*protocol.EmergencyStop, error) {
+	client := &http.Client{Timeout: emergencyNodeTimeout}
+	nodeURL := backend.HTTPAddress + "/api/emergency-stop"
+	var body interface{}
+	if method == http.MethodPost {
+		var confirmation protocol.EmergencyStopConfirmation
+		if err := lb.nodeEmergencyCall(backend, client, method, nodeURL, req, http.StatusAccepted, &confirmation); err != nil {
+			return nil, err
+		}
+		body = protocol.EmergencyStopRequest{ConfirmToken: confirmation.ConfirmToken}
+	}
+	var result struct {
+		EmergencyStop protocol.EmergencyStop `json:"emergency_stop"`
+	}
+	if err := lb.nodeEmergencyCall(backend, client, method, nodeURL, body, http.StatusOK, &result); err != nil {
+		return nil, err
+	}
+	return &result.EmergencyStop, nil
+}
+
+func (lb *LoadBalancer) nodeEmergencyCall(backend *BackendServer, client *http.Client, method, nodeURL string, body interface{}, wantStatus int, out interface{}) error {
+	resp, err := backend.endpoint.send(client, method, nodeURL, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != wantStatus {
+		return fmt.Errorf("节点返回 HTTP %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// handleEmergencyStop 集群紧急停止:
+// GET 查询状态；POST 两步执行，先返回确认令牌，带令牌再次提交后生效；DELETE 解除
+func (lb *LoadBalancer) handleEmergencyStop(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(lb.EmergencyStatus())
+
+	case "POST":
+		var req protocol.EmergencyStopRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "请求格式错误", http.StatusBadRequest)
+			return
+		}
+		if req.ConfirmToken == "" {
+			if err := req.Normalize(); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+				return
+			}
+			token, expiresAt := lb.emergency.confirmations.Issue(req)
+			connections, nodes := lb.emergencyImpact()
+			log.Printf("收到紧急停止请求，等待确认 (关闭码 %d: %s)", req.CloseCode, req.Reason)
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(protocol.EmergencyStopConfirmation{
+				ConfirmationRequired: true,
+				ConfirmToken:         token,
+				ExpiresAt:            expiresAt,
+				CloseCode:            req.CloseCode,
+				Reason:               req.Reason,
+				Connections:          connections,
+				Message: fmt.Sprintf("将关闭 %d 个节点上的约 %d 个连接，集群暂停接受新连接直到解除，请在 %v 内带 confirm_token 再次提交确认",
+					nodes, connections, protocol.EmergencyConfirmTTL),
+			})
+			return
+		}
+		confirmed, ok := lb.emergency.confirmations.Redeem(req.ConfirmToken)
+		if !ok {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"code":    "invalid_confirmation",
+				"error":   "确认令牌无效或已过期，请重新发起紧急停止",
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(lb.EmergencyStop(confirmed.CloseCode, confirmed.Reason))
+
+	case "DELETE":
+		json.NewEncoder(w).Encode(lb.LiftEmergencyStop())
+
+	default:
+		http.Error(w, "仅支持GET、POST和DELETE请求", http.StatusMethodNotAllowed)
+	}
+}
+
+// emergencyImpact 紧急停止将关闭的连接数（按节点最近一次健康检查上报的连接数估算）和节点数
+func (lb *LoadBalancer) emergencyImpact() (connections, nodes int) {
+	lb.backendsMu.RLock()
+	defer lb.backendsMu.RUnlock()
+	for _, backend := range lb.backends {
+		connections += backend.ReportedClients
+	}
+	lb.proxyConnsMu.Lock()
+	if proxied := len(lb.proxyConns); proxied > connections {
+		connections = proxied
+	}
+	lb.proxyConnsMu.Unlock()
+	return connections, len(lb.backends)
+}
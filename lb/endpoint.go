@@ -0,0 +1,253 @@
+package lb
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+)
+
+// BackendOptions 后端的Host头、TLS和Unix域套接字设置，与拨号地址相互独立。
+// 用于托管服务、服务网格入口等按主机名路由并使用自有证书的后端：拨号地址可以是IP或内网域名，
+// 转发请求的Host头和TLS的SNI使用后端对外的主机名。与负载均衡器同机部署的节点可以通过Unix域套接字连接
+type BackendOptions struct {
+	HostHeader string            `json:"host_header,omitempty" yaml:"host_header,omitempty"` // 转发HTTP、WebSocket握手和健康检查请求的Host头，为空时使用拨号地址
+	TLS        *BackendTLSConfig `json:"tls,omitempty" yaml:"tls,omitempty"`                 // 以https/wss连接后端
+	Socket     string            `json:"socket,omitempty" yaml:"socket,omitempty"`           // 经Unix域套接字连接（如 unix:///run/ws/node1.sock），设置后不使用host和port拨号
+}
+
+// BackendTLSConfig 连接后端的TLS设置
+type BackendTLSConfig struct {
+	Enabled            bool   `json:"enabled" yaml:"enabled"`
+	ServerName         string `json:"server_name,omitempty" yaml:"server_name,omitempty"`                   // SNI和证书校验使用的主机名，默认取host_header（去掉端口），其次为拨号地址
+	CAFile             string `json:"ca_file,omitempty" yaml:"ca_file,omitempty"`                           // 校验后端证书的CA（PEM），为空时使用系统根证书
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty" yaml:"insecure_skip_verify,omitempty"` // 不校验后端证书，仅用于测试
+}
+
+// tlsEnabled 是否以TLS连接后端
+func (o BackendOptions) tlsEnabled() bool {
+	return o.TLS != nil && o.TLS.Enabled
+}
+
+// Validate 校验Host头和套接字路径，并加载CA文件确认TLS设置可用
+func (o BackendOptions) Validate() error {
+	_, err := o.tlsConfig("")
+	return err
+}
+
+// tlsConfig 校验设置并生成连接后端的TLS配置，未启用TLS时返回nil
+func (o BackendOptions) tlsConfig(dialHost string) (*tls.Config, error) {
+	if strings.ContainsAny(o.HostHeader, " \t\r\n/") {
+		return nil, fmt.Errorf("无效的host_header: %q", o.HostHeader)
+	}
+	if o.Socket != "" && protocol.UnixSocketPath(o.Socket) == "" {
+		return nil, fmt.Errorf("无效的socket: %q", o.Socket)
+	}
+	if !o.tlsEnabled() {
+		return nil, nil
+	}
+	cfg := &tls.Config{
+		ServerName:         o.serverName(dialHost),
+		InsecureSkipVerify: o.TLS.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if o.TLS.CAFile != "" {
+		pem, err := os.ReadFile(o.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取后端CA文件失败: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("后端CA文件 %s 中没有有效的证书", o.TLS.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// serverName SNI使用的主机名
+func (o BackendOptions) serverName(dialHost string) string {
+	if o.TLS.ServerName != "" {
+		return o.TLS.ServerName
+	}
+	if o.HostHeader != "" {
+		if host, _, err := net.SplitHostPort(o.HostHeader); err == nil {
+			return host
+		}
+		return o.HostHeader
+	}
+	return dialHost
+}
+
+// backendEndpoint 连接后端所需的Host头、TLS和套接字设置。后端创建后不再修改（设置变化时整体重建后端），
+// 因此可以在不持有backendsMu时使用
+type backendEndpoint struct {
+	options     BackendOptions
+	tlsConfig   *tls.Config                                                       // nil表示明文连接
+	dialContext func(ctx context.Context, network, addr string) (net.Conn, error) // 非nil时替换默认的TCP拨号
+	transport   http.RoundTripper                                                 // 需要TLS、自定义拨号的后端的HTTP传输，nil时使用默认传输
+}
+
+// newBackendEndpoint 按设置创建连接后端的端点，CA文件无法加载时返回错误。使用前需调用bind
+func newBackendEndpoint(dialHost string, opts BackendOptions) (*backendEndpoint, error) {
+	tlsConfig, err := opts.tlsConfig(dialHost)
+	if err != nil {
+		return nil, err
+	}
+	return &backendEndpoint{options: opts, tlsConfig: tlsConfig}, nil
+}
+
+// bind 按负载均衡器的地址族偏好设置拨号方式，并为需要时创建HTTP传输（由addBackendUnsafe调用）
+func (e *backendEndpoint) bind(family string) {
+	switch {
+	case e.options.Socket != "":
+		e.dialContext = e.dialSocket
+	case family == protocol.AddressFamilyIPv4 || family == protocol.AddressFamilyIPv6:
+		e.dialContext = protocol.DialContextFunc(family)
+	}
+	if e.tlsConfig == nil && e.dialContext == nil {
+		return
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = e.tlsConfig
+	if e.dialContext != nil {
+		transport.DialContext = e.dialContext
+	}
+	e.transport = transport
+}
+
+// socketPath Unix域套接字文件路径，未设置时为空
+func (e *backendEndpoint) socketPath() string {
+	return protocol.UnixSocketPath(e.options.Socket)
+}
+
+// dialSocket 忽略URL中的地址，连接到后端的Unix域套接字
+func (e *backendEndpoint) dialSocket(ctx context.Context, _, _ string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", e.socketPath())
+}
+
+// addresses 后端的HTTP和WebSocket地址。经Unix域套接字连接且未指定端口时，URL中省略端口
+func (e *backendEndpoint) addresses(host string, port int) (string, string) {
+	httpScheme, wsScheme := e.schemes()
+	hostPort := net.JoinHostPort(host, strconv.Itoa(port))
+	if e.options.Socket != "" && port <= 0 {
+		hostPort = protocol.URLHost(host)
+	}
+	return fmt.Sprintf("%s://%s", httpScheme, hostPort), fmt.Sprintf("%s://%s/ws", wsScheme, hostPort)
+}
+
+// schemes 后端HTTP和WebSocket地址使用的协议
+func (e *backendEndpoint) schemes() (string, string) {
+	if e.tlsConfig != nil {
+		return "https", "wss"
+	}
+	return "http", "ws"
+}
+
+// newRequest 创建发往后端的请求，设置了host_header时替换Host头
+func (e *backendEndpoint) newRequest(method, url string) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if e.options.HostHeader != "" {
+		req.Host = e.options.HostHeader
+	}
+	return req, nil
+}
+
+// client 返回使用该后端传输的HTTP客户端，超时等设置取自base
+func (e *backendEndpoint) client(base *http.Client) *http.Client {
+	if e.transport == nil {
+		return base
+	}
+	c := *base
+	c.Transport = e.transport
+	return &c
+}
+
+// get 向后端发送GET请求
+func (e *backendEndpoint) get(base *http.Client, url string) (*http.Response, error) {
+	req, err := e.newRequest(http.MethodGet, url)
+	if err != nil {
+		return nil, err
+	}
+	return e.client(base).Do(req)
+}
+
+// send 向后端发送请求，body非nil时编码为JSON作为请求体
+func (e *backendEndpoint) send(base *http.Client, method, url string, body interface{}) (*http.Response, error) {
+	req, err := e.newRequest(method, url)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(data))
+		req.ContentLength = int64(len(data))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return e.client(base).Do(req)
+}
+
+// dial 连接后端WebSocket，按设置替换Host头、TLS配置和拨号方式。proxySource非nil时
+// 连接建立后先以PROXY协议v2头发送该源地址（在TLS握手之前）
+func (e *backendEndpoint) dial(dialer *websocket.Dialer, url string, header http.Header, proxySource net.Addr) (*websocket.Conn, *http.Response, error) {
+	if e.tlsConfig == nil && e.options.HostHeader == "" && e.dialContext == nil && proxySource == nil {
+		return dialer.Dial(url, header)
+	}
+	d := *dialer
+	if e.tlsConfig != nil {
+		d.TLSClientConfig = e.tlsConfig
+	}
+	if e.dialContext != nil {
+		d.NetDial, d.NetDialContext = nil, e.dialContext
+	}
+	if proxySource != nil {
+		dial := d.NetDialContext
+		if dial == nil {
+			dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+		}
+		d.NetDial, d.NetDialContext = nil, withProxyHeader(dial, proxySource)
+	}
+	if e.options.HostHeader != "" {
+		header = header.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		header.Set("Host", e.options.HostHeader)
+	}
+	return d.Dial(url, header)
+}
+
+// configureProxy 设置HTTP反向代理的传输和Host头
+func (e *backendEndpoint) configureProxy(proxy *httputil.ReverseProxy) {
+	if e.transport != nil {
+		proxy.Transport = e.transport
+	}
+	if hostHeader := e.options.HostHeader; hostHeader != "" {
+		director := proxy.Director
+		proxy.Director = func(r *http.Request) {
+			director(r)
+			r.Host = hostHeader
+		}
+	}
+}
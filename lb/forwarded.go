@@ -0,0 +1,58 @@
+package lb
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+
+	"websocket-loadbalance/forwarded"
+)
+
+// SetForwarded 设置受信的上游代理（需在Start之前调用），传nil表示不信任任何代理。
+// 客户端地址用于ip_hash、访问控制和访问日志，并通过 X-Forwarded-For、X-Real-IP 传给后端
+func (lb *LoadBalancer) SetForwarded(res *forwarded.Resolver) {
+	lb.forwarded = res
+}
+
+// SetBackendProxyProtocol 连接后端WebSocket时先发送PROXY协议v2头（需在Start之前调用），
+// 后端据此在TCP层得到真实的客户端地址。节点需启用 proxy_protocol 并信任负载均衡器的地址
+func (lb *LoadBalancer) SetBackendProxyProtocol(enabled bool) {
+	lb.backendProxyProtocol = enabled
+}
+
+// clientIP 请求的真实客户端地址，只采信受信代理给出的转发信息
+func (lb *LoadBalancer) clientIP(r *http.Request) string {
+	return lb.forwarded.ClientIP(r)
+}
+
+// proxyHeaderSource 发给后端的PROXY协议头中的源地址：真实客户端地址，端口取自TCP对端
+func (lb *LoadBalancer) proxyHeaderSource(r *http.Request) net.Addr {
+	if !lb.backendProxyProtocol {
+		return nil
+	}
+	ip := net.ParseIP(lb.clientIP(r))
+	if ip == nil {
+		return nil
+	}
+	port := 0
+	if _, p, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		port, _ = strconv.Atoi(p)
+	}
+	return &net.TCPAddr{IP: ip, Port: port}
+}
+
+// withProxyHeader 包装拨号函数，连接建立后先写入PROXY协议v2头
+func withProxyHeader(dial func(ctx context.Context, network, addr string) (net.Conn, error), src net.Addr) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if err := forwarded.WriteProxyHeader(conn, src, conn.RemoteAddr()); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
@@ -0,0 +1,401 @@
+package lb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+)
+
+// 健康检查探测方式
+const (
+	HealthProbeHTTP      = "http"      // GET 健康检查路径，返回200视为健康
+	HealthProbeWebSocket = "websocket" // 升级 /ws 并发送ping，收到pong视为健康
+)
+
+var errPongReceived = errors.New("收到pong")
+
+// refusedByAdmission 握手是否被后端的连接准入拒绝（满员或紧急停止），此时后端本身在正常工作
+func refusedByAdmission(resp *http.Response) bool {
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		return false
+	}
+	var body struct {
+		Code string `json:"code"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return body.Code == "server_full" || body.Code == "emergency_stop"
+}
+
+// ProbeResult 一次健康探测的结果
+type ProbeResult struct {
+	Time      time.Time `json:"time"`
+	Success   bool      `json:"success"`
+	LatencyMs float64   `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// healthProbe 一轮健康检查使用的探测设置，在持有backendsMu时取出，探测期间不持有锁
+type healthProbe struct {
+	protocol string
+	path     string
+	timeout  time.Duration
+	client   *http.Client
+}
+
+// backendCapacity 后端 /health 上报的连接数和上限
+type backendCapacity struct {
+	Connections int `json:"connections"`
+	MaxClients  int `json:"max_clients"`
+
+	ProtocolMinVersion int `json:"protocol_min_version"` // 节点接受的客户端协议版本范围，旧版本节点不上报
+	ProtocolMaxVersion int `json:"protocol_max_version"`
+
+	BuildVersion string `json:"build_version"` // 节点的构建版本，旧版本节点不上报
+
+	Labels map[string]string `json:"labels"` // 节点标签，affinity策略据此匹配客户端的亲和性
+}
+
+// protocolVersions 上报的协议版本范围，未上报或无效时返回nil
+func (c *backendCapacity) protocolVersions() *protocol.VersionRange {
+	v := protocol.VersionRange{Min: c.ProtocolMinVersion, Max: c.ProtocolMaxVersion}
+	if v.Validate() != nil {
+		return nil
+	}
+	return &v
+}
+
+// 设置探测历史长度和抖动抑制参数（需在Start之前调用）
+// 最近history次探测中状态切换的比例达到flapThreshold时判定为抖动，抑制holdDown时长内的恢复
+func (lb *LoadBalancer) SetFlapDetection(history int, flapThreshold float64, holdDown time.Duration) {
+	if history > 1 {
+		lb.probeHistorySize = history
+	}
+	if flapThreshold > 0 {
+		lb.flapThreshold = flapThreshold
+	}
+	if holdDown > 0 {
+		lb.holdDown = holdDown
+	}
+}
+
+// 设置健康检查间隔和超时（需在Start之前调用）
+func (lb *LoadBalancer) SetHealthCheck(interval, timeout time.Duration) {
+	if interval > 0 {
+		lb.healthInterval = interval
+	}
+	if timeout > 0 {
+		lb.healthTimeout = timeout
+		lb.healthClient = &http.Client{Timeout: timeout}
+	}
+}
+
+// 设置状态翻转阈值：连续失败unhealthy次才标记为不健康，连续成功healthy次才恢复（需在Start之前调用）
+func (lb *LoadBalancer) SetHealthThresholds(healthy, unhealthy int) {
+	if healthy > 0 {
+		lb.healthyThreshold = healthy
+	}
+	if unhealthy > 0 {
+		lb.unhealthyThreshold = unhealthy
+	}
+}
+
+// 设置探测方式和HTTP探测路径（需在Start之前调用）
+func (lb *LoadBalancer) SetHealthProbe(protocol, path string) {
+	if protocol != "" {
+		lb.healthProtocol = protocol
+	}
+	if path != "" {
+		lb.healthPath = path
+	}
+}
+
+// 健康检查，重新加载配置修改间隔后立即按新间隔计时
+func (lb *LoadBalancer) healthCheck() {
+	ticker := time.NewTicker(lb.currentHealthInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			lb.runHealthChecks()
+		case <-lb.healthReset:
+			ticker.Reset(lb.currentHealthInterval())
+		}
+	}
+}
+
+func (lb *LoadBalancer) currentHealthInterval() time.Duration {
+	lb.backendsMu.RLock()
+	defer lb.backendsMu.RUnlock()
+	return lb.healthInterval
+}
+
+// 并发探测所有后端，探测期间不持有后端锁，避免阻塞请求转发。
+// 只读观察者在镜像新鲜时使用主负载均衡器的探测结果，镜像过期后才自行探测
+func (lb *LoadBalancer) runHealthChecks() {
+	if lb.observer.mirroring() {
+		return
+	}
+	type target struct {
+		id, httpAddr, wsAddr string
+		endpoint             *backendEndpoint
+	}
+	lb.backendsMu.RLock()
+	targets := make([]target, 0, len(lb.backends))
+	for id, backend := range lb.backends {
+		targets = append(targets, target{id, backend.HTTPAddress, backend.WSAddress, backend.endpoint})
+	}
+	probe := healthProbe{protocol: lb.healthProtocol, path: lb.healthPath, timeout: lb.healthTimeout, client: lb.healthClient}
+	lb.backendsMu.RUnlock()
+	// 设置了健康检查的后端池中的后端按池的方式探测
+	poolProbes := lb.poolHealthProbes(probe)
+
+	results := make([]ProbeResult, len(targets))
+	capacities := make([]*backendCapacity, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t target) {
+			defer wg.Done()
+			p, ok := poolProbes[t.id]
+			if !ok {
+				p = probe
+			}
+			start := time.Now()
+			capacity, err := lb.probe(p, t.endpoint, t.httpAddr, t.wsAddr)
+			capacities[i] = capacity
+			results[i] = ProbeResult{
+				Time:      start,
+				Success:   err == nil,
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}(i, t)
+	}
+	wg.Wait()
+
+	lb.backendsMu.Lock()
+	defer lb.backendsMu.Unlock()
+	for i, t := range targets {
+		if backend, exists := lb.backends[t.id]; exists {
+			if capacity := capacities[i]; capacity != nil {
+				backend.ReportedClients = capacity.Connections
+				backend.MaxClients = capacity.MaxClients
+				backend.ProtocolVersions = capacity.protocolVersions()
+				backend.BuildVersion = capacity.BuildVersion
+				backend.Labels = capacity.Labels
+			}
+			lb.applyProbeResult(backend, results[i])
+		}
+	}
+	lb.checkBuildVersionsUnsafe()
+}
+
+// 记录一次探测结果，连续次数达到阈值时翻转健康状态（调用方持有backendsMu）
+func (lb *LoadBalancer) applyProbeResult(backend *BackendServer, result ProbeResult) {
+	backend.LastCheck = result.Time
+	backend.probeHistory = append(backend.probeHistory, result)
+	if len(backend.probeHistory) > lb.probeHistorySize {
+		backend.probeHistory = backend.probeHistory[len(backend.probeHistory)-lb.probeHistorySize:]
+	}
+	lb.checkFlapping(backend)
+
+	if !result.Success {
+		backend.LastError = result.Error
+		backend.ConsecutiveFailures++
+		backend.ConsecutiveSuccesses = 0
+		if backend.IsHealthy && backend.ConsecutiveFailures >= lb.unhealthyThreshold {
+			backend.IsHealthy = false
+			log.Printf("后端服务器 %s (%s) 连续 %d 次探测失败，变为不健康: %s",
+				backend.ID, backend.HTTPAddress, backend.ConsecutiveFailures, result.Error)
+			lb.RecordEvent(EventBackendDown, backend.ID, "后端变为不健康: "+result.Error,
+				map[string]interface{}{"connections": backend.Connections, "failures": backend.ConsecutiveFailures})
+		}
+		return
+	}
+
+	backend.LastError = ""
+	backend.ConsecutiveSuccesses++
+	backend.ConsecutiveFailures = 0
+	if !backend.IsHealthy && time.Now().Before(backend.HoldDownUntil) {
+		// 抖动抑制期内不恢复，避免路由反复切换
+		return
+	}
+	if !backend.IsHealthy && backend.ConsecutiveSuccesses >= lb.healthyThreshold {
+		backend.IsHealthy = true
+		log.Printf("后端服务器 %s (%s) 连续 %d 次探测成功，恢复健康",
+			backend.ID, backend.HTTPAddress, backend.ConsecutiveSuccesses)
+		lb.RecordEvent(EventBackendUp, backend.ID, "后端恢复健康",
+			map[string]interface{}{"successes": backend.ConsecutiveSuccesses})
+	}
+}
+
+// flapScore 探测历史中相邻两次结果不同的比例，0表示稳定，1表示每次都在切换
+func flapScore(history []ProbeResult) float64 {
+	if len(history) < 2 {
+		return 0
+	}
+	changes := 0
+	for i := 1; i < len(history); i++ {
+		if history[i].Success != history[i-1].Success {
+			changes++
+		}
+	}
+	return float64(changes) / float64(len(history)-1)
+}
+
+// checkFlapping 抖动分数达到阈值时进入抑制期：后端保持不健康，直到抑制期结束且重新满足恢复条件
+// 抑制期内仍在抖动会延长抑制期（调用方持有backendsMu）
+func (lb *LoadBalancer) checkFlapping(backend *BackendServer) {
+	// 样本太少时不判定，避免刚启动时一次失败就被抑制
+	if len(backend.probeHistory) < lb.probeHistorySize/2 {
+		return
+	}
+	score := flapScore(backend.probeHistory)
+	if score < lb.flapThreshold {
+		return
+	}
+
+	now := time.Now()
+	inHoldDown := now.Before(backend.HoldDownUntil)
+	backend.HoldDownUntil = now.Add(lb.holdDown)
+	if inHoldDown {
+		return
+	}
+
+	log.Printf("⚠️ 后端服务器 %s 健康状态抖动 (分数 %.2f)，%v 内不再分配新连接", backend.ID, score, lb.holdDown)
+	lb.RecordEvent(EventBackendFlapping, backend.ID,
+		fmt.Sprintf("健康状态抖动 (分数 %.2f)，抑制 %v", score, lb.holdDown),
+		map[string]interface{}{"flap_score": score, "hold_down_until": backend.HoldDownUntil})
+	if backend.IsHealthy {
+		backend.IsHealthy = false
+		lb.RecordEvent(EventBackendDown, backend.ID, "后端因健康状态抖动被暂时移出",
+			map[string]interface{}{"connections": backend.Connections})
+	}
+}
+
+// 按配置的方式探测一个后端，HTTP探测同时返回后端上报的连接容量（无法解析时为nil）
+func (lb *LoadBalancer) probe(p healthProbe, endpoint *backendEndpoint, httpAddr, wsAddr string) (*backendCapacity, error) {
+	if p.protocol == HealthProbeWebSocket {
+		return nil, lb.probeWebSocket(p, endpoint, wsAddr)
+	}
+	return lb.probeHTTP(p, endpoint, httpAddr)
+}
+
+// HTTP探测：GET 健康检查路径，返回200视为健康；响应中的 connections/max_clients 用于按容量路由，
+// protocol_min_version/protocol_max_version 用于按客户端协议版本路由，build_version 用于发现构建版本分歧，
+// labels 用于按客户端的节点亲和性路由
+func (lb *LoadBalancer) probeHTTP(p healthProbe, endpoint *backendEndpoint, httpAddr string) (*backendCapacity, error) {
+	resp, err := endpoint.get(p.client, httpAddr+p.path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("健康检查返回 %d", resp.StatusCode)
+	}
+	var capacity backendCapacity
+	if err := json.NewDecoder(resp.Body).Decode(&capacity); err != nil {
+		return nil, nil
+	}
+	return &capacity, nil
+}
+
+// WebSocket探测：完成握手后发送ping并等待pong，能发现HTTP正常但WebSocket处理异常的后端
+// 探测连接带 health_probe 参数，后端不会将其注册为客户端。探测连接占用后端的连接名额，
+// 后端满员或紧急停止时以503拒绝握手，与HTTP探测一致视为健康，由满载和紧急停止的处理避开该后端
+func (lb *LoadBalancer) probeWebSocket(p healthProbe, endpoint *backendEndpoint, wsAddr string) error {
+	deadline := time.Now().Add(p.timeout)
+	dialer := &websocket.Dialer{HandshakeTimeout: p.timeout}
+	conn, resp, err := endpoint.dial(dialer, wsAddr+"?health_probe=1", nil, nil)
+	if err != nil {
+		if refusedByAdmission(resp) {
+			return nil
+		}
+		return fmt.Errorf("WebSocket握手失败: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetPongHandler(func(string) error {
+		return errPongReceived
+	})
+	if err := conn.WriteControl(websocket.PingMessage, []byte("health"), deadline); err != nil {
+		return fmt.Errorf("发送ping失败: %v", err)
+	}
+	conn.SetReadDeadline(deadline)
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if err == errPongReceived {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+				return nil
+			}
+			return fmt.Errorf("等待pong失败: %v", err)
+		}
+	}
+}
+
+// handleBackendDetail 单个后端的健康详情和探测历史: GET /api/backends/{id}
+func (lb *LoadBalancer) handleBackendDetail(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/backends/")
+	if id == "" {
+		lb.handleBackends(w, r)
+		return
+	}
+	if backendID, ok := strings.CutSuffix(id, "/drain"); ok {
+		lb.handleBackendDrain(w, r, backendID)
+		return
+	}
+	if r.Method == "PUT" || r.Method == "DELETE" {
+		lb.handleBackendUpdate(w, r, id)
+		return
+	}
+
+	lb.backendsMu.RLock()
+	backend, exists := lb.backends[id]
+	if !exists {
+		lb.backendsMu.RUnlock()
+		http.Error(w, "后端服务器不存在", http.StatusNotFound)
+		return
+	}
+	history := append([]ProbeResult(nil), backend.probeHistory...)
+	response := map[string]interface{}{
+		"id":                    backend.ID,
+		"address":               backend.WSAddress,
+		"http_address":          backend.HTTPAddress,
+		"host_header":           backend.endpoint.options.HostHeader,
+		"tls":                   backend.endpoint.options.TLS,
+		"socket":                backend.endpoint.options.Socket,
+		"connections":           backend.Connections,
+		"is_healthy":            backend.IsHealthy,
+		"in_maintenance":        backend.InMaintenance,
+		"drain":                 backend.drainStatus(),
+		"last_check":            backend.LastCheck,
+		"last_error":            backend.LastError,
+		"consecutive_successes": backend.ConsecutiveSuccesses,
+		"consecutive_failures":  backend.ConsecutiveFailures,
+		"flap_score":            flapScore(history),
+		"flap_threshold":        lb.flapThreshold,
+		"hold_down":             time.Now().Before(backend.HoldDownUntil),
+		"labels":                backend.Labels,
+		"history":               history,
+	}
+	if !backend.HoldDownUntil.IsZero() {
+		response["hold_down_until"] = backend.HoldDownUntil
+	}
+	lb.backendsMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
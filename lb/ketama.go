@@ -0,0 +1,83 @@
+package lb
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 权重为1的后端在哈希环上的虚拟节点数（每个md5摘要产生4个点），与libketama的默认值一致
+const ketamaPointsPerWeight = 160
+
+// ketamaRing ketama一致性哈希环，按后端ID和权重构建，构建后只读
+type ketamaRing struct {
+	key    string   // 后端ID和权重的签名，候选后端变化时重新构建
+	points []uint32 // 升序
+	owners []int    // points[i] 所属后端在候选列表中的下标
+}
+
+// ketamaKey 已按ID排序的候选后端的签名
+func ketamaKey(candidates []*BackendServer) string {
+	var b strings.Builder
+	for _, backend := range candidates {
+		b.WriteString(backend.ID)
+		b.WriteByte('*')
+		b.WriteString(strconv.Itoa(backend.weight()))
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// newKetamaRing 为已按ID排序的候选后端构建哈希环：每个后端放置 160×权重 个点，
+// 点的位置只取决于后端ID，增删一个后端只影响落在它的点上的客户端
+func newKetamaRing(key string, candidates []*BackendServer) *ketamaRing {
+	type point struct {
+		hash  uint32
+		owner int
+	}
+	var points []point
+	for i, backend := range candidates {
+		for n := 0; n < ketamaPointsPerWeight*backend.weight()/4; n++ {
+			digest := md5.Sum([]byte(backend.ID + "-" + strconv.Itoa(n)))
+			for h := 0; h < 4; h++ {
+				points = append(points, point{binary.LittleEndian.Uint32(digest[h*4:]), i})
+			}
+		}
+	}
+	// 哈希相同的点按后端ID排序，保证环与候选顺序无关
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return candidates[points[i].owner].ID < candidates[points[j].owner].ID
+	})
+	ring := &ketamaRing{key: key, points: make([]uint32, len(points)), owners: make([]int, len(points))}
+	for i, p := range points {
+		ring.points[i], ring.owners[i] = p.hash, p.owner
+	}
+	return ring
+}
+
+// pick 顺时针找到客户端哈希之后的第一个点
+func (r *ketamaRing) pick(clientID string, candidates []*BackendServer) *BackendServer {
+	digest := md5.Sum([]byte(clientID))
+	hash := binary.LittleEndian.Uint32(digest[:4])
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return candidates[r.owners[i]]
+}
+
+// ketamaPick 按ketama一致性哈希选择后端，候选后端（健康状态、权重）不变时复用上次构建的哈希环
+func (p *backendPool) ketamaPick(clientID string, candidates []*BackendServer) *BackendServer {
+	key := ketamaKey(candidates)
+	ring := p.ring.Load()
+	if ring == nil || ring.key != key {
+		ring = newKetamaRing(key, candidates)
+		p.ring.Store(ring)
+	}
+	return ring.pick(clientID, candidates)
+}
@@ -0,0 +1,1106 @@
+// Package lb 实现WebSocket负载均衡器：后端选择、健康检查、会话保持和连接代理
+package lb
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/acme"
+	"websocket-loadbalance/auth"
+	"websocket-loadbalance/forwarded"
+	"websocket-loadbalance/logging"
+	"websocket-loadbalance/origin"
+	"websocket-loadbalance/perf"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+	"websocket-loadbalance/tracing"
+)
+
+// 负载均衡策略
+type Strategy string
+
+const (
+	RoundRobin     Strategy = "round_robin"
+	LeastConn      Strategy = "least_conn"
+	IPHash         Strategy = "ip_hash"
+	ConsistentHash Strategy = "consistent_hash" // 最高随机权重哈希，后端增减时迁移的客户端最少
+	Ketama         Strategy = "ketama"          // ketama一致性哈希环，按权重放置虚拟节点，后端增减时迁移的客户端最少
+	Affinity       Strategy = "affinity"        // 优先选择标签满足客户端声明的节点亲和性的后端，其中负载最低者
+)
+
+// 后端服务器信息。ID、地址、Proxy和endpoint创建后不再修改；连接数、健康和维护状态等其余字段
+// 由健康检查、代理连接和管理API并发修改，只能在持有LoadBalancer.backendsMu时读写
+type BackendServer struct {
+	ID                   string
+	HTTPAddress          string      // http://localhost:8081 (HTTP服务地址)
+	WSAddress            string      // ws://localhost:8081/ws (WebSocket地址)
+	Connections          int         // 当前连接数
+	IsHealthy            bool        // 健康状态
+	InMaintenance        bool        // 处于维护窗口中，不分配新连接
+	drain                *drainState // 非nil时处于排空状态，不分配新连接
+	LastCheck            time.Time
+	LastError            string                 // 最近一次探测失败的原因
+	ConsecutiveSuccesses int                    // 连续探测成功次数
+	ConsecutiveFailures  int                    // 连续探测失败次数
+	HoldDownUntil        time.Time              // 健康状态抖动的抑制期结束时间
+	probeHistory         []ProbeResult          // 最近的探测结果
+	ReportedClients      int                    // 后端 /health 上报的当前连接数
+	MaxClients           int                    // 后端 /health 上报的最大连接数，0表示不限制或未知
+	ProtocolVersions     *protocol.VersionRange // 后端 /health 上报的协议版本范围，nil表示未知
+	BuildVersion         string                 // 后端 /health 上报的构建版本，空表示未上报
+	Labels               map[string]string      // 后端 /health 上报的节点标签，affinity策略据此匹配客户端的亲和性
+	outdated             bool                   // 构建版本低于最低版本，已记入时间线
+	Weight               int                    // 权重，轮询和最少连接策略按权重分配
+	Discovered           bool                   // 由服务发现添加，注册中心移除时随之移除
+	Registered           bool                   // 由节点自注册添加，租约到期时移除
+	LeaseExpires         time.Time              // 自注册的租约到期时间，节点每次登记时延长
+	Proxy                *httputil.ReverseProxy // HTTP代理
+	endpoint             *backendEndpoint       // Host头和TLS设置
+	removed              atomic.Bool            // 已从负载均衡器移除，经它转发的代理连接随之关闭
+}
+
+// 会话信息 - 用于会话保持
+type Session struct {
+	SessionID  string    `json:"session_id"`  // 会话ID
+	BackendID  string    `json:"backend_id"`  // 绑定的后端服务器ID
+	ClientIP   string    `json:"client_ip"`   // 客户端IP
+	CreateTime time.Time `json:"create_time"` // 创建时间
+	LastSeen   time.Time `json:"last_seen"`   // 最后访问时间
+}
+
+// 纯七层负载均衡器 - 仅做转发和健康检查
+type LoadBalancer struct {
+	port                   int
+	defaultPool            *backendPool              // 未匹配路由规则时使用的后端池（全部后端、全局策略）
+	pools                  []*backendPool            // 按路径前缀路由的后端池，最长前缀在前
+	poolsMu                sync.RWMutex              // 保护pools，后端池可以通过管理API在运行时修改
+	acl                    aclHolder                 // 客户端访问控制
+	shadow                 shadowHolder              // 影子流量，将抽中连接的消息复制到影子后端
+	versions               *versionCounter           // 按客户端协议版本统计的转发连接
+	statsSamples           statsSamples              // 集群统计的上次查询样本，用于计算吞吐量
+	nonStickyTypes         map[string]bool           // 不启用会话保持的客户端类型
+	peekRegistration       bool                      // 读取注册消息中的client_id来保持会话
+	backends               map[string]*BackendServer // 后端服务器
+	backendsMu             sync.RWMutex
+	minBackendVersion      string              // 后端的最低构建版本，为空表示不检查（由backendsMu保护）
+	skewedVersions         []string            // 上次发现分歧时健康后端的构建版本，nil表示没有分歧（由backendsMu保护）
+	sessions               map[string]*Session // 会话保持
+	sessionsMu             sync.RWMutex
+	sessionTTL             time.Duration // 会话空闲过期时间
+	sessionCleanupInterval time.Duration // 过期清理和持久化间隔
+	sessionStore           SessionStore  // 会话持久化存储，nil表示不持久化
+	upgrader               websocket.Upgrader
+	dialer                 *websocket.Dialer             // 连接后端WebSocket
+	compressionLevel       int                           // 协商permessage-deflate后使用的压缩级别
+	buffers                *bufferPools                  // 转发消息使用的缓冲区池
+	proxyRetries           int                           // 连接后端失败时切换其他后端的最大重试次数
+	maxMessageSize         int64                         // 代理连接两侧单条消息的最大字节数，0表示不限制
+	maintenance            map[string]*MaintenanceWindow // 维护窗口
+	maintenanceMu          sync.RWMutex
+	healthInterval         time.Duration // 健康检查间隔
+	healthTimeout          time.Duration // 单次探测超时
+	healthClient           *http.Client  // 健康检查使用的HTTP客户端（带超时）
+	healthyThreshold       int           // 连续成功多少次后标记为健康
+	unhealthyThreshold     int           // 连续失败多少次后标记为不健康
+	healthProtocol         string        // 探测方式: http 或 websocket
+	healthPath             string        // HTTP探测路径
+	probeHistorySize       int           // 每个后端保留的探测结果数
+	flapThreshold          float64       // 抖动分数阈值
+	holdDown               time.Duration // 抖动后的抑制时长
+	healthReset            chan struct{} // 重新加载配置修改健康检查间隔后通知检查循环
+	httpServer             *http.Server
+	mux                    *http.ServeMux                     // 本负载均衡器的路由，同一进程中的多个实例互不干扰
+	listenAddress          string                             // 监听的主机地址，为空表示所有地址
+	addressFamily          string                             // 监听绑定的地址族，也是连接后端时优先的地址族
+	unixSocket             string                             // 同时监听的Unix域套接字，为空表示只监听TCP端口
+	draining               atomic.Bool                        // 关闭中，不再接受新连接
+	proxyConns             map[*websocket.Conn]*BackendServer // 正在代理的客户端连接及其后端（连接后端之前为nil）
+	proxyConnsMu           sync.Mutex
+	sessionConns           map[string]map[*websocket.Conn]struct{} // 会话标识 -> 按该会话保持转发中的代理连接（受proxyConnsMu保护）
+	proxyWG                sync.WaitGroup
+	emergency              *emergencyStop         // 紧急停止，生效时拒绝所有新的WebSocket连接
+	observer               *observerState         // 非nil时为只读观察者，从主负载均衡器镜像状态
+	auth                   auth.Provider          // 非nil时在转发前认证WebSocket握手
+	forwarded              *forwarded.Resolver    // 受信的上游代理，nil表示客户端地址即TCP对端地址
+	backendProxyProtocol   bool                   // 连接后端WebSocket时先发送PROXY协议v2头
+	origins                *origin.Policy         // 非nil时检查浏览器请求的来源并添加CORS响应头
+	timeline               *timeline              // 集群事件时间线
+	status                 *statusState           // 集群健康状态：连接错误率窗口和上次计算的状态
+	discovery              Discovery              // 非nil时从注册中心动态发现后端
+	stopDiscovery          context.CancelFunc     // 停止服务发现
+	selfRegistration       *selfRegistration      // 非nil时允许节点通过 POST /api/backends 自注册
+	accessLog              *accessLogger          // 非nil时记录每个转发的请求和WebSocket会话
+	autocert               *certManager           // 非nil时端口提供HTTPS，证书通过ACME自动申请
+	tlsCerts               *certReloader          // 非nil时端口提供HTTPS，证书来自文件并自动重新加载
+	startupConfig          *Config                // 启动时的配置，重新加载时用于找出需要重启才能生效的修改
+	configSource           func() (Config, error) // 重新加载时读取配置，nil表示不支持重新加载
+	reloadMu               sync.Mutex
+	ready                  chan struct{} // Start 开始监听后关闭
+	unregisterMetrics      func()        // 注销OTLP指标来源，Start 之后有效
+}
+
+// 创建负载均衡器
+func New(port int, strategy Strategy) *LoadBalancer {
+	lb := &LoadBalancer{
+		port:        port,
+		defaultPool: newBackendPool(DefaultPoolName, "", strategy, nil),
+		backends:    make(map[string]*BackendServer),
+		sessions:    make(map[string]*Session),
+		maintenance: make(map[string]*MaintenanceWindow),
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return true // 来源由 SetOrigins 设置的策略在握手前检查，未设置时允许所有来源
+			},
+			WriteBufferPool: writeBufferPool,
+		},
+		dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: 45 * time.Second,
+			WriteBufferPool:  writeBufferPool,
+		},
+		buffers:                newBufferPools(defaultCopyBufferSize),
+		healthInterval:         10 * time.Second,
+		healthTimeout:          5 * time.Second,
+		healthClient:           &http.Client{Timeout: 5 * time.Second},
+		healthyThreshold:       1,
+		unhealthyThreshold:     1,
+		healthProtocol:         HealthProbeHTTP,
+		healthPath:             "/health",
+		probeHistorySize:       20,
+		flapThreshold:          0.5,
+		holdDown:               time.Minute,
+		healthReset:            make(chan struct{}, 1),
+		proxyRetries:           2,
+		sessionTTL:             24 * time.Hour,
+		sessionCleanupInterval: time.Minute,
+		mux:                    http.NewServeMux(),
+		proxyConns:             make(map[*websocket.Conn]*BackendServer),
+		sessionConns:           make(map[string]map[*websocket.Conn]struct{}),
+		timeline:               newTimeline(TimelineConfig{}),
+		status:                 newStatusState(StatusConfig{}),
+		emergency:              &emergencyStop{},
+		versions:               newVersionCounter(),
+		ready:                  make(chan struct{}),
+	}
+	lb.httpServer = &http.Server{Addr: ":" + strconv.Itoa(port), Handler: lb.mux}
+
+	return lb
+}
+
+// Ready 返回在 Start 开始监听端口后关闭的通道，Start 失败时不会关闭
+func (lb *LoadBalancer) Ready() <-chan struct{} {
+	return lb.ready
+}
+
+// 应用性能参数（需在Start之前调用）
+func (lb *LoadBalancer) SetPerformance(p perf.Settings) {
+	lb.upgrader.ReadBufferSize = p.ReadBufferSize
+	lb.upgrader.WriteBufferSize = p.WriteBufferSize
+	lb.upgrader.EnableCompression = p.Compression
+	lb.dialer.ReadBufferSize = p.ReadBufferSize
+	lb.dialer.WriteBufferSize = p.WriteBufferSize
+	lb.dialer.EnableCompression = p.Compression
+	lb.compressionLevel = p.CompressionLevel
+	lb.buffers = newBufferPools(p.CopyBufferSize)
+}
+
+// 为协商了permessage-deflate的连接设置压缩级别，客户端侧和后端侧各自独立压缩
+func (lb *LoadBalancer) applyCompression(conn *websocket.Conn) {
+	if lb.upgrader.EnableCompression && lb.compressionLevel != 0 {
+		conn.SetCompressionLevel(lb.compressionLevel)
+	}
+}
+
+// 设置WebSocket握手的认证方式（需在Start之前调用），传nil表示不认证
+// 令牌会原样转发给后端，后端可再次校验
+func (lb *LoadBalancer) SetAuth(provider auth.Provider) {
+	lb.auth = provider
+}
+
+// SetOrigins 设置来源白名单和CORS（需在Start之前调用），传nil表示允许所有来源。
+// 来源在负载均衡器检查，转发给后端的HTTP请求去掉Origin请求头，避免后端重复添加CORS响应头
+func (lb *LoadBalancer) SetOrigins(policy *origin.Policy) {
+	lb.origins = policy
+}
+
+// SetListenAddress 设置监听的主机地址和地址族（dual、ipv4、ipv6），地址族同时决定连接后端时优先使用的地址（需在添加后端和Start之前调用）
+func (lb *LoadBalancer) SetListenAddress(host, family string) {
+	lb.listenAddress = host
+	lb.addressFamily = family
+}
+
+// SetUnixSocket 设置同时监听的Unix域套接字，供同机的sidecar等绕过TCP连接（需在Start之前调用）
+func (lb *LoadBalancer) SetUnixSocket(path string) {
+	lb.unixSocket = path
+}
+
+// 设置连接后端失败时的故障转移重试次数（需在Start之前调用）
+func (lb *LoadBalancer) SetProxyRetries(retries int) {
+	if retries >= 0 {
+		lb.proxyRetries = retries
+	}
+}
+
+// SetMaxMessageSize 设置代理连接两侧单条消息的最大字节数（需在Start之前调用），0表示不限制。
+// 任一侧超过上限时，两侧连接都以关闭码1009断开
+func (lb *LoadBalancer) SetMaxMessageSize(size int64) {
+	if size >= 0 {
+		lb.maxMessageSize = size
+	}
+}
+
+// 设置会话过期时间和持久化存储（需在Start之前调用）
+func (lb *LoadBalancer) SetSessionPersistence(ttl, cleanupInterval time.Duration, store SessionStore) {
+	if ttl > 0 {
+		lb.sessionTTL = ttl
+	}
+	if cleanupInterval > 0 {
+		lb.sessionCleanupInterval = cleanupInterval
+	}
+	lb.sessionStore = store
+}
+
+// 添加后端服务器
+func (lb *LoadBalancer) AddBackend(id string, httpPort int) {
+	lb.backendsMu.Lock()
+	defer lb.backendsMu.Unlock()
+
+	lb.addBackendUnsafe(id, "localhost", httpPort, 1, nil)
+}
+
+// AddBackendConfig 按配置添加后端服务器，支持指定主机、Host头、TLS和Unix域套接字
+func (lb *LoadBalancer) AddBackendConfig(cfg BackendConfig) error {
+	state := cfg.state()
+	endpoint, err := newBackendEndpoint(state.Host, state.BackendOptions)
+	if err != nil {
+		return fmt.Errorf("后端 %s: %v", cfg.ID, err)
+	}
+
+	lb.backendsMu.Lock()
+	defer lb.backendsMu.Unlock()
+	lb.addBackendUnsafe(state.ID, state.Host, state.Port, state.Weight, endpoint)
+	return nil
+}
+
+// 添加或替换后端服务器（调用方持有backendsMu），endpoint为nil表示明文连接且不改写Host头
+func (lb *LoadBalancer) addBackendUnsafe(id, host string, httpPort, weight int, endpoint *backendEndpoint) *BackendServer {
+	if endpoint == nil {
+		endpoint = &backendEndpoint{}
+	}
+	endpoint.bind(lb.addressFamily)
+	host = protocol.TrimHostBrackets(host)
+	httpAddr, wsAddr := endpoint.addresses(host, httpPort)
+
+	// 创建 HTTP 反向代理
+	targetURL, _ := url.Parse(httpAddr)
+	proxy := httputil.NewSingleHostReverseProxy(targetURL)
+	endpoint.configureProxy(proxy)
+
+	backend := &BackendServer{
+		ID:          id,
+		HTTPAddress: httpAddr,
+		WSAddress:   wsAddr,
+		IsHealthy:   true,
+		LastCheck:   time.Now(),
+		Weight:      weight,
+		Proxy:       proxy,
+		endpoint:    endpoint,
+	}
+	lb.backends[id] = backend
+
+	switch {
+	case endpoint.options.Socket != "":
+		log.Printf("添加后端服务器: %s -> HTTP:%s WS:%s (经Unix域套接字 %s)", id, httpAddr, wsAddr, endpoint.socketPath())
+	case endpoint.options.HostHeader != "":
+		log.Printf("添加后端服务器: %s -> HTTP:%s WS:%s (Host: %s)", id, httpAddr, wsAddr, endpoint.options.HostHeader)
+	default:
+		log.Printf("添加后端服务器: %s -> HTTP:%s WS:%s", id, httpAddr, wsAddr)
+	}
+	return backend
+}
+
+// 后端是否可以接收新连接
+func (b *BackendServer) isAvailable() bool {
+	return b.IsHealthy && !b.InMaintenance && b.drain == nil && b.hasCapacity()
+}
+
+// 估算后端当前的连接数：取上次健康检查上报值与本负载均衡器转发的连接数中较大者
+func (b *BackendServer) load() int {
+	if b.ReportedClients > b.Connections {
+		return b.ReportedClients
+	}
+	return b.Connections
+}
+
+// 后端是否还有剩余连接名额
+func (b *BackendServer) hasCapacity() bool {
+	return b.MaxClients <= 0 || b.load() < b.MaxClients
+}
+
+// 获取客户端唯一标识（用于会话保持）
+func (lb *LoadBalancer) getClientIdentifier(r *http.Request) string {
+	// 优先使用 Session Cookie
+	if cookie, err := r.Cookie("lb_session"); err == nil {
+		return cookie.Value
+	}
+
+	// 如果没有 Cookie，使用真实客户端IP + User-Agent 生成哈希
+	clientInfo := lb.clientIP(r) + r.UserAgent()
+	hash := md5.Sum([]byte(clientInfo))
+	return fmt.Sprintf("%x", hash)
+}
+
+// 在后端池中选择后端服务器（支持会话保持）
+func (lb *LoadBalancer) selectBackend(rt route) *BackendServer {
+	return lb.selectBackendExcluding(rt, nil)
+}
+
+// 选择后端服务器，跳过exclude中的后端（用于连接失败后的故障转移，会话会重新绑定到新后端）
+func (lb *LoadBalancer) selectBackendExcluding(rt route, exclude map[string]bool) *BackendServer {
+	lb.backendsMu.RLock()
+	defer lb.backendsMu.RUnlock()
+
+	pool := rt.pool
+	sessionKey := pool.sessionKey(rt.clientID)
+
+	// 检查是否有现有会话（命中时要更新最后访问时间，因此持写锁）
+	if rt.sticky {
+		lb.sessionsMu.Lock()
+		if session, exists := lb.sessions[sessionKey]; exists && !session.expired(lb.sessionTTL, time.Now()) {
+			if backend, exists := lb.backends[session.BackendID]; exists && backend.isAvailable() && pool.contains(backend.ID) && backend.supportsVersion(rt.version) && !exclude[backend.ID] {
+				session.LastSeen = time.Now()
+				lb.sessionsMu.Unlock()
+				return backend
+			}
+		}
+		lb.sessionsMu.Unlock()
+	}
+
+	// 没有会话或原后端不健康，选择新的后端
+	var healthyBackends []*BackendServer
+	for _, backend := range lb.backends {
+		if backend.isAvailable() && pool.contains(backend.ID) && backend.supportsVersion(rt.version) && !exclude[backend.ID] {
+			healthyBackends = append(healthyBackends, backend)
+		}
+	}
+
+	if len(healthyBackends) == 0 {
+		return nil
+	}
+
+	selectedBackend := pool.pick(healthyBackends, rt)
+	if !rt.sticky {
+		return selectedBackend
+	}
+
+	// 创建或更新会话
+	lb.sessionsMu.Lock()
+	lb.sessions[sessionKey] = &Session{
+		SessionID:  sessionKey,
+		BackendID:  selectedBackend.ID,
+		CreateTime: time.Now(),
+		LastSeen:   time.Now(),
+	}
+	lb.sessionsMu.Unlock()
+
+	return selectedBackend
+}
+
+// 处理所有请求的核心函数
+func (lb *LoadBalancer) handleRequest(w http.ResponseWriter, r *http.Request) {
+	isWebSocket := websocket.IsWebSocketUpgrade(r)
+	rec := lb.beginAccess(r, isWebSocket)
+	defer lb.finishAccess(rec)
+	w = rec.wrap(w, r)
+
+	if !lb.aclAllows(r) {
+		log.Printf("访问控制拒绝请求: %s %s", lb.clientIP(r), r.URL.Path)
+		http.Error(w, "访问被拒绝", http.StatusForbidden)
+		return
+	}
+	if isWebSocket && lb.emergency.active.Load() {
+		lb.rejectEmergency(w)
+		return
+	}
+
+	// 未通过认证的WebSocket握手直接拒绝，不分配后端和会话
+	var upgradeHeader http.Header
+	if isWebSocket && lb.auth != nil {
+		claims, header, err := lb.auth.AuthenticateUpgrade(r)
+		if err != nil {
+			log.Printf("拒绝未认证的WebSocket连接 (%s): %v", lb.clientIP(r), err)
+			http.Error(w, "认证失败: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if claims != nil {
+			logging.Debugf("WebSocket连接认证通过: sub=%s (%s)", claims.Subject, lb.clientIP(r))
+		}
+		upgradeHeader = header
+	} else if isWebSocket && auth.SubprotocolToken(r) != "" {
+		// 由后端校验令牌，这里仍需完成子协议协商，否则浏览器会拒绝握手
+		upgradeHeader = http.Header{"Sec-WebSocket-Protocol": {auth.SubprotocolName}}
+	}
+	if isWebSocket {
+		upgradeHeader = codecUpgradeHeader(r, upgradeHeader)
+	}
+
+	// 获取客户端标识，握手携带client_id时按客户端ID保持会话
+	clientID := lb.getClientIdentifier(r)
+	sessionID := clientID
+	if id := r.URL.Query().Get(ClientIDParam); id != "" && len(id) <= maxSessionClientIDLength {
+		sessionID = clientSessionKey(id)
+	}
+
+	// 按请求路径匹配后端池并选择后端服务器
+	rt := lb.routeFor(r, sessionID)
+	rec.setRoute(rt)
+
+	// 设置会话 Cookie
+	cookie := &http.Cookie{
+		Name:     "lb_session",
+		Value:    clientID,
+		Path:     "/",
+		MaxAge:   int(lb.sessionTTL / time.Second), // 与会话过期时间一致
+		HttpOnly: false,                            // 允许JS访问，方便WebSocket使用
+	}
+
+	// 没有client_id参数时，升级后根据注册消息中的client_id再选择后端
+	if isWebSocket && rt.sticky && lb.peekRegistration && sessionID == clientID {
+		http.SetCookie(w, cookie)
+		lb.handleWebSocketProxy(w, r, rt, nil, upgradeHeader, rec)
+		return
+	}
+
+	backend := lb.selectBackend(rt)
+	if backend == nil {
+		if isWebSocket {
+			lb.status.recordConnection(true)
+		}
+		http.Error(w, "没有可用的后端服务器", http.StatusServiceUnavailable)
+		return
+	}
+	http.SetCookie(w, cookie)
+
+	// 检查是否是 WebSocket 升级请求
+	if isWebSocket {
+		lb.handleWebSocketProxy(w, r, rt, backend, upgradeHeader, rec)
+		return
+	}
+
+	// HTTP 请求直接代理到后端，追踪上下文通过请求头传给节点
+	rec.setBackend(backend)
+	span := tracing.Start("lb.proxy", tracing.KindServer, tracing.Extract(r.Header))
+	defer span.End()
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.target", r.URL.Path)
+	span.SetAttribute("pool", rt.pool.name)
+	span.SetAttribute("backend.id", backend.ID)
+	tracing.Inject(r.Header, span.Context())
+	if lb.origins != nil {
+		r.Header.Del("Origin")
+	}
+	lb.forwarded.PrepareProxyRequest(r)
+	backend.Proxy.ServeHTTP(w, r)
+}
+
+// WebSocket 代理处理，backend为nil时先读取客户端的注册消息再选择后端。rec为nil表示不记录访问日志
+func (lb *LoadBalancer) handleWebSocketProxy(w http.ResponseWriter, r *http.Request, rt route, backend *BackendServer, upgradeHeader http.Header, rec *accessRecord) {
+	// 关闭中不再接受新连接（与Shutdown共用锁，保证proxyWG.Add先于Wait）
+	lb.proxyConnsMu.Lock()
+	if lb.draining.Load() {
+		lb.proxyConnsMu.Unlock()
+		http.Error(w, "负载均衡器正在关闭", http.StatusServiceUnavailable)
+		return
+	}
+	lb.proxyWG.Add(1)
+	lb.proxyConnsMu.Unlock()
+	defer lb.proxyWG.Done()
+
+	// 追踪从升级到后端连接建立的过程，连接后端时传递追踪上下文
+	span := tracing.Start("lb.websocket.upgrade", tracing.KindServer, tracing.Extract(r.Header))
+	defer span.End()
+	span.SetAttribute("net.peer.addr", r.RemoteAddr)
+	span.SetAttribute("client.address", lb.clientIP(r))
+	span.SetAttribute("pool", rt.pool.name)
+
+	// 升级客户端连接
+	clientConn, err := lb.upgrader.Upgrade(w, r, upgradeHeader)
+	if err != nil {
+		span.SetError(err)
+		log.Printf("WebSocket升级失败: %v", err)
+		return
+	}
+	defer clientConn.Close()
+	rec.setStatus(http.StatusSwitchingProtocols)
+	lb.applyCompression(clientConn)
+	lb.applyReadLimit(clientConn)
+	codec := protocol.NegotiateCodec(websocket.Subprotocols(r)) // 与codecUpgradeHeader的选择一致
+
+	// 登记代理连接，便于关闭时排空；升级期间紧急停止已生效的连接直接关闭
+	lb.proxyConnsMu.Lock()
+	if lb.emergency.active.Load() {
+		lb.proxyConnsMu.Unlock()
+		lb.emergency.rejected.Add(1)
+		clientConn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "集群处于紧急停止状态"))
+		return
+	}
+	lb.proxyConns[clientConn] = nil
+	lb.proxyConnsMu.Unlock()
+	defer func() {
+		lb.proxyConnsMu.Lock()
+		delete(lb.proxyConns, clientConn)
+		lb.proxyConnsMu.Unlock()
+	}()
+
+	// 按注册消息中的client_id保持会话，注册消息在连接后端后原样转发
+	var registration []byte
+	var registrationType int
+	if backend == nil {
+		messageType, data, id, err := peekRegistration(clientConn, codec)
+		if err != nil {
+			log.Printf("读取客户端注册消息失败 (%s): %v", lb.clientIP(r), err)
+			if errors.Is(err, websocket.ErrReadLimit) {
+				rec.setClose(CloseReasonTooBig, err)
+			} else {
+				rec.setClose(CloseReasonRegistration, err)
+			}
+			return
+		}
+		registrationType, registration = messageType, data
+		if counter := rec.inCounter(); counter != nil {
+			counter.Add(int64(len(data)))
+		}
+		if id != "" {
+			rt.clientID = clientSessionKey(id)
+		}
+		if backend = lb.selectBackend(rt); backend == nil {
+			lb.status.recordConnection(true)
+			rec.setClose(CloseReasonNoBackend, nil)
+			clientConn.WriteMessage(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "没有可用的后端服务器"))
+			return
+		}
+	}
+
+	// 连接到后端 WebSocket 服务器，失败时切换到其他健康后端
+	backendConn, backend, err := lb.dialBackend(r, rt, backend, span.Context())
+	lb.status.recordConnection(err != nil)
+	if err != nil {
+		span.SetError(err)
+		log.Printf("连接后端WebSocket失败: %v", err)
+		rec.setClose(CloseReasonDialFailed, err)
+		clientConn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "后端服务器连接失败"))
+		return
+	}
+	defer backendConn.Close()
+	if !lb.bindProxyConn(clientConn, backend) {
+		rec.setClose(CloseReasonBackendRemoved, nil)
+		clientConn.WriteMessage(websocket.CloseMessage, backendRemovedCloseMessage(backend.ID))
+		return
+	}
+	if rt.sticky {
+		defer lb.trackSessionConn(rt.pool.sessionKey(rt.clientID), clientConn)()
+	}
+	defer lb.versions.track(rt.version)()
+	rec.setBackend(backend)
+	lb.applyCompression(backendConn)
+	lb.applyReadLimit(backendConn)
+	transcode := codec != nil && backendConn.Subprotocol() != codec.Name()
+	if transcode {
+		log.Printf("后端 %s 未协商 %s 编码，由负载均衡器转换为JSON", backend.ID, codec.Name())
+		if registrationType == websocket.BinaryMessage {
+			if registration, err = codec.ToJSON(registration); err != nil {
+				log.Printf("转换注册消息失败: %v", err)
+				return
+			}
+			registrationType = websocket.TextMessage
+		}
+	}
+	// 抽中的连接将发给后端的消息同样复制到影子后端
+	shadow := lb.attachShadow(r)
+	defer shadow.close()
+	if registration != nil {
+		if err := backendConn.WriteMessage(registrationType, registration); err != nil {
+			log.Printf("转发注册消息到 %s 失败: %v", backend.ID, err)
+			return
+		}
+		shadow.send(registrationType, registration)
+	}
+
+	logging.Debugf("WebSocket连接已建立: 客户端 -> %s", backend.ID)
+	span.SetAttribute("backend.id", backend.ID)
+	span.End()
+
+	// 增加连接计数
+	lb.backendsMu.Lock()
+	backend.Connections++
+	lb.backendsMu.Unlock()
+
+	// 清理函数
+	defer func() {
+		lb.backendsMu.Lock()
+		if backend.Connections > 0 {
+			backend.Connections--
+		}
+		lb.backendsMu.Unlock()
+		if !backend.removed.Load() {
+			lb.recordDisconnect(backend.ID)
+		}
+		logging.Debugf("WebSocket连接已关闭: 客户端 -> %s", backend.ID)
+	}()
+
+	// 双向消息转发，记录先结束的方向作为关闭原因
+	relayControlFrames(clientConn, backendConn)
+	type relayResult struct {
+		fromClient bool
+		err        error
+	}
+	resultChan := make(chan relayResult, 2)
+
+	if transcode {
+		go func() {
+			err := lb.buffers.transcodeMessages(backendConn, clientConn, websocket.BinaryMessage, websocket.TextMessage, codec.ToJSON, rec.inCounter(), shadow)
+			resultChan <- relayResult{true, err}
+		}()
+		go func() {
+			err := lb.buffers.transcodeMessages(clientConn, backendConn, websocket.TextMessage, websocket.BinaryMessage, codec.FromJSON, rec.outCounter(), nil)
+			resultChan <- relayResult{false, err}
+		}()
+	} else {
+		// 客户端 -> 后端
+		go func() {
+			resultChan <- relayResult{true, lb.buffers.proxyMessages(backendConn, clientConn, rec.inCounter(), shadow)}
+		}()
+
+		// 后端 -> 客户端
+		go func() {
+			resultChan <- relayResult{false, lb.buffers.proxyMessages(clientConn, backendConn, rec.outCounter(), nil)}
+		}()
+	}
+
+	// 等待任一方向发生错误
+	result := <-resultChan
+	// 将先结束一侧的关闭码和原因转告另一侧，等另一侧回应关闭帧（其转发随之结束）后再断开，
+	// 客户端据此区分后端正常关闭和异常断开
+	other := clientConn
+	if result.fromClient {
+		other = backendConn
+	}
+	if err := other.WriteControl(websocket.CloseMessage, relayCloseMessage(result.fromClient, result.err),
+		time.Now().Add(time.Second)); err == nil {
+		select {
+		case <-resultChan:
+		case <-time.After(closeHandshakeTimeout):
+		}
+	}
+	if backend.removed.Load() {
+		rec.setClose(CloseReasonBackendRemoved, result.err)
+		return
+	}
+	rec.setClose(lb.relayCloseReason(result.fromClient, result.err), result.err)
+}
+
+// 为代理连接设置消息大小上限
+func (lb *LoadBalancer) applyReadLimit(conn *websocket.Conn) {
+	if lb.maxMessageSize > 0 {
+		conn.SetReadLimit(lb.maxMessageSize)
+	}
+}
+
+// 连接后端WebSocket，失败时按负载均衡策略依次尝试其他健康后端，最多重试proxyRetries次。
+// trace有效时通过traceparent请求头传给后端
+func (lb *LoadBalancer) dialBackend(r *http.Request, rt route, backend *BackendServer, trace tracing.SpanContext) (*websocket.Conn, *BackendServer, error) {
+	tried := make(map[string]bool)
+	var lastErr error
+
+	// 透传子协议（可能携带认证令牌），查询参数随URL一起转发；转发请求头和PROXY协议头告知后端真实的客户端地址
+	header := http.Header{}
+	if protocols := r.Header.Values("Sec-WebSocket-Protocol"); len(protocols) > 0 {
+		header["Sec-WebSocket-Protocol"] = protocols
+	}
+	if trace.IsValid() {
+		tracing.Inject(header, trace)
+	}
+	lb.forwarded.SetUpgradeHeaders(header, r)
+	proxySource := lb.proxyHeaderSource(r)
+
+	for attempt := 0; attempt <= lb.proxyRetries && backend != nil; attempt++ {
+		backendURL := backend.WSAddress
+		if r.URL.RawQuery != "" {
+			backendURL += "?" + r.URL.RawQuery
+		}
+
+		conn, _, err := backend.endpoint.dial(lb.dialer, backendURL, header, proxySource)
+		if err == nil {
+			if attempt > 0 {
+				log.Printf("故障转移成功: 客户端会话已重新绑定到 %s", backend.ID)
+			}
+			return conn, backend, nil
+		}
+
+		log.Printf("连接后端 %s 失败 (第%d次尝试): %v", backend.ID, attempt+1, err)
+		lastErr = err
+		tried[backend.ID] = true
+		backend = lb.selectBackendExcluding(rt, tried)
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("没有可用的后端服务器")
+	}
+	return nil, nil, lastErr
+}
+
+// 启动负载均衡器
+func (lb *LoadBalancer) Start() error {
+	// 恢复持久化的会话
+	lb.loadSessions()
+
+	// 启动健康检查、维护窗口调度和会话清理
+	if lb.observer != nil {
+		go lb.runObserver()
+	}
+	go lb.healthCheck()
+	go lb.maintenanceScheduler()
+	go lb.sessionMaintenance()
+	if lb.discovery != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		lb.stopDiscovery = cancel
+		go lb.runDiscovery(ctx)
+	}
+
+	// API 路由
+	lb.mux.HandleFunc("/api/global-clients", lb.handleGlobalClients)
+	lb.mux.HandleFunc("/api/global-clients/export", registry.HandleExport)     // NDJSON流式导出
+	lb.mux.HandleFunc("/api/global-clients/rebuild", lb.handleRegistryRebuild) // 按节点上报重建注册表
+	lb.mux.HandleFunc("/api/all-clients", lb.handleAllClients)                 // 聚合所有节点的客户端
+	lb.mux.HandleFunc("/api/command-latency", lb.handleCommandLatency)         // 聚合所有节点的指令时延
+	lb.mux.HandleFunc("/api/emergency-stop", lb.handleEmergencyStop)           // 集群紧急停止
+	lb.mux.HandleFunc("/api/backends", lb.handleBackends)
+	lb.mux.HandleFunc("/api/backends/", lb.handleBackendDetail)
+	lb.mux.HandleFunc("/api/annotations", registry.HandleAnnotations)
+	lb.mux.HandleFunc("/api/maintenance", lb.handleMaintenance)
+	lb.mux.HandleFunc("/api/timeline", lb.handleTimeline)
+	lb.mux.HandleFunc("/api/pools", lb.handlePools)
+	lb.mux.HandleFunc("/api/pools/", lb.handlePool)
+	lb.mux.HandleFunc("/api/cluster", lb.handleCluster)
+	lb.mux.HandleFunc("/api/acl", lb.handleACL)
+	lb.mux.HandleFunc("/api/certificates", lb.handleCertificates)
+	lb.mux.HandleFunc("/api/reload", lb.handleReload)
+	lb.mux.HandleFunc("/api/mirror", lb.handleMirror)                      // 供只读观察者镜像状态
+	lb.mux.HandleFunc("/api/observer", lb.handleObserver)                  // 只读观察者的同步状态
+	lb.mux.HandleFunc("/api/shadow", lb.handleShadow)                      // 影子流量统计
+	lb.mux.HandleFunc("/api/protocol-versions", lb.handleProtocolVersions) // 协议版本分布
+	lb.mux.HandleFunc("/api/build-versions", lb.handleBuildVersions)       // 后端构建版本分布
+	lb.mux.HandleFunc("/api/cluster-stats", lb.handleClusterStats)         // 聚合所有节点的统计
+	lb.mux.HandleFunc("/api/sessions", lb.handleSessions)                  // 会话保持记录
+	lb.mux.HandleFunc("/api/sessions/", lb.handleSessionDetail)            // 重置会话、强制断开连接
+	lb.mux.HandleFunc("/api/status", lb.handleStatus)                      // 集群健康状态
+	lb.mux.HandleFunc("/status", lb.handleStatusPage)                      // 公开的集群状态页
+	lb.mux.Handle("/admin/", adminHandler())                               // 内嵌的管理界面
+	if handler := auth.TokenHandler(lb.auth); handler != nil {
+		lb.mux.HandleFunc("/api/token", handler)
+	}
+
+	// 所有其他请求都通过转发处理器
+	lb.mux.HandleFunc("/", lb.handleRequest)
+	var handler http.Handler = lb.mux
+	if lb.observer != nil {
+		handler = lb.observerGuard(handler)
+	}
+	lb.httpServer.Handler = lb.origins.Wrap(handler)
+
+	log.Printf("纯七层负载均衡器启动在端口 %d", lb.port)
+	log.Printf("管理界面: http://%s/admin/", net.JoinHostPort(protocol.LocalHost(lb.listenAddress, lb.addressFamily), strconv.Itoa(lb.port)))
+	if lb.unixSocket != "" {
+		listener, err := protocol.ListenUnix(lb.unixSocket)
+		if err != nil {
+			return fmt.Errorf("监听Unix域套接字失败: %w", err)
+		}
+		log.Printf("负载均衡器同时监听Unix域套接字 %s", listener.Addr())
+		go func() {
+			if err := lb.httpServer.Serve(listener); err != http.ErrServerClosed {
+				log.Printf("Unix域套接字监听出错: %v", err)
+			}
+		}()
+	}
+	log.Printf("负载均衡策略: %s (另有 %d 个按路径路由的后端池)", lb.defaultPool.getStrategy(), len(lb.pools))
+	log.Printf("健康检查: 每 %v 通过 %s 探测，连续失败 %d 次判定不健康，连续成功 %d 次恢复",
+		lb.healthInterval, lb.healthProtocol, lb.unhealthyThreshold, lb.healthyThreshold)
+	lb.backendsMu.RLock()
+	backendCount := len(lb.backends)
+	lb.backendsMu.RUnlock()
+	lb.RecordEvent(EventLBStart, "", fmt.Sprintf("负载均衡器启动在端口 %d", lb.port),
+		map[string]interface{}{"strategy": lb.defaultPool.getStrategy(), "backends": backendCount, "pools": len(lb.pools)})
+
+	listener, err := protocol.Listen(lb.addressFamily, lb.listenAddress, lb.port)
+	if err != nil {
+		return err
+	}
+	log.Printf("负载均衡器监听 %s", listener.Addr())
+	// 前面的四层代理通过PROXY协议传递客户端地址，在TLS之前解析
+	listener = lb.forwarded.Listen(listener)
+	if lb.autocert != nil {
+		if err := lb.autocert.start(lb.listenAddress, lb.addressFamily); err != nil {
+			listener.Close()
+			return err
+		}
+		// TLS-ALPN-01 验证连接握手后即关闭，不进入HTTP处理
+		lb.httpServer.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){
+			acme.ALPNProto: func(*http.Server, *tls.Conn, http.Handler) {},
+		}
+		listener = tls.NewListener(listener, lb.autocert.tlsConfig())
+		log.Printf("负载均衡器端口 %d 启用HTTPS（自动证书）", lb.port)
+	} else if lb.tlsCerts != nil {
+		lb.tlsCerts.start()
+		listener = tls.NewListener(listener, lb.tlsCerts.tlsConfig())
+		log.Printf("负载均衡器端口 %d 启用HTTPS（证书文件）", lb.port)
+	}
+	lb.unregisterMetrics = tracing.RegisterMetrics(lb.otlpMetrics)
+	if registry.NeedsRebuild() && lb.observer == nil {
+		// 注册表文件损坏，等节点通过健康检查后按其上报的在线客户端重建
+		go lb.rebuildRegistryOnStart()
+	}
+	close(lb.ready)
+	if err := lb.httpServer.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown 优雅关闭：停止接收新连接，向客户端发送关闭帧并等待代理连接结束，
+// ctx 到期后强制关闭剩余连接
+func (lb *LoadBalancer) Shutdown(ctx context.Context) error {
+	defer lb.accessLog.Close()
+	lb.proxyConnsMu.Lock()
+	lb.draining.Store(true)
+	activeConns := len(lb.proxyConns)
+	lb.proxyConnsMu.Unlock()
+	lb.RecordEvent(EventLBDrain, "", "负载均衡器开始优雅关闭",
+		map[string]interface{}{"connections": activeConns})
+	if lb.stopDiscovery != nil {
+		lb.stopDiscovery()
+	}
+	if lb.autocert != nil {
+		lb.autocert.shutdown(ctx)
+	}
+	if lb.tlsCerts != nil {
+		lb.tlsCerts.shutdown()
+	}
+	err := lb.httpServer.Shutdown(ctx)
+	if lb.unregisterMetrics != nil {
+		lb.unregisterMetrics()
+	}
+
+	// 通知所有客户端负载均衡器即将关闭
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "负载均衡器关闭")
+	lb.proxyConnsMu.Lock()
+	for conn := range lb.proxyConns {
+		conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+	}
+	lb.proxyConnsMu.Unlock()
+
+	// 等待所有代理连接结束
+	done := make(chan struct{})
+	go func() {
+		lb.proxyWG.Wait()
+		close(done)
+	}()
+
+	// 保存会话，重启后恢复会话保持（只读观察者不写会话存储）
+	if !lb.isObserver() {
+		lb.saveSessions()
+	}
+	if closer, ok := lb.sessionStore.(io.Closer); ok {
+		closer.Close()
+	}
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		lb.proxyConnsMu.Lock()
+		log.Printf("负载均衡器排空超时，强制关闭 %d 个连接", len(lb.proxyConns))
+		for conn := range lb.proxyConns {
+			conn.Close()
+		}
+		lb.proxyConnsMu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// handleBackends 后端服务器状态列表
+func (lb *LoadBalancer) handleBackends(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		lb.handleBackendRegistration(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	lb.backendsMu.RLock()
+	defer lb.backendsMu.RUnlock()
+
+	backends := make([]map[string]interface{}, 0, len(lb.backends))
+	for _, backend := range lb.backends {
+		backends = append(backends, map[string]interface{}{
+			"id":                   backend.ID,
+			"address":              backend.WSAddress,
+			"http_address":         backend.HTTPAddress,
+			"connections":          backend.Connections,
+			"is_healthy":           backend.IsHealthy,
+			"in_maintenance":       backend.InMaintenance,
+			"draining":             backend.drain != nil,
+			"last_check":           backend.LastCheck.Format("15:04:05"),
+			"last_error":           backend.LastError,
+			"consecutive_failures": backend.ConsecutiveFailures,
+			"flap_score":           flapScore(backend.probeHistory),
+			"hold_down":            time.Now().Before(backend.HoldDownUntil),
+			"reported_clients":     backend.ReportedClients,
+			"max_clients":          backend.MaxClients,
+			"protocol_versions":    backend.ProtocolVersions,
+			"build_version":        backend.BuildVersion,
+			"labels":               backend.Labels,
+			"weight":               backend.Weight,
+			"discovered":           backend.Discovered,
+			"registered":           backend.Registered,
+			"host_header":          backend.endpoint.options.HostHeader,
+			"tls":                  backend.endpoint.options.TLS,
+			"socket":               backend.endpoint.options.Socket,
+			"annotation":           registry.GetAnnotation(registry.AnnotationTargetBackend, backend.ID),
+		})
+	}
+
+	response := map[string]interface{}{
+		"strategy": lb.defaultPool.getStrategy(),
+		"total":    len(backends),
+		"backends": backends,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleGlobalClients 负载均衡器的全局客户端API（读取JSON文件），带 ?limit= 或 ?cursor= 时按客户端ID分页返回
+func (lb *LoadBalancer) handleGlobalClients(w http.ResponseWriter, r *http.Request) {
+	if registry.IsPageRequest(r) {
+		registry.HandleClientPage(w, r, map[string]interface{}{"source": "loadbalancer"})
+		return
+	}
+	// 直接读取全局JSON文件，?namespace=、?selector= 只返回该命名空间、标签满足选择器的客户端
+	match, err := registry.RequestFilter(r)
+	if err != nil {
+		http.Error(w, "无效的标签选择器: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	globalClients := registry.All()
+
+	var clients []registry.ClientInfo
+	for _, client := range globalClients {
+		if match == nil || match(client) {
+			clients = append(clients, *client)
+		}
+	}
+
+	response := map[string]interface{}{
+		"source":  "loadbalancer",
+		"total":   len(clients),
+		"clients": clients,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleAllClients 聚合所有后端节点的客户端数据，?namespace=、?selector= 只返回该命名空间、标签满足选择器的客户端
+func (lb *LoadBalancer) handleAllClients(w http.ResponseWriter, r *http.Request) {
+	if _, err := registry.RequestFilter(r); err != nil {
+		http.Error(w, "无效的标签选择器: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	filter := url.Values{}
+	for _, key := range []string{"namespace", "selector"} {
+		if v := r.URL.Query().Get(key); v != "" {
+			filter.Set(key, v)
+		}
+	}
+
+	// 查询节点期间不持有backendsMu，避免阻塞健康检查和新连接的后端选择
+	lb.backendsMu.RLock()
+	nodesTotal := len(lb.backends)
+	healthy := make([]*BackendServer, 0, len(lb.backends))
+	for _, backend := range lb.backends {
+		if backend.IsHealthy {
+			healthy = append(healthy, backend)
+		}
+	}
+	lb.backendsMu.RUnlock()
+
+	allClients := make([]registry.ClientInfo, 0)
+	totalClients := 0
+
+	// 从所有健康的后端节点获取客户端数据
+	for _, backend := range healthy {
+		// 从后端节点获取全局客户端数据
+		nodeURL := fmt.Sprintf("%s/api/global-clients", backend.HTTPAddress)
+		if len(filter) > 0 {
+			nodeURL += "?" + filter.Encode()
+		}
+		resp, err := backend.endpoint.get(http.DefaultClient, nodeURL)
+		if err != nil {
+			log.Printf("获取节点 %s 客户端数据失败: %v", backend.ID, err)
+			continue
+		}
+		var nodeResponse struct {
+			Clients []registry.ClientInfo `json:"clients"`
+			Total   int                   `json:"total"`
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(&nodeResponse)
+		resp.Body.Close()
+		if err != nil {
+			log.Printf("解析节点 %s 客户端数据失败: %v", backend.ID, err)
+			continue
+		}
+
+		allClients = append(allClients, nodeResponse.Clients...)
+		totalClients += nodeResponse.Total
+	}
+
+	// 去重处理（按客户端ID）
+	uniqueClients := make(map[string]registry.ClientInfo)
+	for _, client := range allClients {
+		uniqueClients[client.ID] = client
+	}
+
+	finalClients := make([]registry.ClientInfo, 0, len(uniqueClients))
+	for _, client := range uniqueClients {
+		finalClients = append(finalClients, client)
+	}
+
+	response := map[string]interface{}{
+		"source":        "aggregated_from_all_nodes",
+		"total":         len(finalClients),
+		"clients":       finalClients,
+		"nodes_queried": nodesTotal,
+		"healthy_nodes": len(healthy),
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,210 @@
+package lb
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 维护窗口状态
+const (
+	MaintenanceScheduled = "scheduled" // 等待开始
+	MaintenanceActive    = "active"    // 进行中，后端已排空
+	MaintenanceCompleted = "completed" // 已结束，后端已恢复
+	MaintenanceCancelled = "cancelled" // 已取消
+)
+
+// MaintenanceWindow 后端维护窗口，窗口期内负载均衡器不再向该后端分配新连接
+type MaintenanceWindow struct {
+	ID        string    `json:"id"`
+	BackendID string    `json:"backend_id"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Reason    string    `json:"reason,omitempty"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// 添加维护窗口
+func (lb *LoadBalancer) ScheduleMaintenance(backendID string, start, end time.Time, reason string) MaintenanceWindow {
+	window := &MaintenanceWindow{
+		ID:        "mw_" + strconv.FormatInt(time.Now().UnixNano(), 36),
+		BackendID: backendID,
+		Start:     start,
+		End:       end,
+		Reason:    reason,
+		Status:    MaintenanceScheduled,
+		CreatedAt: time.Now(),
+	}
+
+	lb.maintenanceMu.Lock()
+	lb.maintenance[window.ID] = window
+	result := *window
+	lb.maintenanceMu.Unlock()
+
+	log.Printf("计划维护窗口 %s: 后端 %s %s ~ %s", window.ID, backendID,
+		start.Format("2006-01-02 15:04:05"), end.Format("2006-01-02 15:04:05"))
+	lb.RecordEvent(EventMaintenanceScheduled, backendID,
+		fmt.Sprintf("计划维护窗口 %s ~ %s", start.Format("2006-01-02 15:04:05"), end.Format("2006-01-02 15:04:05")),
+		map[string]interface{}{"window_id": window.ID, "reason": reason})
+
+	// 立即生效的窗口不必等待下一轮调度
+	lb.applyMaintenance()
+	return result
+}
+
+// 取消维护窗口，进行中的窗口会立即恢复后端
+func (lb *LoadBalancer) CancelMaintenance(id string) (MaintenanceWindow, bool) {
+	lb.maintenanceMu.Lock()
+	window, exists := lb.maintenance[id]
+	if !exists {
+		lb.maintenanceMu.Unlock()
+		return MaintenanceWindow{}, false
+	}
+	if window.Status == MaintenanceScheduled || window.Status == MaintenanceActive {
+		window.Status = MaintenanceCancelled
+	}
+	result := *window
+	lb.maintenanceMu.Unlock()
+
+	log.Printf("取消维护窗口 %s (后端 %s)", id, result.BackendID)
+	lb.RecordEvent(EventMaintenanceCancelled, result.BackendID, "取消维护窗口 "+id,
+		map[string]interface{}{"window_id": id})
+	lb.applyMaintenance()
+	return result, true
+}
+
+// 获取维护日历（按开始时间排序）
+func (lb *LoadBalancer) ListMaintenance(backendID string) []MaintenanceWindow {
+	lb.maintenanceMu.RLock()
+	defer lb.maintenanceMu.RUnlock()
+
+	windows := make([]MaintenanceWindow, 0, len(lb.maintenance))
+	for _, window := range lb.maintenance {
+		if backendID != "" && window.BackendID != backendID {
+			continue
+		}
+		windows = append(windows, *window)
+	}
+
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i].Start.Before(windows[j].Start)
+	})
+	return windows
+}
+
+// 根据当前时间推进维护窗口状态，并同步后端的维护标记
+func (lb *LoadBalancer) applyMaintenance() {
+	now := time.Now()
+	inMaintenance := make(map[string]bool)
+
+	lb.maintenanceMu.Lock()
+	for _, window := range lb.maintenance {
+		switch window.Status {
+		case MaintenanceScheduled, MaintenanceActive:
+			if !now.Before(window.End) {
+				window.Status = MaintenanceCompleted
+			} else if !now.Before(window.Start) {
+				window.Status = MaintenanceActive
+				inMaintenance[window.BackendID] = true
+			}
+		}
+	}
+	lb.maintenanceMu.Unlock()
+
+	lb.backendsMu.Lock()
+	for id, backend := range lb.backends {
+		if inMaintenance[id] && !backend.InMaintenance {
+			log.Printf("后端服务器 %s 进入维护窗口，停止分配新连接（现有连接数: %d）", id, backend.Connections)
+			lb.RecordEvent(EventMaintenanceStarted, id, "进入维护窗口，开始排空",
+				map[string]interface{}{"connections": backend.Connections})
+		} else if !inMaintenance[id] && backend.InMaintenance {
+			log.Printf("后端服务器 %s 维护结束，恢复分配连接", id)
+			lb.RecordEvent(EventMaintenanceEnded, id, "维护结束，恢复分配连接", nil)
+		}
+		backend.InMaintenance = inMaintenance[id]
+	}
+	lb.backendsMu.Unlock()
+}
+
+// 维护窗口调度
+func (lb *LoadBalancer) maintenanceScheduler() {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// 只读观察者的维护窗口和排空状态来自镜像
+		if lb.observer.mirroring() {
+			continue
+		}
+		lb.applyMaintenance()
+		lb.checkDrains()
+		lb.expireRegistrations()
+	}
+}
+
+// handleMaintenance 维护窗口管理API
+// GET    列出维护日历，可选 ?backend_id= 过滤
+// POST   {"backend_id": "node1", "start": "RFC3339", "end": "RFC3339", "reason": "..."}，start为空表示立即开始
+// DELETE ?id=mw_xxx 取消维护窗口
+func (lb *LoadBalancer) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		windows := lb.ListMaintenance(r.URL.Query().Get("backend_id"))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"total":   len(windows),
+			"windows": windows,
+		})
+	case "POST":
+		var req struct {
+			BackendID string    `json:"backend_id"`
+			Start     time.Time `json:"start"`
+			End       time.Time `json:"end"`
+			Reason    string    `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "请求格式错误（时间需为RFC3339格式）", http.StatusBadRequest)
+			return
+		}
+
+		lb.backendsMu.RLock()
+		_, exists := lb.backends[req.BackendID]
+		lb.backendsMu.RUnlock()
+		if !exists {
+			http.Error(w, "后端服务器不存在", http.StatusNotFound)
+			return
+		}
+
+		if req.Start.IsZero() {
+			req.Start = time.Now()
+		}
+		if !req.End.After(req.Start) || !req.End.After(time.Now()) {
+			http.Error(w, "end必须晚于start和当前时间", http.StatusBadRequest)
+			return
+		}
+
+		window := lb.ScheduleMaintenance(req.BackendID, req.Start, req.End, req.Reason)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"window":  window,
+		})
+	case "DELETE":
+		window, exists := lb.CancelMaintenance(r.URL.Query().Get("id"))
+		if !exists {
+			http.Error(w, "维护窗口不存在", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"window":  window,
+		})
+	default:
+		http.Error(w, "仅支持GET、POST和DELETE请求", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,49 @@
+package lb
+
+import (
+	"sort"
+
+	"websocket-loadbalance/tracing"
+)
+
+// otlpMetrics 通过OTLP推送的负载均衡器指标（启用 tracing.metrics 时）：后端数量、健康状态、构建版本分歧和各后端的代理连接数
+func (lb *LoadBalancer) otlpMetrics() []tracing.Metric {
+	lb.backendsMu.RLock()
+	ids := make([]string, 0, len(lb.backends))
+	for id := range lb.backends {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	healthy, outdated := 0, 0
+	buildVersions := len(lb.healthyBuildVersionsUnsafe())
+	perBackend := make([]tracing.Metric, 0, 2*len(ids))
+	for _, id := range ids {
+		backend := lb.backends[id]
+		attrs := map[string]string{"backend.id": id}
+		if backend.outdated {
+			outdated++
+		}
+		up := 0.0
+		if backend.IsHealthy {
+			healthy++
+			up = 1
+		}
+		perBackend = append(perBackend,
+			tracing.Metric{Name: "websocket.lb.backend.connections", Description: "经负载均衡器转发到后端的连接数", Unit: "1", Kind: tracing.Gauge, Value: float64(backend.Connections), Attributes: attrs},
+			tracing.Metric{Name: "websocket.lb.backend.healthy", Description: "后端是否健康（1健康，0不健康）", Unit: "1", Kind: tracing.Gauge, Value: up, Attributes: attrs},
+		)
+	}
+	lb.backendsMu.RUnlock()
+
+	lb.proxyConnsMu.Lock()
+	connections := len(lb.proxyConns)
+	lb.proxyConnsMu.Unlock()
+
+	return append([]tracing.Metric{
+		{Name: "websocket.lb.backends", Description: "后端数量", Unit: "1", Kind: tracing.Gauge, Value: float64(len(ids))},
+		{Name: "websocket.lb.backends.healthy", Description: "健康的后端数量", Unit: "1", Kind: tracing.Gauge, Value: float64(healthy)},
+		{Name: "websocket.lb.connections", Description: "正在代理的客户端连接数", Unit: "1", Kind: tracing.Gauge, Value: float64(connections)},
+		{Name: "websocket.lb.build_versions", Description: "健康后端的不同构建版本数，大于1说明滚动升级未完成", Unit: "1", Kind: tracing.Gauge, Value: float64(buildVersions)},
+		{Name: "websocket.lb.backends.outdated", Description: "构建版本低于 min_backend_version 的后端数量", Unit: "1", Kind: tracing.Gauge, Value: float64(outdated)},
+	}, perBackend...)
+}
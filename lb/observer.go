@@ -0,0 +1,385 @@
+package lb
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+)
+
+// 只读观察者的默认同步间隔
+const defaultObserverSyncInterval = 5 * time.Second
+
+// ObserverConfig 只读观察者模式：定期从主负载均衡器镜像后端健康、会话、注册表、维护窗口和时间线，
+// 提供全部只读API和仪表盘，拒绝修改请求和客户端连接。主负载均衡器不可用时可改为正常模式重启接管
+type ObserverConfig struct {
+	Enabled      bool              `json:"enabled" yaml:"enabled"`
+	Primary      string            `json:"primary" yaml:"primary"`             // 主负载均衡器地址，如 http://lb1:8080
+	SyncInterval protocol.Duration `json:"sync_interval" yaml:"sync_interval"` // 拉取镜像状态的间隔，默认5s
+	StaleAfter   protocol.Duration `json:"stale_after" yaml:"stale_after"`     // 超过该时长未同步成功时恢复自行健康检查，默认3倍同步间隔
+}
+
+// Validate 校验只读观察者配置
+func (c ObserverConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if !strings.HasPrefix(c.Primary, "http://") && !strings.HasPrefix(c.Primary, "https://") {
+		return fmt.Errorf("observer.primary 必须是主负载均衡器的 http:// 或 https:// 地址: %q", c.Primary)
+	}
+	if c.SyncInterval < 0 || c.StaleAfter < 0 {
+		return fmt.Errorf("observer 的同步间隔不能为负数")
+	}
+	return nil
+}
+
+// BackendMirror 镜像的后端健康状态
+type BackendMirror struct {
+	ID                   string                 `json:"id"`
+	IsHealthy            bool                   `json:"is_healthy"`
+	InMaintenance        bool                   `json:"in_maintenance"`
+	Drain                DrainStatus            `json:"drain"`
+	Connections          int                    `json:"connections"` // 经主负载均衡器转发的连接数
+	ReportedClients      int                    `json:"reported_clients"`
+	MaxClients           int                    `json:"max_clients"`
+	ProtocolVersions     *protocol.VersionRange `json:"protocol_versions,omitempty"`
+	LastCheck            time.Time              `json:"last_check"`
+	LastError            string                 `json:"last_error,omitempty"`
+	ConsecutiveSuccesses int                    `json:"consecutive_successes"`
+	ConsecutiveFailures  int                    `json:"consecutive_failures"`
+	HoldDownUntil        time.Time              `json:"hold_down_until"`
+	ProbeHistory         []ProbeResult          `json:"probe_history"`
+}
+
+// MirrorSnapshot GET /api/mirror 返回的状态快照，只读观察者据此替换本地状态
+type MirrorSnapshot struct {
+	Time        time.Time                       `json:"time"`
+	Backends    []BackendMirror                 `json:"backends"`
+	Sessions    map[string]*Session             `json:"sessions"`
+	Clients     map[string]*registry.ClientInfo `json:"clients"`
+	Annotations map[string]*registry.Annotation `json:"annotations"`
+	Maintenance []MaintenanceWindow             `json:"maintenance"`
+	Timeline    []TimelineEvent                 `json:"timeline"`
+}
+
+// ObserverStatus 只读观察者的同步状态
+type ObserverStatus struct {
+	Enabled             bool       `json:"enabled"`
+	Primary             string     `json:"primary,omitempty"`
+	SyncInterval        string     `json:"sync_interval,omitempty"`
+	LastSync            *time.Time `json:"last_sync,omitempty"`     // 最近一次成功同步的时间
+	SnapshotTime        *time.Time `json:"snapshot_time,omitempty"` // 最近一次快照在主负载均衡器上生成的时间
+	LastError           string     `json:"last_error,omitempty"`
+	Syncs               int64      `json:"syncs"`
+	Failures            int64      `json:"failures"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Stale               bool       `json:"stale"`                      // 镜像已过期，正在自行健康检查
+	UnknownBackends     []string   `json:"unknown_backends,omitempty"` // 主负载均衡器上有、本地配置中没有的后端
+}
+
+// observerState 只读观察者的同步状态，nil表示不是观察者
+type observerState struct {
+	primary    string
+	interval   time.Duration
+	staleAfter time.Duration
+	client     *http.Client
+
+	mu                  sync.Mutex
+	lastSync            time.Time
+	snapshotTime        time.Time
+	lastError           string
+	syncs               int64
+	failures            int64
+	consecutiveFailures int
+	stale               bool
+	unknownBackends     []string
+}
+
+// SetObserver 设置只读观察者模式（需在Start之前调用）
+func (lb *LoadBalancer) SetObserver(cfg ObserverConfig) {
+	if !cfg.Enabled {
+		lb.observer = nil
+		return
+	}
+	interval := time.Duration(cfg.SyncInterval)
+	if interval <= 0 {
+		interval = defaultObserverSyncInterval
+	}
+	staleAfter := time.Duration(cfg.StaleAfter)
+	if staleAfter <= 0 {
+		staleAfter = 3 * interval
+	}
+	lb.observer = &observerState{
+		primary:    strings.TrimSuffix(cfg.Primary, "/"),
+		interval:   interval,
+		staleAfter: staleAfter,
+		client:     &http.Client{Timeout: interval + 5*time.Second},
+		stale:      true,
+	}
+}
+
+// mirroring 是否正在使用新鲜的镜像状态，此时不自行健康检查、不推进维护窗口、不持久化会话
+func (o *observerState) mirroring() bool {
+	if o == nil {
+		return false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return !o.lastSync.IsZero() && time.Since(o.lastSync) < o.staleAfter
+}
+
+// isObserver 是否运行在只读观察者模式
+func (lb *LoadBalancer) isObserver() bool {
+	return lb.observer != nil
+}
+
+// MirrorSnapshot 导出当前状态，供只读观察者镜像
+func (lb *LoadBalancer) MirrorSnapshot() *MirrorSnapshot {
+	snapshot := &MirrorSnapshot{Time: time.Now()}
+
+	lb.backendsMu.RLock()
+	snapshot.Backends = make([]BackendMirror, 0, len(lb.backends))
+	for _, backend := range lb.backends {
+		snapshot.Backends = append(snapshot.Backends, BackendMirror{
+			ID:                   backend.ID,
+			IsHealthy:            backend.IsHealthy,
+			InMaintenance:        backend.InMaintenance,
+			Drain:                backend.drainStatus(),
+			Connections:          backend.Connections,
+			ReportedClients:      backend.ReportedClients,
+			MaxClients:           backend.MaxClients,
+			ProtocolVersions:     backend.ProtocolVersions,
+			LastCheck:            backend.LastCheck,
+			LastError:            backend.LastError,
+			ConsecutiveSuccesses: backend.ConsecutiveSuccesses,
+			ConsecutiveFailures:  backend.ConsecutiveFailures,
+			HoldDownUntil:        backend.HoldDownUntil,
+			ProbeHistory:         append([]ProbeResult(nil), backend.probeHistory...),
+		})
+	}
+	lb.backendsMu.RUnlock()
+	sort.Slice(snapshot.Backends, func(i, j int) bool { return snapshot.Backends[i].ID < snapshot.Backends[j].ID })
+
+	lb.sessionsMu.RLock()
+	snapshot.Sessions = make(map[string]*Session, len(lb.sessions))
+	for id, session := range lb.sessions {
+		copied := *session
+		snapshot.Sessions[id] = &copied
+	}
+	lb.sessionsMu.RUnlock()
+
+	snapshot.Clients = registry.All()
+	snapshot.Annotations = registry.AllAnnotations()
+	snapshot.Maintenance = lb.ListMaintenance("")
+	snapshot.Timeline = lb.timeline.query(time.Time{}, time.Time{}, nil, "", 0)
+	return snapshot
+}
+
+// applyMirror 用快照替换本地状态，返回本地配置中没有的后端
+func (lb *LoadBalancer) applyMirror(snapshot *MirrorSnapshot) []string {
+	var unknown []string
+	lb.backendsMu.Lock()
+	for _, mirrored := range snapshot.Backends {
+		backend, exists := lb.backends[mirrored.ID]
+		if !exists {
+			unknown = append(unknown, mirrored.ID)
+			continue
+		}
+		backend.IsHealthy = mirrored.IsHealthy
+		backend.InMaintenance = mirrored.InMaintenance
+		backend.Connections = mirrored.Connections
+		backend.ReportedClients = mirrored.ReportedClients
+		backend.MaxClients = mirrored.MaxClients
+		backend.ProtocolVersions = mirrored.ProtocolVersions
+		backend.LastCheck = mirrored.LastCheck
+		backend.LastError = mirrored.LastError
+		backend.ConsecutiveSuccesses = mirrored.ConsecutiveSuccesses
+		backend.ConsecutiveFailures = mirrored.ConsecutiveFailures
+		backend.HoldDownUntil = mirrored.HoldDownUntil
+		backend.probeHistory = mirrored.ProbeHistory
+		backend.drain = nil
+		if drain := mirrored.Drain; drain.Draining && drain.StartedAt != nil {
+			backend.drain = &drainState{startedAt: *drain.StartedAt, initialConnections: drain.InitialConnections}
+			if drain.DrainedAt != nil {
+				backend.drain.drainedAt = *drain.DrainedAt
+			}
+		}
+	}
+	lb.backendsMu.Unlock()
+
+	sessions := snapshot.Sessions
+	if sessions == nil {
+		sessions = make(map[string]*Session)
+	}
+	lb.sessionsMu.Lock()
+	lb.sessions = sessions
+	lb.sessionsMu.Unlock()
+
+	maintenance := make(map[string]*MaintenanceWindow, len(snapshot.Maintenance))
+	for i := range snapshot.Maintenance {
+		window := snapshot.Maintenance[i]
+		maintenance[window.ID] = &window
+	}
+	lb.maintenanceMu.Lock()
+	lb.maintenance = maintenance
+	lb.maintenanceMu.Unlock()
+
+	registry.Mirror(snapshot.Clients, snapshot.Annotations)
+	lb.timeline.replace(snapshot.Timeline)
+	return unknown
+}
+
+// runObserver 定期从主负载均衡器拉取快照
+func (lb *LoadBalancer) runObserver() {
+	o := lb.observer
+	log.Printf("👀 只读观察者模式：每 %v 从主负载均衡器 %s 同步状态", o.interval, o.primary)
+	lb.syncMirror()
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		lb.syncMirror()
+	}
+}
+
+// syncMirror 拉取并应用一次快照，镜像过期或恢复时记录日志
+func (lb *LoadBalancer) syncMirror() {
+	o := lb.observer
+	snapshot, err := o.fetch()
+	var unknown []string
+	if err == nil {
+		unknown = lb.applyMirror(snapshot)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	if err != nil {
+		o.failures++
+		o.consecutiveFailures++
+		o.lastError = err.Error()
+		if !o.stale && now.Sub(o.lastSync) >= o.staleAfter {
+			o.stale = true
+			log.Printf("⚠️ 超过 %v 未能从主负载均衡器同步状态，恢复自行健康检查: %v", o.staleAfter, err)
+		}
+		return
+	}
+	if o.stale {
+		log.Printf("已从主负载均衡器 %s 同步状态（%d 个后端，%d 个会话，%d 个客户端）",
+			o.primary, len(snapshot.Backends), len(snapshot.Sessions), len(snapshot.Clients))
+	}
+	if len(unknown) > 0 && fmt.Sprint(unknown) != fmt.Sprint(o.unknownBackends) {
+		log.Printf("主负载均衡器上的后端 %v 不在本地配置中，未镜像其健康状态", unknown)
+	}
+	o.stale = false
+	o.lastSync = now
+	o.snapshotTime = snapshot.Time
+	o.lastError = ""
+	o.syncs++
+	o.consecutiveFailures = 0
+	o.unknownBackends = unknown
+}
+
+// fetch 请求主负载均衡器的 /api/mirror
+func (o *observerState) fetch() (*MirrorSnapshot, error) {
+	resp, err := o.client.Get(o.primary + "/api/mirror")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("主负载均衡器返回 HTTP %d", resp.StatusCode)
+	}
+	var snapshot MirrorSnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("解析快照失败: %v", err)
+	}
+	return &snapshot, nil
+}
+
+// ObserverStatus 只读观察者的同步状态，不是观察者时只有 enabled=false
+func (lb *LoadBalancer) ObserverStatus() ObserverStatus {
+	o := lb.observer
+	if o == nil {
+		return ObserverStatus{}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	status := ObserverStatus{
+		Enabled:             true,
+		Primary:             o.primary,
+		SyncInterval:        o.interval.String(),
+		LastError:           o.lastError,
+		Syncs:               o.syncs,
+		Failures:            o.failures,
+		ConsecutiveFailures: o.consecutiveFailures,
+		Stale:               o.stale,
+		UnknownBackends:     o.unknownBackends,
+	}
+	if !o.lastSync.IsZero() {
+		lastSync, snapshotTime := o.lastSync, o.snapshotTime
+		status.LastSync = &lastSync
+		status.SnapshotTime = &snapshotTime
+	}
+	return status
+}
+
+// handleMirror 导出状态快照: GET /api/mirror，供只读观察者拉取
+func (lb *LoadBalancer) handleMirror(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "仅支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lb.MirrorSnapshot())
+}
+
+// handleObserver 只读观察者的同步状态: GET /api/observer
+func (lb *LoadBalancer) handleObserver(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "仅支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lb.ObserverStatus())
+}
+
+// observerGuard 只读观察者只放行GET、HEAD和OPTIONS请求（包括转发到后端的仪表盘），
+// 拒绝修改请求和WebSocket连接，客户端和运维操作应使用主负载均衡器
+func (lb *LoadBalancer) observerGuard(next http.Handler) http.Handler {
+	primary := lb.observer.primary
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsWebSocketUpgrade(r) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"type":    "error",
+				"code":    "observer",
+				"message": "只读观察者不接受客户端连接，请连接主负载均衡器",
+				"primary": primary,
+			})
+			return
+		}
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS":
+			next.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"code":    "read_only_observer",
+				"error":   fmt.Sprintf("只读观察者不接受修改，请在主负载均衡器 %s 上操作", primary),
+				"primary": primary,
+			})
+		}
+	})
+}
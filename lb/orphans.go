@@ -0,0 +1,71 @@
+package lb
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 后端被移除时关闭其代理连接使用的关闭码，客户端重连后由负载均衡器分配到其他后端
+const backendRemovedCloseCode = websocket.CloseServiceRestart
+
+// orphanCleanup 移除后端时清理的会话和连接数
+type orphanCleanup struct {
+	Sessions    int `json:"sessions"`    // 删除的会话保持记录
+	Connections int `json:"connections"` // 关闭的代理连接
+}
+
+// backendRemovedCloseMessage 通知客户端后端已移除的关闭帧
+func backendRemovedCloseMessage(id string) []byte {
+	return websocket.FormatCloseMessage(backendRemovedCloseCode, "后端 "+id+" 已移除")
+}
+
+// bindProxyConn 记录代理连接所属的后端，后端在连接建立期间已被移除时返回false，调用方应关闭连接
+func (lb *LoadBalancer) bindProxyConn(conn *websocket.Conn, backend *BackendServer) bool {
+	lb.proxyConnsMu.Lock()
+	defer lb.proxyConnsMu.Unlock()
+	if backend.removed.Load() {
+		return false
+	}
+	lb.proxyConns[conn] = backend
+	return true
+}
+
+// releaseBackend 后端从 lb.backends 中删除后调用：删除指向它的会话保持记录，
+// 并以1012关闭经它转发的代理连接，避免会话和连接继续指向不存在的后端
+func (lb *LoadBalancer) releaseBackend(backend *BackendServer) orphanCleanup {
+	var cleanup orphanCleanup
+	backend.removed.Store(true)
+
+	lb.sessionsMu.Lock()
+	for key, session := range lb.sessions {
+		if session.BackendID == backend.ID {
+			delete(lb.sessions, key)
+			cleanup.Sessions++
+		}
+	}
+	lb.sessionsMu.Unlock()
+
+	// 与bindProxyConn共用锁，正在连接该后端的代理连接要么在这里关闭，要么登记时发现后端已移除
+	closeMsg := backendRemovedCloseMessage(backend.ID)
+	lb.proxyConnsMu.Lock()
+	for conn, owner := range lb.proxyConns {
+		if owner == backend {
+			conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+			conn.Close()
+			cleanup.Connections++
+		}
+	}
+	lb.proxyConnsMu.Unlock()
+
+	// 主动关闭的连接不计入大规模断开检测
+	lb.timeline.disconnectsMu.Lock()
+	delete(lb.timeline.disconnects, backend.ID)
+	lb.timeline.disconnectsMu.Unlock()
+
+	if cleanup.Sessions > 0 || cleanup.Connections > 0 {
+		log.Printf("后端 %s 已移除: 删除 %d 条会话保持记录，关闭 %d 个代理连接", backend.ID, cleanup.Sessions, cleanup.Connections)
+	}
+	return cleanup
+}
@@ -0,0 +1,126 @@
+package lb
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"websocket-loadbalance/protocol"
+)
+
+// PoolHealthCheck 后端池自己的健康检查方式，覆盖全局 health_check 的探测方式、路径和超时，
+// 作用于池中列出的后端。同一个负载均衡器前面的不同服务健康检查接口往往不同（如 /ws/chat 的节点
+// 提供 /health，/ws/telemetry 的节点只能用WebSocket探测）。间隔、阈值和抖动检测仍使用全局设置
+type PoolHealthCheck struct {
+	Protocol string            `json:"protocol" yaml:"protocol"` // http 或 websocket，为空时使用全局设置
+	Path     string            `json:"path" yaml:"path"`         // HTTP探测路径，为空时使用全局设置
+	Timeout  protocol.Duration `json:"timeout" yaml:"timeout"`   // 单次检查超时，0表示使用全局设置
+}
+
+// String 用于日志和变更计划
+func (h *PoolHealthCheck) String() string {
+	if h == nil {
+		return "-"
+	}
+	parts := []string{}
+	if h.Protocol != "" {
+		parts = append(parts, "protocol="+h.Protocol)
+	}
+	if h.Path != "" {
+		parts = append(parts, "path="+h.Path)
+	}
+	if h.Timeout > 0 {
+		parts = append(parts, "timeout="+time.Duration(h.Timeout).String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// validatePoolHealthCheck 校验后端池的健康检查设置
+func validatePoolHealthCheck(cfg PoolConfig) error {
+	h := cfg.HealthCheck
+	if h == nil {
+		return nil
+	}
+	if len(cfg.Backends) == 0 {
+		return fmt.Errorf("后端池 %s 设置了 health_check，必须列出 backends", cfg.Name)
+	}
+	switch h.Protocol {
+	case "", HealthProbeHTTP, HealthProbeWebSocket:
+	default:
+		return fmt.Errorf("后端池 %s 的健康检查方式无效: %s (可选: http, websocket)", cfg.Name, h.Protocol)
+	}
+	if h.Path != "" && !strings.HasPrefix(h.Path, "/") {
+		return fmt.Errorf("后端池 %s 的 health_check.path 必须以 / 开头", cfg.Name)
+	}
+	if h.Timeout < 0 {
+		return fmt.Errorf("后端池 %s 的 health_check.timeout 不能为负数", cfg.Name)
+	}
+	return nil
+}
+
+// checkHealthOverlap 一个后端最多属于一个设置了健康检查的池，否则无法确定它按哪种方式探测
+func checkHealthOverlap(pools []*backendPool) error {
+	owner := make(map[string]string)
+	for _, pool := range pools {
+		if pool.healthCheck == nil {
+			continue
+		}
+		ids := make([]string, 0, len(pool.backendIDs))
+		for id := range pool.backendIDs {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			if other, exists := owner[id]; exists {
+				return fmt.Errorf("后端 %s 同时属于设置了 health_check 的后端池 %s 和 %s", id, other, pool.name)
+			}
+			owner[id] = pool.name
+		}
+	}
+	return nil
+}
+
+// setHealthCheck 设置后端池的健康检查，超时不同于全局设置时使用单独的HTTP客户端
+func (p *backendPool) setHealthCheck(h *PoolHealthCheck) {
+	if h == nil {
+		return
+	}
+	copied := *h
+	p.healthCheck = &copied
+	if h.Timeout > 0 {
+		p.healthClient = &http.Client{Timeout: time.Duration(h.Timeout)}
+	}
+}
+
+// poolHealthProbes 设置了健康检查的后端池中各后端的探测方式（后端ID -> 探测方式），未列出的后端使用base
+func (lb *LoadBalancer) poolHealthProbes(base healthProbe) map[string]healthProbe {
+	lb.poolsMu.RLock()
+	defer lb.poolsMu.RUnlock()
+	var probes map[string]healthProbe
+	for _, pool := range lb.pools {
+		h := pool.healthCheck
+		if h == nil {
+			continue
+		}
+		probe := base
+		if h.Protocol != "" {
+			probe.protocol = h.Protocol
+		}
+		if h.Path != "" {
+			probe.path = h.Path
+		}
+		if h.Timeout > 0 {
+			probe.timeout = time.Duration(h.Timeout)
+			probe.client = pool.healthClient
+		}
+		if probes == nil {
+			probes = make(map[string]healthProbe)
+		}
+		for id := range pool.backendIDs {
+			probes[id] = probe
+		}
+	}
+	return probes
+}
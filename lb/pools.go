@@ -0,0 +1,376 @@
+package lb
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+)
+
+// DefaultPoolName 未匹配任何路由规则的请求使用的后端池
+const DefaultPoolName = "default"
+
+// ClientTypeParam 客户端在握手时声明类型的查询参数，也可以使用 X-Client-Type 请求头
+const ClientTypeParam = "client_type"
+
+// PoolConfig 后端池配置：路径前缀匹配的请求在指定后端中按池自己的策略选择
+type PoolConfig struct {
+	Name       string   `json:"name" yaml:"name"`
+	PathPrefix string   `json:"path_prefix" yaml:"path_prefix"` // 按最长前缀匹配请求路径
+	Strategy   Strategy `json:"strategy" yaml:"strategy"`       // 为空时使用全局策略
+	Backends   []string `json:"backends" yaml:"backends"`       // 后端ID列表，为空表示全部后端
+	Sticky     *bool    `json:"sticky" yaml:"sticky"`           // 是否启用会话保持，默认启用
+	// 只接收声明了该范围内协议版本的WebSocket客户端，为空表示不限制；
+	// 滚动升级协议时可为旧版本客户端配置单独的池，指向尚未升级的后端
+	ProtocolVersions *protocol.VersionRange `json:"protocol_versions" yaml:"protocol_versions"`
+	// 池中后端的健康检查方式，为空表示使用全局的 health_check
+	HealthCheck *PoolHealthCheck `json:"health_check" yaml:"health_check"`
+}
+
+// backendPool 一组后端及其负载均衡策略
+type backendPool struct {
+	name         string
+	pathPrefix   string
+	backendIDs   map[string]bool            // 为空表示全部后端
+	sticky       bool                       // 是否启用会话保持
+	versions     *protocol.VersionRange     // 接收的客户端协议版本，nil表示不限制
+	strategy     atomic.Value               // Strategy，可在运行时修改
+	rrIdx        atomic.Uint64              // 池内独立的轮询位置
+	ring         atomic.Pointer[ketamaRing] // ketama策略最近一次构建的哈希环
+	healthCheck  *PoolHealthCheck           // 池中后端的健康检查方式，nil表示使用全局设置
+	healthClient *http.Client               // 设置了单独的超时时使用的HTTP客户端
+}
+
+func newBackendPool(name, pathPrefix string, strategy Strategy, backendIDs []string) *backendPool {
+	pool := &backendPool{name: name, pathPrefix: pathPrefix, sticky: true}
+	if len(backendIDs) > 0 {
+		pool.backendIDs = make(map[string]bool, len(backendIDs))
+		for _, id := range backendIDs {
+			pool.backendIDs[id] = true
+		}
+	}
+	pool.strategy.Store(strategy)
+	return pool
+}
+
+func (p *backendPool) getStrategy() Strategy {
+	return p.strategy.Load().(Strategy)
+}
+
+// contains 后端是否属于该池
+func (p *backendPool) contains(backendID string) bool {
+	return len(p.backendIDs) == 0 || p.backendIDs[backendID]
+}
+
+// sessionKey 会话按池隔离，同一客户端访问不同池时可以绑定到不同后端
+func (p *backendPool) sessionKey(clientID string) string {
+	if p.name == DefaultPoolName {
+		return clientID
+	}
+	return p.name + "/" + clientID
+}
+
+// pick 按池的策略从可用后端中选择一个
+func (p *backendPool) pick(candidates []*BackendServer, rt route) *BackendServer {
+	clientID := rt.clientID
+	// map遍历顺序不固定，排序后轮询和哈希的结果才稳定
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].ID < candidates[j].ID })
+
+	switch p.getStrategy() {
+	case RoundRobin:
+		return weightedRoundRobin(candidates, p.rrIdx.Add(1)-1)
+	case LeastConn:
+		return leastLoaded(candidates)
+	case ConsistentHash:
+		return rendezvousPick(clientID, candidates)
+	case Ketama:
+		return p.ketamaPick(clientID, candidates)
+	case Affinity:
+		return affinityPick(candidates, rt.affinity)
+	default: // IPHash
+		hash := md5.Sum([]byte(clientID))
+		return candidates[int(hash[0])%len(candidates)]
+	}
+}
+
+// weightedRoundRobin 按权重轮询：每一轮中权重为N的后端被连续选中N次
+func weightedRoundRobin(candidates []*BackendServer, n uint64) *BackendServer {
+	total := 0
+	for _, backend := range candidates {
+		total += backend.weight()
+	}
+	pos := int(n % uint64(total))
+	for _, backend := range candidates {
+		if pos < backend.weight() {
+			return backend
+		}
+		pos -= backend.weight()
+	}
+	return candidates[0]
+}
+
+// weight 后端权重，未设置时为1
+func (b *BackendServer) weight() int {
+	if b.Weight <= 0 {
+		return 1
+	}
+	return b.Weight
+}
+
+// leastLoaded 选择负载最低的后端：所有后端都上报了最大连接数时按使用率比较，否则按连接数/权重比较
+func leastLoaded(candidates []*BackendServer) *BackendServer {
+	byRatio := true
+	for _, backend := range candidates {
+		if backend.MaxClients <= 0 {
+			byRatio = false
+			break
+		}
+	}
+
+	selected := candidates[0]
+	for _, backend := range candidates[1:] {
+		if byRatio {
+			if float64(backend.load())/float64(backend.MaxClients) < float64(selected.load())/float64(selected.MaxClients) {
+				selected = backend
+			}
+		} else if float64(backend.load())/float64(backend.weight()) < float64(selected.load())/float64(selected.weight()) {
+			selected = backend
+		}
+	}
+	return selected
+}
+
+// rendezvousPick 最高随机权重哈希：后端增减时只有落在该后端上的客户端会重新分配
+func rendezvousPick(clientID string, candidates []*BackendServer) *BackendServer {
+	var selected *BackendServer
+	var best uint64
+	for _, backend := range candidates {
+		hash := md5.Sum([]byte(clientID + "\x00" + backend.ID))
+		score := binary.BigEndian.Uint64(hash[:8])
+		if selected == nil || score > best {
+			selected, best = backend, score
+		}
+	}
+	return selected
+}
+
+// valid 是否为支持的负载均衡策略
+func (s Strategy) valid() bool {
+	switch s {
+	case RoundRobin, LeastConn, IPHash, ConsistentHash, Ketama, Affinity:
+		return true
+	}
+	return false
+}
+
+// SetPools 设置按路径路由的后端池（需在AddBackend和SetDiscovery之后、Start之前调用）。
+// 启用服务发现时池可以引用尚未发现的后端
+func (lb *LoadBalancer) SetPools(configs []PoolConfig) error {
+	lb.backendsMu.RLock()
+	defer lb.backendsMu.RUnlock()
+
+	pools := make([]*backendPool, 0, len(configs))
+	for _, cfg := range configs {
+		strategy := cfg.Strategy
+		if strategy == "" {
+			strategy = lb.defaultPool.getStrategy()
+		}
+		for _, id := range cfg.Backends {
+			if _, exists := lb.backends[id]; !exists && lb.discovery == nil {
+				return fmt.Errorf("后端池 %s 引用了不存在的后端: %s", cfg.Name, id)
+			}
+		}
+		if err := validatePoolHealthCheck(cfg); err != nil {
+			return err
+		}
+		pool := newBackendPool(cfg.Name, cfg.PathPrefix, strategy, cfg.Backends)
+		if cfg.Sticky != nil {
+			pool.sticky = *cfg.Sticky
+		}
+		pool.versions = cfg.ProtocolVersions
+		pool.setHealthCheck(cfg.HealthCheck)
+		pools = append(pools, pool)
+		log.Printf("后端池 %s: 路径前缀 %s, 策略 %s, 后端 %v, 会话保持 %v", cfg.Name, cfg.PathPrefix, strategy, cfg.Backends, pool.sticky)
+		if pool.versions != nil {
+			log.Printf("后端池 %s 只接收协议版本 %s 的客户端", cfg.Name, pool.versions)
+		}
+		if pool.healthCheck != nil {
+			log.Printf("后端池 %s 的后端使用单独的健康检查: %s", cfg.Name, pool.healthCheck)
+		}
+	}
+	if err := checkHealthOverlap(pools); err != nil {
+		return err
+	}
+	// 最长前缀优先匹配
+	sort.SliceStable(pools, func(i, j int) bool { return len(pools[i].pathPrefix) > len(pools[j].pathPrefix) })
+	lb.poolsMu.Lock()
+	lb.pools = pools
+	lb.poolsMu.Unlock()
+	return nil
+}
+
+// poolFor 返回请求路径和协议版本匹配的后端池，未匹配时返回默认池。version为0表示不按版本匹配
+func (lb *LoadBalancer) poolFor(path string, version int) *backendPool {
+	lb.poolsMu.RLock()
+	defer lb.poolsMu.RUnlock()
+	for _, pool := range lb.pools {
+		if strings.HasPrefix(path, pool.pathPrefix) && (pool.versions == nil || version <= 0 || pool.versions.Contains(version)) {
+			return pool
+		}
+	}
+	return lb.defaultPool
+}
+
+// route 一次请求的路由结果
+type route struct {
+	pool     *backendPool
+	clientID string            // 会话标识，哈希类策略也按它选择后端
+	sticky   bool              // 是否读取和记录会话保持
+	version  int               // WebSocket客户端声明的协议版本，0表示不按版本选择后端（HTTP请求或版本无效）
+	affinity registry.Selector // WebSocket客户端声明的节点亲和性，nil表示未声明，只有affinity策略使用
+}
+
+// routeFor 按请求路径和协议版本匹配后端池，池或客户端类型关闭了会话保持时只按策略选择后端。
+// 声明的协议版本无效时不按版本选择，由节点在握手时拒绝
+func (lb *LoadBalancer) routeFor(r *http.Request, clientID string) route {
+	var version int
+	var affinity registry.Selector
+	if websocket.IsWebSocketUpgrade(r) {
+		version, _ = protocol.RequestedVersion(r)
+		affinity = requestedAffinity(r)
+	}
+	pool := lb.poolFor(r.URL.Path, version)
+	return route{
+		pool:     pool,
+		clientID: clientID,
+		sticky:   pool.sticky && !lb.nonStickyTypes[clientType(r)],
+		version:  version,
+		affinity: affinity,
+	}
+}
+
+// clientType 客户端在握手时声明的类型
+func clientType(r *http.Request) string {
+	if t := r.URL.Query().Get(ClientTypeParam); t != "" {
+		return t
+	}
+	return r.Header.Get("X-Client-Type")
+}
+
+// SetNonStickyClientTypes 设置不启用会话保持的客户端类型（需在Start之前调用）
+func (lb *LoadBalancer) SetNonStickyClientTypes(types []string) {
+	lb.nonStickyTypes = make(map[string]bool, len(types))
+	for _, t := range types {
+		lb.nonStickyTypes[t] = true
+	}
+}
+
+// findPool 按名称查找后端池
+func (lb *LoadBalancer) findPool(name string) *backendPool {
+	if name == "" || name == DefaultPoolName {
+		return lb.defaultPool
+	}
+	lb.poolsMu.RLock()
+	defer lb.poolsMu.RUnlock()
+	for _, pool := range lb.pools {
+		if pool.name == name {
+			return pool
+		}
+	}
+	return nil
+}
+
+// SetPoolStrategy 运行时修改后端池的负载均衡策略，已有会话保持原来的绑定
+func (lb *LoadBalancer) SetPoolStrategy(name string, strategy Strategy) error {
+	if !strategy.valid() {
+		return fmt.Errorf("无效的负载均衡策略: %s", strategy)
+	}
+	pool := lb.findPool(name)
+	if pool == nil {
+		return fmt.Errorf("后端池不存在: %s", name)
+	}
+	old := pool.getStrategy()
+	pool.strategy.Store(strategy)
+
+	log.Printf("后端池 %s 的负载均衡策略: %s -> %s", pool.name, old, strategy)
+	lb.RecordEvent(EventConfigChange, "", fmt.Sprintf("后端池 %s 的负载均衡策略: %s -> %s", pool.name, old, strategy),
+		map[string]interface{}{"pool": pool.name, "old_strategy": old, "strategy": strategy})
+	return nil
+}
+
+// poolStatus 导出后端池状态
+func (lb *LoadBalancer) poolStatus(pool *backendPool) map[string]interface{} {
+	backends := make([]string, 0)
+	connections := 0
+	lb.backendsMu.RLock()
+	for id, backend := range lb.backends {
+		if pool.contains(id) {
+			backends = append(backends, id)
+			connections += backend.Connections
+		}
+	}
+	lb.backendsMu.RUnlock()
+	sort.Strings(backends)
+
+	return map[string]interface{}{
+		"name":              pool.name,
+		"path_prefix":       pool.pathPrefix,
+		"strategy":          pool.getStrategy(),
+		"sticky":            pool.sticky,
+		"protocol_versions": pool.versions,
+		"health_check":      pool.healthCheck,
+		"backends":          backends,
+		"connections":       connections,
+	}
+}
+
+// handlePools 后端池API
+// GET 列出所有后端池及其策略
+// PUT {"name": "chat", "strategy": "least_conn"} 运行时修改策略，name为空或default表示默认池
+func (lb *LoadBalancer) handlePools(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		lb.poolsMu.RLock()
+		current := append([]*backendPool{lb.defaultPool}, lb.pools...)
+		lb.poolsMu.RUnlock()
+		pools := make([]map[string]interface{}, 0, len(current))
+		for _, pool := range current {
+			pools = append(pools, lb.poolStatus(pool))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"total": len(pools),
+			"pools": pools,
+		})
+	case "PUT", "POST":
+		var req struct {
+			Name     string   `json:"name"`
+			Strategy Strategy `json:"strategy"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "请求格式错误", http.StatusBadRequest)
+			return
+		}
+		if err := lb.SetPoolStrategy(req.Name, req.Strategy); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"pool":    lb.poolStatus(lb.findPool(req.Name)),
+		})
+	default:
+		http.Error(w, "仅支持GET和PUT请求", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,115 @@
+package lb
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"websocket-loadbalance/registry"
+)
+
+// RegistryRebuild 按节点上报重建全局注册表的结果
+type RegistryRebuild struct {
+	Nodes  map[string]int           `json:"nodes"`  // 节点ID -> 上报的在线客户端数
+	Total  int                      `json:"total"`  // 重建的客户端记录数
+	Failed []string                 `json:"failed"` // 查询失败的后端ID
+	Status *registry.RecoveryStatus `json:"recovery,omitempty"`
+}
+
+// rebuildRegistry 向所有健康的后端查询在线客户端（/api/clients），用查询结果替换注册表中各节点的记录。
+// 注册表文件损坏且备份不可用或已过时后，以此恢复仍然连接着的客户端
+func (lb *LoadBalancer) rebuildRegistry() RegistryRebuild {
+	lb.backendsMu.RLock()
+	healthy := make([]*BackendServer, 0, len(lb.backends))
+	for _, backend := range lb.backends {
+		if backend.IsHealthy {
+			healthy = append(healthy, backend)
+		}
+	}
+	lb.backendsMu.RUnlock()
+
+	result := RegistryRebuild{Nodes: make(map[string]int), Failed: []string{}}
+	for _, backend := range healthy {
+		nodeID, clients, err := lb.nodeClients(backend)
+		if err != nil {
+			log.Printf("重建注册表: 查询后端 %s 的客户端失败: %v", backend.ID, err)
+			result.Failed = append(result.Failed, backend.ID)
+			continue
+		}
+		registry.Rebuild(nodeID, clients)
+		result.Nodes[nodeID] = len(clients)
+		result.Total += len(clients)
+	}
+	sort.Strings(result.Failed)
+	result.Status = registry.Recovery()
+	return result
+}
+
+// nodeClients 查询后端当前连接的客户端，转换为注册表记录
+func (lb *LoadBalancer) nodeClients(backend *BackendServer) (string, []registry.ClientInfo, error) {
+	resp, err := backend.endpoint.get(http.DefaultClient, backend.HTTPAddress+"/api/clients")
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	var list struct {
+		NodeID  string                `json:"node_id"`
+		Clients []registry.ClientInfo `json:"clients"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return "", nil, err
+	}
+	if list.NodeID == "" {
+		list.NodeID = backend.ID
+	}
+	// 节点之间按端口互相转发请求，Unix域套接字后端的地址中没有端口
+	var port int
+	if u, err := url.Parse(backend.HTTPAddress); err == nil {
+		port, _ = strconv.Atoi(u.Port())
+	}
+	for i := range list.Clients {
+		list.Clients[i].NodeID = list.NodeID
+		list.Clients[i].NodePort = port
+	}
+	return list.NodeID, list.Clients, nil
+}
+
+// rebuildRegistryOnStart 启动时发现注册表文件损坏，每个健康检查间隔尝试一次重建，
+// 直到有后端成功上报或负载均衡器开始关闭
+func (lb *LoadBalancer) rebuildRegistryOnStart() {
+	for !lb.draining.Load() {
+		time.Sleep(lb.currentHealthInterval())
+		if !registry.NeedsRebuild() {
+			return
+		}
+		result := lb.rebuildRegistry()
+		if len(result.Nodes) == 0 {
+			continue
+		}
+		log.Printf("已按 %d 个节点的上报重建全局注册表，恢复 %d 个在线客户端", len(result.Nodes), result.Total)
+		lb.RecordEvent(EventRegistryRebuilt, "", fmt.Sprintf("注册表文件损坏后按节点上报重建，恢复 %d 个在线客户端", result.Total),
+			map[string]interface{}{"nodes": result.Nodes, "failed": result.Failed})
+		return
+	}
+}
+
+// handleRegistryRebuild 按节点上报重建全局注册表: POST /api/global-clients/rebuild
+func (lb *LoadBalancer) handleRegistryRebuild(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "仅支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+	result := lb.rebuildRegistry()
+	lb.RecordEvent(EventRegistryRebuilt, "", fmt.Sprintf("手动按节点上报重建注册表，恢复 %d 个在线客户端", result.Total),
+		map[string]interface{}{"nodes": result.Nodes, "failed": result.Failed})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
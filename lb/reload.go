@@ -0,0 +1,285 @@
+package lb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// errNoConfigSource 启动时没有指定配置文件
+var errNoConfigSource = errors.New("未指定配置文件，不支持重新加载")
+
+// ReloadResult 重新加载配置的结果
+type ReloadResult struct {
+	Changes         []string `json:"changes"`          // 已生效的变更
+	RestartRequired []string `json:"restart_required"` // 与启动时不同、需要重启才能生效的配置项
+}
+
+// SetConfigSource 设置重新加载时读取配置的函数（需在Start之前调用），通常重新读取启动时的配置文件
+func (lb *LoadBalancer) SetConfigSource(source func() (Config, error)) {
+	lb.configSource = source
+}
+
+// Reload 重新读取配置并在运行时应用，由SIGHUP或 POST /api/reload 触发
+func (lb *LoadBalancer) Reload() (*ReloadResult, error) {
+	if lb.configSource == nil {
+		return nil, errNoConfigSource
+	}
+	cfg, err := lb.configSource()
+	if err != nil {
+		return nil, err
+	}
+	return lb.ApplyConfig(cfg)
+}
+
+// ApplyConfig 在运行时应用配置：全局策略、静态后端及权重、后端池、访问控制、健康检查设置和后端最低构建版本立即生效，
+// 已建立的WebSocket连接不受影响（被移除后端上的连接保持到自然断开）。
+// 这些状态以配置文件为准，此前通过管理API所做的修改会被覆盖；服务发现的后端不受影响。
+// 其余配置需要重启才能生效，与启动时不同的配置项列在结果的 RestartRequired 中。
+// 应用中途出错时返回已生效的变更和错误
+func (lb *LoadBalancer) ApplyConfig(cfg Config) (*ReloadResult, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	lb.reloadMu.Lock()
+	defer lb.reloadMu.Unlock()
+
+	result := &ReloadResult{Changes: []string{}, RestartRequired: lb.restartRequired(cfg)}
+	add := func(message string) {
+		if message != "" {
+			result.Changes = append(result.Changes, message)
+		}
+	}
+
+	if old := lb.defaultPool.getStrategy(); old != cfg.Strategy {
+		if err := lb.SetPoolStrategy(DefaultPoolName, cfg.Strategy); err != nil {
+			return result, err
+		}
+		add(fmt.Sprintf("负载均衡策略: %s -> %s", old, cfg.Strategy))
+	}
+
+	// 先添加和更新后端、后端池，再删除不再需要的后端池和后端（后端可能仍被待删除的池引用）
+	wantedBackends := make(map[string]bool, len(cfg.Backends))
+	for _, backend := range cfg.Backends {
+		wantedBackends[backend.ID] = true
+		_, message, err := lb.putBackend(backend.state(), viaConfig)
+		if err != nil {
+			return result, err
+		}
+		add(message)
+	}
+	wantedPools := make(map[string]bool, len(cfg.Pools))
+	for _, pool := range cfg.Pools {
+		wantedPools[pool.Name] = true
+		if current := lb.findPool(pool.Name); current != nil && samePool(current.config(), pool, cfg.Strategy) {
+			continue
+		}
+		_, message, err := lb.putPool(pool, viaConfig)
+		if err != nil {
+			return result, err
+		}
+		add(message)
+	}
+	current := lb.ClusterState()
+	for _, pool := range current.Pools {
+		if !wantedPools[pool.Name] {
+			message, err := lb.removePool(pool.Name, viaConfig)
+			if err != nil {
+				return result, err
+			}
+			add(message)
+		}
+	}
+	for _, backend := range current.Backends {
+		if !wantedBackends[backend.ID] {
+			_, message, err := lb.removeBackend(backend.ID, viaConfig)
+			if err != nil {
+				return result, err
+			}
+			add(message)
+		}
+	}
+
+	if old := lb.ACL(); !sameACL(old, cfg.ACL) {
+		if err := lb.SetACL(cfg.ACL); err != nil {
+			return result, err
+		}
+		message := fmt.Sprintf("%s更新访问控制规则: allow=%v deny=%v", viaConfig, cfg.ACL.Allow, cfg.ACL.Deny)
+		log.Print(message)
+		lb.RecordEvent(EventConfigChange, "", message, map[string]interface{}{"old": old, "acl": lb.ACL()})
+		add(message)
+	}
+
+	message, err := lb.reloadShadow(cfg.Shadow)
+	if err != nil {
+		return result, err
+	}
+	add(message)
+	add(lb.reloadHealthCheck(cfg.HealthCheck))
+	if old := lb.MinBackendVersion(); old != cfg.MinBackendVersion {
+		if err := lb.SetMinBackendVersion(cfg.MinBackendVersion); err != nil {
+			return result, err
+		}
+		message := fmt.Sprintf("%s更新后端最低构建版本: %q -> %q", viaConfig, old, cfg.MinBackendVersion)
+		log.Print(message)
+		lb.RecordEvent(EventConfigChange, "", message, map[string]interface{}{"old": old, "min_backend_version": cfg.MinBackendVersion})
+		add(message)
+	}
+
+	log.Printf("重新加载配置: %d 项变更", len(result.Changes))
+	for _, item := range result.RestartRequired {
+		log.Printf("⚠️ 配置项 %s 已修改，需要重启负载均衡器才能生效", item)
+	}
+	lb.RecordEvent(EventConfigChange, "", fmt.Sprintf("重新加载配置: %d 项变更", len(result.Changes)),
+		map[string]interface{}{"changes": result.Changes, "restart_required": result.RestartRequired})
+	return result, nil
+}
+
+// restartRequired 与启动时相比有变化、但不能在运行时修改的配置项
+func (lb *LoadBalancer) restartRequired(cfg Config) []string {
+	items := []string{}
+	if lb.startupConfig == nil {
+		return items
+	}
+	old := lb.startupConfig
+	fields := []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"port", old.Port, cfg.Port},
+		{"socket", old.Socket, cfg.Socket},
+		{"listen_address", old.ListenAddress, cfg.ListenAddress},
+		{"address_family", old.AddressFamily, cfg.AddressFamily},
+		{"sessions", old.Sessions, cfg.Sessions},
+		{"proxy_retries", old.ProxyRetries, cfg.ProxyRetries},
+		{"timeline", old.Timeline, cfg.Timeline},
+		{"discovery", old.Discovery, cfg.Discovery},
+		{"self_registration", old.SelfRegistration, cfg.SelfRegistration},
+		{"access_log", old.AccessLog, cfg.AccessLog},
+		{"autocert", old.Autocert, cfg.Autocert},
+		{"tls", old.TLS, cfg.TLS},
+		{"max_message_size", old.MaxMessageSize, cfg.MaxMessageSize},
+		{"observer", old.Observer, cfg.Observer},
+		{"forwarded", old.Forwarded, cfg.Forwarded},
+		{"backend_proxy_protocol", old.BackendProxyProtocol, cfg.BackendProxyProtocol},
+		{"auth_provider", old.AuthProvider, cfg.AuthProvider},
+		{"status", old.Status, cfg.Status},
+	}
+	for _, field := range fields {
+		if !reflect.DeepEqual(field.old, field.new) {
+			items = append(items, field.name)
+		}
+	}
+	return items
+}
+
+// samePool 运行中的后端池与配置是否一致，配置中未填写的策略和会话保持按默认值比较
+func samePool(current, desired PoolConfig, defaultStrategy Strategy) bool {
+	if desired.Strategy == "" {
+		desired.Strategy = defaultStrategy
+	}
+	sticky := desired.Sticky == nil || *desired.Sticky
+	backends := append([]string{}, desired.Backends...)
+	sort.Strings(backends)
+	return current.PathPrefix == desired.PathPrefix &&
+		current.Strategy == desired.Strategy &&
+		*current.Sticky == sticky &&
+		strings.Join(current.Backends, ",") == strings.Join(backends, ",") &&
+		reflect.DeepEqual(current.ProtocolVersions, desired.ProtocolVersions) &&
+		current.HealthCheck.String() == desired.HealthCheck.String()
+}
+
+// sameACL 两组访问控制规则是否相同（nil与空列表视为相同）
+func sameACL(a, b ACLConfig) bool {
+	return strings.Join(a.Allow, ",") == strings.Join(b.Allow, ",") &&
+		strings.Join(a.Deny, ",") == strings.Join(b.Deny, ",")
+}
+
+// healthSettings 健康检查的运行参数，用于比较重新加载前后的变化
+type healthSettings struct {
+	interval, timeout  time.Duration
+	healthy, unhealthy int
+	protocol, path     string
+	historySize        int
+	flapThreshold      float64
+	holdDown           time.Duration
+}
+
+// healthSettingsUnsafe 当前的健康检查参数（调用方持有backendsMu）
+func (lb *LoadBalancer) healthSettingsUnsafe() healthSettings {
+	return healthSettings{
+		interval:      lb.healthInterval,
+		timeout:       lb.healthTimeout,
+		healthy:       lb.healthyThreshold,
+		unhealthy:     lb.unhealthyThreshold,
+		protocol:      lb.healthProtocol,
+		path:          lb.healthPath,
+		historySize:   lb.probeHistorySize,
+		flapThreshold: lb.flapThreshold,
+		holdDown:      lb.holdDown,
+	}
+}
+
+// reloadHealthCheck 在运行时修改健康检查参数，返回描述变更的消息（没有变化时为空）。
+// 健康检查读取这些参数时持有backendsMu，因此这里在持有写锁时复用启动前的设置函数
+func (lb *LoadBalancer) reloadHealthCheck(cfg HealthCheckConfig) string {
+	lb.backendsMu.Lock()
+	before := lb.healthSettingsUnsafe()
+	lb.SetHealthCheck(time.Duration(cfg.Interval), time.Duration(cfg.Timeout))
+	lb.SetHealthThresholds(cfg.HealthyThreshold, cfg.UnhealthyThreshold)
+	lb.SetHealthProbe(cfg.Protocol, cfg.Path)
+	lb.SetFlapDetection(cfg.HistorySize, cfg.FlapThreshold, time.Duration(cfg.HoldDown))
+	after := lb.healthSettingsUnsafe()
+	lb.backendsMu.Unlock()
+
+	if before == after {
+		return ""
+	}
+	if before.interval != after.interval {
+		select {
+		case lb.healthReset <- struct{}{}:
+		default:
+		}
+	}
+	message := fmt.Sprintf("%s更新健康检查: 每 %v 通过 %s 探测（超时 %v），连续失败 %d 次判定不健康，连续成功 %d 次恢复",
+		viaConfig, after.interval, after.protocol, after.timeout, after.unhealthy, after.healthy)
+	log.Print(message)
+	lb.RecordEvent(EventConfigChange, "", message, map[string]interface{}{"health_check": cfg})
+	return message
+}
+
+// handleReload 重新读取配置文件并应用: POST /api/reload
+func (lb *LoadBalancer) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "仅支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+	result, err := lb.Reload()
+	if err != nil {
+		log.Printf("重新加载配置失败: %v", err)
+		status := http.StatusBadRequest
+		if err == errNoConfigSource {
+			status = http.StatusNotImplemented
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		response := map[string]interface{}{"success": false, "error": err.Error()}
+		if result != nil {
+			response["changes"] = result.Changes
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":          true,
+		"changes":          result.Changes,
+		"restart_required": result.RestartRequired,
+	})
+}
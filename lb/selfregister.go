@@ -0,0 +1,179 @@
+package lb
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/secrets"
+)
+
+// SelfRegistrationConfig 节点自注册：节点启动时通过 POST /api/backends 登记为后端，
+// 之后定期重复登记作为心跳，超过ttl未登记的后端被移除。扩容节点不需要修改负载均衡器配置
+type SelfRegistrationConfig struct {
+	Enabled bool              `json:"enabled" yaml:"enabled"`
+	TTL     protocol.Duration `json:"ttl" yaml:"ttl"`     // 租约时长，默认30s，节点按其三分之一的间隔重复登记
+	Token   string            `json:"token" yaml:"token"` // 非空时登记请求需带 Authorization: Bearer <token>，可以是 env:/file:/vault: 引用
+}
+
+// Validate 校验自注册配置
+func (c SelfRegistrationConfig) Validate() error {
+	if c.TTL < 0 {
+		return fmt.Errorf("self_registration.ttl 不能为负数")
+	}
+	if err := secrets.ValidateRef(c.Token); err != nil {
+		return fmt.Errorf("self_registration.token: %v", err)
+	}
+	return nil
+}
+
+// errBackendNotRegistered 同名后端不是自注册的，登记被拒绝
+var errBackendNotRegistered = errors.New("已由配置文件、API或服务发现管理，不能自注册")
+
+// selfRegistration 自注册的租约时长和登记令牌
+type selfRegistration struct {
+	ttl   time.Duration
+	token *secrets.Secret // nil或空值表示不校验
+}
+
+// SetSelfRegistration 允许节点通过 POST /api/backends 自注册（需在Start之前调用），
+// ttl为租约时长（<=0时为30s），token非nil且非空时校验登记请求的Bearer令牌
+func (lb *LoadBalancer) SetSelfRegistration(ttl time.Duration, token *secrets.Secret) {
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	lb.selfRegistration = &selfRegistration{ttl: ttl, token: token}
+}
+
+// authorized 登记请求是否带有正确的令牌
+func (s *selfRegistration) authorized(r *http.Request) bool {
+	if s.token == nil || s.token.Value() == "" {
+		return true
+	}
+	provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(provided), []byte(s.token.Value())) == 1
+}
+
+// RegisterBackend 登记或续约自注册的后端。首次登记、租约过期后重新登记或地址变化时按新后端添加，
+// 其余情况只延长租约并更新权重。与静态配置或服务发现的后端重名时返回错误
+func (lb *LoadBalancer) RegisterBackend(reg protocol.BackendRegistration) (protocol.BackendRegistrationResponse, error) {
+	if lb.selfRegistration == nil {
+		return protocol.BackendRegistrationResponse{}, fmt.Errorf("负载均衡器未启用节点自注册")
+	}
+	ttl := lb.selfRegistration.ttl
+	state := BackendState{ID: reg.ID, Host: reg.Host, Port: reg.Port, Weight: reg.Weight}.normalize()
+	if state.ID == "" || state.Port <= 0 || state.Port > 65535 {
+		return protocol.BackendRegistrationResponse{}, fmt.Errorf("登记信息无效: id=%q port=%d", reg.ID, reg.Port)
+	}
+
+	lb.backendsMu.Lock()
+	backend, exists := lb.backends[state.ID]
+	if exists && !backend.Registered {
+		lb.backendsMu.Unlock()
+		return protocol.BackendRegistrationResponse{}, fmt.Errorf("后端 %s %w", state.ID, errBackendNotRegistered)
+	}
+	endpoint := &backendEndpoint{}
+	endpoint.bind(lb.addressFamily)
+	httpAddr, _ := endpoint.addresses(state.Host, state.Port)
+	var message string
+	switch {
+	case !exists:
+		backend = lb.addBackendUnsafe(state.ID, state.Host, state.Port, state.Weight, endpoint)
+		backend.MaxClients = reg.MaxClients
+		message = fmt.Sprintf("节点自注册添加后端 %s (%s, 权重 %d)", state.ID, httpAddr, state.Weight)
+	case backend.HTTPAddress != httpAddr:
+		previous := backend.HTTPAddress
+		backend = lb.addBackendUnsafe(state.ID, state.Host, state.Port, state.Weight, endpoint)
+		backend.MaxClients = reg.MaxClients
+		message = fmt.Sprintf("节点自注册更新后端 %s 地址: %s -> %s", state.ID, previous, httpAddr)
+	case backend.Weight != state.Weight:
+		log.Printf("节点自注册更新后端 %s 权重: %d -> %d", state.ID, backend.Weight, state.Weight)
+		backend.Weight = state.Weight
+	}
+	backend.Registered = true
+	backend.LeaseExpires = time.Now().Add(ttl)
+	lb.backendsMu.Unlock()
+
+	if message != "" {
+		log.Print(message)
+		lb.RecordEvent(EventBackendAdded, state.ID, message, map[string]interface{}{
+			"address": httpAddr, "weight": state.Weight, "self_registered": true,
+		})
+	}
+	return protocol.BackendRegistrationResponse{
+		Success:           true,
+		ID:                state.ID,
+		Address:           httpAddr,
+		Created:           !exists,
+		TTL:               protocol.Duration(ttl),
+		HeartbeatInterval: protocol.Duration(ttl / 3),
+	}, nil
+}
+
+// expireRegistrations 移除租约已过期的自注册后端，会话保持记录和代理连接随之清理
+func (lb *LoadBalancer) expireRegistrations() {
+	if lb.selfRegistration == nil {
+		return
+	}
+	now := time.Now()
+	var expired []*BackendServer
+	lb.backendsMu.Lock()
+	for id, backend := range lb.backends {
+		if backend.Registered && now.After(backend.LeaseExpires) {
+			delete(lb.backends, id)
+			expired = append(expired, backend)
+		}
+	}
+	lb.backendsMu.Unlock()
+
+	for _, backend := range expired {
+		cleanup := lb.releaseBackend(backend)
+		message := fmt.Sprintf("自注册的后端 %s 超过 %v 未发送心跳，已移除", backend.ID, lb.selfRegistration.ttl)
+		log.Print(message)
+		lb.RecordEvent(EventBackendRemoved, backend.ID, message, map[string]interface{}{
+			"address":            backend.HTTPAddress,
+			"sessions_removed":   cleanup.Sessions,
+			"connections_closed": cleanup.Connections,
+		})
+	}
+}
+
+// handleBackendRegistration POST /api/backends: 节点自注册和心跳，请求体为 protocol.BackendRegistration
+func (lb *LoadBalancer) handleBackendRegistration(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if lb.selfRegistration == nil {
+		http.Error(w, "负载均衡器未启用节点自注册 (self_registration.enabled)", http.StatusForbidden)
+		return
+	}
+	if !lb.selfRegistration.authorized(r) {
+		http.Error(w, "自注册令牌无效", http.StatusUnauthorized)
+		return
+	}
+	var reg protocol.BackendRegistration
+	if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+		http.Error(w, "请求格式错误", http.StatusBadRequest)
+		return
+	}
+	if reg.Host == "" {
+		reg.Host = lb.clientIP(r)
+	}
+	response, err := lb.RegisterBackend(reg)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errBackendNotRegistered) {
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if response.Created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,558 @@
+package lb
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/secrets"
+)
+
+// SessionConfig 会话保持配置
+type SessionConfig struct {
+	TTL             protocol.Duration `json:"ttl" yaml:"ttl"`                           // 会话空闲过期时间
+	CleanupInterval protocol.Duration `json:"cleanup_interval" yaml:"cleanup_interval"` // 过期清理和持久化间隔
+	Store           string            `json:"store" yaml:"store"`                       // none, file, redis
+	File            string            `json:"file" yaml:"file"`                         // store=file 时的文件路径
+	RedisAddr       string            `json:"redis_addr" yaml:"redis_addr"`             // store=redis 时的地址
+	RedisKey        string            `json:"redis_key" yaml:"redis_key"`               // store=redis 时的key
+	RedisPassword   string            `json:"redis_password" yaml:"redis_password"`     // 非空时连接后先AUTH，可以是 env:/file:/vault: 引用
+	RedisDB         int               `json:"redis_db" yaml:"redis_db"`                 // 连接后SELECT的数据库编号
+
+	// 不启用会话保持的客户端类型（握手时通过 client_type 参数或 X-Client-Type 请求头声明）
+	NonStickyClientTypes []string `json:"non_sticky_client_types" yaml:"non_sticky_client_types"`
+
+	// 握手未携带 client_id 参数时，升级后读取客户端的注册消息，按其中的 client_id 保持会话
+	PeekRegistration bool `json:"peek_registration" yaml:"peek_registration"`
+}
+
+// Validate 校验会话保持配置
+func (c SessionConfig) Validate() error {
+	switch c.Store {
+	case "", "none", "file", "redis":
+	default:
+		return fmt.Errorf("无效的会话存储类型: %s (可选: none, file, redis)", c.Store)
+	}
+	if c.RedisDB < 0 {
+		return fmt.Errorf("sessions.redis_db 不能为负数")
+	}
+	if err := secrets.ValidateRef(c.RedisPassword); err != nil {
+		return fmt.Errorf("sessions.redis_password: %v", err)
+	}
+	return nil
+}
+
+// ClientIDParam 握手时携带客户端ID的查询参数。携带时会话按客户端ID保持，
+// 同一个客户端换了地址重连也会回到原来的后端
+const ClientIDParam = "client_id"
+
+// maxSessionClientIDLength 用作会话标识的客户端ID最大长度，超出时忽略
+const maxSessionClientIDLength = 128
+
+// registrationPeekTimeout 等待客户端注册消息的超时
+const registrationPeekTimeout = 5 * time.Second
+
+// clientSessionKey 按客户端ID保持的会话标识，与Cookie或IP+UA生成的标识区分
+func clientSessionKey(clientID string) string {
+	return "client:" + clientID
+}
+
+// SetPeekRegistration 设置是否读取注册消息中的client_id来保持会话（需在Start之前调用）
+func (lb *LoadBalancer) SetPeekRegistration(enabled bool) {
+	lb.peekRegistration = enabled
+}
+
+// peekRegistration 读取客户端的第一条消息（注册消息），返回消息内容以便转发给后端，
+// 以及其中的client_id（无法解析或没有该字段时为空）。二进制帧按客户端协商的编码解码
+func peekRegistration(conn *websocket.Conn, codec protocol.Codec) (messageType int, data []byte, clientID string, err error) {
+	conn.SetReadDeadline(time.Now().Add(registrationPeekTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	messageType, data, err = conn.ReadMessage()
+	if err != nil {
+		return 0, nil, "", err
+	}
+	decoded := data
+	if messageType == websocket.BinaryMessage && codec != nil {
+		decoded, _ = codec.ToJSON(data)
+	} else if messageType != websocket.TextMessage {
+		decoded = nil
+	}
+	if decoded != nil {
+		var registration struct {
+			ClientID string `json:"client_id"`
+		}
+		if json.Unmarshal(decoded, &registration) == nil && len(registration.ClientID) <= maxSessionClientIDLength {
+			clientID = registration.ClientID
+		}
+	}
+	return messageType, data, clientID, nil
+}
+
+// SessionStore 会话持久化存储，负载均衡器重启后可恢复会话保持
+type SessionStore interface {
+	Load() (map[string]*Session, error)
+	Save(sessions map[string]*Session) error
+}
+
+// NewSessionStore 根据配置创建会话存储，store为空或none时返回nil
+func NewSessionStore(cfg SessionConfig) (SessionStore, error) {
+	switch cfg.Store {
+	case "", "none":
+		return nil, nil
+	case "file":
+		if cfg.File == "" {
+			cfg.File = "lb_sessions.json"
+		}
+		return &fileSessionStore{path: cfg.File}, nil
+	case "redis":
+		if cfg.RedisAddr == "" {
+			cfg.RedisAddr = "localhost:6379"
+		}
+		if cfg.RedisKey == "" {
+			cfg.RedisKey = "lb:sessions"
+		}
+		password, err := secrets.Resolve(cfg.RedisPassword)
+		if err != nil {
+			return nil, fmt.Errorf("读取 sessions.redis_password 失败: %v", err)
+		}
+		return &redisSessionStore{addr: cfg.RedisAddr, key: cfg.RedisKey, password: password, db: cfg.RedisDB}, nil
+	default:
+		return nil, fmt.Errorf("无效的会话存储类型: %s", cfg.Store)
+	}
+}
+
+// fileSessionStore 将会话保存为本地JSON文件
+type fileSessionStore struct {
+	path string
+}
+
+func (s *fileSessionStore) Load() (map[string]*Session, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions map[string]*Session
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (s *fileSessionStore) Save(sessions map[string]*Session) error {
+	data, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0644)
+}
+
+// redisSessionStore 将会话整体保存在Redis的一个key中（使用最小化的RESP协议实现）。
+// 连接在命令之间复用，建立连接后先执行AUTH和SELECT；网络出错时关闭连接，下一条命令重新连接
+type redisSessionStore struct {
+	addr     string
+	key      string
+	password *secrets.Secret // nil或空值表示不认证
+	db       int
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (s *redisSessionStore) Load() (map[string]*Session, error) {
+	reply, err := s.do("GET", s.key)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+
+	var sessions map[string]*Session
+	if err := json.Unmarshal(reply, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (s *redisSessionStore) Save(sessions map[string]*Session) error {
+	data, err := json.Marshal(sessions)
+	if err != nil {
+		return err
+	}
+	_, err = s.do("SET", s.key, string(data))
+	return err
+}
+
+// Close 关闭与Redis的连接
+func (s *redisSessionStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeConn()
+	return nil
+}
+
+// redisError Redis返回的错误响应，连接本身仍然可用
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis错误: " + string(e)
+}
+
+// do 执行一条Redis命令，返回bulk string内容（nil表示key不存在）
+func (s *redisSessionStore) do(args ...string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reused := s.conn != nil
+	reply, err := s.exec(args)
+	if err != nil && reused && s.conn == nil {
+		// 复用的连接已失效（如Redis重启或空闲连接被断开），重新连接后重试一次
+		reply, err = s.exec(args)
+	}
+	return reply, err
+}
+
+// exec 在当前连接上执行命令，没有连接时先建立连接；Redis返回错误之外的失败都关闭连接
+func (s *redisSessionStore) exec(args []string) ([]byte, error) {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return nil, err
+		}
+	}
+	s.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reply, err := s.roundTrip(args)
+	if _, ok := err.(redisError); err != nil && !ok {
+		s.closeConn()
+	}
+	return reply, err
+}
+
+// connect 建立连接并按配置认证、选择数据库
+func (s *redisSessionStore) connect() error {
+	conn, err := net.DialTimeout("tcp", s.addr, 3*time.Second)
+	if err != nil {
+		return err
+	}
+	s.conn, s.reader = conn, bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if s.password != nil && s.password.Value() != "" {
+		if _, err := s.roundTrip([]string{"AUTH", s.password.Value()}); err != nil {
+			s.closeConn()
+			return fmt.Errorf("redis认证失败: %v", err)
+		}
+	}
+	if s.db != 0 {
+		if _, err := s.roundTrip([]string{"SELECT", strconv.Itoa(s.db)}); err != nil {
+			s.closeConn()
+			return fmt.Errorf("redis选择数据库 %d 失败: %v", s.db, err)
+		}
+	}
+	return nil
+}
+
+func (s *redisSessionStore) closeConn() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn, s.reader = nil, nil
+	}
+}
+
+// roundTrip 发送命令并读取一个响应
+func (s *redisSessionStore) roundTrip(args []string) ([]byte, error) {
+	var cmd strings.Builder
+	fmt.Fprintf(&cmd, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&cmd, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := s.conn.Write([]byte(cmd.String())); err != nil {
+		return nil, err
+	}
+
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis返回空响应")
+	}
+
+	switch line[0] {
+	case '+', ':':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, redisError(line[1:])
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(s.reader, buf); err != nil {
+			return nil, err
+		}
+		return buf[:size], nil
+	default:
+		return nil, fmt.Errorf("无法解析的redis响应: %s", line)
+	}
+}
+
+// 会话是否已过期
+func (s *Session) expired(ttl time.Duration, now time.Time) bool {
+	return ttl > 0 && now.Sub(s.LastSeen) > ttl
+}
+
+// 加载持久化的会话（丢弃已过期的）
+func (lb *LoadBalancer) loadSessions() {
+	if lb.sessionStore == nil {
+		return
+	}
+
+	sessions, err := lb.sessionStore.Load()
+	if err != nil {
+		log.Printf("加载会话失败: %v", err)
+		return
+	}
+
+	now := time.Now()
+	loaded := 0
+	lb.sessionsMu.Lock()
+	for id, session := range sessions {
+		if session.expired(lb.sessionTTL, now) {
+			continue
+		}
+		lb.sessions[id] = session
+		loaded++
+	}
+	lb.sessionsMu.Unlock()
+
+	log.Printf("从存储恢复了 %d 个会话", loaded)
+}
+
+// 持久化当前会话
+func (lb *LoadBalancer) saveSessions() {
+	if lb.sessionStore == nil {
+		return
+	}
+
+	lb.sessionsMu.RLock()
+	snapshot := make(map[string]*Session, len(lb.sessions))
+	for id, session := range lb.sessions {
+		copied := *session
+		snapshot[id] = &copied
+	}
+	lb.sessionsMu.RUnlock()
+
+	if err := lb.sessionStore.Save(snapshot); err != nil {
+		log.Printf("保存会话失败: %v", err)
+	}
+}
+
+// 清理过期会话
+func (lb *LoadBalancer) cleanupSessions() {
+	now := time.Now()
+	cleaned := 0
+
+	lb.sessionsMu.Lock()
+	for id, session := range lb.sessions {
+		if session.expired(lb.sessionTTL, now) {
+			delete(lb.sessions, id)
+			cleaned++
+		}
+	}
+	lb.sessionsMu.Unlock()
+
+	if cleaned > 0 {
+		log.Printf("清理了 %d 个过期会话", cleaned)
+	}
+}
+
+// 定期清理过期会话并持久化，只读观察者的会话来自主负载均衡器，不写会话存储
+func (lb *LoadBalancer) sessionMaintenance() {
+	ticker := time.NewTicker(lb.sessionCleanupInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		lb.cleanupSessions()
+		if !lb.isObserver() {
+			lb.saveSessions()
+		}
+	}
+}
+
+// SessionList GET /api/sessions 的响应，按会话ID排序
+type SessionList struct {
+	Total    int           `json:"total"`
+	Sessions []SessionInfo `json:"sessions"`
+}
+
+// SessionInfo 会话保持记录及其当前转发中的WebSocket连接
+type SessionInfo struct {
+	Session
+	AgeSeconds  int64 `json:"age_seconds"`  // 会话创建以来的时长
+	IdleSeconds int64 `json:"idle_seconds"` // 距最后一次按该会话选择后端的时长
+	Connections int   `json:"connections"`  // 按该会话转发中的代理连接数
+}
+
+// Sessions 未过期的会话保持记录，backendID非空时只返回绑定到该后端的会话
+func (lb *LoadBalancer) Sessions(backendID string) SessionList {
+	now := time.Now()
+	lb.sessionsMu.RLock()
+	sessions := make([]SessionInfo, 0, len(lb.sessions))
+	for _, session := range lb.sessions {
+		if session.expired(lb.sessionTTL, now) || (backendID != "" && session.BackendID != backendID) {
+			continue
+		}
+		sessions = append(sessions, SessionInfo{
+			Session:     *session,
+			AgeSeconds:  int64(now.Sub(session.CreateTime) / time.Second),
+			IdleSeconds: int64(now.Sub(session.LastSeen) / time.Second),
+		})
+	}
+	lb.sessionsMu.RUnlock()
+	lb.proxyConnsMu.Lock()
+	for i := range sessions {
+		sessions[i].Connections = len(lb.sessionConns[sessions[i].SessionID])
+	}
+	lb.proxyConnsMu.Unlock()
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].SessionID < sessions[j].SessionID })
+	return SessionList{Total: len(sessions), Sessions: sessions}
+}
+
+// trackSessionConn 记录按会话保持转发的代理连接，返回连接结束时调用的注销函数
+func (lb *LoadBalancer) trackSessionConn(sessionID string, conn *websocket.Conn) func() {
+	lb.proxyConnsMu.Lock()
+	defer lb.proxyConnsMu.Unlock()
+	conns := lb.sessionConns[sessionID]
+	if conns == nil {
+		conns = make(map[*websocket.Conn]struct{})
+		lb.sessionConns[sessionID] = conns
+	}
+	conns[conn] = struct{}{}
+	return func() {
+		lb.proxyConnsMu.Lock()
+		defer lb.proxyConnsMu.Unlock()
+		delete(conns, conn)
+		if len(conns) == 0 {
+			delete(lb.sessionConns, sessionID)
+		}
+	}
+}
+
+// SessionReset DELETE /api/sessions/{id} 的结果
+type SessionReset struct {
+	SessionID         string `json:"session_id"`
+	BackendID         string `json:"backend_id,omitempty"` // 删除的会话绑定的后端，会话不存在时为空
+	Removed           bool   `json:"removed"`              // 是否删除了会话保持记录
+	ConnectionsClosed int    `json:"connections_closed"`   // 强制关闭的代理连接数
+}
+
+// 强制断开会话时默认的关闭码：客户端重连后由负载均衡器重新选择后端
+const sessionResetCloseCode = websocket.CloseServiceRestart
+
+// ResetSession 删除会话保持记录，客户端下次连接时按负载均衡策略重新选择后端。
+// disconnect为true时同时以closeCode关闭按该会话转发中的代理连接，closeCode为0时使用1012
+func (lb *LoadBalancer) ResetSession(sessionID string, disconnect bool, closeCode int) SessionReset {
+	result := SessionReset{SessionID: sessionID}
+	lb.sessionsMu.Lock()
+	if session, exists := lb.sessions[sessionID]; exists {
+		result.BackendID = session.BackendID
+		result.Removed = true
+		delete(lb.sessions, sessionID)
+	}
+	lb.sessionsMu.Unlock()
+
+	if disconnect {
+		if closeCode == 0 {
+			closeCode = sessionResetCloseCode
+		}
+		closeMsg := websocket.FormatCloseMessage(closeCode, "会话已被管理员重置")
+		// 发送关闭帧可能阻塞到写超时，先复制连接再在锁外关闭，避免阻塞新的代理连接
+		lb.proxyConnsMu.Lock()
+		conns := make([]*websocket.Conn, 0, len(lb.sessionConns[sessionID]))
+		for conn := range lb.sessionConns[sessionID] {
+			conns = append(conns, conn)
+		}
+		lb.proxyConnsMu.Unlock()
+		for _, conn := range conns {
+			conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+			conn.Close()
+			result.ConnectionsClosed++
+		}
+	}
+	if result.Removed || result.ConnectionsClosed > 0 {
+		log.Printf("会话 %s 已重置: 删除会话保持记录 %v，关闭 %d 个代理连接", sessionID, result.Removed, result.ConnectionsClosed)
+	}
+	return result
+}
+
+// handleSessions GET /api/sessions[?backend=id] 列出会话保持记录
+func (lb *LoadBalancer) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "仅支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lb.Sessions(r.URL.Query().Get("backend")))
+}
+
+// handleSessionDetail DELETE /api/sessions/{id}[?disconnect=true&close_code=1012] 重置会话，
+// 会话和连接都不存在时返回404
+func (lb *LoadBalancer) handleSessionDetail(w http.ResponseWriter, r *http.Request) {
+	sessionID := strings.TrimPrefix(r.URL.Path, "/api/sessions/")
+	if sessionID == "" {
+		lb.handleSessions(w, r)
+		return
+	}
+	if r.Method != "DELETE" {
+		http.Error(w, "仅支持DELETE请求", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	disconnect := query.Get("disconnect") == "true"
+	closeCode := 0
+	if value := query.Get("close_code"); value != "" {
+		code, err := strconv.Atoi(value)
+		if err == nil {
+			err = protocol.ValidateCloseCode(code)
+		}
+		if err != nil {
+			http.Error(w, "无效的 close_code: "+value, http.StatusBadRequest)
+			return
+		}
+		closeCode = code
+	}
+
+	result := lb.ResetSession(sessionID, disconnect, closeCode)
+	if !result.Removed && result.ConnectionsClosed == 0 {
+		http.Error(w, "会话不存在", http.StatusNotFound)
+		return
+	}
+	if result.ConnectionsClosed > 0 {
+		lb.RecordEvent(EventSessionReset, result.BackendID,
+			fmt.Sprintf("手动重置会话 %s，关闭 %d 个代理连接", sessionID, result.ConnectionsClosed),
+			map[string]interface{}{"session_id": sessionID, "connections_closed": result.ConnectionsClosed})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
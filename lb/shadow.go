@@ -0,0 +1,327 @@
+package lb
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/logging"
+	"websocket-loadbalance/protocol"
+)
+
+// ShadowHeader 连接影子后端时附加的请求头，影子节点可据此区分复制的流量
+const ShadowHeader = "X-Shadow-Traffic"
+
+// 每个影子连接默认缓存的待发送消息数
+const defaultShadowQueueSize = 256
+
+// ShadowConfig 影子流量：将一定比例的客户端连接发出的消息单向复制到影子后端池，
+// 影子后端的响应直接丢弃，用于在切换前以真实流量压测新版本的节点
+type ShadowConfig struct {
+	Enabled bool    `json:"enabled" yaml:"enabled"`
+	Percent float64 `json:"percent" yaml:"percent"` // 复制流量的比例(0~100)，按连接抽样，抽中的连接复制全部消息（包括注册消息）
+	// 影子后端，按轮询为抽中的连接分配，不参与负载均衡和健康检查
+	Backends  []BackendConfig `json:"backends" yaml:"backends"`
+	QueueSize int             `json:"queue_size" yaml:"queue_size"` // 每个连接等待发往影子后端的消息数，默认256，影子后端跟不上时丢弃
+}
+
+// Validate 校验影子流量配置
+func (c ShadowConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Percent < 0 || c.Percent > 100 {
+		return fmt.Errorf("shadow.percent 必须在0到100之间")
+	}
+	if len(c.Backends) == 0 {
+		return fmt.Errorf("启用影子流量时至少需要一个影子后端")
+	}
+	if c.QueueSize < 0 {
+		return fmt.Errorf("shadow.queue_size 不能为负数")
+	}
+	seen := make(map[string]bool)
+	for _, backend := range c.Backends {
+		if backend.ID == "" {
+			return fmt.Errorf("影子后端配置无效: id为空")
+		}
+		if err := backend.validateAddress(); err != nil {
+			return fmt.Errorf("影子后端 %s 配置无效: %v", backend.ID, err)
+		}
+		if seen[backend.ID] {
+			return fmt.Errorf("影子后端ID重复: %s", backend.ID)
+		}
+		seen[backend.ID] = true
+		if err := backend.BackendOptions.Validate(); err != nil {
+			return fmt.Errorf("影子后端 %s: %v", backend.ID, err)
+		}
+	}
+	return nil
+}
+
+// ShadowBackendStatus 单个影子后端的复制统计
+type ShadowBackendStatus struct {
+	ID           string `json:"id"`
+	Address      string `json:"address"`
+	Connections  int64  `json:"connections"` // 正在复制的连接数
+	Mirrored     int64  `json:"mirrored"`    // 累计复制的连接数
+	Frames       int64  `json:"frames"`      // 已发给影子后端的消息数
+	Bytes        int64  `json:"bytes"`
+	Dropped      int64  `json:"dropped"`       // 队列满、连接失败或写入失败而丢弃的消息数
+	DialFailures int64  `json:"dial_failures"` // 连接影子后端失败的次数
+}
+
+// ShadowStatus 影子流量的配置和统计，重新加载配置修改影子流量后重新计数
+type ShadowStatus struct {
+	Enabled  bool                  `json:"enabled"`
+	Percent  float64               `json:"percent"`
+	Backends []ShadowBackendStatus `json:"backends"`
+}
+
+// shadowHolder 当前生效的影子后端池，可在运行时整体替换
+type shadowHolder struct {
+	pool atomic.Pointer[shadowPool]
+}
+
+// shadowPool 影子后端池
+type shadowPool struct {
+	cfg       ShadowConfig
+	queueSize int
+	backends  []*shadowBackend
+	next      atomic.Uint64
+	dialer    *websocket.Dialer
+}
+
+// shadowBackend 影子后端及其统计
+type shadowBackend struct {
+	id       string
+	wsAddr   string
+	endpoint *backendEndpoint
+
+	connections  atomic.Int64
+	mirrored     atomic.Int64
+	frames       atomic.Int64
+	bytes        atomic.Int64
+	dropped      atomic.Int64
+	dialFailures atomic.Int64
+}
+
+// shadowFrame 等待复制的一条消息
+type shadowFrame struct {
+	messageType int
+	data        []byte
+}
+
+// shadowConn 一个客户端连接对应的影子连接，方法对nil安全（未抽中的连接为nil）
+type shadowConn struct {
+	backend *shadowBackend
+	frames  chan shadowFrame
+	done    chan struct{}
+}
+
+// SetShadow 设置影子流量，可在运行时调用，新设置只影响之后建立的连接
+func (lb *LoadBalancer) SetShadow(cfg ShadowConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if !cfg.Enabled {
+		lb.shadow.pool.Store(nil)
+		return nil
+	}
+	pool := &shadowPool{
+		cfg:       cfg,
+		queueSize: cfg.QueueSize,
+		dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: 10 * time.Second,
+			WriteBufferPool:  writeBufferPool,
+		},
+	}
+	if pool.queueSize <= 0 {
+		pool.queueSize = defaultShadowQueueSize
+	}
+	for _, backendCfg := range cfg.Backends {
+		state := backendCfg.state()
+		endpoint, err := newBackendEndpoint(state.Host, state.BackendOptions)
+		if err != nil {
+			return fmt.Errorf("影子后端 %s: %v", state.ID, err)
+		}
+		endpoint.bind(lb.addressFamily)
+		_, wsAddr := endpoint.addresses(protocol.TrimHostBrackets(state.Host), state.Port)
+		pool.backends = append(pool.backends, &shadowBackend{id: state.ID, wsAddr: wsAddr, endpoint: endpoint})
+	}
+	lb.shadow.pool.Store(pool)
+	log.Printf("影子流量: 复制 %.4g%% 的连接到 %d 个影子后端", cfg.Percent, len(pool.backends))
+	return nil
+}
+
+// shadowConfig 当前生效的影子流量配置
+func (lb *LoadBalancer) shadowConfig() ShadowConfig {
+	if pool := lb.shadow.pool.Load(); pool != nil {
+		return pool.cfg
+	}
+	return ShadowConfig{}
+}
+
+// reloadShadow 重新加载配置时替换影子流量设置，未变化时返回空字符串
+func (lb *LoadBalancer) reloadShadow(cfg ShadowConfig) (string, error) {
+	old := lb.shadowConfig()
+	if !old.Enabled && !cfg.Enabled || reflect.DeepEqual(old, cfg) {
+		return "", nil
+	}
+	if err := lb.SetShadow(cfg); err != nil {
+		return "", err
+	}
+	message := fmt.Sprintf("%s更新影子流量: %.4g%% -> %.4g%%", viaConfig, old.Percent, cfg.Percent)
+	if !cfg.Enabled {
+		message = viaConfig + "停用影子流量"
+	}
+	log.Print(message)
+	lb.RecordEvent(EventConfigChange, "", message, map[string]interface{}{"shadow": cfg})
+	return message, nil
+}
+
+// attachShadow 按比例抽样客户端连接，抽中时异步连接影子后端并返回影子连接，否则返回nil。
+// 握手的查询参数和子协议与连接主后端时相同
+func (lb *LoadBalancer) attachShadow(r *http.Request) *shadowConn {
+	pool := lb.shadow.pool.Load()
+	if pool == nil || len(pool.backends) == 0 || rand.Float64()*100 >= pool.cfg.Percent {
+		return nil
+	}
+	backend := pool.backends[(pool.next.Add(1)-1)%uint64(len(pool.backends))]
+	header := http.Header{ShadowHeader: {"1"}}
+	if protocols := r.Header.Values("Sec-WebSocket-Protocol"); len(protocols) > 0 {
+		header["Sec-WebSocket-Protocol"] = protocols
+	}
+	lb.forwarded.SetUpgradeHeaders(header, r)
+	url := backend.wsAddr
+	if r.URL.RawQuery != "" {
+		url += "?" + r.URL.RawQuery
+	}
+	c := &shadowConn{
+		backend: backend,
+		frames:  make(chan shadowFrame, pool.queueSize),
+		done:    make(chan struct{}),
+	}
+	backend.connections.Add(1)
+	backend.mirrored.Add(1)
+	go c.run(pool.dialer, url, header)
+	return c
+}
+
+// send 复制一条消息，队列满时丢弃，不阻塞主连接的转发
+func (c *shadowConn) send(messageType int, data []byte) {
+	if c == nil {
+		return
+	}
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	select {
+	case c.frames <- shadowFrame{messageType: messageType, data: data}:
+	default:
+		c.backend.dropped.Add(1)
+	}
+}
+
+// close 客户端连接结束时关闭影子连接
+func (c *shadowConn) close() {
+	if c == nil {
+		return
+	}
+	close(c.done)
+}
+
+// run 连接影子后端并写出队列中的消息，影子后端发来的消息全部丢弃
+func (c *shadowConn) run(dialer *websocket.Dialer, url string, header http.Header) {
+	defer c.backend.connections.Add(-1)
+	conn, _, err := c.backend.endpoint.dial(dialer, url, header, nil)
+	if err != nil {
+		c.backend.dialFailures.Add(1)
+		logging.Debugf("连接影子后端 %s 失败: %v", c.backend.id, err)
+		c.discard()
+		return
+	}
+	defer conn.Close()
+
+	// 读取并丢弃影子后端的消息，同时处理ping等控制帧
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case frame := <-c.frames:
+			if err := conn.WriteMessage(frame.messageType, frame.data); err != nil {
+				c.backend.dropped.Add(1)
+				logging.Debugf("写入影子后端 %s 失败: %v", c.backend.id, err)
+				c.discard()
+				return
+			}
+			c.backend.frames.Add(1)
+			c.backend.bytes.Add(int64(len(frame.data)))
+		case <-c.done:
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			return
+		}
+	}
+}
+
+// discard 影子连接不可用时丢弃剩余的消息，直到客户端连接结束
+func (c *shadowConn) discard() {
+	for {
+		select {
+		case <-c.frames:
+			c.backend.dropped.Add(1)
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// ShadowStatus 影子流量的配置和统计
+func (lb *LoadBalancer) ShadowStatus() ShadowStatus {
+	pool := lb.shadow.pool.Load()
+	status := ShadowStatus{Backends: []ShadowBackendStatus{}}
+	if pool == nil {
+		return status
+	}
+	status.Enabled = true
+	status.Percent = pool.cfg.Percent
+	for _, backend := range pool.backends {
+		status.Backends = append(status.Backends, ShadowBackendStatus{
+			ID:           backend.id,
+			Address:      backend.wsAddr,
+			Connections:  backend.connections.Load(),
+			Mirrored:     backend.mirrored.Load(),
+			Frames:       backend.frames.Load(),
+			Bytes:        backend.bytes.Load(),
+			Dropped:      backend.dropped.Load(),
+			DialFailures: backend.dialFailures.Load(),
+		})
+	}
+	return status
+}
+
+// handleShadow 影子流量的配置和统计: GET /api/shadow
+func (lb *LoadBalancer) handleShadow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "仅支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lb.ShadowStatus())
+}
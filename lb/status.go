@@ -0,0 +1,397 @@
+package lb
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+)
+
+// 集群及各组成部分的状态
+const (
+	StatusOperational = "operational" // 正常
+	StatusDegraded    = "degraded"    // 部分降级，仍可提供服务
+	StatusOutage      = "outage"      // 不可用
+)
+
+// 健康分数中各部分的权重，合计为1
+const (
+	statusWeightBackends    = 0.5
+	statusWeightConnections = 0.3
+	statusWeightRegistry    = 0.2
+)
+
+// statusBuckets 连接错误率的统计窗口划分的桶数
+const statusBuckets = 30
+
+// StatusConfig 集群健康状态的计算参数，状态见 /api/status 和 /status
+type StatusConfig struct {
+	Title              string            `json:"title" yaml:"title"`                               // 状态页标题
+	ErrorWindow        protocol.Duration `json:"error_window" yaml:"error_window"`                 // 统计连接错误率的时间窗口，默认5m
+	ErrorRateThreshold float64           `json:"error_rate_threshold" yaml:"error_rate_threshold"` // 错误率达到该值视为降级(0~1)，默认0.05
+	MaxRegistryLag     protocol.Duration `json:"max_registry_lag" yaml:"max_registry_lag"`         // 注册表与节点不一致超过该时长视为降级，默认30s
+}
+
+// Validate 校验状态页配置
+func (c StatusConfig) Validate() error {
+	if c.ErrorWindow < 0 {
+		return fmt.Errorf("status.error_window 不能为负数")
+	}
+	if c.ErrorRateThreshold < 0 || c.ErrorRateThreshold > 1 {
+		return fmt.Errorf("status.error_rate_threshold 必须在 0~1 之间")
+	}
+	if c.MaxRegistryLag < 0 {
+		return fmt.Errorf("status.max_registry_lag 不能为负数")
+	}
+	return nil
+}
+
+// ClusterStatus 集群健康汇总，供状态页和外部可用性监控使用
+type ClusterStatus struct {
+	Title      string           `json:"title"`
+	Status     string           `json:"status"` // operational、degraded 或 outage
+	Score      float64          `json:"score"`  // 0~100，各部分得分的加权和
+	Timestamp  time.Time        `json:"timestamp"`
+	Since      time.Time        `json:"since"` // 进入当前状态的时间
+	Components StatusComponents `json:"components"`
+}
+
+// StatusComponents 参与计算健康分数的各部分
+type StatusComponents struct {
+	Backends    BackendsStatus    `json:"backends"`
+	Connections ConnectionsStatus `json:"connections"`
+	Registry    RegistryStatus    `json:"registry"`
+}
+
+// BackendsStatus 健康后端占比，维护中的后端不计入
+type BackendsStatus struct {
+	Status        string  `json:"status"`
+	Healthy       int     `json:"healthy"`
+	Total         int     `json:"total"`
+	InMaintenance int     `json:"in_maintenance"`
+	Ratio         float64 `json:"ratio"`
+}
+
+// ConnectionsStatus 最近窗口内WebSocket连接建立失败（无可用后端、连接后端失败）的比例
+type ConnectionsStatus struct {
+	Status        string  `json:"status"`
+	Attempts      int64   `json:"attempts"`
+	Failures      int64   `json:"failures"`
+	ErrorRate     float64 `json:"error_rate"`
+	WindowSeconds float64 `json:"window_seconds"`
+}
+
+// RegistryStatus 全局注册表与节点实际连接的一致程度。
+// 注册表中在线的客户端数与各后端 /health 上报的连接数不一致时开始计算滞后时长，
+// 只读观察者的滞后时长还包括镜像快照的年龄
+type RegistryStatus struct {
+	Status            string  `json:"status"`
+	RegisteredClients int     `json:"registered_clients"` // 注册表中在线、所在节点为已知后端的客户端
+	ReportedClients   int     `json:"reported_clients"`   // 健康后端上报的连接数之和
+	MismatchedClients int     `json:"mismatched_clients"` // 按后端累计的差值
+	LagSeconds        float64 `json:"lag_seconds"`
+}
+
+// statusBucket 一个时间片内的连接尝试和失败次数
+type statusBucket struct {
+	slot     int64
+	attempts int64
+	failures int64
+}
+
+// statusBackend 计算状态时的后端快照
+type statusBackend struct {
+	healthy, maintenance bool
+	reported             int // 后端 /health 上报的连接数
+}
+
+// statusState 连接错误的滑动窗口和上次计算的状态
+type statusState struct {
+	cfg        StatusConfig
+	bucketSize time.Duration
+
+	mu              sync.Mutex
+	buckets         [statusBuckets]statusBucket
+	mismatchSince   time.Time // 注册表开始与节点不一致的时间，一致时为零值
+	lastStatus      string
+	lastStatusSince time.Time
+}
+
+func newStatusState(cfg StatusConfig) *statusState {
+	if cfg.Title == "" {
+		cfg.Title = "WebSocket 集群状态"
+	}
+	if cfg.ErrorWindow <= 0 {
+		cfg.ErrorWindow = protocol.Duration(5 * time.Minute)
+	}
+	if cfg.ErrorRateThreshold <= 0 {
+		cfg.ErrorRateThreshold = 0.05
+	}
+	if cfg.MaxRegistryLag <= 0 {
+		cfg.MaxRegistryLag = protocol.Duration(30 * time.Second)
+	}
+	bucketSize := time.Duration(cfg.ErrorWindow) / statusBuckets
+	if bucketSize < time.Millisecond {
+		bucketSize = time.Millisecond
+	}
+	return &statusState{cfg: cfg, bucketSize: bucketSize}
+}
+
+// SetStatus 设置集群健康状态的计算参数（需在Start之前调用）
+func (lb *LoadBalancer) SetStatus(cfg StatusConfig) {
+	lb.status = newStatusState(cfg)
+}
+
+// recordConnection 记录一次WebSocket连接的建立结果
+func (s *statusState) recordConnection(failed bool) {
+	slot := time.Now().UnixNano() / int64(s.bucketSize)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bucket := &s.buckets[slot%statusBuckets]
+	if bucket.slot != slot {
+		*bucket = statusBucket{slot: slot}
+	}
+	bucket.attempts++
+	if failed {
+		bucket.failures++
+	}
+}
+
+// connections 统计窗口内的连接尝试和失败次数
+func (s *statusState) connections(now time.Time) ConnectionsStatus {
+	current := now.UnixNano() / int64(s.bucketSize)
+	result := ConnectionsStatus{WindowSeconds: time.Duration(s.cfg.ErrorWindow).Seconds()}
+	s.mu.Lock()
+	for _, bucket := range s.buckets {
+		if current-bucket.slot < statusBuckets {
+			result.Attempts += bucket.attempts
+			result.Failures += bucket.failures
+		}
+	}
+	s.mu.Unlock()
+	if result.Attempts > 0 {
+		result.ErrorRate = float64(result.Failures) / float64(result.Attempts)
+	}
+	switch {
+	case result.Attempts > 0 && result.ErrorRate >= 0.5:
+		result.Status = StatusOutage
+	case result.Attempts > 0 && result.ErrorRate >= s.cfg.ErrorRateThreshold:
+		result.Status = StatusDegraded
+	default:
+		result.Status = StatusOperational
+	}
+	return result
+}
+
+// registryLag 根据本次是否一致更新不一致的起始时间，返回已持续的时长
+func (s *statusState) registryLag(mismatched bool, now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !mismatched {
+		s.mismatchSince = time.Time{}
+		return 0
+	}
+	if s.mismatchSince.IsZero() {
+		s.mismatchSince = now
+	}
+	return now.Sub(s.mismatchSince)
+}
+
+// transition 记录状态变化，返回进入当前状态的时间和变化前的状态（未变化时为空）
+func (s *statusState) transition(status string, now time.Time) (time.Time, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastStatus == status {
+		return s.lastStatusSince, ""
+	}
+	previous := s.lastStatus
+	s.lastStatus, s.lastStatusSince = status, now
+	return now, previous
+}
+
+// ClusterStatus 计算集群健康汇总：健康后端占比、连接错误率和注册表滞后。
+// 没有健康后端时为 outage，任一部分不正常时为 degraded
+func (lb *LoadBalancer) ClusterStatus() *ClusterStatus {
+	now := time.Now()
+	status := &ClusterStatus{Title: lb.status.cfg.Title, Timestamp: now}
+
+	// 在锁内取后端快照，注册表在释放锁之后读取
+	lb.backendsMu.RLock()
+	backends := make(map[string]statusBackend, len(lb.backends))
+	for id, backend := range lb.backends {
+		backends[id] = statusBackend{backend.IsHealthy, backend.InMaintenance, backend.ReportedClients}
+	}
+	lb.backendsMu.RUnlock()
+
+	b := &status.Components.Backends
+	for _, backend := range backends {
+		if backend.maintenance {
+			b.InMaintenance++
+			continue
+		}
+		b.Total++
+		if backend.healthy {
+			b.Healthy++
+		}
+	}
+	switch {
+	case b.Healthy == 0:
+		b.Status = StatusOutage
+	case b.Healthy < b.Total:
+		b.Status = StatusDegraded
+	default:
+		b.Status = StatusOperational
+	}
+	if b.Total > 0 {
+		b.Ratio = float64(b.Healthy) / float64(b.Total)
+	}
+
+	status.Components.Connections = lb.status.connections(now)
+	status.Components.Registry = lb.registryStatus(backends, now)
+
+	c, r := status.Components.Connections, status.Components.Registry
+	registryScore := 1.0
+	if r.Status != StatusOperational {
+		registryScore = 1 - math.Min(1, float64(r.MismatchedClients)/math.Max(1, float64(max(r.RegisteredClients, r.ReportedClients))))
+	}
+	score := statusWeightBackends*b.Ratio + statusWeightConnections*(1-c.ErrorRate) + statusWeightRegistry*registryScore
+	status.Score = math.Round(score*1000) / 10
+
+	switch {
+	case b.Status == StatusOutage:
+		status.Status = StatusOutage
+	case b.Status != StatusOperational || c.Status != StatusOperational || r.Status != StatusOperational:
+		status.Status = StatusDegraded
+	default:
+		status.Status = StatusOperational
+	}
+	since, previous := lb.status.transition(status.Status, now)
+	status.Since = since
+	if previous != "" {
+		message := fmt.Sprintf("集群状态: %s -> %s (健康分数 %.1f)", previous, status.Status, status.Score)
+		log.Print(message)
+		lb.RecordEvent(EventClusterStatus, "", message, map[string]interface{}{"status": status.Status, "previous": previous, "score": status.Score})
+	}
+	return status
+}
+
+// registryStatus 比较注册表中各节点的在线客户端数与后端上报的连接数，不健康的后端按0个连接计算
+func (lb *LoadBalancer) registryStatus(backends map[string]statusBackend, now time.Time) RegistryStatus {
+	registered := make(map[string]int, len(backends))
+	for _, client := range registry.All() {
+		if _, ok := backends[client.NodeID]; ok && client.Status != registry.StatusOffline {
+			registered[client.NodeID]++
+		}
+	}
+	var r RegistryStatus
+	for id, backend := range backends {
+		reported := 0
+		if backend.healthy {
+			reported = backend.reported
+		}
+		r.RegisteredClients += registered[id]
+		r.ReportedClients += reported
+		if registered[id] > reported {
+			r.MismatchedClients += registered[id] - reported
+		} else {
+			r.MismatchedClients += reported - registered[id]
+		}
+	}
+	lag := lb.status.registryLag(r.MismatchedClients > 0, now)
+	synced := true
+	if observer := lb.ObserverStatus(); observer.Enabled {
+		// 尚未同步过的观察者没有可信的注册表
+		synced = observer.SnapshotTime != nil
+		if synced && now.Sub(*observer.SnapshotTime) > lag {
+			lag = now.Sub(*observer.SnapshotTime)
+		}
+	}
+	r.LagSeconds = math.Round(lag.Seconds()*10) / 10
+	r.Status = StatusOperational
+	if !synced || lag > time.Duration(lb.status.cfg.MaxRegistryLag) {
+		r.Status = StatusDegraded
+	}
+	return r
+}
+
+// handleStatus 集群健康状态: GET /api/status，供外部监控和仪表盘嵌入
+func (lb *LoadBalancer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "仅支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(lb.ClusterStatus())
+}
+
+// handleStatusPage 公开的集群状态页: GET /status，不显示后端地址和客户端信息。
+// 集群不可用时返回503，便于只检查HTTP状态码的可用性监控
+func (lb *LoadBalancer) handleStatusPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "仅支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	status := lb.ClusterStatus()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if status.Status == StatusOutage {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if r.Method == "HEAD" {
+		return
+	}
+	if err := statusPage.Execute(w, status); err != nil {
+		log.Printf("输出状态页失败: %v", err)
+	}
+}
+
+var statusPage = template.Must(template.New("status").Funcs(template.FuncMap{
+	"label": func(status string) string {
+		switch status {
+		case StatusOperational:
+			return "正常"
+		case StatusDegraded:
+			return "部分降级"
+		}
+		return "不可用"
+	},
+	"percent": func(ratio float64) string { return fmt.Sprintf("%.1f%%", ratio*100) },
+	"time":    func(t time.Time) string { return t.Local().Format("2006-01-02 15:04:05") },
+}).Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="30">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", "PingFang SC", sans-serif; max-width: 720px; margin: 40px auto; padding: 0 16px; color: #222; }
+.banner { padding: 16px 20px; border-radius: 6px; color: #fff; font-size: 20px; }
+.operational { background: #2e9d4f; } .degraded { background: #e0a100; } .outage { background: #d33c3c; }
+table { width: 100%; border-collapse: collapse; margin-top: 24px; }
+td { padding: 10px 4px; border-bottom: 1px solid #eee; }
+td.state { text-align: right; font-weight: 600; }
+.dot { display: inline-block; width: 10px; height: 10px; border-radius: 50%; margin-right: 6px; }
+footer { margin-top: 24px; color: #888; font-size: 13px; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="banner {{.Status}}">{{label .Status}} · 健康分数 {{printf "%.1f" .Score}}</div>
+<table>
+{{with .Components.Backends}}<tr><td>后端节点</td><td>{{.Healthy}}/{{.Total}} 健康{{if .InMaintenance}}，{{.InMaintenance}} 个维护中{{end}}</td><td class="state"><span class="dot {{.Status}}"></span>{{label .Status}}</td></tr>{{end}}
+{{with .Components.Connections}}<tr><td>连接建立</td><td>错误率 {{percent .ErrorRate}}（最近 {{printf "%.0f" .WindowSeconds}} 秒 {{.Attempts}} 次）</td><td class="state"><span class="dot {{.Status}}"></span>{{label .Status}}</td></tr>{{end}}
+{{with .Components.Registry}}<tr><td>客户端注册表</td><td>滞后 {{printf "%.1f" .LagSeconds}} 秒</td><td class="state"><span class="dot {{.Status}}"></span>{{label .Status}}</td></tr>{{end}}
+</table>
+<footer>自 {{time .Since}} 起为当前状态 · 更新于 {{time .Timestamp}} · <a href="/api/status">JSON</a></footer>
+</body>
+</html>
+`))
@@ -0,0 +1,317 @@
+package lb
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"websocket-loadbalance/protocol"
+)
+
+// 集群时间线事件类型
+const (
+	EventLBStart              = "lb_start"              // 负载均衡器启动
+	EventLBDrain              = "lb_drain"              // 负载均衡器开始优雅关闭
+	EventBackendUp            = "backend_up"            // 后端恢复健康
+	EventBackendDown          = "backend_down"          // 后端变为不健康
+	EventBackendFlapping      = "backend_flapping"      // 后端健康状态抖动，进入抑制期
+	EventMaintenanceScheduled = "maintenance_scheduled" // 计划维护窗口
+	EventMaintenanceStarted   = "maintenance_started"   // 后端进入维护，开始排空
+	EventMaintenanceEnded     = "maintenance_ended"     // 后端维护结束
+	EventMaintenanceCancelled = "maintenance_cancelled" // 取消维护窗口
+	EventMassDisconnect       = "mass_disconnect"       // 短时间内大量连接断开
+	EventConfigChange         = "config_change"         // 配置变更（由运维工具通过API上报）
+	EventBackendAdded         = "backend_added"         // 服务发现或API添加、更新后端
+	EventBackendRemoved       = "backend_removed"       // 服务发现或API移除后端
+	EventBackendDraining      = "backend_draining"      // 后端开始排空
+	EventBackendDrained       = "backend_drained"       // 后端连接已全部断开
+	EventBackendUndrained     = "backend_undrained"     // 后端结束排空
+	EventCertificateIssued    = "certificate_issued"    // 自动证书签发或续期
+	EventCertificateReloaded  = "certificate_reloaded"  // 证书文件变化后重新加载
+	EventEmergencyStop        = "emergency_stop"        // 紧急停止，关闭所有连接并暂停接受新连接
+	EventEmergencyLifted      = "emergency_lifted"      // 解除紧急停止
+	EventClusterStatus        = "cluster_status"        // 集群健康状态变化（查询状态时发现）
+	EventVersionSkew          = "version_skew"          // 健康后端的构建版本出现分歧，或分歧中的版本集合变化
+	EventVersionSkewResolved  = "version_skew_resolved" // 健康后端的构建版本恢复一致
+	EventBackendOutdated      = "backend_outdated"      // 后端的构建版本低于 min_backend_version
+	EventRegistryRebuilt      = "registry_rebuilt"      // 注册表文件损坏后按节点上报重建
+	EventSessionReset         = "session_reset"         // 手动重置会话并强制关闭其代理连接
+)
+
+// TimelineConfig 集群时间线配置
+type TimelineConfig struct {
+	Capacity                int               `json:"capacity" yaml:"capacity"`                                   // 保留的最大事件数
+	MassDisconnectThreshold int               `json:"mass_disconnect_threshold" yaml:"mass_disconnect_threshold"` // 窗口内同一后端断开数达到该值记为大规模断开
+	MassDisconnectWindow    protocol.Duration `json:"mass_disconnect_window" yaml:"mass_disconnect_window"`
+}
+
+// TimelineEvent 集群时间线中的一条事件
+type TimelineEvent struct {
+	Time      time.Time              `json:"time"`
+	Type      string                 `json:"type"`
+	Source    string                 `json:"source"` // 事件来源：loadbalancer 或上报方
+	BackendID string                 `json:"backend_id,omitempty"`
+	Message   string                 `json:"message"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// timeline 按时间顺序保存最近的事件，超出容量时丢弃最早的事件
+type timeline struct {
+	events   []TimelineEvent
+	capacity int
+	mu       sync.RWMutex
+
+	massThreshold int
+	massWindow    time.Duration
+	disconnects   map[string]*disconnectWindow // 按后端统计的断开数
+	disconnectsMu sync.Mutex
+}
+
+// disconnectWindow 单个后端在当前统计窗口内的断开数
+type disconnectWindow struct {
+	start    time.Time
+	count    int
+	reported bool
+}
+
+func newTimeline(cfg TimelineConfig) *timeline {
+	if cfg.Capacity <= 0 {
+		cfg.Capacity = 1000
+	}
+	if cfg.MassDisconnectThreshold <= 0 {
+		cfg.MassDisconnectThreshold = 50
+	}
+	if cfg.MassDisconnectWindow <= 0 {
+		cfg.MassDisconnectWindow = protocol.Duration(10 * time.Second)
+	}
+	return &timeline{
+		capacity:      cfg.Capacity,
+		massThreshold: cfg.MassDisconnectThreshold,
+		massWindow:    time.Duration(cfg.MassDisconnectWindow),
+		disconnects:   make(map[string]*disconnectWindow),
+	}
+}
+
+// add 追加一条事件
+func (t *timeline) add(event TimelineEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.Source == "" {
+		event.Source = "loadbalancer"
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// 上报的事件可能带有较早的时间，插入到正确位置以保持时间顺序
+	i := len(t.events)
+	for i > 0 && t.events[i-1].Time.After(event.Time) {
+		i--
+	}
+	t.events = append(t.events, TimelineEvent{})
+	copy(t.events[i+1:], t.events[i:])
+	t.events[i] = event
+
+	if len(t.events) > t.capacity {
+		t.events = append(t.events[:0:0], t.events[len(t.events)-t.capacity:]...)
+	}
+}
+
+// query 返回 [from, to] 范围内的事件，types 为空表示全部类型，limit>0 时只保留最近的limit条
+func (t *timeline) query(from, to time.Time, types map[string]bool, backendID string, limit int) []TimelineEvent {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	result := make([]TimelineEvent, 0)
+	for _, event := range t.events {
+		if !from.IsZero() && event.Time.Before(from) {
+			continue
+		}
+		if !to.IsZero() && event.Time.After(to) {
+			continue
+		}
+		if len(types) > 0 && !types[event.Type] {
+			continue
+		}
+		if backendID != "" && event.BackendID != backendID {
+			continue
+		}
+		result = append(result, event)
+	}
+	if limit > 0 && len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return result
+}
+
+// replace 用镜像的事件替换全部事件，超出容量时保留最近的事件
+func (t *timeline) replace(events []TimelineEvent) {
+	if len(events) > t.capacity {
+		events = events[len(events)-t.capacity:]
+	}
+	t.mu.Lock()
+	t.events = append([]TimelineEvent(nil), events...)
+	t.mu.Unlock()
+}
+
+// recordDisconnect 统计后端的连接断开，窗口内达到阈值时返回断开数（每个窗口只报告一次）
+func (t *timeline) recordDisconnect(backendID string) (int, bool) {
+	now := time.Now()
+	t.disconnectsMu.Lock()
+	defer t.disconnectsMu.Unlock()
+
+	window := t.disconnects[backendID]
+	if window == nil || now.Sub(window.start) > t.massWindow {
+		window = &disconnectWindow{start: now}
+		t.disconnects[backendID] = window
+	}
+	window.count++
+	if window.count >= t.massThreshold && !window.reported {
+		window.reported = true
+		return window.count, true
+	}
+	return 0, false
+}
+
+// SetTimeline 设置集群时间线容量和大规模断开检测参数（需在Start之前调用）
+func (lb *LoadBalancer) SetTimeline(cfg TimelineConfig) {
+	lb.timeline = newTimeline(cfg)
+}
+
+// RecordEvent 向集群时间线追加一条事件
+func (lb *LoadBalancer) RecordEvent(eventType, backendID, message string, details map[string]interface{}) {
+	lb.timeline.add(TimelineEvent{
+		Type:      eventType,
+		BackendID: backendID,
+		Message:   message,
+		Details:   details,
+	})
+}
+
+// Timeline 查询集群时间线
+func (lb *LoadBalancer) Timeline(from, to time.Time) []TimelineEvent {
+	return lb.timeline.query(from, to, nil, "", 0)
+}
+
+// recordDisconnect 记录一次代理连接断开，必要时追加大规模断开事件
+func (lb *LoadBalancer) recordDisconnect(backendID string) {
+	count, mass := lb.timeline.recordDisconnect(backendID)
+	if !mass {
+		return
+	}
+	window := lb.timeline.massWindow
+	log.Printf("⚠️ 后端 %s 在 %v 内断开了 %d 个连接", backendID, window, count)
+	lb.RecordEvent(EventMassDisconnect, backendID,
+		fmt.Sprintf("%v 内断开 %d 个连接", window, count),
+		map[string]interface{}{"count": count, "window": window.String()})
+}
+
+// parseTimelineTime 解析查询时间：RFC3339、Unix秒，或当天的 "15:04" / "15:04:05"（本地时区）
+func parseTimelineTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			now := time.Now()
+			return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("无法解析时间: %s", value)
+}
+
+// handleTimeline 集群时间线API
+// GET  ?from=&to=&type=backend_down,mass_disconnect&backend_id=&limit=
+// GET  ?at=14:32&window=5m 查询某一时刻前后的事件
+// POST {"type": "config_change", "source": "deploy", "backend_id": "", "message": "...", "details": {}}
+func (lb *LoadBalancer) handleTimeline(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		query := r.URL.Query()
+		from, err := parseTimelineTime(query.Get("from"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		to, err := parseTimelineTime(query.Get("to"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if at := query.Get("at"); at != "" {
+			center, err := parseTimelineTime(at)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			window := 5 * time.Minute
+			if v := query.Get("window"); v != "" {
+				if window, err = time.ParseDuration(v); err != nil || window <= 0 {
+					http.Error(w, "window格式错误，如 5m", http.StatusBadRequest)
+					return
+				}
+			}
+			from, to = center.Add(-window), center.Add(window)
+		}
+
+		var types map[string]bool
+		if v := query.Get("type"); v != "" {
+			types = make(map[string]bool)
+			for _, t := range strings.Split(v, ",") {
+				types[strings.TrimSpace(t)] = true
+			}
+		}
+		limit, _ := strconv.Atoi(query.Get("limit"))
+
+		events := lb.timeline.query(from, to, types, query.Get("backend_id"), limit)
+		response := map[string]interface{}{
+			"total":  len(events),
+			"events": events,
+		}
+		if !from.IsZero() {
+			response["from"] = from
+		}
+		if !to.IsZero() {
+			response["to"] = to
+		}
+		json.NewEncoder(w).Encode(response)
+	case "POST":
+		var event TimelineEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			http.Error(w, "请求格式错误", http.StatusBadRequest)
+			return
+		}
+		if event.Type == "" || event.Message == "" {
+			http.Error(w, "type和message为必填字段", http.StatusBadRequest)
+			return
+		}
+		if event.Source == "" {
+			event.Source = "api"
+		}
+		if event.Time.IsZero() {
+			event.Time = time.Now()
+		}
+		lb.timeline.add(event)
+		log.Printf("时间线事件 [%s] %s: %s", event.Source, event.Type, event.Message)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"event":   event,
+		})
+	default:
+		http.Error(w, "仅支持GET和POST请求", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,217 @@
+package lb
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"websocket-loadbalance/protocol"
+)
+
+// TLSConfig 负载均衡器端口使用的证书文件。文件变化后自动重新加载，新的握手使用新证书，
+// 已建立的WebSocket连接不受影响，适合cert-manager、Vault等签发的短期证书
+type TLSConfig struct {
+	Enabled        bool              `json:"enabled" yaml:"enabled"`
+	CertFile       string            `json:"cert_file" yaml:"cert_file"`             // 证书链（PEM）
+	KeyFile        string            `json:"key_file" yaml:"key_file"`               // 私钥（PEM）
+	Dir            string            `json:"dir" yaml:"dir"`                         // 证书目录（如Kubernetes Secret挂载点），使用其中的 tls.crt 和 tls.key
+	ReloadInterval protocol.Duration `json:"reload_interval" yaml:"reload_interval"` // 检查文件变化的间隔，默认10s
+}
+
+// Validate 校验TLS配置
+func (c TLSConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Dir == "" && (c.CertFile == "" || c.KeyFile == "") {
+		return fmt.Errorf("tls 需要配置 dir 或 cert_file 和 key_file")
+	}
+	if c.ReloadInterval < 0 {
+		return fmt.Errorf("tls.reload_interval 不能为负数")
+	}
+	return nil
+}
+
+// files 证书和私钥的路径，cert_file/key_file 优先于 dir
+func (c TLSConfig) files() (string, string) {
+	certFile, keyFile := c.CertFile, c.KeyFile
+	if certFile == "" {
+		certFile = filepath.Join(c.Dir, "tls.crt")
+	}
+	if keyFile == "" {
+		keyFile = filepath.Join(c.Dir, "tls.key")
+	}
+	return certFile, keyFile
+}
+
+// certReloader 加载证书文件并定期检查变化
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+	lb       *LoadBalancer
+	cert     atomic.Pointer[tls.Certificate]
+	stop     context.CancelFunc
+
+	mu        sync.Mutex
+	certPEM   []byte // 当前证书对应的文件内容，用于判断文件是否变化
+	keyPEM    []byte
+	notAfter  time.Time
+	subject   string
+	loadedAt  time.Time
+	reloads   int
+	lastError string
+	failedAt  time.Time
+}
+
+// SetTLS 使用证书文件提供HTTPS/WSS（需在Start之前调用），与autocert不能同时启用
+func (lb *LoadBalancer) SetTLS(cfg TLSConfig) error {
+	if !cfg.Enabled {
+		lb.tlsCerts = nil
+		return nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	interval := time.Duration(cfg.ReloadInterval)
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	certFile, keyFile := cfg.files()
+	r := &certReloader{certFile: certFile, keyFile: keyFile, interval: interval, lb: lb}
+	if _, err := r.reload(); err != nil {
+		return fmt.Errorf("加载TLS证书失败: %w", err)
+	}
+	lb.tlsCerts = r
+	return nil
+}
+
+// start 开始检查证书文件的变化
+func (r *certReloader) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.stop = cancel
+	r.mu.Lock()
+	log.Printf("TLS证书: %s，有效期至 %s，每 %v 检查文件变化", r.certFile, r.notAfter.Format(time.RFC3339), r.interval)
+	r.mu.Unlock()
+	go r.watch(ctx)
+}
+
+func (r *certReloader) shutdown() {
+	if r.stop != nil {
+		r.stop()
+	}
+}
+
+func (r *certReloader) watch(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := r.reload()
+		if err != nil {
+			// 轮换过程中证书和私钥可能暂时不匹配，继续使用旧证书，下次检查时重试。同一错误只记录一次
+			r.mu.Lock()
+			repeated := r.lastError == err.Error()
+			r.lastError = err.Error()
+			r.failedAt = time.Now()
+			r.mu.Unlock()
+			if !repeated {
+				log.Printf("重新加载TLS证书失败，继续使用当前证书: %v", err)
+			}
+			continue
+		}
+		if changed {
+			r.mu.Lock()
+			message := fmt.Sprintf("重新加载TLS证书 %s，有效期至 %s", r.subject, r.notAfter.Format(time.RFC3339))
+			details := map[string]interface{}{"cert_file": r.certFile, "not_after": r.notAfter}
+			r.mu.Unlock()
+			log.Print(message)
+			r.lb.RecordEvent(EventCertificateReloaded, "", message, details)
+		}
+	}
+}
+
+// reload 读取证书文件，内容变化时解析并替换当前证书。返回证书是否被替换
+func (r *certReloader) reload() (bool, error) {
+	certPEM, err := os.ReadFile(r.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyPEM, err := os.ReadFile(r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	unchanged := bytes.Equal(certPEM, r.certPEM) && bytes.Equal(keyPEM, r.keyPEM)
+	r.mu.Unlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false, err
+	}
+	cert.Leaf = leaf
+	r.cert.Store(&cert)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.certPEM != nil {
+		r.reloads++
+	}
+	r.certPEM, r.keyPEM = certPEM, keyPEM
+	r.notAfter = leaf.NotAfter
+	r.subject = leaf.Subject.String()
+	r.loadedAt = time.Now()
+	r.lastError = ""
+	r.failedAt = time.Time{}
+	return true, nil
+}
+
+// tlsConfig 负载均衡器端口使用的TLS配置，每次握手取当前证书
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.cert.Load(), nil
+		},
+		NextProtos: []string{"http/1.1"},
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+// status 当前证书的状态
+func (r *certReloader) status() map[string]interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := map[string]interface{}{
+		"cert_file": r.certFile,
+		"key_file":  r.keyFile,
+		"subject":   r.subject,
+		"not_after": r.notAfter,
+		"loaded_at": r.loadedAt,
+		"reloads":   r.reloads,
+		"valid":     time.Now().Before(r.notAfter),
+	}
+	if r.lastError != "" {
+		status["last_error"] = r.lastError
+		status["failed_at"] = r.failedAt
+	}
+	return status
+}
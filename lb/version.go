@@ -0,0 +1,118 @@
+package lb
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"websocket-loadbalance/protocol"
+)
+
+// versionCounter 按协议版本统计经负载均衡器转发的WebSocket连接
+type versionCounter struct {
+	mu     sync.Mutex
+	active map[int]int64 // 当前连接数
+	total  map[int]int64 // 累计连接数
+}
+
+func newVersionCounter() *versionCounter {
+	return &versionCounter{active: make(map[int]int64), total: make(map[int]int64)}
+}
+
+// track 记录一个连接，返回连接结束时调用的函数。version为0（声明的版本无效）时不计数
+func (c *versionCounter) track(version int) func() {
+	if version <= 0 {
+		return func() {}
+	}
+	c.mu.Lock()
+	c.active[version]++
+	c.total[version]++
+	c.mu.Unlock()
+	return func() {
+		c.mu.Lock()
+		c.active[version]--
+		if c.active[version] == 0 {
+			delete(c.active, version)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// snapshot 按版本导出当前和累计连接数，键为版本号字符串
+func (c *versionCounter) snapshot() (active, total map[string]int64) {
+	active, total = make(map[string]int64), make(map[string]int64)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for version, n := range c.active {
+		active[strconv.Itoa(version)] = n
+	}
+	for version, n := range c.total {
+		total[strconv.Itoa(version)] = n
+	}
+	return active, total
+}
+
+// supportsVersion 后端是否支持客户端的协议版本。后端未上报版本范围（旧版本节点或TCP探测）
+// 或客户端声明的版本无效时不做限制，由节点在握手时决定
+func (b *BackendServer) supportsVersion(version int) bool {
+	return version <= 0 || b.ProtocolVersions == nil || b.ProtocolVersions.Contains(version)
+}
+
+// BackendVersions 单个后端支持的协议版本
+type BackendVersions struct {
+	ID               string                 `json:"id"`
+	IsHealthy        bool                   `json:"is_healthy"`
+	Connections      int                    `json:"connections"`
+	ProtocolVersions *protocol.VersionRange `json:"protocol_versions"` // 后端 /health 上报的范围，未上报时为null
+}
+
+// ProtocolVersionStatus 协议版本在集群中的分布
+type ProtocolVersionStatus struct {
+	Connections      map[string]int64  `json:"connections"`       // 按客户端协议版本统计的当前转发连接数
+	TotalConnections map[string]int64  `json:"total_connections"` // 按版本统计的累计连接数
+	Backends         []BackendVersions `json:"backends"`
+	// 所有已上报版本的健康后端都支持的版本范围，没有共同支持的版本时为空，说明集群正处于不兼容的滚动升级中
+	CommonVersions *protocol.VersionRange `json:"common_versions,omitempty"`
+}
+
+// ProtocolVersionStatus 按版本统计的转发连接数和各后端支持的版本范围
+func (lb *LoadBalancer) ProtocolVersionStatus() ProtocolVersionStatus {
+	status := ProtocolVersionStatus{Backends: []BackendVersions{}}
+	var common *protocol.VersionRange
+	lb.backendsMu.RLock()
+	for _, backend := range lb.backends {
+		entry := BackendVersions{ID: backend.ID, IsHealthy: backend.IsHealthy, Connections: backend.Connections}
+		if v := backend.ProtocolVersions; v != nil {
+			entry.ProtocolVersions = &protocol.VersionRange{Min: v.Min, Max: v.Max}
+			if backend.IsHealthy {
+				if common == nil {
+					common = &protocol.VersionRange{Min: v.Min, Max: v.Max}
+				} else {
+					common.Min = max(common.Min, v.Min)
+					common.Max = min(common.Max, v.Max)
+				}
+			}
+		}
+		status.Backends = append(status.Backends, entry)
+	}
+	lb.backendsMu.RUnlock()
+	sort.Slice(status.Backends, func(i, j int) bool { return status.Backends[i].ID < status.Backends[j].ID })
+
+	status.Connections, status.TotalConnections = lb.versions.snapshot()
+	if common != nil && common.Min <= common.Max {
+		status.CommonVersions = common
+	}
+	return status
+}
+
+// handleProtocolVersions 协议版本分布: GET /api/protocol-versions
+func (lb *LoadBalancer) handleProtocolVersions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "仅支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lb.ProtocolVersionStatus())
+}
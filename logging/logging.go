@@ -0,0 +1,51 @@
+// Package logging 为标准库log增加日志级别。普通日志仍使用log.Printf（info级别），
+// 逐连接、逐消息的日志使用Debugf，只在debug级别输出。级别可以在运行时修改
+package logging
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// 日志级别
+const (
+	LevelDebug = "debug" // 额外输出逐连接、逐消息的日志
+	LevelInfo  = "info"  // 默认
+)
+
+var debug atomic.Bool
+
+// Validate 校验日志级别，空字符串表示默认的info
+func Validate(level string) error {
+	switch level {
+	case "", LevelDebug, LevelInfo:
+		return nil
+	}
+	return fmt.Errorf("无效的日志级别: %s (可选: debug, info)", level)
+}
+
+// SetLevel 修改日志级别，返回修改前的级别
+func SetLevel(level string) (string, error) {
+	if err := Validate(level); err != nil {
+		return "", err
+	}
+	old := Level()
+	debug.Store(level == LevelDebug)
+	return old, nil
+}
+
+// Level 当前的日志级别
+func Level() string {
+	if debug.Load() {
+		return LevelDebug
+	}
+	return LevelInfo
+}
+
+// Debugf debug级别的日志
+func Debugf(format string, args ...interface{}) {
+	if debug.Load() {
+		log.Output(2, fmt.Sprintf(format, args...))
+	}
+}
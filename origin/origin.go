@@ -0,0 +1,221 @@
+// Package origin 按来源（Origin请求头）限制浏览器发起的WebSocket握手和跨域HTTP请求，并为管理API添加CORS响应头
+package origin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"websocket-loadbalance/logging"
+	"websocket-loadbalance/protocol"
+)
+
+// regexPrefix 以该前缀开头的条目按正则表达式匹配
+const regexPrefix = "regex:"
+
+// 默认允许的跨域请求方法和请求头
+var (
+	defaultMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	defaultHeaders = []string{"Content-Type", "Authorization", "Idempotency-Key", "traceparent"}
+)
+
+// Config 来源白名单和CORS配置
+type Config struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// 允许的来源，每项为以下之一：
+	//   精确匹配: https://app.example.com
+	//   通配符:   https://*.example.com（* 匹配一段或多段主机名，也可用于端口，如 http://localhost:*）
+	//   正则:     regex:^https://(admin|ops)\.example\.com$（整个来源需完全匹配）
+	//   "*" 允许所有来源
+	// 与请求Host相同的来源（同源）和不带Origin的请求（非浏览器客户端）总是允许
+	AllowedOrigins   []string          `json:"allowed_origins" yaml:"allowed_origins"`
+	AllowCredentials bool              `json:"allow_credentials" yaml:"allow_credentials"` // 允许跨域请求携带Cookie和认证信息
+	AllowedMethods   []string          `json:"allowed_methods" yaml:"allowed_methods"`     // 为空时为 GET, POST, PUT, DELETE, OPTIONS
+	AllowedHeaders   []string          `json:"allowed_headers" yaml:"allowed_headers"`     // 为空时为 Content-Type, Authorization, Idempotency-Key, traceparent
+	ExposedHeaders   []string          `json:"exposed_headers" yaml:"exposed_headers"`     // 允许浏览器脚本读取的响应头
+	MaxAge           protocol.Duration `json:"max_age" yaml:"max_age"`                     // 预检结果的缓存时间，默认10m
+}
+
+// Validate 校验来源配置
+func (c Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.AllowedOrigins) == 0 {
+		return fmt.Errorf("origin: 启用来源检查时 allowed_origins 不能为空")
+	}
+	for _, entry := range c.AllowedOrigins {
+		if _, err := compile(entry); err != nil {
+			return err
+		}
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("origin: max_age 不能为负数")
+	}
+	return nil
+}
+
+// Policy 来源检查策略，方法对nil安全（nil表示不检查来源、不添加CORS响应头）
+type Policy struct {
+	any         bool             // 配置了 "*"
+	exact       map[string]bool  // 精确匹配的来源（小写）
+	patterns    []*regexp.Regexp // 通配符和正则
+	credentials bool
+	methods     string
+	headers     string
+	exposed     string
+	maxAge      string
+}
+
+// New 按配置创建来源检查策略，未启用时返回nil
+func New(cfg Config) (*Policy, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	p := &Policy{exact: make(map[string]bool), credentials: cfg.AllowCredentials}
+	for _, entry := range cfg.AllowedOrigins {
+		switch {
+		case entry == "*":
+			p.any = true
+		case strings.HasPrefix(entry, regexPrefix) || strings.Contains(entry, "*"):
+			pattern, _ := compile(entry)
+			p.patterns = append(p.patterns, pattern)
+		default:
+			p.exact[normalize(entry)] = true
+		}
+	}
+	methods, headers := cfg.AllowedMethods, cfg.AllowedHeaders
+	if len(methods) == 0 {
+		methods = defaultMethods
+	}
+	if len(headers) == 0 {
+		headers = defaultHeaders
+	}
+	p.methods = strings.Join(methods, ", ")
+	p.headers = strings.Join(headers, ", ")
+	p.exposed = strings.Join(cfg.ExposedHeaders, ", ")
+	maxAge := time.Duration(cfg.MaxAge)
+	if maxAge == 0 {
+		maxAge = 10 * time.Minute
+	}
+	p.maxAge = strconv.Itoa(int(maxAge / time.Second))
+	log.Printf("启用来源检查: 允许 %v", cfg.AllowedOrigins)
+	return p, nil
+}
+
+// compile 将通配符或正则条目编译为完整匹配的正则表达式，精确匹配的条目返回nil
+func compile(entry string) (*regexp.Regexp, error) {
+	if entry == "" {
+		return nil, fmt.Errorf("origin: allowed_origins 中存在空条目")
+	}
+	if expr, ok := strings.CutPrefix(entry, regexPrefix); ok {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("origin: 无效的正则 %q: %v", entry, err)
+		}
+		return re, nil
+	}
+	if entry == "*" || !strings.Contains(entry, "*") {
+		if entry != "*" && !strings.Contains(entry, "://") {
+			return nil, fmt.Errorf("origin: 来源 %q 缺少协议，如 https://app.example.com", entry)
+		}
+		return nil, nil
+	}
+	if !strings.Contains(entry, "://") {
+		return nil, fmt.Errorf("origin: 来源 %q 缺少协议，如 https://*.example.com", entry)
+	}
+	// * 只匹配主机名和端口中的字符，不会跨越协议或路径
+	expr := strings.ReplaceAll(regexp.QuoteMeta(normalize(entry)), `\*`, `[a-z0-9.-]+`)
+	return regexp.MustCompile("^" + expr + "$"), nil
+}
+
+// normalize 来源不区分大小写，末尾的 / 忽略
+func normalize(origin string) string {
+	return strings.TrimSuffix(strings.ToLower(origin), "/")
+}
+
+// Allowed 请求的来源是否允许：不带Origin的请求和同源请求总是允许
+func (p *Policy) Allowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if p == nil || origin == "" {
+		return true
+	}
+	if p.any {
+		return true
+	}
+	normalized := normalize(origin)
+	if p.exact[normalized] {
+		return true
+	}
+	for _, pattern := range p.patterns {
+		if pattern.MatchString(normalized) {
+			return true
+		}
+	}
+	if _, host, ok := strings.Cut(normalized, "://"); ok && strings.EqualFold(host, r.Host) {
+		return true
+	}
+	return false
+}
+
+// Wrap 在处理请求前检查来源：不允许的来源以403拒绝（包括WebSocket握手）；
+// 允许的跨域请求添加CORS响应头，预检请求直接以204响应
+func (p *Policy) Wrap(next http.Handler) http.Handler {
+	if p == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !p.Allowed(r) {
+			p.reject(w, r, origin)
+			return
+		}
+
+		header := w.Header()
+		header.Set("Access-Control-Allow-Origin", origin)
+		if p.credentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			header.Set("Access-Control-Allow-Methods", p.methods)
+			header.Set("Access-Control-Allow-Headers", p.headers)
+			header.Set("Access-Control-Max-Age", p.maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if p.exposed != "" {
+			header.Set("Access-Control-Expose-Headers", p.exposed)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reject 以403和协议层错误消息拒绝不允许的来源
+func (p *Policy) reject(w http.ResponseWriter, r *http.Request, origin string) {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		log.Printf("拒绝来源 %s 的WebSocket连接 (%s)", origin, r.RemoteAddr)
+	} else {
+		logging.Debugf("拒绝来源 %s 的请求: %s %s", origin, r.Method, r.URL.Path)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":    "error",
+		"code":    "origin_not_allowed",
+		"message": fmt.Sprintf("不允许的来源: %s", origin),
+		"origin":  origin,
+	})
+}
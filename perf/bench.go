@@ -0,0 +1,145 @@
+package perf
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// RunBenchmark 在本机启动回显服务器，按当前性能profile测量建连速度、
+// 每连接内存占用和消息往返吞吐，用于验证调优参数在目标机器上的效果
+func RunBenchmark(perf Settings, conns int, duration time.Duration) {
+	if conns <= 0 {
+		conns = 1
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatalf("性能自测监听失败: %v", err)
+	}
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:    perf.ReadBufferSize,
+		WriteBufferSize:   perf.WriteBufferSize,
+		EnableCompression: perf.Compression,
+		CheckOrigin:       func(r *http.Request) bool { return true },
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(messageType, data); err != nil {
+				return
+			}
+		}
+	})
+	httpServer := &http.Server{Handler: mux}
+	go httpServer.Serve(listener)
+	defer httpServer.Close()
+
+	url := "ws://" + listener.Addr().String() + "/ws"
+	dialer := websocket.Dialer{
+		ReadBufferSize:    perf.ReadBufferSize,
+		WriteBufferSize:   perf.WriteBufferSize,
+		EnableCompression: perf.Compression,
+		HandshakeTimeout:  10 * time.Second,
+	}
+
+	fmt.Println("=== WebSocket 性能自测 ===")
+	fmt.Printf("profile: %s, GOMAXPROCS: %d, CPU: %d, 文件描述符上限: %s\n",
+		perf.Profile, runtime.GOMAXPROCS(0), runtime.NumCPU(), fdLimit())
+	fmt.Printf("读/写缓冲: %d/%d, 压缩: %v\n", perf.ReadBufferSize, perf.WriteBufferSize, perf.Compression)
+
+	// 建立连接（限制并发握手数量，避免瞬时打满accept队列）
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	clients := make([]*websocket.Conn, conns)
+	var dialFailures int64
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 100)
+	start := time.Now()
+	for i := 0; i < conns; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			conn, _, err := dialer.Dial(url, nil)
+			if err != nil {
+				atomic.AddInt64(&dialFailures, 1)
+				return
+			}
+			clients[i] = conn
+		}(i)
+	}
+	wg.Wait()
+	dialElapsed := time.Since(start)
+
+	runtime.GC()
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+
+	connected := conns - int(dialFailures)
+	fmt.Printf("建立连接: %d 成功 / %d 失败, 耗时 %v (%.0f 连接/秒)\n",
+		connected, dialFailures, dialElapsed.Round(time.Millisecond), float64(connected)/dialElapsed.Seconds())
+	if connected > 0 && after.HeapAlloc > before.HeapAlloc {
+		// 客户端和服务端两侧的连接都在本进程内，按连接对统计
+		fmt.Printf("内存占用: 每连接对约 %.1f KB (堆 %.1f MB)\n",
+			float64(after.HeapAlloc-before.HeapAlloc)/float64(connected)/1024,
+			float64(after.HeapAlloc)/1024/1024)
+	}
+
+	// 消息往返吞吐
+	payload := []byte(`{"type":"benchmark","content":"0123456789abcdef0123456789abcdef"}`)
+	var messages, totalLatency int64
+	deadline := time.Now().Add(duration)
+	for _, conn := range clients {
+		if conn == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(conn *websocket.Conn) {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				sent := time.Now()
+				if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+					return
+				}
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+				atomic.AddInt64(&messages, 1)
+				atomic.AddInt64(&totalLatency, int64(time.Since(sent)))
+			}
+		}(conn)
+	}
+	wg.Wait()
+
+	fmt.Printf("消息往返: %d 条, %.0f 条/秒", messages, float64(messages)/duration.Seconds())
+	if messages > 0 {
+		fmt.Printf(", 平均延迟 %v", time.Duration(totalLatency/messages).Round(time.Microsecond))
+	}
+	fmt.Println()
+
+	for _, conn := range clients {
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
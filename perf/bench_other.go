@@ -0,0 +1,8 @@
+//go:build !unix
+
+package perf
+
+// fdLimit 非Unix平台无法获取文件描述符上限
+func fdLimit() string {
+	return "未知"
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package perf
+
+import (
+	"strconv"
+	"syscall"
+)
+
+// fdLimit 返回当前进程的文件描述符上限（软限制/硬限制）
+func fdLimit() string {
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		return "未知"
+	}
+	return strconv.FormatUint(rlimit.Cur, 10) + "/" + strconv.FormatUint(rlimit.Max, 10)
+}
@@ -0,0 +1,138 @@
+// Package perf 提供性能调优预设和内置的性能自测
+package perf
+
+import (
+	"compress/flate"
+	"fmt"
+	"log"
+	"runtime"
+	"time"
+
+	"websocket-loadbalance/protocol"
+)
+
+// Config 性能调优配置，先应用预设profile，再用显式填写的字段覆盖
+type Config struct {
+	Profile          string            `json:"profile" yaml:"profile"`                     // default, low-latency, high-throughput, low-memory
+	GOMAXPROCS       int               `json:"gomaxprocs" yaml:"gomaxprocs"`               // 0表示使用全部CPU
+	ReadBufferSize   int               `json:"read_buffer_size" yaml:"read_buffer_size"`   // WebSocket读缓冲区
+	WriteBufferSize  int               `json:"write_buffer_size" yaml:"write_buffer_size"` // WebSocket写缓冲区
+	CopyBufferSize   int               `json:"copy_buffer_size" yaml:"copy_buffer_size"`   // 代理转发复制缓冲区
+	Compression      *bool             `json:"compression" yaml:"compression"`             // 是否协商permessage-deflate
+	CompressionLevel int               `json:"compression_level" yaml:"compression_level"` // 压缩级别1(最快)~9(最高压缩率)，默认1
+	Batching         *bool             `json:"batching" yaml:"batching"`                   // 是否启用服务端消息批量发送
+	BatchWindow      protocol.Duration `json:"batch_window" yaml:"batch_window"`           // 批量合并等待时间
+}
+
+// Settings 解析后的性能参数
+type Settings struct {
+	Profile          string        `json:"profile"`
+	GOMAXPROCS       int           `json:"gomaxprocs"`
+	ReadBufferSize   int           `json:"read_buffer_size"`
+	WriteBufferSize  int           `json:"write_buffer_size"`
+	CopyBufferSize   int           `json:"copy_buffer_size"`
+	Compression      bool          `json:"compression"`
+	CompressionLevel int           `json:"compression_level"`
+	Batching         bool          `json:"batching"`
+	BatchWindow      time.Duration `json:"batch_window"`
+
+	batchingFixed bool // 为true时Batching覆盖服务端的批量发送配置，否则仅在Batching为true时开启
+}
+
+// BatchingOverride 返回批量发送设置；fixed为true时应覆盖服务端自身的批量配置，
+// 否则仅在enabled为true时开启批量发送
+func (p Settings) BatchingOverride() (enabled, fixed bool) {
+	return p.Batching, p.batchingFixed
+}
+
+// 性能预设
+var performancePresets = map[string]Settings{
+	"default": {
+		ReadBufferSize:  4096,
+		WriteBufferSize: 4096,
+		CopyBufferSize:  32 * 1024,
+	},
+	// 低延迟：不合并、不压缩，每条消息立即发出
+	"low-latency": {
+		ReadBufferSize:  4096,
+		WriteBufferSize: 4096,
+		CopyBufferSize:  32 * 1024,
+		batchingFixed:   true,
+	},
+	// 高吞吐：大缓冲区，小消息合并发送
+	"high-throughput": {
+		ReadBufferSize:  16 * 1024,
+		WriteBufferSize: 16 * 1024,
+		CopyBufferSize:  64 * 1024,
+		Batching:        true,
+		BatchWindow:     5 * time.Millisecond,
+		batchingFixed:   true,
+	},
+	// 低内存：小缓冲区并压缩，适合海量空闲长连接
+	"low-memory": {
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CopyBufferSize:  4 * 1024,
+		Compression:     true,
+	},
+}
+
+// Resolve 合并预设与显式配置
+func (c Config) Resolve() (Settings, error) {
+	profile := c.Profile
+	if profile == "" {
+		profile = "default"
+	}
+	settings, ok := performancePresets[profile]
+	if !ok {
+		return Settings{}, fmt.Errorf("无效的性能profile: %s (可选: default, low-latency, high-throughput, low-memory)", profile)
+	}
+	settings.Profile = profile
+
+	if c.GOMAXPROCS > 0 {
+		settings.GOMAXPROCS = c.GOMAXPROCS
+	}
+	if c.ReadBufferSize > 0 {
+		settings.ReadBufferSize = c.ReadBufferSize
+	}
+	if c.WriteBufferSize > 0 {
+		settings.WriteBufferSize = c.WriteBufferSize
+	}
+	if c.CopyBufferSize > 0 {
+		settings.CopyBufferSize = c.CopyBufferSize
+	}
+	if c.Compression != nil {
+		settings.Compression = *c.Compression
+	}
+	switch {
+	case c.CompressionLevel == 0:
+		settings.CompressionLevel = flate.BestSpeed
+	case c.CompressionLevel >= flate.BestSpeed && c.CompressionLevel <= flate.BestCompression:
+		settings.CompressionLevel = c.CompressionLevel
+	default:
+		return Settings{}, fmt.Errorf("无效的压缩级别: %d (可选: 1-9)", c.CompressionLevel)
+	}
+	if c.Batching != nil {
+		settings.Batching = *c.Batching
+		settings.batchingFixed = true
+	}
+	if c.BatchWindow > 0 {
+		settings.BatchWindow = time.Duration(c.BatchWindow)
+	}
+	return settings, nil
+}
+
+// ApplyRuntime 应用进程级参数（GOMAXPROCS），启动时调用一次
+func (p Settings) ApplyRuntime() {
+	if p.GOMAXPROCS > 0 {
+		runtime.GOMAXPROCS(p.GOMAXPROCS)
+	}
+
+	compression := "关闭"
+	if p.Compression {
+		compression = fmt.Sprintf("级别%d", p.CompressionLevel)
+	}
+	log.Printf("性能profile: %s (GOMAXPROCS=%d, 读/写缓冲=%d/%d, 转发缓冲=%d, 压缩=%s, 批量=%v)",
+		p.Profile, runtime.GOMAXPROCS(0), p.ReadBufferSize, p.WriteBufferSize,
+		p.CopyBufferSize, compression, p.Batching)
+}
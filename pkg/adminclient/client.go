@@ -0,0 +1,302 @@
+// Package adminclient 管理API的Go客户端，封装节点和负载均衡器的HTTP接口，
+// 提供类型化的请求和响应、失败重试和认证令牌处理。
+//
+// 节点和负载均衡器的管理API地址不同，分别创建客户端：
+//
+//	node := adminclient.New("http://localhost:8081", adminclient.Options{})
+//	lb := adminclient.New("http://localhost:8080", adminclient.Options{Token: token})
+package adminclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 默认参数
+const (
+	defaultTimeout      = 30 * time.Second
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 200 * time.Millisecond
+	maxRetryBackoff     = 5 * time.Second
+)
+
+// Options 客户端选项，零值使用默认配置
+type Options struct {
+	// 认证令牌，以 Authorization: Bearer 请求头发送
+	Token string
+	// 动态获取令牌（如定期刷新的JWT），设置后优先于Token；
+	// 请求返回401时会重新获取一次令牌并重试
+	TokenSource func(ctx context.Context) (string, error)
+	// 单次HTTP请求的超时，默认30s；同步等待指令响应时会自动延长
+	Timeout time.Duration
+	// 失败重试次数，默认3，负数表示不重试
+	MaxRetries int
+	// 首次重试前的等待时间，之后每次翻倍，最长5s，默认200ms
+	RetryBackoff time.Duration
+	// 自定义HTTP客户端，nil时使用内部创建的客户端
+	HTTPClient *http.Client
+}
+
+// Client 管理API客户端，可以在多个goroutine中同时使用
+type Client struct {
+	baseURL     string
+	httpClient  *http.Client
+	timeout     time.Duration
+	token       string
+	tokenSource func(ctx context.Context) (string, error)
+	maxRetries  int
+	backoff     time.Duration
+}
+
+// New 创建管理API客户端，baseURL为节点或负载均衡器的HTTP地址，如 http://localhost:8080
+func New(baseURL string, opts Options) *Client {
+	c := &Client{
+		baseURL:     strings.TrimRight(baseURL, "/"),
+		httpClient:  opts.HTTPClient,
+		timeout:     opts.Timeout,
+		token:       opts.Token,
+		tokenSource: opts.TokenSource,
+		maxRetries:  opts.MaxRetries,
+		backoff:     opts.RetryBackoff,
+	}
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{}
+	}
+	if c.timeout <= 0 {
+		c.timeout = defaultTimeout
+	}
+	if c.maxRetries == 0 {
+		c.maxRetries = defaultMaxRetries
+	} else if c.maxRetries < 0 {
+		c.maxRetries = 0
+	}
+	if c.backoff <= 0 {
+		c.backoff = defaultRetryBackoff
+	}
+	return c
+}
+
+// APIError 管理API返回的错误
+type APIError struct {
+	StatusCode int    // HTTP状态码，请求成功但业务失败时为200
+	Code       string // 响应中的 code 字段，如 command_queue_full
+	Message    string // 错误说明
+	Body       []byte // 原始响应内容
+}
+
+func (e *APIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("管理API错误 %d (%s): %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("管理API错误 %d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound 判断错误是否为资源不存在
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// IsUnauthorized 判断错误是否为认证失败或无权限
+func IsUnauthorized(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) &&
+		(apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden)
+}
+
+// request 一次API调用
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   interface{}
+	// 非空时作为 Idempotency-Key 发送，POST请求只有设置了幂等键才会重试
+	idempotencyKey string
+	// 覆盖默认的单次请求超时
+	timeout time.Duration
+	// 重复执行没有副作用的POST请求（如开始排空），可以直接重试
+	idempotent bool
+}
+
+// retryable 请求是否可以安全重试
+func (r *request) retryable() bool {
+	return r.method == http.MethodGet || r.method == http.MethodDelete ||
+		r.method == http.MethodPut || r.idempotencyKey != "" || r.idempotent
+}
+
+// do 发送请求并将响应解析到out，网络错误和429/502/503/504按指数退避重试
+func (c *Client) do(ctx context.Context, req *request, out interface{}) error {
+	var body []byte
+	if req.body != nil {
+		encoded, err := json.Marshal(req.body)
+		if err != nil {
+			return fmt.Errorf("序列化请求失败: %w", err)
+		}
+		body = encoded
+	}
+
+	refreshed := false
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		status, data, err := c.send(ctx, req, body)
+		if err == nil && status == http.StatusUnauthorized && c.tokenSource != nil && !refreshed {
+			// 令牌可能已过期，重新获取后再试一次
+			refreshed = true
+			status, data, err = c.send(ctx, req, body)
+		}
+
+		if err == nil && status < 300 {
+			if out == nil || len(data) == 0 {
+				return nil
+			}
+			if err := json.Unmarshal(data, out); err != nil {
+				return fmt.Errorf("解析 %s %s 的响应失败: %w", req.method, req.path, err)
+			}
+			return nil
+		}
+		if err == nil {
+			err = newAPIError(status, data)
+		}
+
+		if attempt >= c.maxRetries || !req.retryable() || !shouldRetry(status, err) {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// send 发送一次HTTP请求，返回状态码和响应内容
+func (c *Client) send(ctx context.Context, req *request, body []byte) (int, []byte, error) {
+	timeout := c.timeout
+	if req.timeout > 0 {
+		timeout = req.timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	resp, err := c.open(ctx, req, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("读取 %s %s 的响应失败: %w", req.method, req.path, err)
+	}
+	return resp.StatusCode, data, nil
+}
+
+// open 发送一次HTTP请求并返回未读取的响应，由调用方关闭响应体
+func (c *Client) open(ctx context.Context, req *request, body []byte) (*http.Response, error) {
+	target := c.baseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	if req.idempotencyKey != "" {
+		httpReq.Header.Set("Idempotency-Key", req.idempotencyKey)
+	}
+	token, err := c.currentToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s 失败: %w", req.method, req.path, err)
+	}
+	return resp, nil
+}
+
+// currentToken 返回本次请求使用的令牌，设置了TokenSource时每次请求都重新获取
+func (c *Client) currentToken(ctx context.Context) (string, error) {
+	if c.tokenSource == nil {
+		return c.token, nil
+	}
+	token, err := c.tokenSource(ctx)
+	if err != nil {
+		return "", fmt.Errorf("获取认证令牌失败: %w", err)
+	}
+	return token, nil
+}
+
+// shouldRetry 网络错误和表示暂时不可用的状态码可以重试
+func shouldRetry(status int, err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return true
+	}
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// newAPIError 从错误响应中解析code和错误说明，兼容JSON和纯文本响应
+func newAPIError(status int, data []byte) *APIError {
+	apiErr := &APIError{StatusCode: status, Body: data}
+	var parsed struct {
+		Code    string `json:"code"`
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(data, &parsed) == nil {
+		apiErr.Code = parsed.Code
+		apiErr.Message = parsed.Error
+		if apiErr.Message == "" {
+			apiErr.Message = parsed.Message
+		}
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(status)
+	}
+	return apiErr
+}
+
+// newIdempotencyKey 生成随机幂等键，使写操作在重试时不会重复执行
+func newIdempotencyKey() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("adminclient-%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,363 @@
+package adminclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/protocol"
+)
+
+// 变更动作
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// 变更对象
+const (
+	KindStrategy = "strategy"
+	KindBackend  = "backend"
+	KindPool     = "pool"
+	KindACL      = "acl"
+)
+
+// Change 期望状态与当前状态之间的一项差异
+type Change struct {
+	Action  string   // ActionCreate / ActionUpdate / ActionDelete
+	Kind    string   // KindStrategy / KindBackend / KindPool / KindACL
+	Name    string   // 后端ID或后端池名称
+	Details []string // 可读的差异说明，如 "weight: 1 -> 2"
+
+	backend  *lb.BackendState
+	pool     *lb.PoolConfig
+	acl      *lb.ACLConfig
+	strategy lb.Strategy
+}
+
+func (c Change) String() string {
+	if c.Name == "" {
+		return c.Kind
+	}
+	return c.Kind + " " + c.Name
+}
+
+// ClusterState 负载均衡器当前的集群状态
+func (c *Client) ClusterState(ctx context.Context) (*lb.ClusterState, error) {
+	var state lb.ClusterState
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/api/cluster"}, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// PutBackend 添加静态后端，或更新已有后端的地址和权重
+func (c *Client) PutBackend(ctx context.Context, backend lb.BackendState) error {
+	req := &request{method: http.MethodPut, path: "/api/backends/" + url.PathEscape(backend.ID), body: backend}
+	return c.do(ctx, req, nil)
+}
+
+// RemoveBackend 移除静态后端
+func (c *Client) RemoveBackend(ctx context.Context, backendID string) error {
+	return c.do(ctx, &request{method: http.MethodDelete, path: "/api/backends/" + url.PathEscape(backendID)}, nil)
+}
+
+// PutPool 添加或替换按路径路由的后端池
+func (c *Client) PutPool(ctx context.Context, pool lb.PoolConfig) error {
+	req := &request{method: http.MethodPut, path: "/api/pools/" + url.PathEscape(pool.Name), body: pool}
+	return c.do(ctx, req, nil)
+}
+
+// RemovePool 移除后端池
+func (c *Client) RemovePool(ctx context.Context, name string) error {
+	return c.do(ctx, &request{method: http.MethodDelete, path: "/api/pools/" + url.PathEscape(name)}, nil)
+}
+
+// ACL 当前的客户端访问控制规则
+func (c *Client) ACL(ctx context.Context) (*lb.ACLConfig, error) {
+	var acl lb.ACLConfig
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/api/acl"}, &acl); err != nil {
+		return nil, err
+	}
+	return &acl, nil
+}
+
+// SetACL 整体替换客户端访问控制规则
+func (c *Client) SetACL(ctx context.Context, acl lb.ACLConfig) error {
+	return c.do(ctx, &request{method: http.MethodPut, path: "/api/acl", body: acl}, nil)
+}
+
+// PlanCluster 比较期望状态和当前状态，返回需要执行的变更，顺序即执行顺序：
+// 先修改策略、添加和更新后端，再更新后端池，最后删除后端池和后端、替换访问控制规则。
+// 期望状态中为空的部分（strategy为空、backends/pools为nil、acl为nil）不做管理；
+// 写成空列表 [] 表示删除全部
+func PlanCluster(current, desired lb.ClusterState) []Change {
+	var changes []Change
+
+	if desired.Strategy != "" && desired.Strategy != current.Strategy {
+		changes = append(changes, Change{
+			Action:   ActionUpdate,
+			Kind:     KindStrategy,
+			Details:  []string{fmt.Sprintf("strategy: %s -> %s", current.Strategy, desired.Strategy)},
+			strategy: desired.Strategy,
+		})
+	}
+	defaultStrategy := current.Strategy
+	if desired.Strategy != "" {
+		defaultStrategy = desired.Strategy
+	}
+
+	var backendDeletes, poolDeletes []Change
+	if desired.Backends != nil {
+		existing := make(map[string]lb.BackendState, len(current.Backends))
+		for _, b := range current.Backends {
+			existing[b.ID] = normalizeBackend(b)
+		}
+		wanted := make(map[string]bool, len(desired.Backends))
+		for _, b := range sortedBackends(desired.Backends) {
+			b = normalizeBackend(b)
+			backend := b
+			wanted[b.ID] = true
+			old, exists := existing[b.ID]
+			if !exists {
+				details := []string{fmt.Sprintf("address: %s", formatAddress(b)), fmt.Sprintf("weight: %d", b.Weight)}
+				if b.HostHeader != "" {
+					details = append(details, fmt.Sprintf("host_header: %s", b.HostHeader))
+				}
+				if b.Socket != "" {
+					details = append(details, fmt.Sprintf("socket: %s", b.Socket))
+				}
+				if b.TLS != nil {
+					details = append(details, fmt.Sprintf("tls: %s", formatTLS(b.TLS)))
+				}
+				changes = append(changes, Change{Action: ActionCreate, Kind: KindBackend, Name: b.ID, Details: details, backend: &backend})
+				continue
+			}
+			var details []string
+			if old.Host != b.Host || old.Port != b.Port {
+				details = append(details, fmt.Sprintf("address: %s -> %s", formatAddress(old), formatAddress(b)))
+			}
+			if old.Weight != b.Weight {
+				details = append(details, fmt.Sprintf("weight: %d -> %d", old.Weight, b.Weight))
+			}
+			if old.HostHeader != b.HostHeader {
+				details = append(details, fmt.Sprintf("host_header: %q -> %q", old.HostHeader, b.HostHeader))
+			}
+			if old.Socket != b.Socket {
+				details = append(details, fmt.Sprintf("socket: %q -> %q", old.Socket, b.Socket))
+			}
+			if oldTLS, newTLS := formatTLS(old.TLS), formatTLS(b.TLS); oldTLS != newTLS {
+				details = append(details, fmt.Sprintf("tls: %s -> %s", oldTLS, newTLS))
+			}
+			if len(details) > 0 {
+				changes = append(changes, Change{Action: ActionUpdate, Kind: KindBackend, Name: b.ID, Details: details, backend: &backend})
+			}
+		}
+		for _, b := range sortedBackends(current.Backends) {
+			if !wanted[b.ID] {
+				backendDeletes = append(backendDeletes, Change{Action: ActionDelete, Kind: KindBackend, Name: b.ID})
+			}
+		}
+	}
+
+	if desired.Pools != nil {
+		existing := make(map[string]lb.PoolConfig, len(current.Pools))
+		for _, p := range current.Pools {
+			existing[p.Name] = normalizePool(p, current.Strategy)
+		}
+		wanted := make(map[string]bool, len(desired.Pools))
+		for _, p := range sortedPools(desired.Pools) {
+			p = normalizePool(p, defaultStrategy)
+			pool := p
+			wanted[p.Name] = true
+			old, exists := existing[p.Name]
+			if !exists {
+				changes = append(changes, Change{
+					Action: ActionCreate,
+					Kind:   KindPool,
+					Name:   p.Name,
+					Details: []string{
+						"path_prefix: " + p.PathPrefix,
+						fmt.Sprintf("strategy: %s", p.Strategy),
+						"backends: " + formatList(p.Backends),
+						fmt.Sprintf("sticky: %v", *p.Sticky),
+					},
+					pool: &pool,
+				})
+				if p.ProtocolVersions != nil {
+					changes[len(changes)-1].Details = append(changes[len(changes)-1].Details, "protocol_versions: "+formatVersions(p.ProtocolVersions))
+				}
+				if p.HealthCheck != nil {
+					changes[len(changes)-1].Details = append(changes[len(changes)-1].Details, "health_check: "+p.HealthCheck.String())
+				}
+				continue
+			}
+			var details []string
+			if old.PathPrefix != p.PathPrefix {
+				details = append(details, fmt.Sprintf("path_prefix: %s -> %s", old.PathPrefix, p.PathPrefix))
+			}
+			if old.Strategy != p.Strategy {
+				details = append(details, fmt.Sprintf("strategy: %s -> %s", old.Strategy, p.Strategy))
+			}
+			if formatList(old.Backends) != formatList(p.Backends) {
+				details = append(details, fmt.Sprintf("backends: %s -> %s", formatList(old.Backends), formatList(p.Backends)))
+			}
+			if *old.Sticky != *p.Sticky {
+				details = append(details, fmt.Sprintf("sticky: %v -> %v", *old.Sticky, *p.Sticky))
+			}
+			if oldVersions, newVersions := formatVersions(old.ProtocolVersions), formatVersions(p.ProtocolVersions); oldVersions != newVersions {
+				details = append(details, fmt.Sprintf("protocol_versions: %s -> %s", oldVersions, newVersions))
+			}
+			if old.HealthCheck.String() != p.HealthCheck.String() {
+				details = append(details, fmt.Sprintf("health_check: %s -> %s", old.HealthCheck, p.HealthCheck))
+			}
+			if len(details) > 0 {
+				changes = append(changes, Change{Action: ActionUpdate, Kind: KindPool, Name: p.Name, Details: details, pool: &pool})
+			}
+		}
+		for _, p := range sortedPools(current.Pools) {
+			if !wanted[p.Name] {
+				poolDeletes = append(poolDeletes, Change{Action: ActionDelete, Kind: KindPool, Name: p.Name})
+			}
+		}
+	}
+
+	// 后端可能仍被待删除的后端池引用，先删池再删后端
+	changes = append(changes, poolDeletes...)
+	changes = append(changes, backendDeletes...)
+
+	if desired.ACL != nil {
+		var old lb.ACLConfig
+		if current.ACL != nil {
+			old = *current.ACL
+		}
+		var details []string
+		if formatList(old.Allow) != formatList(desired.ACL.Allow) {
+			details = append(details, fmt.Sprintf("allow: %s -> %s", formatList(old.Allow), formatList(desired.ACL.Allow)))
+		}
+		if formatList(old.Deny) != formatList(desired.ACL.Deny) {
+			details = append(details, fmt.Sprintf("deny: %s -> %s", formatList(old.Deny), formatList(desired.ACL.Deny)))
+		}
+		if len(details) > 0 {
+			acl := *desired.ACL
+			changes = append(changes, Change{Action: ActionUpdate, Kind: KindACL, Details: details, acl: &acl})
+		}
+	}
+	return changes
+}
+
+// ApplyChange 通过管理API执行一项变更
+func (c *Client) ApplyChange(ctx context.Context, change Change) error {
+	switch change.Kind {
+	case KindStrategy:
+		_, err := c.SetPoolStrategy(ctx, lb.DefaultPoolName, change.strategy)
+		return err
+	case KindBackend:
+		if change.Action == ActionDelete {
+			return c.RemoveBackend(ctx, change.Name)
+		}
+		return c.PutBackend(ctx, *change.backend)
+	case KindPool:
+		if change.Action == ActionDelete {
+			return c.RemovePool(ctx, change.Name)
+		}
+		return c.PutPool(ctx, *change.pool)
+	case KindACL:
+		return c.SetACL(ctx, *change.acl)
+	}
+	return fmt.Errorf("未知的变更: %s", change)
+}
+
+// ApplyCluster 按顺序执行变更，遇到错误时停止并返回已成功执行的数量。
+// done非nil时每执行完一项回调一次
+func (c *Client) ApplyCluster(ctx context.Context, changes []Change, done func(Change)) (int, error) {
+	for i, change := range changes {
+		if err := c.ApplyChange(ctx, change); err != nil {
+			return i, fmt.Errorf("%s: %w", change, err)
+		}
+		if done != nil {
+			done(change)
+		}
+	}
+	return len(changes), nil
+}
+
+func normalizeBackend(b lb.BackendState) lb.BackendState {
+	b.Host = protocol.TrimHostBrackets(b.Host)
+	if b.Host == "" {
+		b.Host = "localhost"
+	}
+	if b.Weight <= 0 {
+		b.Weight = 1
+	}
+	if b.TLS != nil && !b.TLS.Enabled {
+		b.TLS = nil
+	}
+	return b
+}
+
+func normalizePool(p lb.PoolConfig, defaultStrategy lb.Strategy) lb.PoolConfig {
+	if p.Strategy == "" {
+		p.Strategy = defaultStrategy
+	}
+	p.Backends = append([]string(nil), p.Backends...)
+	sort.Strings(p.Backends)
+	sticky := p.Sticky == nil || *p.Sticky
+	p.Sticky = &sticky
+	return p
+}
+
+func sortedBackends(backends []lb.BackendState) []lb.BackendState {
+	sorted := append([]lb.BackendState(nil), backends...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	return sorted
+}
+
+func sortedPools(pools []lb.PoolConfig) []lb.PoolConfig {
+	sorted := append([]lb.PoolConfig(nil), pools...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted
+}
+
+// formatAddress 输出后端的 host:port，IPv6地址加方括号
+func formatAddress(b lb.BackendState) string {
+	return net.JoinHostPort(b.Host, strconv.Itoa(b.Port))
+}
+
+// formatTLS 输出后端TLS设置的摘要，未启用时为off
+func formatTLS(t *lb.BackendTLSConfig) string {
+	if t == nil || !t.Enabled {
+		return "off"
+	}
+	parts := []string{"on"}
+	if t.ServerName != "" {
+		parts = append(parts, "server_name="+t.ServerName)
+	}
+	if t.CAFile != "" {
+		parts = append(parts, "ca_file="+t.CAFile)
+	}
+	if t.InsecureSkipVerify {
+		parts = append(parts, "insecure_skip_verify")
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatList 以 [a, b] 的形式输出列表，nil和空列表相同
+// formatVersions 后端池接收的协议版本，nil表示不限制
+func formatVersions(v *protocol.VersionRange) string {
+	if v == nil {
+		return "-"
+	}
+	return v.String()
+}
+
+func formatList(values []string) string {
+	return "[" + strings.Join(values, ", ") + "]"
+}
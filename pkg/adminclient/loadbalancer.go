@@ -0,0 +1,377 @@
+package adminclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+)
+
+// AllClients 负载均衡器汇总的所有健康节点上的客户端
+func (c *Client) AllClients(ctx context.Context) (*GlobalClientList, error) {
+	var list GlobalClientList
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/api/all-clients"}, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// CommandLatency 负载均衡器合并的所有健康节点的指令时延统计，command非空时只统计该指令类型
+func (c *Client) CommandLatency(ctx context.Context, command string) (*lb.FleetCommandLatency, error) {
+	req := &request{method: http.MethodGet, path: "/api/command-latency"}
+	if command != "" {
+		req.query = url.Values{"command": {command}}
+	}
+	var latency lb.FleetCommandLatency
+	if err := c.do(ctx, req, &latency); err != nil {
+		return nil, err
+	}
+	return &latency, nil
+}
+
+// RequestEmergencyStop 发起集群紧急停止的第一步，返回确认令牌和将要关闭的连接数，
+// closeCode为0时使用1013。需在令牌有效期内调用 ConfirmEmergencyStop 才会执行
+func (c *Client) RequestEmergencyStop(ctx context.Context, closeCode int, reason string) (*protocol.EmergencyStopConfirmation, error) {
+	body := protocol.EmergencyStopRequest{CloseCode: closeCode, Reason: reason}
+	var confirmation protocol.EmergencyStopConfirmation
+	if err := c.do(ctx, &request{method: http.MethodPost, path: "/api/emergency-stop", body: body}, &confirmation); err != nil {
+		return nil, err
+	}
+	return &confirmation, nil
+}
+
+// ConfirmEmergencyStop 使用确认令牌执行紧急停止：关闭所有客户端连接，集群暂停接受新连接直到解除
+func (c *Client) ConfirmEmergencyStop(ctx context.Context, confirmToken string) (*lb.FleetEmergencyStop, error) {
+	if confirmToken == "" {
+		return nil, errors.New("confirm_token为必填字段")
+	}
+	body := protocol.EmergencyStopRequest{ConfirmToken: confirmToken}
+	var status lb.FleetEmergencyStop
+	if err := c.do(ctx, &request{method: http.MethodPost, path: "/api/emergency-stop", body: body}, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// LiftEmergencyStop 解除集群紧急停止
+func (c *Client) LiftEmergencyStop(ctx context.Context) (*lb.FleetEmergencyStop, error) {
+	var status lb.FleetEmergencyStop
+	if err := c.do(ctx, &request{method: http.MethodDelete, path: "/api/emergency-stop"}, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// EmergencyStatus 负载均衡器和各节点的紧急停止状态
+func (c *Client) EmergencyStatus(ctx context.Context) (*lb.FleetEmergencyStop, error) {
+	var status lb.FleetEmergencyStop
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/api/emergency-stop"}, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// ShadowStatus 影子流量的复制比例和各影子后端的统计
+func (c *Client) ShadowStatus(ctx context.Context) (*lb.ShadowStatus, error) {
+	var status lb.ShadowStatus
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/api/shadow"}, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// ProtocolVersions 按客户端协议版本统计的转发连接数和各后端支持的版本范围
+func (c *Client) ProtocolVersions(ctx context.Context) (*lb.ProtocolVersionStatus, error) {
+	var status lb.ProtocolVersionStatus
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/api/protocol-versions"}, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// BuildVersions 各后端上报的构建版本，Skewed 为true说明健康后端的版本不一致
+func (c *Client) BuildVersions(ctx context.Context) (*lb.BuildVersionStatus, error) {
+	var status lb.BuildVersionStatus
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/api/build-versions"}, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// RebuildRegistry 按各健康节点当前连接的客户端重建全局注册表，用于注册表文件损坏之后
+func (c *Client) RebuildRegistry(ctx context.Context) (*lb.RegistryRebuild, error) {
+	var result lb.RegistryRebuild
+	if err := c.do(ctx, &request{method: http.MethodPost, path: "/api/global-clients/rebuild"}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ClusterStats 汇总所有节点的客户端数、消息吞吐量、运行时长和内存
+func (c *Client) ClusterStats(ctx context.Context) (*lb.ClusterStats, error) {
+	var stats lb.ClusterStats
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/api/cluster-stats"}, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// ClusterStatus 集群健康状态：健康分数、健康后端占比、连接错误率和注册表滞后
+func (c *Client) ClusterStatus(ctx context.Context) (*lb.ClusterStatus, error) {
+	var status lb.ClusterStatus
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/api/status"}, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Sessions 负载均衡器未过期的会话保持记录，backendID非空时只返回绑定到该后端的会话
+func (c *Client) Sessions(ctx context.Context, backendID string) (*lb.SessionList, error) {
+	req := &request{method: http.MethodGet, path: "/api/sessions"}
+	if backendID != "" {
+		req.query = url.Values{"backend": {backendID}}
+	}
+	var list lb.SessionList
+	if err := c.do(ctx, req, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// ResetSession 删除会话保持记录，disconnect为true时同时强制关闭按该会话转发中的连接（关闭码1012）
+func (c *Client) ResetSession(ctx context.Context, sessionID string, disconnect bool) (*lb.SessionReset, error) {
+	req := &request{method: http.MethodDelete, path: "/api/sessions/" + url.PathEscape(sessionID)}
+	if disconnect {
+		req.query = url.Values{"disconnect": {"true"}}
+	}
+	var result lb.SessionReset
+	if err := c.do(ctx, req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ObserverStatus 只读观察者从主负载均衡器同步状态的情况，对主负载均衡器调用时 Enabled 为false
+func (c *Client) ObserverStatus(ctx context.Context) (*lb.ObserverStatus, error) {
+	var status lb.ObserverStatus
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/api/observer"}, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Backends 负载均衡器的后端列表
+func (c *Client) Backends(ctx context.Context) (*BackendList, error) {
+	var list BackendList
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/api/backends"}, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// Backend 单个后端的健康详情和探测历史，后端不存在时返回的错误满足IsNotFound
+func (c *Client) Backend(ctx context.Context, backendID string) (*BackendDetail, error) {
+	var detail BackendDetail
+	req := &request{method: http.MethodGet, path: "/api/backends/" + url.PathEscape(backendID)}
+	if err := c.do(ctx, req, &detail); err != nil {
+		return nil, err
+	}
+	return &detail, nil
+}
+
+// DrainBackend 将后端标记为排空：不再分配新会话，已建立的连接保持不变。
+// 重复调用不会重置排空进度
+func (c *Client) DrainBackend(ctx context.Context, backendID string) (*lb.DrainStatus, error) {
+	return c.drain(ctx, http.MethodPost, backendID)
+}
+
+// UndrainBackend 结束排空，后端恢复分配新连接
+func (c *Client) UndrainBackend(ctx context.Context, backendID string) (*lb.DrainStatus, error) {
+	return c.drain(ctx, http.MethodDelete, backendID)
+}
+
+// DrainStatus 查询后端的排空进度
+func (c *Client) DrainStatus(ctx context.Context, backendID string) (*lb.DrainStatus, error) {
+	return c.drain(ctx, http.MethodGet, backendID)
+}
+
+// WaitDrained 每隔interval查询一次排空进度，直到后端的连接全部断开或ctx结束。
+// progress非nil时每次查询后回调，用于展示进度
+func (c *Client) WaitDrained(ctx context.Context, backendID string, interval time.Duration, progress func(lb.DrainStatus)) (*lb.DrainStatus, error) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status, err := c.DrainStatus(ctx, backendID)
+		if err != nil {
+			return nil, err
+		}
+		if progress != nil {
+			progress(*status)
+		}
+		if !status.Draining {
+			return status, fmt.Errorf("后端 %s 未处于排空状态", backendID)
+		}
+		if status.Drained {
+			return status, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return status, ctx.Err()
+		}
+	}
+}
+
+func (c *Client) drain(ctx context.Context, method, backendID string) (*lb.DrainStatus, error) {
+	req := &request{
+		method:     method,
+		path:       "/api/backends/" + url.PathEscape(backendID) + "/drain",
+		idempotent: true,
+	}
+	var status lb.DrainStatus
+	if err := c.do(ctx, req, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// ScheduleMaintenance 计划维护窗口，start为零值表示立即开始。
+// 创建请求不支持幂等键，仅在连接失败（请求未发出）时由调用方决定是否重试
+func (c *Client) ScheduleMaintenance(ctx context.Context, backendID string, start, end time.Time, reason string) (*lb.MaintenanceWindow, error) {
+	if backendID == "" {
+		return nil, errors.New("backend_id为必填字段")
+	}
+	body := map[string]interface{}{
+		"backend_id": backendID,
+		"end":        end,
+		"reason":     reason,
+	}
+	if !start.IsZero() {
+		body["start"] = start
+	}
+	var result struct {
+		Window lb.MaintenanceWindow `json:"window"`
+	}
+	if err := c.do(ctx, &request{method: http.MethodPost, path: "/api/maintenance", body: body}, &result); err != nil {
+		return nil, err
+	}
+	return &result.Window, nil
+}
+
+// ListMaintenance 列出维护日历，backendID为空时返回全部后端的窗口
+func (c *Client) ListMaintenance(ctx context.Context, backendID string) ([]lb.MaintenanceWindow, error) {
+	req := &request{method: http.MethodGet, path: "/api/maintenance"}
+	if backendID != "" {
+		req.query = url.Values{"backend_id": {backendID}}
+	}
+	var result struct {
+		Windows []lb.MaintenanceWindow `json:"windows"`
+	}
+	if err := c.do(ctx, req, &result); err != nil {
+		return nil, err
+	}
+	return result.Windows, nil
+}
+
+// CancelMaintenance 取消维护窗口，进行中的窗口会立即恢复后端
+func (c *Client) CancelMaintenance(ctx context.Context, windowID string) (*lb.MaintenanceWindow, error) {
+	req := &request{method: http.MethodDelete, path: "/api/maintenance", query: url.Values{"id": {windowID}}}
+	var result struct {
+		Window lb.MaintenanceWindow `json:"window"`
+	}
+	if err := c.do(ctx, req, &result); err != nil {
+		return nil, err
+	}
+	return &result.Window, nil
+}
+
+// Pools 列出后端池及其策略
+func (c *Client) Pools(ctx context.Context) ([]Pool, error) {
+	var result struct {
+		Pools []Pool `json:"pools"`
+	}
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/api/pools"}, &result); err != nil {
+		return nil, err
+	}
+	return result.Pools, nil
+}
+
+// SetPoolStrategy 运行时修改后端池的策略，name为空表示默认池
+func (c *Client) SetPoolStrategy(ctx context.Context, name string, strategy lb.Strategy) (*Pool, error) {
+	req := &request{
+		method: http.MethodPut,
+		path:   "/api/pools",
+		body:   map[string]interface{}{"name": name, "strategy": strategy},
+	}
+	var result struct {
+		Pool Pool `json:"pool"`
+	}
+	if err := c.do(ctx, req, &result); err != nil {
+		return nil, err
+	}
+	return &result.Pool, nil
+}
+
+// Timeline 查询集群时间线
+func (c *Client) Timeline(ctx context.Context, q TimelineQuery) ([]lb.TimelineEvent, error) {
+	query := url.Values{}
+	if !q.From.IsZero() {
+		query.Set("from", q.From.Format(time.RFC3339))
+	}
+	if !q.To.IsZero() {
+		query.Set("to", q.To.Format(time.RFC3339))
+	}
+	if len(q.Types) > 0 {
+		query.Set("type", strings.Join(q.Types, ","))
+	}
+	if q.BackendID != "" {
+		query.Set("backend_id", q.BackendID)
+	}
+	if q.Limit > 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+	var result struct {
+		Events []lb.TimelineEvent `json:"events"`
+	}
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/api/timeline", query: query}, &result); err != nil {
+		return nil, err
+	}
+	return result.Events, nil
+}
+
+// RecordEvent 向集群时间线上报事件（如部署、配置变更）
+func (c *Client) RecordEvent(ctx context.Context, event lb.TimelineEvent) error {
+	return c.do(ctx, &request{method: http.MethodPost, path: "/api/timeline", body: event}, nil)
+}
+
+// SetAnnotation 设置客户端或后端的运维备注，note为空表示删除；节点和负载均衡器均可调用
+func (c *Client) SetAnnotation(ctx context.Context, target, id, note, updatedBy string) (*registry.Annotation, error) {
+	req := &request{
+		method: http.MethodPost,
+		path:   "/api/annotations",
+		body: map[string]interface{}{
+			"target":     target,
+			"id":         id,
+			"note":       note,
+			"updated_by": updatedBy,
+		},
+	}
+	var result struct {
+		Annotation *registry.Annotation `json:"annotation"`
+	}
+	if err := c.do(ctx, req, &result); err != nil {
+		return nil, err
+	}
+	return result.Annotation, nil
+}
@@ -0,0 +1,333 @@
+package adminclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+	"websocket-loadbalance/server"
+)
+
+// 同步等待指令响应时，HTTP超时在指令超时之外预留的时间
+const commandWaitMargin = 15 * time.Second
+
+// ListClients 列出节点上的在线客户端
+func (c *Client) ListClients(ctx context.Context) (*ClientList, error) {
+	var list ClientList
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/api/clients"}, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// GlobalClients 列出全局注册表中的客户端，节点和负载均衡器均可调用
+func (c *Client) GlobalClients(ctx context.Context) (*GlobalClientList, error) {
+	var list GlobalClientList
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/api/global-clients"}, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// GlobalClientsPage 按客户端ID分页读取全局注册表，节点和负载均衡器均可调用。
+// 下一页以返回的 NextCursor 作为 q.Cursor，NextCursor 为空表示已读完
+func (c *Client) GlobalClientsPage(ctx context.Context, q ClientPageQuery) (*GlobalClientList, error) {
+	query := url.Values{"cursor": {q.Cursor}}
+	if q.Limit > 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Namespace != "" {
+		query.Set("namespace", q.Namespace)
+	}
+	if q.Selector != "" {
+		query.Set("selector", q.Selector)
+	}
+	var list GlobalClientList
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/api/global-clients", query: query}, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// ExportGlobalClients 流式读取全局注册表（GET /api/global-clients/export），按客户端ID顺序
+// 对每条记录调用fn，fn返回错误时停止并返回该错误。q 的 Namespace 和 Selector 过滤导出的客户端，
+// Cursor 和 Limit 不使用。导出不受单次请求超时限制，由ctx控制，中途失败不会重试
+func (c *Client) ExportGlobalClients(ctx context.Context, q ClientPageQuery, fn func(registry.ClientInfo) error) error {
+	req := &request{method: http.MethodGet, path: "/api/global-clients/export", query: url.Values{}}
+	if q.Namespace != "" {
+		req.query.Set("namespace", q.Namespace)
+	}
+	if q.Selector != "" {
+		req.query.Set("selector", q.Selector)
+	}
+	resp, err := c.open(ctx, req, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(resp.Body)
+		return newAPIError(resp.StatusCode, data)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var client registry.ClientInfo
+		if err := decoder.Decode(&client); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("读取导出的注册表失败: %w", err)
+		}
+		if err := fn(client); err != nil {
+			return err
+		}
+	}
+}
+
+// QueryClient 查询客户端是否连接在该节点上
+func (c *Client) QueryClient(ctx context.Context, clientID string) (*QueryResult, error) {
+	var result QueryResult
+	req := &request{method: http.MethodGet, path: "/api/query", query: url.Values{"client_id": {clientID}}}
+	if err := c.do(ctx, req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ClientHistory 客户端最近收发的消息，按时间顺序。客户端在其他节点在线时由节点转发查询
+func (c *Client) ClientHistory(ctx context.Context, clientID string, q HistoryQuery) (*ClientHistory, error) {
+	query := url.Values{}
+	if q.Direction != "" {
+		query.Set("direction", q.Direction)
+	}
+	if q.Since > 0 {
+		query.Set("since", strconv.FormatUint(q.Since, 10))
+	}
+	if q.Limit > 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+	var history ClientHistory
+	req := &request{method: http.MethodGet, path: "/api/clients/" + url.PathEscape(clientID) + "/history", query: query}
+	if err := c.do(ctx, req, &history); err != nil {
+		return nil, err
+	}
+	return &history, nil
+}
+
+// ClientCommands 客户端最近的指令及其响应，按发出顺序，limit 为0时返回节点保留的全部记录。
+// 节点需启用 command_history；客户端在其他节点在线时由节点转发查询
+func (c *Client) ClientCommands(ctx context.Context, clientID string, limit int) (*ClientCommands, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var commands ClientCommands
+	req := &request{method: http.MethodGet, path: "/api/clients/" + url.PathEscape(clientID) + "/commands", query: query}
+	if err := c.do(ctx, req, &commands); err != nil {
+		return nil, err
+	}
+	return &commands, nil
+}
+
+// CommandRecord 按 request_id 查询指令及客户端的响应，节点需启用 command_log（启用 command_delivery 时
+// 也可以查询最近需要确认的指令的投递状态）；记录在其他节点时由收到请求的节点代为查询
+func (c *Client) CommandRecord(ctx context.Context, requestID string) (*server.CommandRecord, error) {
+	var record server.CommandRecord
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/api/commands/" + url.PathEscape(requestID)}, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// FeatureFlags 全部功能开关及其在所连节点上的取值
+func (c *Client) FeatureFlags(ctx context.Context) (*FeatureFlags, error) {
+	var flags FeatureFlags
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/api/flags"}, &flags); err != nil {
+		return nil, err
+	}
+	return &flags, nil
+}
+
+// SetFeatureFlag 创建或更新功能开关，各节点在下一次检查时应用
+func (c *Client) SetFeatureFlag(ctx context.Context, name string, flag registry.FeatureFlag) (*registry.FeatureFlag, error) {
+	req := &request{
+		method: http.MethodPut,
+		path:   "/api/flags/" + url.PathEscape(name),
+		body: map[string]interface{}{
+			"enabled":    flag.Enabled,
+			"percentage": flag.Percentage,
+			"nodes":      flag.Nodes,
+			"updated_by": flag.UpdatedBy,
+		},
+	}
+	var result struct {
+		Flag registry.FeatureFlag `json:"flag"`
+	}
+	if err := c.do(ctx, req, &result); err != nil {
+		return nil, err
+	}
+	return &result.Flag, nil
+}
+
+// DeleteFeatureFlag 删除功能开关，各节点恢复默认值
+func (c *Client) DeleteFeatureFlag(ctx context.Context, name string) error {
+	return c.do(ctx, &request{method: http.MethodDelete, path: "/api/flags/" + url.PathEscape(name)}, nil)
+}
+
+// ClientAliases 客户端ID方案和旧客户端ID的映射
+func (c *Client) ClientAliases(ctx context.Context) (*ClientAliases, error) {
+	var aliases ClientAliases
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/api/client-aliases"}, &aliases); err != nil {
+		return nil, err
+	}
+	return &aliases, nil
+}
+
+// DeleteClientAlias 删除 legacy=map 时记录的映射，旧客户端下次注册时重新处理
+func (c *Client) DeleteClientAlias(ctx context.Context, legacyID string) error {
+	return c.do(ctx, &request{method: http.MethodDelete, path: "/api/client-aliases/" + url.PathEscape(legacyID)}, nil)
+}
+
+// NodeInfo 节点基本信息
+func (c *Client) NodeInfo(ctx context.Context) (*NodeInfo, error) {
+	var info NodeInfo
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/api/node-info"}, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Metrics 节点运行指标，top为返回的内存消耗最大的连接数，0表示服务端默认
+func (c *Client) Metrics(ctx context.Context, top int) (*NodeMetrics, error) {
+	req := &request{method: http.MethodGet, path: "/api/metrics"}
+	if top > 0 {
+		req.query = url.Values{"top": {strconv.Itoa(top)}}
+	}
+	var metrics NodeMetrics
+	if err := c.do(ctx, req, &metrics); err != nil {
+		return nil, err
+	}
+	return &metrics, nil
+}
+
+// SendCommand 向客户端发送指令，客户端可以连接在任意节点上。
+// 请求携带幂等键，超时或网络错误重试时不会重复发送；
+// 服务端返回 success=false 时同时返回结果和 *APIError
+func (c *Client) SendCommand(ctx context.Context, cmd CommandRequest) (*CommandResult, error) {
+	if cmd.ClientID == "" || cmd.Command == "" {
+		return nil, errors.New("client_id和command为必填字段")
+	}
+	cmd.Name, cmd.Selector = "", ""
+
+	var result CommandResult
+	if err := c.do(ctx, commandRequest(cmd), &result); err != nil {
+		return nil, err
+	}
+	if !result.Success {
+		message := result.Error
+		if message == "" {
+			message = result.Message
+		}
+		return &result, &APIError{StatusCode: http.StatusOK, Code: result.Code, Message: message}
+	}
+	return &result, nil
+}
+
+// SendCommandToMatching 向名称匹配 cmd.Name、标签满足 cmd.Selector 的所有客户端发送指令，
+// 返回每个客户端的结果。没有匹配的客户端、选择器无效或部分客户端失败时同时返回结果和 *APIError
+func (c *Client) SendCommandToMatching(ctx context.Context, cmd CommandRequest) (*MatchingCommandResult, error) {
+	if cmd.Command == "" || (cmd.Name == "" && cmd.Selector == "") {
+		return nil, errors.New("command为必填字段，并且需要指定name或selector")
+	}
+	cmd.ClientID = ""
+
+	var result MatchingCommandResult
+	if err := c.do(ctx, commandRequest(cmd), &result); err != nil {
+		return nil, err
+	}
+	if !result.Success {
+		return &result, &APIError{StatusCode: http.StatusOK, Code: "partial_failure",
+			Message: fmt.Sprintf("%d/%d 个客户端的指令失败", result.Failed, result.Matched)}
+	}
+	return &result, nil
+}
+
+// commandRequest 构造 POST /api/send-command 请求，携带幂等键；同步等待时按指令超时延长请求超时
+func commandRequest(cmd CommandRequest) *request {
+	body := struct {
+		CommandRequest
+		Timeout protocol.Duration `json:"timeout,omitempty"`
+	}{CommandRequest: cmd, Timeout: protocol.Duration(cmd.Timeout)}
+
+	req := &request{
+		method:         http.MethodPost,
+		path:           "/api/send-command",
+		body:           body,
+		idempotencyKey: cmd.IdempotencyKey,
+	}
+	if req.idempotencyKey == "" {
+		req.idempotencyKey = newIdempotencyKey()
+	}
+	if cmd.Wait {
+		req.timeout = cmd.Timeout + commandWaitMargin
+		if cmd.Timeout <= 0 {
+			req.timeout = 10*time.Second + commandWaitMargin
+		}
+	}
+	return req
+}
+
+// SendCommandAndWait 发送指令并等待客户端的响应，timeout为0时使用服务端默认的10s
+func (c *Client) SendCommandAndWait(ctx context.Context, clientID, command string, data interface{}, timeout time.Duration) (*CommandResult, error) {
+	return c.SendCommand(ctx, CommandRequest{
+		ClientID: clientID,
+		Command:  command,
+		Data:     data,
+		Wait:     true,
+		Timeout:  timeout,
+	})
+}
+
+// Broadcast 向节点的客户端广播指令，AllNodes为true时同时广播到其他节点
+func (c *Client) Broadcast(ctx context.Context, broadcast BroadcastRequest) (*BroadcastResult, error) {
+	if broadcast.Command == "" {
+		return nil, errors.New("command为必填字段")
+	}
+	req := &request{
+		method:         http.MethodPost,
+		path:           "/api/broadcast",
+		body:           broadcast,
+		idempotencyKey: broadcast.IdempotencyKey,
+	}
+	if req.idempotencyKey == "" {
+		req.idempotencyKey = newIdempotencyKey()
+	}
+	var result BroadcastResult
+	if err := c.do(ctx, req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Publish 向主题发布消息，服务端会转发给其他节点的订阅者。
+// 发布不支持幂等键，失败后不会自动重试
+func (c *Client) Publish(ctx context.Context, topic string, data interface{}) (*PublishResult, error) {
+	req := &request{
+		method: http.MethodPost,
+		path:   "/api/publish",
+		body:   map[string]interface{}{"topic": topic, "data": data},
+	}
+	var result PublishResult
+	if err := c.do(ctx, req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
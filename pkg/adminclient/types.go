@@ -0,0 +1,308 @@
+package adminclient
+
+import (
+	"time"
+
+	"websocket-loadbalance/lb"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+	"websocket-loadbalance/server"
+)
+
+// ClientInfo 节点上的在线客户端
+type ClientInfo struct {
+	ID           string                 `json:"id"`
+	Name         string                 `json:"name"`
+	Namespace    string                 `json:"namespace"`
+	ConnTime     time.Time              `json:"conn_time"`
+	LastSeen     time.Time              `json:"last_seen"`
+	IsActive     bool                   `json:"is_active"`
+	Status       string                 `json:"status"`
+	Annotation   *registry.Annotation   `json:"annotation,omitempty"`
+	Quota        *server.QuotaUsage     `json:"quota,omitempty"`
+	Subject      string                 `json:"subject,omitempty"`
+	Claims       map[string]interface{} `json:"claims,omitempty"`
+	Capabilities *registry.Capabilities `json:"capabilities,omitempty"`
+	Labels       map[string]string      `json:"labels,omitempty"`
+	Affinity     string                 `json:"affinity,omitempty"` // 注册时声明的节点亲和性
+	Latency      *server.LatencyStats   `json:"latency,omitempty"`
+	Topics       []string               `json:"topics,omitempty"`
+	Commands     *server.CommandStats   `json:"commands,omitempty"`
+	Encoding     string                 `json:"encoding"`
+	RemoteAddr   string                 `json:"remote_addr"`
+}
+
+// ClientList GET /api/clients 的响应
+type ClientList struct {
+	NodeID  string       `json:"node_id"`
+	Total   int          `json:"total"`
+	Clients []ClientInfo `json:"clients"`
+}
+
+// GlobalClientList 全局注册表中的客户端，来自节点或负载均衡器的 /api/global-clients
+// 以及负载均衡器的 /api/all-clients
+type GlobalClientList struct {
+	CurrentNode string                `json:"current_node,omitempty"` // 节点返回
+	Source      string                `json:"source,omitempty"`       // 负载均衡器返回
+	Total       int                   `json:"total"`
+	Clients     []registry.ClientInfo `json:"clients"`
+	// 分页读取时本页的条数和下一页的游标，游标为空表示已读完
+	Count      int    `json:"count,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ClientPageQuery 分页读取全局注册表的条件
+type ClientPageQuery struct {
+	Cursor    string // 上一页的 NextCursor，为空表示第一页
+	Limit     int    // 每页条数，0表示服务端默认500，最多5000
+	Namespace string // 只返回该命名空间的客户端
+	Selector  string // 只返回标签满足该选择器的客户端，如 "env=prod,role in (pos)"
+}
+
+// QueryResult GET /api/query 的响应
+type QueryResult struct {
+	Found   bool        `json:"found"`
+	NodeID  string      `json:"node_id"`
+	Client  *ClientInfo `json:"client,omitempty"`
+	Message string      `json:"message,omitempty"`
+}
+
+// ClientHistory GET /api/clients/{id}/history 的响应
+type ClientHistory struct {
+	ClientID string                `json:"client_id"`
+	Node     string                `json:"node"` // 保存这些记录的节点
+	Online   bool                  `json:"online"`
+	Capacity int                   `json:"capacity"` // 每个客户端保留的记录条数
+	LastSeq  uint64                `json:"last_seq"` // 最后一条记录的序号，可作为下次查询的 Since
+	Total    int                   `json:"total"`
+	Entries  []server.HistoryEntry `json:"entries"`
+}
+
+// ClientCommands GET /api/clients/{id}/commands 的响应
+type ClientCommands struct {
+	ClientID     string                 `json:"client_id"`
+	Node         string                 `json:"node"` // 保存这些记录的节点
+	Online       bool                   `json:"online"`
+	Capacity     int                    `json:"capacity"` // 每个客户端保留的指令数
+	Total        int                    `json:"total"`
+	Commands     []server.CommandRecord `json:"commands"`
+	LastResponse *server.CommandRecord  `json:"last_response"` // 最近一条收到响应的指令，没有时为nil
+}
+
+// FeatureFlags GET /api/flags 的响应
+type FeatureFlags struct {
+	Node      string                          `json:"node"`
+	Flags     map[string]registry.FeatureFlag `json:"flags"`
+	Effective map[string]bool                 `json:"effective"` // 内置和已设置的功能开关在该节点的取值
+	Builtin   []string                        `json:"builtin"`
+}
+
+// ClientAliases GET /api/client-aliases 的响应
+type ClientAliases struct {
+	Scheme  string               `json:"scheme"`
+	Legacy  string               `json:"legacy"`
+	Total   int                  `json:"total"`
+	Aliases []server.ClientAlias `json:"aliases"`
+}
+
+// HistoryQuery 消息记录的查询条件，零值表示不限制
+type HistoryQuery struct {
+	Direction string // server.HistoryInbound 或 server.HistoryOutbound
+	Since     uint64 // 只返回序号更大的记录
+	Limit     int    // 只返回最后若干条
+}
+
+// NodeInfo GET /api/node-info 的响应
+type NodeInfo struct {
+	NodeID        string          `json:"node_id"`
+	Port          int             `json:"port"`
+	Clients       int             `json:"clients"`
+	Status        string          `json:"status"`
+	StartTime     string          `json:"start_time"`
+	UptimeSeconds int64           `json:"uptime_seconds"`
+	Messages      lb.NodeMessages `json:"messages"`
+	Memory        lb.NodeMemory   `json:"memory"`
+}
+
+// CommandRequest 向客户端发送指令
+type CommandRequest struct {
+	ClientID string `json:"client_id,omitempty"`
+	// 未指定ClientID时按客户端名称的通配符（如 "收银台-*"）和/或标签选择器（如 "env=prod,role in (pos)"）选择目标，
+	// 由 SendCommandToMatching 使用
+	Name     string      `json:"name,omitempty"`
+	Selector string      `json:"selector,omitempty"`
+	Command  string      `json:"command"`
+	Data     interface{} `json:"data,omitempty"`
+	// 同步等待客户端的响应
+	Wait bool `json:"wait,omitempty"`
+	// 发送方的命名空间，为空时为默认命名空间；目标客户端属于其他命名空间会被拒绝 (namespace_mismatch)
+	Namespace string `json:"namespace,omitempty"`
+	// 同步等待的超时，0表示服务端默认10s，最长60s
+	Timeout time.Duration `json:"-"`
+	// 幂等键，为空时自动生成，重试时不会重复发送指令
+	IdempotencyKey string `json:"-"`
+}
+
+// CommandResult POST /api/send-command 的响应
+type CommandResult struct {
+	Success       bool                    `json:"success"`
+	Node          string                  `json:"node"`
+	Message       string                  `json:"message"`
+	Error         string                  `json:"error,omitempty"`
+	Code          string                  `json:"code,omitempty"`
+	RequestID     string                  `json:"request_id,omitempty"`
+	Queued        bool                    `json:"queued,omitempty"`
+	QueuePosition int                     `json:"queue_position,omitempty"`
+	QueuedOffline bool                    `json:"queued_offline,omitempty"` // 客户端不在线，指令已存入离线队列
+	OutboxDepth   int                     `json:"outbox_depth,omitempty"`
+	ExpiresAt     int64                   `json:"expires_at,omitempty"` // 离线指令的过期时间（Unix秒）
+	Response      *server.CommandResponse `json:"response,omitempty"`   // 同步模式下客户端的响应
+}
+
+// TargetResult 按名称或标签选择发送指令时单个客户端的结果，Status为该客户端单独处理时的HTTP状态码
+type TargetResult struct {
+	CommandResult
+	Status int `json:"status"`
+}
+
+// MatchingCommandResult 按名称或标签选择发送指令的响应，Results按客户端ID索引
+type MatchingCommandResult struct {
+	Success   bool                    `json:"success"`
+	Matched   int                     `json:"matched"`
+	Succeeded int                     `json:"succeeded"`
+	Failed    int                     `json:"failed"`
+	Results   map[string]TargetResult `json:"results"`
+}
+
+// BroadcastRequest 向节点的客户端广播指令
+type BroadcastRequest struct {
+	Command string      `json:"command"`
+	Data    interface{} `json:"data,omitempty"`
+	// 同时广播到其他节点的客户端
+	AllNodes bool `json:"all_nodes,omitempty"`
+	// 只广播给该命名空间的客户端，为空时为默认命名空间
+	Namespace string `json:"namespace,omitempty"`
+	// 幂等键，为空时自动生成
+	IdempotencyKey string `json:"-"`
+}
+
+// BroadcastResult POST /api/broadcast 的响应
+type BroadcastResult struct {
+	Success     bool     `json:"success"`
+	Node        string   `json:"node"`
+	Sent        int      `json:"sent"`
+	Failed      int      `json:"failed"`
+	Skipped     int      `json:"skipped"`
+	ForwardedTo []string `json:"forwarded_to,omitempty"`
+}
+
+// PublishResult POST /api/publish 的响应
+type PublishResult struct {
+	Success   bool   `json:"success"`
+	Node      string `json:"node"`
+	Topic     string `json:"topic"`
+	Namespace string `json:"namespace"`
+	Delivered int    `json:"delivered"`
+}
+
+// NodeMetrics GET /api/metrics 的响应，各子系统的统计保持服务端的原始结构
+type NodeMetrics struct {
+	NodeID       string                 `json:"node_id"`
+	Clients      int                    `json:"clients"`
+	Broadcast    map[string]interface{} `json:"broadcast"`
+	Memory       map[string]interface{} `json:"memory"`
+	SlowConsumer map[string]interface{} `json:"slow_consumer"`
+	Admission    map[string]interface{} `json:"admission"`
+	RateLimit    map[string]interface{} `json:"rate_limit"`
+	Latency      *server.LatencyStats   `json:"latency"`
+	Commands     map[string]interface{} `json:"commands"`
+	Bus          map[string]interface{} `json:"bus"`
+	Idempotency  map[string]interface{} `json:"idempotency"`
+	Outbox       map[string]interface{} `json:"outbox"`
+	Delivery     map[string]interface{} `json:"delivery"` // 指令确认与重发的统计
+	// 按指令类型统计的从受理到客户端响应的时延
+	CommandLatency *server.CommandLatencyReport `json:"command_latency"`
+	// 追踪span的导出统计，未启用追踪时为nil
+	Tracing map[string]interface{} `json:"tracing"`
+	// 节点的紧急停止状态
+	EmergencyStop *protocol.EmergencyStop `json:"emergency_stop"`
+	// 连接最长存活时间及回收统计
+	ConnectionLifetime map[string]interface{} `json:"connection_lifetime"`
+	// 全局注册表客户端记录的写回统计
+	RegistryPersistence map[string]interface{} `json:"registry_persistence"`
+}
+
+// Backend 负载均衡器的后端状态，来自 GET /api/backends
+type Backend struct {
+	ID                  string               `json:"id"`
+	Address             string               `json:"address"`
+	HTTPAddress         string               `json:"http_address"`
+	Connections         int                  `json:"connections"`
+	IsHealthy           bool                 `json:"is_healthy"`
+	InMaintenance       bool                 `json:"in_maintenance"`
+	Draining            bool                 `json:"draining"`
+	LastCheck           string               `json:"last_check"`
+	LastError           string               `json:"last_error"`
+	ConsecutiveFailures int                  `json:"consecutive_failures"`
+	FlapScore           float64              `json:"flap_score"`
+	HoldDown            bool                 `json:"hold_down"`
+	ReportedClients     int                  `json:"reported_clients"`
+	MaxClients          int                  `json:"max_clients"`
+	BuildVersion        string               `json:"build_version"` // 后端 /health 上报的构建版本，未上报时为空
+	Labels              map[string]string    `json:"labels"`        // 后端 /health 上报的节点标签，affinity 策略据此选择后端
+	Weight              int                  `json:"weight"`
+	Discovered          bool                 `json:"discovered"`
+	Registered          bool                 `json:"registered"`
+	Annotation          *registry.Annotation `json:"annotation,omitempty"`
+}
+
+// BackendList GET /api/backends 的响应
+type BackendList struct {
+	Strategy string    `json:"strategy"`
+	Total    int       `json:"total"`
+	Backends []Backend `json:"backends"`
+}
+
+// BackendDetail GET /api/backends/{id} 的响应，包含健康探测历史
+type BackendDetail struct {
+	ID                   string            `json:"id"`
+	Address              string            `json:"address"`
+	HTTPAddress          string            `json:"http_address"`
+	Connections          int               `json:"connections"`
+	IsHealthy            bool              `json:"is_healthy"`
+	InMaintenance        bool              `json:"in_maintenance"`
+	Drain                lb.DrainStatus    `json:"drain"`
+	LastCheck            time.Time         `json:"last_check"`
+	LastError            string            `json:"last_error"`
+	ConsecutiveSuccesses int               `json:"consecutive_successes"`
+	ConsecutiveFailures  int               `json:"consecutive_failures"`
+	FlapScore            float64           `json:"flap_score"`
+	FlapThreshold        float64           `json:"flap_threshold"`
+	HoldDown             bool              `json:"hold_down"`
+	HoldDownUntil        *time.Time        `json:"hold_down_until,omitempty"`
+	Labels               map[string]string `json:"labels"`
+	History              []lb.ProbeResult  `json:"history"`
+}
+
+// Pool 后端池及其策略，来自 GET /api/pools
+type Pool struct {
+	Name        string      `json:"name"`
+	PathPrefix  string      `json:"path_prefix"`
+	Strategy    lb.Strategy `json:"strategy"`
+	Sticky      bool        `json:"sticky"`
+	Backends    []string    `json:"backends"`
+	Connections int         `json:"connections"`
+	// 池接收的客户端协议版本，nil表示不限制
+	ProtocolVersions *protocol.VersionRange `json:"protocol_versions,omitempty"`
+	// 池单独的健康检查方式，nil表示使用全局设置
+	HealthCheck *lb.PoolHealthCheck `json:"health_check,omitempty"`
+}
+
+// TimelineQuery 查询集群时间线的条件，零值表示不限制
+type TimelineQuery struct {
+	From      time.Time
+	To        time.Time
+	Types     []string // 事件类型，如 lb.EventBackendDown
+	BackendID string
+	Limit     int
+}
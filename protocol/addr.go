@@ -0,0 +1,144 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 地址族：监听时只绑定该地址族，拨号时优先使用该地址族的地址，失败后再尝试其他地址
+const (
+	AddressFamilyDual = "dual" // 默认：双栈监听，拨号按解析顺序
+	AddressFamilyIPv4 = "ipv4"
+	AddressFamilyIPv6 = "ipv6"
+)
+
+// ValidateAddressFamily 校验地址族配置，空字符串等同于dual
+func ValidateAddressFamily(family string) error {
+	switch family {
+	case "", AddressFamilyDual, AddressFamilyIPv4, AddressFamilyIPv6:
+		return nil
+	}
+	return fmt.Errorf("无效的地址族: %s (可选: dual, ipv4, ipv6)", family)
+}
+
+// TrimHostBrackets 去掉IPv6字面量外的方括号，配置中 "[::1]" 与 "::1" 等价
+func TrimHostBrackets(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// IsLoopbackHost 主机是否为本机回环地址（localhost 或 127.0.0.0/8、::1）
+func IsLoopbackHost(host string) bool {
+	host = TrimHostBrackets(host)
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// LocalHost 同一主机上的其他进程连接该监听地址时使用的主机：指定了具体监听地址时使用该地址，
+// 通配地址或未指定时使用对应地址族的回环地址
+func LocalHost(listenAddress, family string) string {
+	host := TrimHostBrackets(listenAddress)
+	ip := net.ParseIP(host)
+	if host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return host
+	}
+	switch {
+	case family == AddressFamilyIPv6:
+		return "::1"
+	case family == AddressFamilyIPv4 || (ip != nil && ip.To4() != nil):
+		return "127.0.0.1"
+	}
+	return "localhost"
+}
+
+// URLHost 返回URL中使用的主机部分，IPv6字面量加方括号
+func URLHost(host string) string {
+	host = TrimHostBrackets(host)
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// SplitHostPort 解析 host:port 形式的完整地址，IPv6字面量加方括号，如 "[::]:8080"、"0.0.0.0:8081"、
+// "node1.internal:8081"；主机为空（":8080"）表示所有地址
+func SplitHostPort(address string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, fmt.Errorf("无效的地址 %q: %v", address, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, fmt.Errorf("无效的地址 %q: 端口必须在1到65535之间", address)
+	}
+	return host, port, nil
+}
+
+// Listen 在host:port上监听TCP连接。host为空表示所有地址；
+// 地址族为ipv4或ipv6时只绑定该地址族（ipv6的通配地址不接受IPv4映射连接），dual时按系统默认双栈监听
+func Listen(family, host string, port int) (net.Listener, error) {
+	network := "tcp"
+	switch family {
+	case AddressFamilyIPv4:
+		network = "tcp4"
+	case AddressFamilyIPv6:
+		network = "tcp6"
+	}
+	return net.Listen(network, net.JoinHostPort(TrimHostBrackets(host), strconv.Itoa(port)))
+}
+
+// DialContextFunc 返回按地址族偏好拨号的函数，可用于 http.Transport.DialContext 和
+// websocket.Dialer.NetDialContext。dual时返回系统默认的拨号方式
+func DialContextFunc(family string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if family != AddressFamilyIPv4 && family != AddressFamilyIPv6 {
+		return dialer.DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		// 优先的地址族排在前面，同一地址族内保持解析顺序
+		sort.SliceStable(ips, func(i, j int) bool {
+			return isFamily(ips[i].IP, family) && !isFamily(ips[j].IP, family)
+		})
+		var lastErr error
+		for _, ip := range ips {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		if lastErr == nil {
+			lastErr = errors.New("没有解析到地址: " + host)
+		}
+		return nil, lastErr
+	}
+}
+
+func isFamily(ip net.IP, family string) bool {
+	if family == AddressFamilyIPv4 {
+		return ip.To4() != nil
+	}
+	return ip.To4() == nil
+}
@@ -0,0 +1,20 @@
+package protocol
+
+// BackendRegistration 节点通过 POST /api/backends 向负载均衡器登记自己，之后定期重复提交作为心跳
+type BackendRegistration struct {
+	ID         string `json:"id"`
+	Host       string `json:"host,omitempty"`        // 负载均衡器连接节点使用的地址，为空时取请求的来源地址
+	Port       int    `json:"port"`                  // 节点的HTTP和WebSocket端口
+	Weight     int    `json:"weight,omitempty"`      // 默认1
+	MaxClients int    `json:"max_clients,omitempty"` // 节点的最大连接数，0表示不限制，健康检查上报后以上报值为准
+}
+
+// BackendRegistrationResponse 登记的响应：节点应按 heartbeat_interval 重复登记，超过 ttl 未登记时被移除
+type BackendRegistrationResponse struct {
+	Success           bool     `json:"success"`
+	ID                string   `json:"id"`
+	Address           string   `json:"address"` // 负载均衡器连接节点使用的HTTP地址
+	Created           bool     `json:"created"` // 本次登记新增了后端（首次登记或租约过期后重新登记）
+	TTL               Duration `json:"ttl"`
+	HeartbeatInterval Duration `json:"heartbeat_interval"`
+}
@@ -0,0 +1,58 @@
+package protocol
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BuildVersion 程序的构建版本，节点在 /health 中上报，负载均衡器据此发现未完成的滚动升级。
+// 发布构建时通过 -ldflags "-X websocket-loadbalance/protocol.BuildVersion=v1.4.2" 设置
+var BuildVersion = "dev"
+
+// ParseBuildVersion 解析形如 v1.4.2、1.4、v2.0.0-rc.1+abc 的构建版本，返回主、次、修订号以及预发布标识，
+// 缺少的部分视为0，构建元数据（+之后）被忽略
+func ParseBuildVersion(version string) (parts [3]int, prerelease string, err error) {
+	v := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	v, _, _ = strings.Cut(v, "+")
+	v, prerelease, _ = strings.Cut(v, "-")
+	fields := strings.Split(v, ".")
+	if v == "" || len(fields) > 3 {
+		return parts, "", fmt.Errorf("无法解析的构建版本: %q", version)
+	}
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return parts, "", fmt.Errorf("无法解析的构建版本: %q", version)
+		}
+		parts[i] = n
+	}
+	return parts, prerelease, nil
+}
+
+// CompareBuildVersions 比较两个构建版本，a较旧时返回负数、相同时返回0、较新时返回正数。
+// 预发布版本旧于同号的正式版本；任一版本无法解析（如 dev）时ok为false
+func CompareBuildVersions(a, b string) (result int, ok bool) {
+	pa, prea, errA := ParseBuildVersion(a)
+	pb, preb, errB := ParseBuildVersion(b)
+	if errA != nil || errB != nil {
+		return 0, false
+	}
+	for i := range pa {
+		if pa[i] != pb[i] {
+			if pa[i] < pb[i] {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	switch {
+	case prea == preb:
+		return 0, true
+	case prea == "":
+		return 1, true
+	case preb == "":
+		return -1, true
+	}
+	return strings.Compare(prea, preb), true
+}
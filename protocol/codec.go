@@ -0,0 +1,75 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 消息编码，同时也是握手时 Sec-WebSocket-Protocol 中的子协议名称
+const (
+	EncodingJSON     = "json"     // 文本帧，默认
+	EncodingMsgpack  = "msgpack"  // 二进制帧，MessagePack
+	EncodingProtobuf = "protobuf" // 二进制帧，google.protobuf.Value
+)
+
+// Codec 二进制消息编码。消息结构与JSON模式完全相同（Message、Response、指令、发布订阅等），
+// 只是换成二进制帧传输，因此编码以与JSON互相转换的方式实现，服务端的消息处理无需区分编码
+type Codec interface {
+	// Name 编码名称，即协商使用的子协议
+	Name() string
+	// FromJSON 将一条JSON消息编码为二进制帧
+	FromJSON(data []byte) ([]byte, error)
+	// ToJSON 将二进制帧解码为JSON消息
+	ToJSON(data []byte) ([]byte, error)
+}
+
+var codecs = map[string]Codec{
+	EncodingMsgpack:  msgpackCodec{},
+	EncodingProtobuf: protobufCodec{},
+}
+
+// CodecFor 按名称返回编码，json或空字符串返回nil（使用文本帧）
+func CodecFor(encoding string) (Codec, error) {
+	if encoding == "" || encoding == EncodingJSON {
+		return nil, nil
+	}
+	if codec, ok := codecs[encoding]; ok {
+		return codec, nil
+	}
+	return nil, fmt.Errorf("不支持的消息编码: %s（可选 json、msgpack、protobuf）", encoding)
+}
+
+// NegotiateCodec 按客户端在子协议中列出的顺序选择第一个支持的二进制编码，没有时返回nil
+func NegotiateCodec(offered []string) Codec {
+	for _, name := range offered {
+		if name == EncodingJSON {
+			return nil
+		}
+		if codec, ok := codecs[name]; ok {
+			return codec
+		}
+	}
+	return nil
+}
+
+// maxCodecDepth 解码时允许的最大嵌套层数
+const maxCodecDepth = 1000
+
+var errCodecDepth = errors.New("消息嵌套层数过多")
+
+// decodeJSONValue 解析一条JSON消息，数字保留为json.Number以便按整数或浮点数编码
+func decodeJSONValue(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("JSON消息之后存在多余的数据")
+	}
+	return value, nil
+}
@@ -0,0 +1,9 @@
+package protocol
+
+// 指令确认消息类型（消息的 type 字段）。节点启用 command_delivery 后，向注册时声明 "ack": true 的客户端
+// 下发的指令带有 delivery_id 和 attempt（第几次发送），客户端需回复 ack 或 nack，否则指令按退避间隔重发。
+// 重发的指令 delivery_id 不变，客户端应据此去重；command_response 同时视为确认
+const (
+	TypeAck  = "ack"  // 确认收到指令: {"type": "ack", "delivery_id": "..."}
+	TypeNack = "nack" // 暂时无法处理: {"type": "nack", "delivery_id": "...", "reason": "...", "requeue": true}，requeue 为 false 时不再重发
+)
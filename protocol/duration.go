@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Duration 支持 "10s"、"1m30s" 这类字符串的时长类型，用于配置文件
+type Duration time.Duration
+
+// UnmarshalJSON 解析JSON中的时长（字符串或纳秒数字）
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	return d.set(raw)
+}
+
+// UnmarshalYAML 解析YAML中的时长
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var raw interface{}
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+	return d.set(raw)
+}
+
+// MarshalJSON 以字符串形式输出时长
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) set(raw interface{}) error {
+	switch v := raw.(type) {
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("无效的时长 %q: %v", v, err)
+		}
+		*d = Duration(parsed)
+	case float64:
+		*d = Duration(time.Duration(v))
+	case int:
+		*d = Duration(time.Duration(v))
+	default:
+		return fmt.Errorf("无效的时长类型: %v", raw)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package protocol
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultEmergencyCloseCode 紧急停止默认使用的关闭码（1013 Try Again Later）
+const DefaultEmergencyCloseCode = 1013
+
+// EmergencyConfirmTTL 紧急停止确认令牌的有效期
+const EmergencyConfirmTTL = time.Minute
+
+// 关闭帧的载荷最多125字节，其中2字节为关闭码
+const maxCloseReasonBytes = 123
+
+// EmergencyStop 紧急停止状态：生效时已关闭所有客户端连接，并拒绝新连接直到解除
+type EmergencyStop struct {
+	Active    bool       `json:"active"`
+	CloseCode int        `json:"close_code,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	Since     *time.Time `json:"since,omitempty"`     // 生效时间
+	LiftedAt  *time.Time `json:"lifted_at,omitempty"` // 最近一次解除的时间
+	Closed    int        `json:"closed"`              // 生效时关闭的连接数
+	Rejected  int64      `json:"rejected"`            // 生效期间拒绝的新连接数
+}
+
+// EmergencyStopRequest POST /api/emergency-stop 的请求。
+// 不带 confirm_token 时只返回确认令牌，在有效期内带上令牌再次提交才会执行，执行时以第一次请求的参数为准
+type EmergencyStopRequest struct {
+	CloseCode    int    `json:"close_code"` // 默认1013
+	Reason       string `json:"reason"`
+	ConfirmToken string `json:"confirm_token,omitempty"`
+}
+
+// Normalize 填充默认关闭码并校验参数
+func (r *EmergencyStopRequest) Normalize() error {
+	if r.CloseCode == 0 {
+		r.CloseCode = DefaultEmergencyCloseCode
+	}
+	if err := ValidateCloseCode(r.CloseCode); err != nil {
+		return err
+	}
+	if len(r.Reason) > maxCloseReasonBytes {
+		return fmt.Errorf("reason 最长 %d 字节（关闭帧的长度限制）", maxCloseReasonBytes)
+	}
+	return nil
+}
+
+// EmergencyStopConfirmation 第一次请求的响应，提示即将产生的影响
+type EmergencyStopConfirmation struct {
+	ConfirmationRequired bool      `json:"confirmation_required"`
+	ConfirmToken         string    `json:"confirm_token"`
+	ExpiresAt            time.Time `json:"expires_at"`
+	CloseCode            int       `json:"close_code"`
+	Reason               string    `json:"reason"`
+	Connections          int       `json:"connections"` // 当前将被关闭的连接数
+	Message              string    `json:"message"`
+}
+
+// ValidateCloseCode 校验可以由应用发送的WebSocket关闭码（RFC 6455 7.4）
+func ValidateCloseCode(code int) error {
+	switch {
+	case code == 1000 || code == 1001 || code == 1002 || code == 1003:
+	case code >= 1007 && code <= 1014:
+	case code >= 3000 && code <= 4999:
+	default:
+		return fmt.Errorf("无效的关闭码 %d：可用 1000-1003、1007-1014 或 3000-4999", code)
+	}
+	return nil
+}
+
+// EmergencyConfirmations 紧急停止的二次确认令牌，令牌只能使用一次
+type EmergencyConfirmations struct {
+	mu      sync.Mutex
+	pending map[string]emergencyConfirmation
+}
+
+type emergencyConfirmation struct {
+	request   EmergencyStopRequest
+	expiresAt time.Time
+}
+
+// Issue 为已校验的请求签发确认令牌
+func (c *EmergencyConfirmations) Issue(req EmergencyStopRequest) (string, time.Time) {
+	b := make([]byte, 16)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	now := time.Now()
+	expiresAt := now.Add(EmergencyConfirmTTL)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending == nil {
+		c.pending = make(map[string]emergencyConfirmation)
+	}
+	for t, pending := range c.pending {
+		if now.After(pending.expiresAt) {
+			delete(c.pending, t)
+		}
+	}
+	req.ConfirmToken = ""
+	c.pending[token] = emergencyConfirmation{request: req, expiresAt: expiresAt}
+	return token, expiresAt
+}
+
+// Redeem 使用确认令牌，返回签发时的请求；令牌不存在或已过期时返回false
+func (c *EmergencyConfirmations) Redeem(token string) (EmergencyStopRequest, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pending, ok := c.pending[token]
+	if !ok {
+		return EmergencyStopRequest{}, false
+	}
+	delete(c.pending, token)
+	if time.Now().After(pending.expiresAt) {
+		return EmergencyStopRequest{}, false
+	}
+	return pending.request, true
+}
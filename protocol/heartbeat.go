@@ -0,0 +1,12 @@
+package protocol
+
+import "time"
+
+// 心跳消息类型（消息的 type 字段）
+const (
+	TypeHeartbeat    = "heartbeat"     // 客户端定期发送，可带 status: online 或 busy
+	TypeHeartbeatAck = "heartbeat_ack" // 服务端的确认，interval 为服务端期望的心跳间隔（秒）
+)
+
+// DefaultHeartbeatInterval 默认的客户端心跳间隔
+const DefaultHeartbeatInterval = 10 * time.Second
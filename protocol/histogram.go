@@ -0,0 +1,107 @@
+package protocol
+
+import "math"
+
+// LatencyBucketsMs 时延直方图各桶的上界（毫秒），超过最后一个上界的样本计入溢出桶
+var LatencyBucketsMs = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000}
+
+// LatencyHistogram 指令时延直方图。桶的边界固定，多个节点的直方图可以直接合并，
+// 百分位按所在桶的上界估算
+type LatencyHistogram struct {
+	Count    int64   `json:"count"`    // 收到响应的指令数
+	Errors   int64   `json:"errors"`   // 其中客户端返回失败的指令数
+	Timeouts int64   `json:"timeouts"` // 超时未收到响应的指令数
+	SumMs    float64 `json:"sum_ms"`
+	MinMs    float64 `json:"min_ms"`
+	MaxMs    float64 `json:"max_ms"`
+	AvgMs    float64 `json:"avg_ms"`
+	P50Ms    float64 `json:"p50_ms"`
+	P90Ms    float64 `json:"p90_ms"`
+	P99Ms    float64 `json:"p99_ms"`
+	Buckets  []int64 `json:"buckets"` // 与 LatencyBucketsMs 一一对应的计数（非累计），最后一个为溢出桶
+}
+
+// NewLatencyHistogram 创建空的直方图
+func NewLatencyHistogram() *LatencyHistogram {
+	return &LatencyHistogram{Buckets: make([]int64, len(LatencyBucketsMs)+1)}
+}
+
+// Observe 记录一次响应的时延，failed表示客户端返回了失败
+func (h *LatencyHistogram) Observe(ms float64, failed bool) {
+	if h.Count == 0 || ms < h.MinMs {
+		h.MinMs = ms
+	}
+	if ms > h.MaxMs {
+		h.MaxMs = ms
+	}
+	h.Count++
+	h.SumMs += ms
+	if failed {
+		h.Errors++
+	}
+	i := 0
+	for i < len(LatencyBucketsMs) && ms > LatencyBucketsMs[i] {
+		i++
+	}
+	h.Buckets[i]++
+}
+
+// Merge 将other的计数合并到h，桶数不一致（版本不同的节点）时只合并计数
+func (h *LatencyHistogram) Merge(other *LatencyHistogram) {
+	if other == nil {
+		return
+	}
+	if other.Count > 0 {
+		if h.Count == 0 || other.MinMs < h.MinMs {
+			h.MinMs = other.MinMs
+		}
+		if other.MaxMs > h.MaxMs {
+			h.MaxMs = other.MaxMs
+		}
+	}
+	h.Count += other.Count
+	h.Errors += other.Errors
+	h.Timeouts += other.Timeouts
+	h.SumMs += other.SumMs
+	if len(other.Buckets) == len(h.Buckets) {
+		for i, n := range other.Buckets {
+			h.Buckets[i] += n
+		}
+	}
+}
+
+// Clone 复制直方图
+func (h *LatencyHistogram) Clone() *LatencyHistogram {
+	clone := *h
+	clone.Buckets = append([]int64(nil), h.Buckets...)
+	return &clone
+}
+
+// Summarize 根据计数计算平均值和百分位
+func (h *LatencyHistogram) Summarize() *LatencyHistogram {
+	if h.Count == 0 {
+		h.AvgMs, h.P50Ms, h.P90Ms, h.P99Ms = 0, 0, 0, 0
+		return h
+	}
+	h.AvgMs = math.Round(h.SumMs/float64(h.Count)*100) / 100
+	h.SumMs = math.Round(h.SumMs*100) / 100
+	h.MinMs = math.Round(h.MinMs*100) / 100
+	h.MaxMs = math.Round(h.MaxMs*100) / 100
+	h.P50Ms = h.percentile(0.50)
+	h.P90Ms = h.percentile(0.90)
+	h.P99Ms = h.percentile(0.99)
+	return h
+}
+
+// percentile 返回第p百分位样本所在桶的上界，不超过最大值
+func (h *LatencyHistogram) percentile(p float64) float64 {
+	rank := int64(math.Ceil(p * float64(h.Count)))
+	var seen int64
+	for i, n := range h.Buckets {
+		seen += n
+		if seen >= rank && i < len(LatencyBucketsMs) {
+			return math.Min(LatencyBucketsMs[i], h.MaxMs)
+		}
+	}
+	return h.MaxMs
+}
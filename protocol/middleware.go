@@ -0,0 +1,38 @@
+package protocol
+
+// 消息经过中间件的方向，相对于安装中间件的一端
+const (
+	DirectionInbound  = "in"  // 收到的消息
+	DirectionOutbound = "out" // 发出的消息
+)
+
+// Frame 经过中间件的一条消息。Data 为JSON消息，msgpack/protobuf 等二进制编码在中间件之外转换；
+// 中间件可以替换 Data（如加解密），替换后的内容仍应是JSON，以便对端解析和二进制编码转换
+type Frame struct {
+	Direction string
+	ClientID  string // 消息所属的客户端，服务端读取注册消息时为空
+	Data      []byte
+}
+
+// FrameHandler 处理一条消息：出站时写到连接，入站时交给消息处理
+type FrameHandler func(f *Frame) error
+
+// Middleware 包装消息处理，用于加解密、指标、日志等横切逻辑。
+// 不调用 next 即丢弃该消息；出站时返回的错误作为写入失败返回给发送方，入站时消息被丢弃并记录日志
+type Middleware func(next FrameHandler) FrameHandler
+
+// Chain 将中间件组合到 final 之外。出站消息按添加顺序经过各中间件后写出，入站消息按相反顺序经过，
+// 因此客户端和服务端以相同顺序添加同一组中间件即可对称工作，先添加的中间件看到的总是明文
+func Chain(direction string, final FrameHandler, middleware []Middleware) FrameHandler {
+	h := final
+	if direction == DirectionOutbound {
+		for i := len(middleware) - 1; i >= 0; i-- {
+			h = middleware[i](h)
+		}
+		return h
+	}
+	for _, mw := range middleware {
+		h = mw(h)
+	}
+	return h
+}
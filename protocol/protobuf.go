@@ -0,0 +1,235 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// protobufCodec 以 google.protobuf.Value 编码整条消息，其他语言可直接使用官方的
+// struct.proto 解码，不需要额外的 .proto 文件：
+//
+//	message Value {
+//	  oneof kind {
+//	    NullValue null_value = 1;
+//	    double number_value = 2;
+//	    string string_value = 3;
+//	    bool bool_value = 4;
+//	    Struct struct_value = 5;
+//	    ListValue list_value = 6;
+//	  }
+//	}
+//	message Struct { map<string, Value> fields = 1; }
+//	message ListValue { repeated Value values = 1; }
+//
+// 与JSON一样，数字都以double传输
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string { return EncodingProtobuf }
+
+func (protobufCodec) FromJSON(data []byte) ([]byte, error) {
+	value, err := decodeJSONValue(data)
+	if err != nil {
+		return nil, err
+	}
+	return appendProtoValue(make([]byte, 0, len(data)), value)
+}
+
+func (protobufCodec) ToJSON(data []byte) ([]byte, error) {
+	value, err := decodeProtoValue(data, 0)
+	if err != nil {
+		return nil, fmt.Errorf("protobuf解码失败: %v", err)
+	}
+	return json.Marshal(value)
+}
+
+// protobuf线路类型
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func appendProtoTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wireType))
+}
+
+func appendProtoBytes(b []byte, field int, data []byte) []byte {
+	b = appendProtoTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// appendProtoValue 写入一个Value消息的字段（不含外层长度）
+func appendProtoValue(b []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(appendProtoTag(b, 1, wireVarint), 0), nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		b = appendProtoTag(b, 2, wireFixed64)
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(f)), nil
+	case string:
+		return appendProtoBytes(b, 3, []byte(v)), nil
+	case bool:
+		flag := byte(0)
+		if v {
+			flag = 1
+		}
+		return append(appendProtoTag(b, 4, wireVarint), flag), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var fields []byte
+		for _, key := range keys {
+			item, err := appendProtoValue(nil, v[key])
+			if err != nil {
+				return nil, err
+			}
+			entry := appendProtoBytes(nil, 1, []byte(key))
+			entry = appendProtoBytes(entry, 2, item)
+			fields = appendProtoBytes(fields, 1, entry)
+		}
+		return appendProtoBytes(b, 5, fields), nil
+	case []interface{}:
+		var values []byte
+		for _, item := range v {
+			encoded, err := appendProtoValue(nil, item)
+			if err != nil {
+				return nil, err
+			}
+			values = appendProtoBytes(values, 1, encoded)
+		}
+		return appendProtoBytes(b, 6, values), nil
+	}
+	return nil, fmt.Errorf("无法编码的类型: %T", value)
+}
+
+var errProtoShort = errors.New("数据不完整")
+
+// protoField 一个已解析的字段
+type protoField struct {
+	number   int
+	wireType int
+	varint   uint64
+	bytes    []byte
+}
+
+// readProtoFields 依次解析消息中的字段，对每个字段调用fn
+func readProtoFields(data []byte, fn func(protoField) error) error {
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errProtoShort
+		}
+		data = data[n:]
+		field := protoField{number: int(tag >> 3), wireType: int(tag & 7)}
+		switch field.wireType {
+		case wireVarint:
+			field.varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				return errProtoShort
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return errProtoShort
+			}
+			field.varint, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return errProtoShort
+			}
+			field.varint, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return errProtoShort
+			}
+			data = data[n:]
+			field.bytes, data = data[:size], data[size:]
+		default:
+			return fmt.Errorf("不支持的线路类型 %d", field.wireType)
+		}
+		if err := fn(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeProtoValue 解码Value消息，未设置任何字段时为null；oneof出现多次时以最后一次为准
+func decodeProtoValue(data []byte, depth int) (interface{}, error) {
+	if depth > maxCodecDepth {
+		return nil, errCodecDepth
+	}
+	var value interface{}
+	err := readProtoFields(data, func(f protoField) error {
+		switch {
+		case f.number == 1 && f.wireType == wireVarint:
+			value = nil
+		case f.number == 2 && f.wireType == wireFixed64:
+			number := math.Float64frombits(f.varint)
+			if math.IsNaN(number) || math.IsInf(number, 0) {
+				return errors.New("number_value 不能是NaN或无穷大")
+			}
+			value = number
+		case f.number == 3 && f.wireType == wireBytes:
+			value = string(f.bytes)
+		case f.number == 4 && f.wireType == wireVarint:
+			value = f.varint != 0
+		case f.number == 5 && f.wireType == wireBytes:
+			fields := make(map[string]interface{})
+			err := readProtoFields(f.bytes, func(entry protoField) error {
+				if entry.number != 1 || entry.wireType != wireBytes {
+					return nil
+				}
+				var key string
+				var item interface{}
+				err := readProtoFields(entry.bytes, func(kv protoField) error {
+					var err error
+					switch {
+					case kv.number == 1 && kv.wireType == wireBytes:
+						key = string(kv.bytes)
+					case kv.number == 2 && kv.wireType == wireBytes:
+						item, err = decodeProtoValue(kv.bytes, depth+1)
+					}
+					return err
+				})
+				fields[key] = item
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			value = fields
+		case f.number == 6 && f.wireType == wireBytes:
+			items := []interface{}{}
+			err := readProtoFields(f.bytes, func(entry protoField) error {
+				if entry.number != 1 || entry.wireType != wireBytes {
+					return nil
+				}
+				item, err := decodeProtoValue(entry.bytes, depth+1)
+				items = append(items, item)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			value = items
+		}
+		// 未知字段按protobuf的约定忽略
+		return nil
+	})
+	return value, err
+}
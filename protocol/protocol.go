@@ -0,0 +1,126 @@
+// Package protocol 定义客户端与服务端之间的消息格式和共享的编码类型
+package protocol
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Message 定义WebSocket消息格式，类似RESTful
+type Message struct {
+	ID        string            `json:"id"`                // 消息ID，用于请求响应匹配
+	Method    string            `json:"method"`            // GET, POST, PUT, DELETE
+	Path      string            `json:"path"`              // 类似RESTful的路径，如 /users, /users/123
+	Headers   map[string]string `json:"headers,omitempty"` // 请求头
+	Body      interface{}       `json:"body,omitempty"`    // 请求体
+	Timestamp int64             `json:"timestamp"`         // 时间戳
+}
+
+// Response WebSocket响应格式
+type Response struct {
+	ID        string            `json:"id"`     // 对应请求的ID
+	Status    int               `json:"status"` // HTTP风格的状态码
+	Headers   map[string]string `json:"headers,omitempty"`
+	Body      interface{}       `json:"body,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Timestamp int64             `json:"timestamp"`
+}
+
+// 发布订阅消息类型（消息的 type 字段）
+const (
+	TypeSubscribe    = "subscribe"   // 客户端订阅主题
+	TypeUnsubscribe  = "unsubscribe" // 客户端取消订阅
+	TypePublish      = "publish"     // 客户端向主题发布消息
+	TypeTopicMessage = "message"     // 服务端向订阅者投递的主题消息
+	TypePubSubAck    = "pubsub_ack"  // 服务端对订阅、取消订阅和发布的确认
+)
+
+// 客户端连接后发送的注册消息类型，注册消息的 type 字段可以省略
+const TypeRegister = "register"
+
+// 服务端要求客户端重连的消息类型，客户端应以正常关闭码断开后重新连接
+const TypeReconnect = "reconnect"
+
+// 服务端告知客户端注册使用的ID（节点按客户端ID方案分配或把旧ID映射到了新ID），客户端重连时应使用该ID：
+// {"type": "client_id_assigned", "client_id": "...", "requested_id": "...", "legacy_id": "...", "scheme": "uuid"}
+const TypeClientIDAssigned = "client_id_assigned"
+
+// 要求重连的原因（reconnect 消息的 reason 字段）
+const (
+	ReconnectMaxAge = "max_connection_age" // 连接达到最长存活时间
+)
+
+// PubSubMessage 发布订阅消息
+type PubSubMessage struct {
+	Type      string      `json:"type"`
+	ID        string      `json:"id,omitempty"` // 客户端请求ID，确认消息中原样带回
+	Topic     string      `json:"topic"`
+	Data      interface{} `json:"data,omitempty"` // 发布的内容
+	From      string      `json:"from,omitempty"` // 发布者客户端ID，投递时由服务端填写
+	Timestamp int64       `json:"timestamp"`
+}
+
+// PubSubAck 订阅、取消订阅和发布的确认
+type PubSubAck struct {
+	Type      string `json:"type"` // 固定为 pubsub_ack
+	ID        string `json:"id,omitempty"`
+	Action    string `json:"action"` // subscribe, unsubscribe, publish
+	Topic     string `json:"topic"`
+	Success   bool   `json:"success"`
+	Error     string `json:"error,omitempty"`
+	Delivered int    `json:"delivered,omitempty"` // publish: 本节点投递的订阅者数
+	Timestamp int64  `json:"timestamp"`
+}
+
+// NewMessage 创建新消息
+func NewMessage(method, path string, body interface{}) *Message {
+	return &Message{
+		ID:        generateID(),
+		Method:    method,
+		Path:      path,
+		Body:      body,
+		Timestamp: time.Now().UnixMilli(),
+	}
+}
+
+// NewResponse 创建响应
+func NewResponse(requestID string, status int, body interface{}) *Response {
+	return &Response{
+		ID:        requestID,
+		Status:    status,
+		Body:      body,
+		Timestamp: time.Now().UnixMilli(),
+	}
+}
+
+// idSeq 保证同一秒内生成的消息ID不重复
+var idSeq atomic.Uint64
+
+// 简单的ID生成器
+func generateID() string {
+	return time.Now().Format("20060102150405") + "-" + strconv.FormatUint(idSeq.Add(1), 10)
+}
+
+// 协议示例说明：
+// 客户端发送：
+// {
+//   "id": "123456",
+//   "method": "GET",
+//   "path": "/users/1",
+//   "timestamp": 1703123456789
+// }
+//
+// 服务器响应：
+// {
+//   "id": "123456",
+//   "status": 200,
+//   "body": {"id": 1, "name": "张三"},
+//   "timestamp": 1703123456790
+// }
+//
+// 发布订阅：
+// {"type": "subscribe", "id": "1", "topic": "prices"}
+// {"type": "publish", "topic": "prices", "data": {"BTC": 1}}
+// 订阅者收到：
+// {"type": "message", "topic": "prices", "data": {"BTC": 1}, "from": "client_abc", "timestamp": 1703123456790}
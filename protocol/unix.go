@@ -0,0 +1,29 @@
+package protocol
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// UnixScheme Unix域套接字地址的前缀，配置中 "unix:///run/ws/node1.sock" 与 "/run/ws/node1.sock" 等价
+const UnixScheme = "unix://"
+
+// UnixSocketPath 去掉地址中的 unix:// 前缀，返回套接字文件路径
+func UnixSocketPath(addr string) string {
+	return strings.TrimPrefix(addr, UnixScheme)
+}
+
+// ListenUnix 在Unix域套接字上监听。上次进程异常退出遗留的套接字文件会先被删除，
+// 路径上已存在的普通文件不会被覆盖
+func ListenUnix(addr string) (net.Listener, error) {
+	path := UnixSocketPath(addr)
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s 已存在且不是套接字文件", path)
+		}
+		os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,197 @@
+package protocol
+
+import "fmt"
+
+// FieldKind 消息字段的JSON类型
+type FieldKind int
+
+const (
+	FieldString FieldKind = iota
+	FieldNumber
+	FieldBool
+	FieldObject    // 任意JSON对象
+	FieldStringMap // 值均为字符串的JSON对象，如请求头
+	FieldAny       // 任意类型
+)
+
+func (k FieldKind) String() string {
+	switch k {
+	case FieldString:
+		return "字符串"
+	case FieldNumber:
+		return "数字"
+	case FieldBool:
+		return "布尔值"
+	case FieldObject:
+		return "对象"
+	case FieldStringMap:
+		return "字符串映射"
+	}
+	return "任意类型"
+}
+
+// FieldRule 消息中一个字段的约束
+type FieldRule struct {
+	Name     string
+	Kind     FieldKind
+	Required bool // 必须存在且不为空
+}
+
+// SchemaRequest 请求消息（带method字段、没有type或type不在MessageSchemas中）使用的模式名
+const SchemaRequest = "request"
+
+// MessageSchemas 客户端可以发送的消息类型及其字段约束，按 type 字段查找。
+// 未列出的字段不做检查，便于客户端携带扩展字段
+var MessageSchemas = map[string][]FieldRule{
+	SchemaRequest: {
+		{Name: "id", Kind: FieldString, Required: true},
+		{Name: "method", Kind: FieldString, Required: true},
+		{Name: "path", Kind: FieldString, Required: true},
+		{Name: "headers", Kind: FieldStringMap},
+		{Name: "timestamp", Kind: FieldNumber},
+	},
+	TypeSubscribe:   pubSubSchema,
+	TypeUnsubscribe: pubSubSchema,
+	TypePublish:     pubSubSchema,
+	"command_response": {
+		{Name: "result", Kind: FieldString, Required: true},
+		{Name: "message", Kind: FieldString},
+		{Name: "request_id", Kind: FieldString},
+		{Name: "client_id", Kind: FieldString},
+		{Name: "timestamp", Kind: FieldNumber},
+	},
+	TypeAck: {
+		{Name: "delivery_id", Kind: FieldString, Required: true},
+	},
+	TypeNack: {
+		{Name: "delivery_id", Kind: FieldString, Required: true},
+		{Name: "reason", Kind: FieldString},
+		{Name: "requeue", Kind: FieldBool},
+	},
+	"name_response": {
+		{Name: "client_id", Kind: FieldString},
+		{Name: "client_name", Kind: FieldString},
+		{Name: "timestamp", Kind: FieldNumber},
+	},
+	"pong": {
+		{Name: "timestamp", Kind: FieldNumber},
+	},
+	TypeHeartbeat: {
+		{Name: "status", Kind: FieldString},
+		{Name: "timestamp", Kind: FieldNumber},
+	},
+}
+
+// RegisterSchema 注册消息（连接后的第一条消息）的字段约束，labels 和 affinity 的内容由注册流程进一步校验
+var RegisterSchema = []FieldRule{
+	{Name: "client_id", Kind: FieldString},
+	{Name: "client_name", Kind: FieldString},
+	{Name: "namespace", Kind: FieldString},
+	{Name: "labels", Kind: FieldObject},
+	{Name: "affinity", Kind: FieldString},
+	{Name: "capabilities", Kind: FieldObject},
+	{Name: "accept_batch", Kind: FieldBool},
+	{Name: "ack", Kind: FieldBool},
+	{Name: "timestamp", Kind: FieldNumber},
+}
+
+var pubSubSchema = []FieldRule{
+	{Name: "id", Kind: FieldString},
+	{Name: "topic", Kind: FieldString, Required: true},
+	{Name: "timestamp", Kind: FieldNumber},
+}
+
+// ValidationError 消息不符合模式，Field为出错的字段（整条消息出错时为空）
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("字段 %s %s", e.Field, e.Message)
+}
+
+// ValidateMessage 按MessageSchemas校验解析后的JSON消息，返回消息使用的模式名
+func ValidateMessage(msg map[string]interface{}) (string, error) {
+	schema := ""
+	if rawType, exists := msg["type"]; exists {
+		msgType, ok := rawType.(string)
+		if !ok {
+			return "", &ValidationError{Field: "type", Message: "必须是字符串"}
+		}
+		if _, known := MessageSchemas[msgType]; known && msgType != SchemaRequest {
+			schema = msgType
+		}
+	}
+	if schema == "" {
+		if _, hasMethod := msg["method"]; !hasMethod {
+			if msgType, ok := msg["type"].(string); ok {
+				return "", &ValidationError{Message: fmt.Sprintf("未知的消息类型: %s", msgType)}
+			}
+			return "", &ValidationError{Message: "消息缺少 type 或 method 字段"}
+		}
+		schema = SchemaRequest
+	}
+
+	return schema, checkFields(msg, MessageSchemas[schema])
+}
+
+// ValidateRegistration 按RegisterSchema校验注册消息，type 字段存在时必须是 register
+func ValidateRegistration(msg map[string]interface{}) error {
+	if rawType, exists := msg["type"]; exists && rawType != TypeRegister {
+		return &ValidationError{Field: "type", Message: "必须是 " + TypeRegister}
+	}
+	return checkFields(msg, RegisterSchema)
+}
+
+// checkFields 按字段约束逐个检查消息
+func checkFields(msg map[string]interface{}, rules []FieldRule) error {
+	for _, rule := range rules {
+		value, exists := msg[rule.Name]
+		if !exists || value == nil {
+			if rule.Required {
+				return &ValidationError{Field: rule.Name, Message: "不能为空"}
+			}
+			continue
+		}
+		if !matchesKind(value, rule.Kind) {
+			return &ValidationError{Field: rule.Name, Message: "必须是" + rule.Kind.String()}
+		}
+		if rule.Required && value == "" {
+			return &ValidationError{Field: rule.Name, Message: "不能为空"}
+		}
+	}
+	return nil
+}
+
+func matchesKind(value interface{}, kind FieldKind) bool {
+	switch kind {
+	case FieldString:
+		_, ok := value.(string)
+		return ok
+	case FieldNumber:
+		_, ok := value.(float64)
+		return ok
+	case FieldBool:
+		_, ok := value.(bool)
+		return ok
+	case FieldObject:
+		_, ok := value.(map[string]interface{})
+		return ok
+	case FieldStringMap:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for _, v := range m {
+			if _, ok := v.(string); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	return true
+}
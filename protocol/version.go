@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// 协议版本：客户端在握手时通过查询参数或请求头声明自己使用的消息协议版本，
+// 未声明的客户端视为 DefaultVersion（引入版本协商之前的客户端）
+const (
+	VersionParam   = "protocol_version"   // 握手查询参数
+	VersionHeader  = "X-Protocol-Version" // 握手请求头；节点也在101响应中以该头返回协商的版本
+	DefaultVersion = 1                    // 未声明版本的客户端使用的版本
+	CurrentVersion = 1                    // 当前实现的最高协议版本
+)
+
+// VersionRange 支持的协议版本范围（包含两端）
+type VersionRange struct {
+	Min int `json:"min_version" yaml:"min_version"`
+	Max int `json:"max_version" yaml:"max_version"`
+}
+
+// Validate 校验版本范围
+func (v VersionRange) Validate() error {
+	if v.Min < 1 || v.Max < 1 {
+		return fmt.Errorf("协议版本必须大于0")
+	}
+	if v.Min > v.Max {
+		return fmt.Errorf("min_version(%d) 不能大于 max_version(%d)", v.Min, v.Max)
+	}
+	return nil
+}
+
+// Contains 版本是否在范围内
+func (v VersionRange) Contains(version int) bool {
+	return version >= v.Min && version <= v.Max
+}
+
+// String 如 "1-2"，只有一个版本时为 "1"
+func (v VersionRange) String() string {
+	if v.Min == v.Max {
+		return strconv.Itoa(v.Min)
+	}
+	return fmt.Sprintf("%d-%d", v.Min, v.Max)
+}
+
+// RequestedVersion 握手请求声明的协议版本，查询参数优先；未声明时返回 DefaultVersion
+func RequestedVersion(r *http.Request) (int, error) {
+	value := r.URL.Query().Get(VersionParam)
+	if value == "" {
+		value = r.Header.Get(VersionHeader)
+	}
+	if value == "" {
+		return DefaultVersion, nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("无效的协议版本: %q", value)
+	}
+	return version, nil
+}
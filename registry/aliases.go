@@ -0,0 +1,170 @@
+package registry
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Alias 旧客户端ID到按新方案分配的ID的映射，迁移期间旧客户端以旧ID注册时使用映射到的ID
+type Alias struct {
+	LegacyID  string    `json:"legacy_id"`
+	ClientID  string    `json:"client_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// 别名文件路径，与注册表文件放在一起（global_clients.json -> global_clients.aliases.json）
+func (gr *Registry) aliasesPath() string {
+	return strings.TrimSuffix(gr.filePath, filepath.Ext(gr.filePath)) + ".aliases.json"
+}
+
+// 从文件加载别名（调用方持有锁）
+func (gr *Registry) loadAliasesUnsafe() {
+	gr.aliases = make(map[string]*Alias)
+	gr.aliasesModTime = time.Time{}
+
+	info, err := os.Stat(gr.aliasesPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("读取客户端别名文件失败: %v", err)
+		}
+		return
+	}
+	data, err := os.ReadFile(gr.aliasesPath())
+	if err != nil {
+		log.Printf("读取客户端别名文件失败: %v", err)
+		return
+	}
+	if err := json.Unmarshal(data, &gr.aliases); err != nil {
+		log.Printf("解析客户端别名文件失败: %v", err)
+	}
+	if gr.aliases == nil {
+		gr.aliases = make(map[string]*Alias)
+	}
+	gr.aliasesModTime = info.ModTime()
+}
+
+// 保存别名到文件（调用方持有锁）
+func (gr *Registry) saveAliasesUnsafe() {
+	if !gr.persistent() {
+		return
+	}
+	data, err := json.MarshalIndent(gr.aliases, "", "  ")
+	if err != nil {
+		log.Printf("序列化客户端别名失败: %v", err)
+		return
+	}
+	if err := writeFileAtomic(gr.aliasesPath(), data); err != nil {
+		log.Printf("保存客户端别名文件失败: %v", err)
+		return
+	}
+	if info, err := os.Stat(gr.aliasesPath()); err == nil {
+		gr.aliasesModTime = info.ModTime()
+	}
+}
+
+// reloadAliasesUnsafe 别名文件被其他节点修改时重新加载（调用方持有写锁）
+func (gr *Registry) reloadAliasesUnsafe() {
+	if !gr.persistent() {
+		return
+	}
+	info, err := os.Stat(gr.aliasesPath())
+	if err != nil || info.ModTime().Equal(gr.aliasesModTime) {
+		return
+	}
+	gr.loadAliasesUnsafe()
+}
+
+// 返回旧客户端ID映射到的ID，没有映射时返回false。本节点没有记录时检查其他节点是否新增了映射
+func (gr *Registry) ResolveAlias(legacyID string) (string, bool) {
+	gr.mu.RLock()
+	alias, exists := gr.aliases[legacyID]
+	gr.mu.RUnlock()
+	if exists {
+		return alias.ClientID, true
+	}
+
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+	gr.reloadAliasesUnsafe()
+	if alias, exists := gr.aliases[legacyID]; exists {
+		return alias.ClientID, true
+	}
+	return "", false
+}
+
+// 记录旧客户端ID到新ID的映射。其他节点已为该旧ID记录了映射时保留已有的映射，返回实际生效的ID
+func (gr *Registry) SetAlias(legacyID, clientID string) string {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	gr.reloadAliasesUnsafe()
+	if alias, exists := gr.aliases[legacyID]; exists {
+		return alias.ClientID
+	}
+	gr.aliases[legacyID] = &Alias{LegacyID: legacyID, ClientID: clientID, CreatedAt: time.Now()}
+	gr.saveAliasesUnsafe()
+	log.Printf("记录客户端别名: %s -> %s", legacyID, clientID)
+	return clientID
+}
+
+// 删除旧客户端ID的映射，返回是否存在
+func (gr *Registry) DeleteAlias(legacyID string) bool {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	gr.reloadAliasesUnsafe()
+	if _, exists := gr.aliases[legacyID]; !exists {
+		return false
+	}
+	delete(gr.aliases, legacyID)
+	gr.saveAliasesUnsafe()
+	return true
+}
+
+// 获取全部别名，按旧ID排序
+func (gr *Registry) GetAliases() []Alias {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	gr.reloadAliasesUnsafe()
+	aliases := make([]Alias, 0, len(gr.aliases))
+	for _, alias := range gr.aliases {
+		aliases = append(aliases, *alias)
+	}
+	sort.Slice(aliases, func(i, j int) bool { return aliases[i].LegacyID < aliases[j].LegacyID })
+	return aliases
+}
+
+// 全局函数接口
+func ResolveAlias(legacyID string) (string, bool) {
+	if globalRegistry == nil {
+		return "", false
+	}
+	return globalRegistry.ResolveAlias(legacyID)
+}
+
+func SetAlias(legacyID, clientID string) string {
+	if globalRegistry == nil {
+		return clientID
+	}
+	return globalRegistry.SetAlias(legacyID, clientID)
+}
+
+func DeleteAlias(legacyID string) bool {
+	if globalRegistry == nil {
+		return false
+	}
+	return globalRegistry.DeleteAlias(legacyID)
+}
+
+func Aliases() []Alias {
+	if globalRegistry == nil {
+		return []Alias{}
+	}
+	return globalRegistry.GetAliases()
+}
@@ -0,0 +1,177 @@
+package registry
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 注释目标类型
+const (
+	AnnotationTargetClient  = "client"
+	AnnotationTargetBackend = "backend"
+)
+
+// Annotation 运维人员附加在客户端或后端上的备注
+type Annotation struct {
+	Note      string    `json:"note"`
+	UpdatedBy string    `json:"updated_by,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// 注释的存储key，如 client:client_xxx / backend:node1
+func annotationKey(target, id string) string {
+	return target + ":" + id
+}
+
+// 注释文件路径，与注册表文件放在一起（global_clients.json -> global_clients.annotations.json）
+func (gr *Registry) annotationsPath() string {
+	return strings.TrimSuffix(gr.filePath, filepath.Ext(gr.filePath)) + ".annotations.json"
+}
+
+// 从文件加载注释（调用方持有锁）
+func (gr *Registry) loadAnnotationsUnsafe() {
+	gr.annotations = make(map[string]*Annotation)
+
+	data, err := os.ReadFile(gr.annotationsPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("读取注释文件失败: %v", err)
+		}
+		return
+	}
+
+	if err := json.Unmarshal(data, &gr.annotations); err != nil {
+		log.Printf("解析注释文件失败: %v", err)
+	}
+	if gr.annotations == nil {
+		gr.annotations = make(map[string]*Annotation)
+	}
+}
+
+// 保存注释到文件（调用方持有锁）
+func (gr *Registry) saveAnnotationsUnsafe() {
+	if !gr.persistent() {
+		return
+	}
+	data, err := json.MarshalIndent(gr.annotations, "", "  ")
+	if err != nil {
+		log.Printf("序列化注释数据失败: %v", err)
+		return
+	}
+
+	if err := writeFileAtomic(gr.annotationsPath(), data); err != nil {
+		log.Printf("保存注释文件失败: %v", err)
+	}
+}
+
+// 设置注释，note为空时删除
+func (gr *Registry) SetAnnotation(target, id, note, updatedBy string) *Annotation {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	key := annotationKey(target, id)
+	if note == "" {
+		delete(gr.annotations, key)
+		gr.saveAnnotationsUnsafe()
+		log.Printf("删除注释: %s", key)
+		return nil
+	}
+
+	annotation := &Annotation{
+		Note:      note,
+		UpdatedBy: updatedBy,
+		UpdatedAt: time.Now(),
+	}
+	gr.annotations[key] = annotation
+	gr.saveAnnotationsUnsafe()
+
+	log.Printf("设置注释: %s -> %s", key, note)
+	return annotation
+}
+
+// 获取注释
+func (gr *Registry) GetAnnotation(target, id string) *Annotation {
+	gr.mu.RLock()
+	defer gr.mu.RUnlock()
+	return gr.annotations[annotationKey(target, id)]
+}
+
+// 获取全部注释
+func (gr *Registry) GetAllAnnotations() map[string]*Annotation {
+	gr.mu.RLock()
+	defer gr.mu.RUnlock()
+
+	annotations := make(map[string]*Annotation, len(gr.annotations))
+	for key, annotation := range gr.annotations {
+		annotations[key] = annotation
+	}
+	return annotations
+}
+
+// 全局函数接口
+func SetAnnotation(target, id, note, updatedBy string) *Annotation {
+	if globalRegistry == nil {
+		return nil
+	}
+	return globalRegistry.SetAnnotation(target, id, note, updatedBy)
+}
+
+func GetAnnotation(target, id string) *Annotation {
+	if globalRegistry == nil {
+		return nil
+	}
+	return globalRegistry.GetAnnotation(target, id)
+}
+
+// HandleAnnotations 注释管理API
+// GET  列出全部注释
+// POST {"target": "client|backend", "id": "...", "note": "...", "updated_by": "..."}，note为空表示删除
+func HandleAnnotations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		annotations := make(map[string]*Annotation)
+		if globalRegistry != nil {
+			annotations = globalRegistry.GetAllAnnotations()
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"total":       len(annotations),
+			"annotations": annotations,
+		})
+	case "POST":
+		var req struct {
+			Target    string `json:"target"`
+			ID        string `json:"id"`
+			Note      string `json:"note"`
+			UpdatedBy string `json:"updated_by"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "请求格式错误", http.StatusBadRequest)
+			return
+		}
+		if req.Target != AnnotationTargetClient && req.Target != AnnotationTargetBackend {
+			http.Error(w, "target必须为client或backend", http.StatusBadRequest)
+			return
+		}
+		if req.ID == "" {
+			http.Error(w, "id为必填字段", http.StatusBadRequest)
+			return
+		}
+
+		annotation := SetAnnotation(req.Target, req.ID, req.Note, req.UpdatedBy)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"target":     req.Target,
+			"id":         req.ID,
+			"annotation": annotation,
+		})
+	default:
+		http.Error(w, "仅支持GET和POST请求", http.StatusMethodNotAllowed)
+	}
+}
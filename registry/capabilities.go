@@ -0,0 +1,62 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// 需要特定能力的指令
+var (
+	ExecCommands         = map[string]bool{"exec": true, "shell": true, "run": true}
+	FileTransferCommands = map[string]bool{"file_transfer": true, "upload": true, "download": true, "send_file": true}
+)
+
+// Capabilities 客户端注册时声明的能力，未声明能力的旧客户端不做限制
+type Capabilities struct {
+	SupportsExec         bool     `json:"supports_exec"`          // 是否支持执行命令类指令
+	SupportsFileTransfer bool     `json:"supports_file_transfer"` // 是否支持文件传输类指令
+	MaxPayload           int      `json:"max_payload,omitempty"`  // 可接收的指令数据最大字节数，0表示不限制
+	Commands             []string `json:"commands,omitempty"`     // 支持的指令列表，为空表示不限制
+}
+
+// ParseCapabilities 解析注册消息中的 capabilities 字段，未声明时返回nil
+func ParseCapabilities(v interface{}) *Capabilities {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var caps Capabilities
+	if err := json.Unmarshal(data, &caps); err != nil {
+		return nil
+	}
+	return &caps
+}
+
+// CheckCommand 检查客户端能否处理指令，payloadSize为指令数据序列化后的字节数。
+// 接收者为nil（客户端未声明能力）时总是允许
+func (c *Capabilities) CheckCommand(command string, payloadSize int) error {
+	if c == nil {
+		return nil
+	}
+	if ExecCommands[command] && !c.SupportsExec {
+		return fmt.Errorf("客户端不支持执行类指令 %s (supports_exec=false)", command)
+	}
+	if FileTransferCommands[command] && !c.SupportsFileTransfer {
+		return fmt.Errorf("客户端不支持文件传输类指令 %s (supports_file_transfer=false)", command)
+	}
+	if c.MaxPayload > 0 && payloadSize > c.MaxPayload {
+		return fmt.Errorf("指令数据 %d 字节超过客户端的上限 %d 字节 (max_payload)", payloadSize, c.MaxPayload)
+	}
+	if len(c.Commands) > 0 {
+		for _, supported := range c.Commands {
+			if supported == command {
+				return nil
+			}
+		}
+		return fmt.Errorf("客户端不支持指令 %s (支持: %v)", command, c.Commands)
+	}
+	return nil
+}
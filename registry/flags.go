@@ -0,0 +1,224 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 功能开关名称只允许小写字母、数字和下划线
+var flagNamePattern = regexp.MustCompile(`^[a-z0-9_]{1,63}$`)
+
+// FeatureFlag 集中管理的功能开关，节点定期读取并在运行时应用。
+// 判定顺序：nodes 中的按节点覆盖 > enabled > percentage 灰度
+type FeatureFlag struct {
+	Enabled    bool            `json:"enabled"`
+	Percentage int             `json:"percentage,omitempty"` // 1-99时只在按节点ID哈希选中的这部分节点开启，0或100表示所有节点
+	Nodes      map[string]bool `json:"nodes,omitempty"`      // 按节点覆盖，优先于 enabled 和 percentage
+	UpdatedBy  string          `json:"updated_by,omitempty"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// ValidateFlagName 检查功能开关名称
+func ValidateFlagName(name string) error {
+	if !flagNamePattern.MatchString(name) {
+		return fmt.Errorf("功能开关名称 %q 只能包含小写字母、数字和下划线，长度不超过63", name)
+	}
+	return nil
+}
+
+// Validate 检查功能开关的取值
+func (f FeatureFlag) Validate() error {
+	if f.Percentage < 0 || f.Percentage > 100 {
+		return fmt.Errorf("percentage 必须在0到100之间")
+	}
+	for nodeID := range f.Nodes {
+		if nodeID == "" {
+			return fmt.Errorf("nodes 中的节点ID不能为空")
+		}
+	}
+	return nil
+}
+
+// EnabledFor 判断功能开关在指定节点上是否开启。
+// 灰度按 名称/节点ID 的哈希分桶，同一节点的结果稳定，调大比例时已开启的节点保持开启
+func (f FeatureFlag) EnabledFor(name, nodeID string) bool {
+	if enabled, ok := f.Nodes[nodeID]; ok {
+		return enabled
+	}
+	if !f.Enabled {
+		return false
+	}
+	if f.Percentage <= 0 || f.Percentage >= 100 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name + "/" + nodeID))
+	return int(h.Sum32()%100) < f.Percentage
+}
+
+// 功能开关文件路径，与注册表文件放在一起（global_clients.json -> global_clients.flags.json）
+func (gr *Registry) flagsPath() string {
+	return strings.TrimSuffix(gr.filePath, filepath.Ext(gr.filePath)) + ".flags.json"
+}
+
+// 从文件加载功能开关（调用方持有锁）
+func (gr *Registry) loadFlagsUnsafe() {
+	gr.flags = make(map[string]*FeatureFlag)
+	gr.flagsModTime = time.Time{}
+
+	info, err := os.Stat(gr.flagsPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("读取功能开关文件失败: %v", err)
+		}
+		return
+	}
+	data, err := os.ReadFile(gr.flagsPath())
+	if err != nil {
+		log.Printf("读取功能开关文件失败: %v", err)
+		return
+	}
+	gr.flagsModTime = info.ModTime()
+
+	if err := json.Unmarshal(data, &gr.flags); err != nil {
+		log.Printf("解析功能开关文件失败: %v", err)
+	}
+	if gr.flags == nil {
+		gr.flags = make(map[string]*FeatureFlag)
+	}
+}
+
+// 保存功能开关到文件（调用方持有锁）
+func (gr *Registry) saveFlagsUnsafe() {
+	if !gr.persistent() {
+		return
+	}
+	data, err := json.MarshalIndent(gr.flags, "", "  ")
+	if err != nil {
+		log.Printf("序列化功能开关失败: %v", err)
+		return
+	}
+
+	if err := writeFileAtomic(gr.flagsPath(), data); err != nil {
+		log.Printf("保存功能开关文件失败: %v", err)
+		return
+	}
+	if info, err := os.Stat(gr.flagsPath()); err == nil {
+		gr.flagsModTime = info.ModTime()
+	}
+}
+
+// SetFlag 创建或更新功能开关
+func (gr *Registry) SetFlag(name string, flag FeatureFlag) (FeatureFlag, error) {
+	if err := ValidateFlagName(name); err != nil {
+		return FeatureFlag{}, err
+	}
+	if err := flag.Validate(); err != nil {
+		return FeatureFlag{}, err
+	}
+	flag.UpdatedAt = time.Now()
+
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+	gr.flags[name] = &flag
+	gr.saveFlagsUnsafe()
+
+	log.Printf("设置功能开关 %s: enabled=%v percentage=%d nodes=%v (by %s)",
+		name, flag.Enabled, flag.Percentage, flag.Nodes, flag.UpdatedBy)
+	return flag, nil
+}
+
+// DeleteFlag 删除功能开关，节点恢复各自的默认值
+func (gr *Registry) DeleteFlag(name string) bool {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	if _, exists := gr.flags[name]; !exists {
+		return false
+	}
+	delete(gr.flags, name)
+	gr.saveFlagsUnsafe()
+	log.Printf("删除功能开关 %s", name)
+	return true
+}
+
+// GetFlags 返回全部功能开关的副本
+func (gr *Registry) GetFlags() map[string]FeatureFlag {
+	gr.mu.RLock()
+	defer gr.mu.RUnlock()
+
+	flags := make(map[string]FeatureFlag, len(gr.flags))
+	for name, flag := range gr.flags {
+		flags[name] = *flag
+	}
+	return flags
+}
+
+// ReloadFlags 功能开关文件被其他进程修改时重新加载，返回是否重新加载
+func (gr *Registry) ReloadFlags() bool {
+	if !gr.persistent() {
+		return false
+	}
+	info, err := os.Stat(gr.flagsPath())
+
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+	switch {
+	case err != nil && os.IsNotExist(err):
+		if len(gr.flags) == 0 {
+			return false
+		}
+	case err != nil:
+		return false
+	case info.ModTime().Equal(gr.flagsModTime):
+		return false
+	}
+	gr.loadFlagsUnsafe()
+	log.Printf("功能开关文件已变更，重新加载了 %d 个功能开关", len(gr.flags))
+	return true
+}
+
+// 全局函数接口
+func SetFlag(name string, flag FeatureFlag) (FeatureFlag, error) {
+	if globalRegistry == nil {
+		return FeatureFlag{}, fmt.Errorf("注册表未初始化")
+	}
+	return globalRegistry.SetFlag(name, flag)
+}
+
+func DeleteFlag(name string) bool {
+	if globalRegistry == nil {
+		return false
+	}
+	return globalRegistry.DeleteFlag(name)
+}
+
+func Flags() map[string]FeatureFlag {
+	if globalRegistry == nil {
+		return map[string]FeatureFlag{}
+	}
+	return globalRegistry.GetFlags()
+}
+
+func ReloadFlags() bool {
+	if globalRegistry == nil {
+		return false
+	}
+	return globalRegistry.ReloadFlags()
+}
+
+// FlagEnabled 判断功能开关在节点上是否开启，没有设置该开关时返回 def
+func FlagEnabled(name, nodeID string, def bool) bool {
+	flag, exists := Flags()[name]
+	if !exists {
+		return def
+	}
+	return flag.EnabledFor(name, nodeID)
+}
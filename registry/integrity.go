@@ -0,0 +1,265 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FileVersion 注册表文件的格式版本。版本1（旧格式）是客户端记录组成的JSON对象，
+// 版本2在外层加上版本号和客户端记录的校验和，加载时发现损坏而不是当作空注册表
+const FileVersion = 2
+
+// 默认保留的备份数和备份间隔
+const (
+	DefaultBackupCount    = 3
+	DefaultBackupInterval = 10 * time.Minute
+)
+
+// 新建注册表使用的备份和压缩设置，由 SetBackups、SetRetention 设置
+var (
+	backupCount    = DefaultBackupCount
+	backupInterval = DefaultBackupInterval
+	retention      time.Duration
+)
+
+// SetBackups 设置保留的备份数和备份间隔（需在Init之前调用）。写回文件时距上次备份超过间隔，
+// 就把这次写入的内容另存为 <文件>.bak.1，原有备份依次后移，最多保留count个；count为0表示不备份，
+// interval为0表示使用默认值
+func SetBackups(count int, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultBackupInterval
+	}
+	backupCount, backupInterval = count, interval
+}
+
+// SetRetention 设置客户端记录的保留时长（需在Init之前调用）：加载文件时丢弃最后活动早于该时长的记录，
+// 压缩后的注册表立即写回。0表示保留全部记录
+func SetRetention(d time.Duration) {
+	retention = d
+}
+
+// registryFile 版本2的注册表文件
+type registryFile struct {
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum"` // clients 字段压缩后的 sha256，格式为 "sha256:<hex>"
+	SavedAt  time.Time       `json:"saved_at"`
+	Clients  json.RawMessage `json:"clients"`
+}
+
+// encodeClients 按版本2的格式序列化客户端记录
+func encodeClients(clients map[string]*ClientInfo) ([]byte, error) {
+	data, err := json.Marshal(clients)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(registryFile{
+		Version:  FileVersion,
+		Checksum: checksum(data),
+		SavedAt:  time.Now(),
+		Clients:  data,
+	}, "", "  ")
+}
+
+// decodeClients 解析注册表文件并校验完整性，同时兼容版本1的旧格式
+func decodeClients(data []byte) (map[string]*ClientInfo, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("无法解析: %v", err)
+	}
+	if _, ok := probe["checksum"]; !ok {
+		// 版本1：整个文件就是客户端记录
+		var clients map[string]*ClientInfo
+		if err := json.Unmarshal(data, &clients); err != nil {
+			return nil, fmt.Errorf("无法解析旧格式的客户端记录: %v", err)
+		}
+		return clients, nil
+	}
+
+	var file registryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("无法解析: %v", err)
+	}
+	if file.Version != FileVersion {
+		return nil, fmt.Errorf("不支持的文件版本: %d", file.Version)
+	}
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, file.Clients); err != nil {
+		return nil, fmt.Errorf("无法解析客户端记录: %v", err)
+	}
+	if sum := checksum(compacted.Bytes()); sum != file.Checksum {
+		return nil, fmt.Errorf("校验和不匹配: 文件记录 %s，实际 %s", file.Checksum, sum)
+	}
+	var clients map[string]*ClientInfo
+	if err := json.Unmarshal(file.Clients, &clients); err != nil {
+		return nil, fmt.Errorf("无法解析客户端记录: %v", err)
+	}
+	return clients, nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// ReadFile 读取并校验注册表文件，文件损坏时返回错误
+func ReadFile(path string) (map[string]*ClientInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeClients(data)
+}
+
+// backupPath 第n个备份的路径，1为最新
+func backupPath(path string, n int) string {
+	return path + ".bak." + strconv.Itoa(n)
+}
+
+// backupUnsafe 距上次备份超过备份间隔时，把刚写入的内容保存为最新的备份（调用方持有writeMu）
+func (gr *Registry) backupUnsafe(data []byte) {
+	if gr.backupCount <= 0 || time.Since(gr.lastBackup) < gr.backupInterval {
+		return
+	}
+	for n := gr.backupCount; n > 1; n-- {
+		if err := os.Rename(backupPath(gr.filePath, n-1), backupPath(gr.filePath, n)); err != nil && !os.IsNotExist(err) {
+			log.Printf("轮转全局客户端文件备份失败: %v", err)
+		}
+	}
+	if err := writeFileAtomic(backupPath(gr.filePath, 1), data); err != nil {
+		log.Printf("备份全局客户端文件失败: %v", err)
+		return
+	}
+	gr.lastBackup = time.Now()
+}
+
+// RecoveryStatus 本次启动加载注册表文件时发现损坏后的恢复情况
+type RecoveryStatus struct {
+	Time         time.Time      `json:"time"`
+	Error        string         `json:"error"`                   // 损坏的原因
+	CorruptFile  string         `json:"corrupt_file"`            // 损坏的文件改名后的路径，保留以便排查
+	RestoredFrom string         `json:"restored_from,omitempty"` // 恢复所用的备份，为空表示没有可用的备份
+	Restored     int            `json:"restored"`                // 从备份恢复的记录数
+	Rebuilt      map[string]int `json:"rebuilt,omitempty"`       // 按节点上报重建的记录数（节点ID -> 客户端数）
+	RebuiltAt    *time.Time     `json:"rebuilt_at,omitempty"`
+}
+
+// recoveryState 损坏恢复的状态
+type recoveryState struct {
+	mu     sync.Mutex
+	status *RecoveryStatus
+}
+
+// recoverUnsafe 注册表文件损坏时把它改名保留，再依次尝试备份，返回可用的客户端记录（调用方持有锁）
+func (gr *Registry) recoverUnsafe(cause error) map[string]*ClientInfo {
+	status := &RecoveryStatus{Time: time.Now(), Error: cause.Error()}
+	log.Printf("全局客户端文件 %s 已损坏: %v", gr.filePath, cause)
+
+	corrupt := gr.filePath + ".corrupt-" + status.Time.Format("20060102-150405")
+	if err := os.Rename(gr.filePath, corrupt); err != nil {
+		log.Printf("保留损坏的全局客户端文件失败: %v", err)
+	} else {
+		status.CorruptFile = corrupt
+		log.Printf("损坏的全局客户端文件已改名为 %s", corrupt)
+	}
+
+	var clients map[string]*ClientInfo
+	for n := 1; n <= gr.backupCount; n++ {
+		path := backupPath(gr.filePath, n)
+		restored, err := ReadFile(path)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			log.Printf("全局客户端文件备份 %s 不可用: %v", path, err)
+			continue
+		}
+		clients = restored
+		status.RestoredFrom = path
+		status.Restored = len(restored)
+		log.Printf("从备份 %s 恢复了 %d 个全局客户端记录", path, len(restored))
+		break
+	}
+	if status.RestoredFrom == "" {
+		log.Printf("没有可用的全局客户端文件备份，注册表将按节点上报的在线客户端重建")
+	}
+
+	gr.recovery.mu.Lock()
+	gr.recovery.status = status
+	gr.recovery.mu.Unlock()
+	// 立即写回，用恢复后的记录替换损坏的文件
+	gr.markDirtyUnsafe()
+	return clients
+}
+
+// compactUnsafe 丢弃最后活动早于保留时长的记录，返回丢弃的记录数（调用方持有锁）
+func (gr *Registry) compactUnsafe() int {
+	if gr.retention <= 0 {
+		return 0
+	}
+	removed := 0
+	for id, client := range gr.clients {
+		if time.Since(client.LastSeen) > gr.retention {
+			delete(gr.clients, id)
+			removed++
+		}
+	}
+	if removed > 0 {
+		gr.markDirtyUnsafe()
+	}
+	return removed
+}
+
+// Recovery 本次启动时注册表文件损坏后的恢复情况，文件完好时返回nil
+func Recovery() *RecoveryStatus {
+	if globalRegistry == nil {
+		return nil
+	}
+	gr := globalRegistry
+	gr.recovery.mu.Lock()
+	defer gr.recovery.mu.Unlock()
+	if gr.recovery.status == nil {
+		return nil
+	}
+	copied := *gr.recovery.status
+	if copied.Rebuilt != nil {
+		copied.Rebuilt = make(map[string]int, len(gr.recovery.status.Rebuilt))
+		for nodeID, n := range gr.recovery.status.Rebuilt {
+			copied.Rebuilt[nodeID] = n
+		}
+	}
+	return &copied
+}
+
+// NeedsRebuild 注册表文件损坏后是否还没有按节点上报重建。备份可能落后于损坏前的状态，
+// 从备份恢复后同样需要重建
+func NeedsRebuild() bool {
+	status := Recovery()
+	return status != nil && status.RebuiltAt == nil
+}
+
+// Rebuild 用节点上报的在线客户端替换注册表中该节点的记录，并记入恢复情况
+func Rebuild(nodeID string, clients []ClientInfo) {
+	if globalRegistry == nil {
+		return
+	}
+	SyncNode(nodeID, clients)
+	gr := globalRegistry
+	gr.recovery.mu.Lock()
+	defer gr.recovery.mu.Unlock()
+	if status := gr.recovery.status; status != nil {
+		now := time.Now()
+		if status.Rebuilt == nil {
+			status.Rebuilt = make(map[string]int)
+		}
+		status.Rebuilt[nodeID] = len(clients)
+		status.RebuiltAt = &now
+	}
+}
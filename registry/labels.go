@@ -0,0 +1,197 @@
+package registry
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxLabels 每个客户端最多的标签数
+const maxLabels = 32
+
+var (
+	// 标签键: 字母或数字开头，可包含 . _ / -，最长63个字符
+	labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]{0,62}$`)
+	// 标签值: 字母、数字、. _ -，最长63个字符，可以为空
+	labelValuePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{0,63}$`)
+	// 集合条件: key in (a, b) 或 key notin (a, b)
+	setRequirementPattern = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+)
+
+// ParseLabels 解析注册消息中的 labels 字段（字符串到字符串的对象），未声明时返回nil
+func ParseLabels(v interface{}) (map[string]string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("labels 必须是字符串到字符串的对象")
+	}
+	labels := make(map[string]string, len(raw))
+	for key, value := range raw {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("标签 %s 的值必须是字符串", key)
+		}
+		labels[key] = s
+	}
+	if err := ValidateLabels(labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// ValidateLabels 校验标签的数量和键、值的格式，客户端和节点的标签使用相同的规则
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return fmt.Errorf("标签数 %d 超过上限 %d", len(labels), maxLabels)
+	}
+	for key, value := range labels {
+		if !labelKeyPattern.MatchString(key) {
+			return fmt.Errorf("无效的标签键: %q (字母或数字开头，只能包含字母、数字、.、_、/、-，最长63个字符)", key)
+		}
+		if !labelValuePattern.MatchString(value) {
+			return fmt.Errorf("标签 %s 的值无效: %q (只能包含字母、数字、.、_、-，最长63个字符)", key, value)
+		}
+	}
+	return nil
+}
+
+// 标签条件的运算符
+const (
+	selectorEquals    = "="
+	selectorNotEquals = "!="
+	selectorIn        = "in"
+	selectorNotIn     = "notin"
+	selectorExists    = "exists"
+	selectorNotExists = "!exists"
+)
+
+// requirement 标签选择器中的一个条件
+type requirement struct {
+	key    string
+	op     string
+	values []string
+}
+
+// Selector 标签选择器，所有条件都满足时匹配。语法与Kubernetes的标签选择器一致，条件之间以逗号分隔：
+//
+//	env=prod  env==prod  env!=prod  env in (prod, staging)  env notin (dev)  gpu  !gpu
+type Selector []requirement
+
+// ParseSelector 解析标签选择器
+func ParseSelector(s string) (Selector, error) {
+	terms := splitSelector(s)
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("标签选择器不能为空")
+	}
+	selector := make(Selector, 0, len(terms))
+	for _, term := range terms {
+		req, err := parseRequirement(term)
+		if err != nil {
+			return nil, err
+		}
+		selector = append(selector, req)
+	}
+	return selector, nil
+}
+
+// splitSelector 按不在括号内的逗号拆分条件，忽略空条件
+func splitSelector(s string) []string {
+	var terms []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	terms = append(terms, s[start:])
+	result := terms[:0]
+	for _, term := range terms {
+		if term = strings.TrimSpace(term); term != "" {
+			result = append(result, term)
+		}
+	}
+	return result
+}
+
+func parseRequirement(term string) (requirement, error) {
+	var req requirement
+	switch {
+	case setRequirementPattern.MatchString(term):
+		m := setRequirementPattern.FindStringSubmatch(term)
+		req = requirement{key: m[1], op: m[2]}
+		for _, value := range strings.Split(m[3], ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				req.values = append(req.values, value)
+			}
+		}
+		if len(req.values) == 0 {
+			return req, fmt.Errorf("标签条件 %q 的集合不能为空", term)
+		}
+	case strings.Contains(term, "!="):
+		key, value, _ := strings.Cut(term, "!=")
+		req = requirement{key: strings.TrimSpace(key), op: selectorNotEquals, values: []string{strings.TrimSpace(value)}}
+	case strings.Contains(term, "="):
+		key, value, _ := strings.Cut(term, "=")
+		value = strings.TrimPrefix(value, "=")
+		req = requirement{key: strings.TrimSpace(key), op: selectorEquals, values: []string{strings.TrimSpace(value)}}
+	case strings.HasPrefix(term, "!"):
+		req = requirement{key: strings.TrimSpace(term[1:]), op: selectorNotExists}
+	default:
+		req = requirement{key: term, op: selectorExists}
+	}
+
+	if !labelKeyPattern.MatchString(req.key) {
+		return req, fmt.Errorf("标签条件 %q 的键无效", term)
+	}
+	for _, value := range req.values {
+		if !labelValuePattern.MatchString(value) {
+			return req, fmt.Errorf("标签条件 %q 的值 %q 无效", term, value)
+		}
+	}
+	return req, nil
+}
+
+// Matches 标签是否满足选择器的所有条件
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, req := range s {
+		value, exists := labels[req.key]
+		var ok bool
+		switch req.op {
+		case selectorEquals:
+			ok = exists && value == req.values[0]
+		case selectorNotEquals:
+			ok = !exists || value != req.values[0]
+		case selectorIn:
+			ok = exists && contains(req.values, value)
+		case selectorNotIn:
+			ok = !exists || !contains(req.values, value)
+		case selectorExists:
+			ok = exists
+		case selectorNotExists:
+			ok = !exists
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package registry
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 名称来源
+const (
+	NameSourceClient   = "client"   // 客户端注册时自报
+	NameSourceOperator = "operator" // 运维通过API重命名
+)
+
+// 每个客户端保留的名称历史条数
+const maxNameHistory = 50
+
+// NameChange 客户端名称的一次变更
+type NameChange struct {
+	Name      string    `json:"name"`
+	Source    string    `json:"source"`
+	ChangedBy string    `json:"changed_by,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// NameRecord 集中分配的客户端名称，优先于客户端自报的名称
+type NameRecord struct {
+	Name    string       `json:"name"`
+	History []NameChange `json:"history"`
+}
+
+// 名称文件路径，与注册表文件放在一起（global_clients.json -> global_clients.names.json）
+func (gr *Registry) namesPath() string {
+	return strings.TrimSuffix(gr.filePath, filepath.Ext(gr.filePath)) + ".names.json"
+}
+
+// 从文件加载名称记录（调用方持有锁）
+func (gr *Registry) loadNamesUnsafe() {
+	gr.names = make(map[string]*NameRecord)
+
+	data, err := os.ReadFile(gr.namesPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("读取客户端名称文件失败: %v", err)
+		}
+		return
+	}
+
+	if err := json.Unmarshal(data, &gr.names); err != nil {
+		log.Printf("解析客户端名称文件失败: %v", err)
+	}
+	if gr.names == nil {
+		gr.names = make(map[string]*NameRecord)
+	}
+}
+
+// 保存名称记录到文件（调用方持有锁）
+func (gr *Registry) saveNamesUnsafe() {
+	if !gr.persistent() {
+		return
+	}
+	data, err := json.MarshalIndent(gr.names, "", "  ")
+	if err != nil {
+		log.Printf("序列化客户端名称失败: %v", err)
+		return
+	}
+
+	if err := writeFileAtomic(gr.namesPath(), data); err != nil {
+		log.Printf("保存客户端名称文件失败: %v", err)
+	}
+}
+
+func (r *NameRecord) append(change NameChange) {
+	r.History = append(r.History, change)
+	if len(r.History) > maxNameHistory {
+		r.History = r.History[len(r.History)-maxNameHistory:]
+	}
+}
+
+// 重命名客户端，返回新的名称记录和原名称；客户端不在线时名称在其下次注册时生效
+func (gr *Registry) RenameClient(clientID, name, changedBy string) (NameRecord, string) {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	now := time.Now()
+	record, exists := gr.names[clientID]
+	previous := ""
+	if exists {
+		previous = record.Name
+	}
+	if client, ok := gr.clients[clientID]; ok {
+		previous = client.Name
+		client.Name = name
+		gr.markDirtyUnsafe()
+	}
+
+	if !exists {
+		record = &NameRecord{}
+		gr.names[clientID] = record
+		// 首次重命名时记下客户端原来自报的名称
+		if previous != "" {
+			record.append(NameChange{Name: previous, Source: NameSourceClient, ChangedAt: now})
+		}
+	}
+	record.Name = name
+	record.append(NameChange{Name: name, Source: NameSourceOperator, ChangedBy: changedBy, ChangedAt: now})
+	gr.saveNamesUnsafe()
+
+	log.Printf("客户端 %s 重命名: %s -> %s", clientID, previous, name)
+	return *record, previous
+}
+
+// 返回客户端注册时应使用的名称：有集中分配的名称时使用分配的名称，
+// 并把与之不同的自报名称记入历史
+func (gr *Registry) ResolveClientName(clientID, reported string) string {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	record, exists := gr.names[clientID]
+	if !exists {
+		return reported
+	}
+	if reported != "" && reported != record.Name {
+		last := record.History[len(record.History)-1]
+		if last.Name != reported || last.Source != NameSourceClient {
+			record.append(NameChange{Name: reported, Source: NameSourceClient, ChangedAt: time.Now()})
+			gr.saveNamesUnsafe()
+		}
+	}
+	return record.Name
+}
+
+// 获取客户端的名称记录
+func (gr *Registry) GetNameRecord(clientID string) (NameRecord, bool) {
+	gr.mu.RLock()
+	defer gr.mu.RUnlock()
+
+	record, exists := gr.names[clientID]
+	if !exists {
+		return NameRecord{}, false
+	}
+	result := *record
+	result.History = append([]NameChange(nil), record.History...)
+	return result, true
+}
+
+func Rename(clientID, name, changedBy string) (NameRecord, string) {
+	if globalRegistry == nil {
+		return NameRecord{Name: name}, ""
+	}
+	return globalRegistry.RenameClient(clientID, name, changedBy)
+}
+
+func ResolveName(clientID, reported string) string {
+	if globalRegistry == nil {
+		return reported
+	}
+	return globalRegistry.ResolveClientName(clientID, reported)
+}
+
+func GetNameRecord(clientID string) (NameRecord, bool) {
+	if globalRegistry == nil {
+		return NameRecord{}, false
+	}
+	return globalRegistry.GetNameRecord(clientID)
+}
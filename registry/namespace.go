@@ -0,0 +1,48 @@
+package registry
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// DefaultNamespace 注册时未指定命名空间的客户端所属的命名空间
+const DefaultNamespace = "default"
+
+// 命名空间名称: 字母、数字、点、下划线和连字符，最长63个字符
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,63}$`)
+
+// NormalizeNamespace 校验命名空间名称，空字符串返回默认命名空间
+func NormalizeNamespace(namespace string) (string, error) {
+	if namespace == "" {
+		return DefaultNamespace, nil
+	}
+	if !namespacePattern.MatchString(namespace) {
+		return "", fmt.Errorf("无效的命名空间: %q (只能包含字母、数字、.、_、-，最长63个字符)", namespace)
+	}
+	return namespace, nil
+}
+
+// NamespaceOf 客户端所属的命名空间，升级前注册的记录没有命名空间，视为默认命名空间
+func (c *ClientInfo) NamespaceOf() string {
+	if c.Namespace == "" {
+		return DefaultNamespace
+	}
+	return c.Namespace
+}
+
+// InNamespace 客户端是否属于指定命名空间，namespace为空表示不过滤（用于列表查询）
+func (c *ClientInfo) InNamespace(namespace string) bool {
+	return namespace == "" || c.NamespaceOf() == namespace
+}
+
+// SameNamespace 两个命名空间是否相同，空字符串视为默认命名空间。
+// 发送指令和广播按它判断，未指定命名空间的请求只能到达默认命名空间的客户端
+func SameNamespace(a, b string) bool {
+	if a == "" {
+		a = DefaultNamespace
+	}
+	if b == "" {
+		b = DefaultNamespace
+	}
+	return a == b
+}
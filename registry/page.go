@@ -0,0 +1,185 @@
+package registry
+
+import (
+	"container/heap"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// 分页读取注册表的每页条数
+const (
+	DefaultPageSize = 500
+	MaxPageSize     = 5000
+)
+
+// ClientPage 按客户端ID排序的一页注册表记录
+type ClientPage struct {
+	Clients    []*ClientInfo
+	NextCursor string // 下一页的游标（本页最后一个客户端ID），为空表示没有更多记录
+	Total      int    // 满足条件的客户端总数（不受游标限制）
+}
+
+// idHeap 按ID排序的大顶堆，保留遍历过程中ID最小的若干条记录
+type idHeap []*ClientInfo
+
+func (h idHeap) Len() int            { return len(h) }
+func (h idHeap) Less(i, j int) bool  { return h[i].ID > h[j].ID }
+func (h idHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *idHeap) Push(x interface{}) { *h = append(*h, x.(*ClientInfo)) }
+func (h *idHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// GetClientsPage 按客户端ID顺序返回ID大于cursor、满足match（nil表示不过滤）的前limit个客户端。
+// 在读锁下遍历一次注册表，只保留limit条候选记录，不复制整个注册表；翻页期间注册的客户端ID
+// 大于游标时会出现在后续页中
+func (gr *Registry) GetClientsPage(cursor string, limit int, match func(*ClientInfo) bool) ClientPage {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+
+	gr.mu.RLock()
+	defer gr.mu.RUnlock()
+
+	var page ClientPage
+	candidates := make(idHeap, 0, limit+1)
+	more := false
+	for _, client := range gr.clients {
+		if match != nil && !match(client) {
+			continue
+		}
+		page.Total++
+		if client.ID <= cursor {
+			continue
+		}
+		if len(candidates) < limit {
+			heap.Push(&candidates, client)
+			continue
+		}
+		more = true
+		if client.ID < candidates[0].ID {
+			candidates[0] = client
+			heap.Fix(&candidates, 0)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].ID < candidates[j].ID })
+	page.Clients = make([]*ClientInfo, len(candidates))
+	for i, client := range candidates {
+		page.Clients[i] = gr.snapshotUnsafe(client)
+	}
+	if more {
+		page.NextCursor = candidates[len(candidates)-1].ID
+	}
+	return page
+}
+
+// Page 分页读取全局注册表，见 Registry.GetClientsPage
+func Page(cursor string, limit int, match func(*ClientInfo) bool) ClientPage {
+	if globalRegistry == nil {
+		return ClientPage{Clients: []*ClientInfo{}}
+	}
+	return globalRegistry.GetClientsPage(cursor, limit, match)
+}
+
+// RequestFilter 按查询参数 ?namespace= 和 ?selector=（客户端标签的选择器）过滤客户端，
+// 都未指定时返回nil；选择器无效时返回错误
+func RequestFilter(r *http.Request) (func(*ClientInfo) bool, error) {
+	namespace := r.URL.Query().Get("namespace")
+	var selector Selector
+	if s := r.URL.Query().Get("selector"); s != "" {
+		var err error
+		if selector, err = ParseSelector(s); err != nil {
+			return nil, err
+		}
+	}
+	if namespace == "" && selector == nil {
+		return nil, nil
+	}
+	return func(c *ClientInfo) bool {
+		return c.InNamespace(namespace) && (selector == nil || selector.Matches(c.Labels))
+	}, nil
+}
+
+// IsPageRequest 请求是否带有分页参数 limit 或 cursor
+func IsPageRequest(r *http.Request) bool {
+	query := r.URL.Query()
+	return query.Has("limit") || query.Has("cursor")
+}
+
+// HandleClientPage 分页返回全局注册表中的客户端（节点和负载均衡器的 /api/global-clients 共用），
+// ?limit= 为每页条数（默认 DefaultPageSize，最多 MaxPageSize），?cursor= 为上一页的 next_cursor，
+// 过滤条件见 RequestFilter。
+// fields 为附加到响应中的字段，如 current_node
+func HandleClientPage(w http.ResponseWriter, r *http.Request, fields map[string]interface{}) {
+	limit := DefaultPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit 应为正整数", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, MaxPageSize)
+	}
+	match, err := RequestFilter(r)
+	if err != nil {
+		http.Error(w, "无效的标签选择器: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	page := Page(r.URL.Query().Get("cursor"), limit, match)
+
+	clients := make([]ClientInfo, len(page.Clients))
+	for i, client := range page.Clients {
+		clients[i] = *client
+	}
+	response := map[string]interface{}{
+		"total":       page.Total,
+		"count":       len(clients),
+		"clients":     clients,
+		"next_cursor": page.NextCursor,
+	}
+	for k, v := range fields {
+		response[k] = v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// HandleExport GET /api/global-clients/export: 以NDJSON（每行一个客户端记录，按ID排序）流式导出全局注册表，
+// ?namespace=、?selector= 只导出该命名空间、标签满足选择器的客户端。内部按 MaxPageSize 分页读取，每页写出后立即刷新，
+// 不会一次性复制整个注册表或在内存中拼出完整的响应
+func HandleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "仅支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	match, err := RequestFilter(r)
+	if err != nil {
+		http.Error(w, "无效的标签选择器: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	cursor := ""
+	for {
+		page := Page(cursor, MaxPageSize, match)
+		for _, client := range page.Clients {
+			if err := encoder.Encode(client); err != nil {
+				return // 调用方已断开
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if page.NextCursor == "" || r.Context().Err() != nil {
+			return
+		}
+		cursor = page.NextCursor
+	}
+}
@@ -0,0 +1,250 @@
+package registry
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 默认的写回间隔：注册、注销和状态变化先在内存中累积，每个间隔最多写一次文件
+const DefaultFlushInterval = time.Second
+
+// 新建注册表使用的写回间隔，由 SetFlushInterval 设置
+var flushInterval = DefaultFlushInterval
+
+// SetFlushInterval 设置客户端记录的写回间隔（需在Init之前调用），0表示使用默认值
+func SetFlushInterval(d time.Duration) {
+	if d <= 0 {
+		d = DefaultFlushInterval
+	}
+	flushInterval = d
+}
+
+// persistMetrics 客户端记录的写回统计
+type persistMetrics struct {
+	changes atomic.Int64 // 标记为待写回的修改次数
+	writes  atomic.Int64 // 实际写文件的次数
+	failed  atomic.Int64 // 写文件失败的次数
+	saved   atomic.Int64 // 最近一次成功写文件时已包含的修改次数
+}
+
+// backendState 注册表文件的可用状态。写文件失败后进入降级状态：注册、注销和状态变化照常在内存中生效，
+// 作为待写回的操作排队，每个写回间隔重试一次；恢复后通知各节点按当前连接的客户端对账
+type backendState struct {
+	mu        sync.Mutex
+	since     time.Time // 进入降级状态的时间，零值表示可用
+	lastError string
+	recovered map[int]func() // 恢复后的回调
+	nextID    int
+}
+
+// BackendHealth 注册表文件的可用状态
+type BackendHealth struct {
+	Degraded   bool       `json:"degraded"`
+	Since      *time.Time `json:"since,omitempty"`      // 进入降级状态的时间
+	LastError  string     `json:"last_error,omitempty"` // 最近一次写文件失败的原因
+	PendingOps int64      `json:"pending_ops"`          // 尚未写入文件的操作数
+}
+
+// persistent 是否写文件，未指定注册表文件时所有记录只保存在内存中
+func (gr *Registry) persistent() bool {
+	return gr.filePath != ""
+}
+
+// markDirtyUnsafe 标记客户端记录有未写回的修改并唤醒写回协程（调用方持有锁）
+func (gr *Registry) markDirtyUnsafe() {
+	if !gr.persistent() {
+		return
+	}
+	gr.persist.changes.Add(1)
+	gr.scheduleFlushUnsafe()
+}
+
+// scheduleFlushUnsafe 标记有待写回的修改并唤醒写回协程（调用方持有锁）
+func (gr *Registry) scheduleFlushUnsafe() {
+	gr.dirty = true
+	select {
+	case gr.flushSignal <- struct{}{}:
+	default:
+	}
+}
+
+// flushLoop 收到第一次修改后等待一个写回间隔，把间隔内的所有修改合并为一次写文件，
+// 客户端频繁上下线时文件的写入次数与变化次数无关
+func (gr *Registry) flushLoop() {
+	for range gr.flushSignal {
+		time.Sleep(gr.flushInterval)
+		gr.flushClients()
+	}
+}
+
+// flushClients 有未写回的修改时把客户端记录写回文件。在锁内序列化、在锁外写文件，
+// 写文件期间注册和心跳不会被阻塞；writeMu 保证较旧的快照不会覆盖较新的快照
+func (gr *Registry) flushClients() {
+	gr.writeMu.Lock()
+	defer gr.writeMu.Unlock()
+
+	gr.mu.Lock()
+	if !gr.dirty {
+		gr.mu.Unlock()
+		return
+	}
+	data, err := encodeClients(gr.clients)
+	gr.dirty = false
+	changes := gr.persist.changes.Load()
+	gr.mu.Unlock()
+	if err != nil {
+		log.Printf("序列化全局客户端数据失败: %v", err)
+		return
+	}
+
+	gr.persist.writes.Add(1)
+	if err := writeFileAtomic(gr.filePath, data); err != nil {
+		gr.persist.failed.Add(1)
+		log.Printf("保存全局客户端文件失败: %v", err)
+		gr.setDegraded(err)
+		// 下一个间隔重试
+		gr.mu.Lock()
+		gr.scheduleFlushUnsafe()
+		gr.mu.Unlock()
+		return
+	}
+	gr.persist.saved.Store(changes)
+	gr.setAvailable()
+	gr.backupUnsafe(data)
+}
+
+// setDegraded 写文件失败，进入（或保持）降级状态
+func (gr *Registry) setDegraded(err error) {
+	gr.backend.mu.Lock()
+	defer gr.backend.mu.Unlock()
+	gr.backend.lastError = err.Error()
+	if gr.backend.since.IsZero() {
+		gr.backend.since = time.Now()
+		log.Printf("全局注册表文件不可用，注册和注销暂存在内存中，恢复后写回: %v", err)
+	}
+}
+
+// setAvailable 写文件成功，从降级状态恢复时通知各节点对账
+func (gr *Registry) setAvailable() {
+	gr.backend.mu.Lock()
+	if gr.backend.since.IsZero() {
+		gr.backend.mu.Unlock()
+		return
+	}
+	log.Printf("全局注册表文件恢复可用，降级持续了 %v", time.Since(gr.backend.since).Round(time.Millisecond))
+	gr.backend.since = time.Time{}
+	gr.backend.lastError = ""
+	callbacks := make([]func(), 0, len(gr.backend.recovered))
+	for _, fn := range gr.backend.recovered {
+		callbacks = append(callbacks, fn)
+	}
+	gr.backend.mu.Unlock()
+	for _, fn := range callbacks {
+		go fn()
+	}
+}
+
+// Health 注册表文件的可用状态，未初始化或只保存在内存中时始终可用
+func Health() BackendHealth {
+	if globalRegistry == nil {
+		return BackendHealth{}
+	}
+	gr := globalRegistry
+	health := BackendHealth{}
+	if gr.persistent() {
+		health.PendingOps = gr.persist.changes.Load() - gr.persist.saved.Load()
+	}
+	gr.backend.mu.Lock()
+	defer gr.backend.mu.Unlock()
+	if !gr.backend.since.IsZero() {
+		since := gr.backend.since
+		health.Degraded = true
+		health.Since = &since
+		health.LastError = gr.backend.lastError
+	}
+	return health
+}
+
+// Degraded 注册表文件是否不可用（写文件失败，操作暂存在内存中）
+func Degraded() bool {
+	return Health().Degraded
+}
+
+// OnRecovered 注册注册表文件从降级状态恢复后的回调，返回取消注册的函数
+func OnRecovered(fn func()) (cancel func()) {
+	if globalRegistry == nil {
+		return func() {}
+	}
+	gr := globalRegistry
+	gr.backend.mu.Lock()
+	defer gr.backend.mu.Unlock()
+	if gr.backend.recovered == nil {
+		gr.backend.recovered = make(map[int]func())
+	}
+	id := gr.backend.nextID
+	gr.backend.nextID++
+	gr.backend.recovered[id] = fn
+	return func() {
+		gr.backend.mu.Lock()
+		defer gr.backend.mu.Unlock()
+		delete(gr.backend.recovered, id)
+	}
+}
+
+// writeFileAtomic 先写入同目录下的临时文件再重命名，进程在写入中途退出时不会留下截断的文件
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Chmod(tmpName, 0644); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+// PersistStats 客户端记录写回的统计，写文件次数远小于修改次数说明写入被合并
+func PersistStats() map[string]interface{} {
+	if globalRegistry == nil {
+		return nil
+	}
+	gr := globalRegistry
+	gr.mu.RLock()
+	dirty := gr.dirty
+	gr.mu.RUnlock()
+	return map[string]interface{}{
+		"flush_interval": gr.flushInterval.String(),
+		"changes":        gr.persist.changes.Load(),
+		"writes":         gr.persist.writes.Load(),
+		"failed":         gr.persist.failed.Load(),
+		"pending":        dirty,
+		"file_version":   FileVersion,
+		"backups":        gr.backupCount,
+		"recovery":       Recovery(),
+		"backend":        Health(),
+	}
+}
@@ -0,0 +1,149 @@
+package registry
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// Upsert 记录其他节点通过节点总线通告的在线客户端，活跃时间以本节点收到通告的时间为准，
+// 在线状态以通告为准（由客户端所在节点按心跳维护）
+func Upsert(client ClientInfo) {
+	if globalRegistry == nil {
+		return
+	}
+	globalRegistry.upsertRemote([]ClientInfo{client})
+}
+
+// SyncNode 用节点通告的完整客户端列表替换注册表中该节点的记录
+func SyncNode(nodeID string, clients []ClientInfo) {
+	if globalRegistry == nil {
+		return
+	}
+	gr := globalRegistry
+	gr.mu.Lock()
+	listed := make(map[string]bool, len(clients))
+	for _, client := range clients {
+		listed[client.ID] = true
+	}
+	removed := 0
+	for id, client := range gr.clients {
+		if client.NodeID == nodeID && !listed[id] {
+			delete(gr.clients, id)
+			removed++
+		}
+	}
+	gr.mu.Unlock()
+	if removed > 0 {
+		log.Printf("节点 %s 同步在线客户端，移除 %d 条过期记录", nodeID, removed)
+	}
+	gr.upsertRemote(clients)
+}
+
+// Reconcile 比较节点当前连接的客户端与注册表中该节点的记录，只修正不一致的记录：
+// 注册表中缺少的客户端（或记录在其他节点、但连接早于本节点的连接）补回，注册表中属于该节点但已不再连接的记录删除。
+// 返回补回和删除的客户端ID
+func Reconcile(nodeID string, clients []ClientInfo) (added, removed []string) {
+	if globalRegistry == nil {
+		return nil, nil
+	}
+	gr := globalRegistry
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+	listed := make(map[string]bool, len(clients))
+	for i := range clients {
+		client := clients[i]
+		listed[client.ID] = true
+		existing, exists := gr.clients[client.ID]
+		if exists && (existing.NodeID == nodeID || existing.ConnTime.After(client.ConnTime)) {
+			// 记录一致，或客户端已重连到其他节点而本节点的旧连接尚未检测到断开
+			continue
+		}
+		client.Annotation = nil
+		gr.clients[client.ID] = &client
+		added = append(added, client.ID)
+	}
+	for id, client := range gr.clients {
+		if client.NodeID == nodeID && !listed[id] {
+			delete(gr.clients, id)
+			removed = append(removed, id)
+		}
+	}
+	if len(added) > 0 || len(removed) > 0 {
+		gr.markDirtyUnsafe()
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// UnregisterFromNode 注销客户端，仅当注册表中记录的节点与nodeID一致时生效，
+// 避免客户端已重连到其他节点时被旧节点的下线通告误删
+func UnregisterFromNode(clientID, nodeID string) {
+	if globalRegistry == nil {
+		return
+	}
+	gr := globalRegistry
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+	if client, exists := gr.clients[clientID]; exists && client.NodeID == nodeID {
+		delete(gr.clients, clientID)
+		gr.markDirtyUnsafe()
+	}
+}
+
+// ByNode 返回连接在指定节点上的客户端
+func ByNode(nodeID string) []*ClientInfo {
+	if globalRegistry == nil {
+		return nil
+	}
+	return globalRegistry.GetClientsByNode(nodeID)
+}
+
+func (gr *Registry) upsertRemote(clients []ClientInfo) {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+	now := time.Now()
+	for i := range clients {
+		client := clients[i]
+		client.LastSeen = now
+		client.Annotation = nil
+		if client.Status == "" {
+			// 旧版本节点的通告不带状态
+			client.IsActive = true
+			client.Status = StatusOnline
+		}
+		gr.clients[client.ID] = &client
+	}
+	gr.markDirtyUnsafe()
+}
+
+// Mirror 用主负载均衡器的注册表快照替换本地的客户端和注释记录（只读观察者使用）。
+// 注册表文件由主负载均衡器和节点维护，这里只更新内存
+func Mirror(clients map[string]*ClientInfo, annotations map[string]*Annotation) {
+	if globalRegistry == nil {
+		return
+	}
+	gr := globalRegistry
+	mirrored := make(map[string]*ClientInfo, len(clients))
+	for id, client := range clients {
+		copied := *client
+		copied.Annotation = nil
+		mirrored[id] = &copied
+	}
+	if annotations == nil {
+		annotations = make(map[string]*Annotation)
+	}
+	gr.mu.Lock()
+	gr.clients = mirrored
+	gr.annotations = annotations
+	gr.mu.Unlock()
+}
+
+// AllAnnotations 返回全部运维备注
+func AllAnnotations() map[string]*Annotation {
+	if globalRegistry == nil {
+		return make(map[string]*Annotation)
+	}
+	return globalRegistry.GetAllAnnotations()
+}
@@ -0,0 +1,329 @@
+// Package registry 维护跨节点共享的全局客户端注册表和运维备注
+package registry
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// 客户端在线状态，由所在节点根据客户端心跳维护
+const (
+	StatusOnline  = "online"
+	StatusBusy    = "busy"    // 客户端在心跳中上报忙碌
+	StatusOffline = "offline" // 连续多个心跳间隔没有活动
+)
+
+// 全局客户端信息
+type ClientInfo struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Namespace    string            `json:"namespace,omitempty"` // 所属命名空间，旧记录为空时视为默认命名空间
+	NodeID       string            `json:"node_id"`             // 连接到哪个节点
+	NodePort     int               `json:"node_port"`           // 节点端口
+	ConnTime     time.Time         `json:"conn_time"`
+	LastSeen     time.Time         `json:"last_seen"`
+	IsActive     bool              `json:"is_active"`              // 所在节点按心跳判定的活跃状态
+	Status       string            `json:"status"`                 // online, offline, busy
+	Annotation   *Annotation       `json:"annotation,omitempty"`   // 运维备注
+	Capabilities *Capabilities     `json:"capabilities,omitempty"` // 注册时声明的能力
+	Labels       map[string]string `json:"labels,omitempty"`       // 注册时声明的标签，用于按标签选择器发送指令
+	Affinity     string            `json:"affinity,omitempty"`     // 注册时声明的节点亲和性（节点标签的选择器）
+}
+
+// 全局客户端注册表
+type Registry struct {
+	filePath       string
+	clients        map[string]*ClientInfo
+	annotations    map[string]*Annotation  // 运维备注，key为 target:id
+	names          map[string]*NameRecord  // 集中分配的客户端名称，key为客户端ID
+	flags          map[string]*FeatureFlag // 功能开关，key为开关名称
+	flagsModTime   time.Time               // 功能开关文件的修改时间，用于发现其他进程的修改
+	aliases        map[string]*Alias       // 旧客户端ID到新ID的映射，key为旧ID
+	aliasesModTime time.Time               // 别名文件的修改时间，用于发现其他节点新增的映射
+	mu             sync.RWMutex
+
+	dirty         bool          // 客户端记录有未写回文件的修改
+	flushInterval time.Duration // 写回间隔
+	flushSignal   chan struct{} // 唤醒写回协程
+	writeMu       sync.Mutex    // 串行化写文件
+	persist       persistMetrics
+
+	backupCount    int           // 保留的备份数
+	backupInterval time.Duration // 备份间隔
+	lastBackup     time.Time     // 上次备份的时间（writeMu保护）
+	retention      time.Duration // 加载时丢弃最后活动早于该时长的记录，0表示全部保留
+	recovery       recoveryState // 文件损坏后的恢复情况
+	backend        backendState  // 注册表文件的可用状态
+}
+
+var globalRegistry *Registry
+
+// 初始化全局客户端注册表，filePath为空时只保存在内存中（开发模式）
+func Init(filePath string) {
+	globalRegistry = &Registry{
+		filePath:       filePath,
+		clients:        make(map[string]*ClientInfo),
+		annotations:    make(map[string]*Annotation),
+		names:          make(map[string]*NameRecord),
+		flags:          make(map[string]*FeatureFlag),
+		aliases:        make(map[string]*Alias),
+		flushInterval:  flushInterval,
+		flushSignal:    make(chan struct{}, 1),
+		backupCount:    backupCount,
+		backupInterval: backupInterval,
+		retention:      retention,
+	}
+	if !globalRegistry.persistent() {
+		return
+	}
+	globalRegistry.loadFromFile()
+	go globalRegistry.flushLoop()
+}
+
+// 从文件加载客户端信息
+func (gr *Registry) loadFromFile() {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	gr.loadAnnotationsUnsafe()
+	gr.loadNamesUnsafe()
+	gr.loadFlagsUnsafe()
+	gr.loadAliasesUnsafe()
+
+	if _, err := os.Stat(gr.filePath); os.IsNotExist(err) {
+		// 文件不存在，创建空的注册表
+		data, err := encodeClients(gr.clients)
+		if err == nil {
+			err = writeFileAtomic(gr.filePath, data)
+		}
+		if err != nil {
+			log.Printf("保存全局客户端文件失败: %v", err)
+		}
+		return
+	}
+
+	data, err := os.ReadFile(gr.filePath)
+	if err != nil {
+		log.Printf("读取全局客户端文件失败: %v", err)
+		return
+	}
+
+	clients, err := decodeClients(data)
+	if err != nil {
+		// 损坏的文件改名保留，从最新的可用备份恢复，不会被空注册表覆盖
+		clients = gr.recoverUnsafe(err)
+	}
+
+	gr.clients = clients
+	if gr.clients == nil {
+		gr.clients = make(map[string]*ClientInfo)
+	}
+	// 文件中的记录来自上次运行，所在节点重新通告之前视为离线
+	for _, client := range gr.clients {
+		client.IsActive = false
+		client.Status = StatusOffline
+	}
+	if removed := gr.compactUnsafe(); removed > 0 {
+		log.Printf("压缩全局客户端文件: 丢弃 %d 个超过 %v 没有活动的记录", removed, gr.retention)
+	}
+
+	log.Printf("从文件加载了 %d 个全局客户端记录", len(gr.clients))
+}
+
+// 注册客户端
+func (gr *Registry) RegisterClient(clientInfo *ClientInfo) {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	gr.clients[clientInfo.ID] = clientInfo
+	gr.markDirtyUnsafe()
+
+	log.Printf("全局注册客户端: %s (%s) -> 节点 %s:%d",
+		clientInfo.Name, clientInfo.ID, clientInfo.NodeID, clientInfo.NodePort)
+}
+
+// 注销客户端
+func (gr *Registry) UnregisterClient(clientID string) {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	if client, exists := gr.clients[clientID]; exists {
+		delete(gr.clients, clientID)
+		gr.markDirtyUnsafe()
+		log.Printf("全局注销客户端: %s (%s)", client.Name, clientID)
+	}
+}
+
+// SetClientPresence 更新客户端的在线状态和最后活动时间，persist为true（状态发生变化）时标记写回，
+// 心跳只刷新内存中的活动时间
+func (gr *Registry) SetClientPresence(clientID string, active bool, status string, lastSeen time.Time, persist bool) {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	if client, exists := gr.clients[clientID]; exists {
+		client.IsActive = active
+		client.Status = status
+		client.LastSeen = lastSeen
+		if persist {
+			gr.markDirtyUnsafe()
+		}
+	}
+}
+
+// 设置客户端状态
+func (gr *Registry) SetClientStatus(clientID, status string) {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	if client, exists := gr.clients[clientID]; exists {
+		client.Status = status
+		client.LastSeen = time.Now()
+		gr.markDirtyUnsafe()
+	}
+}
+
+// 获取所有客户端
+func (gr *Registry) GetAllClients() map[string]*ClientInfo {
+	gr.mu.RLock()
+	defer gr.mu.RUnlock()
+
+	// 返回副本
+	clients := make(map[string]*ClientInfo, len(gr.clients))
+	for id, client := range gr.clients {
+		clients[id] = gr.snapshotUnsafe(client)
+	}
+
+	return clients
+}
+
+// 获取指定客户端
+func (gr *Registry) GetClient(clientID string) (*ClientInfo, bool) {
+	gr.mu.RLock()
+	defer gr.mu.RUnlock()
+
+	client, exists := gr.clients[clientID]
+	if !exists {
+		return nil, false
+	}
+	return gr.snapshotUnsafe(client), true
+}
+
+// 根据节点获取客户端
+func (gr *Registry) GetClientsByNode(nodeID string) []*ClientInfo {
+	gr.mu.RLock()
+	defer gr.mu.RUnlock()
+
+	var clients []*ClientInfo
+	for _, client := range gr.clients {
+		if client.NodeID == nodeID {
+			clients = append(clients, gr.snapshotUnsafe(client))
+		}
+	}
+
+	return clients
+}
+
+// 复制客户端记录并附上运维备注（调用方至少持有读锁）。记录只在写锁下修改，
+// 返回副本使调用方在锁外读取时不会与心跳等更新竞争
+func (gr *Registry) snapshotUnsafe(client *ClientInfo) *ClientInfo {
+	copied := *client
+	copied.Annotation = gr.annotations[annotationKey(AnnotationTargetClient, client.ID)]
+	return &copied
+}
+
+// 清理离线客户端（超过5分钟无活动）
+func (gr *Registry) CleanupOfflineClients() {
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+
+	cleaned := 0
+	for id, client := range gr.clients {
+		if time.Since(client.LastSeen) > 5*time.Minute {
+			delete(gr.clients, id)
+			cleaned++
+		}
+	}
+
+	if cleaned > 0 {
+		gr.markDirtyUnsafe()
+		log.Printf("清理了 %d 个离线客户端", cleaned)
+	}
+}
+
+// 启动定期清理任务
+func (gr *Registry) StartCleanupTask() {
+	go func() {
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			gr.CleanupOfflineClients()
+		}
+	}()
+}
+
+// 全局函数接口
+func Register(id, name, namespace, nodeID string, nodePort int, caps *Capabilities, labels map[string]string, affinity string) {
+	if globalRegistry == nil {
+		return
+	}
+
+	clientInfo := &ClientInfo{
+		ID:           id,
+		Name:         name,
+		Namespace:    namespace,
+		NodeID:       nodeID,
+		NodePort:     nodePort,
+		ConnTime:     time.Now(),
+		LastSeen:     time.Now(),
+		IsActive:     true,
+		Status:       StatusOnline,
+		Capabilities: caps,
+		Labels:       labels,
+		Affinity:     affinity,
+	}
+
+	globalRegistry.RegisterClient(clientInfo)
+}
+
+func Unregister(clientID string) {
+	if globalRegistry != nil {
+		globalRegistry.UnregisterClient(clientID)
+	}
+}
+
+// SetPresence 由客户端所在节点更新在线状态，见 Registry.SetClientPresence
+func SetPresence(clientID string, active bool, status string, lastSeen time.Time, persist bool) {
+	if globalRegistry != nil {
+		globalRegistry.SetClientPresence(clientID, active, status, lastSeen, persist)
+	}
+}
+
+func SetStatus(clientID, status string) {
+	if globalRegistry != nil {
+		globalRegistry.SetClientStatus(clientID, status)
+	}
+}
+
+// 立即写回尚未写入文件的客户端记录（关闭前调用）
+func Flush() {
+	if globalRegistry != nil {
+		globalRegistry.flushClients()
+	}
+}
+
+func All() map[string]*ClientInfo {
+	if globalRegistry == nil {
+		return make(map[string]*ClientInfo)
+	}
+	return globalRegistry.GetAllClients()
+}
+
+func Get(clientID string) (*ClientInfo, bool) {
+	if globalRegistry == nil {
+		return nil, false
+	}
+	return globalRegistry.GetClient(clientID)
+}
@@ -0,0 +1,220 @@
+// Package secrets 从环境变量、文件或HashiCorp Vault读取敏感配置，避免明文写在配置文件中。
+// 配置项的值可以是以下引用之一，其他值按明文处理：
+//
+//	env:JWT_SECRET                      环境变量
+//	file:/run/secrets/jwt               文件内容（去掉首尾空白）
+//	vault:secret/data/websocket#jwt     Vault中路径的字段（KV v1/v2）
+//
+// 引用会按 refresh_interval 定期重新读取，值变化时通知使用方，实现不重启轮换密钥
+package secrets
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"websocket-loadbalance/protocol"
+)
+
+// 引用前缀
+const (
+	prefixEnv   = "env:"
+	prefixFile  = "file:"
+	prefixVault = "vault:"
+)
+
+// Config 密钥管理配置
+type Config struct {
+	// 重新读取全部引用的间隔，0表示只在启动（以及负载均衡器重新加载配置）时读取
+	RefreshInterval protocol.Duration `json:"refresh_interval" yaml:"refresh_interval"`
+	Vault           VaultConfig       `json:"vault" yaml:"vault"`
+}
+
+// Validate 校验密钥管理配置
+func (c Config) Validate() error {
+	if c.RefreshInterval < 0 {
+		return fmt.Errorf("secrets.refresh_interval 不能为负数")
+	}
+	return c.Vault.Validate()
+}
+
+// Secret 一个敏感配置项的当前值
+type Secret struct {
+	ref      string
+	mu       sync.RWMutex
+	value    string
+	onChange []func(string)
+}
+
+// Value 当前值
+func (s *Secret) Value() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.value
+}
+
+// OnChange 注册值变化时的回调，回调在刷新密钥的goroutine中执行
+func (s *Secret) OnChange(fn func(string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = append(s.onChange, fn)
+}
+
+// update 设置新值，值变化时调用回调
+func (s *Secret) update(value string) bool {
+	s.mu.Lock()
+	if s.value == value {
+		s.mu.Unlock()
+		return false
+	}
+	s.value = value
+	callbacks := append([]func(string){}, s.onChange...)
+	s.mu.Unlock()
+	for _, fn := range callbacks {
+		fn(value)
+	}
+	return true
+}
+
+// Manager 解析引用并定期刷新
+type Manager struct {
+	vault   *vaultClient
+	mu      sync.Mutex
+	secrets []*Secret // 需要刷新的引用，明文值不在其中
+}
+
+var globalManager = &Manager{}
+
+// Init 按配置初始化全局密钥管理，refresh_interval大于0时启动定期刷新。
+// 未调用Init时只支持 env: 和 file: 引用
+func Init(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	vault, err := newVaultClient(cfg.Vault)
+	if err != nil {
+		return err
+	}
+	globalManager.mu.Lock()
+	globalManager.vault = vault
+	globalManager.mu.Unlock()
+
+	if interval := time.Duration(cfg.RefreshInterval); interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for range ticker.C {
+				Refresh()
+			}
+		}()
+	}
+	return nil
+}
+
+// IsReference 值是否为引用（而不是明文）
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, prefixEnv) || strings.HasPrefix(value, prefixFile) || strings.HasPrefix(value, prefixVault)
+}
+
+// ValidateRef 检查引用的格式，明文值总是合法
+func ValidateRef(value string) error {
+	switch {
+	case strings.HasPrefix(value, prefixEnv):
+		if strings.TrimPrefix(value, prefixEnv) == "" {
+			return fmt.Errorf("密钥引用 %q 缺少环境变量名", value)
+		}
+	case strings.HasPrefix(value, prefixFile):
+		if strings.TrimPrefix(value, prefixFile) == "" {
+			return fmt.Errorf("密钥引用 %q 缺少文件路径", value)
+		}
+	case strings.HasPrefix(value, prefixVault):
+		if _, _, err := parseVaultRef(strings.TrimPrefix(value, prefixVault)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resolve 解析配置值：引用会立即读取一次并加入定期刷新，明文值原样返回
+func Resolve(value string) (*Secret, error) {
+	return globalManager.resolve(value)
+}
+
+// Refresh 立即重新读取全部引用，读取失败的引用保留原值，返回值发生变化的引用
+func Refresh() []string {
+	return globalManager.refresh()
+}
+
+func (m *Manager) resolve(value string) (*Secret, error) {
+	if !IsReference(value) {
+		return &Secret{value: value}, nil
+	}
+	if err := ValidateRef(value); err != nil {
+		return nil, err
+	}
+	current, err := m.read(value)
+	if err != nil {
+		return nil, err
+	}
+	secret := &Secret{ref: value, value: current}
+	m.mu.Lock()
+	m.secrets = append(m.secrets, secret)
+	m.mu.Unlock()
+	return secret, nil
+}
+
+func (m *Manager) refresh() []string {
+	m.mu.Lock()
+	secrets := append([]*Secret{}, m.secrets...)
+	m.mu.Unlock()
+
+	changed := []string{}
+	for _, secret := range secrets {
+		value, err := m.read(secret.ref)
+		if err != nil {
+			log.Printf("刷新密钥 %s 失败，继续使用原值: %v", secret.ref, err)
+			continue
+		}
+		if secret.update(value) {
+			log.Printf("🔑 密钥 %s 已更新", secret.ref)
+			changed = append(changed, secret.ref)
+		}
+	}
+	return changed
+}
+
+// read 读取引用的当前值
+func (m *Manager) read(ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, prefixEnv):
+		name := strings.TrimPrefix(ref, prefixEnv)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("环境变量 %s 未设置", name)
+		}
+		return value, nil
+	case strings.HasPrefix(ref, prefixFile):
+		return readFile(strings.TrimPrefix(ref, prefixFile))
+	default:
+		m.mu.Lock()
+		vault := m.vault
+		m.mu.Unlock()
+		if vault == nil {
+			return "", fmt.Errorf("密钥引用 %s 需要配置 secrets.vault", ref)
+		}
+		path, field, _ := parseVaultRef(strings.TrimPrefix(ref, prefixVault))
+		return vault.read(path, field)
+	}
+}
+
+// readFile 读取文件内容并去掉首尾空白（如末尾换行）
+func readFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("读取密钥文件失败: %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,123 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"websocket-loadbalance/protocol"
+)
+
+// VaultConfig HashiCorp Vault连接配置，address为空时不启用Vault
+type VaultConfig struct {
+	Address   string            `json:"address" yaml:"address"`     // 如 https://vault.example.com:8200，为空时使用 VAULT_ADDR 环境变量
+	Token     string            `json:"token" yaml:"token"`         // 访问令牌，可以是 env:/file: 引用，为空时使用 VAULT_TOKEN 环境变量
+	Namespace string            `json:"namespace" yaml:"namespace"` // Vault企业版命名空间（可选）
+	Timeout   protocol.Duration `json:"timeout" yaml:"timeout"`     // 单次请求超时，默认10s
+}
+
+// Validate 校验Vault配置
+func (c VaultConfig) Validate() error {
+	if strings.HasPrefix(c.Token, prefixVault) {
+		return fmt.Errorf("secrets.vault.token 不能引用Vault自身")
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("secrets.vault.timeout 不能为负数")
+	}
+	return ValidateRef(c.Token)
+}
+
+// vaultClient 通过HTTP API读取Vault中的密钥
+type vaultClient struct {
+	address   string
+	token     string // 明文令牌或 env:/file: 引用，每次请求时读取，以便使用Vault Agent轮换的令牌文件
+	namespace string
+	client    *http.Client
+}
+
+// newVaultClient 创建Vault客户端，未配置地址时返回nil
+func newVaultClient(cfg VaultConfig) (*vaultClient, error) {
+	if cfg.Address == "" {
+		cfg.Address = os.Getenv("VAULT_ADDR")
+	}
+	if cfg.Address == "" {
+		return nil, nil
+	}
+	if cfg.Token == "" {
+		cfg.Token = prefixEnv + "VAULT_TOKEN"
+	}
+	timeout := time.Duration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &vaultClient{
+		address:   strings.TrimSuffix(cfg.Address, "/"),
+		token:     cfg.Token,
+		namespace: cfg.Namespace,
+		client:    &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// parseVaultRef 拆分 path#field 形式的Vault引用
+func parseVaultRef(ref string) (path, field string, err error) {
+	path, field, ok := strings.Cut(ref, "#")
+	path = strings.Trim(path, "/")
+	if !ok || path == "" || field == "" {
+		return "", "", fmt.Errorf("Vault引用 %q 格式错误，应为 vault:<路径>#<字段>，如 vault:secret/data/websocket#jwt", prefixVault+ref)
+	}
+	return path, field, nil
+}
+
+// read 读取路径中的字段，同时支持KV v2（data.data）和KV v1（data）的响应格式
+func (v *vaultClient) read(path, field string) (string, error) {
+	token := v.token
+	if IsReference(token) {
+		var err error
+		if token, err = globalManager.read(token); err != nil {
+			return "", fmt.Errorf("读取Vault令牌失败: %v", err)
+		}
+	}
+
+	req, err := http.NewRequest("GET", v.address+"/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("请求Vault失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("读取Vault路径 %s 失败: HTTP %d: %s", path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("解析Vault响应失败: %v", err)
+	}
+	data := body.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, isV2 := data["metadata"]; isV2 {
+			data = nested
+		}
+	}
+	value, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("Vault路径 %s 中没有字段 %s", path, field)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// admissionRetryAfter 节点满载时建议客户端重试的间隔（秒）
+const admissionRetryAfter = 5
+
+// SetMaxClients 设置节点最大并发客户端数（需在Start之前调用），0表示不限制
+func (s *Server) SetMaxClients(max int) {
+	if max < 0 {
+		max = 0
+	}
+	s.maxClients = max
+}
+
+// admit 为新连接占用一个名额，节点满载时以503拒绝握手并返回协议层错误消息。
+// 名额在握手前占用，避免并发握手越过上限；返回true时调用方必须在连接结束后调用release
+func (s *Server) admit(w http.ResponseWriter, r *http.Request) bool {
+	if s.emergency.active.Load() {
+		s.rejectEmergency(w, r)
+		return false
+	}
+	current := s.admitted.Add(1)
+	if s.maxClients <= 0 || current <= int64(s.maxClients) {
+		return true
+	}
+	s.admitted.Add(-1)
+	s.admissionRejected.Add(1)
+	log.Printf("节点 %s 已达到最大连接数 %d，拒绝连接 (%s)", s.nodeID, s.maxClients, s.clientAddr(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(admissionRetryAfter))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":        "error",
+		"code":        "server_full",
+		"message":     fmt.Sprintf("节点 %s 已达到最大连接数 %d", s.nodeID, s.maxClients),
+		"node_id":     s.nodeID,
+		"max_clients": s.maxClients,
+		"retry_after": admissionRetryAfter,
+	})
+	return false
+}
+
+// release 释放连接占用的名额
+func (s *Server) release() {
+	s.admitted.Add(-1)
+}
+
+// admissionStats 导出连接准入统计
+func (s *Server) admissionStats() map[string]interface{} {
+	return map[string]interface{}{
+		"connections": s.admitted.Load(),
+		"max_clients": s.maxClients,
+		"rejected":    s.admissionRejected.Load(),
+	}
+}
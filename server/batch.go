@@ -0,0 +1,213 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+)
+
+// BatchConfig 消息批量发送配置
+type BatchConfig struct {
+	Enabled     bool              `json:"enabled" yaml:"enabled"`
+	Window      protocol.Duration `json:"window" yaml:"window"`             // 合并等待时间
+	MaxMessages int               `json:"max_messages" yaml:"max_messages"` // 单批最大消息数，达到后立即发送
+}
+
+var errWriterClosed = errors.New("连接写入器已关闭")
+
+// wsConn 服务端WebSocket连接的写入接口，由gorilla连接和epoll模式的pollConn实现
+type wsConn interface {
+	WriteJSON(v interface{}) error
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	Close() error
+}
+
+// connWriter 串行化单个连接的写操作；启用批量发送时，将短时间内的多条消息
+// 合并为一个 {"type": "batch", "messages": [...]} 帧，由客户端透明拆包
+type connWriter struct {
+	conn     wsConn
+	queue    *sendQueue     // 慢消费者检测启用时的发送队列，nil表示直接写连接
+	sent     *atomic.Int64  // 节点的发送消息计数，nil表示不计数
+	history  *clientHistory // 记录发出的消息，nil表示不记录
+	clientID string
+	timeout  time.Duration        // 直接写连接时每帧的写超时，0表示不限制
+	metrics  *SlowConsumerMetrics // 记录写超时，nil表示不记录也不断开
+	config   BatchConfig
+	pending  []interface{}
+	timer    *time.Timer
+	closed   bool
+	mu       sync.Mutex
+}
+
+func newConnWriter(conn wsConn, config BatchConfig) *connWriter {
+	return &connWriter{
+		conn:   conn,
+		config: config,
+	}
+}
+
+// WriteJSON 发送一条消息；批量模式下消息先进入缓冲区，由定时器或缓冲区满时发出
+func (w *connWriter) WriteJSON(v interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errWriterClosed
+	}
+	w.countSent()
+	w.history.recordOut(v)
+	if !w.config.Enabled {
+		return w.writeJSON(v)
+	}
+
+	w.pending = append(w.pending, v)
+	if len(w.pending) >= w.config.MaxMessages {
+		return w.flushLocked()
+	}
+	if w.timer == nil {
+		w.timer = time.AfterFunc(time.Duration(w.config.Window), func() {
+			if err := w.Flush(); err != nil && err != errWriterClosed {
+				log.Printf("批量发送消息失败: %v", err)
+				w.conn.Close()
+			}
+		})
+	}
+	return nil
+}
+
+// WritePrepared 发送预编码的消息，帧编码（及压缩）在所有接收者间共享；
+// 批量模式下退化为写入已序列化的JSON以便合并进批量帧，返回值表示是否绕过批量缓冲区直接写出
+func (w *connWriter) WritePrepared(pm *websocket.PreparedMessage, data []byte) (bool, error) {
+	w.mu.Lock()
+	if !w.config.Enabled && !w.closed {
+		defer w.mu.Unlock()
+		w.countSent()
+		w.history.recordOut(json.RawMessage(data))
+		if w.queue != nil {
+			// 广播属于非关键消息，慢消费者按策略丢弃或合并
+			return true, w.queue.push(outFrame{prepared: pm, data: data, size: int64(len(data))}, false)
+		}
+		setWriteDeadline(w.conn, w.timeout)
+		if conn, ok := w.conn.(*websocket.Conn); ok {
+			return true, w.checkTimeout(conn.WritePreparedMessage(pm))
+		}
+		// epoll模式的连接自行编码帧，二进制编码的连接逐个转换，直接写入序列化后的数据
+		return true, w.checkTimeout(w.conn.WriteMessage(websocket.TextMessage, data))
+	}
+	w.mu.Unlock()
+	if w.queue != nil && w.queue.isSlow() {
+		// 慢消费者的广播不再进入批量缓冲区
+		w.countSent()
+		w.history.recordOut(json.RawMessage(data))
+		return false, w.queue.push(outFrame{data: data, size: int64(len(data))}, false)
+	}
+	return false, w.WriteJSON(json.RawMessage(data))
+}
+
+// countSent 记录一条发往客户端的消息
+func (w *connWriter) countSent() {
+	if w.sent != nil {
+		w.sent.Add(1)
+	}
+}
+
+// writeJSON 写出一条消息，启用发送队列时作为关键消息入队
+func (w *connWriter) writeJSON(v interface{}) error {
+	if w.queue != nil {
+		if raw, ok := v.(json.RawMessage); ok {
+			return w.queue.push(outFrame{data: raw, size: int64(len(raw))}, true)
+		}
+		return w.queue.push(outFrame{value: v, size: pendingMessageEstimate}, true)
+	}
+	setWriteDeadline(w.conn, w.timeout)
+	if raw, ok := v.(json.RawMessage); ok {
+		return w.checkTimeout(w.conn.WriteMessage(websocket.TextMessage, raw))
+	}
+	return w.checkTimeout(w.conn.WriteJSON(v))
+}
+
+// checkTimeout 直接写连接超时后断开连接
+func (w *connWriter) checkTimeout(err error) error {
+	if w.metrics == nil {
+		return err
+	}
+	return checkWriteTimeout(w.conn, w.clientID, err, w.metrics)
+}
+
+// queued 返回缓冲区中待发送消息的条数和估算字节数
+func (w *connWriter) queued() (int, int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var size int64
+	for _, msg := range w.pending {
+		if raw, ok := msg.(json.RawMessage); ok {
+			size += int64(len(raw))
+		} else {
+			size += pendingMessageEstimate
+		}
+	}
+	count := len(w.pending)
+	if w.queue != nil {
+		count += len(w.queue.frames)
+		size += w.queue.bytes.Load()
+	}
+	return count, size
+}
+
+// Flush 立即发送缓冲区中的消息
+func (w *connWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errWriterClosed
+	}
+	return w.flushLocked()
+}
+
+// Close 发送剩余消息并停止写入
+func (w *connWriter) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return
+	}
+	w.flushLocked()
+	w.closed = true
+	if w.queue != nil {
+		w.queue.close()
+	}
+}
+
+func (w *connWriter) flushLocked() error {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if len(w.pending) == 0 {
+		return nil
+	}
+
+	messages := w.pending
+	w.pending = nil
+
+	// 单条消息无需包装
+	if len(messages) == 1 {
+		return w.writeJSON(messages[0])
+	}
+	return w.writeJSON(map[string]interface{}{
+		"type":     "batch",
+		"count":    len(messages),
+		"messages": messages,
+	})
+}
@@ -0,0 +1,335 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"websocket-loadbalance/auth"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+)
+
+// 客户端ID方案：注册时如何确定客户端ID
+const (
+	ClientIDSchemeClient  = "client"  // 默认：使用客户端提供的ID，未提供时取令牌的 sub 或由节点生成
+	ClientIDSchemeUUID    = "uuid"    // 由节点分配UUID，客户端重连时带回分配的UUID则保持不变
+	ClientIDSchemeRegex   = "regex"   // 客户端提供的ID必须匹配 pattern
+	ClientIDSchemeSubject = "subject" // 使用认证令牌的 sub 声明，需启用认证
+)
+
+// 不符合方案的旧客户端ID的处理方式
+const (
+	LegacyIDReject = "reject" // 默认：以 invalid_client_id 错误拒绝注册
+	LegacyIDAccept = "accept" // 原样接受，迁移期间新旧ID并存
+	LegacyIDMap    = "map"    // 按方案分配新ID并记录别名，同一个旧ID之后总是映射到这个ID（regex方案不支持）
+)
+
+// ClientIDConfig 客户端ID方案。迁移到新方案期间，aliases 和 legacy 使仍以旧ID注册的客户端可以继续使用，
+// 注册使用的ID与客户端提供的不同时，节点以 client_id_assigned 消息告知客户端
+type ClientIDConfig struct {
+	Scheme  string            `json:"scheme" yaml:"scheme"`   // client(默认)、uuid、regex、subject
+	Pattern string            `json:"pattern" yaml:"pattern"` // scheme=regex 时客户端ID必须完整匹配的正则
+	Legacy  string            `json:"legacy" yaml:"legacy"`   // 不符合方案的ID: reject(默认)、accept、map
+	Aliases map[string]string `json:"aliases" yaml:"aliases"` // 旧ID -> 新ID，优先于方案和 legacy
+}
+
+// Validate 校验客户端ID方案
+func (c ClientIDConfig) Validate() error {
+	switch c.Scheme {
+	case "", ClientIDSchemeClient, ClientIDSchemeUUID, ClientIDSchemeRegex, ClientIDSchemeSubject:
+	default:
+		return fmt.Errorf("无效的 client_ids.scheme: %s (可选: client, uuid, regex, subject)", c.Scheme)
+	}
+	switch c.Legacy {
+	case "", LegacyIDReject, LegacyIDAccept:
+	case LegacyIDMap:
+		if c.Scheme != ClientIDSchemeUUID && c.Scheme != ClientIDSchemeSubject {
+			return fmt.Errorf("client_ids.legacy=map 只能用于 uuid 或 subject 方案，regex 方案请用 aliases 指定映射")
+		}
+	default:
+		return fmt.Errorf("无效的 client_ids.legacy: %s (可选: reject, accept, map)", c.Legacy)
+	}
+	var pattern *regexp.Regexp
+	if c.Scheme == ClientIDSchemeRegex {
+		if c.Pattern == "" {
+			return fmt.Errorf("client_ids.scheme=regex 时必须设置 pattern")
+		}
+		var err error
+		if pattern, err = regexp.Compile(anchorClientIDPattern(c.Pattern)); err != nil {
+			return fmt.Errorf("client_ids.pattern 无效: %v", err)
+		}
+	} else if c.Pattern != "" {
+		return fmt.Errorf("client_ids.pattern 只能用于 regex 方案")
+	}
+	for legacyID, clientID := range c.Aliases {
+		if legacyID == "" || clientID == "" {
+			return fmt.Errorf("client_ids.aliases 中的ID不能为空")
+		}
+		if c.Scheme == ClientIDSchemeUUID && !isUUID(clientID) {
+			return fmt.Errorf("client_ids.aliases 中 %s 映射到的 %s 不是UUID", legacyID, clientID)
+		}
+		if pattern != nil && !pattern.MatchString(clientID) {
+			return fmt.Errorf("client_ids.aliases 中 %s 映射到的 %s 不匹配 pattern", legacyID, clientID)
+		}
+	}
+	return nil
+}
+
+// anchorClientIDPattern pattern 需完整匹配客户端ID
+func anchorClientIDPattern(pattern string) string {
+	return `^(?:` + pattern + `)$`
+}
+
+// clientIDScheme 生效的客户端ID方案及其统计
+type clientIDScheme struct {
+	config   ClientIDConfig
+	pattern  *regexp.Regexp
+	assigned atomic.Int64 // 由节点分配ID的注册数
+	mapped   atomic.Int64 // 旧ID按别名映射的注册数
+	legacy   atomic.Int64 // 原样接受旧ID的注册数
+	rejected atomic.Int64 // 因ID不符合方案被拒绝的注册数
+}
+
+// SetClientIDs 设置客户端ID方案（需在Start之前调用，cfg应已通过Validate）
+func (s *Server) SetClientIDs(cfg ClientIDConfig) {
+	if cfg.Scheme == "" {
+		cfg.Scheme = ClientIDSchemeClient
+	}
+	if cfg.Legacy == "" {
+		cfg.Legacy = LegacyIDReject
+	}
+	ids := &clientIDScheme{config: cfg}
+	if cfg.Scheme == ClientIDSchemeRegex {
+		ids.pattern = regexp.MustCompile(anchorClientIDPattern(cfg.Pattern))
+	}
+	s.clientIDs = ids
+	if cfg.Scheme != ClientIDSchemeClient {
+		log.Printf("节点 %s 使用客户端ID方案 %s，不符合方案的旧ID: %s，静态别名 %d 个", s.nodeID, cfg.Scheme, cfg.Legacy, len(cfg.Aliases))
+	}
+}
+
+// alias 旧ID映射到的ID：先查配置中的别名，再查 legacy=map 时记录在全局注册表中的别名
+func (ids *clientIDScheme) alias(legacyID string) (string, bool) {
+	if clientID, ok := ids.config.Aliases[legacyID]; ok {
+		return clientID, true
+	}
+	if ids.config.Scheme == ClientIDSchemeClient {
+		return "", false
+	}
+	return registry.ResolveAlias(legacyID)
+}
+
+// errInvalidClientID 注册使用的客户端ID不符合方案
+var errInvalidClientID = errors.New("客户端ID不符合方案")
+
+// assignClientID 按客户端ID方案确定注册使用的ID。requested 为注册消息中的 client_id，
+// fallback 为默认方案下使用的ID（注册消息未提供时取自令牌的 sub）；
+// legacyID 非空表示客户端以旧ID注册，注册使用的是它映射到的ID
+func (s *Server) assignClientID(requested, fallback string, claims *auth.Claims) (clientID, legacyID string, err error) {
+	ids := s.clientIDs
+	scheme := ids.config.Scheme
+	subject := ""
+	if claims != nil {
+		subject = claims.Subject
+	}
+	if scheme == ClientIDSchemeSubject && subject == "" {
+		ids.rejected.Add(1)
+		return "", "", fmt.Errorf("%w: subject 方案需要携带 sub 声明的认证令牌", errInvalidClientID)
+	}
+
+	if requested != "" {
+		if target, ok := ids.alias(requested); ok {
+			if scheme == ClientIDSchemeSubject && target != subject {
+				ids.rejected.Add(1)
+				return "", "", fmt.Errorf("%w: 旧ID %s 映射到的 %s 与令牌的 sub %s 不符", errInvalidClientID, requested, target, subject)
+			}
+			ids.mapped.Add(1)
+			return target, requested, nil
+		}
+	}
+
+	switch scheme {
+	case ClientIDSchemeUUID:
+		if requested == "" {
+			ids.assigned.Add(1)
+			return newUUID(), "", nil
+		}
+		if isUUID(requested) {
+			return requested, "", nil
+		}
+	case ClientIDSchemeRegex:
+		if requested == "" {
+			ids.rejected.Add(1)
+			return "", "", fmt.Errorf("%w: regex 方案要求注册消息提供 client_id", errInvalidClientID)
+		}
+		if ids.pattern.MatchString(requested) {
+			return requested, "", nil
+		}
+	case ClientIDSchemeSubject:
+		if requested == "" || requested == subject {
+			return subject, "", nil
+		}
+	default:
+		if fallback == "" {
+			fallback = "client_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+		}
+		return fallback, "", nil
+	}
+
+	// 不符合方案的旧ID
+	switch ids.config.Legacy {
+	case LegacyIDAccept:
+		ids.legacy.Add(1)
+		log.Printf("⚠️ 客户端以不符合 %s 方案的旧ID %s 注册，按 legacy=accept 原样接受", scheme, requested)
+		return requested, "", nil
+	case LegacyIDMap:
+		clientID = subject
+		if scheme == ClientIDSchemeUUID {
+			clientID = newUUID()
+		}
+		// 其他节点可能同时为这个旧ID记录了映射，以先记录的为准
+		clientID = registry.SetAlias(requested, clientID)
+		if scheme == ClientIDSchemeSubject && clientID != subject {
+			ids.rejected.Add(1)
+			return "", "", fmt.Errorf("%w: 旧ID %s 映射到的 %s 与令牌的 sub %s 不符", errInvalidClientID, requested, clientID, subject)
+		}
+		ids.mapped.Add(1)
+		return clientID, requested, nil
+	default:
+		ids.rejected.Add(1)
+		return "", "", fmt.Errorf("%w: %s 不符合 %s 方案", errInvalidClientID, requested, scheme)
+	}
+}
+
+// clientIDSuffix 客户端ID的最后n个字符，用于生成默认的客户端名称；ID不足n个字符时返回整个ID
+func clientIDSuffix(clientID string, n int) string {
+	runes := []rune(clientID)
+	if len(runes) <= n {
+		return clientID
+	}
+	return string(runes[len(runes)-n:])
+}
+
+// notifyClientID 注册使用的ID与客户端提供的不同时告知客户端，客户端重连时应使用新ID
+func (s *Server) notifyClientID(client *ClientInfo, requested, legacyID string) {
+	if client.ID == requested || (requested == "" && s.clientIDs.config.Scheme == ClientIDSchemeClient) {
+		return
+	}
+	msg := map[string]interface{}{
+		"type":         protocol.TypeClientIDAssigned,
+		"client_id":    client.ID,
+		"requested_id": requested,
+		"scheme":       s.clientIDs.config.Scheme,
+		"timestamp":    time.Now().Unix(),
+	}
+	if legacyID != "" {
+		msg["legacy_id"] = legacyID
+	}
+	client.writer.WriteJSON(msg)
+}
+
+// resolveClientID 管理API以旧ID指定不在线的客户端时换成它映射到的ID
+func (s *Server) resolveClientID(clientID string) string {
+	if _, online := registry.Get(clientID); online {
+		return clientID
+	}
+	if target, ok := s.clientIDs.alias(clientID); ok {
+		return target
+	}
+	return clientID
+}
+
+// clientIDStats 客户端ID方案的统计，供 /api/metrics 使用
+func (s *Server) clientIDStats() map[string]interface{} {
+	ids := s.clientIDs
+	return map[string]interface{}{
+		"scheme":          ids.config.Scheme,
+		"legacy":          ids.config.Legacy,
+		"assigned":        ids.assigned.Load(),
+		"mapped":          ids.mapped.Load(),
+		"legacy_accepted": ids.legacy.Load(),
+		"rejected":        ids.rejected.Load(),
+	}
+}
+
+// ClientAlias 旧客户端ID的映射，source 为 config（配置文件）或 registry（legacy=map 时记录）
+type ClientAlias struct {
+	LegacyID  string     `json:"legacy_id"`
+	ClientID  string     `json:"client_id"`
+	Source    string     `json:"source"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// handleClientAliases 客户端别名API
+// GET /api/client-aliases 列出配置的和记录的别名
+// DELETE /api/client-aliases/{legacy_id} 删除记录的别名，旧客户端下次注册时按 legacy 重新处理
+func (s *Server) handleClientAliases(w http.ResponseWriter, r *http.Request) {
+	legacyID := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/client-aliases"), "/")
+	switch {
+	case r.Method == "GET" && legacyID == "":
+		aliases := make([]ClientAlias, 0, len(s.clientIDs.config.Aliases))
+		for legacy, clientID := range s.clientIDs.config.Aliases {
+			aliases = append(aliases, ClientAlias{LegacyID: legacy, ClientID: clientID, Source: "config"})
+		}
+		for _, alias := range registry.Aliases() {
+			if _, configured := s.clientIDs.config.Aliases[alias.LegacyID]; configured {
+				continue
+			}
+			created := alias.CreatedAt
+			aliases = append(aliases, ClientAlias{LegacyID: alias.LegacyID, ClientID: alias.ClientID, Source: "registry", CreatedAt: &created})
+		}
+		sort.Slice(aliases, func(i, j int) bool { return aliases[i].LegacyID < aliases[j].LegacyID })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"scheme":  s.clientIDs.config.Scheme,
+			"legacy":  s.clientIDs.config.Legacy,
+			"total":   len(aliases),
+			"aliases": aliases,
+		})
+	case r.Method == "DELETE" && legacyID != "":
+		if _, configured := s.clientIDs.config.Aliases[legacyID]; configured {
+			http.Error(w, "配置文件中的别名需修改 client_ids.aliases 删除", http.StatusConflict)
+			return
+		}
+		if !registry.DeleteAlias(legacyID) {
+			http.Error(w, "别名不存在: "+legacyID, http.StatusNotFound)
+			return
+		}
+		log.Printf("删除客户端别名 %s", legacyID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "支持 GET /api/client-aliases 和 DELETE /api/client-aliases/{legacy_id}", http.StatusMethodNotAllowed)
+	}
+}
+
+// uuidPattern 小写或大写的标准UUID格式
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func isUUID(id string) bool {
+	return uuidPattern.MatchString(id)
+}
+
+// newUUID 生成随机的UUID（版本4）
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// 随机数不可用时退回时间戳，仍保持UUID格式
+		binaryTime := uint64(time.Now().UnixNano())
+		for i := 0; i < 8; i++ {
+			b[i] = byte(binaryTime >> (8 * i))
+		}
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
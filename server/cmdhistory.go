@@ -0,0 +1,205 @@
+package server
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"websocket-loadbalance/registry"
+)
+
+// CommandHistoryConfig 按客户端保留最近发出的指令及其响应，通过 GET /api/clients/{id}/commands 查看，
+// 便于了解设备最近被要求做了什么、结果如何，不需要翻日志或按 request_id 逐条查询
+type CommandHistoryConfig struct {
+	Enabled        bool `json:"enabled" yaml:"enabled"`
+	Size           int  `json:"size" yaml:"size"`                         // 每个客户端保留的最近指令数，默认20
+	MaxClients     int  `json:"max_clients" yaml:"max_clients"`           // 最多为多少个客户端保留记录，默认10000，超出时淘汰最久没有收到指令的客户端
+	MaxPayloadSize int  `json:"max_payload_size" yaml:"max_payload_size"` // 指令和响应数据超过该字节数时只记录大小，默认4096
+}
+
+// Validate 校验指令历史配置
+func (c CommandHistoryConfig) Validate() error {
+	if c.Size < 0 || c.MaxClients < 0 || c.MaxPayloadSize < 0 {
+		return fmt.Errorf("command_history 的参数不能为负数")
+	}
+	return nil
+}
+
+// SetCommandHistory 设置按客户端保留的指令历史（需在Start之前调用）
+func (s *Server) SetCommandHistory(cfg CommandHistoryConfig) {
+	if !cfg.Enabled {
+		s.commandHistory = nil
+		return
+	}
+	if cfg.Size <= 0 {
+		cfg.Size = 20
+	}
+	if cfg.MaxClients <= 0 {
+		cfg.MaxClients = 10000
+	}
+	if cfg.MaxPayloadSize <= 0 {
+		cfg.MaxPayloadSize = 4096
+	}
+	s.commandHistory = &commandHistory{config: cfg, clients: make(map[string]*list.Element), lru: list.New()}
+}
+
+// clientCommands 单个客户端最近的指令，按发出顺序
+type clientCommands struct {
+	clientID string
+	records  []*CommandRecord
+}
+
+// commandHistory 节点上各客户端最近的指令，客户端断开后保留，重连时继续追加
+type commandHistory struct {
+	config   CommandHistoryConfig
+	mu       sync.Mutex
+	clients  map[string]*list.Element // 客户端ID -> lru中的 *clientCommands
+	lru      *list.List               // 按最后一次收到指令排序，最近的在前
+	recorded atomic.Int64             // 记录的指令数
+	evicted  atomic.Int64             // 因 max_clients 淘汰的客户端数
+}
+
+// add 追加一条指令，超过 size 时丢弃该客户端最早的指令
+func (h *commandHistory) add(record *CommandRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	elem, ok := h.clients[record.ClientID]
+	if ok {
+		h.lru.MoveToFront(elem)
+	} else {
+		if len(h.clients) >= h.config.MaxClients {
+			h.evictOldestUnsafe()
+		}
+		elem = h.lru.PushFront(&clientCommands{clientID: record.ClientID})
+		h.clients[record.ClientID] = elem
+	}
+	c := elem.Value.(*clientCommands)
+	c.records = append(c.records, record)
+	if len(c.records) > h.config.Size {
+		c.records = c.records[len(c.records)-h.config.Size:]
+	}
+	h.recorded.Add(1)
+}
+
+// evictOldestUnsafe 淘汰最久没有收到指令的客户端（调用方持有 mu）
+func (h *commandHistory) evictOldestUnsafe() {
+	elem := h.lru.Back()
+	if elem == nil {
+		return
+	}
+	h.lru.Remove(elem)
+	delete(h.clients, elem.Value.(*clientCommands).clientID)
+	h.evicted.Add(1)
+}
+
+// update 修改客户端的一条指令（收到响应、投递状态变化），指令已被淘汰时忽略
+func (h *commandHistory) update(clientID, requestID string, fn func(record *CommandRecord)) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	elem, ok := h.clients[clientID]
+	if !ok {
+		return
+	}
+	c := elem.Value.(*clientCommands)
+	for i := len(c.records) - 1; i >= 0; i-- {
+		if c.records[i].RequestID == requestID {
+			fn(c.records[i])
+			return
+		}
+	}
+}
+
+// snapshot 客户端最近的指令（副本），按发出顺序；没有记录时ok为false
+func (h *commandHistory) snapshot(clientID string) (records []CommandRecord, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	elem, ok := h.clients[clientID]
+	if !ok {
+		return nil, false
+	}
+	c := elem.Value.(*clientCommands)
+	records = make([]CommandRecord, len(c.records))
+	for i, record := range c.records {
+		records[i] = *record
+	}
+	return records, true
+}
+
+// commandHistoryStats 导出指令历史统计
+func (s *Server) commandHistoryStats() map[string]interface{} {
+	if s.commandHistory == nil {
+		return map[string]interface{}{"enabled": false}
+	}
+	s.commandHistory.mu.Lock()
+	clients := len(s.commandHistory.clients)
+	s.commandHistory.mu.Unlock()
+	return map[string]interface{}{
+		"enabled":  true,
+		"size":     s.commandHistory.config.Size,
+		"clients":  clients,
+		"recorded": s.commandHistory.recorded.Load(),
+		"evicted":  s.commandHistory.evicted.Load(),
+	}
+}
+
+// handleClientCommands GET /api/clients/{id}/commands: 客户端最近的指令及其响应，按发出顺序，
+// last_response 为最近一条收到响应的指令。查询参数 limit 只返回最后若干条。客户端在其他节点在线时转发到该节点
+func (s *Server) handleClientCommands(w http.ResponseWriter, r *http.Request, clientID string) {
+	if r.Method != "GET" {
+		http.Error(w, "仅支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "limit 应为非负整数", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	if client, online := registry.Get(clientID); online && client.NodeID != s.nodeID && r.Header.Get(historyForwardedHeader) == "" {
+		s.forwardHistoryToNode(w, r, client)
+		return
+	}
+	if s.commandHistory == nil {
+		http.Error(w, "节点 "+s.nodeID+" 未启用指令历史 (server.command_history.enabled)", http.StatusNotFound)
+		return
+	}
+	records, ok := s.commandHistory.snapshot(clientID)
+	if !ok {
+		http.Error(w, "节点 "+s.nodeID+" 没有客户端 "+clientID+" 的指令历史", http.StatusNotFound)
+		return
+	}
+
+	var lastResponse *CommandRecord
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Status == CommandStatusResponded {
+			lastResponse = &records[i]
+			break
+		}
+	}
+	if limit > 0 && len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	_, online := registry.Get(clientID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"client_id":     clientID,
+		"node":          s.nodeID,
+		"online":        online,
+		"capacity":      s.commandHistory.config.Size,
+		"total":         len(records),
+		"commands":      records,
+		"last_response": lastResponse,
+	})
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/tracing"
+)
+
+// 分别统计的指令类型数上限，超出后的新指令类型合并计入 otherCommands
+const (
+	maxLatencyCommands = 100
+	otherCommands      = "_other"
+)
+
+// commandResponseTimeout 指令超过该时间未收到响应时计为超时，不再等待
+const commandResponseTimeout = maxCommandTimeout
+
+var errCommandResponseTimeout = errors.New("等待客户端响应超时")
+
+// acceptedCommand 已受理、等待客户端响应的指令
+type acceptedCommand struct {
+	command    string
+	acceptedAt time.Time
+	span       *tracing.Span // 收到响应时结束的 command.deliver span，未启用追踪时为nil
+}
+
+// commandLatency 按指令类型统计从节点受理指令到收到客户端响应的时延，
+// 受理时间包括在指令并发队列中等待的时间
+type commandLatency struct {
+	mu        sync.Mutex
+	pending   map[string]acceptedCommand // request_id -> 受理的指令
+	total     *protocol.LatencyHistogram
+	commands  map[string]*protocol.LatencyHistogram
+	lastPurge time.Time
+}
+
+func newCommandLatency() *commandLatency {
+	return &commandLatency{
+		pending:  make(map[string]acceptedCommand),
+		total:    protocol.NewLatencyHistogram(),
+		commands: make(map[string]*protocol.LatencyHistogram),
+	}
+}
+
+// accept 记录受理的指令
+func (c *commandLatency) accept(requestID, command string, span *tracing.Span) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.purgeUnsafe(now)
+	c.pending[requestID] = acceptedCommand{command: command, acceptedAt: now, span: span}
+}
+
+// cancel 指令未能发送时取消记录，reason记录到追踪span中
+func (c *commandLatency) cancel(requestID string, reason error) {
+	c.mu.Lock()
+	accepted, ok := c.pending[requestID]
+	delete(c.pending, requestID)
+	c.mu.Unlock()
+	if ok {
+		accepted.span.SetError(reason)
+		accepted.span.End()
+	}
+}
+
+// traceparent 指令的追踪上下文，随指令发给客户端
+func (c *commandLatency) traceparent(requestID string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pending[requestID].span.Traceparent()
+}
+
+// respond 收到客户端响应时记录时延，requestID不是本节点受理的指令（或已超时）时返回false
+func (c *commandLatency) respond(requestID, result string) bool {
+	c.mu.Lock()
+	accepted, ok := c.pending[requestID]
+	if !ok {
+		c.mu.Unlock()
+		return false
+	}
+	delete(c.pending, requestID)
+	failed := result != "success"
+	ms := float64(time.Since(accepted.acceptedAt)) / float64(time.Millisecond)
+	c.total.Observe(ms, failed)
+	c.histogramUnsafe(accepted.command).Observe(ms, failed)
+	c.mu.Unlock()
+
+	accepted.span.SetAttribute("command.result", result)
+	if failed {
+		accepted.span.SetError(fmt.Errorf("客户端返回 %s", result))
+	}
+	accepted.span.End()
+	return true
+}
+
+// histogramUnsafe 返回指令类型的直方图，类型数达到上限后的新类型共用一个
+func (c *commandLatency) histogramUnsafe(command string) *protocol.LatencyHistogram {
+	h, ok := c.commands[command]
+	if ok {
+		return h
+	}
+	if len(c.commands) >= maxLatencyCommands {
+		command = otherCommands
+		if h, ok = c.commands[command]; ok {
+			return h
+		}
+	}
+	h = protocol.NewLatencyHistogram()
+	c.commands[command] = h
+	return h
+}
+
+// purgeUnsafe 每秒最多一次，将超时未响应的指令计为超时
+func (c *commandLatency) purgeUnsafe(now time.Time) {
+	if now.Sub(c.lastPurge) < time.Second {
+		return
+	}
+	c.lastPurge = now
+	for requestID, accepted := range c.pending {
+		if now.Sub(accepted.acceptedAt) > commandResponseTimeout {
+			delete(c.pending, requestID)
+			c.total.Timeouts++
+			c.histogramUnsafe(accepted.command).Timeouts++
+			accepted.span.SetError(errCommandResponseTimeout)
+			accepted.span.End()
+		}
+	}
+}
+
+// CommandLatencyReport 节点的指令时延统计
+type CommandLatencyReport struct {
+	NodeID    string                                `json:"node_id"`
+	BucketsMs []float64                             `json:"buckets_ms"`
+	Pending   int                                   `json:"pending"` // 已受理、尚未收到响应的指令数
+	Total     *protocol.LatencyHistogram            `json:"total"`
+	Commands  map[string]*protocol.LatencyHistogram `json:"commands"`
+}
+
+// commandLatencyReport 导出指令时延统计，command非空时只返回该指令类型
+func (s *Server) commandLatencyReport(command string) *CommandLatencyReport {
+	c := s.commandLatency
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.purgeUnsafe(time.Now())
+
+	report := &CommandLatencyReport{
+		NodeID:    s.nodeID,
+		BucketsMs: protocol.LatencyBucketsMs,
+		Pending:   len(c.pending),
+		Total:     c.total.Clone().Summarize(),
+		Commands:  make(map[string]*protocol.LatencyHistogram, len(c.commands)),
+	}
+	for name, h := range c.commands {
+		if command == "" || name == command {
+			report.Commands[name] = h.Clone().Summarize()
+		}
+	}
+	return report
+}
+
+// handleCommandLatency 指令时延统计: GET /api/command-latency?command=restart
+func (s *Server) handleCommandLatency(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "仅支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.commandLatencyReport(r.URL.Query().Get("command")))
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/tracing"
+)
+
+var (
+	errCommandQueueFull   = errors.New("客户端指令队列已满")
+	errCommandSendFailed  = errors.New("指令发送失败")
+	errCommandWaitTimeout = errors.New("同步等待超时，排队的指令未发送")
+)
+
+// CommandConcurrencyConfig 每个客户端同时执行的指令数限制，超出的指令排队，MaxInFlight为0表示不限制
+type CommandConcurrencyConfig struct {
+	MaxInFlight int               `json:"max_in_flight" yaml:"max_in_flight"` // 已发送但未响应的指令数上限
+	MaxQueue    int               `json:"max_queue" yaml:"max_queue"`         // 排队的指令数上限，默认100，队列满时拒绝新指令
+	SlotTimeout protocol.Duration `json:"slot_timeout" yaml:"slot_timeout"`   // 未收到响应的指令最多占用名额的时间，默认30s
+}
+
+// Validate 校验指令并发配置
+func (c CommandConcurrencyConfig) Validate() error {
+	if c.MaxInFlight < 0 || c.MaxQueue < 0 || c.SlotTimeout < 0 {
+		return fmt.Errorf("command_concurrency 的参数不能为负数")
+	}
+	return nil
+}
+
+// SetCommandConcurrency 设置每个客户端的指令并发限制（需在Start之前调用）
+func (s *Server) SetCommandConcurrency(cfg CommandConcurrencyConfig) {
+	if cfg.MaxQueue <= 0 {
+		cfg.MaxQueue = 100
+	}
+	if cfg.SlotTimeout <= 0 {
+		cfg.SlotTimeout = protocol.Duration(30 * time.Second)
+	}
+	s.commandConcurrency = cfg
+}
+
+// queuedCommand 排队等待发送的指令
+type queuedCommand struct {
+	command   string
+	data      interface{}
+	requestID string
+}
+
+// commandSlots 单个客户端的在途指令和等待队列
+type commandSlots struct {
+	mu       sync.Mutex
+	inFlight map[string]*time.Timer // request_id -> 名额超时
+	queue    []queuedCommand
+	closed   bool
+}
+
+func newCommandSlots() *commandSlots {
+	return &commandSlots{inFlight: make(map[string]*time.Timer)}
+}
+
+// CommandStats 客户端的指令并发状态
+type CommandStats struct {
+	InFlight    int `json:"in_flight"`
+	Queued      int `json:"queued"`
+	MaxInFlight int `json:"max_in_flight"`
+}
+
+func (c *commandSlots) snapshot(max int) *CommandStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &CommandStats{InFlight: len(c.inFlight), Queued: len(c.queue), MaxInFlight: max}
+}
+
+// CommandConcurrencyMetrics 指令并发统计
+type CommandConcurrencyMetrics struct {
+	queued   atomic.Int64 // 曾经排队的指令数
+	rejected atomic.Int64 // 因队列满被拒绝的指令数
+	timedOut atomic.Int64 // 超时未响应而释放名额的指令数
+}
+
+// dispatchCommand 向本地客户端发送指令。启用并发限制时，在途指令达到上限后新指令进入队列，
+// 返回排队位置（0表示已直接发送）；客户端收到响应或名额超时后依次发送排队的指令。
+// parent为指令所属的追踪上下文，command.deliver span在收到客户端响应时结束
+func (s *Server) dispatchCommand(clientID, command string, data interface{}, requestID string, parent tracing.SpanContext) (int, error) {
+	// 每条指令都带request_id，按其关联客户端的响应，用于统计时延和释放并发名额
+	if requestID == "" {
+		requestID = s.pendingCommands.newID(s.nodeID)
+	}
+	span := tracing.Start("command.deliver", tracing.KindProducer, parent)
+	span.SetAttribute("command", command)
+	span.SetAttribute("client.id", clientID)
+	span.SetAttribute("request.id", requestID)
+	span.SetAttribute("node.id", s.nodeID)
+	s.commandLatency.accept(requestID, command, span)
+	position, err := s.sendOrQueueCommand(clientID, command, data, requestID)
+	s.recordCommand(requestID, clientID, command, data, position, err)
+	if err != nil {
+		s.commandLatency.cancel(requestID, err)
+	} else if position > 0 {
+		span.SetAttribute("command.queue_position", position)
+	}
+	return position, err
+}
+
+// sendOrQueueCommand 直接发送指令，或在并发名额已满时放入客户端的指令队列
+func (s *Server) sendOrQueueCommand(clientID, command string, data interface{}, requestID string) (int, error) {
+	s.clientsMu.RLock()
+	client, exists := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	if !exists {
+		return 0, errCommandSendFailed
+	}
+	if client.commands == nil {
+		if !s.writeCommand(client, command, data, requestID) {
+			return 0, errCommandSendFailed
+		}
+		return 0, nil
+	}
+
+	slots := client.commands
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+	if slots.closed {
+		return 0, errCommandSendFailed
+	}
+	if len(slots.inFlight) < s.commandConcurrency.MaxInFlight && len(slots.queue) == 0 {
+		if !s.writeCommand(client, command, data, requestID) {
+			return 0, errCommandSendFailed
+		}
+		s.occupySlotUnsafe(client, requestID)
+		return 0, nil
+	}
+	if len(slots.queue) >= s.commandConcurrency.MaxQueue {
+		s.commandMetrics.rejected.Add(1)
+		return 0, errCommandQueueFull
+	}
+	slots.queue = append(slots.queue, queuedCommand{command: command, data: data, requestID: requestID})
+	s.commandMetrics.queued.Add(1)
+	log.Printf("客户端 %s 在途指令已达上限 %d，指令 %s 排队 (第%d位)", clientID,
+		s.commandConcurrency.MaxInFlight, command, len(slots.queue))
+	return len(slots.queue), nil
+}
+
+// occupySlotUnsafe 登记一条在途指令，超时未响应时自动释放名额（调用方持有slots.mu）
+func (s *Server) occupySlotUnsafe(client *ClientInfo, requestID string) {
+	client.commands.inFlight[requestID] = time.AfterFunc(time.Duration(s.commandConcurrency.SlotTimeout), func() {
+		if s.releaseCommandSlot(client, requestID) {
+			s.commandMetrics.timedOut.Add(1)
+			log.Printf("客户端 %s 的指令 %s 超时未响应，释放名额", client.ID, requestID)
+		}
+	})
+}
+
+// releaseCommandSlot 指令完成（收到响应或超时）后释放名额并发送排队的指令，返回该指令是否在途
+func (s *Server) releaseCommandSlot(client *ClientInfo, requestID string) bool {
+	slots := client.commands
+	if slots == nil {
+		return false
+	}
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+
+	timer, ok := slots.inFlight[requestID]
+	if !ok {
+		return false
+	}
+	timer.Stop()
+	delete(slots.inFlight, requestID)
+
+	for !slots.closed && len(slots.inFlight) < s.commandConcurrency.MaxInFlight && len(slots.queue) > 0 {
+		next := slots.queue[0]
+		slots.queue = slots.queue[1:]
+		if !s.writeCommand(client, next.command, next.data, next.requestID) {
+			continue
+		}
+		s.occupySlotUnsafe(client, next.requestID)
+	}
+	return true
+}
+
+// cancelQueuedCommand 同步指令等待超时后，将仍在排队的指令移出队列
+func (s *Server) cancelQueuedCommand(clientID, requestID string) {
+	s.clientsMu.RLock()
+	client, exists := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	if !exists || client.commands == nil {
+		return
+	}
+
+	slots := client.commands
+	slots.mu.Lock()
+	defer slots.mu.Unlock()
+	for i, queued := range slots.queue {
+		if queued.requestID == requestID {
+			slots.queue = append(slots.queue[:i], slots.queue[i+1:]...)
+			s.commandLatency.cancel(requestID, errCommandWaitTimeout)
+			return
+		}
+	}
+}
+
+// close 客户端断开时丢弃排队的指令
+func (c *commandSlots) close(clientID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for _, timer := range c.inFlight {
+		timer.Stop()
+	}
+	if len(c.queue) > 0 {
+		log.Printf("客户端 %s 断开，丢弃 %d 条排队的指令", clientID, len(c.queue))
+	}
+	c.queue = nil
+}
+
+// writeQueueFull 指令队列已满时返回429
+func writeQueueFull(w http.ResponseWriter, nodeID, clientID string) {
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   false,
+		"code":      "command_queue_full",
+		"node":      nodeID,
+		"client_id": clientID,
+		"error":     errCommandQueueFull.Error(),
+	})
+}
+
+// commandConcurrencyStats 导出指令并发统计
+func (s *Server) commandConcurrencyStats() map[string]interface{} {
+	inFlight, queued := 0, 0
+	s.clientsMu.RLock()
+	clients := make([]*ClientInfo, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.clientsMu.RUnlock()
+	for _, client := range clients {
+		if client.commands != nil {
+			stats := client.commands.snapshot(s.commandConcurrency.MaxInFlight)
+			inFlight += stats.InFlight
+			queued += stats.Queued
+		}
+	}
+
+	return map[string]interface{}{
+		"max_in_flight": s.commandConcurrency.MaxInFlight,
+		"max_queue":     s.commandConcurrency.MaxQueue,
+		"in_flight":     inFlight,
+		"queued":        queued,
+		"total_queued":  s.commandMetrics.queued.Load(),
+		"rejected":      s.commandMetrics.rejected.Load(),
+		"slot_timeouts": s.commandMetrics.timedOut.Load(),
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+)
+
+// codecConn 协商了二进制编码（msgpack/protobuf）的连接：服务端内部仍按JSON处理消息，
+// 写出时将JSON文本帧转换为二进制帧。广播的预编码帧也经由这里逐连接转换
+type codecConn struct {
+	*websocket.Conn
+	codec protocol.Codec
+}
+
+func (c *codecConn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(websocket.TextMessage, data)
+}
+
+func (c *codecConn) WriteMessage(messageType int, data []byte) error {
+	if messageType == websocket.TextMessage {
+		encoded, err := c.codec.FromJSON(data)
+		if err != nil {
+			return err
+		}
+		messageType, data = websocket.BinaryMessage, encoded
+	}
+	return c.Conn.WriteMessage(messageType, data)
+}
+
+// negotiateCodec 按握手请求的子协议选择消息编码，选中时在响应头中回应该子协议
+// （取代通过子协议传递令牌时回应的 access_token，两者都在客户端提供的列表中）
+func negotiateCodec(r *http.Request, header http.Header) (protocol.Codec, http.Header) {
+	codec := protocol.NegotiateCodec(websocket.Subprotocols(r))
+	if codec == nil {
+		return nil, header
+	}
+	return codec, http.Header{"Sec-WebSocket-Protocol": {codec.Name()}}
+}
+
+// decodeFrame 将二进制帧按协商的编码转换为JSON，文本帧原样返回
+func decodeFrame(codec protocol.Codec, messageType int, data []byte) ([]byte, error) {
+	if codec == nil || messageType != websocket.BinaryMessage {
+		return data, nil
+	}
+	return codec.ToJSON(data)
+}
+
+// connEncoding 连接使用的消息编码名称
+func connEncoding(conn wsConn) string {
+	if c, ok := unwrapConn(conn).(*codecConn); ok {
+		return c.codec.Name()
+	}
+	return protocol.EncodingJSON
+}
@@ -0,0 +1,525 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+)
+
+// 指令记录的状态
+const (
+	CommandStatusSent      = "sent"      // 已发给客户端，等待响应
+	CommandStatusQueued    = "queued"    // 客户端在途指令已达上限，在节点上排队
+	CommandStatusFailed    = "failed"    // 发送失败，客户端不会收到
+	CommandStatusResponded = "responded" // 已收到客户端的响应
+)
+
+// CommandLogConfig 指令及其响应的持久化，之后可以通过 GET /api/commands/{request_id} 查询结果
+type CommandLogConfig struct {
+	Store          string            `json:"store" yaml:"store"`                       // none(默认)、memory 或 file
+	File           string            `json:"file" yaml:"file"`                         // file存储的路径，{node_id} 替换为节点ID，默认 commands-{node_id}.jsonl
+	Retention      protocol.Duration `json:"retention" yaml:"retention"`               // 记录保留时长，默认24h
+	MaxRecords     int               `json:"max_records" yaml:"max_records"`           // 最多保留的记录数，默认100000
+	MaxPayloadSize int               `json:"max_payload_size" yaml:"max_payload_size"` // 指令和响应数据超过该字节数时只记录大小，默认16384
+}
+
+// Validate 检查指令记录配置
+func (c CommandLogConfig) Validate() error {
+	switch c.Store {
+	case "", "none", "memory", "file":
+	default:
+		return fmt.Errorf("无效的指令记录存储类型: %s", c.Store)
+	}
+	if c.Retention < 0 || c.MaxRecords < 0 || c.MaxPayloadSize < 0 {
+		return fmt.Errorf("command_log 的参数不能为负数")
+	}
+	return nil
+}
+
+// CommandRecord 一条指令及其响应
+type CommandRecord struct {
+	RequestID   string          `json:"request_id"`
+	ClientID    string          `json:"client_id"`
+	Node        string          `json:"node"` // 发出指令的节点
+	Command     string          `json:"command"`
+	Data        json.RawMessage `json:"data,omitempty"`
+	DataSize    int             `json:"data_size"`
+	Status      string          `json:"status"`
+	Error       string          `json:"error,omitempty"` // 发送失败的原因
+	SentAt      time.Time       `json:"sent_at"`
+	RespondedAt *time.Time      `json:"responded_at,omitempty"`
+	LatencyMs   float64         `json:"latency_ms,omitempty"` // 从发出到收到响应的时长
+	Result      string          `json:"result,omitempty"`     // 客户端响应中的 result，如 success/error
+	Message     string          `json:"message,omitempty"`
+	Response    json.RawMessage `json:"response,omitempty"`  // 客户端响应中的 data
+	Truncated   bool            `json:"truncated,omitempty"` // data 或 response 超过 max_payload_size 未保存
+	Delivery    *DeliveryStatus `json:"delivery,omitempty"`  // 需要客户端确认的指令（启用 command_delivery）的投递状态
+}
+
+// CommandStore 指令记录的存储。内置 memory 和 file 两种，
+// 也可以通过 SetCommandStore 接入 SQLite 等外部存储
+type CommandStore interface {
+	// Save 新增记录，或覆盖同一 request_id 的记录
+	Save(record *CommandRecord) error
+	// Get 按 request_id 查询，不存在时返回 nil, nil
+	Get(requestID string) (*CommandRecord, error)
+	Close() error
+}
+
+// NewCommandStore 根据配置创建指令记录存储，store为空或none时返回nil
+func NewCommandStore(cfg CommandLogConfig, nodeID string) (CommandStore, error) {
+	if cfg.Retention <= 0 {
+		cfg.Retention = protocol.Duration(24 * time.Hour)
+	}
+	if cfg.MaxRecords <= 0 {
+		cfg.MaxRecords = 100000
+	}
+	memory := newMemoryCommandStore(time.Duration(cfg.Retention), cfg.MaxRecords)
+
+	switch cfg.Store {
+	case "", "none":
+		return nil, nil
+	case "memory":
+		return memory, nil
+	case "file":
+		if cfg.File == "" {
+			cfg.File = "commands-{node_id}.jsonl"
+		}
+		return openFileCommandStore(strings.ReplaceAll(cfg.File, "{node_id}", nodeID), memory)
+	default:
+		return nil, fmt.Errorf("无效的指令记录存储类型: %s", cfg.Store)
+	}
+}
+
+// memoryCommandStore 在内存中保留最近的指令记录，按发出时间淘汰超过保留时长或数量上限的记录
+type memoryCommandStore struct {
+	mu         sync.Mutex
+	records    map[string]*CommandRecord
+	order      []string // 按首次保存的顺序
+	retention  time.Duration
+	maxRecords int
+}
+
+func newMemoryCommandStore(retention time.Duration, maxRecords int) *memoryCommandStore {
+	return &memoryCommandStore{
+		records:    make(map[string]*CommandRecord),
+		retention:  retention,
+		maxRecords: maxRecords,
+	}
+}
+
+func (m *memoryCommandStore) Save(record *CommandRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.putUnsafe(record)
+	return nil
+}
+
+func (m *memoryCommandStore) putUnsafe(record *CommandRecord) {
+	copied := *record
+	if _, exists := m.records[record.RequestID]; !exists {
+		m.order = append(m.order, record.RequestID)
+	}
+	m.records[record.RequestID] = &copied
+	m.pruneUnsafe(time.Now())
+}
+
+// pruneUnsafe 淘汰超过数量上限和保留时长的记录（调用方持有锁）
+func (m *memoryCommandStore) pruneUnsafe(now time.Time) {
+	expired := now.Add(-m.retention)
+	for len(m.order) > 0 {
+		oldest, exists := m.records[m.order[0]]
+		if exists && len(m.records) <= m.maxRecords && oldest.SentAt.After(expired) {
+			return
+		}
+		delete(m.records, m.order[0])
+		m.order = m.order[1:]
+	}
+}
+
+func (m *memoryCommandStore) Get(requestID string) (*CommandRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, exists := m.records[requestID]
+	if !exists || record.SentAt.Before(time.Now().Add(-m.retention)) {
+		return nil, nil
+	}
+	copied := *record
+	return &copied, nil
+}
+
+func (m *memoryCommandStore) Close() error {
+	return nil
+}
+
+// fileCommandStore 在内存存储之外把每次保存追加到JSON Lines文件，启动时重放恢复；
+// 文件中的过期行超过有效记录数时重写文件
+type fileCommandStore struct {
+	*memoryCommandStore
+	path  string
+	file  *os.File
+	lines int // 文件中的行数
+}
+
+func openFileCommandStore(path string, memory *memoryCommandStore) (*fileCommandStore, error) {
+	s := &fileCommandStore{memoryCommandStore: memory, path: path}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.rewrite(); err != nil {
+		return nil, err
+	}
+	if len(s.records) > 0 {
+		log.Printf("从 %s 恢复了 %d 条指令记录", path, len(s.records))
+	}
+	return s, nil
+}
+
+// load 重放文件中的记录，后面的行覆盖同一 request_id 的前面的行
+func (s *fileCommandStore) load() error {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var record CommandRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil || record.RequestID == "" {
+			continue // 上次写入中断留下的不完整行
+		}
+		s.putUnsafe(&record)
+	}
+	return scanner.Err()
+}
+
+// rewrite 只保留有效记录重写文件，之后以追加方式写入（调用方持有锁或在打开时调用）
+func (s *fileCommandStore) rewrite() error {
+	tmp := s.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, requestID := range s.order {
+		if err := encoder.Encode(s.records[requestID]); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644)
+	s.lines = len(s.order)
+	return err
+}
+
+func (s *fileCommandStore) Save(record *CommandRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.putUnsafe(record)
+	if s.file == nil {
+		return fmt.Errorf("指令记录文件已关闭")
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.lines++
+	if s.lines > 2*len(s.records)+1000 {
+		return s.rewrite()
+	}
+	return nil
+}
+
+func (s *fileCommandStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// SetCommandLog 按配置记录指令及其响应（需在Start之前调用），存储打开失败时不记录
+func (s *Server) SetCommandLog(cfg CommandLogConfig) {
+	if cfg.MaxPayloadSize <= 0 {
+		cfg.MaxPayloadSize = 16384
+	}
+	s.commandLog = cfg
+	store, err := NewCommandStore(cfg, s.nodeID)
+	if err != nil {
+		log.Printf("节点 %s 打开指令记录存储失败，不记录指令: %v", s.nodeID, err)
+		return
+	}
+	s.commandStore = store
+}
+
+// SetCommandStore 使用自定义的指令记录存储（需在Start之前调用），nil表示不记录
+func (s *Server) SetCommandStore(store CommandStore) {
+	if s.commandLog.MaxPayloadSize <= 0 {
+		s.commandLog.MaxPayloadSize = 16384
+	}
+	s.commandStore = store
+}
+
+// payloadJSON 序列化指令或响应数据，超过limit字节时只返回大小
+func payloadJSON(v interface{}, limit int) (json.RawMessage, int, bool) {
+	if v == nil {
+		return nil, 0, false
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, 0, false
+	}
+	if len(data) > limit {
+		return nil, len(data), true
+	}
+	return data, len(data), false
+}
+
+// recordCommand 记录一条发出的指令，写入指令记录和客户端的指令历史
+func (s *Server) recordCommand(requestID, clientID, command string, data interface{}, position int, sendErr error) {
+	if s.commandStore == nil && s.commandHistory == nil {
+		return
+	}
+	record := &CommandRecord{
+		RequestID: requestID,
+		ClientID:  clientID,
+		Node:      s.nodeID,
+		Command:   command,
+		Status:    CommandStatusSent,
+		SentAt:    time.Now(),
+	}
+	record.Delivery, _, _ = s.deliveries.status(requestID)
+	switch {
+	case sendErr != nil:
+		record.Status = CommandStatusFailed
+		record.Error = sendErr.Error()
+	case position > 0:
+		record.Status = CommandStatusQueued
+	}
+	if s.commandHistory != nil {
+		entry := *record
+		entry.Data, entry.DataSize, entry.Truncated = payloadJSON(data, s.commandHistory.config.MaxPayloadSize)
+		s.commandHistory.add(&entry)
+	}
+	if s.commandStore == nil {
+		return
+	}
+	record.Data, record.DataSize, record.Truncated = payloadJSON(data, s.commandLog.MaxPayloadSize)
+	if err := s.commandStore.Save(record); err != nil {
+		log.Printf("保存指令记录 %s 失败: %v", requestID, err)
+	}
+}
+
+// recordCommandResponse 将客户端的响应写入对应的指令记录和指令历史
+func (s *Server) recordCommandResponse(clientID, requestID, result, message string, data interface{}) {
+	now := time.Now()
+	delivery, _, _ := s.deliveries.status(requestID)
+	respond := func(record *CommandRecord, limit int) {
+		record.Status = CommandStatusResponded
+		record.RespondedAt = &now
+		record.LatencyMs = float64(now.Sub(record.SentAt).Microseconds()) / 1000
+		record.Result, record.Message = result, message
+		var truncated bool
+		record.Response, _, truncated = payloadJSON(data, limit)
+		record.Truncated = record.Truncated || truncated
+		if delivery != nil {
+			record.Delivery = delivery
+		}
+	}
+	s.commandHistory.update(clientID, requestID, func(record *CommandRecord) {
+		respond(record, s.commandHistory.config.MaxPayloadSize)
+	})
+
+	if s.commandStore == nil {
+		return
+	}
+	record, err := s.commandStore.Get(requestID)
+	if err != nil || record == nil {
+		return
+	}
+	respond(record, s.commandLog.MaxPayloadSize)
+	if err := s.commandStore.Save(record); err != nil {
+		log.Printf("保存指令 %s 的响应失败: %v", requestID, err)
+	}
+}
+
+// recordDelivery 将投递状态（重发、确认、失败）写入对应的指令记录和指令历史
+func (s *Server) recordDelivery(clientID, requestID string, delivery *DeliveryStatus) {
+	update := func(record *CommandRecord) {
+		record.Delivery = delivery
+		if failure := delivery.failure(); failure != "" {
+			record.Status, record.Error = CommandStatusFailed, failure
+		}
+	}
+	s.commandHistory.update(clientID, requestID, update)
+
+	if s.commandStore == nil {
+		return
+	}
+	record, err := s.commandStore.Get(requestID)
+	if err != nil || record == nil {
+		return
+	}
+	update(record)
+	if err := s.commandStore.Save(record); err != nil {
+		log.Printf("保存指令 %s 的投递状态失败: %v", requestID, err)
+	}
+}
+
+// deliveryRecord 未启用指令记录或记录已淘汰时，由投递状态构造指令记录，不需要确认的指令返回nil
+func (s *Server) deliveryRecord(requestID string) *CommandRecord {
+	delivery, clientID, command := s.deliveries.status(requestID)
+	if delivery == nil {
+		return nil
+	}
+	record := &CommandRecord{
+		RequestID: requestID,
+		ClientID:  clientID,
+		Node:      s.nodeID,
+		Command:   command,
+		Status:    CommandStatusSent,
+		SentAt:    delivery.FirstSentAt,
+		Delivery:  delivery,
+	}
+	if delivery.State == DeliveryResponded {
+		record.Status = CommandStatusResponded
+	}
+	if failure := delivery.failure(); failure != "" {
+		record.Status, record.Error = CommandStatusFailed, failure
+	}
+	return record
+}
+
+// closeCommandStore 节点关闭时关闭指令记录存储
+func (s *Server) closeCommandStore() {
+	if s.commandStore == nil {
+		return
+	}
+	if err := s.commandStore.Close(); err != nil {
+		log.Printf("关闭指令记录存储失败: %v", err)
+	}
+}
+
+// commandsForwardedHeader 标记已由其他节点转发的指令记录查询，收到的节点只查本地
+const commandsForwardedHeader = "X-Commands-Forwarded"
+
+// handleCommandRecord 指令记录API
+// GET /api/commands/{request_id} 查询指令及其响应。记录保存在发出指令的节点（客户端所在节点），
+// 本节点没有时依次询问其他节点，request_id 以节点ID开头的节点优先
+func (s *Server) handleCommandRecord(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "仅支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	requestID := strings.TrimPrefix(r.URL.Path, "/api/commands/")
+	if requestID == "" || strings.Contains(requestID, "/") {
+		http.Error(w, "路径格式: /api/commands/{request_id}", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	if s.commandStore != nil {
+		record, err := s.commandStore.Get(requestID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if record != nil {
+			json.NewEncoder(w).Encode(record)
+			return
+		}
+	}
+	if record := s.deliveryRecord(requestID); record != nil {
+		json.NewEncoder(w).Encode(record)
+		return
+	}
+
+	if r.Header.Get(commandsForwardedHeader) == "" && s.forwardCommandRecord(w, r, requestID) {
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    false,
+		"code":       "command_not_found",
+		"error":      "没有该指令的记录（未启用 command_log 或 command_delivery、已过期或 request_id 不存在）",
+		"request_id": requestID,
+	})
+}
+
+// forwardCommandRecord 向其他节点查询指令记录，找到时透传响应并返回true
+func (s *Server) forwardCommandRecord(w http.ResponseWriter, r *http.Request, requestID string) bool {
+	// 客户端断开后记录仍在原节点，因此也询问只有离线客户端的节点
+	peers := make(map[string]int)
+	for _, client := range registry.All() {
+		if client.NodeID != s.nodeID {
+			peers[client.NodeID] = client.NodePort
+		}
+	}
+	nodeIDs := make([]string, 0, len(peers))
+	for nodeID := range peers {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.SliceStable(nodeIDs, func(i, j int) bool {
+		return strings.HasPrefix(requestID, nodeIDs[i]+"-") && !strings.HasPrefix(requestID, nodeIDs[j]+"-")
+	})
+
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	for _, nodeID := range nodeIDs {
+		req, err := http.NewRequestWithContext(r.Context(), "GET", s.peerURL(peers[nodeID], "/api/commands/"+requestID), nil)
+		if err != nil {
+			return false
+		}
+		req.Header.Set(commandsForwardedHeader, s.nodeID)
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			log.Printf("向节点 %s 查询指令记录失败: %v", nodeID, err)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			continue
+		}
+		io.Copy(w, resp.Body)
+		resp.Body.Close()
+		return true
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"websocket-loadbalance/protocol"
+)
+
+// 同步指令默认/最大等待时间
+const (
+	defaultCommandTimeout = 10 * time.Second
+	maxCommandTimeout     = 60 * time.Second
+)
+
+// CommandResponse 客户端返回的指令响应
+type CommandResponse struct {
+	Result    string      `json:"result"`
+	Message   string      `json:"message"`
+	Data      interface{} `json:"data"`
+	Timestamp int64       `json:"timestamp"`
+}
+
+// pendingCommands 记录等待客户端响应的同步指令，按request_id关联请求和响应
+type pendingCommands struct {
+	waiters map[string]chan CommandResponse
+	seq     atomic.Uint64
+	mu      sync.Mutex
+}
+
+func newPendingCommands() *pendingCommands {
+	return &pendingCommands{
+		waiters: make(map[string]chan CommandResponse),
+	}
+}
+
+// newID 生成request_id
+func (p *pendingCommands) newID(nodeID string) string {
+	return fmt.Sprintf("%s-%d-%d", nodeID, time.Now().UnixNano(), p.seq.Add(1))
+}
+
+// add 生成request_id并登记等待者
+func (p *pendingCommands) add(nodeID string) (string, chan CommandResponse) {
+	requestID := p.newID(nodeID)
+	ch := make(chan CommandResponse, 1)
+
+	p.mu.Lock()
+	p.waiters[requestID] = ch
+	p.mu.Unlock()
+	return requestID, ch
+}
+
+// remove 取消等待（超时或发送失败）
+func (p *pendingCommands) remove(requestID string) {
+	p.mu.Lock()
+	delete(p.waiters, requestID)
+	p.mu.Unlock()
+}
+
+// resolve 将响应交给等待者，返回false表示没有对应的等待者（已超时或非同步指令）
+func (p *pendingCommands) resolve(requestID string, response CommandResponse) bool {
+	p.mu.Lock()
+	ch, ok := p.waiters[requestID]
+	delete(p.waiters, requestID)
+	p.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+	ch <- response
+	return true
+}
+
+// wait 等待指令响应，超时返回false
+func (p *pendingCommands) wait(requestID string, ch chan CommandResponse, timeout time.Duration) (CommandResponse, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case response := <-ch:
+		return response, true
+	case <-timer.C:
+		p.remove(requestID)
+		return CommandResponse{}, false
+	}
+}
+
+// commandTimeout 规范化同步指令的等待时间
+func commandTimeout(timeout protocol.Duration) time.Duration {
+	d := time.Duration(timeout)
+	if d <= 0 {
+		return defaultCommandTimeout
+	}
+	if d > maxCommandTimeout {
+		return maxCommandTimeout
+	}
+	return d
+}
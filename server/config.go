@@ -0,0 +1,330 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"websocket-loadbalance/forwarded"
+	"websocket-loadbalance/perf"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+)
+
+// NodeConfig 服务端节点配置
+type NodeConfig struct {
+	ID     string            `json:"id" yaml:"id"`
+	Port   int               `json:"port" yaml:"port"`
+	Socket string            `json:"socket" yaml:"socket"` // 同时监听的Unix域套接字，为空表示不监听
+	Labels map[string]string `json:"labels" yaml:"labels"` // 该节点的标签，与 server.labels 合并，同名时以这里为准
+}
+
+// NodeSetConfig 按数量生成多节点模式的节点列表，便于演示和本地压测时启动任意数量的节点
+type NodeSetConfig struct {
+	Count    int      `json:"count" yaml:"count"`         // 节点数，0表示使用 ids 的数量；两者都为空时使用 nodes 列表
+	BasePort int      `json:"base_port" yaml:"base_port"` // 第一个节点的端口，其余节点依次加1，默认8081
+	IDPrefix string   `json:"id_prefix" yaml:"id_prefix"` // 未指定 ids 时节点ID为前缀加序号，默认 node（node1、node2...）
+	IDs      []string `json:"ids" yaml:"ids"`             // 指定各节点的ID
+}
+
+// Validate 校验节点集合配置
+func (c NodeSetConfig) Validate() error {
+	if c.Count < 0 || c.BasePort < 0 {
+		return fmt.Errorf("node_set 的参数不能为负数")
+	}
+	if c.Count > 0 && len(c.IDs) > 0 && c.Count != len(c.IDs) {
+		return fmt.Errorf("node_set.count (%d) 与 node_set.ids 的数量 (%d) 不一致", c.Count, len(c.IDs))
+	}
+	return nil
+}
+
+// nodes 生成的节点列表，未设置节点数和ID时返回nil
+func (c NodeSetConfig) nodes() []NodeConfig {
+	count := c.Count
+	if count == 0 {
+		count = len(c.IDs)
+	}
+	if count == 0 {
+		return nil
+	}
+	basePort := c.BasePort
+	if basePort == 0 {
+		basePort = 8081
+	}
+	prefix := c.IDPrefix
+	if prefix == "" {
+		prefix = "node"
+	}
+	nodes := make([]NodeConfig, count)
+	for i := range nodes {
+		nodes[i] = NodeConfig{ID: prefix + strconv.Itoa(i+1), Port: basePort + i}
+		if len(c.IDs) > 0 {
+			nodes[i].ID = c.IDs[i]
+		}
+	}
+	return nodes
+}
+
+// Config 服务端配置
+type Config struct {
+	Port    int           `json:"port" yaml:"port"`         // 单节点模式端口
+	NodeID  string        `json:"node_id" yaml:"node_id"`   // 单节点模式节点ID
+	Socket  string        `json:"socket" yaml:"socket"`     // 单节点模式同时监听的Unix域套接字，如 unix:///run/ws/node1.sock
+	Nodes   []NodeConfig  `json:"nodes" yaml:"nodes"`       // 多节点模式的节点列表
+	NodeSet NodeSetConfig `json:"node_set" yaml:"node_set"` // 按数量生成多节点模式的节点，设置后取代 nodes
+
+	ListenAddress string `json:"listen_address" yaml:"listen_address"` // 监听的主机地址，为空表示所有地址，如 "::"、"127.0.0.1"、"::1"
+	AddressFamily string `json:"address_family" yaml:"address_family"` // 监听绑定的地址族: dual(默认), ipv4, ipv6
+
+	PingInterval protocol.Duration `json:"ping_interval" yaml:"ping_interval"` // 向客户端发送ping的间隔
+	PongTimeout  protocol.Duration `json:"pong_timeout" yaml:"pong_timeout"`   // 等待pong的超时
+	WriteTimeout protocol.Duration `json:"write_timeout" yaml:"write_timeout"` // 向客户端写一帧的超时，超时后断开连接
+	// 全局注册表文件不可用（写文件失败）时对新注册的处理: accept(默认，操作暂存在内存中，恢复后写回) 或 reject(以1013拒绝)
+	RegistryUnavailable string `json:"registry_unavailable" yaml:"registry_unavailable"`
+
+	Labels map[string]string `json:"labels" yaml:"labels"` // 节点标签，在 /health 中上报，供负载均衡器的 affinity 策略按客户端的亲和性选择节点
+
+	Quota QuotaConfig `json:"quota" yaml:"quota"` // 每个客户端的消息配额
+	Batch BatchConfig `json:"batch" yaml:"batch"` // 消息批量发送
+
+	ConnMode    string `json:"conn_mode" yaml:"conn_mode"`       // gorilla(默认) 或 epoll(实验性，仅Linux)
+	PollWorkers int    `json:"poll_workers" yaml:"poll_workers"` // epoll模式工作协程数，0表示按CPU数自动设置
+
+	Memory       MemoryConfig       `json:"memory" yaml:"memory"`               // 连接内存上限
+	SlowConsumer SlowConsumerConfig `json:"slow_consumer" yaml:"slow_consumer"` // 慢消费者检测
+	MaxClients   int                `json:"max_clients" yaml:"max_clients"`     // 每个节点的最大并发客户端数，0表示不限制
+	RateLimit    RateLimitConfig    `json:"rate_limit" yaml:"rate_limit"`       // 每个客户端的入站消息限流
+
+	MaxMessageSize int64 `json:"max_message_size" yaml:"max_message_size"` // 客户端单条消息的最大字节数，0表示不限制（epoll模式最大1MB）
+
+	CommandConcurrency CommandConcurrencyConfig `json:"command_concurrency" yaml:"command_concurrency"` // 每个客户端的指令并发限制
+	NodeBus            NodeBusConfig            `json:"node_bus" yaml:"node_bus"`                       // 节点之间的消息总线
+	Idempotency        IdempotencyConfig        `json:"idempotency" yaml:"idempotency"`                 // 管理API幂等键
+	Outbox             OutboxConfig             `json:"outbox" yaml:"outbox"`                           // 离线客户端的指令队列
+	CommandDelivery    CommandDeliveryConfig    `json:"command_delivery" yaml:"command_delivery"`       // 指令的确认和重发
+	History            HistoryConfig            `json:"history" yaml:"history"`                         // 按客户端保留最近收发的消息
+	CommandHistory     CommandHistoryConfig     `json:"command_history" yaml:"command_history"`         // 按客户端保留最近的指令及其响应
+	FeatureFlags       FeatureFlagsConfig       `json:"feature_flags" yaml:"feature_flags"`             // 集中管理的功能开关
+	CommandLog         CommandLogConfig         `json:"command_log" yaml:"command_log"`                 // 记录指令及其响应，供之后查询
+
+	ProtocolVersions protocol.VersionRange `json:"protocol_versions" yaml:"protocol_versions"` // 接受的客户端协议版本范围，默认为1到当前版本
+
+	Heartbeat HeartbeatConfig `json:"heartbeat" yaml:"heartbeat"` // 客户端心跳间隔和在线状态判定
+
+	ConnectionLifetime ConnectionLifetimeConfig `json:"connection_lifetime" yaml:"connection_lifetime"` // 连接最长存活时间，到期后要求客户端重连
+
+	RegistryReconcile RegistryReconcileConfig `json:"registry_reconcile" yaml:"registry_reconcile"` // 定期核对全局注册表中本节点的记录
+
+	ClientIDs ClientIDConfig `json:"client_ids" yaml:"client_ids"` // 客户端ID方案：客户端提供、节点分配UUID、按正则校验或取自令牌的 sub
+
+	Forwarded forwarded.Config `json:"forwarded" yaml:"forwarded"` // 受信的负载均衡器地址，采信其转发请求头和PROXY协议头中的客户端地址
+
+	Registration RegistrationConfig `json:"registration" yaml:"registration"` // 启动时向负载均衡器自注册并定期发送心跳
+
+	AuthProvider string `json:"auth_provider" yaml:"auth_provider"` // 节点使用的认证方式（none、jwt、static、http），为空时沿用 auth 中的设置
+}
+
+// DefaultConfig 返回默认的服务端配置（单节点8081，多节点8081-8083）
+func DefaultConfig() Config {
+	return Config{
+		Port:   8081,
+		NodeID: "node1",
+		Nodes: []NodeConfig{
+			{ID: "node1", Port: 8081},
+			{ID: "node2", Port: 8082},
+			{ID: "node3", Port: 8083},
+		},
+		PingInterval: protocol.Duration(20 * time.Second),
+		PongTimeout:  protocol.Duration(10 * time.Second),
+		WriteTimeout: protocol.Duration(10 * time.Second),
+		Quota:        QuotaConfig{WarnRatio: 0.8},
+		Batch:        BatchConfig{Window: protocol.Duration(5 * time.Millisecond), MaxMessages: 64},
+		// 默认部署中负载均衡器与节点在同一主机
+		Forwarded: forwarded.Config{TrustedProxies: []string{"127.0.0.0/8", "::1"}},
+		// 部署中默认启用注册表对账，作为库使用时需显式开启
+		RegistryReconcile: RegistryReconcileConfig{Enabled: true, Interval: protocol.Duration(time.Minute)},
+	}
+}
+
+// MultiNodes 多节点模式启动的节点：设置了 node_set 时按其生成，否则为 nodes 列表
+func (c Config) MultiNodes() []NodeConfig {
+	if nodes := c.NodeSet.nodes(); nodes != nil {
+		return nodes
+	}
+	return c.Nodes
+}
+
+// Validate 校验服务端配置
+func (c Config) Validate() error {
+	if err := ValidateRegistryUnavailablePolicy(c.RegistryUnavailable); err != nil {
+		return err
+	}
+	switch c.ConnMode {
+	case "", ConnModeGorilla, ConnModeEpoll:
+	default:
+		return fmt.Errorf("无效的连接模式: %s (可选: gorilla, epoll)", c.ConnMode)
+	}
+	if c.MaxClients < 0 {
+		return fmt.Errorf("max_clients 不能为负数")
+	}
+	if c.MaxMessageSize < 0 {
+		return fmt.Errorf("max_message_size 不能为负数")
+	}
+	if err := protocol.ValidateAddressFamily(c.AddressFamily); err != nil {
+		return err
+	}
+	if c.Memory.Limit < 0 {
+		return fmt.Errorf("memory.limit 不能为负数")
+	}
+	if err := c.SlowConsumer.Validate(); err != nil {
+		return err
+	}
+	if err := c.RateLimit.Validate(); err != nil {
+		return err
+	}
+	if err := c.CommandConcurrency.Validate(); err != nil {
+		return err
+	}
+	if err := c.NodeBus.Validate(); err != nil {
+		return err
+	}
+	if err := c.Idempotency.Validate(); err != nil {
+		return err
+	}
+	if err := c.Outbox.Validate(); err != nil {
+		return err
+	}
+	if err := c.CommandDelivery.Validate(); err != nil {
+		return err
+	}
+	if err := c.History.Validate(); err != nil {
+		return err
+	}
+	if err := c.CommandHistory.Validate(); err != nil {
+		return err
+	}
+	if err := c.FeatureFlags.Validate(); err != nil {
+		return err
+	}
+	if err := c.CommandLog.Validate(); err != nil {
+		return err
+	}
+	if err := c.Heartbeat.Validate(); err != nil {
+		return err
+	}
+	if err := c.RegistryReconcile.Validate(); err != nil {
+		return err
+	}
+	if err := c.ClientIDs.Validate(); err != nil {
+		return err
+	}
+	if err := c.ConnectionLifetime.Validate(); err != nil {
+		return err
+	}
+	if err := c.Forwarded.Validate(); err != nil {
+		return err
+	}
+	if err := c.Registration.Validate(); err != nil {
+		return err
+	}
+	if err := protocolVersionsOrDefault(c.ProtocolVersions).Validate(); err != nil {
+		return fmt.Errorf("protocol_versions: %v", err)
+	}
+
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("无效的端口: %d", c.Port)
+	}
+	if err := registry.ValidateLabels(c.Labels); err != nil {
+		return fmt.Errorf("server.labels: %v", err)
+	}
+	if err := c.NodeSet.Validate(); err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	ports := make(map[int]string) // 端口 -> 节点ID
+	for _, node := range c.MultiNodes() {
+		if node.ID == "" || node.Port <= 0 || node.Port > 65535 {
+			return fmt.Errorf("节点配置无效: id=%q port=%d", node.ID, node.Port)
+		}
+		if seen[node.ID] {
+			return fmt.Errorf("节点ID重复: %s", node.ID)
+		}
+		if err := registry.ValidateLabels(node.Labels); err != nil {
+			return fmt.Errorf("节点 %s 的 labels: %v", node.ID, err)
+		}
+		seen[node.ID] = true
+		// 多节点模式的节点在同一进程中监听
+		if other, ok := ports[node.Port]; ok {
+			return fmt.Errorf("节点 %s 与 %s 的端口冲突: %d", node.ID, other, node.Port)
+		}
+		ports[node.Port] = node.ID
+	}
+	return nil
+}
+
+// nodeLabels 节点的标签：server.labels 与多节点配置中该节点的 labels 合并
+func (c Config) nodeLabels(nodeID string) map[string]string {
+	labels := make(map[string]string, len(c.Labels))
+	for k, v := range c.Labels {
+		labels[k] = v
+	}
+	for _, node := range c.MultiNodes() {
+		if node.ID == nodeID {
+			for k, v := range node.Labels {
+				labels[k] = v
+			}
+		}
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
+
+// NewFromConfig 按配置创建服务端节点，port和nodeID用于多节点模式下区分各节点
+func NewFromConfig(cfg Config, perfSettings perf.Settings, port int, nodeID string) *Server {
+	server := New(port, nodeID)
+	server.SetListenAddress(cfg.ListenAddress, cfg.AddressFamily)
+	server.SetKeepalive(time.Duration(cfg.PingInterval), time.Duration(cfg.PongTimeout))
+	server.SetWriteTimeout(time.Duration(cfg.WriteTimeout))
+	server.SetRegistryUnavailablePolicy(cfg.RegistryUnavailable)
+	server.SetQuota(cfg.Quota)
+	server.SetHeartbeat(cfg.Heartbeat)
+	server.SetConnectionLifetime(cfg.ConnectionLifetime)
+	server.SetRegistryReconcile(cfg.RegistryReconcile)
+	server.SetClientIDs(cfg.ClientIDs)
+	server.SetBatching(cfg.Batch)
+	server.SetPerformance(perfSettings)
+	server.SetConnMode(cfg.ConnMode, cfg.PollWorkers)
+	server.SetMemoryLimit(cfg.Memory)
+	server.SetSlowConsumer(cfg.SlowConsumer)
+	server.SetMaxClients(cfg.MaxClients)
+	server.SetRateLimit(cfg.RateLimit)
+	server.SetMaxMessageSize(cfg.MaxMessageSize)
+	server.SetCommandConcurrency(cfg.CommandConcurrency)
+	server.SetIdempotency(cfg.Idempotency)
+	server.SetOutbox(cfg.Outbox)
+	server.SetCommandDelivery(cfg.CommandDelivery)
+	server.SetHistory(cfg.History)
+	server.SetCommandHistory(cfg.CommandHistory)
+	server.SetFeatureFlags(cfg.FeatureFlags)
+	server.SetCommandLog(cfg.CommandLog)
+	server.SetProtocolVersions(cfg.ProtocolVersions)
+	resolver, _ := forwarded.New(cfg.Forwarded) // 已由Validate校验
+	server.SetForwarded(resolver)
+	server.SetRegistration(cfg.Registration)
+	server.SetLabels(cfg.nodeLabels(nodeID))
+
+	// 未配置总线对端时，连接多节点配置中的其余节点
+	bus := cfg.NodeBus
+	if bus.Enabled && len(bus.Peers) == 0 {
+		for _, node := range cfg.MultiNodes() {
+			if node.ID != nodeID {
+				bus.Peers = append(bus.Peers, net.JoinHostPort(protocol.LocalHost(cfg.ListenAddress, cfg.AddressFamily), strconv.Itoa(node.Port)))
+			}
+		}
+	}
+	server.SetNodeBus(bus)
+	return server
+}
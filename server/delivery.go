@@ -0,0 +1,434 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"websocket-loadbalance/protocol"
+)
+
+var errDeliveryFailed = errors.New("重发次数用尽，客户端仍未确认收到指令")
+
+// 指令投递的状态
+const (
+	DeliveryPending   = "pending"   // 已发出，等待客户端确认，超时未确认时重发
+	DeliveryAcked     = "acked"     // 客户端已确认收到，等待响应
+	DeliveryResponded = "responded" // 已收到客户端的响应
+	DeliveryRejected  = "rejected"  // 客户端 nack 且不要求重发
+	DeliveryFailed    = "failed"    // 达到最大发送次数仍未确认
+)
+
+// CommandDeliveryConfig 指令的至少一次投递：注册时声明 ack 的客户端收到指令后回复 ack，
+// 超时未确认或被 nack 的指令按退避间隔重发（delivery_id 不变），直到达到最大发送次数
+type CommandDeliveryConfig struct {
+	Enabled          bool              `json:"enabled" yaml:"enabled"`
+	RetryInterval    protocol.Duration `json:"retry_interval" yaml:"retry_interval"`         // 首次发送后等待确认的时长，默认5s，之后每次重发翻倍
+	MaxRetryInterval protocol.Duration `json:"max_retry_interval" yaml:"max_retry_interval"` // 重发间隔的上限，默认1m
+	MaxAttempts      int               `json:"max_attempts" yaml:"max_attempts"`             // 最多发送次数（含首次），默认5
+	MaxRecent        int               `json:"max_recent" yaml:"max_recent"`                 // 保留最近多少条已结束投递的状态供查询，默认1000
+}
+
+// Validate 校验指令投递配置
+func (c CommandDeliveryConfig) Validate() error {
+	if c.RetryInterval < 0 || c.MaxRetryInterval < 0 || c.MaxAttempts < 0 || c.MaxRecent < 0 {
+		return fmt.Errorf("command_delivery 的参数不能为负数")
+	}
+	if c.RetryInterval > 0 && c.MaxRetryInterval > 0 && c.MaxRetryInterval < c.RetryInterval {
+		return fmt.Errorf("command_delivery.max_retry_interval 不能小于 retry_interval")
+	}
+	return nil
+}
+
+// SetCommandDelivery 设置指令的至少一次投递（需在Start之前调用），仅对注册时声明 ack 的客户端生效
+func (s *Server) SetCommandDelivery(cfg CommandDeliveryConfig) {
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = protocol.Duration(5 * time.Second)
+	}
+	if cfg.MaxRetryInterval <= 0 {
+		cfg.MaxRetryInterval = protocol.Duration(time.Minute)
+	}
+	if cfg.MaxRetryInterval < cfg.RetryInterval {
+		cfg.MaxRetryInterval = cfg.RetryInterval
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.MaxRecent <= 0 {
+		cfg.MaxRecent = 1000
+	}
+	s.deliveries.config = cfg
+}
+
+// DeliveryStatus 一条指令的投递状态
+type DeliveryStatus struct {
+	State       string     `json:"state"`    // pending、acked、responded、rejected 或 failed
+	Attempts    int        `json:"attempts"` // 已发送次数，客户端离线时的重发机会也计入
+	MaxAttempts int        `json:"max_attempts"`
+	FirstSentAt time.Time  `json:"first_sent_at"`
+	LastSentAt  time.Time  `json:"last_sent_at"`
+	NextRetryAt *time.Time `json:"next_retry_at,omitempty"` // 等待确认时下次重发的时间
+	AckedAt     *time.Time `json:"acked_at,omitempty"`
+	Nacks       int        `json:"nacks,omitempty"`
+	LastNack    string     `json:"last_nack,omitempty"` // 最近一次 nack 的原因
+}
+
+// failure 投递失败的原因，未失败时为空
+func (d *DeliveryStatus) failure() string {
+	switch d.State {
+	case DeliveryFailed:
+		return errDeliveryFailed.Error()
+	case DeliveryRejected:
+		return "客户端拒绝指令: " + d.LastNack
+	}
+	return ""
+}
+
+// delivery 一条需要确认的指令
+type delivery struct {
+	clientID string
+	command  string
+	data     interface{}
+	status   DeliveryStatus
+	timer    *time.Timer
+}
+
+// deliveryTracker 按 request_id（即 delivery_id）跟踪需要确认的指令。
+// 等待确认的投递在 active 中；结束的投递移到 recent，保留最近的若干条供查询，
+// 已确认但尚未响应的投递被 nack 时重新进入 active
+type deliveryTracker struct {
+	config CommandDeliveryConfig
+	mu     sync.Mutex
+	active map[string]*delivery
+	recent map[string]*delivery
+	order  []string // recent 中的 request_id，按结束顺序
+
+	tracked  atomic.Int64 // 开始跟踪的指令数
+	retries  atomic.Int64 // 重发次数（不含首次发送）
+	acked    atomic.Int64 // 客户端确认（ack 或直接响应）的指令数
+	nacked   atomic.Int64 // 收到的 nack 数
+	rejected atomic.Int64 // 被客户端拒绝、不再重发的指令数
+	failed   atomic.Int64 // 重发次数用尽的指令数
+}
+
+func newDeliveryTracker() *deliveryTracker {
+	return &deliveryTracker{
+		active: make(map[string]*delivery),
+		recent: make(map[string]*delivery),
+	}
+}
+
+// backoff 第attempts次发送后等待确认的时长，每次翻倍，不超过 MaxRetryInterval
+func (t *deliveryTracker) backoff(attempts int) time.Duration {
+	wait := time.Duration(t.config.RetryInterval)
+	for i := 1; i < attempts && wait < time.Duration(t.config.MaxRetryInterval); i++ {
+		wait *= 2
+	}
+	return min(wait, time.Duration(t.config.MaxRetryInterval))
+}
+
+// finishUnsafe 结束投递并移到 recent，超过 MaxRecent 时淘汰最早结束的（调用方持有锁）
+func (t *deliveryTracker) finishUnsafe(requestID string, d *delivery, state string) {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.status.State = state
+	d.status.NextRetryAt = nil
+	delete(t.active, requestID)
+	if _, exists := t.recent[requestID]; !exists {
+		t.order = append(t.order, requestID)
+	}
+	t.recent[requestID] = d
+	for len(t.order) > t.config.MaxRecent {
+		delete(t.recent, t.order[0])
+		t.order = t.order[1:]
+	}
+}
+
+// status 查询投递状态，不需要确认或已淘汰的指令返回nil
+func (t *deliveryTracker) status(requestID string) (*DeliveryStatus, string, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d, ok := t.active[requestID]
+	if !ok {
+		d, ok = t.recent[requestID]
+	}
+	if !ok {
+		return nil, "", ""
+	}
+	status := d.status
+	return &status, d.clientID, d.command
+}
+
+// pendingFor 客户端等待确认的投递，重新注册时立即重发
+func (t *deliveryTracker) pendingFor(clientID string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var requestIDs []string
+	for requestID, d := range t.active {
+		if d.clientID == clientID {
+			requestIDs = append(requestIDs, requestID)
+		}
+	}
+	return requestIDs
+}
+
+// beginDelivery 写入指令前调用：需要确认的指令返回本次是第几次发送（从1开始），否则返回0。
+// 注册时声明 ack 的客户端开始跟踪新指令；已在跟踪中的指令（重发）无论客户端是否声明 ack 都继续跟踪
+func (s *Server) beginDelivery(client *ClientInfo, command string, data interface{}, requestID string) int {
+	t := s.deliveries
+	if !t.config.Enabled || requestID == "" {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d, ok := t.active[requestID]
+	if !ok {
+		if !client.acks {
+			return 0
+		}
+		d = &delivery{clientID: client.ID, command: command, data: data, status: DeliveryStatus{
+			State:       DeliveryPending,
+			MaxAttempts: t.config.MaxAttempts,
+			FirstSentAt: time.Now(),
+		}}
+		t.active[requestID] = d
+		t.tracked.Add(1)
+	}
+	return d.status.Attempts + 1
+}
+
+// endDelivery 写入指令后调用，记录本次发送并安排超时未确认时的重发。
+// 首次发送即写入失败的指令不再跟踪（调用方按发送失败处理），重发时写入失败与客户端离线同样计为一次发送
+func (s *Server) endDelivery(requestID string, attempt int, written bool) {
+	t := s.deliveries
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d, ok := t.active[requestID]
+	if !ok {
+		return
+	}
+	if attempt == 1 && !written {
+		delete(t.active, requestID)
+		return
+	}
+	d.status.Attempts = attempt
+	d.status.LastSentAt = time.Now()
+	if attempt > 1 {
+		t.retries.Add(1)
+	}
+	s.scheduleRetryUnsafe(requestID, d, t.backoff(attempt))
+}
+
+// scheduleRetryUnsafe 安排重发，已达最大发送次数时改为在等待时长后判定失败（调用方持有锁）
+func (s *Server) scheduleRetryUnsafe(requestID string, d *delivery, wait time.Duration) {
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	next := time.Now().Add(wait)
+	d.status.NextRetryAt = &next
+	d.timer = time.AfterFunc(wait, func() { s.retryDelivery(requestID) })
+}
+
+// retryDelivery 超时未确认时重发指令，客户端不在本节点时只计入发送次数，重发次数用尽时判定失败
+func (s *Server) retryDelivery(requestID string) {
+	t := s.deliveries
+	t.mu.Lock()
+	d, ok := t.active[requestID]
+	if !ok || d.status.State != DeliveryPending {
+		t.mu.Unlock()
+		return
+	}
+	if d.status.Attempts >= t.config.MaxAttempts {
+		t.finishUnsafe(requestID, d, DeliveryFailed)
+		t.failed.Add(1)
+		status := d.status
+		t.mu.Unlock()
+		log.Printf("⚠️ 客户端 %s 未确认指令 %s (%s)，已发送 %d 次，放弃重发", d.clientID, d.command, requestID, status.Attempts)
+		s.commandLatency.cancel(requestID, errDeliveryFailed)
+		s.recordDelivery(d.clientID, requestID, &status)
+		return
+	}
+	clientID, command, data := d.clientID, d.command, d.data
+	t.mu.Unlock()
+
+	s.clientsMu.RLock()
+	client, exists := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	if exists {
+		log.Printf("客户端 %s 未确认指令 %s (%s)，重发", clientID, command, requestID)
+		s.writeCommand(client, command, data, requestID)
+	} else {
+		attempt := s.beginDelivery(&ClientInfo{ID: clientID}, command, data, requestID)
+		s.endDelivery(requestID, attempt, false)
+	}
+	s.saveDeliveryStatus(requestID)
+}
+
+// redeliver 客户端在本节点重新注册后，立即重发其等待确认的指令
+func (s *Server) redeliver(client *ClientInfo) {
+	if !s.deliveries.config.Enabled {
+		return
+	}
+	requestIDs := s.deliveries.pendingFor(client.ID)
+	if len(requestIDs) == 0 {
+		return
+	}
+	log.Printf("客户端 %s 重新连接，重发 %d 条未确认的指令", client.ID, len(requestIDs))
+	for _, requestID := range requestIDs {
+		s.deliveries.mu.Lock()
+		d, ok := s.deliveries.active[requestID]
+		var command string
+		var data interface{}
+		if ok {
+			command, data = d.command, d.data
+		}
+		s.deliveries.mu.Unlock()
+		if ok {
+			s.writeCommand(client, command, data, requestID)
+			s.saveDeliveryStatus(requestID)
+		}
+	}
+}
+
+// handleAck 客户端确认收到指令: {"type": "ack", "delivery_id": "..."}
+func (s *Server) handleAck(clientID string, msg map[string]interface{}) {
+	requestID, _ := msg["delivery_id"].(string)
+	if s.ackDelivery(clientID, requestID, DeliveryAcked) {
+		s.saveDeliveryStatus(requestID)
+	}
+}
+
+// ackDelivery 客户端确认或响应指令后停止重发，state 为 acked 或 responded，返回状态是否变化
+func (s *Server) ackDelivery(clientID, requestID, state string) bool {
+	t := s.deliveries
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d, ok := t.active[requestID]
+	if !ok {
+		d, ok = t.recent[requestID]
+		// 已确认的指令收到响应时更新状态
+		if !ok || d.clientID != clientID || d.status.State != DeliveryAcked || state != DeliveryResponded {
+			return false
+		}
+		d.status.State = DeliveryResponded
+		return true
+	}
+	if d.clientID != clientID {
+		return false
+	}
+	now := time.Now()
+	d.status.AckedAt = &now
+	t.acked.Add(1)
+	t.finishUnsafe(requestID, d, state)
+	return true
+}
+
+// handleNack 客户端拒绝指令: {"type": "nack", "delivery_id": "...", "reason": "...", "requeue": true}。
+// requeue 默认为 true，指令按退避间隔重发；为 false 时不再重发。已确认但尚未响应的指令也可以 nack
+func (s *Server) handleNack(clientID string, msg map[string]interface{}) {
+	requestID, _ := msg["delivery_id"].(string)
+	reason, _ := msg["reason"].(string)
+	requeue, ok := msg["requeue"].(bool)
+	if !ok {
+		requeue = true
+	}
+
+	t := s.deliveries
+	t.mu.Lock()
+	d, active := t.active[requestID]
+	if !active {
+		d, active = t.recent[requestID]
+		if !active || d.clientID != clientID || d.status.State != DeliveryAcked {
+			t.mu.Unlock()
+			return
+		}
+		// 重新进入等待确认
+		delete(t.recent, requestID)
+		for i, id := range t.order {
+			if id == requestID {
+				t.order = append(t.order[:i], t.order[i+1:]...)
+				break
+			}
+		}
+		t.active[requestID] = d
+		d.status.State = DeliveryPending
+		d.status.AckedAt = nil
+	} else if d.clientID != clientID {
+		t.mu.Unlock()
+		return
+	}
+	t.nacked.Add(1)
+	d.status.Nacks++
+	d.status.LastNack = reason
+	if requeue {
+		s.scheduleRetryUnsafe(requestID, d, t.backoff(d.status.Attempts))
+		t.mu.Unlock()
+		log.Printf("客户端 %s nack 指令 %s (%s): %s，稍后重发", clientID, d.command, requestID, reason)
+		s.saveDeliveryStatus(requestID)
+		return
+	}
+	t.finishUnsafe(requestID, d, DeliveryRejected)
+	t.rejected.Add(1)
+	failure := d.status.failure()
+	t.mu.Unlock()
+	log.Printf("客户端 %s 拒绝指令 %s (%s): %s", clientID, d.command, requestID, reason)
+	s.commandLatency.cancel(requestID, errors.New(failure))
+	s.saveDeliveryStatus(requestID)
+}
+
+// saveDeliveryStatus 把当前的投递状态写入指令记录
+func (s *Server) saveDeliveryStatus(requestID string) {
+	if status, clientID, _ := s.deliveries.status(requestID); status != nil {
+		s.recordDelivery(clientID, requestID, status)
+	}
+}
+
+// deliveryStats 导出指令投递统计
+func (s *Server) deliveryStats() map[string]interface{} {
+	t := s.deliveries
+	if !t.config.Enabled {
+		return map[string]interface{}{"enabled": false}
+	}
+	t.mu.Lock()
+	pending, acked := 0, 0
+	for _, d := range t.active {
+		if d.status.State == DeliveryPending {
+			pending++
+		}
+	}
+	for _, d := range t.recent {
+		if d.status.State == DeliveryAcked {
+			acked++
+		}
+	}
+	t.mu.Unlock()
+	return map[string]interface{}{
+		"enabled":         true,
+		"max_attempts":    t.config.MaxAttempts,
+		"pending":         pending,
+		"awaiting_result": acked,
+		"tracked":         t.tracked.Load(),
+		"retries":         t.retries.Load(),
+		"acked":           t.acked.Load(),
+		"nacked":          t.nacked.Load(),
+		"rejected":        t.rejected.Load(),
+		"failed":          t.failed.Load(),
+	}
+}
+
+// stop 节点关闭时停止所有重发
+func (t *deliveryTracker) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, d := range t.active {
+		if d.timer != nil {
+			d.timer.Stop()
+			d.timer = nil
+		}
+	}
+}
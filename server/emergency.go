@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/logging"
+	"websocket-loadbalance/protocol"
+)
+
+// emergencyRetryAfter 紧急停止期间拒绝连接时建议客户端等待的秒数
+const emergencyRetryAfter = 30
+
+// emergencyStop 节点的紧急停止状态
+type emergencyStop struct {
+	active        atomic.Bool
+	rejected      atomic.Int64
+	mu            sync.Mutex
+	status        protocol.EmergencyStop
+	confirmations protocol.EmergencyConfirmations
+}
+
+// rejectEmergency 紧急停止期间拒绝新的WebSocket连接
+func (s *Server) rejectEmergency(w http.ResponseWriter, r *http.Request) {
+	s.emergency.rejected.Add(1)
+	logging.Debugf("节点 %s 处于紧急停止状态，拒绝连接 (%s)", s.nodeID, s.clientAddr(r))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(emergencyRetryAfter))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":        "error",
+		"code":        "emergency_stop",
+		"message":     fmt.Sprintf("节点 %s 处于紧急停止状态，暂停接受连接", s.nodeID),
+		"node_id":     s.nodeID,
+		"retry_after": emergencyRetryAfter,
+	})
+}
+
+// EmergencyStop 立即以指定的关闭码关闭本节点的所有客户端连接，并拒绝新连接直到 LiftEmergencyStop。
+// 批量缓冲区中尚未发出的消息直接丢弃，避免继续下发有问题的内容
+func (s *Server) EmergencyStop(closeCode int, reason string) protocol.EmergencyStop {
+	e := s.emergency
+	e.mu.Lock()
+	// 先拒绝新连接，再关闭现有连接，避免客户端在关闭过程中重连进来
+	e.active.Store(true)
+	if !e.status.Active {
+		now := time.Now()
+		e.status = protocol.EmergencyStop{Active: true, Since: &now, LiftedAt: e.status.LiftedAt}
+		e.rejected.Store(0)
+	}
+	e.status.CloseCode = closeCode
+	e.status.Reason = reason
+
+	s.clientsMu.RLock()
+	clients := make([]*ClientInfo, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.clientsMu.RUnlock()
+
+	closeMsg := websocket.FormatCloseMessage(closeCode, reason)
+	for _, client := range clients {
+		client.Connection.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		client.Connection.Close()
+	}
+	e.status.Closed += len(clients)
+	status := e.statusUnsafe()
+	e.mu.Unlock()
+
+	log.Printf("🛑 节点 %s 紧急停止：以关闭码 %d 关闭 %d 个连接，暂停接受新连接 (%s)", s.nodeID, closeCode, len(clients), reason)
+	return status
+}
+
+// LiftEmergencyStop 解除紧急停止，恢复接受连接
+func (s *Server) LiftEmergencyStop() protocol.EmergencyStop {
+	e := s.emergency
+	e.mu.Lock()
+	wasActive := e.status.Active
+	if wasActive {
+		now := time.Now()
+		e.status.Active = false
+		e.status.LiftedAt = &now
+		e.status.Rejected = e.rejected.Load()
+	}
+	e.active.Store(false)
+	status := e.statusUnsafe()
+	e.mu.Unlock()
+
+	if wasActive {
+		log.Printf("✅ 节点 %s 解除紧急停止，恢复接受连接（期间拒绝 %d 个连接）", s.nodeID, status.Rejected)
+	}
+	return status
+}
+
+// EmergencyStatus 紧急停止状态
+func (s *Server) EmergencyStatus() protocol.EmergencyStop {
+	s.emergency.mu.Lock()
+	defer s.emergency.mu.Unlock()
+	return s.emergency.statusUnsafe()
+}
+
+func (e *emergencyStop) statusUnsafe() protocol.EmergencyStop {
+	status := e.status
+	if status.Active {
+		status.Rejected = e.rejected.Load()
+	}
+	return status
+}
+
+// handleEmergencyStop 紧急停止:
+// GET 查询状态；POST 两步执行，先返回确认令牌，带令牌再次提交后生效；DELETE 解除
+func (s *Server) handleEmergencyStop(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"node":           s.nodeID,
+			"emergency_stop": s.EmergencyStatus(),
+		})
+
+	case "POST":
+		var req protocol.EmergencyStopRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "请求格式错误", http.StatusBadRequest)
+			return
+		}
+		if req.ConfirmToken == "" {
+			if err := req.Normalize(); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+				return
+			}
+			token, expiresAt := s.emergency.confirmations.Issue(req)
+			log.Printf("节点 %s 收到紧急停止请求，等待确认 (关闭码 %d: %s)", s.nodeID, req.CloseCode, req.Reason)
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(protocol.EmergencyStopConfirmation{
+				ConfirmationRequired: true,
+				ConfirmToken:         token,
+				ExpiresAt:            expiresAt,
+				CloseCode:            req.CloseCode,
+				Reason:               req.Reason,
+				Connections:          s.GetClientCount(),
+				Message:              fmt.Sprintf("将关闭节点 %s 的 %d 个连接并暂停接受新连接，请在 %v 内带 confirm_token 再次提交确认", s.nodeID, s.GetClientCount(), protocol.EmergencyConfirmTTL),
+			})
+			return
+		}
+		confirmed, ok := s.emergency.confirmations.Redeem(req.ConfirmToken)
+		if !ok {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"code":    "invalid_confirmation",
+				"error":   "确认令牌无效或已过期，请重新发起紧急停止",
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":        true,
+			"node":           s.nodeID,
+			"emergency_stop": s.EmergencyStop(confirmed.CloseCode, confirmed.Reason),
+		})
+
+	case "DELETE":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":        true,
+			"node":           s.nodeID,
+			"emergency_stop": s.LiftEmergencyStop(),
+		})
+
+	default:
+		http.Error(w, "仅支持GET、POST和DELETE请求", http.StatusMethodNotAllowed)
+	}
+}
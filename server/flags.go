@@ -0,0 +1,217 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+)
+
+// 节点内置的功能开关，没有在注册表中设置时使用节点自身配置的默认值
+const (
+	FlagCompression          = "compression"            // 新连接协商 permessage-deflate，默认取 performance 中的配置
+	FlagExecCommands         = "exec_commands"          // 向本节点客户端发送执行类指令（exec/shell/run），默认开启
+	FlagFileTransferCommands = "file_transfer_commands" // 向本节点客户端发送文件传输类指令，默认开启
+	FlagMessageHistory       = "message_history"        // 记录客户端收发的消息（需启用 history），默认开启
+)
+
+// FeatureFlagsConfig 功能开关的应用方式
+type FeatureFlagsConfig struct {
+	WatchInterval protocol.Duration `json:"watch_interval" yaml:"watch_interval"` // 检查功能开关变化的间隔，默认2s
+}
+
+// Validate 检查功能开关配置
+func (c FeatureFlagsConfig) Validate() error {
+	if c.WatchInterval < 0 {
+		return fmt.Errorf("feature_flags.watch_interval 不能为负数")
+	}
+	return nil
+}
+
+// nodeFeatures 本节点当前生效的内置功能开关
+type nodeFeatures struct {
+	mu     sync.RWMutex
+	values map[string]bool
+}
+
+// SetFeatureFlags 设置功能开关的检查间隔（需在Start之前调用）
+func (s *Server) SetFeatureFlags(cfg FeatureFlagsConfig) {
+	if cfg.WatchInterval <= 0 {
+		cfg.WatchInterval = protocol.Duration(2 * time.Second)
+	}
+	s.featureFlags = cfg
+}
+
+// flagDefaults 内置功能开关在本节点的默认值
+func (s *Server) flagDefaults() map[string]bool {
+	return map[string]bool{
+		FlagCompression:          s.upgrader.EnableCompression,
+		FlagExecCommands:         true,
+		FlagFileTransferCommands: true,
+		FlagMessageHistory:       true,
+	}
+}
+
+// applyFlags 按注册表中的功能开关重新计算本节点的取值，记录变化并应用到运行中的组件
+func (s *Server) applyFlags() {
+	flags := registry.Flags()
+	next := s.flagDefaults()
+	for name := range next {
+		if flag, ok := flags[name]; ok {
+			next[name] = flag.EnabledFor(name, s.nodeID)
+		}
+	}
+
+	s.features.mu.Lock()
+	prev := s.features.values
+	s.features.values = next
+	s.features.mu.Unlock()
+
+	if prev != nil {
+		for name, enabled := range next {
+			if prev[name] != enabled {
+				log.Printf("节点 %s 功能开关 %s 变更为 %s", s.nodeID, name, onOff(enabled))
+			}
+		}
+	}
+	if s.history != nil {
+		s.history.paused.Store(!next[FlagMessageHistory])
+	}
+}
+
+// flagWatcher 定期检查功能开关的变化，包括其他进程对功能开关文件的修改
+func (s *Server) flagWatcher() {
+	ticker := time.NewTicker(time.Duration(s.featureFlags.WatchInterval))
+	defer ticker.Stop()
+	for range ticker.C {
+		registry.ReloadFlags()
+		s.applyFlags()
+	}
+}
+
+// FeatureEnabled 返回功能开关在本节点上是否开启。内置开关返回最近一次应用的值，
+// 其他开关直接按注册表判定，没有设置时为false
+func (s *Server) FeatureEnabled(name string) bool {
+	s.features.mu.RLock()
+	enabled, builtin := s.features.values[name]
+	s.features.mu.RUnlock()
+	if builtin {
+		return enabled
+	}
+	// Start之前内置开关尚未应用
+	def := s.flagDefaults()[name]
+	return registry.FlagEnabled(name, s.nodeID, def)
+}
+
+// commandFeatureError 指令所属的类别被功能开关关闭时返回错误
+func (s *Server) commandFeatureError(command string) error {
+	switch {
+	case registry.ExecCommands[command] && !s.FeatureEnabled(FlagExecCommands):
+		return fmt.Errorf("节点 %s 已关闭执行类指令 (%s)", s.nodeID, FlagExecCommands)
+	case registry.FileTransferCommands[command] && !s.FeatureEnabled(FlagFileTransferCommands):
+		return fmt.Errorf("节点 %s 已关闭文件传输类指令 (%s)", s.nodeID, FlagFileTransferCommands)
+	}
+	return nil
+}
+
+// writeFeatureDisabled 以403拒绝被功能开关关闭的指令
+func writeFeatureDisabled(w http.ResponseWriter, nodeID string, err error) {
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"code":    "feature_disabled",
+		"error":   err.Error(),
+		"node":    nodeID,
+	})
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "开启"
+	}
+	return "关闭"
+}
+
+// flagRequest 设置功能开关的请求
+type flagRequest struct {
+	Enabled    bool            `json:"enabled"`
+	Percentage int             `json:"percentage"`
+	Nodes      map[string]bool `json:"nodes"`
+	UpdatedBy  string          `json:"updated_by"`
+}
+
+// handleFlags 功能开关API
+// GET    /api/flags              列出全部功能开关及其在本节点的取值
+// PUT    /api/flags/{name}       {"enabled": true, "percentage": 20, "nodes": {"node2": false}, "updated_by": "ops"}
+// DELETE /api/flags/{name}       删除功能开关，各节点恢复默认值
+func (s *Server) handleFlags(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/flags"), "/")
+
+	switch {
+	case r.Method == "GET" && name == "":
+		s.writeFlags(w)
+	case (r.Method == "PUT" || r.Method == "POST") && name != "":
+		var req flagRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "请求格式错误", http.StatusBadRequest)
+			return
+		}
+		flag, err := registry.SetFlag(name, registry.FeatureFlag{
+			Enabled:    req.Enabled,
+			Percentage: req.Percentage,
+			Nodes:      req.Nodes,
+			UpdatedBy:  req.UpdatedBy,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.applyFlags()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"name":    name,
+			"flag":    flag,
+			"node":    s.nodeID,
+			"enabled": flag.EnabledFor(name, s.nodeID), // 在本节点的取值，其他节点在下一次检查时应用
+		})
+	case r.Method == "DELETE" && name != "":
+		if !registry.DeleteFlag(name) {
+			http.Error(w, "功能开关不存在", http.StatusNotFound)
+			return
+		}
+		s.applyFlags()
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "name": name})
+	default:
+		http.Error(w, "GET /api/flags，PUT或DELETE /api/flags/{name}", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeFlags 输出全部功能开关，effective 为内置开关和已设置的开关在本节点的取值
+func (s *Server) writeFlags(w http.ResponseWriter) {
+	flags := registry.Flags()
+	effective := make(map[string]bool, len(flags))
+	for name := range flags {
+		effective[name] = s.FeatureEnabled(name)
+	}
+	builtin := make([]string, 0, 4)
+	for name := range s.flagDefaults() {
+		effective[name] = s.FeatureEnabled(name)
+		builtin = append(builtin, name)
+	}
+	sort.Strings(builtin)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"node":      s.nodeID,
+		"flags":     flags,
+		"effective": effective,
+		"builtin":   builtin,
+	})
+}
@@ -0,0 +1,456 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+)
+
+// 消息记录的方向
+const (
+	HistoryInbound  = "in"  // 客户端发给节点
+	HistoryOutbound = "out" // 节点发给客户端
+)
+
+// HistoryConfig 按客户端保留最近收发的消息，通过 GET /api/clients/{id}/history 查看，
+// 用于排查设备收到了什么、回复了什么，不需要抓包
+type HistoryConfig struct {
+	Enabled        bool              `json:"enabled" yaml:"enabled"`
+	Size           int               `json:"size" yaml:"size"`                         // 每个客户端保留的最近消息条数，默认100
+	MaxClients     int               `json:"max_clients" yaml:"max_clients"`           // 最多为多少个客户端保留记录，默认10000，超出时淘汰最久没有收发消息的客户端
+	MaxMessageSize int               `json:"max_message_size" yaml:"max_message_size"` // 单条记录保存的最大字节数，默认4096，超出时只保存截断的预览
+	File           string            `json:"file" yaml:"file"`                         // 非空时定期保存到该JSON文件，节点重启后恢复；{node_id} 替换为节点ID
+	SaveInterval   protocol.Duration `json:"save_interval" yaml:"save_interval"`       // 保存间隔，默认30s
+}
+
+// Validate 校验消息记录配置
+func (c HistoryConfig) Validate() error {
+	if c.Size < 0 || c.MaxClients < 0 || c.MaxMessageSize < 0 || c.SaveInterval < 0 {
+		return fmt.Errorf("history 的参数不能为负数")
+	}
+	return nil
+}
+
+// HistoryEntry 一条收发记录
+type HistoryEntry struct {
+	Seq       uint64          `json:"seq"` // 该客户端记录的序号，从1开始递增
+	Direction string          `json:"direction"`
+	Type      string          `json:"type,omitempty"`    // 消息的 type 字段
+	Command   string          `json:"command,omitempty"` // 指令和指令响应的 command 字段
+	Time      time.Time       `json:"time"`
+	Size      int             `json:"size"`              // 消息的字节数
+	Message   json.RawMessage `json:"message,omitempty"` // 完整消息，超过 max_message_size 时为空
+	Truncated bool            `json:"truncated,omitempty"`
+	Preview   string          `json:"preview,omitempty"` // 截断后的消息文本
+}
+
+// clientHistory 单个客户端的环形缓冲区
+type clientHistory struct {
+	store   *messageHistory
+	mu      sync.Mutex
+	entries []HistoryEntry // 长度为 config.Size 的环
+	next    int            // 下一条写入的位置
+	count   int            // 环中有效记录数
+	seq     uint64         // 最后一条记录的序号
+	updated time.Time      // 最后一次记录的时间，用于淘汰
+}
+
+// recordIn 记录客户端发来的原始消息
+func (h *clientHistory) recordIn(data []byte) {
+	if h != nil {
+		h.record(HistoryInbound, data)
+	}
+}
+
+// recordOut 记录发往客户端的消息，v 为 WriteJSON 的参数
+func (h *clientHistory) recordOut(v interface{}) {
+	if h == nil {
+		return
+	}
+	data, ok := v.(json.RawMessage)
+	if !ok {
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return
+		}
+	}
+	h.record(HistoryOutbound, data)
+}
+
+func (h *clientHistory) record(direction string, data []byte) {
+	if h.store.paused.Load() {
+		return
+	}
+	var fields struct {
+		Type    string `json:"type"`
+		Command string `json:"command"`
+	}
+	json.Unmarshal(data, &fields)
+	entry := HistoryEntry{
+		Direction: direction,
+		Type:      fields.Type,
+		Command:   fields.Command,
+		Time:      time.Now(),
+		Size:      len(data),
+	}
+	if limit := h.store.config.MaxMessageSize; len(data) > limit {
+		entry.Truncated = true
+		entry.Preview = strings.ToValidUTF8(string(data[:limit]), "")
+	} else if json.Valid(data) {
+		entry.Message = append(json.RawMessage(nil), data...)
+	} else {
+		entry.Preview = strings.ToValidUTF8(string(data), "")
+	}
+
+	h.mu.Lock()
+	h.seq++
+	entry.Seq = h.seq
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % len(h.entries)
+	if h.count < len(h.entries) {
+		h.count++
+	}
+	h.updated = entry.Time
+	h.mu.Unlock()
+	h.store.recorded.Add(1)
+}
+
+// snapshot 按时间顺序返回序号大于since的记录
+func (h *clientHistory) snapshot(since uint64) []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries := make([]HistoryEntry, 0, h.count)
+	start := (h.next - h.count + len(h.entries)) % len(h.entries)
+	for i := 0; i < h.count; i++ {
+		entry := h.entries[(start+i)%len(h.entries)]
+		if entry.Seq > since {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// messageHistory 节点上所有客户端的消息记录，客户端断开后保留，重连时继续追加
+type messageHistory struct {
+	config   HistoryConfig
+	mu       sync.Mutex
+	clients  map[string]*clientHistory
+	recorded atomic.Int64  // 记录的消息数
+	evicted  atomic.Int64  // 因 max_clients 淘汰的客户端数
+	paused   atomic.Bool   // 功能开关 message_history 关闭时暂停记录
+	done     chan struct{} // 节点关闭时停止定期保存
+	stopOnce sync.Once
+}
+
+// SetHistory 设置按客户端保留的消息记录（需在Start之前调用）。
+// 配置了 file 时立即从文件恢复此前保存的记录
+func (s *Server) SetHistory(cfg HistoryConfig) {
+	if !cfg.Enabled {
+		s.history = nil
+		return
+	}
+	if cfg.Size <= 0 {
+		cfg.Size = 100
+	}
+	if cfg.MaxClients <= 0 {
+		cfg.MaxClients = 10000
+	}
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = 4096
+	}
+	if cfg.SaveInterval <= 0 {
+		cfg.SaveInterval = protocol.Duration(30 * time.Second)
+	}
+	cfg.File = strings.ReplaceAll(cfg.File, "{node_id}", s.nodeID)
+	s.history = &messageHistory{config: cfg, clients: make(map[string]*clientHistory), done: make(chan struct{})}
+	if cfg.File != "" {
+		if restored, err := s.history.load(); err != nil {
+			log.Printf("节点 %s 读取消息记录文件 %s 失败: %v", s.nodeID, cfg.File, err)
+		} else if restored > 0 {
+			log.Printf("节点 %s 从 %s 恢复了 %d 个客户端的消息记录", s.nodeID, cfg.File, restored)
+		}
+	}
+}
+
+// forClient 返回客户端的消息记录，不存在时创建；达到 max_clients 时淘汰最久没有收发消息的客户端。
+// 未启用时返回nil
+func (m *messageHistory) forClient(clientID string) *clientHistory {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if h, ok := m.clients[clientID]; ok {
+		return h
+	}
+	if len(m.clients) >= m.config.MaxClients {
+		m.evictOldestUnsafe()
+	}
+	h := m.newClientHistory()
+	m.clients[clientID] = h
+	return h
+}
+
+func (m *messageHistory) newClientHistory() *clientHistory {
+	return &clientHistory{store: m, entries: make([]HistoryEntry, m.config.Size), updated: time.Now()}
+}
+
+func (m *messageHistory) evictOldestUnsafe() {
+	var oldestID string
+	var oldest time.Time
+	for id, h := range m.clients {
+		h.mu.Lock()
+		updated := h.updated
+		h.mu.Unlock()
+		if oldestID == "" || updated.Before(oldest) {
+			oldestID, oldest = id, updated
+		}
+	}
+	if oldestID != "" {
+		delete(m.clients, oldestID)
+		m.evicted.Add(1)
+	}
+}
+
+// lookup 返回已有的消息记录，不存在时返回nil
+func (m *messageHistory) lookup(clientID string) *clientHistory {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.clients[clientID]
+}
+
+// savedHistory 保存到文件的单个客户端记录
+type savedHistory struct {
+	Seq     uint64         `json:"seq"`
+	Entries []HistoryEntry `json:"entries"`
+}
+
+// load 从文件恢复消息记录，返回恢复的客户端数；文件不存在时不是错误
+func (m *messageHistory) load() (int, error) {
+	data, err := os.ReadFile(m.config.File)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var saved map[string]savedHistory
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for clientID, record := range saved {
+		if len(m.clients) >= m.config.MaxClients {
+			break
+		}
+		h := m.newClientHistory()
+		entries := record.Entries
+		if len(entries) > len(h.entries) {
+			entries = entries[len(entries)-len(h.entries):]
+		}
+		copy(h.entries, entries)
+		h.count = len(entries)
+		h.next = len(entries) % len(h.entries)
+		h.seq = record.Seq
+		if len(entries) > 0 {
+			h.updated = entries[len(entries)-1].Time
+		}
+		m.clients[clientID] = h
+	}
+	return len(m.clients), nil
+}
+
+// save 将全部消息记录写入文件，先写临时文件再改名，避免写到一半时崩溃留下损坏的文件
+func (m *messageHistory) save() error {
+	if m == nil || m.config.File == "" {
+		return nil
+	}
+	m.mu.Lock()
+	saved := make(map[string]savedHistory, len(m.clients))
+	for clientID, h := range m.clients {
+		entries := h.snapshot(0)
+		h.mu.Lock()
+		seq := h.seq
+		h.mu.Unlock()
+		saved[clientID] = savedHistory{Seq: seq, Entries: entries}
+	}
+	m.mu.Unlock()
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.config.File), filepath.Base(m.config.File)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), m.config.File)
+}
+
+// historySaver 按 save_interval 定期保存消息记录，直到节点关闭
+func (s *Server) historySaver() {
+	ticker := time.NewTicker(time.Duration(s.history.config.SaveInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.history.done:
+			return
+		case <-ticker.C:
+			if err := s.history.save(); err != nil {
+				log.Printf("节点 %s 保存消息记录失败: %v", s.nodeID, err)
+			}
+		}
+	}
+}
+
+// saveHistory 节点关闭时保存一次消息记录
+func (s *Server) saveHistory() {
+	if s.history == nil || s.history.config.File == "" {
+		return
+	}
+	s.history.stopOnce.Do(func() { close(s.history.done) })
+	if err := s.history.save(); err != nil {
+		log.Printf("节点 %s 保存消息记录失败: %v", s.nodeID, err)
+	}
+}
+
+// historyStats 导出消息记录统计
+func (s *Server) historyStats() map[string]interface{} {
+	if s.history == nil {
+		return map[string]interface{}{"enabled": false}
+	}
+	s.history.mu.Lock()
+	clients := len(s.history.clients)
+	s.history.mu.Unlock()
+	return map[string]interface{}{
+		"enabled":  true,
+		"size":     s.history.config.Size,
+		"clients":  clients,
+		"recorded": s.history.recorded.Load(),
+		"evicted":  s.history.evicted.Load(),
+	}
+}
+
+// handleClientHistory GET /api/clients/{id}/history: 客户端最近收发的消息，按时间顺序。
+// 查询参数 limit 只返回最后若干条，direction 为 in 或 out 时只返回一个方向，since 只返回序号更大的记录。
+// 客户端在其他节点在线时转发到该节点
+func (s *Server) handleClientHistory(w http.ResponseWriter, r *http.Request, clientID string) {
+	if r.Method != "GET" {
+		http.Error(w, "仅支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	direction := query.Get("direction")
+	if direction != "" && direction != HistoryInbound && direction != HistoryOutbound {
+		http.Error(w, "direction 应为 in 或 out", http.StatusBadRequest)
+		return
+	}
+	limit, since := 0, uint64(0)
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "limit 应为非负整数", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if v := query.Get("since"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "since 应为非负整数", http.StatusBadRequest)
+			return
+		}
+		since = n
+	}
+
+	if client, online := registry.Get(clientID); online && client.NodeID != s.nodeID && r.Header.Get(historyForwardedHeader) == "" {
+		s.forwardHistoryToNode(w, r, client)
+		return
+	}
+	if s.history == nil {
+		http.Error(w, "节点 "+s.nodeID+" 未启用消息记录 (server.history.enabled)", http.StatusNotFound)
+		return
+	}
+	h := s.history.lookup(clientID)
+	if h == nil {
+		http.Error(w, "节点 "+s.nodeID+" 没有客户端 "+clientID+" 的消息记录", http.StatusNotFound)
+		return
+	}
+
+	entries := h.snapshot(since)
+	if direction != "" {
+		filtered := entries[:0]
+		for _, entry := range entries {
+			if entry.Direction == direction {
+				filtered = append(filtered, entry)
+			}
+		}
+		entries = filtered
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	h.mu.Lock()
+	seq := h.seq
+	h.mu.Unlock()
+	_, online := registry.Get(clientID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"client_id": clientID,
+		"node":      s.nodeID,
+		"online":    online,
+		"capacity":  s.history.config.Size,
+		"last_seq":  seq,
+		"total":     len(entries),
+		"entries":   entries,
+	})
+}
+
+// historyForwardedHeader 标记已由其他节点转发的记录查询（消息记录和指令历史），避免注册表短暂不一致时来回转发
+const historyForwardedHeader = "X-History-Forwarded"
+
+// forwardHistoryToNode 将记录查询按原路径转发到客户端当前所在的节点
+func (s *Server) forwardHistoryToNode(w http.ResponseWriter, r *http.Request, client *registry.ClientInfo) {
+	target := s.peerURL(client.NodePort, r.URL.EscapedPath())
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	req, err := http.NewRequestWithContext(r.Context(), "GET", target, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req.Header.Set(historyForwardedHeader, s.nodeID)
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		log.Printf("转发消息记录查询到节点 %s:%d 失败: %v", client.NodeID, client.NodePort, err)
+		http.Error(w, "转发到客户端所在节点失败", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
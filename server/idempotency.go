@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"websocket-loadbalance/protocol"
+)
+
+// idempotencyHeader 携带幂等键的请求头
+const idempotencyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength 幂等键的最大长度
+const maxIdempotencyKeyLength = 255
+
+// IdempotencyConfig 管理API幂等键配置：带 Idempotency-Key 的请求在窗口内重复提交时返回首次的结果
+type IdempotencyConfig struct {
+	Window  protocol.Duration `json:"window" yaml:"window"`     // 保留结果的时间，默认10m
+	MaxKeys int               `json:"max_keys" yaml:"max_keys"` // 最多保留的键数，超出时淘汰最早的，默认10000
+}
+
+// Validate 校验幂等键配置
+func (c IdempotencyConfig) Validate() error {
+	if c.Window < 0 || c.MaxKeys < 0 {
+		return fmt.Errorf("idempotency 的参数不能为负数")
+	}
+	return nil
+}
+
+// SetIdempotency 设置管理API幂等键的保留时间和数量（需在Start之前调用）
+func (s *Server) SetIdempotency(cfg IdempotencyConfig) {
+	if cfg.Window <= 0 {
+		cfg.Window = protocol.Duration(10 * time.Minute)
+	}
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = 10000
+	}
+	s.idempotency.config = cfg
+}
+
+// idempotentResult 一次请求的结果，done关闭前请求仍在处理
+type idempotentResult struct {
+	bodyHash [32]byte
+	created  time.Time
+	done     chan struct{}
+	status   int
+	header   http.Header
+	body     []byte
+}
+
+type idempotencyEntry struct {
+	key    string
+	result *idempotentResult
+}
+
+// idempotencyStore 按 路径+幂等键 保存请求结果
+type idempotencyStore struct {
+	config IdempotencyConfig
+	mu     sync.Mutex
+	keys   map[string]*idempotentResult
+	order  []idempotencyEntry // 按创建时间排列，用于过期和淘汰
+
+	replays   atomic.Int64 // 重复提交而返回首次结果的次数
+	conflicts atomic.Int64 // 同一幂等键携带不同请求体的次数
+}
+
+func newIdempotencyStore() *idempotencyStore {
+	return &idempotencyStore{
+		config: IdempotencyConfig{Window: protocol.Duration(10 * time.Minute), MaxKeys: 10000},
+		keys:   make(map[string]*idempotentResult),
+	}
+}
+
+// begin 查找或登记幂等键，返回已有的结果（nil表示首次提交，调用方负责处理并完成）
+func (st *idempotencyStore) begin(key string, bodyHash [32]byte) (existing, created *idempotentResult) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.expireUnsafe()
+
+	if result, ok := st.keys[key]; ok {
+		return result, nil
+	}
+	result := &idempotentResult{bodyHash: bodyHash, created: time.Now(), done: make(chan struct{})}
+	st.keys[key] = result
+	st.order = append(st.order, idempotencyEntry{key: key, result: result})
+	return nil, result
+}
+
+// expireUnsafe 移除过期的键，超出数量上限时淘汰最早的键（调用方持有mu）
+func (st *idempotencyStore) expireUnsafe() {
+	window := time.Duration(st.config.Window)
+	for len(st.order) > 0 {
+		entry := st.order[0]
+		current := st.keys[entry.key] == entry.result
+		if current && time.Since(entry.result.created) < window && len(st.order) < st.config.MaxKeys {
+			break
+		}
+		st.order = st.order[1:]
+		if current {
+			delete(st.keys, entry.key)
+		}
+	}
+}
+
+// forget 处理失败时移除幂等键，允许客户端用同一个键重试
+func (st *idempotencyStore) forget(key string, result *idempotentResult) {
+	st.mu.Lock()
+	if st.keys[key] == result {
+		delete(st.keys, key)
+	}
+	st.mu.Unlock()
+}
+
+// idempotencyRecorder 将响应写给客户端的同时记录下来
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *idempotencyRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *idempotencyRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+// idempotent 为管理API增加幂等键支持：窗口内重复提交同一个 Idempotency-Key 时不再执行，
+// 直接返回首次的状态码和响应内容；首次请求仍在处理时等待其完成。
+// 服务端错误(5xx)的结果不保留，客户端可以用同一个键重试
+func (s *Server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" || r.Method != "POST" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(w, fmt.Sprintf("%s 超过 %d 字节", idempotencyHeader, maxIdempotencyKeyLength), http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "读取请求失败", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.Sum256(body)
+		storeKey := r.URL.Path + "\x00" + key
+
+		st := s.idempotency
+		existing, result := st.begin(storeKey, hash)
+		if existing != nil {
+			if existing.bodyHash != hash {
+				st.conflicts.Add(1)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"success":         false,
+					"code":            "idempotency_key_reused",
+					"error":           "幂等键已用于内容不同的请求",
+					"idempotency_key": key,
+				})
+				return
+			}
+			select {
+			case <-existing.done:
+			case <-r.Context().Done():
+				return
+			}
+			if existing.status == 0 {
+				// 首次请求处理失败已被移除，按新请求处理
+				s.idempotent(next)(w, r)
+				return
+			}
+			st.replays.Add(1)
+			log.Printf("幂等键 %s 重复提交 %s，返回首次的结果", key, r.URL.Path)
+			for name, values := range existing.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(existing.status)
+			w.Write(existing.body)
+			return
+		}
+
+		recorder := &idempotencyRecorder{ResponseWriter: w}
+		defer func() {
+			if recorder.status == 0 || recorder.status >= 500 {
+				st.forget(storeKey, result)
+			} else {
+				result.status = recorder.status
+				result.header = w.Header().Clone()
+				result.body = recorder.body.Bytes()
+			}
+			close(result.done)
+		}()
+		next(recorder, r)
+	}
+}
+
+// idempotencyStats 导出幂等键统计
+func (s *Server) idempotencyStats() map[string]interface{} {
+	st := s.idempotency
+	st.mu.Lock()
+	keys := len(st.keys)
+	st.mu.Unlock()
+	return map[string]interface{}{
+		"window":    time.Duration(st.config.Window).String(),
+		"keys":      keys,
+		"replays":   st.replays.Load(),
+		"conflicts": st.conflicts.Load(),
+	}
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latencySamples 每个连接保留的最近RTT样本数
+const latencySamples = 100
+
+// latencyTracker 根据ping/pong测量单个连接的往返时延和抖动。
+// ping的负载为发送时间（UnixNano），客户端按协议原样回带在pong中
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples []float64 // 最近的RTT（毫秒），环形缓冲
+	next    int
+	last    float64
+	jitter  float64 // RFC 3550 方式平滑的相邻RTT差值
+	count   int64
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{samples: make([]float64, 0, latencySamples)}
+}
+
+// pingPayload 生成携带发送时间的ping负载
+func pingPayload() []byte {
+	return strconv.AppendInt(nil, time.Now().UnixNano(), 10)
+}
+
+// observePong 根据pong回带的发送时间记录一次RTT，负载无法解析时忽略
+func (t *latencyTracker) observePong(payload string) {
+	sent, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil || sent <= 0 {
+		return
+	}
+	rtt := float64(time.Now().UnixNano()-sent) / float64(time.Millisecond)
+	if rtt < 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.count > 0 {
+		t.jitter += (math.Abs(rtt-t.last) - t.jitter) / 16
+	}
+	t.last = rtt
+	t.count++
+	if len(t.samples) < latencySamples {
+		t.samples = append(t.samples, rtt)
+	} else {
+		t.samples[t.next] = rtt
+		t.next = (t.next + 1) % latencySamples
+	}
+}
+
+// LatencyStats 往返时延统计（毫秒）
+type LatencyStats struct {
+	Samples  int64   `json:"samples"`           // 累计测量次数
+	LastMs   float64 `json:"last_ms,omitempty"` // 节点汇总中不返回
+	MinMs    float64 `json:"min_ms"`
+	AvgMs    float64 `json:"avg_ms"`
+	P50Ms    float64 `json:"p50_ms"`
+	P90Ms    float64 `json:"p90_ms"`
+	P99Ms    float64 `json:"p99_ms"`
+	MaxMs    float64 `json:"max_ms"`
+	JitterMs float64 `json:"jitter_ms"`
+}
+
+// snapshot 导出最近样本的统计，没有样本时返回nil
+func (t *latencyTracker) snapshot() *LatencyStats {
+	t.mu.Lock()
+	samples := append([]float64(nil), t.samples...)
+	stats := &LatencyStats{Samples: t.count, LastMs: round2(t.last), JitterMs: round2(t.jitter)}
+	t.mu.Unlock()
+
+	if len(samples) == 0 {
+		return nil
+	}
+	fillPercentiles(stats, samples)
+	return stats
+}
+
+// fillPercentiles 按最近排名法计算百分位
+func fillPercentiles(stats *LatencyStats, samples []float64) {
+	sort.Float64s(samples)
+	sum := 0.0
+	for _, v := range samples {
+		sum += v
+	}
+	percentile := func(p float64) float64 {
+		idx := int(math.Ceil(p*float64(len(samples)))) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		return round2(samples[idx])
+	}
+	stats.MinMs = round2(samples[0])
+	stats.MaxMs = round2(samples[len(samples)-1])
+	stats.AvgMs = round2(sum / float64(len(samples)))
+	stats.P50Ms = percentile(0.50)
+	stats.P90Ms = percentile(0.90)
+	stats.P99Ms = percentile(0.99)
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// clientLatency 单个客户端的时延统计
+type clientLatency struct {
+	ClientID string        `json:"client_id"`
+	Name     string        `json:"name"`
+	Latency  *LatencyStats `json:"latency"`
+}
+
+// latencyStats 节点的时延统计：合并所有连接的最近样本计算百分位，抖动取各连接的平均值
+func (s *Server) latencyStats() (*LatencyStats, []clientLatency) {
+	// 名称在重命名时于写锁下修改，与时延统计一起在读锁内取出
+	type clientTracker struct {
+		id, name string
+		tracker  *latencyTracker
+	}
+	s.clientsMu.RLock()
+	trackers := make([]clientTracker, 0, len(s.clients))
+	for _, client := range s.clients {
+		trackers = append(trackers, clientTracker{client.ID, client.Name, client.latency})
+	}
+	s.clientsMu.RUnlock()
+
+	var all []float64
+	var jitterSum float64
+	node := &LatencyStats{}
+	clients := make([]clientLatency, 0, len(trackers))
+	for _, t := range trackers {
+		tracker := t.tracker
+		tracker.mu.Lock()
+		all = append(all, tracker.samples...)
+		tracker.mu.Unlock()
+
+		stats := tracker.snapshot()
+		if stats == nil {
+			continue
+		}
+		node.Samples += stats.Samples
+		jitterSum += stats.JitterMs
+		clients = append(clients, clientLatency{ClientID: t.id, Name: t.name, Latency: stats})
+	}
+	if len(all) == 0 {
+		return nil, clients
+	}
+	fillPercentiles(node, all)
+	node.JitterMs = round2(jitterSum / float64(len(clients)))
+	return node, clients
+}
+
+// handleLatency 时延统计API
+// GET /api/latency?worst=10&sort=p99 节点百分位和时延最差的N个客户端
+// GET /api/latency?client_id=xxx 单个客户端的统计
+func (s *Server) handleLatency(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+
+	if clientID := query.Get("client_id"); clientID != "" {
+		s.clientsMu.RLock()
+		client, exists := s.clients[clientID]
+		var name string
+		if exists {
+			name = client.Name // 重命名时在写锁下修改
+		}
+		s.clientsMu.RUnlock()
+		if !exists {
+			http.Error(w, "客户端不在本节点", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"node_id":   s.nodeID,
+			"client_id": client.ID,
+			"name":      name,
+			"latency":   client.latency.snapshot(), // 尚未收到pong时为null
+		})
+		return
+	}
+
+	worst := 10
+	if v, err := strconv.Atoi(query.Get("worst")); err == nil && v >= 0 {
+		worst = v
+	}
+	key := query.Get("sort")
+	if key == "" {
+		key = "p99"
+	}
+	metric := map[string]func(*LatencyStats) float64{
+		"last":   func(l *LatencyStats) float64 { return l.LastMs },
+		"avg":    func(l *LatencyStats) float64 { return l.AvgMs },
+		"p50":    func(l *LatencyStats) float64 { return l.P50Ms },
+		"p99":    func(l *LatencyStats) float64 { return l.P99Ms },
+		"max":    func(l *LatencyStats) float64 { return l.MaxMs },
+		"jitter": func(l *LatencyStats) float64 { return l.JitterMs },
+	}[key]
+	if metric == nil {
+		http.Error(w, "sort 可选: last, avg, p50, p99, max, jitter", http.StatusBadRequest)
+		return
+	}
+
+	node, clients := s.latencyStats()
+	sort.Slice(clients, func(i, j int) bool { return metric(clients[i].Latency) > metric(clients[j].Latency) })
+	measured := len(clients)
+	if len(clients) > worst {
+		clients = clients[:worst]
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"node_id":       s.nodeID,
+		"ping_interval": s.pingInterval.String(),
+		"measured":      measured, // 已有RTT样本的客户端数
+		"latency":       node,
+		"sort":          key,
+		"worst":         clients,
+	})
+}
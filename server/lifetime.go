@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+)
+
+// ConnectionLifetimeConfig 连接最长存活时间
+// 连接达到最长存活时间后，服务端发送 reconnect 消息要求客户端主动断开并重连，
+// 使客户端重新换取连接令牌，并由负载均衡器按当前负载重新选择节点
+type ConnectionLifetimeConfig struct {
+	MaxAge      protocol.Duration `json:"max_age" yaml:"max_age"`           // 连接的最长存活时间，如 24h，0表示不限制
+	Jitter      protocol.Duration `json:"jitter" yaml:"jitter"`             // 各连接的到期时间在 [max_age-jitter, max_age] 内随机分布，避免同时建立的连接同时重连，默认为 max_age 的10%
+	GracePeriod protocol.Duration `json:"grace_period" yaml:"grace_period"` // 发送 reconnect 后等待客户端主动断开的时长，超时以关闭码1001断开，默认30s
+}
+
+// Validate 校验连接最长存活时间配置
+func (c ConnectionLifetimeConfig) Validate() error {
+	if c.MaxAge < 0 || c.Jitter < 0 || c.GracePeriod < 0 {
+		return fmt.Errorf("connection_lifetime 的参数不能为负数")
+	}
+	if c.MaxAge > 0 && c.Jitter >= c.MaxAge {
+		return fmt.Errorf("connection_lifetime.jitter 必须小于 max_age")
+	}
+	return nil
+}
+
+// LifetimeMetrics 连接回收统计
+type LifetimeMetrics struct {
+	requested atomic.Int64 // 发送 reconnect 的连接数
+	forced    atomic.Int64 // 宽限期内未断开、被服务端关闭的连接数
+}
+
+// connLifetime 单个连接的回收时间，notifiedAt 和 closed 只由 lifetimeLoop 读写
+type connLifetime struct {
+	deadline   time.Time // 到期后发送 reconnect
+	notifiedAt time.Time // 发送 reconnect 的时间，零值表示尚未发送
+	closed     bool      // 已被服务端关闭，等待读协程清理
+}
+
+// SetConnectionLifetime 设置连接最长存活时间（需在Start之前调用）
+func (s *Server) SetConnectionLifetime(cfg ConnectionLifetimeConfig) {
+	if cfg.MaxAge > 0 && cfg.Jitter == 0 {
+		cfg.Jitter = cfg.MaxAge / 10
+	}
+	if cfg.GracePeriod <= 0 {
+		cfg.GracePeriod = protocol.Duration(30 * time.Second)
+	}
+	s.lifetime = cfg
+}
+
+// newConnLifetime 为新连接确定回收时间，未设置最长存活时间时返回nil
+func (s *Server) newConnLifetime(connTime time.Time) *connLifetime {
+	if s.lifetime.MaxAge <= 0 {
+		return nil
+	}
+	age := time.Duration(s.lifetime.MaxAge)
+	if jitter := int64(s.lifetime.Jitter); jitter > 0 {
+		age -= time.Duration(rand.Int63n(jitter + 1))
+	}
+	return &connLifetime{deadline: connTime.Add(age)}
+}
+
+// lifetimeLoop 定期检查到期的连接：先要求客户端重连，宽限期后仍未断开的连接由服务端关闭
+func (s *Server) lifetimeLoop() {
+	interval := time.Duration(s.lifetime.MaxAge) / 20
+	if interval > time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if s.draining.Load() {
+			return
+		}
+		s.clientsMu.RLock()
+		clients := make([]*ClientInfo, 0, len(s.clients))
+		for _, client := range s.clients {
+			if client.lifetime != nil && now.After(client.lifetime.deadline) {
+				clients = append(clients, client)
+			}
+		}
+		s.clientsMu.RUnlock()
+
+		for _, client := range clients {
+			s.recycleConnection(client, now)
+		}
+	}
+}
+
+// recycleConnection 处理一个到期的连接
+func (s *Server) recycleConnection(client *ClientInfo, now time.Time) {
+	lifetime := client.lifetime
+	grace := time.Duration(s.lifetime.GracePeriod)
+	if lifetime.notifiedAt.IsZero() {
+		lifetime.notifiedAt = now
+		s.lifetimeMetrics.requested.Add(1)
+		log.Printf("♻️ 客户端 %s (%s) 的连接已存活 %v，要求其重连", client.Name, client.ID, now.Sub(client.ConnTime).Round(time.Second))
+		client.writer.WriteJSON(map[string]interface{}{
+			"type":         protocol.TypeReconnect,
+			"reason":       protocol.ReconnectMaxAge,
+			"grace_period": grace.Seconds(),
+			"timestamp":    now.Unix(),
+		})
+		return
+	}
+	if lifetime.closed || now.Sub(lifetime.notifiedAt) < grace {
+		return
+	}
+	lifetime.closed = true
+	s.lifetimeMetrics.forced.Add(1)
+	log.Printf("🔌 客户端 %s (%s) 在宽限期 %v 内未重连，断开连接", client.Name, client.ID, grace)
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "连接已达到最长存活时间")
+	client.Connection.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+	client.Connection.Close()
+}
+
+// lifetimeStats 连接回收的统计
+func (s *Server) lifetimeStats() map[string]interface{} {
+	return map[string]interface{}{
+		"max_age":      time.Duration(s.lifetime.MaxAge).String(),
+		"jitter":       time.Duration(s.lifetime.Jitter).String(),
+		"grace_period": time.Duration(s.lifetime.GracePeriod).String(),
+		"requested":    s.lifetimeMetrics.requested.Load(),
+		"forced":       s.lifetimeMetrics.forced.Load(),
+	}
+}
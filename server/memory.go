@@ -0,0 +1,172 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+)
+
+// 内存估算使用的经验值，只用于比较连接之间的相对开销，不代表精确的堆占用
+const (
+	goroutineStackEstimate = 8 << 10 // 每个协程的栈
+	connStateEstimate      = 1 << 10 // ClientInfo、写入器、net.Conn 等固定结构
+	quotaStateEstimate     = 256     // 配额跟踪器
+	claimEstimate          = 64      // 每条认证声明
+	pendingMessageEstimate = 256     // 批量缓冲区中未序列化的消息
+	defaultIOBufferSize    = 4096    // gorilla 未设置缓冲区大小时的默认值
+)
+
+// MemoryConfig 连接内存上限配置
+type MemoryConfig struct {
+	Limit         int64             `json:"limit" yaml:"limit"`                   // 所有连接估算内存上限(字节)，0表示不限制
+	CheckInterval protocol.Duration `json:"check_interval" yaml:"check_interval"` // 检查间隔
+}
+
+// ConnMemory 单个连接的估算内存(字节)
+type ConnMemory struct {
+	ClientID string `json:"client_id"`
+	Name     string `json:"name"`
+	Buffers  int64  `json:"buffers"` // 读缓冲区和协程栈
+	Queue    int64  `json:"queue"`   // 批量缓冲区中待发送的消息
+	Queued   int    `json:"queued"`  // 待发送消息条数
+	State    int64  `json:"state"`   // 客户端信息、配额、认证声明
+	Total    int64  `json:"total"`
+}
+
+// SetMemoryLimit 设置连接内存上限（需在Start之前调用），超出时断开估算内存最大的连接
+func (s *Server) SetMemoryLimit(cfg MemoryConfig) {
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = protocol.Duration(5 * time.Second)
+	}
+	s.memory = cfg
+}
+
+// connBufferSize 按连接模式估算每个连接常驻的缓冲区和协程栈
+func (s *Server) connBufferSize() int64 {
+	var sender int64
+	if s.slowConsumer.Enabled {
+		sender = goroutineStackEstimate // 发送队列的发送协程
+	}
+	if s.connMode == ConnModeEpoll {
+		// 事件驱动模式没有常驻读缓冲区和读协程
+		return sender
+	}
+	readBuffer := s.upgrader.ReadBufferSize
+	if readBuffer <= 0 {
+		readBuffer = defaultIOBufferSize
+	}
+	// 写缓冲区空闲时归还到 writeBufferPool，不计入常驻内存；读协程和心跳协程各一个
+	return int64(readBuffer) + 2*goroutineStackEstimate + sender
+}
+
+// connMemory 估算单个连接的内存（调用方持有clientsMu读锁）
+func (s *Server) connMemory(client *ClientInfo, buffers int64) ConnMemory {
+	usage := ConnMemory{
+		ClientID: client.ID,
+		Name:     client.Name,
+		Buffers:  buffers,
+		State:    connStateEstimate + int64(len(client.ID)+len(client.Name)),
+	}
+	usage.Queued, usage.Queue = client.writer.queued()
+	if client.quota != nil {
+		usage.State += quotaStateEstimate
+	}
+	usage.State += int64(len(client.Claims)) * claimEstimate
+	usage.Total = usage.Buffers + usage.Queue + usage.State
+	return usage
+}
+
+// memoryUsage 返回所有连接的估算内存，按总量从大到小排序
+func (s *Server) memoryUsage() []ConnMemory {
+	buffers := s.connBufferSize()
+
+	// 在读锁内估算，名称可能被重命名并发修改
+	s.clientsMu.RLock()
+	usage := make([]ConnMemory, 0, len(s.clients))
+	for _, client := range s.clients {
+		usage = append(usage, s.connMemory(client, buffers))
+	}
+	s.clientsMu.RUnlock()
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].Total > usage[j].Total
+	})
+	return usage
+}
+
+// MemoryStats 导出连接内存统计，top 为返回的最大消耗者数量
+func (s *Server) MemoryStats(top int) map[string]interface{} {
+	usage := s.memoryUsage()
+
+	var buffers, queue, state, total int64
+	for _, u := range usage {
+		buffers += u.Buffers
+		queue += u.Queue
+		state += u.State
+		total += u.Total
+	}
+	if top > len(usage) {
+		top = len(usage)
+	}
+
+	return map[string]interface{}{
+		"connections":   len(usage),
+		"buffers":       buffers,
+		"queue":         queue,
+		"state":         state,
+		"total":         total,
+		"limit":         s.memory.Limit,
+		"shed":          s.memoryShed.Load(),
+		"top_consumers": usage[:top],
+	}
+}
+
+// memoryGuard 定期检查连接内存总量，超出上限时断开消耗最大的连接直到回到上限以下
+func (s *Server) memoryGuard() {
+	ticker := time.NewTicker(time.Duration(s.memory.CheckInterval))
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if s.draining.Load() {
+			return
+		}
+		s.shedMemory()
+	}
+}
+
+// shedMemory 执行一次内存检查
+func (s *Server) shedMemory() {
+	usage := s.memoryUsage()
+	var total int64
+	for _, u := range usage {
+		total += u.Total
+	}
+	if total <= s.memory.Limit {
+		return
+	}
+
+	log.Printf("⚠️ 节点 %s 连接内存估算 %d 字节超出上限 %d，开始断开消耗最大的连接", s.nodeID, total, s.memory.Limit)
+	for _, u := range usage {
+		if total <= s.memory.Limit {
+			break
+		}
+		s.clientsMu.RLock()
+		client := s.clients[u.ClientID]
+		s.clientsMu.RUnlock()
+		if client == nil {
+			continue
+		}
+
+		log.Printf("🚫 断开客户端 %s (%s)，估算内存 %d 字节", u.Name, u.ClientID, u.Total)
+		reason := fmt.Sprintf("内存压力，连接占用约 %d 字节", u.Total)
+		closeMsg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, reason)
+		client.Connection.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		client.Connection.Close()
+		s.memoryShed.Add(1)
+		total -= u.Total
+	}
+}
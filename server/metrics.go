@@ -0,0 +1,76 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"websocket-loadbalance/tracing"
+)
+
+// BroadcastMetrics 广播发送统计
+type BroadcastMetrics struct {
+	broadcasts     atomic.Int64 // 广播次数
+	recipients     atomic.Int64 // 成功发送的接收者总数
+	failures       atomic.Int64 // 发送失败的接收者总数
+	preparedWrites atomic.Int64 // 使用PreparedMessage发送的次数（帧只编码一次）
+	batchedWrites  atomic.Int64 // 进入批量缓冲区发送的次数
+	bytes          atomic.Int64 // 序列化后的消息字节数（每次广播计一次）
+	nanos          atomic.Int64 // 广播总耗时
+}
+
+// record 记录一次广播
+func (m *BroadcastMetrics) record(size int, sent, failed int, elapsed time.Duration) {
+	m.broadcasts.Add(1)
+	m.recipients.Add(int64(sent))
+	m.failures.Add(int64(failed))
+	m.bytes.Add(int64(size))
+	m.nanos.Add(int64(elapsed))
+}
+
+// Snapshot 导出统计数据
+func (m *BroadcastMetrics) Snapshot() map[string]interface{} {
+	broadcasts := m.broadcasts.Load()
+	recipients := m.recipients.Load()
+	nanos := m.nanos.Load()
+
+	var avgBroadcastUs, avgRecipientUs float64
+	if broadcasts > 0 {
+		avgBroadcastUs = float64(nanos) / float64(broadcasts) / 1e3
+	}
+	if recipients > 0 {
+		avgRecipientUs = float64(nanos) / float64(recipients) / 1e3
+	}
+
+	return map[string]interface{}{
+		"broadcasts":           broadcasts,
+		"recipients":           recipients,
+		"failures":             m.failures.Load(),
+		"prepared_writes":      m.preparedWrites.Load(),
+		"batched_writes":       m.batchedWrites.Load(),
+		"bytes":                m.bytes.Load(),
+		"avg_broadcast_us":     avgBroadcastUs,
+		"avg_per_recipient_us": avgRecipientUs,
+	}
+}
+
+// otlpMetrics 通过OTLP推送的节点指标（启用 tracing.metrics 时），与 /api/metrics 中的同名统计一致
+func (s *Server) otlpMetrics() []tracing.Metric {
+	attrs := map[string]string{"node.id": s.nodeID}
+	counter := func(name, description, unit string, value int64) tracing.Metric {
+		return tracing.Metric{Name: name, Description: description, Unit: unit, Kind: tracing.Counter, Value: float64(value), Attributes: attrs}
+	}
+	return []tracing.Metric{
+		{Name: "websocket.server.clients", Description: "当前连接的客户端数", Unit: "1", Kind: tracing.Gauge, Value: float64(s.GetClientCount()), Attributes: attrs},
+		counter("websocket.server.messages.received", "收到的客户端消息数", "1", s.messageMetrics.received.Load()),
+		counter("websocket.server.messages.received_bytes", "收到的客户端消息字节数", "By", s.messageMetrics.receivedBytes.Load()),
+		counter("websocket.server.messages.sent", "发往客户端的消息数", "1", s.messageMetrics.sent.Load()),
+		counter("websocket.server.messages.invalid", "不符合消息模式被拒绝的消息数", "1", s.messageMetrics.invalid.Load()),
+		counter("websocket.server.broadcasts", "广播次数", "1", s.broadcastMetrics.broadcasts.Load()),
+		counter("websocket.server.broadcast.recipients", "广播成功发送的接收者总数", "1", s.broadcastMetrics.recipients.Load()),
+		counter("websocket.server.broadcast.failures", "广播发送失败的接收者总数", "1", s.broadcastMetrics.failures.Load()),
+		counter("websocket.server.connections.rejected", "因满载被拒绝的连接数", "1", s.admissionRejected.Load()),
+		counter("websocket.server.connections.recycled", "达到最长存活时间被要求重连的连接数", "1", s.lifetimeMetrics.requested.Load()),
+		counter("websocket.server.registry.reconcile.added", "注册表对账补回的缺失记录数", "1", s.reconcileMetrics.added.Load()),
+		counter("websocket.server.registry.reconcile.removed", "注册表对账删除的过期记录数", "1", s.reconcileMetrics.removed.Load()),
+	}
+}
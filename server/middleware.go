@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+)
+
+// Use 添加客户端消息中间件（需在Start之前调用），与客户端库的 Client.Use 对称：
+// 出站消息按添加顺序经过中间件，入站消息按相反顺序经过。广播的预编码帧对安装了中间件的连接逐个处理
+func (s *Server) Use(middleware ...protocol.Middleware) {
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// middlewareConn 让写往客户端的文本消息经过中间件，二进制编码在中间件之后由内层连接转换
+type middlewareConn struct {
+	wsConn
+	clientID atomic.Value // string，注册完成后设置
+	out      protocol.FrameHandler
+}
+
+// wrapMiddleware 未添加中间件时原样返回连接
+func (s *Server) wrapMiddleware(conn wsConn) wsConn {
+	if len(s.middleware) == 0 {
+		return conn
+	}
+	mc := &middlewareConn{wsConn: conn}
+	mc.clientID.Store("")
+	mc.out = protocol.Chain(protocol.DirectionOutbound, func(f *protocol.Frame) error {
+		return conn.WriteMessage(websocket.TextMessage, f.Data)
+	}, s.middleware)
+	return mc
+}
+
+// SetWriteDeadline 设置底层连接的写超时，底层连接不支持时忽略
+func (c *middlewareConn) SetWriteDeadline(t time.Time) error {
+	if d, ok := c.wsConn.(writeDeadliner); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return nil
+}
+
+func (c *middlewareConn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(websocket.TextMessage, data)
+}
+
+func (c *middlewareConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != websocket.TextMessage {
+		return c.wsConn.WriteMessage(messageType, data)
+	}
+	return c.out(&protocol.Frame{
+		Direction: protocol.DirectionOutbound,
+		ClientID:  c.clientID.Load().(string),
+		Data:      data,
+	})
+}
+
+// setMiddlewareClient 注册完成后记录连接所属的客户端，供中间件区分
+func setMiddlewareClient(conn wsConn, clientID string) {
+	if mc, ok := conn.(*middlewareConn); ok {
+		mc.clientID.Store(clientID)
+	}
+}
+
+// inbound 让客户端发来的消息经过中间件，返回处理后的消息；中间件丢弃消息或返回错误时ok为false
+func (s *Server) inbound(clientID string, data []byte) (result []byte, ok bool) {
+	if len(s.middleware) == 0 {
+		return data, true
+	}
+	err := protocol.Chain(protocol.DirectionInbound, func(f *protocol.Frame) error {
+		result, ok = f.Data, true
+		return nil
+	}, s.middleware)(&protocol.Frame{Direction: protocol.DirectionInbound, ClientID: clientID, Data: data})
+	if err != nil {
+		log.Printf("客户端 %s 的消息被中间件拒绝: %v", clientID, err)
+		return nil, false
+	}
+	return result, ok
+}
+
+// unwrapConn 返回中间件包装之下的连接
+func unwrapConn(conn wsConn) wsConn {
+	if mc, ok := conn.(*middlewareConn); ok {
+		return mc.wsConn
+	}
+	return conn
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"websocket-loadbalance/registry"
+)
+
+// renameRequest 重命名请求
+type renameRequest struct {
+	Name      string `json:"name"`
+	UpdatedBy string `json:"updated_by"`
+}
+
+// handleClientResource 按路径分发 /api/clients/{id}/name 和 /api/clients/{id}/history
+func (s *Server) handleClientResource(w http.ResponseWriter, r *http.Request) {
+	clientID, resource, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/clients/"), "/")
+	if !ok || clientID == "" {
+		resource = ""
+	}
+	switch resource {
+	case "name":
+		s.handleClientName(w, r, clientID)
+	case "history":
+		s.handleClientHistory(w, r, clientID)
+	case "commands":
+		s.handleClientCommands(w, r, clientID)
+	default:
+		http.Error(w, "路径格式: /api/clients/{id}/name、/api/clients/{id}/history 或 /api/clients/{id}/commands", http.StatusNotFound)
+	}
+}
+
+// handleClientName 客户端名称API
+// GET /api/clients/{id}/name 查看集中分配的名称和历史
+// PUT /api/clients/{id}/name {"name": "收银台-3", "updated_by": "ops"} 重命名并通知客户端
+func (s *Server) handleClientName(w http.ResponseWriter, r *http.Request, clientID string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		record, exists := registry.GetNameRecord(clientID)
+		current := record.Name
+		if client, online := registry.Get(clientID); online && !exists {
+			current = client.Name
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"client_id": clientID,
+			"name":      current,
+			"assigned":  exists, // 是否由运维集中分配
+			"history":   record.History,
+		})
+	case "PUT":
+		var req renameRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "请求格式错误", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			http.Error(w, "name为必填字段", http.StatusBadRequest)
+			return
+		}
+
+		// 客户端在其他节点时由该节点处理，以便同步其本地连接信息并推送给客户端
+		if client, online := registry.Get(clientID); online && client.NodeID != s.nodeID {
+			s.forwardRenameToNode(w, client, clientID, req)
+			return
+		}
+
+		record, previous := registry.Rename(clientID, req.Name, req.UpdatedBy)
+		pushed := s.pushClientName(clientID, req.Name, previous)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"client_id": clientID,
+			"name":      record.Name,
+			"previous":  previous,
+			"pushed":    pushed, // 客户端不在线时为false，名称在其下次注册时生效
+			"node":      s.nodeID,
+			"history":   record.History,
+		})
+	default:
+		http.Error(w, "仅支持GET和PUT请求", http.StatusMethodNotAllowed)
+	}
+}
+
+// pushClientName 更新本节点上客户端的名称，并以 rename 指令通知客户端
+func (s *Server) pushClientName(clientID, name, previous string) bool {
+	s.clientsMu.Lock()
+	client, exists := s.clients[clientID]
+	if exists {
+		client.Name = name
+	}
+	s.clientsMu.Unlock()
+	if !exists {
+		return false
+	}
+	// 声明了能力但不支持 rename 的客户端只更新服务端记录
+	if err := client.Capabilities.CheckCommand("rename", 0); err != nil {
+		return false
+	}
+
+	return s.sendCommandToLocalClient(clientID, "rename", map[string]interface{}{
+		"name":     name,
+		"previous": previous,
+	}, "")
+}
+
+// forwardRenameToNode 将重命名请求转发到客户端所在节点，并透传其响应
+func (s *Server) forwardRenameToNode(w http.ResponseWriter, client *registry.ClientInfo, clientID string, req renameRequest) {
+	body, _ := json.Marshal(req)
+	targetURL := s.peerURL(client.NodePort, "/api/clients/"+clientID+"/name")
+	httpReq, err := http.NewRequest("PUT", targetURL, bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		log.Printf("转发重命名请求到节点 %s:%d 失败: %v", client.NodeID, client.NodePort, err)
+		http.Error(w, "转发到客户端所在节点失败", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
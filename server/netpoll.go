@@ -0,0 +1,499 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/tracing"
+)
+
+// 实验性的epoll连接模式：握手后连接交给事件循环，只有可读时才由工作协程读取一帧，
+// 空闲连接不再各自占用读协程和心跳协程，适合海量低频长连接。
+// 该模式不协商压缩扩展，单条消息上限为 max_message_size，未设置时为 maxPollMessageSize。
+
+// 连接处理模式
+const (
+	ConnModeGorilla = "gorilla" // 每个连接一个读协程（默认）
+	ConnModeEpoll   = "epoll"   // 实验性的事件驱动模式，仅Linux
+)
+
+const (
+	maxPollMessageSize = 1 << 20
+	pollFrameTimeout   = 10 * time.Second // 可读后读完一帧的最长时间
+	websocketGUID      = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+var errPollConnClosed = errors.New("连接已关闭")
+
+var errPollMessageTooBig = errors.New("消息超过大小上限")
+
+// pollConn epoll模式下的服务端WebSocket连接，自行完成帧编解码
+type pollConn struct {
+	conn    net.Conn
+	fd      int
+	pending *bytes.Reader // 握手时已被读入缓冲区的数据
+
+	// 读状态只由持有该连接的工作协程访问（EPOLLONESHOT保证同一时刻只有一个）
+	fragments []byte
+	readLimit uint64 // 单条消息的最大字节数
+
+	client     *ClientInfo
+	lastActive atomic.Int64 // 最近一次收到数据的时间（UnixNano）
+	writeMu    sync.Mutex
+	closeOnce  sync.Once
+	onClose    func()
+}
+
+func (c *pollConn) Read(p []byte) (int, error) {
+	if c.pending != nil && c.pending.Len() > 0 {
+		return c.pending.Read(p)
+	}
+	return c.conn.Read(p)
+}
+
+// hasPending 握手缓冲区中是否还有未处理的数据（这部分数据不会触发epoll事件）
+func (c *pollConn) hasPending() bool {
+	return c.pending != nil && c.pending.Len() > 0
+}
+
+func (c *pollConn) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+// WriteMessage 发送一帧未分片、不加掩码的数据帧
+func (c *pollConn) WriteMessage(messageType int, data []byte) error {
+	return c.writeFrame(messageType, data, time.Now().Add(pollFrameTimeout))
+}
+
+func (c *pollConn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(websocket.TextMessage, data)
+}
+
+func (c *pollConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	return c.writeFrame(messageType, data, deadline)
+}
+
+func (c *pollConn) writeFrame(opcode int, payload []byte, deadline time.Time) error {
+	header := make([]byte, 0, 10)
+	header = append(header, 0x80|byte(opcode))
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(deadline)
+	buffers := net.Buffers{header, payload}
+	_, err := buffers.WriteTo(c.conn)
+	return err
+}
+
+// Close 关闭连接并从事件循环和客户端列表中移除，可重复调用
+func (c *pollConn) Close() error {
+	c.closeOnce.Do(func() {
+		// 先移出事件循环再关闭，避免fd被新连接复用后误删
+		if c.onClose != nil {
+			c.onClose()
+		}
+		c.conn.Close()
+	})
+	return nil
+}
+
+// readFrame 读取一帧；完整的数据消息通过返回值交出，控制帧在内部处理
+func (c *pollConn) readFrame() ([]byte, error) {
+	c.conn.SetReadDeadline(time.Now().Add(pollFrameTimeout))
+
+	var head [2]byte
+	if _, err := io.ReadFull(c, head[:]); err != nil {
+		return nil, err
+	}
+	fin := head[0]&0x80 != 0
+	if head[0]&0x70 != 0 {
+		return nil, errors.New("不支持的扩展位")
+	}
+	opcode := int(head[0] & 0x0F)
+	if head[1]&0x80 == 0 {
+		return nil, errors.New("客户端帧未加掩码")
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c, ext[:]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c, ext[:]); err != nil {
+			return nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > c.readLimit || uint64(len(c.fragments))+length > c.readLimit {
+		c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseMessageTooBig, ""), time.Now().Add(time.Second))
+		return nil, errPollMessageTooBig
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c, mask[:]); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c, payload); err != nil {
+		return nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	c.touch()
+
+	switch opcode {
+	case websocket.PingMessage:
+		return nil, c.WriteControl(websocket.PongMessage, payload, time.Now().Add(time.Second))
+	case websocket.PongMessage:
+		if c.client != nil {
+			c.client.latency.observePong(string(payload))
+		}
+		return nil, nil
+	case websocket.CloseMessage:
+		c.WriteControl(websocket.CloseMessage, payload, time.Now().Add(time.Second))
+		return nil, io.EOF
+	case websocket.TextMessage, websocket.BinaryMessage:
+		if c.fragments != nil {
+			return nil, errors.New("分片消息未结束")
+		}
+		if fin {
+			return payload, nil
+		}
+		c.fragments = payload
+		return nil, nil
+	case 0: // 分片续帧
+		if c.fragments == nil {
+			return nil, errors.New("意外的续帧")
+		}
+		c.fragments = append(c.fragments, payload...)
+		if !fin {
+			return nil, nil
+		}
+		message := c.fragments
+		c.fragments = nil
+		return message, nil
+	default:
+		return nil, fmt.Errorf("未知的帧类型: %d", opcode)
+	}
+}
+
+// readMessage 阻塞读取一条完整的数据消息，仅用于握手后的注册消息
+func (c *pollConn) readMessage() ([]byte, error) {
+	for {
+		message, err := c.readFrame()
+		if err != nil || message != nil {
+			return message, err
+		}
+	}
+}
+
+// upgradePollConn 完成WebSocket握手并接管底层连接，header 中的字段会附加到101响应
+func upgradePollConn(w http.ResponseWriter, r *http.Request, header http.Header) (*pollConn, error) {
+	if r.Method != http.MethodGet ||
+		!strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		!strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") {
+		http.Error(w, "需要WebSocket握手", http.StatusBadRequest)
+		return nil, errors.New("不是WebSocket握手请求")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "不支持的WebSocket版本", http.StatusBadRequest)
+		return nil, fmt.Errorf("不支持的WebSocket版本: %s", r.Header.Get("Sec-WebSocket-Version"))
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "缺少Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("缺少Sec-WebSocket-Key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "不支持连接接管", http.StatusInternalServerError)
+		return nil, errors.New("ResponseWriter不支持Hijack")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := connFD(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n"
+	for name, values := range header {
+		for _, value := range values {
+			response += name + ": " + value + "\r\n"
+		}
+	}
+	response += "\r\n"
+	conn.SetWriteDeadline(time.Now().Add(pollFrameTimeout))
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	pc := &pollConn{conn: conn, fd: fd, readLimit: maxPollMessageSize}
+	if buffered := rw.Reader.Buffered(); buffered > 0 {
+		data, _ := rw.Reader.Peek(buffered)
+		pc.pending = bytes.NewReader(append([]byte(nil), data...))
+	}
+	pc.touch()
+	return pc, nil
+}
+
+// handleWebSocketEpoll epoll模式的WebSocket入口：同步读取注册消息后，
+// 连接交给事件循环，当前协程随即返回
+func (s *Server) handleWebSocketEpoll(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		http.Error(w, "服务器正在关闭", http.StatusServiceUnavailable)
+		return
+	}
+	if isHealthProbe(r) {
+		// 探测连接短暂存在，沿用gorilla处理即可
+		s.serveHealthProbe(w, r)
+		return
+	}
+	claims, header, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	version, header, ok := s.negotiateVersion(w, r, header)
+	if !ok {
+		return
+	}
+	if !s.admit(w, r) {
+		return
+	}
+	// 连接交给事件循环之前出错或panic时在这里释放名额，之后由连接关闭时释放
+	handedOff := false
+	defer func() {
+		if !handedOff {
+			s.release()
+		}
+	}()
+
+	span := tracing.Start("websocket.upgrade", tracing.KindServer, tracing.Extract(r.Header))
+	defer span.End()
+	span.SetAttribute("node.id", s.nodeID)
+	span.SetAttribute("net.peer.addr", r.RemoteAddr)
+	span.SetAttribute("client.address", s.clientAddr(r))
+
+	pc, err := upgradePollConn(w, r, header)
+	if err != nil {
+		span.SetError(err)
+		log.Printf("WebSocket升级失败: %v", err)
+		return
+	}
+	defer func() {
+		if !handedOff {
+			pc.Close()
+		}
+	}()
+
+	if s.maxMessageSize > 0 && s.maxMessageSize < maxPollMessageSize {
+		pc.readLimit = uint64(s.maxMessageSize)
+	}
+	data, err := pc.readMessage()
+	if err != nil {
+		span.SetError(err)
+		if err == errPollMessageTooBig {
+			s.recordTooLarge(s.clientAddr(r))
+		} else {
+			log.Printf("读取注册消息失败: %v", err)
+		}
+		return
+	}
+	data, ok = s.inbound("", data)
+	if !ok {
+		span.SetError(errors.New("注册消息被中间件丢弃"))
+		return
+	}
+	var regMsg map[string]interface{}
+	if err := json.Unmarshal(data, &regMsg); err != nil {
+		span.SetError(err)
+		log.Printf("读取注册消息失败: %v", err)
+		return
+	}
+
+	writer := s.wrapMiddleware(pc)
+	clientInfo, err := s.registerClient(writer, regMsg, claims, version, s.clientAddr(r))
+	if err != nil {
+		span.SetError(err)
+		return
+	}
+	setMiddlewareClient(writer, clientInfo.ID)
+	span.SetAttribute("client.id", clientInfo.ID)
+	pc.client = clientInfo
+	pc.onClose = func() {
+		s.poller.remove(pc)
+		s.unregisterClient(clientInfo)
+		s.release()
+	}
+	handedOff = true
+	pc.conn.SetReadDeadline(time.Time{})
+
+	if err := s.poller.add(pc); err != nil {
+		log.Printf("连接加入事件循环失败: %v", err)
+		pc.Close()
+	}
+}
+
+// servePollConn 处理一次可读事件，返回false表示连接已关闭。
+// 处理消息时发生panic只关闭这个连接，工作协程继续服务其他连接
+func (s *Server) servePollConn(pc *pollConn) (open bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("处理客户端 %s 的消息时发生panic，关闭连接: %v\n%s", pc.client.ID, r, debug.Stack())
+			pc.Close()
+			open = false
+		}
+	}()
+	for {
+		message, err := pc.readFrame()
+		if err != nil {
+			if err == errPollMessageTooBig {
+				s.recordTooLarge(pc.client.ID)
+			} else if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Printf("客户端 %s 读取错误: %v", pc.client.ID, err)
+			}
+			pc.Close()
+			return false
+		}
+		if message == nil {
+			// 控制帧（pong）只说明连接还在
+			s.touch(pc.client, true)
+		} else if message, ok := s.inbound(pc.client.ID, message); ok {
+			if err := s.handleClientMessage(pc.client, message); err != nil {
+				pc.Close()
+				return false
+			}
+		}
+		// 握手缓冲区中剩余的数据不会触发epoll事件，需要在这里读完
+		if !pc.hasPending() {
+			return true
+		}
+	}
+}
+
+// pollKeepalive epoll模式的集中心跳：一个协程负责所有连接的ping和超时检测
+func (s *Server) pollKeepalive(done <-chan struct{}) {
+	ticker := time.NewTicker(s.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		s.clientsMu.RLock()
+		conns := make([]*pollConn, 0, len(s.clients))
+		for _, client := range s.clients {
+			if pc, ok := unwrapConn(client.Connection).(*pollConn); ok {
+				conns = append(conns, pc)
+			}
+		}
+		s.clientsMu.RUnlock()
+
+		deadline := time.Now().Add(-(s.pingInterval + s.pongTimeout)).UnixNano()
+		for _, pc := range conns {
+			if pc.lastActive.Load() < deadline {
+				log.Printf("客户端 %s 心跳超时，关闭连接", pc.client.ID)
+				pc.Close()
+				continue
+			}
+			if err := pc.WriteControl(websocket.PingMessage, pingPayload(), time.Now().Add(s.pongTimeout)); err != nil {
+				log.Printf("向客户端 %s 发送ping失败: %v", pc.client.ID, err)
+				pc.Close()
+			}
+		}
+	}
+}
+
+// startPoller 启动epoll事件循环、工作协程和集中心跳
+func (s *Server) startPoller() error {
+	p, err := newPoller()
+	if err != nil {
+		return err
+	}
+	s.poller = p
+	s.pollDone = make(chan struct{})
+
+	workers := s.pollWorkers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0) * 4
+	}
+	for i := 0; i < workers; i++ {
+		go s.pollWorker(p.ready)
+	}
+	go p.run()
+	go s.pollKeepalive(s.pollDone)
+
+	log.Printf("节点 %s 使用实验性epoll连接模式 (工作协程 %d)", s.nodeID, workers)
+	return nil
+}
+
+// stopPoller 停止事件循环，在所有连接关闭后调用
+func (s *Server) stopPoller() {
+	if s.poller == nil {
+		return
+	}
+	close(s.pollDone)
+	s.poller.close()
+}
+
+// poller 的工作协程：处理就绪连接，处理完后重新注册可读事件
+func (s *Server) pollWorker(ready <-chan *pollConn) {
+	for pc := range ready {
+		if s.servePollConn(pc) {
+			if err := s.poller.rearm(pc); err != nil && !errors.Is(err, errPollConnClosed) {
+				log.Printf("重新注册可读事件失败: %v", err)
+				pc.Close()
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+//go:build linux
+
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// poller 基于epoll的可读事件循环；连接以EPOLLONESHOT注册，
+// 同一连接同一时刻只会被一个工作协程处理，处理完后重新注册
+type poller struct {
+	epfd  int
+	conns map[int]*pollConn
+	mu    sync.Mutex
+	ready chan *pollConn
+}
+
+func newPoller() (*poller, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	return &poller{
+		epfd:  epfd,
+		conns: make(map[int]*pollConn),
+		ready: make(chan *pollConn, 1024),
+	}, nil
+}
+
+const pollEvents = syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT
+
+func (p *poller) add(pc *pollConn) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	event := syscall.EpollEvent{Events: pollEvents, Fd: int32(pc.fd)}
+	if err := syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, pc.fd, &event); err != nil {
+		return err
+	}
+	p.conns[pc.fd] = pc
+	return nil
+}
+
+func (p *poller) rearm(pc *pollConn) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conns[pc.fd] != pc {
+		return errPollConnClosed
+	}
+	event := syscall.EpollEvent{Events: pollEvents, Fd: int32(pc.fd)}
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_MOD, pc.fd, &event)
+}
+
+func (p *poller) remove(pc *pollConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conns[pc.fd] != pc {
+		return
+	}
+	delete(p.conns, pc.fd)
+	syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_DEL, pc.fd, nil)
+}
+
+// run 等待可读事件并分发给工作协程，close后返回
+func (p *poller) run() {
+	events := make([]syscall.EpollEvent, 256)
+	for {
+		n, err := syscall.EpollWait(p.epfd, events, -1)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			close(p.ready)
+			return
+		}
+
+		p.mu.Lock()
+		batch := make([]*pollConn, 0, n)
+		for i := 0; i < n; i++ {
+			if pc, ok := p.conns[int(events[i].Fd)]; ok {
+				batch = append(batch, pc)
+			}
+		}
+		p.mu.Unlock()
+
+		for _, pc := range batch {
+			p.ready <- pc
+		}
+	}
+}
+
+func (p *poller) close() error {
+	return syscall.Close(p.epfd)
+}
+
+// connFD 获取TCP连接的文件描述符
+func connFD(conn net.Conn) (int, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0, errors.New("epoll模式仅支持TCP连接")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var fd int
+	if err := raw.Control(func(f uintptr) { fd = int(f) }); err != nil {
+		return 0, err
+	}
+	return fd, nil
+}
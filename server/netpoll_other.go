@@ -0,0 +1,29 @@
+//go:build !linux
+
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+var errPollUnsupported = errors.New("epoll连接模式仅支持Linux")
+
+// poller 非Linux平台的占位实现
+type poller struct {
+	ready chan *pollConn
+}
+
+func newPoller() (*poller, error) {
+	return nil, errPollUnsupported
+}
+
+func (p *poller) add(pc *pollConn) error   { return errPollUnsupported }
+func (p *poller) rearm(pc *pollConn) error { return errPollUnsupported }
+func (p *poller) remove(pc *pollConn)      {}
+func (p *poller) run()                     {}
+func (p *poller) close() error             { return nil }
+
+func connFD(conn net.Conn) (int, error) {
+	return 0, errPollUnsupported
+}
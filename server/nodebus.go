@@ -0,0 +1,619 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+)
+
+// errNoBusLink 与目标节点之间没有总线连接，调用方回退到HTTP转发
+var errNoBusLink = errors.New("与目标节点之间没有总线连接")
+
+// NodeBusConfig 节点总线配置：节点之间保持WebSocket长连接，指令转发、发布订阅、
+// 跨节点广播和在线状态同步都通过总线传递，总线不可用时回退到HTTP
+type NodeBusConfig struct {
+	Enabled           bool              `json:"enabled" yaml:"enabled"`
+	Peers             []string          `json:"peers" yaml:"peers"`                           // 其他节点的地址(host:port)，多节点模式下默认为其余节点，另外也会连接注册表中出现的节点
+	ReconnectInterval protocol.Duration `json:"reconnect_interval" yaml:"reconnect_interval"` // 断线重连的间隔，默认2s
+	PresenceInterval  protocol.Duration `json:"presence_interval" yaml:"presence_interval"`   // 同步在线客户端列表的间隔，也用作心跳，默认10s
+	RequestTimeout    protocol.Duration `json:"request_timeout" yaml:"request_timeout"`       // 等待对端回复的超时，默认10s
+}
+
+// Validate 校验节点总线配置
+func (c NodeBusConfig) Validate() error {
+	if c.ReconnectInterval < 0 || c.PresenceInterval < 0 || c.RequestTimeout < 0 {
+		return fmt.Errorf("node_bus 的时间参数不能为负数")
+	}
+	for _, peer := range c.Peers {
+		if peer == "" {
+			return fmt.Errorf("node_bus.peers 不能包含空地址")
+		}
+	}
+	return nil
+}
+
+// SetNodeBus 启用节点总线（需在Start之前调用）
+func (s *Server) SetNodeBus(cfg NodeBusConfig) {
+	if !cfg.Enabled {
+		s.bus = nil
+		return
+	}
+	if cfg.ReconnectInterval <= 0 {
+		cfg.ReconnectInterval = protocol.Duration(2 * time.Second)
+	}
+	if cfg.PresenceInterval <= 0 {
+		cfg.PresenceInterval = protocol.Duration(10 * time.Second)
+	}
+	if cfg.RequestTimeout <= 0 {
+		cfg.RequestTimeout = protocol.Duration(10 * time.Second)
+	}
+	s.bus = &nodeBus{
+		s:       s,
+		cfg:     cfg,
+		links:   make(map[string]*busLink),
+		addrs:   make(map[string]string),
+		failed:  make(map[string]bool),
+		seen:    make(map[string]bool),
+		pending: make(map[uint64]chan busFrame),
+		done:    make(chan struct{}),
+	}
+}
+
+// 总线帧类型
+const (
+	busHello     = "hello"
+	busCommand   = "command"   // 转发指令，对端回复reply
+	busReply     = "reply"     // 对command的回复
+	busPublish   = "publish"   // 转发发布订阅消息
+	busBroadcast = "broadcast" // 跨节点广播指令
+	busPresence  = "presence"  // 在线客户端同步
+)
+
+// busFrame 节点之间传递的消息
+type busFrame struct {
+	Type    string          `json:"type"`
+	From    string          `json:"from"`               // 发送节点ID
+	ID      uint64          `json:"id,omitempty"`       // 需要回复的请求ID
+	ReplyTo uint64          `json:"reply_to,omitempty"` // 回复对应的请求ID
+	Status  int             `json:"status,omitempty"`   // 回复的HTTP状态码
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// presenceUpdate 在线状态通告：Full为true时Clients是节点的完整客户端列表
+type presenceUpdate struct {
+	Full    bool                  `json:"full,omitempty"`
+	Online  bool                  `json:"online,omitempty"`
+	Clients []registry.ClientInfo `json:"clients,omitempty"`
+	Offline []string              `json:"offline,omitempty"`
+}
+
+// busBroadcastRequest 跨节点广播的指令
+type busBroadcastRequest struct {
+	Command   string      `json:"command"`
+	Data      interface{} `json:"data"`
+	Namespace string      `json:"namespace,omitempty"` // 只广播给该命名空间的客户端
+}
+
+// busLink 与一个节点之间的总线连接
+type busLink struct {
+	conn      *websocket.Conn
+	nodeID    string
+	addr      string
+	outgoing  bool // 由本节点发起
+	since     time.Time
+	writeMu   sync.Mutex
+	sent      atomic.Int64
+	received  atomic.Int64
+	closeOnce sync.Once
+}
+
+func (l *busLink) write(frame busFrame) error {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	l.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if err := l.conn.WriteJSON(frame); err != nil {
+		return err
+	}
+	l.sent.Add(1)
+	return nil
+}
+
+func (l *busLink) close() {
+	l.closeOnce.Do(func() { l.conn.Close() })
+}
+
+// nodeBus 本节点到其他节点的总线连接
+type nodeBus struct {
+	s   *Server
+	cfg NodeBusConfig
+
+	mu      sync.Mutex
+	links   map[string]*busLink // 节点ID -> 连接
+	addrs   map[string]string   // 已知地址 -> 节点ID，避免重复拨号
+	failed  map[string]bool     // 拨号失败且已记录日志的地址
+	seen    map[string]bool     // 曾经连接过的节点，用于统计重连
+	pending map[uint64]chan busFrame
+	seq     atomic.Uint64
+
+	done     chan struct{}
+	stopOnce sync.Once
+
+	reconnects atomic.Int64 // 与曾经连接过的节点重新建立连接的次数
+	fallbacks  atomic.Int64 // 没有总线连接而回退到HTTP的次数
+}
+
+// start 启动拨号和在线状态同步
+func (b *nodeBus) start() {
+	go b.dialLoop()
+	go b.presenceLoop()
+}
+
+// stop 关闭所有总线连接
+func (b *nodeBus) stop() {
+	b.stopOnce.Do(func() {
+		close(b.done)
+		b.mu.Lock()
+		links := make([]*busLink, 0, len(b.links))
+		for _, link := range b.links {
+			links = append(links, link)
+		}
+		b.mu.Unlock()
+		for _, link := range links {
+			link.close()
+		}
+	})
+}
+
+// peerAddrs 需要连接的节点地址：配置的peers和注册表中其他节点
+func (b *nodeBus) peerAddrs() []string {
+	seen := make(map[string]bool)
+	var addrs []string
+	for _, addr := range b.cfg.Peers {
+		if !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	for _, port := range b.s.peerNodes() {
+		addr := b.s.peerAddr(port)
+		if !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// dialLoop 定期连接尚未建立总线连接的节点
+func (b *nodeBus) dialLoop() {
+	interval := time.Duration(b.cfg.ReconnectInterval)
+	for {
+		for _, addr := range b.peerAddrs() {
+			b.mu.Lock()
+			nodeID, known := b.addrs[addr]
+			_, connected := b.links[nodeID]
+			b.mu.Unlock()
+			if known && (connected || nodeID == b.s.nodeID) {
+				continue
+			}
+			if err := b.dial(addr); err != nil {
+				// 对端未启动或已下线时会持续重试，每个地址只记录一次失败
+				b.mu.Lock()
+				first := !b.failed[addr]
+				b.failed[addr] = true
+				b.mu.Unlock()
+				if first {
+					log.Printf("节点 %s 连接总线对端 %s 失败: %v（每 %v 重试）", b.s.nodeID, addr, err, interval)
+				}
+			}
+		}
+
+		select {
+		case <-b.done:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// dial 连接对端节点的总线入口并交换hello
+func (b *nodeBus) dial(addr string) error {
+	dialer := websocket.Dialer{HandshakeTimeout: 5 * time.Second}
+	conn, _, err := dialer.Dial("ws://"+addr+"/api/bus", nil)
+	if err != nil {
+		return err
+	}
+	link := &busLink{conn: conn, addr: addr, outgoing: true, since: time.Now()}
+	if err := link.write(busFrame{Type: busHello, From: b.s.nodeID}); err != nil {
+		conn.Close()
+		return err
+	}
+	hello, err := readHello(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	link.nodeID = hello.From
+
+	b.mu.Lock()
+	b.addrs[addr] = hello.From
+	delete(b.failed, addr)
+	b.mu.Unlock()
+	if hello.From == b.s.nodeID {
+		conn.Close()
+		return nil
+	}
+	go b.serve(link)
+	return nil
+}
+
+func readHello(conn *websocket.Conn) (busFrame, error) {
+	var hello busFrame
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&hello); err != nil {
+		return hello, err
+	}
+	if hello.Type != busHello || hello.From == "" {
+		return hello, fmt.Errorf("无效的总线握手消息")
+	}
+	return hello, nil
+}
+
+// handleBus 其他节点连接总线的入口，普通GET请求返回总线状态
+func (s *Server) handleBus(w http.ResponseWriter, r *http.Request) {
+	if s.bus == nil {
+		http.Error(w, "节点总线未启用", http.StatusNotFound)
+		return
+	}
+	if !websocket.IsWebSocketUpgrade(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.bus.stats())
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("总线连接升级失败: %v", err)
+		return
+	}
+	hello, err := readHello(conn)
+	if err != nil {
+		log.Printf("总线握手失败 (%s): %v", r.RemoteAddr, err)
+		conn.Close()
+		return
+	}
+	link := &busLink{conn: conn, nodeID: hello.From, addr: r.RemoteAddr, since: time.Now()}
+	if err := link.write(busFrame{Type: busHello, From: s.nodeID}); err != nil {
+		conn.Close()
+		return
+	}
+	go s.bus.serve(link)
+}
+
+// serve 登记连接，发送在线客户端快照，然后处理对端消息直到断开
+func (b *nodeBus) serve(link *busLink) {
+	if !b.addLink(link) {
+		link.close()
+		return
+	}
+	defer b.removeLink(link)
+
+	b.sendPresenceSnapshot(link)
+
+	// 对端每个同步周期至少发送一次在线状态，超过三个周期没有消息视为断线
+	idle := 3 * time.Duration(b.cfg.PresenceInterval)
+	for {
+		link.conn.SetReadDeadline(time.Now().Add(idle))
+		var frame busFrame
+		if err := link.conn.ReadJSON(&frame); err != nil {
+			select {
+			case <-b.done:
+			default:
+				log.Printf("节点 %s 与 %s 的总线连接断开: %v", b.s.nodeID, link.nodeID, err)
+			}
+			return
+		}
+		link.received.Add(1)
+		b.handleFrame(link, frame)
+	}
+}
+
+// addLink 登记连接。两个节点同时互相拨号时，保留由节点ID较小的一方发起的连接
+func (b *nodeBus) addLink(link *busLink) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	select {
+	case <-b.done:
+		return false
+	default:
+	}
+
+	existing, replaced := b.links[link.nodeID]
+	if replaced {
+		preferOutgoing := b.s.nodeID < link.nodeID
+		if link.outgoing != preferOutgoing {
+			return false
+		}
+		existing.close()
+	} else if b.seen[link.nodeID] {
+		b.reconnects.Add(1)
+	}
+	b.seen[link.nodeID] = true
+	b.links[link.nodeID] = link
+	log.Printf("节点 %s 与 %s 建立总线连接 (%s)", b.s.nodeID, link.nodeID, link.addr)
+	return true
+}
+
+func (b *nodeBus) removeLink(link *busLink) {
+	link.close()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.links[link.nodeID] == link {
+		delete(b.links, link.nodeID)
+	}
+}
+
+func (b *nodeBus) link(nodeID string) *busLink {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.links[nodeID]
+}
+
+// send 向指定节点发送一条消息，没有连接时返回errNoBusLink
+func (b *nodeBus) send(nodeID, frameType string, payload interface{}) error {
+	link := b.link(nodeID)
+	if link == nil {
+		b.fallbacks.Add(1)
+		return errNoBusLink
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if err := link.write(busFrame{Type: frameType, From: b.s.nodeID, Payload: encoded}); err != nil {
+		link.close()
+		return err
+	}
+	return nil
+}
+
+// sendAll 向所有已连接的节点发送一条消息，返回发送成功的节点
+func (b *nodeBus) sendAll(frameType string, payload interface{}) map[string]bool {
+	reached := make(map[string]bool)
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return reached
+	}
+	b.mu.Lock()
+	links := make([]*busLink, 0, len(b.links))
+	for _, link := range b.links {
+		links = append(links, link)
+	}
+	b.mu.Unlock()
+
+	frame := busFrame{Type: frameType, From: b.s.nodeID, Payload: encoded}
+	for _, link := range links {
+		if err := link.write(frame); err != nil {
+			log.Printf("向节点 %s 发送总线消息失败: %v", link.nodeID, err)
+			link.close()
+			continue
+		}
+		reached[link.nodeID] = true
+	}
+	return reached
+}
+
+// request 向指定节点发送请求并等待回复
+func (b *nodeBus) request(nodeID, frameType string, payload interface{}, timeout time.Duration) (busFrame, error) {
+	link := b.link(nodeID)
+	if link == nil {
+		b.fallbacks.Add(1)
+		return busFrame{}, errNoBusLink
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return busFrame{}, err
+	}
+
+	id := b.seq.Add(1)
+	ch := make(chan busFrame, 1)
+	b.mu.Lock()
+	b.pending[id] = ch
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.pending, id)
+		b.mu.Unlock()
+	}()
+
+	if err := link.write(busFrame{Type: frameType, From: b.s.nodeID, ID: id, Payload: encoded}); err != nil {
+		link.close()
+		return busFrame{}, err
+	}
+	select {
+	case reply := <-ch:
+		return reply, nil
+	case <-time.After(timeout):
+		return busFrame{}, fmt.Errorf("等待节点 %s 回复超时 (%v)", nodeID, timeout)
+	case <-b.done:
+		return busFrame{}, fmt.Errorf("节点总线已关闭")
+	}
+}
+
+// handleFrame 处理对端发来的一条消息
+func (b *nodeBus) handleFrame(link *busLink, frame busFrame) {
+	switch frame.Type {
+	case busReply:
+		b.mu.Lock()
+		ch := b.pending[frame.ReplyTo]
+		b.mu.Unlock()
+		if ch != nil {
+			ch <- frame
+		}
+
+	case busCommand:
+		// 同步指令会等待客户端响应，不能阻塞读循环
+		go func() {
+			status, body := b.s.serveForwardedCommand(frame.Payload)
+			reply := busFrame{Type: busReply, From: b.s.nodeID, ReplyTo: frame.ID, Status: status, Payload: body}
+			if err := link.write(reply); err != nil {
+				log.Printf("回复节点 %s 的转发指令失败: %v", link.nodeID, err)
+			}
+		}()
+
+	case busPublish:
+		var req forwardedPublish
+		if err := json.Unmarshal(frame.Payload, &req); err == nil {
+			b.s.deliverTopicMessage(req.Namespace, req.Topic, req.Data, req.From)
+		}
+
+	case busBroadcast:
+		var req busBroadcastRequest
+		if err := json.Unmarshal(frame.Payload, &req); err == nil {
+			sent, failed, skipped := b.s.broadcastCommand(req.Command, req.Data, req.Namespace)
+			log.Printf("节点 %s 执行来自 %s 的广播指令 %s: 成功 %d, 失败 %d, 跳过 %d",
+				b.s.nodeID, frame.From, req.Command, sent, failed, skipped)
+		}
+
+	case busPresence:
+		var update presenceUpdate
+		if err := json.Unmarshal(frame.Payload, &update); err != nil {
+			return
+		}
+		switch {
+		case update.Full:
+			registry.SyncNode(frame.From, update.Clients)
+		case update.Online:
+			for _, client := range update.Clients {
+				registry.Upsert(client)
+			}
+		}
+		for _, clientID := range update.Offline {
+			registry.UnregisterFromNode(clientID, frame.From)
+		}
+	}
+}
+
+// serveForwardedCommand 按 /api/send-command 处理其他节点转发来的指令，返回HTTP状态码和响应内容
+func (s *Server) serveForwardedCommand(payload []byte) (int, []byte) {
+	r := httptest.NewRequest(http.MethodPost, "/api/send-command", bytes.NewReader(payload))
+	w := httptest.NewRecorder()
+	s.handleSendCommand(w, r)
+	return w.Code, w.Body.Bytes()
+}
+
+// localPresence 本节点的在线客户端列表
+func (s *Server) localPresence() []registry.ClientInfo {
+	records := registry.ByNode(s.nodeID)
+	clients := make([]registry.ClientInfo, 0, len(records))
+	for _, record := range records {
+		client := *record
+		client.Annotation = nil
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+func (b *nodeBus) sendPresenceSnapshot(link *busLink) {
+	encoded, err := json.Marshal(presenceUpdate{Full: true, Clients: b.s.localPresence()})
+	if err != nil {
+		return
+	}
+	if err := link.write(busFrame{Type: busPresence, From: b.s.nodeID, Payload: encoded}); err != nil {
+		link.close()
+	}
+}
+
+// presenceLoop 定期向所有节点同步本节点的在线客户端列表
+func (b *nodeBus) presenceLoop() {
+	ticker := time.NewTicker(time.Duration(b.cfg.PresenceInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			b.sendAll(busPresence, presenceUpdate{Full: true, Clients: b.s.localPresence()})
+		}
+	}
+}
+
+// announceOnline 通告客户端上线
+func (b *nodeBus) announceOnline(clientID string) {
+	if b == nil {
+		return
+	}
+	client, exists := registry.Get(clientID)
+	if !exists {
+		return
+	}
+	record := *client
+	record.Annotation = nil
+	b.sendAll(busPresence, presenceUpdate{Online: true, Clients: []registry.ClientInfo{record}})
+}
+
+// announceOffline 通告客户端下线
+func (b *nodeBus) announceOffline(clientID string) {
+	if b == nil {
+		return
+	}
+	b.sendAll(busPresence, presenceUpdate{Offline: []string{clientID}})
+}
+
+// busStats 节点总线状态，未启用时返回nil
+func (s *Server) busStats() map[string]interface{} {
+	if s.bus == nil {
+		return nil
+	}
+	return s.bus.stats()
+}
+
+// busLinkStats 单个总线连接的状态
+type busLinkStats struct {
+	NodeID   string `json:"node_id"`
+	Addr     string `json:"addr"`
+	Outgoing bool   `json:"outgoing"`
+	Since    string `json:"since"`
+	Sent     int64  `json:"sent"`
+	Received int64  `json:"received"`
+}
+
+// stats 导出总线状态
+func (b *nodeBus) stats() map[string]interface{} {
+	b.mu.Lock()
+	links := make([]busLinkStats, 0, len(b.links))
+	for _, link := range b.links {
+		links = append(links, busLinkStats{
+			NodeID:   link.nodeID,
+			Addr:     link.addr,
+			Outgoing: link.outgoing,
+			Since:    link.since.Format(time.RFC3339),
+			Sent:     link.sent.Load(),
+			Received: link.received.Load(),
+		})
+	}
+	pending := len(b.pending)
+	b.mu.Unlock()
+	sort.Slice(links, func(i, j int) bool { return links[i].NodeID < links[j].NodeID })
+
+	return map[string]interface{}{
+		"node_id":    b.s.nodeID,
+		"links":      links,
+		"pending":    pending,
+		"reconnects": b.reconnects.Load(),
+		"fallbacks":  b.fallbacks.Load(),
+	}
+}
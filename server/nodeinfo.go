@@ -0,0 +1,43 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// runtimeMemory 进程的Go运行时内存统计。同一进程中运行多个节点时（multi模式）各节点报告相同的值
+func runtimeMemory() map[string]interface{} {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return map[string]interface{}{
+		"heap_alloc": m.HeapAlloc,
+		"heap_inuse": m.HeapInuse,
+		"sys":        m.Sys,
+		"num_gc":     m.NumGC,
+		"goroutines": runtime.NumGoroutine(),
+	}
+}
+
+// handleNodeInfo 处理节点信息请求：连接数、消息收发总数、运行时长和内存，供负载均衡器汇总集群统计
+func (s *Server) handleNodeInfo(w http.ResponseWriter, r *http.Request) {
+	memory := runtimeMemory()
+	memory["connections_estimate"] = s.MemoryStats(0)["total"]
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"node_id":        s.nodeID,
+		"port":           s.port,
+		"clients":        s.GetClientCount(),
+		"status":         "running",
+		"start_time":     s.startTime.Format(time.RFC3339),
+		"uptime_seconds": int64(time.Since(s.startTime).Seconds()),
+		"messages": map[string]int64{
+			"received":       s.messageMetrics.received.Load(),
+			"received_bytes": s.messageMetrics.receivedBytes.Load(),
+			"sent":           s.messageMetrics.sent.Load(),
+		},
+		"memory": memory,
+	})
+}
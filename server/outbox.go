@@ -0,0 +1,289 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+	"websocket-loadbalance/tracing"
+)
+
+var errOutboxFull = errors.New("客户端离线队列已满")
+
+// OutboxConfig 离线指令队列：/api/send-command 的目标客户端不在线时，指令暂存在收到请求的节点，
+// 客户端重新连接后发送，超过有效期仍未送达的指令被丢弃
+type OutboxConfig struct {
+	Enabled    bool              `json:"enabled" yaml:"enabled"`
+	TTL        protocol.Duration `json:"ttl" yaml:"ttl"`                 // 指令的有效期，默认5m
+	MaxDepth   int               `json:"max_depth" yaml:"max_depth"`     // 每个客户端最多暂存的指令数，默认100，队列满时返回429
+	MaxClients int               `json:"max_clients" yaml:"max_clients"` // 最多为多少个离线客户端暂存指令，默认10000
+}
+
+// Validate 校验离线指令队列配置
+func (c OutboxConfig) Validate() error {
+	if c.TTL < 0 || c.MaxDepth < 0 || c.MaxClients < 0 {
+		return fmt.Errorf("outbox 的参数不能为负数")
+	}
+	return nil
+}
+
+// SetOutbox 设置离线指令队列（需在Start之前调用）
+func (s *Server) SetOutbox(cfg OutboxConfig) {
+	if cfg.TTL <= 0 {
+		cfg.TTL = protocol.Duration(5 * time.Minute)
+	}
+	if cfg.MaxDepth <= 0 {
+		cfg.MaxDepth = 100
+	}
+	if cfg.MaxClients <= 0 {
+		cfg.MaxClients = 10000
+	}
+	s.outbox.config = cfg
+}
+
+// outboxCommand 暂存的一条指令
+type outboxCommand struct {
+	command     string
+	data        interface{}
+	namespace   string // 发送方的命名空间，送达时客户端不属于该命名空间则丢弃
+	traceparent string // 请求的追踪上下文，送达时的 command.deliver span 属于同一条trace
+	queuedAt    time.Time
+	expiresAt   time.Time
+}
+
+// outbox 按客户端ID暂存的离线指令
+type outbox struct {
+	config OutboxConfig
+	mu     sync.Mutex
+	queues map[string][]outboxCommand
+
+	queued    atomic.Int64 // 进入离线队列的指令数
+	delivered atomic.Int64 // 客户端重连后送达的指令数
+	expired   atomic.Int64 // 过期丢弃的指令数
+	rejected  atomic.Int64 // 因队列满被拒绝的指令数
+	dropped   atomic.Int64 // 送达时因命名空间或能力不符而丢弃的指令数
+}
+
+func newOutbox() *outbox {
+	return &outbox{queues: make(map[string][]outboxCommand)}
+}
+
+// push 暂存指令，返回该客户端的队列深度
+func (o *outbox) push(clientID string, cmd outboxCommand) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	queue := o.pruneUnsafe(clientID, cmd.queuedAt)
+	if len(queue) >= o.config.MaxDepth || (len(queue) == 0 && len(o.queues) >= o.config.MaxClients) {
+		o.rejected.Add(1)
+		return len(queue), errOutboxFull
+	}
+	o.queues[clientID] = append(queue, cmd)
+	o.queued.Add(1)
+	return len(queue) + 1, nil
+}
+
+// take 取出客户端的全部未过期指令
+func (o *outbox) take(clientID string) []outboxCommand {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	queue := o.pruneUnsafe(clientID, time.Now())
+	delete(o.queues, clientID)
+	return queue
+}
+
+// pruneUnsafe 丢弃客户端队列中已过期的指令，返回剩余的指令
+func (o *outbox) pruneUnsafe(clientID string, now time.Time) []outboxCommand {
+	queue := o.queues[clientID]
+	kept := queue[:0]
+	for _, cmd := range queue {
+		if now.Before(cmd.expiresAt) {
+			kept = append(kept, cmd)
+		}
+	}
+	if expired := len(queue) - len(kept); expired > 0 {
+		o.expired.Add(int64(expired))
+		log.Printf("客户端 %s 未在有效期内重连，丢弃 %d 条离线指令", clientID, expired)
+	}
+	if len(kept) == 0 {
+		delete(o.queues, clientID)
+		return nil
+	}
+	o.queues[clientID] = kept
+	return kept
+}
+
+// clientIDs 当前有暂存指令的客户端
+func (o *outbox) clientIDs() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ids := make([]string, 0, len(o.queues))
+	for id := range o.queues {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// queueOfflineCommand 目标客户端不在线时将指令放入离线队列
+func (s *Server) queueOfflineCommand(w http.ResponseWriter, req commandRequest) {
+	now := time.Now()
+	cmd := outboxCommand{
+		command:     req.Command,
+		data:        req.Data,
+		namespace:   req.Namespace,
+		traceparent: req.Traceparent,
+		queuedAt:    now,
+		expiresAt:   now.Add(time.Duration(s.outbox.config.TTL)),
+	}
+	depth, err := s.outbox.push(req.ClientID, cmd)
+	if err != nil {
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":      false,
+			"code":         "outbox_full",
+			"node":         s.nodeID,
+			"client_id":    req.ClientID,
+			"outbox_depth": depth,
+			"error":        err.Error(),
+		})
+		return
+	}
+
+	log.Printf("客户端 %s 不在线，指令 %s 存入离线队列 (第%d条，%v 内有效)", req.ClientID, req.Command, depth,
+		time.Duration(s.outbox.config.TTL))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":        true,
+		"node":           s.nodeID,
+		"message":        "客户端不在线，指令已存入离线队列，将在客户端重连后发送",
+		"queued_offline": true,
+		"outbox_depth":   depth,
+		"expires_at":     cmd.expiresAt.Unix(),
+	})
+}
+
+// deliverOutbox 客户端在本节点注册后，依次发送其离线队列中的指令
+func (s *Server) deliverOutbox(client *ClientInfo) {
+	if !s.outbox.config.Enabled {
+		return
+	}
+	queue := s.outbox.take(client.ID)
+	if len(queue) == 0 {
+		return
+	}
+	delivered := 0
+	for _, cmd := range queue {
+		if !s.outboxCommandAllowed(client.ID, client.Namespace, client.Capabilities, cmd) {
+			continue
+		}
+		parent, _ := tracing.ParseTraceparent(cmd.traceparent)
+		if _, err := s.dispatchCommand(client.ID, cmd.command, cmd.data, "", parent); err != nil {
+			log.Printf("向客户端 %s 发送离线指令 %s 失败: %v", client.ID, cmd.command, err)
+			continue
+		}
+		delivered++
+	}
+	s.outbox.delivered.Add(int64(delivered))
+	log.Printf("客户端 %s 重新连接，发送 %d/%d 条离线指令", client.ID, delivered, len(queue))
+}
+
+// outboxCommandAllowed 检查暂存的指令是否仍能发给客户端：命名空间需与发送方一致，且客户端能处理该指令
+func (s *Server) outboxCommandAllowed(clientID, namespace string, caps *registry.Capabilities, cmd outboxCommand) bool {
+	if !registry.SameNamespace(cmd.namespace, namespace) {
+		log.Printf("丢弃客户端 %s 的离线指令 %s: 客户端属于命名空间 %s，发送方的命名空间为 %s",
+			clientID, cmd.command, namespace, cmd.namespace)
+		s.outbox.dropped.Add(1)
+		return false
+	}
+	if err := s.commandFeatureError(cmd.command); err != nil {
+		log.Printf("丢弃客户端 %s 的离线指令 %s: %v", clientID, cmd.command, err)
+		s.outbox.dropped.Add(1)
+		return false
+	}
+	if err := caps.CheckCommand(cmd.command, payloadSize(cmd.data)); err != nil {
+		log.Printf("丢弃客户端 %s 的离线指令 %s: %v", clientID, cmd.command, err)
+		s.outbox.dropped.Add(1)
+		return false
+	}
+	return true
+}
+
+// outboxSweeper 定期清理过期的离线指令，并将客户端已重连到其他节点的指令转发过去
+func (s *Server) outboxSweeper() {
+	interval := time.Duration(s.outbox.config.TTL) / 2
+	if interval > 5*time.Second {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.sweepOutbox()
+	}
+}
+
+func (s *Server) sweepOutbox() {
+	for _, clientID := range s.outbox.clientIDs() {
+		client, exists := registry.Get(clientID)
+		if !exists {
+			s.outbox.mu.Lock()
+			s.outbox.pruneUnsafe(clientID, time.Now())
+			s.outbox.mu.Unlock()
+			continue
+		}
+		if client.NodeID == s.nodeID {
+			// 正在本节点注册，由 deliverOutbox 发送
+			continue
+		}
+
+		queue := s.outbox.take(clientID)
+		delivered := 0
+		for _, cmd := range queue {
+			if !s.outboxCommandAllowed(clientID, client.NamespaceOf(), client.Capabilities, cmd) {
+				continue
+			}
+			status, _, err := s.forwardCommandToOtherNode(client, commandRequest{
+				ClientID:    clientID,
+				Command:     cmd.command,
+				Data:        cmd.data,
+				Namespace:   cmd.namespace,
+				Traceparent: cmd.traceparent,
+			})
+			if err != nil || status != http.StatusOK {
+				log.Printf("转发客户端 %s 的离线指令 %s 到节点 %s 失败: status=%d err=%v", clientID, cmd.command, client.NodeID, status, err)
+				continue
+			}
+			delivered++
+		}
+		s.outbox.delivered.Add(int64(delivered))
+		log.Printf("客户端 %s 已重连到节点 %s，转发 %d/%d 条离线指令", clientID, client.NodeID, delivered, len(queue))
+	}
+}
+
+// outboxStats 导出离线指令队列统计
+func (s *Server) outboxStats() map[string]interface{} {
+	s.outbox.mu.Lock()
+	clients, pending := len(s.outbox.queues), 0
+	for _, queue := range s.outbox.queues {
+		pending += len(queue)
+	}
+	s.outbox.mu.Unlock()
+
+	return map[string]interface{}{
+		"enabled":   s.outbox.config.Enabled,
+		"ttl":       time.Duration(s.outbox.config.TTL).String(),
+		"max_depth": s.outbox.config.MaxDepth,
+		"clients":   clients,
+		"pending":   pending,
+		"queued":    s.outbox.queued.Load(),
+		"delivered": s.outbox.delivered.Load(),
+		"expired":   s.outbox.expired.Load(),
+		"rejected":  s.outbox.rejected.Load(),
+		"dropped":   s.outbox.dropped.Load(),
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+)
+
+// HeartbeatConfig 客户端心跳配置。客户端每隔 interval 发送一条 heartbeat 消息，
+// 连续 missed_beats 个间隔没有收到心跳（或其他消息）的客户端标记为不活跃
+type HeartbeatConfig struct {
+	Interval    protocol.Duration `json:"interval" yaml:"interval"`         // 期望的心跳间隔，默认10s，在 heartbeat_ack 中告知客户端
+	MissedBeats int               `json:"missed_beats" yaml:"missed_beats"` // 允许连续缺失的心跳数，默认3
+}
+
+// Validate 校验心跳配置
+func (c HeartbeatConfig) Validate() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("heartbeat.interval 不能为负数")
+	}
+	if c.MissedBeats < 0 {
+		return fmt.Errorf("heartbeat.missed_beats 不能为负数")
+	}
+	return nil
+}
+
+// heartbeatOrDefault 填充默认的心跳间隔和缺失次数
+func heartbeatOrDefault(cfg HeartbeatConfig) HeartbeatConfig {
+	if cfg.Interval == 0 {
+		cfg.Interval = protocol.Duration(protocol.DefaultHeartbeatInterval)
+	}
+	if cfg.MissedBeats == 0 {
+		cfg.MissedBeats = 3
+	}
+	return cfg
+}
+
+// presenceState 客户端的心跳和在线状态，由读协程、心跳处理和定期检查并发访问
+type presenceState struct {
+	lastSeen       atomic.Int64 // 最后一次活动的时间（UnixNano）
+	heartbeats     atomic.Bool  // 客户端发送过心跳
+	reportedStatus atomic.Value // 客户端在心跳中上报的状态（string）
+	mu             sync.Mutex   // 保护以下字段
+	active         bool
+	status         string
+}
+
+func newPresenceState() *presenceState {
+	p := &presenceState{active: true, status: registry.StatusOnline}
+	p.lastSeen.Store(time.Now().UnixNano())
+	return p
+}
+
+// snapshot 读取在线状态，用于API输出
+func (p *presenceState) snapshot() (lastSeen time.Time, active bool, status string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return time.Unix(0, p.lastSeen.Load()), p.active, p.status
+}
+
+// SetHeartbeat 设置客户端心跳间隔和判定不活跃前允许缺失的心跳数（需在Start之前调用）
+func (s *Server) SetHeartbeat(cfg HeartbeatConfig) {
+	s.heartbeat = heartbeatOrDefault(cfg)
+}
+
+// presenceTimeout 超过该时长没有收到客户端的心跳或消息即判定为不活跃。
+// 从未发送心跳的客户端只能靠pong证明活跃，超时不短于一个ping周期
+func (s *Server) presenceTimeout(client *ClientInfo) time.Duration {
+	timeout := time.Duration(s.heartbeat.Interval) * time.Duration(s.heartbeat.MissedBeats)
+	if !client.presence.heartbeats.Load() {
+		timeout = max(timeout, s.pingInterval+s.pongTimeout)
+	}
+	return timeout
+}
+
+// touch 记录客户端的活动。pong只说明连接还在，客户端发送过心跳后不再以pong作为活跃依据，
+// 以便发现连接正常但应用已经卡住的客户端；从未发送心跳的旧客户端仍按pong判定
+func (s *Server) touch(client *ClientInfo, pong bool) {
+	if pong && client.presence.heartbeats.Load() {
+		return
+	}
+	client.presence.lastSeen.Store(time.Now().UnixNano())
+}
+
+// handleHeartbeat 处理客户端心跳：刷新活跃时间，按消息中的 status 更新在线状态，并回复 heartbeat_ack
+func (s *Server) handleHeartbeat(client *ClientInfo, msg map[string]interface{}) error {
+	if !client.presence.heartbeats.Swap(true) {
+		log.Printf("客户端 %s 启用心跳，在线状态由心跳驱动", client.ID)
+	}
+	if status, _ := msg["status"].(string); status != "" {
+		if status != registry.StatusOnline && status != registry.StatusBusy {
+			return s.rejectMessage(client, msg, &protocol.ValidationError{Field: "status", Message: "必须是 online 或 busy"})
+		}
+		client.presence.reportedStatus.Store(status)
+	}
+	s.touch(client, false)
+	s.updatePresence(client, time.Now())
+
+	return client.writer.WriteJSON(map[string]interface{}{
+		"type":      protocol.TypeHeartbeatAck,
+		"interval":  time.Duration(s.heartbeat.Interval).Seconds(),
+		"timestamp": time.Now().Unix(),
+	})
+}
+
+// presenceLoop 每个心跳间隔检查一次本节点客户端的活跃状态
+func (s *Server) presenceLoop() {
+	ticker := time.NewTicker(time.Duration(s.heartbeat.Interval))
+	defer ticker.Stop()
+	for now := range ticker.C {
+		s.clientsMu.RLock()
+		clients := make([]*ClientInfo, 0, len(s.clients))
+		for _, client := range s.clients {
+			clients = append(clients, client)
+		}
+		s.clientsMu.RUnlock()
+		for _, client := range clients {
+			s.updatePresence(client, now)
+		}
+	}
+}
+
+// updatePresence 根据最后活动时间和客户端上报的状态计算在线状态，变化时写入注册表并通告其他节点
+func (s *Server) updatePresence(client *ClientInfo, now time.Time) {
+	p := client.presence
+	lastSeen := time.Unix(0, p.lastSeen.Load())
+	active := now.Sub(lastSeen) <= s.presenceTimeout(client)
+	status := registry.StatusOffline
+	if active {
+		status, _ = p.reportedStatus.Load().(string)
+		if status == "" {
+			status = registry.StatusOnline
+		}
+	}
+
+	p.mu.Lock()
+	changed := p.active != active || p.status != status
+	p.active, p.status = active, status
+	p.mu.Unlock()
+
+	registry.SetPresence(client.ID, active, status, lastSeen, changed)
+	if !changed {
+		return
+	}
+	if active {
+		log.Printf("客户端 %s 状态变为 %s", client.ID, status)
+	} else {
+		log.Printf("客户端 %s 已 %v 没有心跳，标记为不活跃", client.ID, now.Sub(lastSeen).Round(time.Second))
+	}
+	s.bus.announceOnline(client.ID)
+}
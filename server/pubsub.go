@@ -0,0 +1,317 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/logging"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+)
+
+// maxTopicLength 主题名称的最大长度
+const maxTopicLength = 256
+
+// topicManager 本节点的主题订阅关系
+type topicManager struct {
+	mu           sync.RWMutex
+	topics       map[string]map[string]*ClientInfo // 主题 -> 客户端ID -> 客户端
+	clientTopics map[string]map[string]bool        // 客户端ID -> 已订阅的主题，断开时据此清理
+
+	published atomic.Int64 // 本节点收到的发布次数（含其他节点转发）
+	delivered atomic.Int64 // 投递给本节点订阅者的消息数
+	forwarded atomic.Int64 // 转发到其他节点的次数
+}
+
+func newTopicManager() *topicManager {
+	return &topicManager{
+		topics:       make(map[string]map[string]*ClientInfo),
+		clientTopics: make(map[string]map[string]bool),
+	}
+}
+
+func validateTopic(topic string) error {
+	if topic == "" {
+		return fmt.Errorf("topic为必填字段")
+	}
+	if len(topic) > maxTopicLength {
+		return fmt.Errorf("主题名称超过 %d 字节", maxTopicLength)
+	}
+	return nil
+}
+
+func (m *topicManager) subscribe(topic string, client *ClientInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.topics[topic] == nil {
+		m.topics[topic] = make(map[string]*ClientInfo)
+	}
+	m.topics[topic][client.ID] = client
+	if m.clientTopics[client.ID] == nil {
+		m.clientTopics[client.ID] = make(map[string]bool)
+	}
+	m.clientTopics[client.ID][topic] = true
+}
+
+func (m *topicManager) unsubscribe(topic, clientID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.unsubscribeUnsafe(topic, clientID)
+}
+
+func (m *topicManager) unsubscribeUnsafe(topic, clientID string) {
+	if subscribers := m.topics[topic]; subscribers != nil {
+		delete(subscribers, clientID)
+		if len(subscribers) == 0 {
+			delete(m.topics, topic)
+		}
+	}
+	if topics := m.clientTopics[clientID]; topics != nil {
+		delete(topics, topic)
+		if len(topics) == 0 {
+			delete(m.clientTopics, clientID)
+		}
+	}
+}
+
+// removeClient 客户端断开时取消其全部订阅
+func (m *topicManager) removeClient(clientID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for topic := range m.clientTopics[clientID] {
+		m.unsubscribeUnsafe(topic, clientID)
+	}
+}
+
+// subscribers 主题在指定命名空间内的订阅者
+func (m *topicManager) subscribers(namespace, topic string) []*ClientInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	clients := make([]*ClientInfo, 0, len(m.topics[topic]))
+	for _, client := range m.topics[topic] {
+		if client.Namespace == namespace {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// topicsOf 客户端已订阅的主题
+func (m *topicManager) topicsOf(clientID string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	topics := make([]string, 0, len(m.clientTopics[clientID]))
+	for topic := range m.clientTopics[clientID] {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// handlePubSubMessage 处理客户端的订阅、取消订阅和发布消息，并回复确认
+func (s *Server) handlePubSubMessage(client *ClientInfo, data []byte) error {
+	var msg protocol.PubSubMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		log.Printf("收到无效的发布订阅消息: %v", err)
+		return nil
+	}
+
+	ack := protocol.PubSubAck{
+		Type:      protocol.TypePubSubAck,
+		ID:        msg.ID,
+		Action:    msg.Type,
+		Topic:     msg.Topic,
+		Success:   true,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	if err := validateTopic(msg.Topic); err != nil {
+		ack.Success = false
+		ack.Error = err.Error()
+		return client.writer.WriteJSON(ack)
+	}
+
+	switch msg.Type {
+	case protocol.TypeSubscribe:
+		s.topics.subscribe(msg.Topic, client)
+		logging.Debugf("客户端 %s 订阅主题 %s", client.ID, msg.Topic)
+	case protocol.TypeUnsubscribe:
+		s.topics.unsubscribe(msg.Topic, client.ID)
+		logging.Debugf("客户端 %s 取消订阅主题 %s", client.ID, msg.Topic)
+	case protocol.TypePublish:
+		ack.Delivered = s.Publish(client.Namespace, msg.Topic, msg.Data, client.ID)
+	}
+	return client.writer.WriteJSON(ack)
+}
+
+// Publish 向命名空间内的主题发布消息：投递给本节点的订阅者，并转发到其他节点，返回本节点投递的订阅者数。
+// 主题按命名空间隔离，其他命名空间订阅了同名主题的客户端收不到消息
+func (s *Server) Publish(namespace, topic string, data interface{}, from string) int {
+	delivered := s.deliverTopicMessage(namespace, topic, data, from)
+	go s.forwardPublish(namespace, topic, data, from)
+	return delivered
+}
+
+// deliverTopicMessage 将主题消息投递给本节点该命名空间的订阅者，消息只编码一次
+func (s *Server) deliverTopicMessage(namespace, topic string, data interface{}, from string) int {
+	s.topics.published.Add(1)
+	namespace, _ = registry.NormalizeNamespace(namespace)
+	subscribers := s.topics.subscribers(namespace, topic)
+	if len(subscribers) == 0 {
+		return 0
+	}
+
+	encoded, err := json.Marshal(protocol.PubSubMessage{
+		Type:      protocol.TypeTopicMessage,
+		Topic:     topic,
+		Data:      data,
+		From:      from,
+		Timestamp: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		log.Printf("序列化主题 %s 的消息失败: %v", topic, err)
+		return 0
+	}
+	pm, err := websocket.NewPreparedMessage(websocket.TextMessage, encoded)
+	if err != nil {
+		log.Printf("创建主题 %s 的消息失败: %v", topic, err)
+		return 0
+	}
+
+	delivered := 0
+	for _, client := range subscribers {
+		if _, err := client.writer.WritePrepared(pm, encoded); err != nil {
+			log.Printf("向订阅者 %s 投递主题 %s 的消息失败: %v", client.ID, topic, err)
+			continue
+		}
+		delivered++
+	}
+	s.topics.delivered.Add(int64(delivered))
+	return delivered
+}
+
+// forwardedPublish 节点之间转发的发布请求
+type forwardedPublish struct {
+	Topic      string      `json:"topic"`
+	Data       interface{} `json:"data"`
+	From       string      `json:"from,omitempty"`
+	Namespace  string      `json:"namespace,omitempty"`   // 发布者的命名空间，为空表示默认命名空间
+	OriginNode string      `json:"origin_node,omitempty"` // 非空表示来自其他节点，只在本地投递不再转发
+}
+
+// peerNodes 注册表中有客户端连接的其他节点（节点ID -> 端口）
+func (s *Server) peerNodes() map[string]int {
+	peers := make(map[string]int)
+	for _, client := range registry.All() {
+		if client.NodeID != s.nodeID && client.IsActive {
+			peers[client.NodeID] = client.NodePort
+		}
+	}
+	return peers
+}
+
+// forwardPublish 将发布请求转发到其他节点，由各节点投递给自己的订阅者。
+// 优先通过节点总线发送，总线未连接的节点回退到HTTP
+func (s *Server) forwardPublish(namespace, topic string, data interface{}, from string) {
+	msg := forwardedPublish{Topic: topic, Data: data, From: from, Namespace: namespace, OriginNode: s.nodeID}
+	reached := make(map[string]bool)
+	if s.bus != nil {
+		reached = s.bus.sendAll(busPublish, msg)
+		s.topics.forwarded.Add(int64(len(reached)))
+	}
+
+	peers := s.peerNodes()
+	for nodeID := range reached {
+		delete(peers, nodeID)
+	}
+	if len(peers) == 0 {
+		return
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	for nodeID, port := range peers {
+		targetURL := s.peerURL(port, "/api/publish")
+		resp, err := httpClient.Post(targetURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("转发主题 %s 的消息到节点 %s 失败: %v", topic, nodeID, err)
+			continue
+		}
+		resp.Body.Close()
+		s.topics.forwarded.Add(1)
+	}
+}
+
+// handlePublish 通过HTTP向主题发布消息，也用于接收其他节点转发的发布
+// POST /api/publish {"topic": "prices", "data": {...}, "namespace": "team-a"}，未指定命名空间时发布到默认命名空间
+func (s *Server) handlePublish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "仅支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+	var req forwardedPublish
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "请求格式错误", http.StatusBadRequest)
+		return
+	}
+	if err := validateTopic(req.Topic); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	namespace, err := registry.NormalizeNamespace(req.Namespace)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var delivered int
+	if req.OriginNode != "" {
+		delivered = s.deliverTopicMessage(namespace, req.Topic, req.Data, req.From)
+	} else {
+		delivered = s.Publish(namespace, req.Topic, req.Data, req.From)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"node":      s.nodeID,
+		"topic":     req.Topic,
+		"namespace": namespace,
+		"delivered": delivered,
+	})
+}
+
+// handleTopics 本节点的主题及订阅者数
+func (s *Server) handleTopics(w http.ResponseWriter, r *http.Request) {
+	s.topics.mu.RLock()
+	topics := make([]map[string]interface{}, 0, len(s.topics.topics))
+	for topic, subscribers := range s.topics.topics {
+		topics = append(topics, map[string]interface{}{
+			"topic":       topic,
+			"subscribers": len(subscribers),
+		})
+	}
+	s.topics.mu.RUnlock()
+	sort.Slice(topics, func(i, j int) bool { return topics[i]["topic"].(string) < topics[j]["topic"].(string) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"node_id":   s.nodeID,
+		"total":     len(topics),
+		"topics":    topics,
+		"published": s.topics.published.Load(),
+		"delivered": s.topics.delivered.Load(),
+		"forwarded": s.topics.forwarded.Load(),
+	})
+}
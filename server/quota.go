@@ -0,0 +1,111 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// 配额统计窗口
+const quotaWindow = time.Minute
+
+// QuotaConfig 每个客户端的消息配额，0表示不限制
+type QuotaConfig struct {
+	MessagesPerMinute int     `json:"messages_per_minute" yaml:"messages_per_minute"` // 每分钟消息数上限
+	BytesPerMinute    int64   `json:"bytes_per_minute" yaml:"bytes_per_minute"`       // 每分钟字节数上限
+	WarnRatio         float64 `json:"warn_ratio" yaml:"warn_ratio"`                   // 达到上限的该比例时发送警告
+}
+
+// QuotaUsage 客户端当前窗口的配额消耗
+type QuotaUsage struct {
+	WindowStart  time.Time `json:"window_start"`
+	Messages     int       `json:"messages"`
+	Bytes        int64     `json:"bytes"`
+	MessageLimit int       `json:"message_limit"`
+	ByteLimit    int64     `json:"byte_limit"`
+	Dropped      int       `json:"dropped"` // 超出配额被丢弃的消息数（累计）
+}
+
+// quotaTracker 单个连接的配额计数器
+type quotaTracker struct {
+	config QuotaConfig
+	usage  QuotaUsage
+	warned map[string]bool // 本窗口已发送过警告的配额类型
+	mu     sync.Mutex
+}
+
+// quotaEvent 一次记录产生的配额事件
+type quotaEvent struct {
+	Type  string // messages 或 bytes
+	Used  int64
+	Limit int64
+}
+
+func newQuotaTracker(config QuotaConfig) *quotaTracker {
+	return &quotaTracker{
+		config: config,
+		usage: QuotaUsage{
+			WindowStart:  time.Now(),
+			MessageLimit: config.MessagesPerMinute,
+			ByteLimit:    config.BytesPerMinute,
+		},
+		warned: make(map[string]bool),
+	}
+}
+
+// enabled 是否配置了任何配额
+func (c QuotaConfig) enabled() bool {
+	return c.MessagesPerMinute > 0 || c.BytesPerMinute > 0
+}
+
+// record 记录一条消息，返回需要发送的警告以及超限信息（超限时消息应被丢弃）
+func (q *quotaTracker) record(size int) (warnings []quotaEvent, exceeded *quotaEvent) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(q.usage.WindowStart) >= quotaWindow {
+		q.usage.WindowStart = now
+		q.usage.Messages = 0
+		q.usage.Bytes = 0
+		q.warned = make(map[string]bool)
+	}
+
+	q.usage.Messages++
+	q.usage.Bytes += int64(size)
+
+	checks := []quotaEvent{
+		{Type: "messages", Used: int64(q.usage.Messages), Limit: int64(q.config.MessagesPerMinute)},
+		{Type: "bytes", Used: q.usage.Bytes, Limit: q.config.BytesPerMinute},
+	}
+	for i := range checks {
+		check := checks[i]
+		if check.Limit <= 0 {
+			continue
+		}
+		if check.Used > check.Limit {
+			q.usage.Dropped++
+			return warnings, &check
+		}
+		if !q.warned[check.Type] && float64(check.Used) >= float64(check.Limit)*q.config.WarnRatio {
+			q.warned[check.Type] = true
+			warnings = append(warnings, check)
+		}
+	}
+	return warnings, nil
+}
+
+// snapshot 返回当前配额消耗的副本
+func (q *quotaTracker) snapshot() *QuotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	usage := q.usage
+	return &usage
+}
+
+// windowReset 当前窗口的重置时间
+func (q *quotaTracker) windowReset() time.Time {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.usage.WindowStart.Add(quotaWindow)
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+)
+
+// errRateLimitAbuse 客户端持续超出速率限制，连接应被关闭
+var errRateLimitAbuse = errors.New("持续超出消息速率限制")
+
+// rateLimitNoticeInterval 同一连接两次限流错误消息之间的最小间隔，避免错误消息本身放大流量
+const rateLimitNoticeInterval = time.Second
+
+// RateLimitConfig 每个客户端入站消息的令牌桶限流，MessagesPerSecond为0表示不限制
+type RateLimitConfig struct {
+	MessagesPerSecond float64           `json:"messages_per_second" yaml:"messages_per_second"` // 令牌补充速率
+	Burst             int               `json:"burst" yaml:"burst"`                             // 桶容量，默认等于每秒消息数
+	DisconnectAfter   int               `json:"disconnect_after" yaml:"disconnect_after"`       // 窗口内被限流的消息数达到该值时断开，0表示不断开
+	DisconnectWindow  protocol.Duration `json:"disconnect_window" yaml:"disconnect_window"`     // 统计被限流消息的窗口，默认10s
+}
+
+// Validate 校验限流配置
+func (c RateLimitConfig) Validate() error {
+	if c.MessagesPerSecond < 0 || c.Burst < 0 || c.DisconnectAfter < 0 {
+		return fmt.Errorf("rate_limit 的参数不能为负数")
+	}
+	return nil
+}
+
+// rateLimiter 单个连接的令牌桶
+type rateLimiter struct {
+	config RateLimitConfig
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+
+	violations     int       // 当前窗口内被限流的消息数
+	violationStart time.Time // 当前统计窗口的开始时间
+	lastNotice     time.Time // 上次发送限流错误消息的时间
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		config: config,
+		tokens: float64(config.Burst),
+		last:   time.Now(),
+	}
+}
+
+// allow 消耗一个令牌。返回值：是否放行、是否需要通知客户端、是否应断开连接
+func (l *rateLimiter) allow() (allowed, notify, disconnect bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = math.Min(float64(l.config.Burst), l.tokens+now.Sub(l.last).Seconds()*l.config.MessagesPerSecond)
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, false, false
+	}
+
+	if now.Sub(l.violationStart) > time.Duration(l.config.DisconnectWindow) {
+		l.violationStart = now
+		l.violations = 0
+	}
+	l.violations++
+	if l.config.DisconnectAfter > 0 && l.violations >= l.config.DisconnectAfter {
+		return false, false, true
+	}
+	if now.Sub(l.lastNotice) >= rateLimitNoticeInterval {
+		l.lastNotice = now
+		notify = true
+	}
+	return false, notify, false
+}
+
+// retryAfter 下一个令牌可用前需要等待的时间
+func (l *rateLimiter) retryAfter() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	missing := 1 - l.tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / l.config.MessagesPerSecond * float64(time.Second))
+}
+
+// RateLimitMetrics 限流统计
+type RateLimitMetrics struct {
+	limited      atomic.Int64 // 被限流丢弃的消息数
+	disconnected atomic.Int64 // 因持续超限被断开的连接数
+}
+
+// SetRateLimit 设置每个客户端的入站消息限流（需在Start之前调用）
+func (s *Server) SetRateLimit(cfg RateLimitConfig) {
+	if cfg.Burst <= 0 {
+		cfg.Burst = int(math.Ceil(cfg.MessagesPerSecond))
+	}
+	if cfg.DisconnectWindow <= 0 {
+		cfg.DisconnectWindow = protocol.Duration(10 * time.Second)
+	}
+	s.rateLimit = cfg
+}
+
+// checkRateLimit 对一条入站消息执行限流，返回false表示消息被丢弃；
+// 持续超限时返回errRateLimitAbuse，连接以关闭码1008断开
+func (s *Server) checkRateLimit(client *ClientInfo) (bool, error) {
+	allowed, notify, disconnect := client.limiter.allow()
+	if allowed {
+		return true, nil
+	}
+	s.rateLimitMetrics.limited.Add(1)
+
+	if disconnect {
+		s.rateLimitMetrics.disconnected.Add(1)
+		log.Printf("🚫 客户端 %s 持续超出消息速率限制，断开连接", client.ID)
+		closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "持续超出消息速率限制")
+		client.Connection.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		return false, errRateLimitAbuse
+	}
+
+	if notify {
+		log.Printf("客户端 %s 超出消息速率限制 (%.1f条/秒, 突发%d)，丢弃消息", client.ID,
+			s.rateLimit.MessagesPerSecond, s.rateLimit.Burst)
+		client.writer.WriteJSON(map[string]interface{}{
+			"type":           "error",
+			"code":           "rate_limited",
+			"message":        "消息发送过快，已被丢弃",
+			"limit":          s.rateLimit.MessagesPerSecond,
+			"burst":          s.rateLimit.Burst,
+			"retry_after_ms": client.limiter.retryAfter().Milliseconds(),
+			"timestamp":      time.Now().Unix(),
+		})
+	}
+	return false, nil
+}
+
+// rateLimitStats 导出限流统计
+func (s *Server) rateLimitStats() map[string]interface{} {
+	return map[string]interface{}{
+		"messages_per_second": s.rateLimit.MessagesPerSecond,
+		"burst":               s.rateLimit.Burst,
+		"limited":             s.rateLimitMetrics.limited.Load(),
+		"disconnected":        s.rateLimitMetrics.disconnected.Load(),
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/registry"
+)
+
+// 全局注册表文件不可用（写文件失败）时对新注册的处理
+const (
+	RegistryUnavailableAccept = "accept" // 默认：照常接受注册，注册表操作暂存在内存中，恢复后写回
+	RegistryUnavailableReject = "reject" // 拒绝新注册并以1013关闭连接，/readyz 返回503；已连接的客户端不受影响
+)
+
+// ValidateRegistryUnavailablePolicy 校验注册表不可用时的处理方式，空字符串等同于accept
+func ValidateRegistryUnavailablePolicy(policy string) error {
+	switch policy {
+	case "", RegistryUnavailableAccept, RegistryUnavailableReject:
+		return nil
+	}
+	return fmt.Errorf("无效的 registry_unavailable: %s (可选: accept, reject)", policy)
+}
+
+// SetRegistryUnavailablePolicy 设置注册表文件不可用时对新注册的处理（需在Start之前调用）
+func (s *Server) SetRegistryUnavailablePolicy(policy string) {
+	if policy == "" {
+		policy = RegistryUnavailableAccept
+	}
+	s.registryPolicy = policy
+}
+
+// rejectIfRegistryUnavailable 按配置在注册表不可用时拒绝注册，返回非nil表示已拒绝
+func (s *Server) rejectIfRegistryUnavailable(conn wsConn, clientID string) error {
+	if s.registryPolicy != RegistryUnavailableReject || !registry.Degraded() {
+		return nil
+	}
+	err := fmt.Errorf("全局注册表暂不可用，请稍后重连")
+	log.Printf("拒绝客户端 %s 注册: %v", clientID, err)
+	rejectRegistration(conn, "registry_unavailable", http.StatusServiceUnavailable, err)
+	// 1013 Try Again Later：客户端按退避重连，负载均衡器可能把它分配到其他节点
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "registry unavailable"), time.Now().Add(time.Second))
+	return err
+}
+
+// reconcileRegistry 注册表文件恢复后，用本节点当前连接的客户端替换注册表中本节点的记录，
+// 纠正降级期间与其他进程写入的文件之间的偏差
+func (s *Server) reconcileRegistry() {
+	s.clientsMu.RLock()
+	records := s.registryRecordsUnsafe()
+	s.clientsMu.RUnlock()
+	registry.SyncNode(s.nodeID, records)
+	log.Printf("全局注册表恢复可用，节点 %s 按当前连接的 %d 个客户端完成对账", s.nodeID, len(records))
+}
+
+// handleReadyz 就绪检查：关闭中、紧急停止，或注册表不可用且配置为拒绝注册时返回503。
+// 注册表不可用但仍接受注册时返回200，registry_degraded 为true
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	health := registry.Health()
+	ready := true
+	reasons := []string{}
+	if s.draining.Load() {
+		ready = false
+		reasons = append(reasons, "draining")
+	}
+	if s.emergency.active.Load() {
+		ready = false
+		reasons = append(reasons, "emergency_stop")
+	}
+	if health.Degraded && s.registryPolicy == RegistryUnavailableReject {
+		ready = false
+		reasons = append(reasons, "registry_unavailable")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ready":                ready,
+		"node_id":              s.nodeID,
+		"reasons":              reasons,
+		"registry_degraded":    health.Degraded,
+		"registry":             health,
+		"registry_unavailable": s.registryPolicy,
+	})
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+)
+
+// RegistryReconcileConfig 注册表对账：定期比较本节点当前连接的客户端与全局注册表中本节点的记录，
+// 补回缺失的记录（如注册表文件被其他进程覆盖），删除已断开客户端的记录（如节点崩溃重启后遗留），使偏差自行修复
+type RegistryReconcileConfig struct {
+	Enabled  bool              `json:"enabled" yaml:"enabled"`
+	Interval protocol.Duration `json:"interval" yaml:"interval"` // 对账间隔，默认1m
+}
+
+// Validate 校验注册表对账配置
+func (c RegistryReconcileConfig) Validate() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("registry_reconcile.interval 不能为负数")
+	}
+	return nil
+}
+
+// 默认的对账间隔
+const defaultReconcileInterval = time.Minute
+
+// 保留的最近发现偏差的对账结果数
+const maxReconcileReports = 20
+
+// ReconcileReport 一次对账的结果
+type ReconcileReport struct {
+	Time    time.Time `json:"time"`
+	Added   []string  `json:"added,omitempty"`   // 注册表中缺失、已补回的客户端
+	Removed []string  `json:"removed,omitempty"` // 已不再连接本节点、已删除的记录
+}
+
+// reconcileMetrics 对账统计
+type reconcileMetrics struct {
+	runs    atomic.Int64
+	added   atomic.Int64
+	removed atomic.Int64
+	mu      sync.Mutex
+	lastRun time.Time
+	recent  []ReconcileReport // 最近发现偏差的对账，最新的在最后
+}
+
+// SetRegistryReconcile 设置注册表对账（需在Start之前调用）
+func (s *Server) SetRegistryReconcile(cfg RegistryReconcileConfig) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = protocol.Duration(defaultReconcileInterval)
+	}
+	s.reconcile = cfg
+}
+
+// reconcileLoop 每个对账间隔执行一次对账，节点关闭时退出
+func (s *Server) reconcileLoop() {
+	ticker := time.NewTicker(time.Duration(s.reconcile.Interval))
+	defer ticker.Stop()
+	for range ticker.C {
+		if s.draining.Load() {
+			return
+		}
+		s.ReconcileRegistry()
+	}
+}
+
+// ReconcileRegistry 立即执行一次对账，返回补回和删除的客户端
+func (s *Server) ReconcileRegistry() ReconcileReport {
+	// 持有读锁期间连接集合不变；注册和注销先修改连接集合再写注册表，
+	// 尚未写入注册表的操作与对账的修正方向一致
+	s.clientsMu.RLock()
+	added, removed := registry.Reconcile(s.nodeID, s.registryRecordsUnsafe())
+	s.clientsMu.RUnlock()
+
+	report := ReconcileReport{Time: time.Now(), Added: added, Removed: removed}
+	m := &s.reconcileMetrics
+	m.runs.Add(1)
+	m.added.Add(int64(len(added)))
+	m.removed.Add(int64(len(removed)))
+	m.mu.Lock()
+	m.lastRun = report.Time
+	if len(added) > 0 || len(removed) > 0 {
+		m.recent = append(m.recent, report)
+		if len(m.recent) > maxReconcileReports {
+			m.recent = m.recent[len(m.recent)-maxReconcileReports:]
+		}
+	}
+	m.mu.Unlock()
+	if len(added) > 0 || len(removed) > 0 {
+		log.Printf("🔄 节点 %s 注册表对账: 补回 %d 条缺失的记录 %v，删除 %d 条过期记录 %v",
+			s.nodeID, len(added), added, len(removed), removed)
+	}
+	return report
+}
+
+// registryRecordsUnsafe 本节点当前连接的客户端对应的注册表记录（调用方持有clientsMu读锁）
+func (s *Server) registryRecordsUnsafe() []registry.ClientInfo {
+	records := make([]registry.ClientInfo, 0, len(s.clients))
+	for _, client := range s.clients {
+		lastSeen, active, status := client.presence.snapshot()
+		records = append(records, registry.ClientInfo{
+			ID:           client.ID,
+			Name:         client.Name,
+			Namespace:    client.Namespace,
+			NodeID:       s.nodeID,
+			NodePort:     s.port,
+			ConnTime:     client.ConnTime,
+			LastSeen:     lastSeen,
+			IsActive:     active,
+			Status:       status,
+			Capabilities: client.Capabilities,
+			Labels:       client.Labels,
+			Affinity:     client.Affinity,
+		})
+	}
+	return records
+}
+
+// reconcileStats 对账统计，供 /api/metrics 使用
+func (s *Server) reconcileStats() map[string]interface{} {
+	m := &s.reconcileMetrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := map[string]interface{}{
+		"enabled":  s.reconcile.Enabled,
+		"interval": time.Duration(s.reconcile.Interval).String(),
+		"runs":     m.runs.Load(),
+		"added":    m.added.Load(),
+		"removed":  m.removed.Load(),
+		"recent":   append([]ReconcileReport{}, m.recent...),
+	}
+	if !m.lastRun.IsZero() {
+		stats["last_run"] = m.lastRun
+	}
+	return stats
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/secrets"
+)
+
+const (
+	registrationTimeout = 5 * time.Second // 单次登记请求的超时
+	registrationRetry   = 2 * time.Second // 登记失败后的重试间隔
+)
+
+// RegistrationConfig 节点启动时向负载均衡器自注册为后端，之后按负载均衡器要求的间隔重复登记作为心跳，关闭时注销
+type RegistrationConfig struct {
+	LoadBalancer  string `json:"loadbalancer" yaml:"loadbalancer"`     // 负载均衡器的管理API地址，如 http://127.0.0.1:8080，为空表示不自注册
+	AdvertiseHost string `json:"advertise_host" yaml:"advertise_host"` // 负载均衡器连接本节点使用的主机名或IP，为空时取登记请求的来源地址
+	Weight        int    `json:"weight" yaml:"weight"`                 // 默认1
+	Token         string `json:"token" yaml:"token"`                   // 与负载均衡器的 self_registration.token 相同，可以是 env:/file:/vault: 引用
+}
+
+// Validate 校验自注册配置
+func (c RegistrationConfig) Validate() error {
+	if c.LoadBalancer != "" {
+		u, err := url.Parse(c.LoadBalancer)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("registration.loadbalancer 应为 http(s)://host:port 形式的地址: %q", c.LoadBalancer)
+		}
+	}
+	if c.Weight < 0 {
+		return fmt.Errorf("registration.weight 不能为负数")
+	}
+	if err := secrets.ValidateRef(c.Token); err != nil {
+		return fmt.Errorf("registration.token: %v", err)
+	}
+	return nil
+}
+
+// registrar 向负载均衡器登记本节点并定期续约
+type registrar struct {
+	s        *Server
+	cfg      RegistrationConfig
+	endpoint string // 负载均衡器的 /api/backends 地址
+	client   *http.Client
+	token    *secrets.Secret // 启动时解析
+	started  atomic.Bool
+	mu       sync.Mutex // 串行化登记和注销，注销后不再登记
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// SetRegistration 设置向负载均衡器自注册（需在Start之前调用），loadbalancer为空表示不自注册
+func (s *Server) SetRegistration(cfg RegistrationConfig) {
+	if cfg.LoadBalancer == "" {
+		s.registrar = nil
+		return
+	}
+	s.registrar = &registrar{
+		s:        s,
+		cfg:      cfg,
+		endpoint: strings.TrimSuffix(cfg.LoadBalancer, "/") + "/api/backends",
+		client:   &http.Client{Timeout: registrationTimeout},
+		done:     make(chan struct{}),
+	}
+}
+
+// start 解析登记令牌并在后台开始登记
+func (r *registrar) start() error {
+	token, err := secrets.Resolve(r.cfg.Token)
+	if err != nil {
+		return fmt.Errorf("读取 registration.token 失败: %v", err)
+	}
+	r.token = token
+	r.started.Store(true)
+	go r.run()
+	return nil
+}
+
+// run 立即登记，之后按负载均衡器返回的心跳间隔续约，失败时每隔 registrationRetry 重试
+func (r *registrar) run() {
+	failing := false
+	for {
+		wait := registrationRetry
+		interval, created, err := r.register()
+		switch {
+		case err != nil:
+			if !failing {
+				log.Printf("节点 %s 向负载均衡器 %s 登记失败，每 %v 重试: %v", r.s.nodeID, r.cfg.LoadBalancer, registrationRetry, err)
+			}
+			failing = true
+		default:
+			if created || failing {
+				log.Printf("节点 %s 已登记到负载均衡器 %s，心跳间隔 %v", r.s.nodeID, r.cfg.LoadBalancer, interval)
+			}
+			failing = false
+			if interval > 0 {
+				wait = interval
+			}
+		}
+		select {
+		case <-r.done:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// register 提交一次登记，返回负载均衡器要求的心跳间隔以及是否新增了后端
+func (r *registrar) register() (time.Duration, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	select {
+	case <-r.done:
+		return 0, false, nil
+	default:
+	}
+	body, _ := json.Marshal(protocol.BackendRegistration{
+		ID:         r.s.nodeID,
+		Host:       r.cfg.AdvertiseHost,
+		Port:       r.s.port,
+		Weight:     r.cfg.Weight,
+		MaxClients: r.s.maxClients,
+	})
+	resp, err := r.do("POST", r.endpoint, body)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return 0, false, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	var result protocol.BackendRegistrationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, false, fmt.Errorf("解析登记响应失败: %v", err)
+	}
+	return time.Duration(result.HeartbeatInterval), result.Created, nil
+}
+
+// deregister 停止续约并从负载均衡器注销，负载均衡器随即停止向本节点分配新连接
+func (r *registrar) deregister(ctx context.Context) {
+	r.stopOnce.Do(func() {
+		close(r.done)
+		if !r.started.Load() {
+			return
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		resp, err := r.doContext(ctx, "DELETE", r.endpoint+"/"+url.PathEscape(r.s.nodeID), nil)
+		if err != nil {
+			log.Printf("节点 %s 从负载均衡器注销失败: %v", r.s.nodeID, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			log.Printf("节点 %s 已从负载均衡器 %s 注销", r.s.nodeID, r.cfg.LoadBalancer)
+		}
+	})
+}
+
+func (r *registrar) do(method, target string, body []byte) (*http.Response, error) {
+	return r.doContext(context.Background(), method, target, body)
+}
+
+func (r *registrar) doContext(ctx context.Context, method, target string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := r.token.Value(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r.client.Do(req)
+}
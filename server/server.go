@@ -0,0 +1,1764 @@
+// Package server 实现WebSocket服务端节点：客户端连接管理、指令下发、广播和节点间转发
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/auth"
+	"websocket-loadbalance/forwarded"
+	"websocket-loadbalance/logging"
+	"websocket-loadbalance/origin"
+	"websocket-loadbalance/perf"
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/registry"
+	"websocket-loadbalance/tracing"
+)
+
+// 客户端连接信息
+type ClientInfo struct {
+	ID              string                 `json:"id"`
+	Name            string                 `json:"name"`
+	Namespace       string                 `json:"namespace"` // 所属命名空间，不同命名空间的客户端相互隔离
+	ConnTime        time.Time              `json:"conn_time"`
+	LastSeen        time.Time              `json:"last_seen"`
+	IsActive        bool                   `json:"is_active"`              // 按心跳判定的活跃状态
+	Status          string                 `json:"status"`                 // online、busy 或 offline
+	Annotation      *registry.Annotation   `json:"annotation,omitempty"`   // 运维备注
+	Quota           *QuotaUsage            `json:"quota,omitempty"`        // 配额消耗
+	Subject         string                 `json:"subject,omitempty"`      // 认证令牌的 sub 声明
+	Claims          map[string]interface{} `json:"claims,omitempty"`       // 认证令牌的全部声明
+	Capabilities    *registry.Capabilities `json:"capabilities,omitempty"` // 注册时声明的能力
+	Labels          map[string]string      `json:"labels,omitempty"`       // 注册时声明的标签
+	Affinity        string                 `json:"affinity,omitempty"`     // 注册时声明的节点亲和性，见 lb.Affinity
+	Latency         *LatencyStats          `json:"latency,omitempty"`      // ping/pong往返时延
+	Topics          []string               `json:"topics,omitempty"`       // 已订阅的主题
+	Commands        *CommandStats          `json:"commands,omitempty"`     // 在途和排队的指令数（启用指令并发限制时）
+	Encoding        string                 `json:"encoding"`               // 消息编码: json、msgpack 或 protobuf
+	ProtocolVersion int                    `json:"protocol_version"`       // 握手时协商的协议版本
+	RemoteAddr      string                 `json:"remote_addr"`            // 真实客户端地址，经受信的负载均衡器转发时取自 X-Forwarded-For 或PROXY协议头
+	Connection      wsConn                 `json:"-"`                      // 不序列化连接对象
+	writer          *connWriter            // 串行化写操作，支持批量发送
+	quota           *quotaTracker
+	limiter         *rateLimiter // 入站消息限流，nil表示不限制
+	latency         *latencyTracker
+	commands        *commandSlots  // 指令并发限制，nil表示不限制
+	acks            bool           // 注册时声明 ack，启用 command_delivery 时需确认收到的指令
+	presence        *presenceState // 心跳和在线状态
+	history         *clientHistory // 最近收发的消息，nil表示未启用消息记录
+	lifetime        *connLifetime  // 连接的回收时间，nil表示不限制连接存活时间
+}
+
+// WebSocket写缓冲区池，连接空闲时归还写缓冲区，减少大量长连接的常驻内存
+var writeBufferPool = &sync.Pool{}
+
+// Server WebSocket服务器 - 每个节点独立运行
+type Server struct {
+	port                int
+	upgrader            websocket.Upgrader
+	compressionLevel    int                    // 协商permessage-deflate后使用的压缩级别
+	clients             map[string]*ClientInfo // 使用clientID作为key
+	clientsMu           sync.RWMutex
+	nodeID              string
+	httpServer          *http.Server
+	mux                 *http.ServeMux          // 本节点的路由，同一进程中的多个节点互不干扰
+	listenAddress       string                  // 监听的主机地址，为空表示所有地址
+	addressFamily       string                  // 监听绑定的地址族: dual(默认)、ipv4、ipv6
+	unixSocket          string                  // 同时监听的Unix域套接字，为空表示只监听TCP端口
+	draining            atomic.Bool             // 关闭中，不再接受新连接
+	registryPolicy      string                  // 注册表文件不可用时对新注册的处理: accept(默认) 或 reject
+	unwatchRegistry     func()                  // 取消注册表恢复后的对账回调
+	reconcile           RegistryReconcileConfig // 定期核对注册表中本节点的记录
+	reconcileMetrics    reconcileMetrics
+	clientIDs           *clientIDScheme // 客户端ID方案
+	ready               chan struct{}   // Start 开始监听后关闭
+	pingInterval        time.Duration   // 向客户端发送ping的间隔
+	pongTimeout         time.Duration   // 等待pong的超时，超时视为死连接
+	writeTimeout        time.Duration   // 向客户端写一帧的超时，客户端停止读取时不会无限期阻塞发送方
+	quota               QuotaConfig     // 每个客户端的消息配额
+	batch               BatchConfig     // 消息批量发送配置
+	broadcastMetrics    BroadcastMetrics
+	pendingCommands     *pendingCommands // 等待客户端响应的同步指令
+	connMode            string           // 连接处理模式: gorilla(默认) 或 epoll(实验性)
+	pollWorkers         int              // epoll模式的工作协程数
+	poller              *poller
+	pollDone            chan struct{}
+	auth                auth.Provider       // 非nil时握手前和注册时认证客户端
+	origins             *origin.Policy      // 非nil时检查浏览器请求的来源并添加CORS响应头
+	forwarded           *forwarded.Resolver // 受信的负载均衡器，nil表示客户端地址即TCP对端地址
+	registrar           *registrar          // 非nil时向负载均衡器自注册
+	memory              MemoryConfig        // 连接内存上限
+	memoryShed          atomic.Int64        // 因内存压力断开的连接数
+	slowConsumer        SlowConsumerConfig  // 慢消费者检测
+	slowConsumerMetrics SlowConsumerMetrics
+	maxClients          int                   // 最大并发客户端数，0表示不限制
+	admitted            atomic.Int64          // 已占用名额的连接数（含握手和注册中的连接）
+	admissionRejected   atomic.Int64          // 因满载被拒绝的连接数
+	protocolVersions    protocol.VersionRange // 接受的客户端协议版本范围
+	versionRejected     atomic.Int64          // 因协议版本不支持被拒绝的连接数
+	buildVersion        string                // 在 /health 中上报的构建版本
+	labels              map[string]string     // 节点标签，在 /health 中上报，负载均衡器的 affinity 策略据此选择节点
+	rateLimit           RateLimitConfig       // 每个客户端的入站消息限流
+	maxMessageSize      int64                 // 客户端单条消息的最大字节数，0表示不限制
+	messageMetrics      MessageMetrics
+	rateLimitMetrics    RateLimitMetrics
+	topics              *topicManager            // 发布订阅的主题订阅关系
+	commandConcurrency  CommandConcurrencyConfig // 每个客户端的指令并发限制
+	commandMetrics      CommandConcurrencyMetrics
+	bus                 *nodeBus                 // 节点总线，nil表示节点之间使用HTTP转发
+	idempotency         *idempotencyStore        // 管理API的幂等键
+	outbox              *outbox                  // 离线客户端的指令队列
+	deliveries          *deliveryTracker         // 需要客户端确认的指令
+	history             *messageHistory          // 按客户端保留的最近消息，nil表示不记录
+	commandHistory      *commandHistory          // 按客户端保留的最近指令，nil表示不记录
+	featureFlags        FeatureFlagsConfig       // 功能开关的检查间隔
+	features            nodeFeatures             // 本节点当前生效的内置功能开关
+	middleware          []protocol.Middleware    // 客户端消息中间件
+	commandLog          CommandLogConfig         // 指令记录的配置
+	commandStore        CommandStore             // 指令及其响应的存储，nil表示不记录
+	commandLatency      *commandLatency          // 指令从受理到客户端响应的时延
+	emergency           *emergencyStop           // 紧急停止，生效时拒绝所有新连接
+	startTime           time.Time                // 节点启动时间
+	heartbeat           HeartbeatConfig          // 客户端心跳间隔和判定不活跃的缺失次数
+	lifetime            ConnectionLifetimeConfig // 连接最长存活时间
+	lifetimeMetrics     LifetimeMetrics
+	unregisterMetrics   func() // 注销OTLP指标来源，Start 之后有效
+}
+
+// New 创建新服务器
+func New(port int, nodeID string) *Server {
+	mux := http.NewServeMux()
+	return &Server{
+		port: port,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return true // 来源由 SetOrigins 设置的策略在握手前检查，未设置时允许所有来源
+			},
+			WriteBufferPool: writeBufferPool,
+		},
+		clients:          make(map[string]*ClientInfo),
+		nodeID:           nodeID,
+		httpServer:       &http.Server{Addr: ":" + strconv.Itoa(port), Handler: mux},
+		mux:              mux,
+		pingInterval:     20 * time.Second,
+		pongTimeout:      10 * time.Second,
+		writeTimeout:     10 * time.Second,
+		registryPolicy:   RegistryUnavailableAccept,
+		reconcile:        RegistryReconcileConfig{Interval: protocol.Duration(defaultReconcileInterval)},
+		clientIDs:        &clientIDScheme{config: ClientIDConfig{Scheme: ClientIDSchemeClient, Legacy: LegacyIDReject}},
+		pendingCommands:  newPendingCommands(),
+		connMode:         ConnModeGorilla,
+		topics:           newTopicManager(),
+		idempotency:      newIdempotencyStore(),
+		outbox:           newOutbox(),
+		deliveries:       newDeliveryTracker(),
+		commandLatency:   newCommandLatency(),
+		emergency:        &emergencyStop{},
+		protocolVersions: protocolVersionsOrDefault(protocol.VersionRange{}),
+		buildVersion:     protocol.BuildVersion,
+		heartbeat:        heartbeatOrDefault(HeartbeatConfig{}),
+		featureFlags:     FeatureFlagsConfig{WatchInterval: protocol.Duration(2 * time.Second)},
+		ready:            make(chan struct{}),
+	}
+}
+
+// NodeID 返回节点ID
+func (s *Server) NodeID() string {
+	return s.nodeID
+}
+
+// Ready 返回在 Start 开始监听端口后关闭的通道，Start 失败时不会关闭
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// StopAccepting 停止接受新的WebSocket连接，已有连接不受影响（Shutdown 会先执行这一步）。
+// 同一进程中的多个节点关闭时先让所有节点停止接受新连接，再逐个排空，
+// 避免被断开的客户端重连到尚未关闭的节点
+func (s *Server) StopAccepting() {
+	s.draining.Store(true)
+}
+
+// SetQuota 设置每个客户端的消息配额（需在Start之前调用）
+func (s *Server) SetQuota(quota QuotaConfig) {
+	if quota.WarnRatio <= 0 || quota.WarnRatio > 1 {
+		quota.WarnRatio = 0.8
+	}
+	s.quota = quota
+}
+
+// SetBatching 设置消息批量发送（需在Start之前调用），仅对注册时声明 accept_batch 的客户端生效
+func (s *Server) SetBatching(batch BatchConfig) {
+	if batch.Window <= 0 {
+		batch.Window = protocol.Duration(5 * time.Millisecond)
+	}
+	if batch.MaxMessages <= 0 {
+		batch.MaxMessages = 64
+	}
+	s.batch = batch
+}
+
+// SetPerformance 应用性能参数（需在SetBatching之后、Start之前调用）
+func (s *Server) SetPerformance(p perf.Settings) {
+	s.upgrader.ReadBufferSize = p.ReadBufferSize
+	s.upgrader.WriteBufferSize = p.WriteBufferSize
+	s.upgrader.EnableCompression = p.Compression
+	s.compressionLevel = p.CompressionLevel
+	if enabled, fixed := p.BatchingOverride(); fixed {
+		s.batch.Enabled = enabled
+	} else if enabled {
+		s.batch.Enabled = true
+	}
+	if s.batch.Enabled && p.BatchWindow > 0 {
+		s.batch.Window = protocol.Duration(p.BatchWindow)
+	}
+}
+
+// SetConnMode 设置连接处理模式（需在Start之前调用）：gorilla为每连接一个读协程，
+// epoll为实验性的事件驱动模式（仅Linux），workers<=0时按CPU数自动设置
+func (s *Server) SetConnMode(mode string, workers int) {
+	if mode == "" {
+		mode = ConnModeGorilla
+	}
+	s.connMode = mode
+	s.pollWorkers = workers
+}
+
+// SetAuth 设置客户端认证方式（需在Start之前调用），传nil表示不认证
+func (s *Server) SetAuth(provider auth.Provider) {
+	s.auth = provider
+}
+
+// SetOrigins 设置来源白名单和CORS（需在Start之前调用），传nil表示允许所有来源
+func (s *Server) SetOrigins(policy *origin.Policy) {
+	s.origins = policy
+}
+
+// SetForwarded 设置受信的负载均衡器（需在Start之前调用），传nil表示不信任任何代理。
+// 启用 proxy_protocol 时监听端口同时接受PROXY协议头
+func (s *Server) SetForwarded(res *forwarded.Resolver) {
+	s.forwarded = res
+}
+
+// clientAddr 请求的真实客户端地址，用于日志和客户端信息
+func (s *Server) clientAddr(r *http.Request) string {
+	return s.forwarded.ClientIP(r)
+}
+
+// authenticate 校验握手请求，失败时已写入401响应
+// 未启用认证时返回 (nil, nil, true)
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*auth.Claims, http.Header, bool) {
+	if s.auth == nil {
+		return nil, nil, true
+	}
+	claims, header, err := s.auth.AuthenticateUpgrade(r)
+	if err != nil {
+		log.Printf("节点 %s 拒绝未认证的连接 (%s): %v", s.nodeID, s.clientAddr(r), err)
+		http.Error(w, "认证失败: "+err.Error(), http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	return claims, header, true
+}
+
+// SetSlowConsumer 设置慢消费者检测（需在Start之前调用）
+func (s *Server) SetSlowConsumer(cfg SlowConsumerConfig) {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 256
+	}
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = protocol.Duration(5 * time.Second)
+	}
+	if cfg.Policy == "" {
+		cfg.Policy = SlowPolicyDrop
+	}
+	s.slowConsumer = cfg
+}
+
+// SetListenAddress 设置监听的主机地址和地址族（dual、ipv4、ipv6）（需在Start之前调用）。
+// 同一主机上的节点之间按该地址互相转发请求，各节点应使用相同的设置
+func (s *Server) SetListenAddress(host, family string) {
+	s.listenAddress = host
+	s.addressFamily = family
+}
+
+// peerAddr 同一主机上端口为port的节点的 host:port 地址
+func (s *Server) peerAddr(port int) string {
+	return net.JoinHostPort(protocol.LocalHost(s.listenAddress, s.addressFamily), strconv.Itoa(port))
+}
+
+// peerURL 同一主机上端口为port的节点的HTTP地址
+func (s *Server) peerURL(port int, path string) string {
+	return "http://" + s.peerAddr(port) + path
+}
+
+// SetUnixSocket 设置同时监听的Unix域套接字，供同机部署的负载均衡器绕过TCP连接（需在Start之前调用）
+func (s *Server) SetUnixSocket(path string) {
+	s.unixSocket = path
+}
+
+// SetKeepalive 设置心跳参数（需在Start之前调用）
+func (s *Server) SetKeepalive(pingInterval, pongTimeout time.Duration) {
+	if pingInterval > 0 {
+		s.pingInterval = pingInterval
+	}
+	if pongTimeout > 0 {
+		s.pongTimeout = pongTimeout
+	}
+}
+
+// SetWriteTimeout 设置向客户端写一帧的超时（需在Start之前调用）。客户端停止读取、TCP缓冲区写满后，
+// 写操作最多阻塞该时长，随后断开连接并计入 slow_consumer.write_timeouts
+func (s *Server) SetWriteTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		s.writeTimeout = timeout
+	}
+}
+
+// Start 启动服务器
+func (s *Server) Start() error {
+	s.startTime = time.Now()
+	// WebSocket 接口
+	switch s.connMode {
+	case ConnModeGorilla:
+		s.mux.HandleFunc("/ws", s.handleWebSocket)
+	case ConnModeEpoll:
+		if err := s.startPoller(); err != nil {
+			return fmt.Errorf("启动epoll连接模式失败: %w", err)
+		}
+		s.mux.HandleFunc("/ws", s.handleWebSocketEpoll)
+	default:
+		return fmt.Errorf("无效的连接模式: %s (可选: gorilla, epoll)", s.connMode)
+	}
+
+	go s.presenceLoop()
+	if s.memory.Limit > 0 {
+		go s.memoryGuard()
+	}
+	if s.slowConsumer.Enabled {
+		go s.slowConsumerMonitor()
+	}
+	if s.outbox.config.Enabled {
+		go s.outboxSweeper()
+	}
+	if s.history != nil && s.history.config.File != "" {
+		go s.historySaver()
+	}
+	if s.lifetime.MaxAge > 0 {
+		go s.lifetimeLoop()
+	}
+	if s.reconcile.Enabled {
+		go s.reconcileLoop()
+	}
+	s.applyFlags()
+	go s.flagWatcher()
+	if s.bus != nil {
+		s.bus.start()
+	}
+
+	// API 接口
+	s.mux.HandleFunc("/health", s.handleHealth)
+	s.mux.HandleFunc("/readyz", s.handleReadyz)
+	s.mux.HandleFunc("/api/clients", s.handleClientList)
+	s.mux.HandleFunc("/api/clients/", s.handleClientResource)
+	s.mux.HandleFunc("/api/global-clients", s.handleGlobalClientList)
+	s.mux.HandleFunc("/api/global-clients/export", registry.HandleExport) // NDJSON流式导出
+	s.mux.HandleFunc("/api/query", s.handleQuery)
+	s.mux.HandleFunc("/api/node-info", s.handleNodeInfo)
+	s.mux.HandleFunc("/api/send-command", s.idempotent(s.handleSendCommand))
+	s.mux.HandleFunc("/api/broadcast", s.idempotent(s.handleBroadcast))
+	s.mux.HandleFunc("/api/command-latency", s.handleCommandLatency)
+	s.mux.HandleFunc("/api/commands/", s.handleCommandRecord)
+	s.mux.HandleFunc("/api/emergency-stop", s.handleEmergencyStop)
+	s.mux.HandleFunc("/api/metrics", s.handleMetrics)
+	s.mux.HandleFunc("/api/latency", s.handleLatency)
+	s.mux.HandleFunc("/api/publish", s.handlePublish)
+	s.mux.HandleFunc("/api/topics", s.handleTopics)
+	s.mux.HandleFunc("/api/bus", s.handleBus)
+	s.mux.HandleFunc("/api/annotations", registry.HandleAnnotations)
+	s.mux.HandleFunc("/api/flags", s.handleFlags)
+	s.mux.HandleFunc("/api/flags/", s.handleFlags)
+	s.mux.HandleFunc("/api/client-aliases", s.handleClientAliases)
+	s.mux.HandleFunc("/api/client-aliases/", s.handleClientAliases)
+	if handler := auth.TokenHandler(s.auth); handler != nil {
+		s.mux.HandleFunc("/api/token", handler)
+	}
+	s.httpServer.Handler = s.origins.Wrap(s.mux)
+
+	log.Printf("WebSocket服务器节点 %s 启动在端口 %d", s.nodeID, s.port)
+	if s.unixSocket != "" {
+		listener, err := protocol.ListenUnix(s.unixSocket)
+		if err != nil {
+			return fmt.Errorf("监听Unix域套接字失败: %w", err)
+		}
+		log.Printf("节点 %s 同时监听Unix域套接字 %s", s.nodeID, listener.Addr())
+		go func() {
+			if err := s.httpServer.Serve(listener); err != http.ErrServerClosed {
+				log.Printf("Unix域套接字监听出错: %v", err)
+			}
+		}()
+	}
+	listener, err := protocol.Listen(s.addressFamily, s.listenAddress, s.port)
+	if err != nil {
+		return err
+	}
+	log.Printf("节点 %s 监听 %s", s.nodeID, listener.Addr())
+	if s.registrar != nil {
+		if err := s.registrar.start(); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+	s.unregisterMetrics = tracing.RegisterMetrics(s.otlpMetrics)
+	s.unwatchRegistry = registry.OnRecovered(s.reconcileRegistry)
+	close(s.ready)
+	if err := s.httpServer.Serve(s.forwarded.Listen(listener)); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown 优雅关闭：停止接收新连接，向客户端发送关闭帧并等待其断开，
+// ctx 到期后强制关闭剩余连接
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.StopAccepting()
+	if s.registrar != nil {
+		s.registrar.deregister(ctx)
+	}
+	err := s.httpServer.Shutdown(ctx)
+	if s.unregisterMetrics != nil {
+		s.unregisterMetrics()
+	}
+	if s.unwatchRegistry != nil {
+		s.unwatchRegistry()
+	}
+	defer s.stopPoller()
+	if s.bus != nil {
+		defer s.bus.stop()
+	}
+	defer s.saveHistory()
+	defer s.closeCommandStore()
+	defer s.deliveries.stop()
+
+	// 通知所有客户端服务器即将关闭
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "服务器关闭")
+	s.clientsMu.RLock()
+	for _, client := range s.clients {
+		client.writer.Flush()
+		client.Connection.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+	}
+	s.clientsMu.RUnlock()
+
+	// 等待客户端断开
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for s.GetClientCount() > 0 {
+		select {
+		case <-ctx.Done():
+			s.clientsMu.RLock()
+			remaining := make([]*ClientInfo, 0, len(s.clients))
+			for _, client := range s.clients {
+				remaining = append(remaining, client)
+			}
+			s.clientsMu.RUnlock()
+			log.Printf("节点 %s 排空超时，强制关闭 %d 个连接", s.nodeID, len(remaining))
+			for _, client := range remaining {
+				client.Connection.Close()
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return err
+}
+
+// handleWebSocket 处理WebSocket连接
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		http.Error(w, "服务器正在关闭", http.StatusServiceUnavailable)
+		return
+	}
+	if isHealthProbe(r) {
+		s.serveHealthProbe(w, r)
+		return
+	}
+	claims, header, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	version, header, ok := s.negotiateVersion(w, r, header)
+	if !ok {
+		return
+	}
+	if !s.admit(w, r) {
+		return
+	}
+	defer s.release()
+
+	// 追踪从升级到客户端完成注册的过程，负载均衡器转发时请求头带有上游的追踪上下文
+	span := tracing.Start("websocket.upgrade", tracing.KindServer, tracing.Extract(r.Header))
+	defer span.End()
+	span.SetAttribute("node.id", s.nodeID)
+	span.SetAttribute("net.peer.addr", r.RemoteAddr)
+	span.SetAttribute("client.address", s.clientAddr(r))
+
+	codec, header := negotiateCodec(r, header)
+	// 是否协商压缩由功能开关 compression 决定，只影响新连接
+	upgrader := s.upgrader
+	upgrader.EnableCompression = s.FeatureEnabled(FlagCompression)
+	conn, err := upgrader.Upgrade(w, r, header)
+	if err != nil {
+		span.SetError(err)
+		log.Printf("WebSocket升级失败: %v", err)
+		return
+	}
+	defer conn.Close()
+	if upgrader.EnableCompression && s.compressionLevel != 0 {
+		conn.SetCompressionLevel(s.compressionLevel)
+	}
+	s.applyReadLimit(conn)
+	var writer wsConn = conn
+	if codec != nil {
+		writer = &codecConn{Conn: conn, codec: codec}
+	}
+	writer = s.wrapMiddleware(writer)
+
+	// 等待客户端注册消息
+	var regMsg map[string]interface{}
+	messageType, data, err := conn.ReadMessage()
+	if err == nil {
+		data, err = decodeFrame(codec, messageType, data)
+	}
+	if err == nil {
+		var ok bool
+		if data, ok = s.inbound("", data); !ok {
+			err = errors.New("注册消息被中间件丢弃")
+		}
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &regMsg)
+	}
+	if err != nil {
+		span.SetError(err)
+		if errors.Is(err, websocket.ErrReadLimit) {
+			s.recordTooLarge(s.clientAddr(r))
+		} else {
+			log.Printf("读取注册消息失败: %v", err)
+		}
+		return
+	}
+
+	clientInfo, err := s.registerClient(writer, regMsg, claims, version, s.clientAddr(r))
+	if err != nil {
+		span.SetError(err)
+		return
+	}
+	clientID := clientInfo.ID
+	setMiddlewareClient(writer, clientID)
+	span.SetAttribute("client.id", clientID)
+	span.End()
+
+	// 清理客户端连接
+	defer s.unregisterClient(clientInfo)
+
+	// 心跳检测：收到任何消息或pong都会延长读超时，超时未响应的连接会被关闭
+	readTimeout := s.pingInterval + s.pongTimeout
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+	conn.SetPongHandler(func(appData string) error {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		clientInfo.latency.observePong(appData)
+		s.touch(clientInfo, true)
+		return nil
+	})
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.keepalive(conn, clientID, done)
+
+	// 处理消息
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			if netErr, ok := err.(interface{ Timeout() bool }); ok && netErr.Timeout() {
+				log.Printf("客户端 %s 心跳超时，关闭连接", clientID)
+			} else if errors.Is(err, websocket.ErrReadLimit) {
+				s.recordTooLarge(clientID)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("WebSocket读取错误: %v", err)
+			}
+			break
+		}
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		if data, err = decodeFrame(codec, messageType, data); err != nil {
+			log.Printf("客户端 %s 发送了无效的%s消息: %v", clientID, codec.Name(), err)
+			continue
+		}
+		var ok bool
+		if data, ok = s.inbound(clientID, data); !ok {
+			continue
+		}
+
+		if err := s.handleClientMessage(clientInfo, data); err != nil {
+			break
+		}
+	}
+}
+
+// 负载均衡器WebSocket健康探测的最长连接时间
+const healthProbeTimeout = 10 * time.Second
+
+// isHealthProbe 是否为负载均衡器的WebSocket健康探测连接
+func isHealthProbe(r *http.Request) bool {
+	return r.URL.Query().Get("health_probe") != ""
+}
+
+// serveHealthProbe 处理健康探测连接：只响应ping，不认证也不注册为客户端。
+// 任何人都能带上 health_probe 参数，探测连接同样占用连接名额，满员或紧急停止时拒绝
+func (s *Server) serveHealthProbe(w http.ResponseWriter, r *http.Request) {
+	if !s.admit(w, r) {
+		return
+	}
+	defer s.release()
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// ping由默认处理器回复pong，探测方关闭连接或超时后结束
+	conn.SetReadDeadline(time.Now().Add(healthProbeTimeout))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// registerClient 根据注册消息创建客户端信息并加入本节点和全局客户端列表
+// 启用认证时，认证方式先校验注册消息（失败时回复 unauthorized 错误），注册阶段返回的身份替换握手时的身份；
+// 注册消息未提供的ID和名称取自令牌的 sub/name 声明，令牌的 namespace 声明优先于注册消息。
+// 连接令牌绑定了客户端ID，注册消息中的 client_id 与之不同时回复 client_id_mismatch 错误；
+// 命名空间无效时回复 invalid_namespace 错误。出错时返回错误，调用方应关闭连接。version 为握手时协商的协议版本，
+// remoteAddr 为真实客户端地址
+func (s *Server) registerClient(conn wsConn, regMsg map[string]interface{}, claims *auth.Claims, version int, remoteAddr string) (*ClientInfo, error) {
+	if err := protocol.ValidateRegistration(regMsg); err != nil {
+		s.messageMetrics.invalid.Add(1)
+		log.Printf("拒绝客户端注册: 无效的注册消息: %v", err)
+		rejectRegistration(conn, "invalid_message", http.StatusBadRequest, fmt.Errorf("无效的注册消息: %v", err))
+		return nil, err
+	}
+	clientID, _ := regMsg["client_id"].(string)
+	requestedID := clientID
+	clientName, _ := regMsg["client_name"].(string)
+	namespace, _ := regMsg["namespace"].(string)
+	acceptBatch, _ := regMsg["accept_batch"].(bool)
+	acks, _ := regMsg["ack"].(bool)
+
+	if s.auth != nil {
+		registered, err := s.auth.AuthenticateRegistration(regMsg)
+		if err != nil {
+			log.Printf("拒绝客户端 %s 注册: %v", clientID, err)
+			rejectRegistration(conn, "unauthorized", http.StatusUnauthorized, err)
+			return nil, err
+		}
+		if registered != nil {
+			claims = registered
+		}
+	}
+	if claims != nil && claims.Connection && clientID != "" && clientID != claims.Subject {
+		err := fmt.Errorf("连接令牌属于客户端 %s，不能用于注册 %s", claims.Subject, clientID)
+		log.Printf("拒绝客户端 %s 注册: %v", clientID, err)
+		rejectRegistration(conn, "client_id_mismatch", http.StatusForbidden, err)
+		return nil, err
+	}
+	if claims != nil {
+		if clientID == "" {
+			clientID = claims.Subject
+		}
+		if clientName == "" {
+			clientName = claims.Name
+		}
+		if claims.Namespace != "" {
+			namespace = claims.Namespace
+		}
+	}
+	namespace, err := registry.NormalizeNamespace(namespace)
+	if err != nil {
+		log.Printf("拒绝客户端 %s 注册: %v", clientID, err)
+		rejectRegistration(conn, "invalid_namespace", http.StatusBadRequest, err)
+		return nil, err
+	}
+	labels, err := registry.ParseLabels(regMsg["labels"])
+	if err != nil {
+		log.Printf("拒绝客户端 %s 注册: %v", clientID, err)
+		rejectRegistration(conn, "invalid_labels", http.StatusBadRequest, err)
+		return nil, err
+	}
+	affinity, _ := regMsg["affinity"].(string)
+	if affinity != "" {
+		selector, err := registry.ParseSelector(affinity)
+		if err != nil {
+			err = fmt.Errorf("无效的节点亲和性: %v", err)
+			log.Printf("拒绝客户端 %s 注册: %v", clientID, err)
+			rejectRegistration(conn, "invalid_affinity", http.StatusBadRequest, err)
+			return nil, err
+		}
+		if !selector.Matches(s.labels) {
+			logging.Debugf("客户端 %s 的节点亲和性 %q 与节点 %s 的标签 %v 不符（没有满足条件的可用节点，或负载均衡器未使用 affinity 策略）",
+				clientID, affinity, s.nodeID, s.labels)
+		}
+	}
+	if err := s.rejectIfRegistryUnavailable(conn, clientID); err != nil {
+		return nil, err
+	}
+	var legacyID string
+	if clientID, legacyID, err = s.assignClientID(requestedID, clientID, claims); err != nil {
+		log.Printf("拒绝客户端 %s 注册: %v", requestedID, err)
+		rejectRegistration(conn, "invalid_client_id", http.StatusBadRequest, err)
+		return nil, err
+	}
+	// 运维集中分配过名称的客户端使用分配的名称
+	clientName = registry.ResolveName(clientID, clientName)
+	if clientName == "" {
+		clientName = "客户端_" + clientIDSuffix(clientID, 4)
+	}
+
+	// 创建客户端信息
+	clientInfo := &ClientInfo{
+		ID:              clientID,
+		Name:            clientName,
+		Namespace:       namespace,
+		ConnTime:        time.Now(),
+		LastSeen:        time.Now(),
+		IsActive:        true,
+		Status:          registry.StatusOnline,
+		Connection:      conn,
+		Encoding:        connEncoding(conn),
+		ProtocolVersion: version,
+		RemoteAddr:      remoteAddr,
+		Capabilities:    registry.ParseCapabilities(regMsg["capabilities"]),
+		Labels:          labels,
+		Affinity:        affinity,
+		latency:         newLatencyTracker(),
+		presence:        newPresenceState(),
+		acks:            acks,
+	}
+	clientInfo.lifetime = s.newConnLifetime(clientInfo.ConnTime)
+	if claims != nil {
+		clientInfo.Subject = claims.Subject
+		clientInfo.Claims = claims.Raw
+	}
+	batch := s.batch
+	batch.Enabled = batch.Enabled && acceptBatch
+	clientInfo.history = s.history.forClient(clientID)
+	clientInfo.writer = newConnWriter(conn, batch)
+	clientInfo.writer.history = clientInfo.history
+	clientInfo.writer.sent = &s.messageMetrics.sent
+	clientInfo.writer.clientID = clientID
+	clientInfo.writer.timeout = s.writeTimeout
+	clientInfo.writer.metrics = &s.slowConsumerMetrics
+	if s.slowConsumer.Enabled {
+		clientInfo.writer.queue = newSendQueue(conn, clientID, s.slowConsumer, &s.slowConsumerMetrics, s.writeTimeout)
+	}
+	if s.quota.enabled() {
+		clientInfo.quota = newQuotaTracker(s.quota)
+	}
+	if s.rateLimit.MessagesPerSecond > 0 {
+		clientInfo.limiter = newRateLimiter(s.rateLimit)
+	}
+	if s.commandConcurrency.MaxInFlight > 0 {
+		clientInfo.commands = newCommandSlots()
+	}
+
+	// 添加客户端连接
+	s.clientsMu.Lock()
+	s.clients[clientID] = clientInfo
+	s.clientsMu.Unlock()
+
+	// 注册到全局客户端列表
+	registry.Register(clientID, clientName, namespace, s.nodeID, s.port, clientInfo.Capabilities, labels, affinity)
+	s.bus.announceOnline(clientID)
+
+	log.Printf("客户端 %s (%s, %s) 连接到节点 %s，命名空间 %s，当前连接数: %d",
+		clientName, clientID, remoteAddr, s.nodeID, namespace, s.GetClientCount())
+	if legacyID != "" {
+		log.Printf("客户端以旧ID %s 注册，使用映射到的ID %s", legacyID, clientID)
+	}
+	s.notifyClientID(clientInfo, requestedID, legacyID)
+	s.redeliver(clientInfo)
+	s.deliverOutbox(clientInfo)
+	return clientInfo, nil
+}
+
+// rejectRegistration 回复注册失败的协议错误
+func rejectRegistration(conn wsConn, code string, status int, err error) {
+	conn.WriteJSON(map[string]interface{}{
+		"type":      "error",
+		"code":      code,
+		"status":    status,
+		"message":   err.Error(),
+		"timestamp": time.Now().Unix(),
+	})
+}
+
+// unregisterClient 客户端断开后清理
+func (s *Server) unregisterClient(clientInfo *ClientInfo) {
+	clientInfo.writer.Close()
+	s.clientsMu.Lock()
+	delete(s.clients, clientInfo.ID)
+	s.clientsMu.Unlock()
+	s.topics.removeClient(clientInfo.ID)
+	if clientInfo.commands != nil {
+		clientInfo.commands.close(clientInfo.ID)
+	}
+
+	// 从全局客户端列表注销
+	registry.Unregister(clientInfo.ID)
+	s.bus.announceOffline(clientInfo.ID)
+
+	log.Printf("客户端 %s 断开连接，节点 %s 剩余连接数: %d",
+		clientInfo.Name, s.nodeID, s.GetClientCount())
+}
+
+// handleClientMessage 处理客户端发来的一条消息，返回错误表示连接应被关闭
+func (s *Server) handleClientMessage(clientInfo *ClientInfo, data []byte) error {
+	clientID := clientInfo.ID
+	s.touch(clientInfo, false)
+	clientInfo.history.recordIn(data)
+	s.messageMetrics.received.Add(1)
+	s.messageMetrics.receivedBytes.Add(int64(len(data)))
+
+	// 令牌桶限流：超出速率的消息直接丢弃，持续超限时断开连接
+	if clientInfo.limiter != nil {
+		if allowed, err := s.checkRateLimit(clientInfo); !allowed {
+			return err
+		}
+	}
+
+	// 配额检查：接近上限时发送警告，超出上限的消息直接丢弃
+	if clientInfo.quota != nil && !s.checkQuota(clientInfo, len(data)) {
+		return nil
+	}
+
+	var rawMsg map[string]interface{}
+	if err := json.Unmarshal(data, &rawMsg); err != nil || rawMsg == nil {
+		return s.rejectMessage(clientInfo, nil, errors.New("消息必须是JSON对象"))
+	}
+
+	// 按消息模式校验字段，不符合时回复400而不是丢弃
+	schema, err := protocol.ValidateMessage(rawMsg)
+	if err != nil {
+		return s.rejectMessage(clientInfo, rawMsg, err)
+	}
+
+	switch schema {
+	case protocol.TypeHeartbeat:
+		return s.handleHeartbeat(clientInfo, rawMsg)
+	case "command_response":
+		// 处理客户端指令响应
+		s.handleCommandResponse(clientID, rawMsg)
+	case protocol.TypeAck:
+		s.handleAck(clientID, rawMsg)
+	case protocol.TypeNack:
+		s.handleNack(clientID, rawMsg)
+	case protocol.TypeSubscribe, protocol.TypeUnsubscribe, protocol.TypePublish:
+		return s.handlePubSubMessage(clientInfo, data)
+	case protocol.SchemaRequest:
+		// protocol.Message 格式的请求，响应按ID回给客户端
+		return s.handleRequestMessage(clientInfo, data)
+	}
+	return nil
+}
+
+// handleRequestMessage 处理method/path格式的请求并回复带相同ID的响应
+func (s *Server) handleRequestMessage(clientInfo *ClientInfo, data []byte) error {
+	var msg protocol.Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		log.Printf("收到无效的请求消息: %v", err)
+		return nil
+	}
+	logging.Debugf("节点 %s 收到消息: %s %s", s.nodeID, msg.Method, msg.Path)
+	response := s.handleMessage(&msg)
+	if err := clientInfo.writer.WriteJSON(response); err != nil {
+		log.Printf("发送响应失败: %v", err)
+		return err
+	}
+	return nil
+}
+
+// checkQuota 记录消息的配额消耗并发送警告，返回false表示消息超出配额应被丢弃
+func (s *Server) checkQuota(client *ClientInfo, size int) bool {
+	warnings, exceeded := client.quota.record(size)
+	resetAt := client.quota.windowReset().Unix()
+
+	for _, warning := range warnings {
+		log.Printf("⚠️ 客户端 %s 接近%s配额: %d/%d", client.ID, warning.Type, warning.Used, warning.Limit)
+		client.writer.WriteJSON(map[string]interface{}{
+			"type":      "quota_warning",
+			"quota":     warning.Type,
+			"used":      warning.Used,
+			"limit":     warning.Limit,
+			"reset_at":  resetAt,
+			"timestamp": time.Now().Unix(),
+		})
+	}
+
+	if exceeded != nil {
+		log.Printf("🚫 客户端 %s 超出%s配额，丢弃消息: %d/%d", client.ID, exceeded.Type, exceeded.Used, exceeded.Limit)
+		client.writer.WriteJSON(map[string]interface{}{
+			"type":      "quota_exceeded",
+			"quota":     exceeded.Type,
+			"used":      exceeded.Used,
+			"limit":     exceeded.Limit,
+			"reset_at":  resetAt,
+			"timestamp": time.Now().Unix(),
+		})
+		return false
+	}
+	return true
+}
+
+// keepalive 定期向客户端发送ping，直到连接处理结束
+func (s *Server) keepalive(conn *websocket.Conn, clientID string, done <-chan struct{}) {
+	ticker := time.NewTicker(s.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, pingPayload(), time.Now().Add(s.pongTimeout)); err != nil {
+				log.Printf("向客户端 %s 发送ping失败: %v", clientID, err)
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// handleMessage 处理WebSocket消息
+func (s *Server) handleMessage(msg *protocol.Message) *protocol.Response {
+	switch msg.Method {
+	case "GET":
+		return s.handleGet(msg)
+	case "POST":
+		return s.handlePost(msg)
+	case "PUT":
+		return s.handlePut(msg)
+	case "DELETE":
+		return s.handleDelete(msg)
+	default:
+		return protocol.NewResponse(msg.ID, 405, nil)
+	}
+}
+
+// handleGet 处理GET请求
+func (s *Server) handleGet(msg *protocol.Message) *protocol.Response {
+	path := strings.TrimPrefix(msg.Path, "/")
+
+	switch path {
+	case "info":
+		return protocol.NewResponse(msg.ID, 200, map[string]interface{}{
+			"node_id":   s.nodeID,
+			"port":      s.port,
+			"clients":   s.GetClientCount(),
+			"timestamp": time.Now().Unix(),
+		})
+	case "health":
+		return protocol.NewResponse(msg.ID, 200, map[string]string{
+			"status": "ok",
+			"node":   s.nodeID,
+		})
+	default:
+		return protocol.NewResponse(msg.ID, 404, map[string]string{
+			"error": "路径不存在",
+		})
+	}
+}
+
+// handlePost 处理POST请求
+func (s *Server) handlePost(msg *protocol.Message) *protocol.Response {
+	return protocol.NewResponse(msg.ID, 201, map[string]interface{}{
+		"message": "创建成功",
+		"node":    s.nodeID,
+		"data":    msg.Body,
+	})
+}
+
+// handlePut 处理PUT请求
+func (s *Server) handlePut(msg *protocol.Message) *protocol.Response {
+	return protocol.NewResponse(msg.ID, 200, map[string]interface{}{
+		"message": "更新成功",
+		"node":    s.nodeID,
+		"data":    msg.Body,
+	})
+}
+
+// handleDelete 处理DELETE请求
+func (s *Server) handleDelete(msg *protocol.Message) *protocol.Response {
+	return protocol.NewResponse(msg.ID, 200, map[string]interface{}{
+		"message": "删除成功",
+		"node":    s.nodeID,
+	})
+}
+
+// handleHealth 健康检查
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]interface{}{
+		"status":               "healthy",
+		"node_id":              s.nodeID,
+		"port":                 s.port,
+		"clients":              s.GetClientCount(),
+		"connections":          s.admitted.Load(),
+		"max_clients":          s.maxClients, // 0表示不限制，负载均衡器据此避开满载节点
+		"emergency_stop":       s.emergency.active.Load(),
+		"protocol_min_version": s.protocolVersions.Min, // 负载均衡器据此把客户端路由到兼容的节点
+		"protocol_max_version": s.protocolVersions.Max,
+		"build_version":        s.buildVersion, // 负载均衡器据此发现构建版本不一致的后端
+		"labels":               s.labels,       // 负载均衡器的 affinity 策略据此选择节点
+		"time":                 time.Now().Format(time.RFC3339),
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetClientCount 获取客户端连接数
+func (s *Server) GetClientCount() int {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+	return len(s.clients)
+}
+
+// handleClientList 处理客户端列表请求，?namespace= 只返回该命名空间的客户端
+func (s *Server) handleClientList(w http.ResponseWriter, r *http.Request) {
+	namespace := r.URL.Query().Get("namespace")
+	var selector registry.Selector
+	if v := r.URL.Query().Get("selector"); v != "" {
+		var err error
+		if selector, err = registry.ParseSelector(v); err != nil {
+			http.Error(w, "无效的标签选择器: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	var clients []ClientInfo
+	for _, client := range s.clients {
+		if namespace != "" && client.Namespace != namespace {
+			continue
+		}
+		if selector != nil && !selector.Matches(client.Labels) {
+			continue
+		}
+		clients = append(clients, s.clientSnapshot(client))
+	}
+
+	response := map[string]interface{}{
+		"node_id": s.nodeID,
+		"total":   len(clients),
+		"clients": clients,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// clientSnapshot 复制客户端记录并填入在线状态、配额、时延等实时统计（调用方持有clientsMu读锁）。
+// 共享的记录只在写锁下修改，并发的查询各自填充副本
+func (s *Server) clientSnapshot(client *ClientInfo) ClientInfo {
+	info := *client
+	info.LastSeen, info.IsActive, info.Status = client.presence.snapshot()
+	info.Annotation = registry.GetAnnotation(registry.AnnotationTargetClient, client.ID)
+	if client.quota != nil {
+		info.Quota = client.quota.snapshot()
+	}
+	info.Latency = client.latency.snapshot()
+	info.Topics = s.topics.topicsOf(client.ID)
+	if client.commands != nil {
+		info.Commands = client.commands.snapshot(s.commandConcurrency.MaxInFlight)
+	}
+	return info
+}
+
+// handleQuery 处理查询请求
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	clientID := r.URL.Query().Get("client_id")
+	if clientID == "" {
+		http.Error(w, "缺少client_id参数", http.StatusBadRequest)
+		return
+	}
+	clientID = s.resolveClientID(clientID)
+
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if client, exists := s.clients[clientID]; exists {
+		info := *client
+		info.LastSeen, info.IsActive, info.Status = client.presence.snapshot()
+		info.Annotation = registry.GetAnnotation(registry.AnnotationTargetClient, clientID)
+		response := map[string]interface{}{
+			"found":   true,
+			"node_id": s.nodeID,
+			"client":  info,
+		}
+		json.NewEncoder(w).Encode(response)
+	} else {
+		response := map[string]interface{}{
+			"found":   false,
+			"node_id": s.nodeID,
+			"message": "客户端不存在",
+		}
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// handleGlobalClientList 处理全局客户端列表请求，?namespace=、?selector= 只返回该命名空间、标签满足选择器的客户端；
+// 带 ?limit= 或 ?cursor= 时按客户端ID分页返回
+func (s *Server) handleGlobalClientList(w http.ResponseWriter, r *http.Request) {
+	if registry.IsPageRequest(r) {
+		registry.HandleClientPage(w, r, map[string]interface{}{"current_node": s.nodeID})
+		return
+	}
+	match, err := registry.RequestFilter(r)
+	if err != nil {
+		http.Error(w, "无效的标签选择器: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	globalClients := registry.All()
+
+	var clients []registry.ClientInfo
+	for _, client := range globalClients {
+		if match == nil || match(client) {
+			clients = append(clients, *client)
+		}
+	}
+
+	response := map[string]interface{}{
+		"current_node": s.nodeID,
+		"total":        len(clients),
+		"clients":      clients,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// commandRequest 发送指令请求；wait为true时同步等待客户端的command_response
+type commandRequest struct {
+	ClientID    string            `json:"client_id"`
+	Name        string            `json:"name,omitempty"`     // 未指定client_id时，按客户端名称的通配符选择目标
+	Selector    string            `json:"selector,omitempty"` // 未指定client_id时，按注册时声明的标签选择目标
+	Command     string            `json:"command"`
+	Data        interface{}       `json:"data"`
+	Wait        bool              `json:"wait"`
+	Timeout     protocol.Duration `json:"timeout"`               // 同步等待超时，默认10s，最长60s
+	Namespace   string            `json:"namespace,omitempty"`   // 发送方所在的命名空间，非空时只能向同一命名空间的客户端发送
+	Traceparent string            `json:"traceparent,omitempty"` // 追踪上下文，节点之间转发时携带；HTTP请求也可以用traceparent请求头传入
+}
+
+// handleSendCommand 处理向客户端发送指令
+func (s *Server) handleSendCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "仅支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req commandRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "请求格式错误", http.StatusBadRequest)
+		return
+	}
+
+	if req.Command == "" || (req.ClientID == "" && req.Name == "" && req.Selector == "") {
+		http.Error(w, "command为必填字段，并且需要指定client_id、name或selector", http.StatusBadRequest)
+		return
+	}
+	if req.ClientID != "" && (req.Name != "" || req.Selector != "") {
+		http.Error(w, "client_id不能与name或selector同时使用", http.StatusBadRequest)
+		return
+	}
+	// 未指定命名空间的请求属于默认命名空间，不能向其他命名空间的客户端发送指令
+	namespace, err := registry.NormalizeNamespace(req.Namespace)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Namespace = namespace
+
+	// 请求头中的追踪上下文优先，总线转发的请求在请求体中携带
+	parent := tracing.Extract(r.Header)
+	if !parent.IsValid() {
+		parent, _ = tracing.ParseTraceparent(req.Traceparent)
+	}
+	span := tracing.Start("send-command", tracing.KindServer, parent)
+	defer span.End()
+	span.SetAttribute("command", req.Command)
+	span.SetAttribute("client.id", req.ClientID)
+	span.SetAttribute("node.id", s.nodeID)
+	span.SetAttribute("command.wait", req.Wait)
+	req.Traceparent = span.Traceparent()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if req.ClientID == "" {
+		s.sendCommandToMatching(w, req, span)
+		return
+	}
+
+	// 查找目标客户端，以旧ID指定时换成映射到的ID
+	req.ClientID = s.resolveClientID(req.ClientID)
+	globalClient, exists := registry.Get(req.ClientID)
+	if !exists {
+		// 客户端暂时离线时存入离线队列，同步模式无法等待离线客户端的响应
+		if s.outbox.config.Enabled && !req.Wait {
+			span.SetAttribute("command.queued_offline", true)
+			s.queueOfflineCommand(w, req)
+			return
+		}
+		span.SetError(errors.New("客户端不存在"))
+		response := map[string]interface{}{
+			"success": false,
+			"error":   "客户端不存在",
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// 禁止跨命名空间发送指令
+	if !registry.SameNamespace(globalClient.NamespaceOf(), req.Namespace) {
+		span.SetError(errors.New("命名空间不匹配"))
+		log.Printf("拒绝向客户端 %s 发送指令 %s: 客户端属于命名空间 %s，请求的命名空间为 %s",
+			req.ClientID, req.Command, globalClient.NamespaceOf(), req.Namespace)
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"code":    "namespace_mismatch",
+			"error":   fmt.Sprintf("客户端 %s 不属于命名空间 %s", req.ClientID, req.Namespace),
+		})
+		return
+	}
+
+	// 客户端声明了能力时，拒绝其无法处理的指令
+	if err := globalClient.Capabilities.CheckCommand(req.Command, payloadSize(req.Data)); err != nil {
+		span.SetError(err)
+		log.Printf("拒绝向客户端 %s 发送指令 %s: %v", req.ClientID, req.Command, err)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":      false,
+			"code":         "unsupported_command",
+			"error":        err.Error(),
+			"capabilities": globalClient.Capabilities,
+		})
+		return
+	}
+
+	// 如果客户端在当前节点，直接发送
+	if globalClient.NodeID == s.nodeID {
+		if err := s.commandFeatureError(req.Command); err != nil {
+			span.SetError(err)
+			log.Printf("拒绝向客户端 %s 发送指令 %s: %v", req.ClientID, req.Command, err)
+			writeFeatureDisabled(w, s.nodeID, err)
+			return
+		}
+		if req.Wait {
+			s.sendCommandAndWait(w, req, span)
+			return
+		}
+		requestID := s.pendingCommands.newID(s.nodeID)
+		span.SetAttribute("request.id", requestID)
+		position, err := s.dispatchCommand(req.ClientID, req.Command, req.Data, requestID, span.Context())
+		span.SetError(err)
+		if err == errCommandQueueFull {
+			writeQueueFull(w, s.nodeID, req.ClientID)
+			return
+		}
+		response := map[string]interface{}{
+			"success":    err == nil,
+			"node":       s.nodeID,
+			"request_id": requestID, // 启用 command_log 时可通过 /api/commands/{request_id} 查询结果
+			"message": func() string {
+				if err != nil {
+					return "指令发送失败"
+				}
+				if position > 0 {
+					return fmt.Sprintf("客户端在途指令已达上限，指令已排队 (第%d位)", position)
+				}
+				return "指令已发送"
+			}(),
+		}
+		if position > 0 {
+			response["queued"] = true
+			response["queue_position"] = position
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// 如果客户端在其他节点，转发请求
+	status, body, err := s.forwardCommandToOtherNode(globalClient, req)
+	span.SetError(err)
+	if req.Wait && err == nil {
+		// 同步模式直接透传目标节点的响应
+		w.WriteHeader(status)
+		w.Write(body)
+		return
+	}
+	success := err == nil && status == http.StatusOK
+	response := map[string]interface{}{
+		"success": success,
+		"node":    globalClient.NodeID,
+		"message": func() string {
+			if success {
+				return fmt.Sprintf("指令已转发到节点 %s", globalClient.NodeID)
+			}
+			return fmt.Sprintf("转发到节点 %s 失败", globalClient.NodeID)
+		}(),
+	}
+	// 带回目标节点分配的request_id，用于之后查询指令记录
+	var forwarded struct {
+		RequestID string `json:"request_id"`
+	}
+	if success && json.Unmarshal(body, &forwarded) == nil && forwarded.RequestID != "" {
+		response["request_id"] = forwarded.RequestID
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// sendCommandAndWait 向本地客户端发送指令并等待其响应，将客户端的响应内容写入HTTP回复
+func (s *Server) sendCommandAndWait(w http.ResponseWriter, req commandRequest, span *tracing.Span) {
+	timeout := commandTimeout(req.Timeout)
+	requestID, ch := s.pendingCommands.add(s.nodeID)
+	span.SetAttribute("request.id", requestID)
+
+	position, err := s.dispatchCommand(req.ClientID, req.Command, req.Data, requestID, span.Context())
+	if err != nil {
+		span.SetError(err)
+		s.pendingCommands.remove(requestID)
+		if err == errCommandQueueFull {
+			writeQueueFull(w, s.nodeID, req.ClientID)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    false,
+			"node":       s.nodeID,
+			"request_id": requestID,
+			"message":    "指令发送失败",
+		})
+		return
+	}
+
+	response, ok := s.pendingCommands.wait(requestID, ch, timeout)
+	if !ok {
+		// 超时时仍在排队的指令不再发送
+		if position > 0 {
+			s.cancelQueuedCommand(req.ClientID, requestID)
+		}
+		log.Printf("等待客户端 %s 响应指令 %s 超时 (%v)", req.ClientID, req.Command, timeout)
+		span.SetError(errCommandResponseTimeout)
+		w.WriteHeader(http.StatusGatewayTimeout)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    false,
+			"node":       s.nodeID,
+			"request_id": requestID,
+			"message":    fmt.Sprintf("等待客户端响应超时 (%v)", timeout),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    response.Result == "success",
+		"node":       s.nodeID,
+		"request_id": requestID,
+		"message":    "已收到客户端响应",
+		"response":   response,
+	})
+}
+
+// sendCommandToLocalClient 向本地客户端发送指令，requestID非空时客户端会在响应中带回
+func (s *Server) sendCommandToLocalClient(clientID, command string, data interface{}, requestID string) bool {
+	s.clientsMu.RLock()
+	client, exists := s.clients[clientID]
+	s.clientsMu.RUnlock()
+
+	if !exists {
+		return false
+	}
+	return s.writeCommand(client, command, data, requestID)
+}
+
+// writeCommand 构造指令消息并写入客户端连接
+func (s *Server) writeCommand(client *ClientInfo, command string, data interface{}, requestID string) bool {
+	clientID := client.ID
+	if client.Connection == nil {
+		return false
+	}
+
+	// 构造指令消息
+	cmdMsg := map[string]interface{}{
+		"type":    "command",
+		"command": command,
+		"data":    data,
+		"from":    fmt.Sprintf("node-%s", s.nodeID),
+	}
+	if requestID != "" {
+		cmdMsg["request_id"] = requestID
+		// 客户端处理指令的span属于同一条trace
+		if traceparent := s.commandLatency.traceparent(requestID); traceparent != "" {
+			cmdMsg[tracing.Header] = traceparent
+		}
+	}
+	// 需要确认的指令带上 delivery_id，重发时不变，客户端据此去重
+	attempt := s.beginDelivery(client, command, data, requestID)
+	if attempt > 0 {
+		cmdMsg["delivery_id"] = requestID
+		cmdMsg["attempt"] = attempt
+	}
+
+	// 发送指令
+	err := client.writer.WriteJSON(cmdMsg)
+	if attempt > 0 {
+		s.endDelivery(requestID, attempt, err == nil)
+	}
+	if err != nil {
+		log.Printf("向客户端 %s 发送指令失败: %v", clientID, err)
+		return false
+	}
+
+	log.Printf("向客户端 %s 发送指令: %s", clientID, command)
+	return true
+}
+
+// Broadcast 向本节点所有客户端广播消息，消息只序列化一次，
+// 并通过PreparedMessage让WebSocket帧编码和压缩也只进行一次
+func (s *Server) Broadcast(msg interface{}) (sent, failed int) {
+	sent, failed, _ = s.broadcast(msg, nil)
+	return sent, failed
+}
+
+// broadcast 向本节点的客户端广播消息，accept非nil时跳过其返回false的客户端
+func (s *Server) broadcast(msg interface{}, accept func(*ClientInfo) bool) (sent, failed, skipped int) {
+	start := time.Now()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("序列化广播消息失败: %v", err)
+		return 0, 0, 0
+	}
+	pm, err := websocket.NewPreparedMessage(websocket.TextMessage, data)
+	if err != nil {
+		log.Printf("创建广播消息失败: %v", err)
+		return 0, 0, 0
+	}
+
+	s.clientsMu.RLock()
+	clients := make([]*ClientInfo, 0, len(s.clients))
+	for _, client := range s.clients {
+		if accept != nil && !accept(client) {
+			skipped++
+			continue
+		}
+		clients = append(clients, client)
+	}
+	s.clientsMu.RUnlock()
+
+	for _, client := range clients {
+		prepared, err := client.writer.WritePrepared(pm, data)
+		if err != nil {
+			log.Printf("向客户端 %s 广播失败: %v", client.ID, err)
+			failed++
+			continue
+		}
+		if prepared {
+			s.broadcastMetrics.preparedWrites.Add(1)
+		} else {
+			s.broadcastMetrics.batchedWrites.Add(1)
+		}
+		sent++
+	}
+
+	s.broadcastMetrics.record(len(data), sent, failed, time.Since(start))
+	return sent, failed, skipped
+}
+
+// handleBroadcast 向本节点所有客户端广播指令
+func (s *Server) handleBroadcast(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "仅支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Command   string      `json:"command"`
+		Data      interface{} `json:"data"`
+		AllNodes  bool        `json:"all_nodes"` // 同时广播到其他节点的客户端
+		Namespace string      `json:"namespace"` // 只广播给该命名空间的客户端，为空表示默认命名空间
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "请求格式错误", http.StatusBadRequest)
+		return
+	}
+	if req.Command == "" {
+		http.Error(w, "command为必填字段", http.StatusBadRequest)
+		return
+	}
+	namespace, err := registry.NormalizeNamespace(req.Namespace)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Namespace = namespace
+
+	sent, failed, skipped := s.broadcastCommand(req.Command, req.Data, req.Namespace)
+	log.Printf("节点 %s 广播指令 %s: 成功 %d, 失败 %d, 不支持或不在命名空间内而跳过 %d", s.nodeID, req.Command, sent, failed, skipped)
+
+	response := map[string]interface{}{
+		"success": failed == 0,
+		"node":    s.nodeID,
+		"sent":    sent,
+		"failed":  failed,
+		"skipped": skipped,
+	}
+	if req.AllNodes {
+		response["forwarded_to"] = s.forwardBroadcast(req.Command, req.Data, req.Namespace)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// broadcastCommand 向本节点客户端广播指令，跳过声明了能力但无法处理该指令的客户端，
+// 以及其他命名空间的客户端（namespace为空时为默认命名空间）；指令类别被功能开关关闭时全部跳过
+func (s *Server) broadcastCommand(command string, data interface{}, namespace string) (sent, failed, skipped int) {
+	size := payloadSize(data)
+	disabled := s.commandFeatureError(command) != nil
+	return s.broadcast(map[string]interface{}{
+		"type":    "command",
+		"command": command,
+		"data":    data,
+		"from":    fmt.Sprintf("node-%s", s.nodeID),
+	}, func(client *ClientInfo) bool {
+		if disabled || !registry.SameNamespace(client.Namespace, namespace) {
+			return false
+		}
+		return client.Capabilities.CheckCommand(command, size) == nil
+	})
+}
+
+// forwardBroadcast 将广播转发到其他节点，优先使用节点总线，返回转发成功的节点
+func (s *Server) forwardBroadcast(command string, data interface{}, namespace string) []string {
+	reached := make(map[string]bool)
+	if s.bus != nil {
+		reached = s.bus.sendAll(busBroadcast, busBroadcastRequest{Command: command, Data: data, Namespace: namespace})
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"command": command, "data": data, "namespace": namespace})
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	for nodeID, port := range s.peerNodes() {
+		if reached[nodeID] {
+			continue
+		}
+		resp, err := httpClient.Post(s.peerURL(port, "/api/broadcast"), "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("转发广播到节点 %s 失败: %v", nodeID, err)
+			continue
+		}
+		resp.Body.Close()
+		reached[nodeID] = true
+	}
+
+	nodes := make([]string, 0, len(reached))
+	for nodeID := range reached {
+		nodes = append(nodes, nodeID)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// handleMetrics 节点运行指标
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	// top 指定返回的内存消耗最大的连接数，默认10
+	top := 10
+	if v, err := strconv.Atoi(r.URL.Query().Get("top")); err == nil && v >= 0 {
+		top = v
+	}
+
+	nodeLatency, _ := s.latencyStats()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"node_id":              s.nodeID,
+		"clients":              s.GetClientCount(),
+		"broadcast":            s.broadcastMetrics.Snapshot(),
+		"memory":               s.MemoryStats(top),
+		"slow_consumer":        s.slowConsumerStats(),
+		"admission":            s.admissionStats(),
+		"rate_limit":           s.rateLimitStats(),
+		"messages":             s.messageStats(),
+		"latency":              nodeLatency,
+		"commands":             s.commandConcurrencyStats(),
+		"bus":                  s.busStats(),
+		"idempotency":          s.idempotencyStats(),
+		"outbox":               s.outboxStats(),
+		"delivery":             s.deliveryStats(),
+		"history":              s.historyStats(),
+		"command_history":      s.commandHistoryStats(),
+		"command_latency":      s.commandLatencyReport(""),
+		"tracing":              tracing.Stats(),
+		"emergency_stop":       s.EmergencyStatus(),
+		"protocol_versions":    s.versionStats(),
+		"connection_lifetime":  s.lifetimeStats(),
+		"registry_persistence": registry.PersistStats(),
+		"registry_reconcile":   s.reconcileStats(),
+		"client_ids":           s.clientIDStats(),
+	})
+}
+
+// payloadSize 指令数据序列化后的字节数
+func payloadSize(data interface{}) int {
+	if data == nil {
+		return 0
+	}
+	encoded, _ := json.Marshal(data)
+	return len(encoded)
+}
+
+// forwardCommandToOtherNode 将指令转发到其他节点，返回目标节点的HTTP状态码和响应内容
+func (s *Server) forwardCommandToOtherNode(targetClient *registry.ClientInfo, req commandRequest) (status int, body []byte, err error) {
+	parent, _ := tracing.ParseTraceparent(req.Traceparent)
+	span := tracing.Start("command.forward", tracing.KindClient, parent)
+	defer func() {
+		span.SetAttribute("http.status_code", status)
+		span.SetError(err)
+		span.End()
+	}()
+	span.SetAttribute("command", req.Command)
+	span.SetAttribute("client.id", targetClient.ID)
+	span.SetAttribute("target.node.id", targetClient.NodeID)
+	if traceparent := span.Traceparent(); traceparent != "" {
+		req.Traceparent = traceparent
+	}
+
+	// 构造转发请求
+	req.ClientID = targetClient.ID
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		log.Printf("构造转发请求失败: %v", err)
+		return 0, nil, err
+	}
+
+	// 同步模式需要等待目标节点收到客户端响应
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	if req.Wait {
+		httpClient.Timeout = commandTimeout(req.Timeout) + 5*time.Second
+	}
+
+	// 优先通过节点总线转发，没有总线连接时回退到HTTP
+	if s.bus != nil {
+		timeout := time.Duration(s.bus.cfg.RequestTimeout)
+		if req.Wait {
+			timeout = httpClient.Timeout
+		}
+		reply, err := s.bus.request(targetClient.NodeID, busCommand, req, timeout)
+		if err == nil {
+			span.SetAttribute("transport", "bus")
+			return reply.Status, reply.Payload, nil
+		}
+		if err != errNoBusLink {
+			log.Printf("通过总线转发指令到节点 %s 失败: %v", targetClient.NodeID, err)
+			return 0, nil, err
+		}
+	}
+
+	// 发送HTTP请求到目标节点
+	span.SetAttribute("transport", "http")
+	targetURL := s.peerURL(targetClient.NodePort, "/api/send-command")
+	httpReq, err := http.NewRequest(http.MethodPost, targetURL, bytes.NewReader(reqBody))
+	if err != nil {
+		return 0, nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	tracing.Inject(httpReq.Header, span.Context())
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		log.Printf("转发指令到节点 %s:%d 失败: %v", targetClient.NodeID, targetClient.NodePort, err)
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, body, nil
+}
+
+// handleCommandResponse 处理客户端指令响应
+func (s *Server) handleCommandResponse(clientID string, response map[string]interface{}) {
+	result, _ := response["result"].(string)
+	message, _ := response["message"].(string)
+	data := response["data"]
+	timestamp, _ := response["timestamp"].(float64)
+
+	log.Printf("📨 收到客户端 %s 的指令响应: %s - %s", clientID, result, message)
+
+	// 带request_id的响应：记录时延，释放并发名额，同步指令交给等待中的HTTP请求
+	if requestID, _ := response["request_id"].(string); requestID != "" {
+		tracked := s.commandLatency.respond(requestID, result)
+		// 响应同时确认收到了指令
+		s.ackDelivery(clientID, requestID, DeliveryResponded)
+		s.recordCommandResponse(clientID, requestID, result, message, data)
+
+		// 指令完成，释放并发名额并发送排队的指令
+		s.clientsMu.RLock()
+		client, exists := s.clients[clientID]
+		s.clientsMu.RUnlock()
+		released := false
+		if exists {
+			released = s.releaseCommandSlot(client, requestID)
+		}
+
+		// 异步指令为占用并发名额也会带request_id，没有等待者属正常情况
+		if !s.pendingCommands.resolve(requestID, CommandResponse{
+			Result:    result,
+			Message:   message,
+			Data:      data,
+			Timestamp: int64(timestamp),
+		}) && !released && !tracked {
+			log.Printf("指令响应 %s 没有等待者（可能已超时）", requestID)
+		}
+	}
+
+	if result == "success" {
+		log.Printf("✅ 客户端 %s 成功执行指令: %s", clientID, message)
+	} else {
+		log.Printf("❌ 客户端 %s 执行指令失败: %s", clientID, message)
+	}
+
+	// 如果有响应数据，也可以记录下来
+	if data != nil {
+		if dataJSON, err := json.MarshalIndent(data, "", "  "); err == nil {
+			log.Printf("📄 响应数据: %s", string(dataJSON))
+		}
+	}
+
+	log.Printf("⏰ 响应时间: %v", time.Unix(int64(timestamp), 0).Format("2006-01-02 15:04:05"))
+}
@@ -0,0 +1,366 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+)
+
+// 慢消费者处理策略
+const (
+	SlowPolicyDrop       = "drop"       // 丢弃非关键消息（广播），指令等关键消息照常排队
+	SlowPolicySummary    = "summary"    // 非关键消息合并为摘要，恢复后发送一条 summary 消息
+	SlowPolicyDisconnect = "disconnect" // 以关闭码1008断开连接
+)
+
+var errSendQueueFull = errors.New("发送队列已满")
+
+// SlowConsumerConfig 慢消费者检测配置
+// 启用后每个连接使用有界发送队列和独立的发送协程，读取过慢的客户端不再阻塞广播和指令发送
+type SlowConsumerConfig struct {
+	Enabled   bool              `json:"enabled" yaml:"enabled"`
+	QueueSize int               `json:"queue_size" yaml:"queue_size"` // 每个连接的发送队列长度
+	Threshold protocol.Duration `json:"threshold" yaml:"threshold"`   // 队列持续满载超过该时长判定为慢消费者
+	Policy    string            `json:"policy" yaml:"policy"`         // drop, summary, disconnect
+}
+
+// Validate 校验慢消费者配置
+func (c SlowConsumerConfig) Validate() error {
+	switch c.Policy {
+	case "", SlowPolicyDrop, SlowPolicySummary, SlowPolicyDisconnect:
+	default:
+		return fmt.Errorf("无效的慢消费者策略: %s (可选: drop, summary, disconnect)", c.Policy)
+	}
+	if c.QueueSize < 0 {
+		return fmt.Errorf("slow_consumer.queue_size 不能为负数")
+	}
+	return nil
+}
+
+// SlowConsumerMetrics 慢消费者统计
+type SlowConsumerMetrics struct {
+	detected      atomic.Int64 // 判定为慢消费者的次数
+	recovered     atomic.Int64 // 恢复正常的次数
+	dropped       atomic.Int64 // 丢弃的非关键消息数
+	summarized    atomic.Int64 // 合并进摘要的消息数
+	disconnected  atomic.Int64 // 因读取过慢被断开的连接数
+	rejected      atomic.Int64 // 队列持续满载、等待超过阈值仍无法入队的关键消息数（发送方收到错误）
+	writeTimeouts atomic.Int64 // 写超时后被断开的连接数
+}
+
+// Snapshot 导出统计数据
+func (m *SlowConsumerMetrics) Snapshot() map[string]interface{} {
+	return map[string]interface{}{
+		"detected":       m.detected.Load(),
+		"recovered":      m.recovered.Load(),
+		"dropped":        m.dropped.Load(),
+		"summarized":     m.summarized.Load(),
+		"disconnected":   m.disconnected.Load(),
+		"rejected":       m.rejected.Load(),
+		"write_timeouts": m.writeTimeouts.Load(),
+	}
+}
+
+// writeDeadliner 支持写超时的连接：gorilla连接及包装它的二进制编码、中间件连接。
+// epoll模式的pollConn每帧自带写超时，不需要单独设置
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// setWriteDeadline 写数据帧前设置写超时，客户端停止读取时写操作最多阻塞timeout，timeout为0时不限制
+func setWriteDeadline(conn wsConn, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	if d, ok := conn.(writeDeadliner); ok {
+		d.SetWriteDeadline(time.Now().Add(timeout))
+	}
+}
+
+// checkWriteTimeout 写超时后连接已不可用（gorilla连接写出部分帧后无法继续），
+// 关闭连接使读循环退出并注销客户端，不再让后续发送者阻塞在同一个连接上
+func checkWriteTimeout(conn wsConn, clientID string, err error, metrics *SlowConsumerMetrics) error {
+	var netErr net.Error
+	if err == nil || !errors.As(err, &netErr) || !netErr.Timeout() {
+		return err
+	}
+	metrics.writeTimeouts.Add(1)
+	log.Printf("🐢 向客户端 %s 写入超时，断开连接: %v", clientID, err)
+	conn.Close()
+	return err
+}
+
+// outFrame 发送队列中的一帧
+type outFrame struct {
+	prepared *websocket.PreparedMessage // 广播的预编码帧
+	data     []byte                     // 已序列化的文本消息
+	value    interface{}                // 待序列化的消息
+	size     int64
+}
+
+// sendQueue 单个连接的有界发送队列
+type sendQueue struct {
+	conn     wsConn
+	clientID string
+	config   SlowConsumerConfig
+	metrics  *SlowConsumerMetrics
+	timeout  time.Duration // 每帧的写超时
+	frames   chan outFrame
+	bytes    atomic.Int64 // 队列中消息的估算字节数
+	done     chan struct{}
+
+	mu         sync.Mutex
+	fullSince  time.Time // 队列开始满载的时间，零值表示未满
+	slow       bool
+	closed     bool
+	slowSince  time.Time // 判定为慢消费者的时间
+	suppressed int64     // summary策略下合并的消息数
+}
+
+func newSendQueue(conn wsConn, clientID string, config SlowConsumerConfig, metrics *SlowConsumerMetrics, timeout time.Duration) *sendQueue {
+	q := &sendQueue{
+		conn:     conn,
+		clientID: clientID,
+		config:   config,
+		metrics:  metrics,
+		timeout:  timeout,
+		frames:   make(chan outFrame, config.QueueSize),
+		done:     make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// run 发送协程，按顺序写出队列中的消息
+func (q *sendQueue) run() {
+	for {
+		select {
+		case <-q.done:
+			return
+		case frame := <-q.frames:
+			q.bytes.Add(-frame.size)
+			if err := checkWriteTimeout(q.conn, q.clientID, q.write(frame), q.metrics); err != nil {
+				log.Printf("向客户端 %s 发送消息失败: %v", q.clientID, err)
+				q.conn.Close()
+				return
+			}
+			q.afterWrite()
+		}
+	}
+}
+
+func (q *sendQueue) write(frame outFrame) error {
+	setWriteDeadline(q.conn, q.timeout)
+	switch {
+	case frame.prepared != nil:
+		if conn, ok := q.conn.(*websocket.Conn); ok {
+			return conn.WritePreparedMessage(frame.prepared)
+		}
+		return q.conn.WriteMessage(websocket.TextMessage, frame.data)
+	case frame.data != nil:
+		return q.conn.WriteMessage(websocket.TextMessage, frame.data)
+	default:
+		return q.conn.WriteJSON(frame.value)
+	}
+}
+
+// afterWrite 队列回落到一半以下时清除满载状态，慢消费者恢复时发送摘要（被合并的消息数）
+func (q *sendQueue) afterWrite() {
+	if len(q.frames) >= cap(q.frames)/2 {
+		return
+	}
+
+	q.mu.Lock()
+	q.fullSince = time.Time{}
+	if !q.slow {
+		q.mu.Unlock()
+		return
+	}
+	q.slow = false
+	suppressed, slowSince := q.suppressed, q.slowSince
+	q.suppressed = 0
+	q.mu.Unlock()
+
+	q.metrics.recovered.Add(1)
+	log.Printf("客户端 %s 发送队列已恢复", q.clientID)
+	if suppressed > 0 {
+		summary := map[string]interface{}{
+			"type":       "summary",
+			"suppressed": suppressed,
+			"since":      slowSince.Unix(),
+			"timestamp":  time.Now().Unix(),
+		}
+		setWriteDeadline(q.conn, q.timeout)
+		if err := checkWriteTimeout(q.conn, q.clientID, q.conn.WriteJSON(summary), q.metrics); err != nil {
+			log.Printf("向客户端 %s 发送摘要失败: %v", q.clientID, err)
+		}
+	}
+}
+
+// push 将消息放入队列。关键消息在队列满时最多等待一个检测阈值；
+// 非关键消息在队列满或客户端已判定为慢消费者时按策略丢弃或合并
+func (q *sendQueue) push(frame outFrame, critical bool) error {
+	if !critical && q.shed() {
+		return nil
+	}
+
+	q.bytes.Add(frame.size)
+	select {
+	case q.frames <- frame:
+		return nil
+	default:
+	}
+
+	q.markFull()
+	if !critical {
+		q.bytes.Add(-frame.size)
+		q.shedFull()
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(q.config.Threshold))
+	defer timer.Stop()
+	select {
+	case q.frames <- frame:
+		return nil
+	case <-q.done:
+	case <-timer.C:
+	}
+	q.bytes.Add(-frame.size)
+	q.metrics.rejected.Add(1)
+	q.markFull()
+	return errSendQueueFull
+}
+
+// shed 已判定为慢消费者时直接处理非关键消息，返回true表示消息已被处理
+func (q *sendQueue) shed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.slow {
+		return false
+	}
+	q.suppressLocked()
+	return true
+}
+
+// shedFull 队列满时处理无法入队的非关键消息
+func (q *sendQueue) shedFull() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.suppressLocked()
+}
+
+func (q *sendQueue) suppressLocked() {
+	if q.config.Policy == SlowPolicySummary {
+		q.suppressed++
+		q.metrics.summarized.Add(1)
+		return
+	}
+	q.metrics.dropped.Add(1)
+}
+
+// markFull 记录队列满载，持续超过阈值时判定为慢消费者并执行策略
+func (q *sendQueue) markFull() {
+	q.mu.Lock()
+	now := time.Now()
+	if q.fullSince.IsZero() {
+		q.fullSince = now
+	}
+	if q.slow || q.closed || now.Sub(q.fullSince) < time.Duration(q.config.Threshold) {
+		q.mu.Unlock()
+		return
+	}
+	q.slow = true
+	q.slowSince = now
+	fullFor := now.Sub(q.fullSince)
+	q.mu.Unlock()
+
+	q.metrics.detected.Add(1)
+	log.Printf("🐢 客户端 %s 读取过慢，发送队列已持续满载 %v，执行策略: %s", q.clientID, fullFor.Round(time.Millisecond), q.config.Policy)
+
+	if q.config.Policy == SlowPolicyDisconnect {
+		q.metrics.disconnected.Add(1)
+		reason := fmt.Sprintf("读取过慢，发送队列持续满载 %v", fullFor.Round(time.Second))
+		closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+		// 客户端不读取时TCP缓冲区已满，关闭帧只能尽力发送
+		q.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		q.conn.Close()
+	}
+}
+
+// checkFull 由监控协程定期调用，队列满载时记录并检查是否超过阈值
+func (q *sendQueue) checkFull() {
+	if len(q.frames) == cap(q.frames) {
+		q.markFull()
+	}
+}
+
+// isSlow 是否已判定为慢消费者
+func (q *sendQueue) isSlow() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.slow
+}
+
+// close 停止发送协程，未发出的消息被丢弃
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.done)
+}
+
+// slowConsumerStats 导出慢消费者统计和当前被判定为慢消费者的客户端
+func (s *Server) slowConsumerStats() map[string]interface{} {
+	stats := s.slowConsumerMetrics.Snapshot()
+	stats["enabled"] = s.slowConsumer.Enabled
+	stats["policy"] = s.slowConsumer.Policy
+	stats["write_timeout"] = s.writeTimeout.String()
+
+	slow := []string{}
+	s.clientsMu.RLock()
+	for id, client := range s.clients {
+		if client.writer.queue != nil && client.writer.queue.isSlow() {
+			slow = append(slow, id)
+		}
+	}
+	s.clientsMu.RUnlock()
+	stats["slow_clients"] = slow
+	return stats
+}
+
+// slowConsumerMonitor 定期检查所有连接的发送队列，没有新消息入队时也能发现持续满载的连接
+func (s *Server) slowConsumerMonitor() {
+	ticker := time.NewTicker(time.Duration(s.slowConsumer.Threshold) / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if s.draining.Load() {
+			return
+		}
+		s.clientsMu.RLock()
+		queues := make([]*sendQueue, 0, len(s.clients))
+		for _, client := range s.clients {
+			if client.writer.queue != nil {
+				queues = append(queues, client.writer.queue)
+			}
+		}
+		s.clientsMu.RUnlock()
+
+		for _, q := range queues {
+			q.checkFull()
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"sync"
+
+	"websocket-loadbalance/registry"
+	"websocket-loadbalance/tracing"
+)
+
+// maxCommandFanout 按名称或标签选择多个客户端时，同时发送的指令数
+const maxCommandFanout = 16
+
+// commandTargets 在全局注册表中查找名称匹配通配符 name、标签满足 selector 的客户端（两者都指定时需同时满足），
+// 只查找请求所在命名空间（未指定时为默认命名空间）的客户端。结果按客户端ID排序
+func commandTargets(req commandRequest) ([]*registry.ClientInfo, error) {
+	if req.Name != "" {
+		if _, err := path.Match(req.Name, ""); err != nil {
+			return nil, fmt.Errorf("无效的名称通配符 %q: %v", req.Name, err)
+		}
+	}
+	var selector registry.Selector
+	if req.Selector != "" {
+		var err error
+		if selector, err = registry.ParseSelector(req.Selector); err != nil {
+			return nil, err
+		}
+	}
+
+	var targets []*registry.ClientInfo
+	for _, client := range registry.All() {
+		if !registry.SameNamespace(client.NamespaceOf(), req.Namespace) {
+			continue
+		}
+		if req.Name != "" {
+			if matched, _ := path.Match(req.Name, client.Name); !matched {
+				continue
+			}
+		}
+		if selector != nil && !selector.Matches(client.Labels) {
+			continue
+		}
+		targets = append(targets, client)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].ID < targets[j].ID })
+	return targets, nil
+}
+
+// sendCommandToMatching 向所有匹配 name / selector 的客户端发送指令，客户端可以连接在任意节点上。
+// 每个客户端按 client_id 单独处理（能力检查、本地发送或转发、同步等待），results 按客户端ID返回各自的结果
+func (s *Server) sendCommandToMatching(w http.ResponseWriter, req commandRequest, span *tracing.Span) {
+	span.SetAttribute("command.name_pattern", req.Name)
+	span.SetAttribute("command.selector", req.Selector)
+
+	targets, err := commandTargets(req)
+	if err != nil {
+		span.SetError(err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"code":    "invalid_selector",
+			"error":   err.Error(),
+		})
+		return
+	}
+	span.SetAttribute("command.matched", len(targets))
+	if len(targets) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"code":    "no_matching_clients",
+			"error":   "没有匹配的客户端",
+			"matched": 0,
+			"results": map[string]interface{}{},
+		})
+		return
+	}
+
+	results := make(map[string]map[string]interface{}, len(targets))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, maxCommandFanout)
+	for _, target := range targets {
+		single := req
+		single.ClientID, single.Name, single.Selector = target.ID, "", ""
+		payload, _ := json.Marshal(single)
+
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(clientID string) {
+			defer func() { <-slots; wg.Done() }()
+			status, body := s.serveForwardedCommand(payload)
+			result := make(map[string]interface{})
+			if err := json.Unmarshal(body, &result); err != nil {
+				result = map[string]interface{}{"success": false, "error": string(body)}
+			}
+			result["status"] = status
+			mu.Lock()
+			results[clientID] = result
+			mu.Unlock()
+		}(target.ID)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, result := range results {
+		if result["success"] == true && result["status"] == http.StatusOK {
+			succeeded++
+		}
+	}
+	failed := len(targets) - succeeded
+	if failed > 0 {
+		span.SetError(fmt.Errorf("%d 个客户端的指令失败", failed))
+	}
+	log.Printf("按 name=%q selector=%q 向 %d 个客户端发送指令 %s: 成功 %d, 失败 %d",
+		req.Name, req.Selector, len(targets), req.Command, succeeded, failed)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   failed == 0,
+		"matched":   len(targets),
+		"succeeded": succeeded,
+		"failed":    failed,
+		"results":   results,
+	})
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"websocket-loadbalance/protocol"
+)
+
+// MessageMetrics 入站消息校验统计
+type MessageMetrics struct {
+	invalid       atomic.Int64 // 不符合消息模式被拒绝的消息数
+	tooLarge      atomic.Int64 // 超过大小上限被断开的连接数
+	received      atomic.Int64 // 收到的客户端消息数
+	receivedBytes atomic.Int64 // 收到的客户端消息字节数
+	sent          atomic.Int64 // 发往客户端的消息数（批量帧按其中的消息计）
+}
+
+// SetMaxMessageSize 设置客户端单条消息的最大字节数（需在Start之前调用），0表示不限制。
+// 超过上限的连接以关闭码1009断开
+func (s *Server) SetMaxMessageSize(size int64) {
+	if size < 0 {
+		size = 0
+	}
+	s.maxMessageSize = size
+}
+
+// applyReadLimit 为客户端连接设置消息大小上限
+func (s *Server) applyReadLimit(conn *websocket.Conn) {
+	if s.maxMessageSize > 0 {
+		conn.SetReadLimit(s.maxMessageSize)
+	}
+}
+
+// recordTooLarge 记录超过大小上限被断开的连接（1009关闭帧已发出）
+func (s *Server) recordTooLarge(clientID string) {
+	s.messageMetrics.tooLarge.Add(1)
+	log.Printf("客户端 %s 的消息超过 %d 字节上限，关闭连接", clientID, s.maxMessageSize)
+}
+
+// rejectMessage 回复消息校验失败：请求消息回复状态码400的响应（按ID匹配），
+// 其他消息回复 invalid_message 协议错误
+func (s *Server) rejectMessage(client *ClientInfo, msg map[string]interface{}, err error) error {
+	s.messageMetrics.invalid.Add(1)
+	log.Printf("客户端 %s 发送了无效消息: %v", client.ID, err)
+
+	id, _ := msg["id"].(string)
+	if _, isRequest := msg["method"]; isRequest {
+		response := protocol.NewResponse(id, 400, nil)
+		response.Error = err.Error()
+		return client.writer.WriteJSON(response)
+	}
+	reply := map[string]interface{}{
+		"type":      "error",
+		"code":      "invalid_message",
+		"status":    400,
+		"message":   fmt.Sprintf("无效消息: %v", err),
+		"timestamp": time.Now().Unix(),
+	}
+	if id != "" {
+		reply["id"] = id
+	}
+	var validationErr *protocol.ValidationError
+	if errors.As(err, &validationErr) && validationErr.Field != "" {
+		reply["field"] = validationErr.Field
+	}
+	return client.writer.WriteJSON(reply)
+}
+
+// messageStats 导出消息收发和校验统计
+func (s *Server) messageStats() map[string]interface{} {
+	return map[string]interface{}{
+		"max_message_size": s.maxMessageSize,
+		"invalid":          s.messageMetrics.invalid.Load(),
+		"too_large":        s.messageMetrics.tooLarge.Load(),
+		"received":         s.messageMetrics.received.Load(),
+		"received_bytes":   s.messageMetrics.receivedBytes.Load(),
+		"sent":             s.messageMetrics.sent.Load(),
+	}
+}
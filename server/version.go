@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"websocket-loadbalance/protocol"
+)
+
+// protocolVersionsOrDefault 未配置的一端使用默认值：最低 DefaultVersion，最高 CurrentVersion
+func protocolVersionsOrDefault(v protocol.VersionRange) protocol.VersionRange {
+	if v.Min == 0 {
+		v.Min = protocol.DefaultVersion
+	}
+	if v.Max == 0 {
+		v.Max = protocol.CurrentVersion
+	}
+	return v
+}
+
+// SetProtocolVersions 设置节点接受的客户端协议版本范围（需在Start之前调用），
+// 滚动升级协议时新版本节点先同时支持新旧版本，全部客户端升级后再提高最低版本
+func (s *Server) SetProtocolVersions(v protocol.VersionRange) {
+	s.protocolVersions = protocolVersionsOrDefault(v)
+}
+
+// SetBuildVersion 设置节点在 /health 中上报的构建版本（需在Start之前调用），默认为 protocol.BuildVersion
+func (s *Server) SetBuildVersion(version string) {
+	if version == "" {
+		version = protocol.BuildVersion
+	}
+	s.buildVersion = version
+}
+
+// SetLabels 设置节点标签（需在Start之前调用），如 {"region": "eu", "gpu": "true"}。标签在 /health 中上报，
+// 负载均衡器的 affinity 策略按客户端握手时声明的亲和性（节点标签的选择器）优先选择标签满足条件的节点
+func (s *Server) SetLabels(labels map[string]string) {
+	s.labels = labels
+}
+
+// negotiateVersion 检查客户端声明的协议版本，不在支持范围内时以426拒绝握手并返回协议层错误消息。
+// 接受时返回协商的版本和附加了 X-Protocol-Version 的升级响应头
+func (s *Server) negotiateVersion(w http.ResponseWriter, r *http.Request, header http.Header) (int, http.Header, bool) {
+	version, err := protocol.RequestedVersion(r)
+	if err != nil {
+		s.versionRejected.Add(1)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, nil, false
+	}
+	supported := s.protocolVersions
+	if !supported.Contains(version) {
+		s.versionRejected.Add(1)
+		log.Printf("节点 %s 拒绝协议版本 %d 的连接 (%s)，支持的版本: %s", s.nodeID, version, r.RemoteAddr, supported)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUpgradeRequired)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"type":        "error",
+			"code":        "unsupported_protocol_version",
+			"message":     fmt.Sprintf("节点 %s 不支持协议版本 %d（支持 %s）", s.nodeID, version, supported),
+			"node_id":     s.nodeID,
+			"version":     version,
+			"min_version": supported.Min,
+			"max_version": supported.Max,
+		})
+		return 0, nil, false
+	}
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set(protocol.VersionHeader, strconv.Itoa(version))
+	return version, header, true
+}
+
+// versionStats 导出支持的协议版本和在线客户端的版本分布
+func (s *Server) versionStats() map[string]interface{} {
+	clients := make(map[string]int)
+	s.clientsMu.RLock()
+	for _, client := range s.clients {
+		clients[strconv.Itoa(client.ProtocolVersion)]++
+	}
+	s.clientsMu.RUnlock()
+	return map[string]interface{}{
+		"min_version": s.protocolVersions.Min,
+		"max_version": s.protocolVersions.Max,
+		"clients":     clients,
+		"rejected":    s.versionRejected.Load(),
+	}
+}
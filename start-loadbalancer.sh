@@ -25,7 +25,7 @@ check_dependencies() {
 build_program() {
     echo "🔧 编译Go程序..."
     
-    if ! go build -o websocket-system .; then
+    if ! go build -o websocket-system ./cmd/websocket-system; then
         echo "❌ Go编译失败"
         exit 1
     fi
@@ -120,10 +120,12 @@ show_system_info() {
     echo "  🌐 负载均衡器入口: http://localhost:8080"
     echo "  🔌 客户端连接: ws://localhost:8080/ws"
     echo ""
-    echo "🖥️ 后端节点独立管理界面:"
-    echo "  • Node1: http://localhost:8081/web-node.html (PID: $NODE1_PID)"
-    echo "  • Node2: http://localhost:8082/web-node.html (PID: $NODE2_PID)"
-    echo "  • Node3: http://localhost:8083/web-node.html (PID: $NODE3_PID)"
+    echo "  🖥️ 管理界面: http://localhost:8080/admin/"
+    echo ""
+    echo "🖥️ 后端节点:"
+    echo "  • Node1: http://localhost:8081/api/node-info (PID: $NODE1_PID)"
+    echo "  • Node2: http://localhost:8082/api/node-info (PID: $NODE2_PID)"
+    echo "  • Node3: http://localhost:8083/api/node-info (PID: $NODE3_PID)"
     echo ""
     echo "📊 各节点API接口:"
     echo "  • Node1 客户端列表: http://localhost:8081/api/clients"
@@ -138,10 +140,7 @@ show_system_info() {
     echo "  1. 启动多个客户端: ./websocket-system -service=client -name=客户端A"
     echo "  2. 再启动客户端B: ./websocket-system -service=client -name=客户端B"
     echo "  3. 访问负载均衡器: http://localhost:8080 (会自动转发到后端节点)"
-    echo "  4. 打开各节点管理界面查看客户端分布:"
-    echo "     • http://localhost:8081/web-node.html"
-    echo "     • http://localhost:8082/web-node.html"
-    echo "     • http://localhost:8083/web-node.html"
+    echo "  4. 打开管理界面查看客户端分布: http://localhost:8080/admin/"
     echo "  5. 测试会话保持: 刷新页面应始终访问同一后端节点"
     echo "  6. 关闭某个服务端测试故障转移: kill $NODE1_PID"
     echo ""
@@ -151,7 +150,7 @@ show_system_info() {
     echo ""
     echo "💡 新架构特性验证:"
     echo "  ✅ 七层负载均衡器 (纯转发，类似nginx)"
-    echo "  ✅ 每个后端节点独立Web管理界面"
+    echo "  ✅ 负载均衡器内置Web管理界面"
     echo "  ✅ 会话保持机制 (同用户固定访问同一节点)"
     echo "  ✅ HTTP/WebSocket双重代理"
     echo "  ✅ 后端节点故障自动转移"
@@ -0,0 +1,292 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/secrets"
+)
+
+// 默认的OTLP/HTTP metrics接收地址
+const defaultMetricsEndpoint = "http://localhost:4318/v1/metrics"
+
+// MetricsConfig 通过OTLP/HTTP（JSON编码）定期推送指标，适合统一使用OpenTelemetry管道、不抓取 /api/metrics 的环境
+type MetricsConfig struct {
+	Enabled  bool              `json:"enabled" yaml:"enabled"`
+	Endpoint string            `json:"endpoint" yaml:"endpoint"` // OTLP/HTTP metrics接收地址，默认 http://localhost:4318/v1/metrics
+	Interval protocol.Duration `json:"interval" yaml:"interval"` // 推送间隔，默认15s
+}
+
+// Validate 校验指标推送配置
+func (c MetricsConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Endpoint != "" && !strings.HasPrefix(c.Endpoint, "http://") && !strings.HasPrefix(c.Endpoint, "https://") {
+		return fmt.Errorf("tracing.metrics.endpoint 必须是 http:// 或 https:// 地址: %s", c.Endpoint)
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("tracing.metrics.interval 不能为负数")
+	}
+	return nil
+}
+
+// MetricKind 指标类型
+type MetricKind int
+
+const (
+	Gauge   MetricKind = iota // 当前值，如连接数
+	Counter                   // 进程启动以来单调递增的累计值，如收到的消息数
+)
+
+// Metric 一个指标数据点，同名数据点按 Attributes 区分（如各节点的连接数）
+type Metric struct {
+	Name        string
+	Description string
+	Unit        string // UCUM单位，如 "1"、"By"
+	Kind        MetricKind
+	Value       float64
+	Attributes  map[string]string
+}
+
+// MetricSource 在每次推送时采集一组指标
+type MetricSource func() []Metric
+
+var (
+	metricSources   = make(map[uint64]MetricSource)
+	metricSourceSeq uint64
+	metricSourcesMu sync.Mutex
+)
+
+// RegisterMetrics 登记指标来源，返回注销函数。未启用指标推送时来源不会被调用
+func RegisterMetrics(source MetricSource) (unregister func()) {
+	metricSourcesMu.Lock()
+	defer metricSourcesMu.Unlock()
+	metricSourceSeq++
+	id := metricSourceSeq
+	metricSources[id] = source
+	return func() {
+		metricSourcesMu.Lock()
+		defer metricSourcesMu.Unlock()
+		delete(metricSources, id)
+	}
+}
+
+// collectMetrics 按登记顺序采集所有来源的指标
+func collectMetrics() []Metric {
+	metricSourcesMu.Lock()
+	ids := make([]uint64, 0, len(metricSources))
+	for id := range metricSources {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	sources := make([]MetricSource, len(ids))
+	for i, id := range ids {
+		sources[i] = metricSources[id]
+	}
+	metricSourcesMu.Unlock()
+
+	var metrics []Metric
+	for _, source := range sources {
+		metrics = append(metrics, source()...)
+	}
+	return metrics
+}
+
+// metricsExporter 定期采集并推送指标
+type metricsExporter struct {
+	endpoint string
+	service  string
+	headers  map[string]*secrets.Secret
+	client   *http.Client
+	interval time.Duration
+	start    time.Time // 累计值的起始时间
+	done     chan struct{}
+
+	pushes  atomic.Int64 // 成功推送的次数
+	points  atomic.Int64 // 成功推送的数据点数
+	failed  atomic.Int64 // 推送失败的次数
+	failing atomic.Bool
+}
+
+var activeMetrics atomic.Pointer[metricsExporter]
+
+// initMetrics 按配置启动指标推送，替换之前的推送
+func initMetrics(cfg Config) error {
+	var exp *metricsExporter
+	if cfg.Metrics.Enabled {
+		headers, err := resolveHeaders(cfg.Headers)
+		if err != nil {
+			return err
+		}
+		exp = &metricsExporter{
+			endpoint: cfg.Metrics.Endpoint,
+			service:  cfg.ServiceName,
+			headers:  headers,
+			client:   &http.Client{Timeout: 10 * time.Second},
+			interval: time.Duration(cfg.Metrics.Interval),
+			start:    time.Now(),
+			done:     make(chan struct{}),
+		}
+		if exp.endpoint == "" {
+			exp.endpoint = defaultMetricsEndpoint
+		}
+		if exp.interval <= 0 {
+			exp.interval = 15 * time.Second
+		}
+	}
+	if previous := activeMetrics.Swap(exp); previous != nil {
+		close(previous.done)
+	}
+	if exp != nil {
+		go exp.run()
+	}
+	return nil
+}
+
+func (m *metricsExporter) run() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.push()
+		}
+	}
+}
+
+// push 采集并推送一次，没有数据点时不发送
+func (m *metricsExporter) push() {
+	metrics := collectMetrics()
+	if len(metrics) == 0 {
+		return
+	}
+	body, err := json.Marshal(m.encode(metrics, time.Now()))
+	if err != nil {
+		log.Printf("tracing: 编码指标失败: %v", err)
+		return
+	}
+	if err := postOTLP(m.client, m.endpoint, m.headers, body); err != nil {
+		m.failed.Add(1)
+		if !m.failing.Swap(true) {
+			log.Printf("tracing: 推送指标到 %s 失败: %v（恢复前不再重复记录）", m.endpoint, err)
+		}
+		return
+	}
+	m.pushes.Add(1)
+	m.points.Add(int64(len(metrics)))
+	if m.failing.Swap(false) {
+		log.Printf("tracing: 推送指标到 %s 已恢复", m.endpoint)
+	}
+}
+
+// metricsStats 指标推送的统计，未启用时返回nil
+func metricsStats() map[string]interface{} {
+	m := activeMetrics.Load()
+	if m == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"endpoint": m.endpoint,
+		"interval": m.interval.String(),
+		"pushes":   m.pushes.Load(),
+		"points":   m.points.Load(),
+		"failed":   m.failed.Load(),
+	}
+}
+
+// OTLP metrics 的JSON编码，计数器编码为累计（aggregationTemporality=2）的单调sum
+
+type otlpMetricsRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpMetric struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Unit        string     `json:"unit,omitempty"`
+	Gauge       *otlpGauge `json:"gauge,omitempty"`
+	Sum         *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	AsDouble          float64        `json:"asDouble"`
+}
+
+// encode 把同名数据点合并到同一个指标中，指标按首次出现的顺序排列
+func (m *metricsExporter) encode(metrics []Metric, now time.Time) otlpMetricsRequest {
+	byName := make(map[string]*otlpMetric)
+	var ordered []*otlpMetric
+	for _, metric := range metrics {
+		point := otlpDataPoint{TimeUnixNano: strconv.FormatInt(now.UnixNano(), 10), AsDouble: metric.Value}
+		keys := make([]string, 0, len(metric.Attributes))
+		for key := range metric.Attributes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			point.Attributes = append(point.Attributes, otlpKeyValue{Key: key, Value: otlpValue(metric.Attributes[key])})
+		}
+
+		encoded, exists := byName[metric.Name]
+		if !exists {
+			encoded = &otlpMetric{Name: metric.Name, Description: metric.Description, Unit: metric.Unit}
+			if metric.Kind == Counter {
+				encoded.Sum = &otlpSum{AggregationTemporality: 2, IsMonotonic: true}
+			} else {
+				encoded.Gauge = &otlpGauge{}
+			}
+			byName[metric.Name] = encoded
+			ordered = append(ordered, encoded)
+		}
+		if encoded.Sum != nil {
+			point.StartTimeUnixNano = strconv.FormatInt(m.start.UnixNano(), 10)
+			encoded.Sum.DataPoints = append(encoded.Sum.DataPoints, point)
+		} else {
+			encoded.Gauge.DataPoints = append(encoded.Gauge.DataPoints, point)
+		}
+	}
+
+	result := make([]otlpMetric, len(ordered))
+	for i, metric := range ordered {
+		result[i] = *metric
+	}
+	return otlpMetricsRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource:     otlpResourceFor(m.service),
+		ScopeMetrics: []otlpScopeMetrics{{Scope: otlpScope{Name: "websocket-loadbalance"}, Metrics: result}},
+	}}}
+}
@@ -0,0 +1,311 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/secrets"
+)
+
+// exporter 按批次将span以OTLP/HTTP JSON格式发送到接收端
+type exporter struct {
+	endpoint string
+	service  string
+	headers  map[string]*secrets.Secret
+	client   *http.Client
+	queue    chan *Span
+	flush    chan chan struct{}
+	interval time.Duration
+	maxBatch int
+
+	exported atomic.Int64 // 成功导出的span数
+	dropped  atomic.Int64 // 队列满时丢弃的span数
+	failed   atomic.Int64 // 导出失败的span数
+	failing  atomic.Bool  // 上一次导出失败，恢复前只记录一次日志
+}
+
+func newExporter(cfg Config) (*exporter, error) {
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = defaultEndpoint
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = protocol.Duration(5 * time.Second)
+	}
+	if cfg.MaxBatch <= 0 {
+		cfg.MaxBatch = 512
+	}
+	if cfg.MaxQueue <= 0 {
+		cfg.MaxQueue = 4096
+	}
+	headers, err := resolveHeaders(cfg.Headers)
+	if err != nil {
+		return nil, err
+	}
+	return &exporter{
+		endpoint: cfg.Endpoint,
+		service:  cfg.ServiceName,
+		headers:  headers,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan *Span, cfg.MaxQueue),
+		flush:    make(chan chan struct{}),
+		interval: time.Duration(cfg.FlushInterval),
+		maxBatch: cfg.MaxBatch,
+	}, nil
+}
+
+// enqueue 提交待导出的span，队列满时丢弃
+func (e *exporter) enqueue(span *Span) {
+	select {
+	case e.queue <- span:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// run 定期或攒够一批时导出
+func (e *exporter) run() {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, e.maxBatch)
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= e.maxBatch {
+				e.export(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				e.export(batch)
+				batch = batch[:0]
+			}
+		case done := <-e.flush:
+			for drained := false; !drained; {
+				select {
+				case span := <-e.queue:
+					batch = append(batch, span)
+				default:
+					drained = true
+				}
+			}
+			for len(batch) > 0 {
+				n := min(len(batch), e.maxBatch)
+				e.export(batch[:n])
+				batch = batch[n:]
+			}
+			batch = make([]*Span, 0, e.maxBatch)
+			close(done)
+		}
+	}
+}
+
+// Flush 导出所有等待中的span，进程退出前调用
+func Flush(ctx context.Context) {
+	t := active.Load()
+	if t == nil {
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case t.exporter.flush <- done:
+	case <-ctx.Done():
+		return
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// Stats 导出统计，追踪和指标推送都未启用时返回nil；启用指标推送时包含 metrics 子项
+func Stats() map[string]interface{} {
+	metrics := metricsStats()
+	t := active.Load()
+	if t == nil {
+		if metrics == nil {
+			return nil
+		}
+		return map[string]interface{}{"metrics": metrics}
+	}
+	e := t.exporter
+	stats := map[string]interface{}{
+		"endpoint": e.endpoint,
+		"service":  e.service,
+		"queued":   len(e.queue),
+		"exported": e.exported.Load(),
+		"dropped":  e.dropped.Load(),
+		"failed":   e.failed.Load(),
+	}
+	if metrics != nil {
+		stats["metrics"] = metrics
+	}
+	return stats
+}
+
+func (e *exporter) export(batch []*Span) {
+	body, err := json.Marshal(e.encode(batch))
+	if err != nil {
+		log.Printf("tracing: 编码span失败: %v", err)
+		return
+	}
+	if err := postOTLP(e.client, e.endpoint, e.headers, body); err != nil {
+		e.failed.Add(int64(len(batch)))
+		if !e.failing.Swap(true) {
+			log.Printf("tracing: 导出 %d 个span到 %s 失败: %v（恢复前不再重复记录）", len(batch), e.endpoint, err)
+		}
+		return
+	}
+	e.exported.Add(int64(len(batch)))
+	if e.failing.Swap(false) {
+		log.Printf("tracing: 导出到 %s 已恢复", e.endpoint)
+	}
+}
+
+// postOTLP 以OTLP/HTTP JSON格式发送一次导出请求，非2xx响应视为失败
+func postOTLP(client *http.Client, endpoint string, headers map[string]*secrets.Secret, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, secret := range headers {
+		req.Header.Set(name, secret.Value())
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// resolveHeaders 解析导出请求头中的密钥引用
+func resolveHeaders(values map[string]string) (map[string]*secrets.Secret, error) {
+	headers := make(map[string]*secrets.Secret, len(values))
+	for name, value := range values {
+		secret, err := secrets.Resolve(value)
+		if err != nil {
+			return nil, fmt.Errorf("tracing: 读取 headers.%s 失败: %v", name, err)
+		}
+		headers[name] = secret
+	}
+	return headers, nil
+}
+
+// otlpResourceFor 标识导出方的resource属性
+func otlpResourceFor(service string) otlpResource {
+	host, _ := os.Hostname()
+	return otlpResource{Attributes: []otlpKeyValue{
+		{Key: "service.name", Value: otlpValue(service)},
+		{Key: "host.name", Value: otlpValue(host)},
+		{Key: "telemetry.sdk.language", Value: otlpValue("go")},
+	}}
+}
+
+// OTLP JSON编码，字段名与 opentelemetry-proto 的JSON映射一致：
+// traceId/spanId 为十六进制字符串，64位整数编码为字符串
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 2 表示 STATUS_CODE_ERROR
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func otlpValue(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": v}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	default:
+		return map[string]interface{}{"stringValue": fmt.Sprint(v)}
+	}
+}
+
+func (e *exporter) encode(batch []*Span) otlpRequest {
+	resource := otlpResourceFor(e.service)
+
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		s.mu.Lock()
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.ctx.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.ctx.SpanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		for _, attr := range s.attrs {
+			span.Attributes = append(span.Attributes, otlpKeyValue{Key: attr.key, Value: otlpValue(attr.value)})
+		}
+		if s.errorMsg != "" {
+			span.Status = &otlpStatus{Code: 2, Message: s.errorMsg}
+		}
+		s.mu.Unlock()
+		spans = append(spans, span)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   resource,
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "websocket-loadbalance"}, Spans: spans}},
+	}}}
+}
@@ -0,0 +1,297 @@
+// Package tracing 实现与OpenTelemetry兼容的分布式追踪：按W3C Trace Context（traceparent）
+// 在HTTP请求头、节点总线和WebSocket消息中传播追踪上下文，并通过OTLP/HTTP（JSON编码）
+// 将span批量导出到Jaeger、OpenTelemetry Collector等后端。未调用Init或未启用时不产生span。
+// 启用 metrics 后还会定期把各组件登记的指标推送到同一类接收端，见 RegisterMetrics
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"websocket-loadbalance/protocol"
+	"websocket-loadbalance/secrets"
+)
+
+// Header 传播追踪上下文的HTTP请求头和消息字段
+const Header = "traceparent"
+
+// 默认的OTLP/HTTP traces接收地址（Jaeger和OpenTelemetry Collector的默认端口）
+const defaultEndpoint = "http://localhost:4318/v1/traces"
+
+// Config 分布式追踪配置
+type Config struct {
+	Enabled       bool              `json:"enabled" yaml:"enabled"`
+	Endpoint      string            `json:"endpoint" yaml:"endpoint"`             // OTLP/HTTP traces接收地址，默认 http://localhost:4318/v1/traces
+	ServiceName   string            `json:"service_name" yaml:"service_name"`     // 为空时按服务类型命名，如 websocket-loadbalancer
+	SampleRatio   *float64          `json:"sample_ratio" yaml:"sample_ratio"`     // 没有上游追踪上下文时的采样比例(0~1)，默认1；有上游时沿用上游的采样决定
+	Headers       map[string]string `json:"headers" yaml:"headers"`               // 导出请求附加的请求头（如认证），值可以是 env:/file:/vault: 引用
+	FlushInterval protocol.Duration `json:"flush_interval" yaml:"flush_interval"` // 批量导出的间隔，默认5s
+	MaxBatch      int               `json:"max_batch" yaml:"max_batch"`           // 每次导出的最大span数，默认512
+	MaxQueue      int               `json:"max_queue" yaml:"max_queue"`           // 等待导出的最大span数，默认4096，队列满时丢弃新的span
+	Metrics       MetricsConfig     `json:"metrics" yaml:"metrics"`               // 通过OTLP推送指标，与追踪分别启用，共用 service_name 和 headers
+}
+
+// Validate 校验追踪配置
+func (c Config) Validate() error {
+	if err := c.Metrics.Validate(); err != nil {
+		return err
+	}
+	if !c.Enabled && !c.Metrics.Enabled {
+		return nil
+	}
+	for name, value := range c.Headers {
+		if err := secrets.ValidateRef(value); err != nil {
+			return fmt.Errorf("tracing.headers.%s: %v", name, err)
+		}
+	}
+	if !c.Enabled {
+		return nil
+	}
+	if c.Endpoint != "" && !strings.HasPrefix(c.Endpoint, "http://") && !strings.HasPrefix(c.Endpoint, "https://") {
+		return fmt.Errorf("tracing.endpoint 必须是 http:// 或 https:// 地址: %s", c.Endpoint)
+	}
+	if c.SampleRatio != nil && (*c.SampleRatio < 0 || *c.SampleRatio > 1) {
+		return fmt.Errorf("tracing.sample_ratio 必须在0到1之间")
+	}
+	if c.FlushInterval < 0 || c.MaxBatch < 0 || c.MaxQueue < 0 {
+		return fmt.Errorf("tracing 的参数不能为负数")
+	}
+	return nil
+}
+
+// SpanKind span的类型，取值与OTLP一致
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+	KindProducer SpanKind = 4
+	KindConsumer SpanKind = 5
+)
+
+// SpanContext 跨进程传播的追踪上下文
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid traceID和spanID均非零
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent 编码为W3C traceparent，无效的上下文返回空字符串
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceparent 解析W3C traceparent，格式错误时返回false
+func ParseTraceparent(value string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	// 版本00必须恰好4段，更高版本允许追加字段
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&0x01 == 1
+	return sc, sc.IsValid()
+}
+
+// Extract 读取HTTP请求头中的追踪上下文
+func Extract(header http.Header) SpanContext {
+	sc, _ := ParseTraceparent(header.Get(Header))
+	return sc
+}
+
+// Inject 将追踪上下文写入HTTP请求头，无效的上下文不写入
+func Inject(header http.Header, sc SpanContext) {
+	if value := sc.Traceparent(); value != "" {
+		header.Set(Header, value)
+	}
+}
+
+// attribute span的属性，value为string、int64、float64或bool
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+// Span 一次操作的耗时记录，方法对nil安全，未启用追踪时Start返回nil
+type Span struct {
+	name   string
+	kind   SpanKind
+	ctx    SpanContext
+	parent [8]byte
+	start  time.Time
+
+	mu       sync.Mutex
+	end      time.Time
+	attrs    []attribute
+	errorMsg string
+	ended    bool
+}
+
+// tracer 全局追踪状态
+type tracer struct {
+	sampleRatio float64
+	exporter    *exporter
+}
+
+var active atomic.Pointer[tracer]
+
+// Init 按配置启用追踪和指标推送，defaultService 为未配置 service_name 时使用的服务名。
+// 重复调用时按新配置替换之前的指标推送
+func Init(cfg Config, defaultService string) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = defaultService
+	}
+	if err := initMetrics(cfg); err != nil {
+		return err
+	}
+	if !cfg.Enabled {
+		return nil
+	}
+	exp, err := newExporter(cfg)
+	if err != nil {
+		return err
+	}
+	ratio := 1.0
+	if cfg.SampleRatio != nil {
+		ratio = *cfg.SampleRatio
+	}
+	active.Store(&tracer{sampleRatio: ratio, exporter: exp})
+	go exp.run()
+	return nil
+}
+
+// Enabled 是否启用了追踪
+func Enabled() bool {
+	return active.Load() != nil
+}
+
+// Start 开始一个span。parent有效时作为其子span并沿用其采样决定，否则开始新的trace
+func Start(name string, kind SpanKind, parent SpanContext) *Span {
+	t := active.Load()
+	if t == nil {
+		return nil
+	}
+	span := &Span{name: name, kind: kind, start: time.Now()}
+	if parent.IsValid() {
+		span.ctx.TraceID = parent.TraceID
+		span.ctx.Sampled = parent.Sampled
+		span.parent = parent.SpanID
+	} else {
+		rand.Read(span.ctx.TraceID[:])
+		span.ctx.Sampled = sampled(span.ctx.TraceID, t.sampleRatio)
+	}
+	rand.Read(span.ctx.SpanID[:])
+	return span
+}
+
+// sampled 按traceID确定性地采样，同一trace在各进程得到相同的结果
+func sampled(traceID [16]byte, ratio float64) bool {
+	if ratio >= 1 {
+		return true
+	}
+	if ratio <= 0 {
+		return false
+	}
+	var v uint64
+	for _, b := range traceID[8:] {
+		v = v<<8 | uint64(b)
+	}
+	return float64(v>>11) < ratio*float64(uint64(1)<<53)
+}
+
+// Context 向下游传播的追踪上下文
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.ctx
+}
+
+// Traceparent 向下游传播的traceparent，未启用追踪时为空
+func (s *Span) Traceparent() string {
+	return s.Context().Traceparent()
+}
+
+// SetAttribute 设置属性，value支持string、整数、浮点数和bool，其他类型按字符串记录
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	switch v := value.(type) {
+	case string, int64, float64, bool:
+	case int:
+		value = int64(v)
+	case int32:
+		value = int64(v)
+	case float32:
+		value = float64(v)
+	default:
+		value = fmt.Sprint(v)
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attribute{key: key, value: value})
+	s.mu.Unlock()
+}
+
+// SetError 将span标记为失败
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.errorMsg = err.Error()
+	s.mu.Unlock()
+}
+
+// End 结束span并提交导出，重复调用无效
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+	if t := active.Load(); t != nil && s.ctx.Sampled {
+		t.exporter.enqueue(s)
+	}
+}